@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/family"
+	"ollama-auto-ctx/internal/ollama"
+)
+
+// benchText is repeated to fill prompts when no -text sample is given.
+const benchText = "The proxy estimates how many tokens a prompt will take before it is sent, " +
+	"so it can pick a context window that fits the conversation and the answer. " +
+	"Estimates that run short truncate the prompt; estimates that run long waste memory " +
+	"and slow down loading. Measuring real prompts against the model's own tokenizer " +
+	"shows how far off the defaults are. "
+
+// benchReport is the JSON report of the bench command.
+type benchReport struct {
+	Upstream string             `json:"upstream"`
+	Models   []benchModelReport `json:"models"`
+	// Calibration holds the recommended parameters per model, in the
+	// format POST /autoctx/api/v1/calibration/import accepts.
+	Calibration map[string]calibration.Params `json:"calibration"`
+}
+
+// benchModelReport compares one model's measured prompt tokens with the
+// proxy's estimates under the configured defaults and under the fitted
+// recommendation.
+type benchModelReport struct {
+	Model       string             `json:"model"`
+	Family      string             `json:"family,omitempty"`
+	Samples     []benchSample      `json:"samples"`
+	Current     benchAccuracy      `json:"current"`
+	Recommended calibration.Params `json:"recommended"`
+	// RecommendedAccuracy is the error the recommended parameters would
+	// have had on the same samples.
+	RecommendedAccuracy benchAccuracy `json:"recommended_accuracy"`
+	// Env lists the settings that apply the recommendation before any
+	// calibration has been learned.
+	Env []string `json:"env"`
+}
+
+// benchSample is one measured prompt.
+type benchSample struct {
+	TextBytes int     `json:"text_bytes"`
+	Messages  int     `json:"messages"`
+	Estimated int     `json:"estimated"`
+	Actual    int     `json:"actual"`
+	ErrorPct  float64 `json:"error_pct"` // (estimated - actual) / actual; negative under-sizes
+}
+
+// benchAccuracy summarizes estimate errors. A negative BiasPct means the
+// estimates run short, which risks truncated prompts.
+type benchAccuracy struct {
+	MeanAbsErrorPct float64 `json:"mean_abs_error_pct"`
+	MaxAbsErrorPct  float64 `json:"max_abs_error_pct"`
+	BiasPct         float64 `json:"bias_pct"`
+}
+
+// runBench implements "ollama-auto-ctx bench": it sends a matrix of prompt
+// sizes straight to the upstream (no proxy is started), compares Ollama's
+// prompt_eval_count with the proxy's estimate and recommends calibration
+// parameters. The JSON report goes to stdout and a summary to stderr. It
+// returns the process exit code.
+func runBench(cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	models := fs.String("models", "", "comma-separated models to measure (required)")
+	sizes := fs.String("sizes", "256,1024,4096,16384", "comma-separated prompt sizes in bytes")
+	messages := fs.String("messages", "1,4", "comma-separated message counts per prompt")
+	textFile := fs.String("text", "", "file whose text fills the prompts (default: built-in English prose)")
+	timeout := fs.Duration("timeout", 5*time.Minute, "timeout per request, including model load")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: ollama-auto-ctx bench -models llama3[,qwen3] [flags]")
+		fmt.Fprintln(stderr, "Measures prompt tokens against UPSTREAM_URL and recommends calibration parameters.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	modelList := splitBenchList(*models)
+	sizeList, err := parseBenchInts(*sizes)
+	if err != nil {
+		fmt.Fprintln(stderr, "bench: -sizes:", err)
+		return 2
+	}
+	messageList, err := parseBenchInts(*messages)
+	if err != nil {
+		fmt.Fprintln(stderr, "bench: -messages:", err)
+		return 2
+	}
+	if len(modelList) == 0 {
+		fs.Usage()
+		return 2
+	}
+	text := benchText
+	if *textFile != "" {
+		b, err := os.ReadFile(*textFile)
+		if err != nil {
+			fmt.Fprintln(stderr, "bench:", err)
+			return 2
+		}
+		text = strings.ToValidUTF8(string(b), "")
+		if strings.TrimSpace(text) == "" {
+			fmt.Fprintln(stderr, "bench: -text file is empty")
+			return 2
+		}
+	}
+
+	client, err := ollama.NewClient(cfg.UpstreamURL)
+	if err != nil {
+		fmt.Fprintln(stderr, "bench:", err)
+		return 2
+	}
+	client.HTTP.Timeout = *timeout
+
+	families := family.NewClassifier(cfg.ModelFamilyRules)
+	report := benchReport{Upstream: cfg.UpstreamURL, Calibration: make(map[string]calibration.Params)}
+	for _, model := range modelList {
+		fam := families.Classify(model)
+		params := calibration.Params{
+			TokensPerByte:      cfg.DefaultTokensPerByte,
+			FixedOverhead:      cfg.DefaultFixedOverheadTokens,
+			PerMessageOverhead: cfg.DefaultPerMessageOverhead,
+		}
+		if v, ok := cfg.FamilyTokensPerByte[string(fam)]; ok {
+			params.TokensPerByte = v
+		}
+		weights := estimate.RoleWeights(cfg.RoleWeightsFor(fam))
+
+		mr := benchModelReport{Model: model, Family: string(fam)}
+		for _, msgs := range messageList {
+			for _, size := range sizeList {
+				chat := benchMessages(text, size, msgs)
+				features, err := benchFeatures(model, chat)
+				if err != nil {
+					fmt.Fprintln(stderr, "bench:", err)
+					return 1
+				}
+				est := estimate.EstimatePromptTokens(features, params, 0, 0, weights)
+				// Tokenizers produce at most about one token per byte, so
+				// this leaves room for the whole prompt and the template.
+				numCtx := min(size+msgs*64+512, cfg.MaxCtx)
+
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				actual, err := client.PromptEvalCount(ctx, model, chat, numCtx)
+				cancel()
+				if err != nil {
+					fmt.Fprintf(stderr, "bench: %s (%d bytes, %d messages): %v\n", model, size, msgs, err)
+					return 1
+				}
+				mr.Samples = append(mr.Samples, benchSample{
+					TextBytes: features.TextBytes,
+					Messages:  features.MessageCount,
+					Estimated: est,
+					Actual:    actual,
+					ErrorPct:  errorPct(float64(est), actual),
+				})
+			}
+		}
+
+		rec := fitBenchParams(mr.Samples, params)
+		mr.Current = benchAccuracyOf(mr.Samples, func(s benchSample) float64 { return float64(s.Estimated) })
+		mr.Recommended = rec
+		mr.RecommendedAccuracy = benchAccuracyOf(mr.Samples, func(s benchSample) float64 {
+			return rec.FixedOverhead + rec.PerMessageOverhead*float64(s.Messages) + rec.TokensPerByte*float64(s.TextBytes)
+		})
+		mr.Env = benchEnv(fam, rec, len(modelList) == 1)
+		report.Models = append(report.Models, mr)
+		report.Calibration[model] = rec
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintln(stderr, "bench:", err)
+		return 1
+	}
+	writeBenchSummary(stderr, report)
+	return 0
+}
+
+// benchMessages builds msgs alternating user/assistant messages, ending with
+// a user message, that together hold size bytes of text.
+func benchMessages(text string, size, msgs int) []ollama.ChatMessage {
+	out := make([]ollama.ChatMessage, msgs)
+	for i := range out {
+		n := size / msgs
+		if i == msgs-1 {
+			n = size - n*(msgs-1)
+		}
+		role := "user"
+		if (msgs-1-i)%2 == 1 {
+			role = "assistant"
+		}
+		out[i] = ollama.ChatMessage{Role: role, Content: fillText(text, n)}
+	}
+	return out
+}
+
+// fillText repeats text to n bytes, cutting at a rune boundary.
+func fillText(text string, n int) string {
+	s := strings.Repeat(text, n/len(text)+1)[:n]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// benchFeatures extracts the features the proxy would see for chat.
+func benchFeatures(model string, chat []ollama.ChatMessage) (estimate.Features, error) {
+	msgs := make([]any, len(chat))
+	for i, m := range chat {
+		msgs[i] = map[string]any{"role": m.Role, "content": m.Content}
+	}
+	return estimate.ExtractFeatures(estimate.EndpointChat, map[string]any{"model": model, "messages": msgs})
+}
+
+// fitBenchParams fits tokens ~= FixedOverhead + PerMessageOverhead*messages +
+// TokensPerByte*bytes to the samples by least squares, within the ranges
+// calibration learns in. Terms the samples don't vary keep defaults' value.
+func fitBenchParams(samples []benchSample, defaults calibration.Params) calibration.Params {
+	rec := calibration.Params{
+		TokensPerByte:      defaults.TokensPerByte,
+		FixedOverhead:      defaults.FixedOverhead,
+		PerMessageOverhead: defaults.PerMessageOverhead,
+		UpdatedAt:          time.Now().UTC(),
+		Samples:            len(samples),
+	}
+	bytesVary, messagesVary := false, false
+	for _, s := range samples {
+		bytesVary = bytesVary || s.TextBytes != samples[0].TextBytes
+		messagesVary = messagesVary || s.Messages != samples[0].Messages
+	}
+
+	// Design matrix columns: bytes, then messages if they vary, then the
+	// intercept if enough sizes vary to separate it from the rate.
+	rows := make([][]float64, len(samples))
+	y := make([]float64, len(samples))
+	for i, s := range samples {
+		row := []float64{float64(s.TextBytes)}
+		y[i] = float64(s.Actual)
+		if messagesVary {
+			row = append(row, float64(s.Messages))
+		} else {
+			y[i] -= defaults.PerMessageOverhead * float64(s.Messages)
+		}
+		if bytesVary {
+			row = append(row, 1)
+		} else {
+			y[i] -= defaults.FixedOverhead
+		}
+		rows[i] = row
+	}
+	coef, ok := leastSquares(rows, y)
+	if !ok {
+		return rec
+	}
+	rec.TokensPerByte = clampBench(coef[0], 0.05, 1.0)
+	i := 1
+	if messagesVary {
+		rec.PerMessageOverhead = clampBench(coef[i], 0, 64)
+		i++
+	}
+	if bytesVary {
+		rec.FixedOverhead = clampBench(coef[i], 0, 256)
+	}
+	return rec
+}
+
+// leastSquares solves the normal equations for coefficients minimizing
+// |rows*coef - y|. It reports false if the system is singular.
+func leastSquares(rows [][]float64, y []float64) ([]float64, bool) {
+	if len(rows) == 0 {
+		return nil, false
+	}
+	n := len(rows[0])
+	// Augmented matrix [A^T A | A^T y]
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n+1)
+		for k, row := range rows {
+			for j := range n {
+				m[i][j] += row[i] * row[j]
+			}
+			m[i][n] += row[i] * y[k]
+		}
+	}
+	// Gaussian elimination with partial pivoting
+	for col := range n {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-9 {
+			return nil, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := range n {
+			if r == col {
+				continue
+			}
+			f := m[r][col] / m[col][col]
+			for c := col; c <= n; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+		}
+	}
+	coef := make([]float64, n)
+	for i := range coef {
+		coef[i] = m[i][n] / m[i][i]
+	}
+	return coef, true
+}
+
+// benchAccuracyOf summarizes the errors of predict against the samples.
+func benchAccuracyOf(samples []benchSample, predict func(benchSample) float64) benchAccuracy {
+	var a benchAccuracy
+	if len(samples) == 0 {
+		return a
+	}
+	for _, s := range samples {
+		e := errorPct(predict(s), s.Actual)
+		a.MeanAbsErrorPct += math.Abs(e)
+		a.MaxAbsErrorPct = max(a.MaxAbsErrorPct, math.Abs(e))
+		a.BiasPct += e
+	}
+	a.MeanAbsErrorPct = round1(a.MeanAbsErrorPct / float64(len(samples)))
+	a.MaxAbsErrorPct = round1(a.MaxAbsErrorPct)
+	a.BiasPct = round1(a.BiasPct / float64(len(samples)))
+	return a
+}
+
+// benchEnv returns the settings that apply rec before calibration has
+// samples: the family's tokens per byte, or the global defaults when the
+// model has no family and is the only one measured.
+func benchEnv(fam family.Family, rec calibration.Params, only bool) []string {
+	tpb := strconv.FormatFloat(round3(rec.TokensPerByte), 'f', -1, 64)
+	if fam != family.Unknown {
+		return []string{"FAMILY_TOKENS_PER_BYTE=" + string(fam) + "=" + tpb}
+	}
+	if !only {
+		return nil
+	}
+	return []string{
+		"DEFAULT_TOKENS_PER_BYTE=" + tpb,
+		"DEFAULT_FIXED_OVERHEAD_TOKENS=" + strconv.FormatFloat(math.Round(rec.FixedOverhead), 'f', -1, 64),
+		"DEFAULT_PER_MESSAGE_OVERHEAD_TOKENS=" + strconv.FormatFloat(math.Round(rec.PerMessageOverhead), 'f', -1, 64),
+	}
+}
+
+func writeBenchSummary(w io.Writer, report benchReport) {
+	for _, m := range report.Models {
+		fmt.Fprintf(w, "\n%s", m.Model)
+		if m.Family != "" {
+			fmt.Fprintf(w, " (family %s)", m.Family)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "  %10s %8s %10s %8s %8s\n", "bytes", "messages", "estimated", "actual", "error")
+		for _, s := range m.Samples {
+			fmt.Fprintf(w, "  %10d %8d %10d %8d %+7.1f%%\n", s.TextBytes, s.Messages, s.Estimated, s.Actual, s.ErrorPct)
+		}
+		fmt.Fprintf(w, "  current:     mean |error| %.1f%%, max %.1f%%, bias %+.1f%%\n",
+			m.Current.MeanAbsErrorPct, m.Current.MaxAbsErrorPct, m.Current.BiasPct)
+		fmt.Fprintf(w, "  recommended: tokens_per_byte %.3f, fixed_overhead %.0f, per_message_overhead %.0f\n",
+			m.Recommended.TokensPerByte, m.Recommended.FixedOverhead, m.Recommended.PerMessageOverhead)
+		fmt.Fprintf(w, "               mean |error| %.1f%%, max %.1f%%, bias %+.1f%%\n",
+			m.RecommendedAccuracy.MeanAbsErrorPct, m.RecommendedAccuracy.MaxAbsErrorPct, m.RecommendedAccuracy.BiasPct)
+		for _, e := range m.Env {
+			fmt.Fprintf(w, "  set %s\n", e)
+		}
+	}
+	fmt.Fprintln(w, "\nThe report's \"calibration\" object can be posted to /autoctx/api/v1/calibration/import.")
+}
+
+func splitBenchList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" && !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func parseBenchInts(s string) ([]int, error) {
+	var out []int
+	for _, p := range splitBenchList(s) {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid value %q (must be a positive integer)", p)
+		}
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no values")
+	}
+	return out, nil
+}
+
+func errorPct(predicted float64, actual int) float64 {
+	return round1((predicted - float64(actual)) / float64(actual) * 100)
+}
+
+func clampBench(v, lo, hi float64) float64 {
+	return math.Min(math.Max(v, lo), hi)
+}
+
+func round1(v float64) float64 { return math.Round(v*10) / 10 }
+func round3(v float64) float64 { return math.Round(v*1000) / 1000 }
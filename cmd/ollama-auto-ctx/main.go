@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"ollama-auto-ctx/internal/api"
 	"ollama-auto-ctx/internal/calibration"
 	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/estimate"
 	"ollama-auto-ctx/internal/ollama"
 	"ollama-auto-ctx/internal/proxy"
 	"ollama-auto-ctx/internal/storage"
@@ -25,8 +30,18 @@ func main() {
 		fmt.Fprintln(os.Stderr, "config error:", err)
 		os.Exit(2)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(cfg, os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// Live log streaming tees every record to /logs subscribers
+	var logBroadcaster *supervisor.LogBroadcaster
+	if cfg.LogStreamEnabled {
+		logBroadcaster = supervisor.NewLogBroadcaster(256)
+		defer logBroadcaster.Shutdown()
+	}
 
-	logger := newLogger(cfg.LogLevel)
+	logger := newLogger(cfg.LogLevel, logBroadcaster)
 	features := cfg.Features()
 
 	logConfig(logger, cfg, features)
@@ -39,6 +54,7 @@ func main() {
 	}
 
 	showCache := ollama.NewShowCache(ollamaClient, cfg.ShowCacheTTL)
+	showCache.SetStaleWhileRevalidate(cfg.ShowCacheStale)
 
 	// Calibration store
 	defaults := calibration.Params{
@@ -47,6 +63,7 @@ func main() {
 		PerMessageOverhead: cfg.DefaultPerMessageOverhead,
 	}
 	calibStore := calibration.NewStore(0.20, defaults, cfg.CalibrationFile)
+	calibStore.SetOverrides(overheadOverrides(cfg))
 
 	// Storage (SQLite or Memory)
 	var store storage.Store
@@ -68,8 +85,15 @@ func main() {
 				store = storage.NewMemoryStore(cfg.StorageMaxRows)
 			}
 		}
-		if store != nil {
-			defer store.Close()
+	}
+
+	if cfg.CalibrationBackend == config.CalibrationBackendStorage {
+		if sqliteStore, ok := store.(*storage.SQLiteStore); ok {
+			if err := calibStore.SetBackend(sqliteStore, cfg.CalibrationSaveDebounce); err != nil {
+				logger.Warn("failed to load calibration from storage", "err", err)
+			}
+		} else {
+			logger.Warn("CALIBRATION_BACKEND=storage needs SQLite storage; calibration is only persisted to CALIBRATION_FILE")
 		}
 	}
 
@@ -77,6 +101,9 @@ func main() {
 	var apiServer *api.Server
 	if features.API && store != nil {
 		apiServer = api.NewServer(store, cfg, logger)
+		if logBroadcaster != nil {
+			apiServer.SetLogBroadcaster(logBroadcaster)
+		}
 	}
 
 	// Supervisor components (legacy compatibility, used when features.Protect is true)
@@ -93,11 +120,15 @@ func main() {
 		// Create metrics if enabled
 		if features.Metrics {
 			metrics = supervisor.NewMetrics()
+			if cfg.MetricsInFlightPeakEnabled {
+				metrics.EnableInFlightPeak()
+			}
 		}
 
 		// Create event bus if enabled
 		if features.Events {
 			eventBus = supervisor.NewEventBus(100)
+			eventBus.SetDropReporting(metrics, logger, cfg.EventDropLogThreshold, cfg.EventDropLogInterval)
 		}
 
 		// Create tracker
@@ -109,6 +140,15 @@ func main() {
 			cfg.ProgressInterval,
 			metrics,
 		)
+		if cfg.OverflowLogPath != "" {
+			overflow, err := supervisor.NewOverflowLog(cfg.OverflowLogPath, cfg.OverflowLogMaxBytes, logger)
+			if err != nil {
+				logger.Error("failed to open overflow log; evicted errors won't be kept", "path", cfg.OverflowLogPath, "err", err)
+			} else {
+				tracker.SetOverflowLog(overflow)
+				defer overflow.Close()
+			}
+		}
 
 		// Create retryer if retry mode enabled
 		if features.Retry {
@@ -118,6 +158,9 @@ func main() {
 				Backoff:          time.Duration(cfg.RetryBackoffMs) * time.Millisecond,
 				OnlyNonStreaming: true,
 				MaxResponseBytes: 8 * 1024 * 1024,
+				MinEvalCount:     cfg.RetryMinEvalCount,
+				RetryLoading:     cfg.RetryOnModelLoading,
+				LoadingBackoff:   time.Duration(cfg.RetryLoadingBackoffMs) * time.Millisecond,
 			})
 		}
 
@@ -147,6 +190,80 @@ func main() {
 		defer healthChecker.Shutdown()
 	}
 
+	// Estimate divergence detection (needs tracker observations)
+	var divergence *supervisor.DivergenceDetector
+	if tracker != nil && cfg.DivergenceDetectEnabled {
+		divergence = supervisor.NewDivergenceDetector(cfg.DivergenceWindow, cfg.DivergenceThreshold, eventBus, metrics, logger)
+		tracker.SetDivergenceDetector(divergence)
+	}
+
+	if apiServer != nil && tracker != nil {
+		apiServer.SetTracker(tracker)
+		apiServer.SetDivergenceDetector(divergence)
+	}
+	if apiServer != nil && eventBus != nil {
+		apiServer.SetEventBus(eventBus)
+	}
+
+	// Background components that may touch the store are stopped before it
+	// is closed at shutdown, rather than deferred to after it.
+	var stopBeforeClose []func()
+
+	// Latency SLO tracking (computed from stored durations)
+	if store != nil && cfg.SLOLatencyThreshold > 0 {
+		slo := supervisor.NewSLOMonitor(store, cfg.SLOTarget, cfg.SLOLatencyThreshold, cfg.SLOWindow, metrics, logger)
+		slo.Start()
+		stopBeforeClose = append(stopBeforeClose, slo.Shutdown)
+		if apiServer != nil {
+			apiServer.SetSLOMonitor(slo)
+		}
+	}
+
+	// Periodic metric snapshots for history without Prometheus
+	if store != nil && metrics != nil && cfg.MetricsSnapshotInterval > 0 {
+		snapshotter := supervisor.NewMetricsSnapshotter(metrics, store, cfg.MetricsSnapshotInterval, cfg.MetricsSnapshotRetention, logger)
+		snapshotter.Start()
+		stopBeforeClose = append(stopBeforeClose, snapshotter.Shutdown)
+		if apiServer != nil {
+			apiServer.SetMetricsSnapshotter(snapshotter)
+		}
+	}
+
+	// Per-tag quotas, whose usage survives restarts in SQLite storage
+	var quotas *supervisor.TagQuotas
+	if len(cfg.TagQuotas) > 0 {
+		limits := make(map[string]supervisor.QuotaLimit, len(cfg.TagQuotas))
+		for tag, q := range cfg.TagQuotaLimits() {
+			limits[tag] = supervisor.QuotaLimit{Tokens: q.Tokens, Requests: q.Requests}
+		}
+		quotas = supervisor.NewTagQuotas(limits, cfg.TagQuotaWindow, metrics, logger)
+		if sqliteStore, ok := store.(*storage.SQLiteStore); ok {
+			if err := quotas.SetBackend(sqliteStore); err != nil {
+				logger.Warn("failed to load tag quota usage from storage", "err", err)
+			}
+		} else {
+			logger.Warn("TAG_QUOTAS usage needs SQLite storage to survive restarts; keeping it in memory")
+		}
+		if apiServer != nil {
+			apiServer.SetTagQuotas(quotas)
+		}
+	}
+
+	// Only the proxy's per-request writes go through the breaker and
+	// sampling; everything else sees the same store. The breaker sits
+	// underneath so sampled-out errors it persists later are covered too.
+	var breaker *storage.BreakerStore
+	if store != nil && cfg.StorageBreakerThreshold > 0 {
+		breaker = storage.NewBreakerStore(store, storage.BreakerConfig{
+			Threshold: cfg.StorageBreakerThreshold,
+			Cooldown:  cfg.StorageBreakerCooldown,
+		}, logger, metrics.SetStorageDegraded)
+		store = breaker
+	}
+	if store != nil && cfg.StorageSampleRate < 1 {
+		store = storage.NewSampledStore(store, cfg.StorageSampleRate, metrics.RecordStorageSampledOut)
+	}
+
 	// Create handler
 	h := proxy.NewHandler(
 		cfg,
@@ -164,6 +281,125 @@ func main() {
 		healthChecker,
 		logger,
 	)
+	h.SetStorageBreaker(breaker)
+	h.SetTagQuotas(quotas)
+
+	var residency *supervisor.ResidencyPoller
+	if cfg.ResidencyPollInterval > 0 {
+		residency = supervisor.NewResidencyPoller(ollamaClient, cfg.ResidencyPollInterval, metrics, logger)
+		residency.Start()
+		defer residency.Shutdown()
+		h.SetResidencyPoller(residency)
+		if apiServer != nil {
+			apiServer.SetResidencyPoller(residency)
+		}
+	}
+
+	if cfg.VRAMGateEnabled {
+		h.SetVRAMGate(supervisor.NewVRAMGate(residency, supervisor.VRAMGateConfig{
+			TotalBytes:    cfg.IdleEvictVRAMBytes,
+			HeadroomBytes: cfg.VRAMHeadroomBytes,
+			QueueTimeout:  cfg.VRAMGateQueueTimeout,
+			MaxAge:        3 * cfg.ResidencyPollInterval,
+		}, metrics, logger))
+	}
+
+	if cfg.IdleEvictEnabled {
+		evictor := supervisor.NewIdleEvictor(ollamaClient, supervisor.IdleEvictorConfig{
+			IdleAfter: cfg.IdleEvictAfter,
+			Interval:  cfg.IdleEvictInterval,
+			Always:    cfg.IdleEvictMode == config.IdleEvictAlways,
+			VRAMBytes: cfg.IdleEvictVRAMBytes,
+			Pressure:  cfg.IdleEvictPressure,
+		}, metrics, logger)
+		evictor.SetResidencyPoller(residency)
+		evictor.Start()
+		defer evictor.Shutdown()
+		h.SetIdleEvictor(evictor)
+		if apiServer != nil {
+			apiServer.SetIdleEvictor(evictor)
+		}
+	}
+
+	if cfg.ShadowEnabled && store != nil {
+		shadowURL, err := url.Parse(cfg.ShadowUpstreamURL)
+		if err != nil {
+			logger.Error("invalid shadow upstream URL", "err", err)
+			os.Exit(2)
+		}
+		h.SetShadowMirror(proxy.NewShadowMirror(shadowURL, proxy.ShadowConfig{
+			SampleRate:   cfg.ShadowSampleRate,
+			Timeout:      cfg.ShadowTimeout,
+			MaxInFlight:  cfg.ShadowMaxInFlight,
+			MaxBodyBytes: cfg.ResponseTapMaxBytes,
+		}, store, metrics, logger))
+	}
+
+	if cfg.DecisionCorpusPath != "" {
+		file, err := supervisor.OpenJSONLFile(cfg.DecisionCorpusPath, cfg.DecisionCorpusMaxBytes, "decision corpus", logger)
+		if err != nil {
+			logger.Error("failed to open decision corpus; decisions won't be recorded", "path", cfg.DecisionCorpusPath, "err", err)
+		} else {
+			corpus := proxy.NewDecisionCorpus(file, cfg.DecisionCorpusSampleRate)
+			h.SetDecisionCorpus(corpus)
+			stopBeforeClose = append(stopBeforeClose, func() { corpus.Close() })
+		}
+	}
+
+	if len(cfg.HookCommands) > 0 {
+		if eventBus == nil {
+			logger.Warn("outcome hooks need events, which MODE=off disables; HOOK_CMD_* ignored")
+		} else {
+			hooks, err := supervisor.NewOutcomeHooks(eventBus, supervisor.OutcomeHookConfig{
+				Commands:       cfg.HookCommands,
+				Cooldown:       cfg.HookCooldown,
+				MaxPerHour:     cfg.HookMaxPerHour,
+				CommandTimeout: cfg.HookTimeout,
+			}, logger)
+			if err != nil {
+				logger.Error("invalid outcome hook", "err", err)
+				os.Exit(2)
+			}
+			hooks.Start()
+			stopBeforeClose = append(stopBeforeClose, hooks.Shutdown)
+		}
+	}
+
+	if cfg.UtilizationLearnerEnabled {
+		learner := calibration.NewUtilizationLearner(cfg.UtilizationWindow, cfg.UtilizationMinSamples, cfg.UtilizationMargin, cfg.UtilizationFloor)
+		h.SetUtilizationLearner(learner)
+		if apiServer != nil {
+			apiServer.SetUtilizationLearner(learner)
+		}
+	}
+
+	if cfg.BucketAnalyzerEnabled {
+		analyzerCfg := calibration.BucketAnalyzerConfig{
+			Window:      cfg.BucketAnalyzerWindow,
+			MinSamples:  cfg.BucketAnalyzerMinSamples,
+			Granularity: cfg.BucketAnalyzerGranularity,
+		}
+		if cfg.BucketAutoInsertEnabled {
+			analyzerCfg.AutoInsertMinSaving = cfg.BucketAutoInsertMinSaving
+			analyzerCfg.AutoInsertMax = cfg.BucketAutoInsertMax
+		}
+		analyzer := calibration.NewBucketAnalyzer(analyzerCfg)
+		h.SetBucketAnalyzer(analyzer)
+		if apiServer != nil {
+			apiServer.SetBucketAnalyzer(analyzer)
+		}
+	}
+
+	if cfg.SessionBudgetEscalationEnabled {
+		h.SetSessionEscalator(calibration.NewSessionEscalator(cfg.SessionBudgetEscalationAfter, cfg.SessionBudgetEscalationFactor, cfg.SessionBudgetTTL, cfg.SessionBudgetMaxSessions))
+	}
+
+	if apiServer != nil {
+		apiServer.SetCalibrationStore(calibStore)
+		apiServer.SetEstimator(func(ctx context.Context, f estimate.Features) (any, error) {
+			return h.Estimate(ctx, f)
+		})
+	}
 
 	srv := &http.Server{
 		Addr:              cfg.ListenAddr,
@@ -171,8 +407,19 @@ func main() {
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
+	// Optional separate admin listener (dashboard, API, events, metrics)
+	var adminSrv *http.Server
+	if cfg.AdminListenAddr != "" {
+		adminSrv = &http.Server{
+			Addr:              cfg.AdminListenAddr,
+			Handler:           h.AdminHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+
 	logger.Info("starting ollama-auto-ctx",
 		"listen", cfg.ListenAddr,
+		"admin_listen", cfg.AdminListenAddr,
 		"upstream", cfg.UpstreamURL,
 		"mode", cfg.Mode,
 	)
@@ -184,18 +431,99 @@ func main() {
 		}
 	}()
 
+	if adminSrv != nil {
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("admin server error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 	logger.Info("shutting down")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
 	defer cancel()
-	_ = srv.Shutdown(ctx)
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			logger.Warn("admin server shutdown error", "err", err)
+		}
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn("server shutdown error", "err", err)
+	}
+
+	flushOnShutdown(ctx, logger, store, calibStore, quotas, stopBeforeClose)
 }
 
-func newLogger(level string) *slog.Logger {
+// flushOnShutdown runs stop, last registered first, then persists
+// calibration and tag quota usage and closes storage within what is left of
+// the grace period, logging anything that could not be saved. Calibration
+// and quotas go first since they may be written to the store.
+func flushOnShutdown(ctx context.Context, logger *slog.Logger, store storage.Store, calibStore *calibration.Store, quotas *supervisor.TagQuotas, stop []func()) {
+	for i := len(stop) - 1; i >= 0; i-- {
+		stop[i]()
+	}
+
+	if n, err := calibStore.Flush(); err != nil {
+		logger.Error("calibration flush failed", "err", err)
+	} else if n > 0 {
+		logger.Info("calibration flushed", "models", n)
+	}
+	if err := quotas.Flush(); err != nil {
+		logger.Error("tag quota usage flush failed", "err", err)
+	}
+
+	if store != nil {
+		if n, err := store.InFlightCount(); err == nil && n > 0 {
+			logger.Warn("requests still in flight at shutdown; their records stay incomplete", "count", n)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- store.Close() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				logger.Error("storage close failed; pending writes may be lost", "err", err)
+			} else {
+				logger.Info("storage flushed and closed")
+			}
+		case <-ctx.Done():
+			logger.Error("storage close exceeded shutdown grace period; pending writes may be lost")
+		}
+	}
+}
+
+// overheadOverrides merges MODEL_FIXED_OVERHEAD_TOKENS and
+// MODEL_PER_MESSAGE_OVERHEAD_TOKENS by prefix, pinning those listed in
+// MODEL_OVERHEAD_PINNED.
+func overheadOverrides(cfg config.Config) map[string]calibration.Override {
+	out := make(map[string]calibration.Override)
+	for prefix, v := range cfg.ModelFixedOverheadTokens {
+		o := out[prefix]
+		o.FixedOverhead = v
+		out[prefix] = o
+	}
+	for prefix, v := range cfg.ModelPerMessageOverhead {
+		o := out[prefix]
+		o.PerMessageOverhead = v
+		out[prefix] = o
+	}
+	for _, prefix := range cfg.ModelOverheadPinned {
+		prefix = strings.ToLower(prefix)
+		if o, ok := out[prefix]; ok {
+			o.Pinned = true
+			out[prefix] = o
+		}
+	}
+	return out
+}
+
+func newLogger(level string, broadcaster *supervisor.LogBroadcaster) *slog.Logger {
 	lvl := new(slog.LevelVar)
 	switch level {
 	case "debug":
@@ -210,18 +538,47 @@ func newLogger(level string) *slog.Logger {
 		lvl.Set(slog.LevelInfo)
 	}
 
-	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl})
+	var h slog.Handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl})
+	if broadcaster != nil {
+		h = broadcaster.Handler(h)
+	}
 	return slog.New(h)
 }
 
 func logConfig(logger *slog.Logger, cfg config.Config, f config.Features) {
 	logger.Info("configuration",
+		"config_file", cfg.ConfigFile,
 		"mode", cfg.Mode,
 		"listen_addr", cfg.ListenAddr,
+		"admin_listen_addr", cfg.AdminListenAddr,
+		"admin_auth", cfg.AdminAuthEnabled(),
+		"shutdown_grace_period", cfg.ShutdownGracePeriod,
+		"global_request_timeout", cfg.GlobalRequestTimeout,
+		"proxy_auth_required", cfg.ProxyAuthRequired,
 		"upstream_url", cfg.UpstreamURL,
+		"log_stream_enabled", cfg.LogStreamEnabled,
+		"slow_request_threshold", cfg.SlowRequestThreshold,
 		"storage", cfg.Storage,
 		"storage_path", cfg.StoragePath,
 		"storage_max_rows", cfg.StorageMaxRows,
+		"store_request_options", cfg.StoreRequestOptions,
+		"store_request_fingerprint", cfg.StoreRequestFingerprint,
+		"snapshot_option_keys", cfg.SnapshotOptionKeys,
+		"exclude_option_keys", cfg.ExcludeOptionKeys,
+		"storage_sample_rate", cfg.StorageSampleRate,
+		"storage_breaker_threshold", cfg.StorageBreakerThreshold,
+		"storage_breaker_cooldown", cfg.StorageBreakerCooldown,
+		"tag_quotas", len(cfg.TagQuotas),
+		"tag_quota_window", cfg.TagQuotaWindow,
+		"overflow_log_path", cfg.OverflowLogPath,
+		"options_allowlist", cfg.OptionsAllowlist,
+		"model_family_rules", cfg.ModelFamilyRules,
+		"family_think_encodings", cfg.FamilyThinkEncodings,
+		"family_buckets", cfg.FamilyBuckets,
+		"family_role_weights", cfg.FamilyRoleWeights,
+		"model_buckets", cfg.ModelBuckets,
+		"model_preferred_ctx", cfg.ModelPreferredCtx,
+		"preferred_ctx_tolerance", cfg.PreferredCtxTolerance,
 		"features.dashboard", f.Dashboard,
 		"features.api", f.API,
 		"features.events", f.Events,
@@ -232,6 +589,66 @@ func logConfig(logger *slog.Logger, cfg config.Config, f config.Features) {
 		"min_ctx", cfg.MinCtx,
 		"max_ctx", cfg.MaxCtx,
 		"headroom", cfg.Headroom,
+		"min_absolute_headroom_tokens", cfg.MinAbsoluteHeadroom,
+		"headroom_confidence_enabled", cfg.HeadroomConfidenceEnabled,
+		"unstable_calibration_policy", cfg.UnstableCalibrationPolicy,
+		"override_num_ctx", cfg.OverrideNumCtx,
+		"endpoint_override_num_ctx", cfg.EndpointOverrideNumCtx,
+		"show_timeout", cfg.ShowTimeout,
+		"show_timeout_policy", cfg.ShowTimeoutPolicy,
+		"unverified_max_ctx", cfg.UnverifiedMaxCtx,
+		"error_response_style", cfg.ErrorResponseStyle,
+		"upstream_dialect", cfg.UpstreamDialect,
+		"seed_policy", cfg.SeedPolicy,
+		"no_supervise_policy", cfg.NoSupervisePolicy,
+		"timeout_profiles", cfg.TimeoutProfiles,
+		"model_timeout_profile", cfg.ModelTimeoutProfile,
+		"loop_retry_enabled", cfg.LoopRetryEnabled,
+		"model_fallback", cfg.ModelFallback,
+		"explain_enabled", cfg.ExplainEnabled,
+		"image_budget_policy", cfg.ImageBudgetPolicy,
+		"image_budget_fraction", cfg.ImageBudgetFraction,
+		"stop_budget_factor", cfg.StopBudgetFactor,
+		"no_stop_think_budget_factor", cfg.NoStopThinkBudgetFactor,
+		"code_tokens_per_byte", cfg.CodeTokensPerByte,
+		"model_code_tokens_per_byte", cfg.CodeTokensPerByteOverrides,
+		"overhead_overrides", overheadOverrides(cfg),
+		"role_weights", cfg.RoleWeights,
+		"template_replaces_overhead", cfg.TemplateReplacesOverhead,
 		"calibration_enabled", cfg.CalibrationEnabled,
+		"calibration_backend", cfg.CalibrationBackend,
+		"calibration_min_samples", cfg.CalibrationMinSamples,
+		"calibration_import_policy", cfg.CalibrationImportPolicy,
+		"truncation_check_enabled", cfg.TruncationCheckEnabled,
+		"utilization_learner_enabled", cfg.UtilizationLearnerEnabled,
+		"bucket_analyzer_enabled", cfg.BucketAnalyzerEnabled,
+		"bucket_auto_insert_enabled", cfg.BucketAutoInsertEnabled,
+		"session_budget_escalation_enabled", cfg.SessionBudgetEscalationEnabled,
+		"divergence_detect_enabled", cfg.DivergenceDetectEnabled,
+		"event_drop_log_threshold", cfg.EventDropLogThreshold,
+		"idle_evict_enabled", cfg.IdleEvictEnabled,
+		"idle_evict_mode", cfg.IdleEvictMode,
+		"residency_poll_interval", cfg.ResidencyPollInterval,
+		"vram_gate_enabled", cfg.VRAMGateEnabled,
+		"vram_ctx_cap_enabled", cfg.VRAMCtxCapEnabled,
+		"shadow_enabled", cfg.ShadowEnabled,
+		"max_prompt_tokens", cfg.MaxPromptTokens,
+		"model_max_concurrency", cfg.ModelMaxConcurrency,
+		"model_concurrency_policy", cfg.ModelConcurrencyPolicy,
+		"load_coalesce_enabled", cfg.LoadCoalesceEnabled,
+		"prompt_scan_rules", len(cfg.PromptScanRules),
+		"response_cache_ttl", cfg.ResponseCacheTTL,
+		"stream_coalesce_bytes", cfg.StreamCoalesceBytes,
+		"progress_sideband_enabled", cfg.ProgressSidebandEnabled,
+		"token_trailers_enabled", cfg.TokenTrailersEnabled,
+		"hook_outcomes", slices.Sorted(maps.Keys(cfg.HookCommands)),
+		"slo_latency_threshold", cfg.SLOLatencyThreshold,
+		"slo_target", cfg.SLOTarget,
+		"slo_window", cfg.SLOWindow,
+		"metrics_snapshot_interval", cfg.MetricsSnapshotInterval,
+		"metrics_snapshot_retention", cfg.MetricsSnapshotRetention,
+		"metrics_in_flight_peak_enabled", cfg.MetricsInFlightPeakEnabled,
+		"decision_corpus_path", cfg.DecisionCorpusPath,
+		"decision_corpus_sample_rate", cfg.DecisionCorpusSampleRate,
 	)
 }
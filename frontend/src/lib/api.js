@@ -5,6 +5,29 @@
 
 const API_BASE = '/autoctx/api/v1'
 
+/**
+ * Dashboard settings, overwritten by fetchUIConfig with the server's values.
+ * Sections set to false are hidden.
+ */
+export const uiConfig = {
+  refresh_ms: { overview: 5000, requests: 3000, health: 10000 },
+  requests_limit: 200,
+  sections: {}
+}
+
+/**
+ * Load refresh intervals, page size and sections from /ui-config into uiConfig.
+ * @returns {Promise<void>}
+ */
+export async function fetchUIConfig() {
+  const res = await fetch(`${API_BASE}/ui-config`)
+  if (!res.ok) throw new Error('Failed to fetch UI config')
+  const data = await res.json()
+  if (data.refresh_ms) Object.assign(uiConfig.refresh_ms, data.refresh_ms)
+  if (data.requests_limit > 0) uiConfig.requests_limit = data.requests_limit
+  if (data.sections) uiConfig.sections = data.sections
+}
+
 /**
  * Fetch overview statistics and time series data.
  * @param {string} window - Time window: '1h', '24h', or '7d'
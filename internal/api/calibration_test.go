@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/config"
+)
+
+func TestCalibrationImport(t *testing.T) {
+	defaults := calibration.Params{TokensPerByte: 0.25, FixedOverhead: 32, PerMessageOverhead: 8}
+	newServer := func() (*Server, *calibration.Store) {
+		calib := calibration.NewStore(0.2, defaults, "")
+		if _, err := calib.Import(map[string]calibration.Params{
+			"llama3":  {TokensPerByte: 0.3, FixedOverhead: 40, PerMessageOverhead: 6, Samples: 12},
+			"mistral": {TokensPerByte: 0.28, FixedOverhead: 20, PerMessageOverhead: 4, Samples: 3},
+		}, true); err != nil {
+			t.Fatal(err)
+		}
+		s := NewServer(nil, config.Config{AdminAuthToken: "s3cret", CalibrationImportPolicy: config.CalibrationImportMerge}, nil)
+		s.SetCalibrationStore(calib)
+		return s, calib
+	}
+	post := func(s *Server, query, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, APIPrefix+"/calibration/import"+query, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		s, calib := newServer()
+		w := post(s, "", `{"llama3":{"tokens_per_byte":0.5,"fixed_overhead":10,"per_message_overhead":2},"qwen":{"tokens_per_byte":0.2}}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp CalibrationImportResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Policy != "merge" || resp.Imported != 2 || resp.Models != 3 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+		snap := calib.Snapshot()
+		if snap["llama3"].TokensPerByte != 0.5 {
+			t.Errorf("llama3 not overwritten: %+v", snap["llama3"])
+		}
+		if snap["mistral"].TokensPerByte != 0.28 {
+			t.Errorf("mistral should be kept by merge: %+v", snap["mistral"])
+		}
+		// Missing fields are filled from the defaults.
+		if q := snap["qwen"]; q.TokensPerByte != 0.2 || q.FixedOverhead != 32 || q.UpdatedAt.IsZero() {
+			t.Errorf("qwen not imported with defaults: %+v", q)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		s, calib := newServer()
+		w := post(s, "?policy=replace", `{"qwen":{"tokens_per_byte":0.2}}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		snap := calib.Snapshot()
+		if len(snap) != 1 || snap["qwen"].TokensPerByte != 0.2 {
+			t.Errorf("replace should leave only qwen, got %+v", snap)
+		}
+	})
+
+	for _, tt := range []struct {
+		name, query, body string
+	}{
+		{"tokens per byte out of range", "", `{"llama3":{"tokens_per_byte":3},"qwen":{"tokens_per_byte":0.2}}`},
+		{"negative overhead", "?policy=replace", `{"qwen":{"tokens_per_byte":0.2,"fixed_overhead":-1}}`},
+		{"per-message overhead out of range", "", `{"qwen":{"per_message_overhead":100}}`},
+		{"empty model name", "", `{"":{"tokens_per_byte":0.2}}`},
+		{"unknown field", "", `{"qwen":{"tokens_per_bytes":0.2}}`},
+		{"empty payload", "", `{}`},
+		{"no body", "", ``},
+		{"unknown policy", "?policy=overwrite", `{"qwen":{"tokens_per_byte":0.2}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, calib := newServer()
+			before := calib.Snapshot()
+			if w := post(s, tt.query, tt.body); w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+			}
+			after := calib.Snapshot()
+			if len(after) != len(before) {
+				t.Fatalf("rejected import changed the models: %+v", after)
+			}
+			for model, p := range before {
+				if after[model] != p {
+					t.Errorf("rejected import changed %s: %+v -> %+v", model, p, after[model])
+				}
+			}
+		})
+	}
+
+	t.Run("requires admin auth", func(t *testing.T) {
+		s, _ := newServer()
+		s.cfg.AdminAuthToken = ""
+		if w := post(s, "", `{"qwen":{"tokens_per_byte":0.2}}`); w.Code != http.StatusForbidden {
+			t.Errorf("expected 403 without admin auth, got %d", w.Code)
+		}
+	})
+}
@@ -1,10 +1,26 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/family"
 	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
 )
 
 // OverviewResponse contains summary statistics and time series data.
@@ -25,6 +41,8 @@ type SummaryData struct {
 	Timeouts      int     `json:"timeouts"`
 	Loops         int     `json:"loops"`
 	InFlight      int     `json:"in_flight"`
+	// StatusCodes counts requests by upstream HTTP status.
+	StatusCodes map[int]int `json:"status_codes,omitempty"`
 }
 
 // SeriesData contains time-binned chart data.
@@ -36,7 +54,7 @@ type SeriesData struct {
 }
 
 // handleOverview returns summary statistics and time series.
-// GET /autoctx/api/v1/overview?window=1h|24h|7d
+// GET /autoctx/api/v1/overview?window=1h|24h|7d&refresh=true
 func (s *Server) handleOverview(w http.ResponseWriter, r *http.Request) {
 	if s.store == nil {
 		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
@@ -45,15 +63,18 @@ func (s *Server) handleOverview(w http.ResponseWriter, r *http.Request) {
 
 	window := parseWindow(r)
 	cacheKey := window.String()
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
 
-	// Check cache
-	s.overviewCacheMu.RLock()
-	if cached, ok := s.overviewCache[cacheKey]; ok && time.Now().Before(cached.expiresAt) {
+	// Check cache, unless the caller asked for fresh data
+	if !refresh {
+		s.overviewCacheMu.RLock()
+		if cached, ok := s.overviewCache[cacheKey]; ok && time.Now().Before(cached.expiresAt) {
+			s.overviewCacheMu.RUnlock()
+			s.writeJSON(w, cached.data)
+			return
+		}
 		s.overviewCacheMu.RUnlock()
-		s.writeJSON(w, cached.data)
-		return
 	}
-	s.overviewCacheMu.RUnlock()
 
 	// Fetch fresh data
 	overview, err := s.store.Overview(window)
@@ -83,6 +104,7 @@ func (s *Server) handleOverview(w http.ResponseWriter, r *http.Request) {
 			Timeouts:      overview.Timeouts,
 			Loops:         overview.Loops,
 			InFlight:      inFlight,
+			StatusCodes:   overview.StatusCodes,
 		},
 		Series: SeriesData{
 			DurationP95:    durationSeries,
@@ -122,6 +144,8 @@ type RequestListItem struct {
 	RetryCount       int    `json:"retry_count"`
 	Status           string `json:"status"`
 	Reason           string `json:"reason,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // RequestListResponse contains paginated request list.
@@ -133,7 +157,7 @@ type RequestListResponse struct {
 }
 
 // handleListRequests returns a paginated list of requests.
-// GET /autoctx/api/v1/requests?limit=50&offset=0&status=&model=&reason=&window=24h
+// GET /autoctx/api/v1/requests?limit=50&offset=0&status=&model=&reason=&tag=key=value&window=24h
 func (s *Server) handleListRequests(w http.ResponseWriter, r *http.Request) {
 	if s.store == nil {
 		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
@@ -162,6 +186,11 @@ func (s *Server) handleListRequests(w http.ResponseWriter, r *http.Request) {
 		r := storage.Reason(reason)
 		opts.Reason = &r
 	}
+	if tag := q.Get("tag"); tag != "" {
+		// Keys are stored lowercased, values as sent.
+		k, v, _ := strings.Cut(tag, "=")
+		opts.Tag = strings.ToLower(strings.TrimSpace(k)) + "=" + strings.TrimSpace(v)
+	}
 
 	requests, err := s.store.List(opts)
 	if err != nil {
@@ -191,6 +220,7 @@ func (s *Server) handleListRequests(w http.ResponseWriter, r *http.Request) {
 			RetryCount:       req.RetryCount,
 			Status:           string(req.Status),
 			Reason:           string(req.Reason),
+			Tags:             storage.ParseTags(req.Tags),
 		}
 	}
 
@@ -211,7 +241,16 @@ type RequestDetailResponse struct {
 	Status   string `json:"status"`
 	Reason   string `json:"reason,omitempty"`
 	Model    string `json:"model"`
+	Family   string `json:"family,omitempty"`
 	Endpoint string `json:"endpoint"`
+	// Tags are the client's X-AutoCtx-Tags key=value pairs.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Unsupervised is set when X-AutoCtx-No-Supervise bypassed the watchdog,
+	// loop detection and output limit.
+	Unsupervised bool `json:"unsupervised,omitempty"`
+	// TimeoutProfile is the TIMEOUT_PROFILES profile the watchdog held the
+	// request to, if any.
+	TimeoutProfile string `json:"timeout_profile,omitempty"`
 
 	// Request shape
 	Request RequestShape `json:"request"`
@@ -224,6 +263,10 @@ type RequestDetailResponse struct {
 
 	// Response summary
 	Response ResponseData `json:"response"`
+
+	// Latency splits DurationMs into phases that sum to it; nil until the
+	// request finished.
+	Latency *storage.LatencyBreakdown `json:"latency,omitempty"`
 }
 
 // RequestShape contains request structure metadata.
@@ -236,14 +279,34 @@ type RequestShape struct {
 	ToolChoice      string `json:"tool_choice,omitempty"`
 	StreamRequested bool   `json:"stream_requested"`
 	ClientInBytes   int64  `json:"client_in_bytes"`
+
+	// Options is the client's options object as stored (redacted per config).
+	Options json.RawMessage `json:"options,omitempty"`
+	// StrippedOptions are option keys the allow-list removed before forwarding.
+	StrippedOptions []string `json:"stripped_options,omitempty"`
+	// InvalidImages counts images that failed IMAGE_VALIDATION.
+	InvalidImages int `json:"invalid_images,omitempty"`
+	// ImagesDropped counts images IMAGE_BUDGET_POLICY=drop removed before forwarding.
+	ImagesDropped int `json:"images_dropped,omitempty"`
+	// InjectedSeed is the options.seed added per SEED_POLICY, if any.
+	InjectedSeed *int64 `json:"injected_seed,omitempty"`
+	// Shadow is how the shadow upstream answered, if the request was mirrored.
+	Shadow *storage.ShadowResult `json:"shadow,omitempty"`
 }
 
 // AutoCTXData contains context sizing decisions.
 type AutoCTXData struct {
-	CtxEst       int `json:"ctx_est"`
-	CtxSelected  int `json:"ctx_selected"`
-	CtxBucket    int `json:"ctx_bucket"`
-	OutputBudget int `json:"output_budget"`
+	CtxEst       int    `json:"ctx_est"`
+	CtxSelected  int    `json:"ctx_selected"`
+	CtxBucket    int    `json:"ctx_bucket"`
+	OutputBudget int    `json:"output_budget"`
+	ThinkVerdict string `json:"think_verdict,omitempty"`
+	ThinkSource  string `json:"think_source,omitempty"` // client|directive|default
+	ThinkValue   string `json:"think_value,omitempty"`  // "think" field sent upstream, as JSON
+	// TruncationSuspected is set when the actual prompt tokens exceeded
+	// ctx_selected minus output_budget (TRUNCATION_CHECK_ENABLED).
+	TruncationSuspected bool   `json:"truncation_suspected"`
+	TruncationReason    string `json:"truncation_reason,omitempty"`
 }
 
 // OllamaData contains upstream response data.
@@ -265,7 +328,14 @@ type ResponseData struct {
 	TTFBMs         int    `json:"ttfb_ms"`
 	ClientOutBytes int64  `json:"client_out_bytes"`
 	RetryCount     int    `json:"retry_count"`
+	LoadingRetries int    `json:"loading_retries,omitempty"` // retries for model-still-loading errors
+	LoopRetry      string `json:"loop_retry,omitempty"`      // rescued|looped when the completion looped
+	FallbackModel  string `json:"fallback_model,omitempty"`  // MODEL_FALLBACK model that served the request
 	ErrorClass     string `json:"error_class,omitempty"`
+	// Cost is what the request's tokens cost at the configured token prices;
+	// CostEstimated is set when it was priced from token estimates.
+	Cost          float64 `json:"cost,omitempty"`
+	CostEstimated bool    `json:"cost_estimated,omitempty"`
 }
 
 // handleGetRequest returns full details for a single request.
@@ -288,13 +358,17 @@ func (s *Server) handleGetRequest(w http.ResponseWriter, r *http.Request, id str
 	}
 
 	resp := RequestDetailResponse{
-		ID:       req.ID,
-		TSStart:  req.TSStart,
-		TSEnd:    req.TSEnd,
-		Status:   string(req.Status),
-		Reason:   string(req.Reason),
-		Model:    req.Model,
-		Endpoint: req.Endpoint,
+		ID:             req.ID,
+		TSStart:        req.TSStart,
+		TSEnd:          req.TSEnd,
+		Status:         string(req.Status),
+		Reason:         string(req.Reason),
+		Model:          req.Model,
+		Family:         req.Family,
+		Endpoint:       req.Endpoint,
+		Tags:           storage.ParseTags(req.Tags),
+		Unsupervised:   req.Unsupervised,
+		TimeoutProfile: req.TimeoutProfile,
 		Request: RequestShape{
 			MessagesCount:   req.MessagesCount,
 			SystemChars:     req.SystemChars,
@@ -304,12 +378,24 @@ func (s *Server) handleGetRequest(w http.ResponseWriter, r *http.Request, id str
 			ToolChoice:      req.ToolChoice,
 			StreamRequested: req.StreamRequested,
 			ClientInBytes:   req.ClientInBytes,
+			Options:         optionsRaw(req.OptionsJSON),
+			StrippedOptions: splitList(req.StrippedOptions),
+			InvalidImages:   req.InvalidImages,
+			ImagesDropped:   req.ImagesDropped,
+			InjectedSeed:    req.InjectedSeed,
+			Shadow:          req.Shadow,
 		},
 		AutoCTX: AutoCTXData{
 			CtxEst:       req.CtxEst,
 			CtxSelected:  req.CtxSelected,
 			CtxBucket:    req.CtxBucket,
 			OutputBudget: req.OutputBudget,
+			ThinkVerdict: req.ThinkVerdict,
+			ThinkSource:  req.ThinkSource,
+			ThinkValue:   req.ThinkValue,
+
+			TruncationSuspected: req.TruncationSuspected,
+			TruncationReason:    truncationReason(req),
 		},
 		Ollama: OllamaData{
 			PromptTokens:         req.PromptTokens,
@@ -327,13 +413,29 @@ func (s *Server) handleGetRequest(w http.ResponseWriter, r *http.Request, id str
 			TTFBMs:         req.TTFBMs,
 			ClientOutBytes: req.ClientOutBytes,
 			RetryCount:     req.RetryCount,
+			LoadingRetries: req.LoadingRetries,
+			LoopRetry:      req.LoopRetry,
+			FallbackModel:  req.FallbackModel,
 			ErrorClass:     req.ErrorClass,
+			Cost:           req.Cost,
+			CostEstimated:  req.CostEstimated,
 		},
+		Latency: req.LatencyBreakdown(),
 	}
 
 	s.writeJSON(w, resp)
 }
 
+// truncationReason explains why req was flagged as likely truncated, or
+// returns "" if it wasn't.
+func truncationReason(req *storage.Request) string {
+	if !req.TruncationSuspected {
+		return ""
+	}
+	return fmt.Sprintf("prompt_eval_count %d exceeded ctx_selected %d minus output_budget %d",
+		req.PromptTokens, req.CtxSelected, req.OutputBudget)
+}
+
 // ModelListResponse contains per-model statistics.
 type ModelListResponse struct {
 	Models []storage.ModelStat `json:"models"`
@@ -358,6 +460,418 @@ func (s *Server) handleListModels(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, ModelListResponse{Models: stats})
 }
 
+// maxCompareModels bounds the models accepted by /compare.
+const maxCompareModels = 10
+
+// CompareResponse contains side-by-side stats for the requested models.
+type CompareResponse struct {
+	Window string                    `json:"window"`
+	Models []storage.ModelComparison `json:"models"`
+}
+
+// handleCompare returns side-by-side efficiency stats for a few models.
+// GET /autoctx/api/v1/compare?models=a,b,c&window=7d
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	var models []string
+	seen := make(map[string]bool)
+	for _, m := range splitList(r.URL.Query().Get("models")) {
+		m = strings.TrimSpace(m)
+		if m != "" && !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	if len(models) == 0 {
+		s.writeError(w, http.StatusBadRequest, "models is required (comma-separated)")
+		return
+	}
+	if len(models) > maxCompareModels {
+		s.writeError(w, http.StatusBadRequest, "too many models")
+		return
+	}
+
+	window := parseWindow(r)
+	stats, err := s.store.CompareModels(window, models)
+	if err != nil {
+		s.logger.Error("failed to compare models", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to compare models")
+		return
+	}
+
+	s.writeJSON(w, CompareResponse{Window: window.String(), Models: stats})
+}
+
+// Ranking: /compare/rank scores models by a weighted blend of their
+// /compare stats, e.g. to pick the model behind a "fast" or "smart" alias.
+const (
+	maxRankModels          = 50 // most-used models considered when none are named
+	defaultRankMinRequests = 5
+)
+
+// RankFactors holds one value per ranking factor: the weights of a ranking,
+// or a model's score on each factor in [0, 1].
+type RankFactors struct {
+	Latency       float64 `json:"latency"`        // best p95 duration over this model's
+	Throughput    float64 `json:"throughput"`     // this model's gen tokens/sec over the best
+	Success       float64 `json:"success"`        // success rate
+	CtxEfficiency float64 `json:"ctx_efficiency"` // share of the chosen context actually used
+}
+
+var defaultRankWeights = RankFactors{Latency: 1, Throughput: 1, Success: 2, CtxEfficiency: 0.5}
+
+// RankedModel is a model's stats with its weighted score.
+type RankedModel struct {
+	storage.ModelComparison
+	Score   float64     `json:"score"`
+	Factors RankFactors `json:"factors"`
+}
+
+// RankResponse lists models best first.
+type RankResponse struct {
+	Window      string        `json:"window"`
+	Weights     RankFactors   `json:"weights"`
+	MinRequests int           `json:"min_requests"`
+	Models      []RankedModel `json:"models"`
+}
+
+// handleRank returns models ordered by a weighted score of latency,
+// throughput, success rate and context efficiency over the window.
+// GET /autoctx/api/v1/compare/rank?models=a,b&window=7d&min_requests=5&latency=1&throughput=1&success=2&ctx_efficiency=0.5
+func (s *Server) handleRank(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	q := r.URL.Query()
+	weights := defaultRankWeights
+	for name, wt := range map[string]*float64{
+		"latency":        &weights.Latency,
+		"throughput":     &weights.Throughput,
+		"success":        &weights.Success,
+		"ctx_efficiency": &weights.CtxEfficiency,
+	} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f >= 0) || math.IsInf(f, 0) {
+			s.writeError(w, http.StatusBadRequest, name+" weight must be a number >= 0")
+			return
+		}
+		*wt = f
+	}
+	if weights.Latency+weights.Throughput+weights.Success+weights.CtxEfficiency == 0 {
+		s.writeError(w, http.StatusBadRequest, "at least one weight must be > 0")
+		return
+	}
+	minRequests := parseInt(q.Get("min_requests"), defaultRankMinRequests)
+	window := parseWindow(r)
+
+	var models []string
+	seen := make(map[string]bool)
+	for _, m := range splitList(q.Get("models")) {
+		m = strings.TrimSpace(m)
+		if m != "" && !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	if len(models) > maxRankModels {
+		s.writeError(w, http.StatusBadRequest, "too many models")
+		return
+	}
+	if len(models) == 0 {
+		stats, err := s.store.ModelStats(window)
+		if err != nil {
+			s.logger.Error("failed to get model stats", "err", err)
+			s.writeError(w, http.StatusInternalServerError, "failed to rank models")
+			return
+		}
+		for _, st := range stats {
+			if len(models) < maxRankModels {
+				models = append(models, st.Model)
+			}
+		}
+	}
+
+	stats, err := s.store.CompareModels(window, models)
+	if err != nil {
+		s.logger.Error("failed to compare models", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to rank models")
+		return
+	}
+	var eligible []storage.ModelComparison
+	for _, st := range stats {
+		if st.RequestCount > 0 && st.RequestCount >= minRequests {
+			eligible = append(eligible, st)
+		}
+	}
+
+	s.writeJSON(w, RankResponse{
+		Window:      window.String(),
+		Weights:     weights,
+		MinRequests: minRequests,
+		Models:      rankModels(eligible, s.utilizationP95(), weights),
+	})
+}
+
+// utilizationP95 returns the utilization learner's p95 per model, used for
+// models whose stored requests carry no token counts.
+func (s *Server) utilizationP95() map[string]float64 {
+	out := make(map[string]float64)
+	for _, st := range s.utilization.Stats() {
+		out[st.Model] = st.P95
+	}
+	return out
+}
+
+// rankModels scores stats by weights and sorts them best first. Latency and
+// throughput are relative to the best model in stats, so a score only
+// compares models ranked together.
+func rankModels(stats []storage.ModelComparison, utilization map[string]float64, weights RankFactors) []RankedModel {
+	var bestP95 int
+	var bestTokPerS float64
+	for _, st := range stats {
+		if st.DurationP95Ms > 0 && (bestP95 == 0 || st.DurationP95Ms < bestP95) {
+			bestP95 = st.DurationP95Ms
+		}
+		bestTokPerS = max(bestTokPerS, st.AvgGenTokPerS)
+	}
+
+	total := weights.Latency + weights.Throughput + weights.Success + weights.CtxEfficiency
+	out := make([]RankedModel, 0, len(stats))
+	for _, st := range stats {
+		var f RankFactors
+		if st.DurationP95Ms > 0 {
+			f.Latency = float64(bestP95) / float64(st.DurationP95Ms)
+		}
+		if bestTokPerS > 0 {
+			f.Throughput = st.AvgGenTokPerS / bestTokPerS
+		}
+		f.Success = st.SuccessRate
+		util := st.AvgCtxUtilization
+		if util == 0 {
+			util = utilization[st.Model]
+		}
+		f.CtxEfficiency = min(util, 1)
+
+		score := (weights.Latency*f.Latency + weights.Throughput*f.Throughput +
+			weights.Success*f.Success + weights.CtxEfficiency*f.CtxEfficiency) / total
+		out = append(out, RankedModel{ModelComparison: st, Score: score, Factors: f})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out
+}
+
+// TagStatsResponse groups request stats by the values of one tag key.
+type TagStatsResponse struct {
+	Window string            `json:"window"`
+	Key    string            `json:"key"`
+	Values []storage.TagStat `json:"values"`
+}
+
+// handleTagStats returns per-value stats for a tag key, e.g. per team.
+// GET /autoctx/api/v1/tags?key=team&window=7d
+func (s *Server) handleTagStats(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	key := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("key")))
+	if key == "" || strings.ContainsAny(key, ",=") {
+		s.writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+
+	window := parseWindow(r)
+	stats, err := s.store.TagStats(window, key)
+	if err != nil {
+		s.logger.Error("failed to get tag stats", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to get tag stats")
+		return
+	}
+
+	s.writeJSON(w, TagStatsResponse{Window: window.String(), Key: key, Values: stats})
+}
+
+// CostsResponse sums request costs per model, or per value of a tag key.
+type CostsResponse struct {
+	Window string             `json:"window"`
+	Tag    string             `json:"tag,omitempty"` // tag key grouped by; empty groups by model
+	Total  float64            `json:"total"`
+	Groups []storage.CostStat `json:"groups"`
+}
+
+// handleCosts returns what requests in the window cost, costliest group
+// first, per model or, with ?tag=, per value of that tag key.
+// GET /autoctx/api/v1/costs?window=7d&tag=team
+func (s *Server) handleCosts(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.CostEnabled() {
+		s.writeError(w, http.StatusNotFound, "token prices not configured")
+		return
+	}
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	key := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+	if strings.ContainsAny(key, ",=") {
+		s.writeError(w, http.StatusBadRequest, "tag must be a tag key")
+		return
+	}
+	window := parseWindow(r)
+	stats, err := s.store.CostStats(window, key)
+	if err != nil {
+		s.logger.Error("failed to get cost stats", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to get cost stats")
+		return
+	}
+
+	resp := CostsResponse{Window: window.String(), Tag: key, Groups: stats}
+	for _, st := range stats {
+		resp.Total += st.Cost
+	}
+	s.writeJSON(w, resp)
+}
+
+// FingerprintsResponse lists the request fingerprints repeated most often,
+// for judging whether caching responses would pay off.
+type FingerprintsResponse struct {
+	Window       string                    `json:"window"`
+	Fingerprints []storage.FingerprintStat `json:"fingerprints"`
+}
+
+// handleFingerprints returns the fingerprints more than one request carried,
+// most repeated first.
+// GET /autoctx/api/v1/fingerprints?limit=20&window=7d
+func (s *Server) handleFingerprints(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.StoreRequestFingerprint {
+		s.writeError(w, http.StatusNotFound, "request fingerprints not enabled")
+		return
+	}
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	window := parseWindow(r)
+	stats, err := s.store.TopFingerprints(window, parseInt(r.URL.Query().Get("limit"), 20))
+	if err != nil {
+		s.logger.Error("failed to get fingerprints", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to get fingerprints")
+		return
+	}
+
+	s.writeJSON(w, FingerprintsResponse{Window: window.String(), Fingerprints: stats})
+}
+
+// defaultUsageWindow is the window of the usage heatmap: four weeks, so every
+// weekday/hour slot has several samples.
+const defaultUsageWindow = 28 * 24 * time.Hour
+
+// UsageHeatmapResponse buckets request counts and p95 latency by weekday and
+// hour of day for capacity planning.
+type UsageHeatmapResponse struct {
+	Window           string   `json:"window"`
+	UTCOffsetMinutes int      `json:"utc_offset_minutes"`
+	Weekdays         []string `json:"weekdays"` // row labels of cells, Sunday first
+	*storage.UsagePattern
+}
+
+// handleUsageHeatmap returns a weekday x hour-of-day grid of request counts
+// and p95 durations. utc_offset shifts the buckets into a local time zone,
+// in minutes east of UTC (e.g. 120 for UTC+2).
+// GET /autoctx/api/v1/usage/heatmap?window=28d&utc_offset=120
+func (s *Server) handleUsageHeatmap(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	window := defaultUsageWindow
+	if r.URL.Query().Get("window") != "" {
+		window = parseWindow(r)
+	}
+	offset := 0
+	if v := r.URL.Query().Get("utc_offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < -14*60 || n > 14*60 {
+			s.writeError(w, http.StatusBadRequest, "utc_offset must be minutes between -840 and 840")
+			return
+		}
+		offset = n
+	}
+
+	pattern, err := s.store.UsagePattern(window, time.Duration(offset)*time.Minute)
+	if err != nil {
+		s.logger.Error("failed to get usage pattern", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to get usage pattern")
+		return
+	}
+
+	weekdays := make([]string, 7)
+	for d := range weekdays {
+		weekdays[d] = time.Weekday(d).String()
+	}
+	s.writeJSON(w, UsageHeatmapResponse{
+		Window:           window.String(),
+		UTCOffsetMinutes: offset,
+		Weekdays:         weekdays,
+		UsagePattern:     pattern,
+	})
+}
+
+// MetricsHistoryResponse lists stored metric snapshots, oldest first.
+type MetricsHistoryResponse struct {
+	Window    string                   `json:"window"`
+	Interval  string                   `json:"interval"`
+	Snapshots []storage.MetricSnapshot `json:"snapshots"`
+}
+
+// handleMetricsHistory returns stored metric snapshots for long-term trend
+// charts; names limits the values returned (e.g. rate(oac_requests_total)).
+// GET /autoctx/api/v1/metrics/history?window=7d&names=oac_requests_total,oac_requests_in_flight
+func (s *Server) handleMetricsHistory(w http.ResponseWriter, r *http.Request) {
+	if s.snapshots == nil {
+		s.writeError(w, http.StatusNotFound, "metric snapshots not enabled")
+		return
+	}
+
+	window := parseWindow(r)
+	var names []string
+	for _, n := range splitList(r.URL.Query().Get("names")) {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	snaps, err := s.store.MetricSnapshots(window, names)
+	if err != nil {
+		s.logger.Error("failed to get metric snapshots", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to get metric snapshots")
+		return
+	}
+
+	s.writeJSON(w, MetricsHistoryResponse{
+		Window:    window.String(),
+		Interval:  s.cfg.MetricsSnapshotInterval.String(),
+		Snapshots: snaps,
+	})
+}
+
 // ModelSeriesResponse contains time series data for a model.
 type ModelSeriesResponse struct {
 	Model  string              `json:"model"`
@@ -403,7 +917,18 @@ type ConfigResponse struct {
 	Storage        string `json:"storage"`
 	StorageMaxRows int    `json:"storage_max_rows"`
 	RetryMax       int    `json:"retry_max"`
-	Features       struct {
+	// ThinkDefaults maps model-name prefixes to their default think verdict.
+	ThinkDefaults map[string]string `json:"think_defaults"`
+	// OptionsAllowlist is the set of forwarded option keys; empty forwards all.
+	OptionsAllowlist []string `json:"options_allowlist,omitempty"`
+	// Buckets is the global context ladder; ModelBuckets (by model-name
+	// prefix) and FamilyBuckets replace it, in that order of precedence.
+	Buckets       []int            `json:"buckets"`
+	ModelBuckets  map[string][]int `json:"model_buckets,omitempty"`
+	FamilyBuckets map[string][]int `json:"family_buckets,omitempty"`
+	// Resolved is the ladder ?model= gets, when given.
+	Resolved *ResolvedBuckets `json:"resolved,omitempty"`
+	Features struct {
 		Dashboard bool `json:"dashboard"`
 		API       bool `json:"api"`
 		Events    bool `json:"events"`
@@ -414,8 +939,17 @@ type ConfigResponse struct {
 	} `json:"features"`
 }
 
-// handleConfig returns the current configuration.
-// GET /autoctx/api/v1/config
+// ResolvedBuckets is the bucket ladder a model resolves to.
+type ResolvedBuckets struct {
+	Model   string `json:"model"`
+	Family  string `json:"family,omitempty"`
+	Source  string `json:"source"` // model|family|global
+	Buckets []int  `json:"buckets"`
+}
+
+// handleConfig returns the current configuration. With ?model=, it also
+// resolves that model's bucket ladder.
+// GET /autoctx/api/v1/config?model=qwen3:8b
 func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	features := s.cfg.Features()
 
@@ -424,6 +958,21 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		Storage:        string(s.cfg.Storage),
 		StorageMaxRows: s.cfg.StorageMaxRows,
 		RetryMax:       s.cfg.RetryMax,
+		ThinkDefaults:  s.cfg.ThinkDefaults,
+
+		OptionsAllowlist: s.cfg.OptionsAllowlist,
+
+		Buckets:       s.cfg.Buckets,
+		ModelBuckets:  s.cfg.ModelBuckets,
+		FamilyBuckets: s.cfg.FamilyBuckets,
+	}
+	if resp.ThinkDefaults == nil {
+		resp.ThinkDefaults = map[string]string{}
+	}
+	if model := r.URL.Query().Get("model"); model != "" {
+		fam := family.NewClassifier(s.cfg.ModelFamilyRules).Classify(model)
+		buckets, source := s.cfg.BucketsFor(model, fam)
+		resp.Resolved = &ResolvedBuckets{Model: model, Family: string(fam), Source: source, Buckets: buckets}
 	}
 	resp.Features.Dashboard = features.Dashboard
 	resp.Features.API = features.API
@@ -435,3 +984,916 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 
 	s.writeJSON(w, resp)
 }
+
+// InFlightRequest is a live request with estimated progress.
+type InFlightRequest struct {
+	supervisor.RequestInfo
+	EstimatedOutputTokens int64 `json:"estimated_output_tokens"`
+	supervisor.Progress
+}
+
+// InFlightResponse lists in-flight requests, oldest first.
+type InFlightResponse struct {
+	Requests []InFlightRequest `json:"requests"`
+}
+
+// handleInFlight returns in-flight requests with percent complete and ETA.
+// GET /autoctx/api/v1/inflight
+func (s *Server) handleInFlight(w http.ResponseWriter, r *http.Request) {
+	if s.tracker == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "tracker not available")
+		return
+	}
+
+	now := time.Now()
+	snapshot := s.tracker.Snapshot()
+	items := make([]InFlightRequest, 0, len(snapshot.InFlight))
+	for _, req := range snapshot.InFlight {
+		items = append(items, InFlightRequest{
+			RequestInfo:           req,
+			EstimatedOutputTokens: s.tracker.EstimatedOutputTokens(req),
+			Progress:              s.tracker.Progress(req, now),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartTime.Before(items[j].StartTime)
+	})
+
+	s.writeJSON(w, InFlightResponse{Requests: items})
+}
+
+// HealthScoreResponse summarizes proxy health as a 0-100 score.
+type HealthScoreResponse struct {
+	Score       int                            `json:"score"`
+	Status      string                         `json:"status"` // ok|degraded|unhealthy
+	Window      string                         `json:"window"`
+	SuccessRate *float64                       `json:"success_rate,omitempty"`
+	Anomalies   []supervisor.DivergenceAnomaly `json:"anomalies"`
+	SLO         *supervisor.SLOStatus          `json:"slo,omitempty"`
+}
+
+// handleHealthScore scores recent success rate, active estimate anomalies and
+// latency SLO burn. Failed requests cost up to 50 points, each diverging model
+// 10, and a burn rate above 1 costs 10 points per unit (capped at 40).
+// GET /autoctx/api/v1/health-score?window=1h
+func (s *Server) handleHealthScore(w http.ResponseWriter, r *http.Request) {
+	window := time.Hour
+	if r.URL.Query().Get("window") != "" {
+		window = parseWindow(r)
+	}
+
+	resp := HealthScoreResponse{
+		Window:    window.String(),
+		Anomalies: s.divergence.Active(),
+	}
+	if resp.Anomalies == nil {
+		resp.Anomalies = []supervisor.DivergenceAnomaly{}
+	}
+
+	score := 100.0
+	if s.store != nil {
+		overview, err := s.store.Overview(window)
+		if err != nil {
+			s.logger.Error("failed to get overview for health score", "err", err)
+		} else if overview.TotalRequests > 0 {
+			rate := overview.SuccessRate
+			resp.SuccessRate = &rate
+			score -= (1 - rate) * 50
+		}
+	}
+	score -= float64(len(resp.Anomalies)) * 10
+
+	if s.slo != nil {
+		slo := s.slo.Status()
+		resp.SLO = &slo
+		if slo.BurnRate != nil && *slo.BurnRate > 1 {
+			score -= math.Min(40, (*slo.BurnRate-1)*10)
+		}
+	}
+
+	if score < 0 {
+		score = 0
+	}
+
+	resp.Score = int(score)
+	switch {
+	case resp.Score >= 80:
+		resp.Status = "ok"
+	case resp.Score >= 50:
+		resp.Status = "degraded"
+	default:
+		resp.Status = "unhealthy"
+	}
+
+	s.writeJSON(w, resp)
+}
+
+// optionsRaw passes a stored options snapshot through as JSON, dropping anything
+// that isn't valid JSON so the response stays well-formed.
+func optionsRaw(s string) json.RawMessage {
+	if s == "" || !json.Valid([]byte(s)) {
+		return nil
+	}
+	return json.RawMessage(s)
+}
+
+// splitList splits a stored comma-separated list, returning nil when empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// UIConfigResponse tells the dashboard how often to poll and what to show.
+type UIConfigResponse struct {
+	APIBase       string `json:"api_base"`
+	EventsPath    string `json:"events_path,omitempty"` // empty when events are disabled
+	MetricsPath   string `json:"metrics_path,omitempty"`
+	MetricsPrefix string `json:"metrics_prefix"`
+	RefreshMs     struct {
+		Overview int64 `json:"overview"`
+		Requests int64 `json:"requests"`
+		Health   int64 `json:"health"`
+	} `json:"refresh_ms"`
+	RequestsLimit int             `json:"requests_limit"`
+	Sections      map[string]bool `json:"sections"`
+}
+
+// handleUIConfig returns dashboard settings driven by server config.
+// GET /autoctx/api/v1/ui-config
+func (s *Server) handleUIConfig(w http.ResponseWriter, r *http.Request) {
+	features := s.cfg.Features()
+
+	resp := UIConfigResponse{
+		APIBase:       APIPrefix,
+		MetricsPrefix: supervisor.MetricsPrefix,
+		RequestsLimit: s.cfg.DashboardRequestsLimit,
+		Sections:      make(map[string]bool, len(config.DashboardSectionNames)),
+	}
+	if features.Events {
+		resp.EventsPath = "/events"
+	}
+	if features.Metrics {
+		resp.MetricsPath = "/metrics"
+	}
+	resp.RefreshMs.Overview = s.cfg.DashboardOverviewRefresh.Milliseconds()
+	resp.RefreshMs.Requests = s.cfg.DashboardRequestsRefresh.Milliseconds()
+	resp.RefreshMs.Health = s.cfg.DashboardHealthRefresh.Milliseconds()
+
+	for _, name := range config.DashboardSectionNames {
+		resp.Sections[name] = false
+	}
+	for _, name := range s.cfg.DashboardSections {
+		resp.Sections[name] = true
+	}
+	// The metrics section reads /metrics, so it can't show without it.
+	if !features.Metrics {
+		resp.Sections["metrics"] = false
+	}
+
+	s.writeJSON(w, resp)
+}
+
+// handleLogs streams live log records over SSE.
+// GET /autoctx/api/v1/logs?level=warn&q=substring
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if s.logs == nil {
+		s.writeError(w, http.StatusNotFound, "log stream not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := supervisor.LogFilter{MinLevel: slog.LevelInfo, Contains: q.Get("q")}
+	if lvl := q.Get("level"); lvl != "" {
+		if err := filter.MinLevel.UnmarshalText([]byte(lvl)); err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid level (use debug, info, warn or error)")
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := s.logs.Subscribe(filter)
+	defer s.logs.Unsubscribe(ch)
+
+	_, _ = w.Write([]byte(": connected\n\n"))
+	flusher.Flush()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rec, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := supervisor.FormatSSELog(rec)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte(data)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handleRequestStream streams one in-flight request's lifecycle events (start,
+// first byte, progress, and the final one) over SSE, and ends the stream
+// after its final event.
+// GET /autoctx/api/v1/requests/{id}/stream
+func (s *Server) handleRequestStream(w http.ResponseWriter, r *http.Request, id string) {
+	if s.events == nil || s.tracker == nil {
+		s.writeError(w, http.StatusNotFound, "events not enabled")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	// Subscribe before the in-flight check so the final event can't slip
+	// between them.
+	ch := s.events.Subscribe()
+	defer s.events.Unsubscribe(ch)
+	if s.tracker.GetRequestInfo(id) == nil {
+		s.writeError(w, http.StatusNotFound, "request not in flight")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	_, _ = w.Write([]byte(": connected\n\n"))
+	flusher.Flush()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.RequestID != id || !event.Lifecycle() {
+				continue
+			}
+			data, err := supervisor.FormatSSEEvent(event)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte(data)); err != nil {
+				return
+			}
+			flusher.Flush()
+			if event.Final() {
+				return
+			}
+		}
+	}
+}
+
+// handleDecisions streams each chat/generate request's sizing Decision as it
+// is made, optionally only for models starting with the model query param.
+// GET /autoctx/api/v1/decisions?model=
+func (s *Server) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		s.writeError(w, http.StatusNotFound, "events not enabled")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	model := strings.ToLower(r.URL.Query().Get("model"))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := s.events.Subscribe()
+	defer s.events.Unsubscribe(ch)
+
+	_, _ = w.Write([]byte(": connected\n\n"))
+	flusher.Flush()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.Type != supervisor.EventDecision || !strings.HasPrefix(strings.ToLower(event.Model), model) {
+				continue
+			}
+			data, err := supervisor.FormatSSEEvent(event)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write([]byte(data)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// UtilizationResponse lists the utilization learner's per-model state.
+type UtilizationResponse struct {
+	Models []calibration.UtilizationStat `json:"models"`
+}
+
+// handleUtilization returns per-model utilization history and downsizing factors.
+// GET /autoctx/api/v1/utilization
+func (s *Server) handleUtilization(w http.ResponseWriter, r *http.Request) {
+	if s.utilization == nil {
+		s.writeError(w, http.StatusNotFound, "utilization learner not enabled")
+		return
+	}
+	s.writeJSON(w, UtilizationResponse{Models: s.utilization.Stats()})
+}
+
+// handleUtilizationReset forgets utilization history for one model, or all.
+// POST /autoctx/api/v1/utilization/reset?model=
+func (s *Server) handleUtilizationReset(w http.ResponseWriter, r *http.Request) {
+	if s.utilization == nil {
+		s.writeError(w, http.StatusNotFound, "utilization learner not enabled")
+		return
+	}
+	model := r.URL.Query().Get("model")
+	n := s.utilization.Reset(model)
+	s.logger.Info("utilization history reset", "model", model, "models", n)
+	s.writeJSON(w, map[string]int{"reset": n})
+}
+
+// BucketsResponse lists the bucket analyzer's over-allocation hotspots and
+// the buckets it inserted per model.
+type BucketsResponse struct {
+	Hotspots []calibration.BucketHotspot `json:"hotspots"`
+	Inserted map[string][]int            `json:"inserted"`
+}
+
+// handleBuckets returns the buckets wasting the most context, with the finer
+// bucket that would save the most in each.
+// GET /autoctx/api/v1/buckets?model=&limit=20
+func (s *Server) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	if s.buckets == nil {
+		s.writeError(w, http.StatusNotFound, "bucket analyzer not enabled")
+		return
+	}
+	q := r.URL.Query()
+	resp := BucketsResponse{
+		Hotspots: s.buckets.Hotspots(q.Get("model"), parseInt(q.Get("limit"), 20)),
+		Inserted: s.buckets.Inserted(),
+	}
+	if resp.Hotspots == nil {
+		resp.Hotspots = []calibration.BucketHotspot{}
+	}
+	s.writeJSON(w, resp)
+}
+
+// handleBucketsReset forgets bucket history and inserted buckets for one
+// model, or all.
+// POST /autoctx/api/v1/buckets/reset?model=
+func (s *Server) handleBucketsReset(w http.ResponseWriter, r *http.Request) {
+	if s.buckets == nil {
+		s.writeError(w, http.StatusNotFound, "bucket analyzer not enabled")
+		return
+	}
+	model := r.URL.Query().Get("model")
+	n := s.buckets.Reset(model)
+	s.logger.Info("bucket history reset", "model", model, "models", n)
+	s.writeJSON(w, map[string]int{"reset": n})
+}
+
+// handleCalibration exports the learned per-model calibration in the
+// CALIBRATION_FILE format, ready for /calibration/import elsewhere.
+// GET /autoctx/api/v1/calibration
+func (s *Server) handleCalibration(w http.ResponseWriter, r *http.Request) {
+	if s.calib == nil {
+		s.writeError(w, http.StatusNotFound, "calibration not available")
+		return
+	}
+	s.writeJSON(w, s.calib.Snapshot())
+}
+
+// CalibrationOverridesResponse lists the configured overhead overrides.
+type CalibrationOverridesResponse struct {
+	Overrides map[string]calibration.Override `json:"overrides"` // by model-name prefix
+}
+
+// handleCalibrationOverrides returns the per-model overhead overrides
+// (MODEL_FIXED_OVERHEAD_TOKENS and friends), seeded or pinned.
+// GET /autoctx/api/v1/calibration/overrides
+func (s *Server) handleCalibrationOverrides(w http.ResponseWriter, r *http.Request) {
+	if s.calib == nil {
+		s.writeError(w, http.StatusNotFound, "calibration not available")
+		return
+	}
+	s.writeJSON(w, CalibrationOverridesResponse{Overrides: s.calib.Overrides()})
+}
+
+// CalibrationImportResponse reports the result of a calibration import.
+type CalibrationImportResponse struct {
+	Policy   string `json:"policy"`
+	Imported int    `json:"imported"` // models in the upload
+	Models   int    `json:"models"`   // models in the store afterwards
+}
+
+// handleCalibrationImport seeds the calibration store from an uploaded
+// CALIBRATION_FILE-format body and persists it. policy defaults to
+// CALIBRATION_IMPORT_POLICY.
+// POST /autoctx/api/v1/calibration/import?policy=merge|replace
+func (s *Server) handleCalibrationImport(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.AdminAuthEnabled() {
+		s.writeError(w, http.StatusForbidden, "calibration import requires admin auth (ADMIN_AUTH_TOKEN or ADMIN_BASIC_USER)")
+		return
+	}
+	if s.calib == nil {
+		s.writeError(w, http.StatusNotFound, "calibration not available")
+		return
+	}
+
+	policy := config.CalibrationImportPolicy(r.URL.Query().Get("policy"))
+	if policy == "" {
+		policy = s.cfg.CalibrationImportPolicy
+	}
+	switch policy {
+	case config.CalibrationImportMerge, config.CalibrationImportReplace:
+	default:
+		s.writeError(w, http.StatusBadRequest, "policy must be merge or replace")
+		return
+	}
+
+	var models map[string]calibration.Params
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&models); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid calibration payload: "+err.Error())
+		return
+	}
+	if len(models) == 0 {
+		s.writeError(w, http.StatusBadRequest, "calibration payload has no models")
+		return
+	}
+
+	n, err := s.calib.Import(models, policy == config.CalibrationImportReplace)
+	if errors.Is(err, calibration.ErrInvalidParams) {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		s.logger.Error("calibration imported but not persisted", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "calibration imported but not persisted: "+err.Error())
+		return
+	}
+	s.logger.Info("calibration imported", "policy", policy, "imported", len(models), "models", n)
+	s.writeJSON(w, CalibrationImportResponse{Policy: string(policy), Imported: len(models), Models: n})
+}
+
+// handleEventStats returns how many events the SSE bus has dropped, overall
+// and for each connected subscriber.
+// GET /autoctx/api/v1/events/stats
+func (s *Server) handleEventStats(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		s.writeError(w, http.StatusNotFound, "events not enabled")
+		return
+	}
+	s.writeJSON(w, s.events.DropStats())
+}
+
+// EvictionsResponse lists the idle evictor's recent unloads.
+type EvictionsResponse struct {
+	Evictions []supervisor.Eviction `json:"evictions"`
+}
+
+// handleEvictions returns recent idle model evictions, newest first.
+// GET /autoctx/api/v1/evictions
+func (s *Server) handleEvictions(w http.ResponseWriter, r *http.Request) {
+	if s.evictor == nil {
+		s.writeError(w, http.StatusNotFound, "idle eviction not enabled")
+		return
+	}
+	s.writeJSON(w, EvictionsResponse{Evictions: s.evictor.Evictions()})
+}
+
+// handleResidency returns the models Ollama has loaded and their VRAM, as of
+// the residency poller's last successful /api/ps poll.
+// GET /autoctx/api/v1/residency
+func (s *Server) handleResidency(w http.ResponseWriter, r *http.Request) {
+	if s.residency == nil {
+		s.writeError(w, http.StatusNotFound, "residency polling not enabled")
+		return
+	}
+	s.writeJSON(w, s.residency.Snapshot())
+}
+
+// QuotasResponse lists the TAG_QUOTAS quotas and their usage.
+type QuotasResponse struct {
+	Window string                      `json:"window"`
+	Quotas []supervisor.TagQuotaStatus `json:"quotas"`
+}
+
+// handleQuotas returns each tag quota with what its tag has used in the
+// current window.
+// GET /autoctx/api/v1/quotas
+func (s *Server) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	if s.quotas == nil {
+		s.writeError(w, http.StatusNotFound, "tag quotas not configured")
+		return
+	}
+	s.writeJSON(w, QuotasResponse{Window: s.cfg.TagQuotaWindow.String(), Quotas: s.quotas.Status()})
+}
+
+// ShadowPrimary is the primary upstream's side of a shadow comparison.
+type ShadowPrimary struct {
+	Status           string `json:"status"`
+	HTTPStatus       int    `json:"http_status,omitempty"`
+	DurationMs       int    `json:"duration_ms"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// ShadowComparison pairs a mirrored request's primary and shadow results.
+type ShadowComparison struct {
+	ID        string               `json:"id"`
+	Timestamp int64                `json:"ts"`
+	Model     string               `json:"model"`
+	Endpoint  string               `json:"endpoint"`
+	Primary   ShadowPrimary        `json:"primary"`
+	Shadow    storage.ShadowResult `json:"shadow"`
+}
+
+// ShadowResponse summarizes recent shadow comparisons.
+type ShadowResponse struct {
+	Window       string             `json:"window"`
+	Requests     []ShadowComparison `json:"requests"`
+	Compared     int                `json:"compared"` // both outputs captured
+	Matches      int                `json:"matches"`
+	MatchRate    float64            `json:"match_rate"`
+	Errors       int                `json:"errors"` // shadow failed or answered non-200
+	PrimaryAvgMs int                `json:"primary_avg_ms"`
+	ShadowAvgMs  int                `json:"shadow_avg_ms"`
+}
+
+// handleShadow returns recent requests mirrored to the shadow upstream, newest
+// first, with both results side by side.
+// GET /autoctx/api/v1/shadow?limit=50&model=&window=24h
+func (s *Server) handleShadow(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.ShadowEnabled {
+		s.writeError(w, http.StatusNotFound, "shadow mode not enabled")
+		return
+	}
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	q := r.URL.Query()
+	window := parseWindow(r)
+	requests, err := s.store.List(storage.ListOptions{
+		Limit:  parseInt(q.Get("limit"), 50),
+		Model:  q.Get("model"),
+		Shadow: true,
+		Window: window,
+	})
+	if err != nil {
+		s.logger.Error("failed to list shadow requests", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to list shadow requests")
+		return
+	}
+
+	resp := ShadowResponse{Window: window.String(), Requests: make([]ShadowComparison, 0, len(requests))}
+	var primaryMs, shadowMs int
+	for _, req := range requests {
+		resp.Requests = append(resp.Requests, ShadowComparison{
+			ID:        req.ID,
+			Timestamp: req.TSStart,
+			Model:     req.Model,
+			Endpoint:  req.Endpoint,
+			Primary: ShadowPrimary{
+				Status:           string(req.Status),
+				HTTPStatus:       req.UpstreamHTTPStatus,
+				DurationMs:       req.DurationMs,
+				CompletionTokens: req.CompletionTokens,
+			},
+			Shadow: *req.Shadow,
+		})
+		primaryMs += req.DurationMs
+		shadowMs += req.Shadow.DurationMs
+		if req.Shadow.Error != "" || req.Shadow.HTTPStatus != http.StatusOK {
+			resp.Errors++
+		}
+		if m := req.Shadow.OutputMatch; m != nil {
+			resp.Compared++
+			if *m {
+				resp.Matches++
+			}
+		}
+	}
+	if n := len(requests); n > 0 {
+		resp.PrimaryAvgMs, resp.ShadowAvgMs = primaryMs/n, shadowMs/n
+	}
+	if resp.Compared > 0 {
+		resp.MatchRate = float64(resp.Matches) / float64(resp.Compared)
+	}
+	s.writeJSON(w, resp)
+}
+
+// BackupResponse describes a database snapshot written by /maintenance/backup.
+type BackupResponse struct {
+	Path       string `json:"path"`
+	SizeBytes  int64  `json:"size_bytes"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// BackupDumpResponse is returned instead of a file for stores without an
+// on-disk form (STORAGE=memory).
+type BackupDumpResponse struct {
+	CreatedAt int64             `json:"created_at"` // unix ms
+	Count     int               `json:"count"`
+	Requests  []storage.Request `json:"requests"`
+}
+
+// handleBackup snapshots request history without stopping the proxy. SQLite
+// is copied with VACUUM INTO to name (default oac-<UTC timestamp>.sqlite) in
+// STORAGE_BACKUP_DIR; the memory store is returned as a JSON dump. Since it
+// writes files on the host, it is only available with admin auth configured.
+// POST /autoctx/api/v1/maintenance/backup?name=
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.AdminAuthEnabled() {
+		s.writeError(w, http.StatusForbidden, "backup requires admin auth (ADMIN_AUTH_TOKEN or ADMIN_BASIC_USER)")
+		return
+	}
+	if s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "oac-" + time.Now().UTC().Format("20060102-150405") + ".sqlite"
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		s.writeError(w, http.StatusBadRequest, "name must be a plain file name")
+		return
+	}
+	dir := s.cfg.StorageBackupDir
+	if dir == "" {
+		dir = filepath.Dir(s.cfg.StoragePath)
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		s.writeError(w, http.StatusConflict, "backup target already exists")
+		return
+	}
+
+	start := time.Now()
+	size, err := s.store.Backup(path)
+	if errors.Is(err, storage.ErrBackupUnsupported) {
+		s.writeBackupDump(w)
+		return
+	}
+	if err != nil {
+		s.logger.Error("backup failed", "err", err, "path", path)
+		s.writeError(w, http.StatusInternalServerError, "backup failed")
+		return
+	}
+	s.logger.Info("storage backup written", "path", path, "size_bytes", size)
+	s.writeJSON(w, BackupResponse{Path: path, SizeBytes: size, DurationMs: time.Since(start).Milliseconds()})
+}
+
+func (s *Server) writeBackupDump(w http.ResponseWriter) {
+	requests, err := s.store.List(storage.ListOptions{})
+	if err != nil {
+		s.logger.Error("failed to dump requests", "err", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to dump requests")
+		return
+	}
+	if requests == nil {
+		requests = []storage.Request{}
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="oac-requests.json"`)
+	s.writeJSON(w, BackupDumpResponse{CreatedAt: time.Now().UnixMilli(), Count: len(requests), Requests: requests})
+}
+
+// handleCacheInvalidate drops every cached overview so the next fetch is fresh.
+// POST /autoctx/api/v1/cache/invalidate
+func (s *Server) handleCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	s.overviewCacheMu.Lock()
+	n := len(s.overviewCache)
+	s.overviewCache = make(map[string]*cachedOverview)
+	s.overviewCacheMu.Unlock()
+	s.logger.Debug("overview cache invalidated", "entries", n)
+	s.writeJSON(w, map[string]int{"invalidated": n})
+}
+
+// maxEstimateItems bounds how many requests one /estimate call may size.
+const maxEstimateItems = 1000
+
+// EstimateFunc sizes a request with the given features under the current
+// config without sending it upstream, returning the proxy's decision.
+type EstimateFunc func(ctx context.Context, f estimate.Features) (any, error)
+
+// EstimateFeatures describes one request to size. NumCtx and NumPredict are
+// the client's options.num_ctx and options.num_predict, when sent. The
+// per-role byte counts are the parts of TextBytes ROLE_WEIGHTS applies to.
+type EstimateFeatures struct {
+	Model            string `json:"model"`
+	Endpoint         string `json:"endpoint"` // chat|generate
+	TextBytes        int    `json:"text_bytes"`
+	CodeBytes        int    `json:"code_bytes,omitempty"`
+	SystemBytes      int    `json:"system_bytes,omitempty"`
+	UserBytes        int    `json:"user_bytes,omitempty"`
+	AssistantBytes   int    `json:"assistant_bytes,omitempty"`
+	ToolBytes        int    `json:"tool_bytes,omitempty"`
+	MessageCount     int    `json:"message_count"`
+	ImageCount       int    `json:"image_count,omitempty"`
+	Structured       bool   `json:"structured,omitempty"`
+	Raw              bool   `json:"raw,omitempty"`
+	CustomTemplate   bool   `json:"custom_template,omitempty"`
+	SchemaProperties int    `json:"schema_properties,omitempty"`
+	NumCtx           *int   `json:"num_ctx,omitempty"`
+	NumPredict       *int   `json:"num_predict,omitempty"`
+	StopSequences    int    `json:"stop_sequences,omitempty"`
+}
+
+// EstimateRequest is the /estimate body: synthetic features, stored
+// request IDs whose recorded shapes are replayed, or both.
+type EstimateRequest struct {
+	Features   []EstimateFeatures `json:"features"`
+	RequestIDs []string           `json:"request_ids"`
+}
+
+// EstimateResult is the decision for one input. For stored requests,
+// StoredCtx is the context size the request was originally sent with, for
+// diffing against Decision.
+type EstimateResult struct {
+	RequestID string           `json:"request_id,omitempty"`
+	StoredCtx int              `json:"stored_ctx,omitempty"`
+	Features  EstimateFeatures `json:"features"`
+	Decision  any              `json:"decision,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// EstimateResponse lists results in input order: features first, then
+// request_ids.
+type EstimateResponse struct {
+	Results []EstimateResult `json:"results"`
+}
+
+// handleEstimate replays sizing decisions under the current config without
+// forwarding anything upstream. Stored shapes only record character counts
+// and client options, so their text_bytes are the summed message characters
+// and they carry no images or structured format.
+// POST /autoctx/api/v1/estimate
+func (s *Server) handleEstimate(w http.ResponseWriter, r *http.Request) {
+	if s.estimate == nil {
+		s.writeError(w, http.StatusNotFound, "estimation not enabled")
+		return
+	}
+	var body EstimateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if n := len(body.Features) + len(body.RequestIDs); n == 0 || n > maxEstimateItems {
+		s.writeError(w, http.StatusBadRequest, "send between 1 and "+strconv.Itoa(maxEstimateItems)+" features or request_ids")
+		return
+	}
+	if len(body.RequestIDs) > 0 && s.store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "storage not available")
+		return
+	}
+
+	results := make([]EstimateResult, 0, len(body.Features)+len(body.RequestIDs))
+	for _, f := range body.Features {
+		results = append(results, s.estimateOne(r.Context(), EstimateResult{Features: f}))
+	}
+	for _, id := range body.RequestIDs {
+		req, err := s.store.GetByID(id)
+		switch {
+		case err != nil:
+			s.logger.Error("failed to get request", "err", err, "id", id)
+			results = append(results, EstimateResult{RequestID: id, Error: "failed to get request"})
+		case req == nil:
+			results = append(results, EstimateResult{RequestID: id, Error: "request not found"})
+		default:
+			results = append(results, s.estimateOne(r.Context(), EstimateResult{
+				RequestID: id,
+				StoredCtx: req.CtxSelected,
+				Features:  storedFeatures(req),
+			}))
+		}
+	}
+	s.writeJSON(w, EstimateResponse{Results: results})
+}
+
+func (s *Server) estimateOne(ctx context.Context, res EstimateResult) EstimateResult {
+	f := res.Features
+	feat := estimate.Features{
+		Model:            f.Model,
+		Endpoint:         f.Endpoint,
+		TextBytes:        f.TextBytes,
+		CodeBytes:        min(f.CodeBytes, f.TextBytes),
+		MessageCount:     f.MessageCount,
+		ImageCount:       f.ImageCount,
+		Structured:       f.Structured,
+		Raw:              f.Raw,
+		CustomTemplate:   f.CustomTemplate,
+		SchemaProperties: f.SchemaProperties,
+		StopSequences:    f.StopSequences,
+
+		Roles: estimate.RoleBytes{
+			System:    f.SystemBytes,
+			User:      f.UserBytes,
+			Assistant: f.AssistantBytes,
+			Tool:      f.ToolBytes,
+		},
+	}
+	if f.NumCtx != nil {
+		feat.ProvidedNumCtx, feat.ProvidedNumCtxOK = *f.NumCtx, true
+	}
+	if f.NumPredict != nil {
+		feat.NumPredict, feat.NumPredictOK = *f.NumPredict, true
+	}
+	dec, err := s.estimate(ctx, feat)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Decision = dec
+	return res
+}
+
+// FeaturesFrom returns the /estimate input that sizes like f, the inverse of
+// what the endpoint builds from one. Code bytes by role aren't kept.
+func FeaturesFrom(f estimate.Features) EstimateFeatures {
+	out := EstimateFeatures{
+		Model:            f.Model,
+		Endpoint:         f.Endpoint,
+		TextBytes:        f.TextBytes,
+		CodeBytes:        f.CodeBytes,
+		SystemBytes:      f.Roles.System,
+		UserBytes:        f.Roles.User,
+		AssistantBytes:   f.Roles.Assistant,
+		ToolBytes:        f.Roles.Tool,
+		MessageCount:     f.MessageCount,
+		ImageCount:       f.ImageCount,
+		Structured:       f.Structured,
+		Raw:              f.Raw,
+		CustomTemplate:   f.CustomTemplate,
+		SchemaProperties: f.SchemaProperties,
+		StopSequences:    f.StopSequences,
+	}
+	if f.ProvidedNumCtxOK {
+		n := f.ProvidedNumCtx
+		out.NumCtx = &n
+	}
+	if f.NumPredictOK {
+		n := f.NumPredict
+		out.NumPredict = &n
+	}
+	return out
+}
+
+// storedFeatures rebuilds estimation features from a stored request shape.
+func storedFeatures(req *storage.Request) EstimateFeatures {
+	f := EstimateFeatures{
+		Model:        req.Model,
+		Endpoint:     req.Endpoint,
+		TextBytes:    req.SystemChars + req.UserChars + req.AssistantChars,
+		MessageCount: req.MessagesCount,
+
+		SystemBytes:    req.SystemChars,
+		UserBytes:      req.UserChars,
+		AssistantBytes: req.AssistantChars,
+	}
+	var opts map[string]any
+	if req.OptionsJSON != "" && json.Unmarshal([]byte(req.OptionsJSON), &opts) == nil {
+		if v, ok := opts["num_ctx"].(float64); ok {
+			n := int(v)
+			f.NumCtx = &n
+		}
+		if v, ok := opts["num_predict"].(float64); ok {
+			n := int(v)
+			f.NumPredict = &n
+		}
+	}
+	return f
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+func TestRankModels(t *testing.T) {
+	stats := []storage.ModelComparison{
+		{Model: "slow", RequestCount: 10, SuccessRate: 1, DurationP95Ms: 4000, AvgGenTokPerS: 20, AvgCtxUtilization: 0.8},
+		{Model: "fast", RequestCount: 10, SuccessRate: 0.9, DurationP95Ms: 1000, AvgGenTokPerS: 80},
+		{Model: "flaky", RequestCount: 10, SuccessRate: 0.2, DurationP95Ms: 1000, AvgGenTokPerS: 80, AvgCtxUtilization: 0.5},
+	}
+	utilization := map[string]float64{"fast": 0.4}
+
+	ranked := rankModels(stats, utilization, RankFactors{Latency: 1, Throughput: 1})
+	if len(ranked) != 3 || ranked[0].Model != "fast" || ranked[1].Model != "flaky" || ranked[2].Model != "slow" {
+		t.Fatalf("speed-only ranking = %+v", ranked)
+	}
+	if ranked[0].Score != 1 || ranked[2].Factors.Latency != 0.25 || ranked[2].Factors.Throughput != 0.25 {
+		t.Errorf("unexpected speed scores: %+v", ranked)
+	}
+	if ranked[0].Factors.CtxEfficiency != 0.4 {
+		t.Errorf("expected the learner's utilization for a model without token counts, got %v", ranked[0].Factors.CtxEfficiency)
+	}
+
+	ranked = rankModels(stats, utilization, RankFactors{Success: 1})
+	if ranked[0].Model != "slow" || ranked[2].Model != "flaky" {
+		t.Errorf("success-only ranking = %+v", ranked)
+	}
+}
+
+func TestRankWeights(t *testing.T) {
+	s := NewServer(storage.NewMemoryStore(10), config.Config{}, nil)
+	for _, query := range []string{"?latency=-1", "?success=abc", "?latency=0&throughput=0&success=0&ctx_efficiency=0"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, APIPrefix+"/compare/rank"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, APIPrefix+"/compare/rank?success=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestStream(t *testing.T) {
+	bus := supervisor.NewEventBus(100)
+	defer bus.Shutdown()
+	tracker := supervisor.NewTracker(10, bus, nil, 0.25, time.Second, nil)
+	s := NewServer(nil, config.Config{}, nil)
+	s.SetTracker(tracker)
+	s.SetEventBus(bus)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + APIPrefix + "/requests/r1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a request not in flight, got %d", resp.StatusCode)
+	}
+
+	tracker.Start("r1", "chat", "m", true)
+	tracker.Start("r2", "chat", "m", true)
+	resp, err = http.Get(srv.URL + APIPrefix + "/requests/r1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	go func() {
+		tracker.MarkFirstByte("r2")
+		tracker.MarkFirstByte("r1")
+		tracker.Finish("r2", supervisor.StatusSuccess, nil)
+		tracker.Finish("r1", supervisor.StatusTimeoutStall, nil)
+	}()
+
+	// The stream ends on its own after r1's final event.
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []supervisor.EventType
+	for _, line := range strings.Split(string(body), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var ev supervisor.Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("bad event %q: %v", data, err)
+		}
+		if ev.RequestID != "r1" {
+			t.Errorf("event for another request: %+v", ev)
+		}
+		types = append(types, ev.Type)
+	}
+	if len(types) != 2 || types[0] != supervisor.EventFirstByte || types[1] != supervisor.EventTimeoutStall {
+		t.Errorf("events = %v, want first_byte then timeout_stall", types)
+	}
+}
+
+func TestDecisionStream(t *testing.T) {
+	bus := supervisor.NewEventBus(100)
+	defer bus.Shutdown()
+	s := NewServer(nil, config.Config{}, nil)
+	s.SetEventBus(bus)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + APIPrefix + "/decisions?model=llama3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	br := bufio.NewReader(resp.Body)
+	if line, _ := br.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("expected the connected comment, got %q", line)
+	}
+
+	bus.Publish(supervisor.Event{Type: supervisor.EventRequestStart, RequestID: "1", Model: "llama3"})
+	bus.Publish(supervisor.Event{Type: supervisor.EventDecision, RequestID: "2", Model: "qwen3", Decision: json.RawMessage(`{"chosen_ctx":2048}`)})
+	bus.Publish(supervisor.Event{Type: supervisor.EventDecision, RequestID: "3", Model: "Llama3:8b", Decision: json.RawMessage(`{"chosen_ctx":4096}`)})
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		var ev supervisor.Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("bad event %q: %v", data, err)
+		}
+		if ev.RequestID != "3" || string(ev.Decision) != `{"chosen_ctx":4096}` {
+			t.Errorf("expected only llama3's decision, got %s", data)
+		}
+		return
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"ollama-auto-ctx/internal/calibration"
 	"ollama-auto-ctx/internal/config"
 	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
 )
 
 const (
@@ -24,9 +27,24 @@ const (
 
 // Server handles API requests for telemetry data.
 type Server struct {
-	store  storage.Store
-	cfg    config.Config
-	logger *slog.Logger
+	store   storage.Store
+	cfg     config.Config
+	logger  *slog.Logger
+	tracker *supervisor.Tracker // optional; enables /inflight
+
+	divergence *supervisor.DivergenceDetector // optional; anomalies on /health-score
+	slo        *supervisor.SLOMonitor         // optional; burn rate on /health-score
+	logs       *supervisor.LogBroadcaster     // optional; enables /logs
+	events     *supervisor.EventBus           // optional; enables /events/stats
+
+	utilization *calibration.UtilizationLearner // optional; enables /utilization
+	buckets     *calibration.BucketAnalyzer     // optional; enables /buckets
+	calib       *calibration.Store              // optional; enables /calibration
+	evictor     *supervisor.IdleEvictor         // optional; enables /evictions
+	residency   *supervisor.ResidencyPoller     // optional; enables /residency
+	snapshots   *supervisor.MetricsSnapshotter  // optional; enables /metrics/history
+	quotas      *supervisor.TagQuotas           // optional; enables /quotas
+	estimate    EstimateFunc                    // optional; enables /estimate
 
 	// Overview cache to prevent refresh storms
 	overviewCache     map[string]*cachedOverview
@@ -51,6 +69,71 @@ func NewServer(store storage.Store, cfg config.Config, logger *slog.Logger) *Ser
 	}
 }
 
+// SetTracker enables the live in-flight endpoint.
+func (s *Server) SetTracker(t *supervisor.Tracker) {
+	s.tracker = t
+}
+
+// SetDivergenceDetector surfaces estimate divergence anomalies on /health-score.
+func (s *Server) SetDivergenceDetector(d *supervisor.DivergenceDetector) {
+	s.divergence = d
+}
+
+// SetEventBus enables the /events/stats endpoint.
+func (s *Server) SetEventBus(eb *supervisor.EventBus) {
+	s.events = eb
+}
+
+// SetLogBroadcaster enables the /logs SSE endpoint.
+func (s *Server) SetLogBroadcaster(b *supervisor.LogBroadcaster) {
+	s.logs = b
+}
+
+// SetUtilizationLearner enables the /utilization endpoints.
+func (s *Server) SetUtilizationLearner(l *calibration.UtilizationLearner) {
+	s.utilization = l
+}
+
+// SetBucketAnalyzer enables the /buckets endpoints.
+func (s *Server) SetBucketAnalyzer(a *calibration.BucketAnalyzer) {
+	s.buckets = a
+}
+
+// SetCalibrationStore enables the /calibration export and import endpoints.
+func (s *Server) SetCalibrationStore(c *calibration.Store) {
+	s.calib = c
+}
+
+// SetIdleEvictor enables the /evictions endpoint.
+func (s *Server) SetIdleEvictor(e *supervisor.IdleEvictor) {
+	s.evictor = e
+}
+
+// SetResidencyPoller enables the /residency endpoint.
+func (s *Server) SetResidencyPoller(p *supervisor.ResidencyPoller) {
+	s.residency = p
+}
+
+// SetSLOMonitor surfaces latency SLO compliance and burn rate on /health-score.
+func (s *Server) SetSLOMonitor(m *supervisor.SLOMonitor) {
+	s.slo = m
+}
+
+// SetMetricsSnapshotter enables the /metrics/history endpoint.
+func (s *Server) SetMetricsSnapshotter(m *supervisor.MetricsSnapshotter) {
+	s.snapshots = m
+}
+
+// SetTagQuotas enables the /quotas endpoint.
+func (s *Server) SetTagQuotas(q *supervisor.TagQuotas) {
+	s.quotas = q
+}
+
+// SetEstimator enables the /estimate dry-run endpoint.
+func (s *Server) SetEstimator(fn EstimateFunc) {
+	s.estimate = fn
+}
+
 // ServeHTTP handles API requests.
 // It expects paths starting with /autoctx/api/v1/.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +151,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.handleOverview(w, r)
 	case path == "/requests" && r.Method == http.MethodGet:
 		s.handleListRequests(w, r)
+	case strings.HasPrefix(path, "/requests/") && strings.HasSuffix(path, "/stream") && r.Method == http.MethodGet:
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/requests/"), "/stream")
+		s.handleRequestStream(w, r, id)
 	case strings.HasPrefix(path, "/requests/") && r.Method == http.MethodGet:
 		id := strings.TrimPrefix(path, "/requests/")
 		s.handleGetRequest(w, r, id)
@@ -77,8 +163,62 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		model := strings.TrimPrefix(path, "/models/")
 		model = strings.TrimSuffix(model, "/series")
 		s.handleModelSeries(w, r, model)
+	case path == "/compare" && r.Method == http.MethodGet:
+		s.handleCompare(w, r)
+	case path == "/compare/rank" && r.Method == http.MethodGet:
+		s.handleRank(w, r)
+	case path == "/tags" && r.Method == http.MethodGet:
+		s.handleTagStats(w, r)
+	case path == "/costs" && r.Method == http.MethodGet:
+		s.handleCosts(w, r)
+	case path == "/fingerprints" && r.Method == http.MethodGet:
+		s.handleFingerprints(w, r)
+	case path == "/usage/heatmap" && r.Method == http.MethodGet:
+		s.handleUsageHeatmap(w, r)
+	case path == "/metrics/history" && r.Method == http.MethodGet:
+		s.handleMetricsHistory(w, r)
 	case path == "/config" && r.Method == http.MethodGet:
 		s.handleConfig(w, r)
+	case path == "/inflight" && r.Method == http.MethodGet:
+		s.handleInFlight(w, r)
+	case path == "/health-score" && r.Method == http.MethodGet:
+		s.handleHealthScore(w, r)
+	case path == "/ui-config" && r.Method == http.MethodGet:
+		s.handleUIConfig(w, r)
+	case path == "/events/stats" && r.Method == http.MethodGet:
+		s.handleEventStats(w, r)
+	case path == "/decisions" && r.Method == http.MethodGet:
+		s.handleDecisions(w, r)
+	case path == "/logs" && r.Method == http.MethodGet:
+		s.handleLogs(w, r)
+	case path == "/utilization" && r.Method == http.MethodGet:
+		s.handleUtilization(w, r)
+	case path == "/utilization/reset" && r.Method == http.MethodPost:
+		s.handleUtilizationReset(w, r)
+	case path == "/buckets" && r.Method == http.MethodGet:
+		s.handleBuckets(w, r)
+	case path == "/buckets/reset" && r.Method == http.MethodPost:
+		s.handleBucketsReset(w, r)
+	case path == "/calibration" && r.Method == http.MethodGet:
+		s.handleCalibration(w, r)
+	case path == "/calibration/overrides" && r.Method == http.MethodGet:
+		s.handleCalibrationOverrides(w, r)
+	case path == "/calibration/import" && r.Method == http.MethodPost:
+		s.handleCalibrationImport(w, r)
+	case path == "/evictions" && r.Method == http.MethodGet:
+		s.handleEvictions(w, r)
+	case path == "/residency" && r.Method == http.MethodGet:
+		s.handleResidency(w, r)
+	case path == "/quotas" && r.Method == http.MethodGet:
+		s.handleQuotas(w, r)
+	case path == "/shadow" && r.Method == http.MethodGet:
+		s.handleShadow(w, r)
+	case path == "/maintenance/backup" && r.Method == http.MethodPost:
+		s.handleBackup(w, r)
+	case path == "/cache/invalidate" && r.Method == http.MethodPost:
+		s.handleCacheInvalidate(w, r)
+	case path == "/estimate" && r.Method == http.MethodPost:
+		s.handleEstimate(w, r)
 	default:
 		http.NotFound(w, r)
 	}
@@ -114,10 +254,13 @@ func parseWindow(r *http.Request) time.Duration {
 	case "24h", "":
 		return 24 * time.Hour
 	default:
-		// Try to parse as duration
+		// Try to parse as duration, or as a number of days ("28d")
 		if d, err := time.ParseDuration(w); err == nil {
 			return d
 		}
+		if days, err := strconv.Atoi(strings.TrimSuffix(w, "d")); err == nil && strings.HasSuffix(w, "d") && days > 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
 		return 24 * time.Hour
 	}
 }
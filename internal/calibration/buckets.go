@@ -0,0 +1,240 @@
+package calibration
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// BucketHotspot is a ladder bucket that requests needing much less than it
+// keep landing in, and the finer bucket below it that would save the most.
+type BucketHotspot struct {
+	Model       string  `json:"model"`
+	Bucket      int     `json:"bucket"`       // the ladder bucket requests were sized to
+	Requests    int     `json:"requests"`     // requests in the window sized to Bucket
+	WasteTokens int64   `json:"waste_tokens"` // sum of Bucket less the tokens each needed
+	AvgWaste    float64 `json:"avg_waste"`
+	Suggested   int     `json:"suggested_bucket"` // finer bucket saving the most tokens
+	Caught      int     `json:"caught"`           // requests Suggested would hold
+	SavedTokens int64   `json:"saved_tokens"`     // Caught * (Bucket - Suggested)
+}
+
+// BucketAnalyzerConfig holds configuration for the bucket analyzer.
+type BucketAnalyzerConfig struct {
+	Window      int // BUCKET_ANALYZER_WINDOW: recent decisions kept per model
+	MinSamples  int // BUCKET_ANALYZER_MIN_SAMPLES: requests a bucket needs before it is a hotspot
+	Granularity int // BUCKET_ANALYZER_GRANULARITY: suggested buckets are multiples of it
+	// AutoInsertMinSaving is the least Bucket - Suggested worth inserting
+	// (BUCKET_AUTO_INSERT_MIN_SAVING); AutoInsertMax caps the buckets
+	// inserted per model (BUCKET_AUTO_INSERT_MAX, 0 = suggest only).
+	AutoInsertMinSaving int
+	AutoInsertMax       int
+}
+
+// BucketAnalyzer tracks, per model, the tokens each request needed with
+// headroom against the bucket it was sized to, and finds the buckets
+// wasting the most context: where a finer bucket, a multiple of Granularity
+// just above a cluster of needs, would hold many requests in much less. With
+// AutoInsertMax > 0 such buckets are inserted into the model's ladder once
+// they would save at least AutoInsertMinSaving tokens for each of
+// MinSamples requests; inserted buckets stay until Reset. It is safe for
+// concurrent use; a nil analyzer ignores observations and inserts nothing.
+type BucketAnalyzer struct {
+	mu       sync.Mutex
+	cfg      BucketAnalyzerConfig
+	models   map[string]*bucketState
+	inserted map[string][]int // sorted buckets inserted per model
+}
+
+type bucketObservation struct {
+	needed int
+	bucket int
+}
+
+type bucketState struct {
+	obs   []bucketObservation // circular buffer of the last Window decisions
+	next  int
+	count int
+}
+
+// NewBucketAnalyzer creates an analyzer with cfg.
+func NewBucketAnalyzer(cfg BucketAnalyzerConfig) *BucketAnalyzer {
+	if cfg.Window < 1 {
+		cfg.Window = 1
+	}
+	if cfg.MinSamples < 1 {
+		cfg.MinSamples = 1
+	}
+	if cfg.Granularity < 1 {
+		cfg.Granularity = 1
+	}
+	return &BucketAnalyzer{
+		cfg:      cfg,
+		models:   make(map[string]*bucketState),
+		inserted: make(map[string][]int),
+	}
+}
+
+// Observe records that a request for model needing needed tokens was sized
+// to bucket. Requests no ladder bucket held (bucket <= needed) waste nothing
+// and are ignored. It returns the bucket it inserted into model's ladder,
+// or 0.
+func (a *BucketAnalyzer) Observe(model string, needed, bucket int) int {
+	if a == nil || model == "" || needed <= 0 || bucket <= needed {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st, ok := a.models[model]
+	if !ok {
+		st = &bucketState{obs: make([]bucketObservation, a.cfg.Window)}
+		a.models[model] = st
+	}
+	st.obs[st.next] = bucketObservation{needed: needed, bucket: bucket}
+	st.next = (st.next + 1) % a.cfg.Window
+	if st.count < a.cfg.Window {
+		st.count++
+	}
+
+	if a.cfg.AutoInsertMax <= 0 || len(a.inserted[model]) >= a.cfg.AutoInsertMax {
+		return 0
+	}
+	h, ok := a.hotspotLocked(model, st, bucket)
+	if !ok || h.Bucket-h.Suggested < a.cfg.AutoInsertMinSaving || h.Caught < a.cfg.MinSamples {
+		return 0
+	}
+	ladder := a.inserted[model]
+	if i, found := slices.BinarySearch(ladder, h.Suggested); !found {
+		a.inserted[model] = slices.Insert(ladder, i, h.Suggested)
+		return h.Suggested
+	}
+	return 0
+}
+
+// Buckets returns ladder with the buckets inserted for model added, sorted.
+// ladder itself is not modified.
+func (a *BucketAnalyzer) Buckets(model string, ladder []int) []int {
+	if a == nil {
+		return ladder
+	}
+	a.mu.Lock()
+	extra := a.inserted[model]
+	a.mu.Unlock()
+	if len(extra) == 0 {
+		return ladder
+	}
+	out := append(slices.Clone(ladder), extra...)
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// Inserted returns the buckets inserted per model.
+func (a *BucketAnalyzer) Inserted() map[string][]int {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make(map[string][]int, len(a.inserted))
+	for model, ladder := range a.inserted {
+		out[model] = slices.Clone(ladder)
+	}
+	return out
+}
+
+// Hotspots returns, for model or every model when model is empty, the
+// buckets at least MinSamples requests were sized to that a finer bucket
+// would save tokens in, most saved first, at most limit of them (0 = all).
+func (a *BucketAnalyzer) Hotspots(model string, limit int) []BucketHotspot {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []BucketHotspot
+	for name, st := range a.models {
+		if model != "" && name != model {
+			continue
+		}
+		buckets := make(map[int]bool)
+		for _, o := range st.obs[:st.count] {
+			buckets[o.bucket] = true
+		}
+		for bucket := range buckets {
+			if h, ok := a.hotspotLocked(name, st, bucket); ok && h.Requests >= a.cfg.MinSamples {
+				out = append(out, h)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].SavedTokens != out[j].SavedTokens {
+			return out[i].SavedTokens > out[j].SavedTokens
+		}
+		if out[i].Model != out[j].Model {
+			return out[i].Model < out[j].Model
+		}
+		return out[i].Bucket < out[j].Bucket
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Reset forgets the history and inserted buckets for model, or for every
+// model when model is empty. It returns how many models were reset.
+func (a *BucketAnalyzer) Reset(model string) int {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if model == "" {
+		n := len(a.models)
+		a.models = make(map[string]*bucketState)
+		a.inserted = make(map[string][]int)
+		return n
+	}
+	if _, ok := a.models[model]; !ok {
+		return 0
+	}
+	delete(a.models, model)
+	delete(a.inserted, model)
+	return 1
+}
+
+// hotspotLocked sums the waste of the requests in st sized to bucket and
+// picks the finer bucket saving the most: the multiple of Granularity just
+// above some request's need, by the requests it would hold times what each
+// would save. ok is false when no finer bucket saves anything. Caller must
+// hold a.mu.
+func (a *BucketAnalyzer) hotspotLocked(model string, st *bucketState, bucket int) (BucketHotspot, bool) {
+	h := BucketHotspot{Model: model, Bucket: bucket}
+	var needs []int
+	for _, o := range st.obs[:st.count] {
+		if o.bucket == bucket {
+			needs = append(needs, o.needed)
+			h.WasteTokens += int64(bucket - o.needed)
+		}
+	}
+	h.Requests = len(needs)
+	if h.Requests == 0 {
+		return h, false
+	}
+	h.AvgWaste = float64(h.WasteTokens) / float64(h.Requests)
+	slices.Sort(needs)
+	g := a.cfg.Granularity
+	for i := 0; i < len(needs); {
+		candidate := (needs[i] + g - 1) / g * g
+		for i < len(needs) && needs[i] <= candidate {
+			i++
+		}
+		if candidate >= bucket {
+			break
+		}
+		if saved := int64(i) * int64(bucket-candidate); saved > h.SavedTokens {
+			h.Suggested, h.Caught, h.SavedTokens = candidate, i, saved
+		}
+	}
+	return h, h.SavedTokens > 0
+}
@@ -0,0 +1,121 @@
+package calibration
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxEscalationLevel bounds how often a session's budget is multiplied; the
+// budget is capped at the max output budget long before in practice.
+const maxEscalationLevel = 8
+
+// SessionEscalator raises the output budget of a session (requests sharing
+// a client-chosen session ID) whose responses keep stopping at
+// done_reason=length: after `after` such stops in a row the session's budget
+// is multiplied by factor once more. A response that ends normally resets the
+// count but keeps the level reached, since the larger budget was what it
+// needed. Sessions unseen for ttl are forgotten, and at most maxSessions are
+// kept (the least recently seen go first).
+//
+// It is safe for concurrent use; a nil escalator never escalates.
+type SessionEscalator struct {
+	mu          sync.Mutex
+	after       int
+	factor      float64
+	ttl         time.Duration
+	maxSessions int
+	now         func() time.Time
+	sessions    map[string]*sessionState
+}
+
+type sessionState struct {
+	lengthStops int // consecutive done_reason=length responses at the current level
+	level       int
+	seen        time.Time
+}
+
+// NewSessionEscalator creates an escalator; see SessionEscalator.
+func NewSessionEscalator(after int, factor float64, ttl time.Duration, maxSessions int) *SessionEscalator {
+	if after < 1 {
+		after = 1
+	}
+	if maxSessions < 1 {
+		maxSessions = 1
+	}
+	return &SessionEscalator{
+		after:       after,
+		factor:      factor,
+		ttl:         ttl,
+		maxSessions: maxSessions,
+		now:         time.Now,
+		sessions:    make(map[string]*sessionState),
+	}
+}
+
+// Escalate returns budget scaled for session, at most maxBudget, and the
+// escalation level applied (0 when unchanged).
+func (e *SessionEscalator) Escalate(session string, budget, maxBudget int) (int, int) {
+	if e == nil || session == "" || budget <= 0 {
+		return budget, 0
+	}
+	e.mu.Lock()
+	st, ok := e.sessions[session]
+	level := 0
+	if ok && e.now().Sub(st.seen) <= e.ttl {
+		level = st.level
+	}
+	e.mu.Unlock()
+	if level == 0 || budget >= maxBudget {
+		return budget, 0
+	}
+	scaled := math.Ceil(float64(budget) * math.Pow(e.factor, float64(level)))
+	return int(min(scaled, float64(maxBudget))), level
+}
+
+// Observe records how a response in session ended: lengthStop is true for
+// done_reason=length.
+func (e *SessionEscalator) Observe(session string, lengthStop bool) {
+	if e == nil || session == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	st, ok := e.sessions[session]
+	if !ok || now.Sub(st.seen) > e.ttl {
+		if !ok && len(e.sessions) >= e.maxSessions {
+			e.evictLocked(now)
+		}
+		st = &sessionState{}
+		e.sessions[session] = st
+	}
+	st.seen = now
+	if !lengthStop {
+		st.lengthStops = 0
+		return
+	}
+	st.lengthStops++
+	if st.lengthStops >= e.after && st.level < maxEscalationLevel {
+		st.level++
+		st.lengthStops = 0
+	}
+}
+
+// evictLocked drops expired sessions, or the least recently seen one when
+// none has expired.
+func (e *SessionEscalator) evictLocked(now time.Time) {
+	var oldest string
+	for id, st := range e.sessions {
+		if now.Sub(st.seen) > e.ttl {
+			delete(e.sessions, id)
+			continue
+		}
+		if oldest == "" || st.seen.Before(e.sessions[oldest].seen) {
+			oldest = id
+		}
+	}
+	if len(e.sessions) >= e.maxSessions && oldest != "" {
+		delete(e.sessions, oldest)
+	}
+}
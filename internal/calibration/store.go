@@ -3,8 +3,11 @@ package calibration
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,16 +19,35 @@ import (
 type Sample struct {
 	Model        string    `json:"model"`
 	Endpoint     string    `json:"endpoint"` // "chat" or "generate"
+	// TextBytes are weighted by ROLE_WEIGHTS, so calibration learns the
+	// rate of a weighted byte.
 	TextBytes    int       `json:"text_bytes"`
 	MessageCount int       `json:"message_count"`
 	ImageTokens  int       `json:"image_tokens"`
+	// CodeTokens are tokens estimated at CODE_TOKENS_PER_BYTE for bytes
+	// detected as code; those bytes are not in TextBytes, so calibration
+	// learns the prose rate.
+	CodeTokens int `json:"code_tokens,omitempty"`
 	UsedCtx      int       `json:"used_ctx"`
+	// RequestedTokens is the headroom-inclusive token estimate before any
+	// utilization adjustment; the utilization learner compares actual use to it.
+	RequestedTokens int `json:"requested_tokens,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
+	// Sampled marks features approximated from a body prefix; such samples
+	// are too rough to learn from.
+	Sampled bool `json:"sampled,omitempty"`
+	// CustomTemplate marks a generate request with its own template, whose
+	// tokens carry none of the model template's fixed overhead; it is left
+	// out of the prediction and not learned from it.
+	CustomTemplate bool `json:"custom_template,omitempty"`
 }
 
 // Observed wraps an actual prompt token count from Ollama.
 type Observed struct {
 	PromptEvalCount int `json:"prompt_eval_count"`
+	// Weight scales the EMA weight of this observation (values <= 1 mean 1),
+	// e.g. for a request the estimate undersized so far it was likely truncated.
+	Weight float64 `json:"weight,omitempty"`
 }
 
 // Params are the tunable token estimation parameters for a given model.
@@ -43,6 +65,87 @@ type Params struct {
 
 	UpdatedAt time.Time `json:"updated_at"`
 	Samples   int       `json:"samples"`
+	// ErrorEMA tracks |predicted - actual| / actual prompt tokens, as
+	// predicted before each update.
+	ErrorEMA float64 `json:"error_ema,omitempty"`
+	// EvalTokensPerSec is an EMA of the model's generation speed,
+	// eval_count / eval_duration as Ollama reports them, for ETAs; 0 until a
+	// completion reported both.
+	EvalTokensPerSec float64 `json:"eval_tokens_per_sec,omitempty"`
+}
+
+// Confidence grows from 0 to 1 as a model's calibration reaches
+// confidentSamples, scaled down by its ErrorEMA until an average error of
+// maxConfidentError leaves none.
+const (
+	confidentSamples  = 20
+	maxConfidentError = 0.5
+)
+
+// Confidence rates how far estimates from p can be trusted, from 0 (never
+// calibrated, or typically off by maxConfidentError) to 1.
+func (p Params) Confidence() float64 {
+	if p.Samples <= 0 {
+		return 0
+	}
+	samples := min(float64(p.Samples)/confidentSamples, 1)
+	accuracy := max(1-p.ErrorEMA/maxConfidentError, 0)
+	return samples * accuracy
+}
+
+// ErrInvalidParams is returned by Import for parameters outside the ranges
+// calibration itself learns within.
+var ErrInvalidParams = errors.New("invalid calibration params")
+
+// Validate checks p against the ranges Update clamps learned values to.
+// Zero rates and overheads are allowed; they are filled from the defaults.
+func (p Params) Validate() error {
+	switch {
+	case p.TokensPerByte != 0 && (p.TokensPerByte < 0.05 || p.TokensPerByte > 1.0):
+		return fmt.Errorf("%w: tokens_per_byte %g outside [0.05, 1]", ErrInvalidParams, p.TokensPerByte)
+	case p.FixedOverhead < 0 || p.FixedOverhead > 256:
+		return fmt.Errorf("%w: fixed_overhead %g outside [0, 256]", ErrInvalidParams, p.FixedOverhead)
+	case p.PerMessageOverhead < 0 || p.PerMessageOverhead > 64:
+		return fmt.Errorf("%w: per_message_overhead %g outside [0, 64]", ErrInvalidParams, p.PerMessageOverhead)
+	case p.SafeMaxCtx < 0:
+		return fmt.Errorf("%w: safe_max_ctx %d is negative", ErrInvalidParams, p.SafeMaxCtx)
+	case p.Samples < 0:
+		return fmt.Errorf("%w: samples %d is negative", ErrInvalidParams, p.Samples)
+	case p.ErrorEMA < 0:
+		return fmt.Errorf("%w: error_ema %g is negative", ErrInvalidParams, p.ErrorEMA)
+	case p.EvalTokensPerSec < 0:
+		return fmt.Errorf("%w: eval_tokens_per_sec %g is negative", ErrInvalidParams, p.EvalTokensPerSec)
+	}
+	return nil
+}
+
+// Override sets a model's fixed and per-message overhead from config, for
+// models whose chat templates cost more than the defaults assume. A seeded
+// override is where calibration starts for a model it hasn't learned yet; a
+// pinned one replaces the learned values on every Get and is never learned
+// away. A zero overhead is left to the defaults or calibration.
+type Override struct {
+	FixedOverhead      float64 `json:"fixed_overhead,omitempty"`
+	PerMessageOverhead float64 `json:"per_message_overhead,omitempty"`
+	Pinned             bool    `json:"pinned"`
+}
+
+// apply returns p with o's overheads set.
+func (o Override) apply(p Params) Params {
+	if o.FixedOverhead > 0 {
+		p.FixedOverhead = o.FixedOverhead
+	}
+	if o.PerMessageOverhead > 0 {
+		p.PerMessageOverhead = o.PerMessageOverhead
+	}
+	return p
+}
+
+// Backend persists calibration parameters outside the JSON file, e.g. in the
+// request storage database.
+type Backend interface {
+	LoadCalibration() (map[string]Params, error)
+	SaveCalibration(models map[string]Params) error
 }
 
 // Store holds model calibration data. It is safe for concurrent use.
@@ -52,6 +155,14 @@ type Store struct {
 	defaults Params
 	models   map[string]Params
 	file     string
+
+	// Overhead overrides by lowercase model-name prefix (longest wins).
+	overrides map[string]Override
+
+	// Optional backend; writes are debounced so bursts of updates cost one save.
+	backend  Backend
+	debounce time.Duration
+	timer    *time.Timer
 }
 
 // NewStore creates a calibration store.
@@ -71,20 +182,122 @@ func NewStore(alpha float64, defaults Params, filePath string) *Store {
 	return s
 }
 
-// Get returns the current parameters for a model, falling back to defaults.
+// SetBackend persists calibration through b in addition to (or instead of) the
+// JSON file. Parameters stored in b are loaded, taking precedence over the
+// file, and later updates are written through at most once per debounce.
+func (s *Store) SetBackend(b Backend, debounce time.Duration) error {
+	data, err := b.LoadCalibration()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = b
+	s.debounce = debounce
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		s.models[k] = s.fillDefaults(v)
+	}
+	return nil
+}
+
+// SetOverrides sets the per-model overhead overrides, keyed by model-name
+// prefix; the longest prefix matching a model applies.
+func (s *Store) SetOverrides(overrides map[string]Override) {
+	m := make(map[string]Override, len(overrides))
+	for prefix, o := range overrides {
+		m[strings.ToLower(prefix)] = o
+	}
+	s.mu.Lock()
+	s.overrides = m
+	s.mu.Unlock()
+}
+
+// Overrides returns a copy of the per-model overhead overrides.
+func (s *Store) Overrides() map[string]Override {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Override, len(s.overrides))
+	for k, v := range s.overrides {
+		out[k] = v
+	}
+	return out
+}
+
+// Get returns the current parameters for a model, falling back to defaults
+// (seeded by its override, if any). A pinned override always applies.
 func (s *Store) Get(model string) Params {
 	s.mu.RLock()
-	p, ok := s.models[model]
-	s.mu.RUnlock()
-	if ok {
+	defer s.mu.RUnlock()
+	if p, ok := s.models[model]; ok {
+		if o, ok := s.overrideLocked(model); ok && o.Pinned {
+			p = o.apply(p)
+		}
 		return p
 	}
-	p = s.defaults
+	p := s.defaultsLocked(model)
 	p.UpdatedAt = time.Time{}
 	p.Samples = 0
 	return p
 }
 
+// Defaults returns the parameters used for models without calibration data.
+func (s *Store) Defaults() Params {
+	return s.defaults
+}
+
+// DefaultsFor returns the parameters model starts from before calibration:
+// the defaults with its override applied.
+func (s *Store) DefaultsFor(model string) Params {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.defaultsLocked(model)
+}
+
+// defaultsLocked is DefaultsFor. Caller must hold s.mu.
+func (s *Store) defaultsLocked(model string) Params {
+	if o, ok := s.overrideLocked(model); ok {
+		return o.apply(s.defaults)
+	}
+	return s.defaults
+}
+
+// overrideLocked returns the override with the longest prefix of model.
+// Caller must hold s.mu.
+func (s *Store) overrideLocked(model string) (Override, bool) {
+	model = strings.ToLower(model)
+	best := -1
+	var o Override
+	for prefix, v := range s.overrides {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, o = len(prefix), v
+		}
+	}
+	return o, best >= 0
+}
+
+// Blend weights learned toward prior until learned has minSamples
+// observations, so one or two outliers can't swing estimates for a new model:
+// with n samples each estimation parameter is prior + (learned-prior)*n/minSamples.
+// SafeMaxCtx and the metadata are kept from learned. With minSamples <= 0 any
+// learned sample is trusted fully.
+func Blend(learned, prior Params, minSamples int) Params {
+	weight := 1.0
+	switch {
+	case learned.Samples <= 0:
+		weight = 0
+	case learned.Samples < minSamples:
+		weight = float64(learned.Samples) / float64(minSamples)
+	}
+	if weight >= 1 {
+		return learned
+	}
+	out := learned
+	out.TokensPerByte = prior.TokensPerByte + (learned.TokensPerByte-prior.TokensPerByte)*weight
+	out.FixedOverhead = prior.FixedOverhead + (learned.FixedOverhead-prior.FixedOverhead)*weight
+	out.PerMessageOverhead = prior.PerMessageOverhead + (learned.PerMessageOverhead-prior.PerMessageOverhead)*weight
+	return out
+}
+
 // Update refines model parameters using a new observation.
 //
 // The update is intentionally conservative; it clamps values to sane ranges
@@ -102,49 +315,76 @@ func (s *Store) Update(sample Sample, obs Observed) {
 
 	p, ok := s.models[sample.Model]
 	if !ok {
-		p = s.defaults
+		p = s.defaultsLocked(sample.Model)
+	}
+	o, _ := s.overrideLocked(sample.Model)
+	if o.Pinned {
+		p = o.apply(p)
+	}
+
+	// Image and code tokens are estimated at fixed rates, not learned.
+	fixed := float64(sample.ImageTokens + sample.CodeTokens)
+	fixedOverhead := p.FixedOverhead
+	if sample.CustomTemplate {
+		fixedOverhead = 0
 	}
 
 	// Predicted tokens (current params)
-	pred := p.FixedOverhead + p.PerMessageOverhead*float64(sample.MessageCount) + p.TokensPerByte*float64(sample.TextBytes) + float64(sample.ImageTokens)
+	pred := fixedOverhead + p.PerMessageOverhead*float64(sample.MessageCount) + p.TokensPerByte*float64(sample.TextBytes) + fixed
 	actual := float64(obs.PromptEvalCount)
+	alpha := s.alpha
+	if obs.Weight > 1 {
+		alpha = min(alpha*obs.Weight, 1)
+	}
 
 	// We do sequential EMA updates for each parameter.
 	// This isn't perfect statistical modeling, but it's stable and self-correcting.
 	//
 	// 1) Update TokensPerByte from the residual after subtracting overhead terms.
 	if sample.TextBytes > 0 {
-		residual := actual - float64(sample.ImageTokens) - p.FixedOverhead - p.PerMessageOverhead*float64(sample.MessageCount)
+		residual := actual - fixed - fixedOverhead - p.PerMessageOverhead*float64(sample.MessageCount)
 		cand := residual / float64(sample.TextBytes)
 		cand = clampFloat(cand, 0.05, 1.0) // [1 token/20B, 1 token/1B]
-		p.TokensPerByte = ema(p.TokensPerByte, cand, s.alpha)
+		p.TokensPerByte = ema(p.TokensPerByte, cand, alpha)
 	}
 
 	// 2) Update per-message overhead (only for chat-like requests)
 	if sample.MessageCount > 0 {
-		residual := actual - float64(sample.ImageTokens) - p.FixedOverhead - p.TokensPerByte*float64(sample.TextBytes)
+		residual := actual - fixed - fixedOverhead - p.TokensPerByte*float64(sample.TextBytes)
 		cand := residual / float64(sample.MessageCount)
 		cand = clampFloat(cand, 0, 64)
-		p.PerMessageOverhead = ema(p.PerMessageOverhead, cand, s.alpha)
+		p.PerMessageOverhead = ema(p.PerMessageOverhead, cand, alpha)
+	}
+
+	// 3) Update fixed overhead, unless a custom template replaced the one
+	// it stands for.
+	if !sample.CustomTemplate {
+		residual := actual - fixed - p.PerMessageOverhead*float64(sample.MessageCount) - p.TokensPerByte*float64(sample.TextBytes)
+		cand := clampFloat(residual, 0, 256)
+		p.FixedOverhead = ema(p.FixedOverhead, cand, alpha)
 	}
 
-	// 3) Update fixed overhead
-	residual := actual - float64(sample.ImageTokens) - p.PerMessageOverhead*float64(sample.MessageCount) - p.TokensPerByte*float64(sample.TextBytes)
-	cand := clampFloat(residual, 0, 256)
-	p.FixedOverhead = ema(p.FixedOverhead, cand, s.alpha)
+	// Pinned overheads stay put; the residual went into the other params.
+	if o.Pinned {
+		p = o.apply(p)
+	}
 
+	relErr := math.Abs(pred-actual) / actual
+	if p.Samples == 0 {
+		p.ErrorEMA = relErr
+	} else {
+		p.ErrorEMA = ema(p.ErrorEMA, relErr, alpha)
+	}
 	p.UpdatedAt = time.Now()
 	p.Samples++
 
-	// Extra safeguard: don't let prediction drift too far from actual in one update.
-	_ = pred
-
 	s.models[sample.Model] = p
 
 	// Persist in background-ish (still synchronous, but only when file is configured).
 	if s.file != "" {
 		_ = s.saveLocked()
 	}
+	s.scheduleBackendSaveLocked()
 }
 
 // RecordOOM reduces the safe max ctx for a model if we see an out-of-memory error.
@@ -157,7 +397,7 @@ func (s *Store) RecordOOM(model string, usedCtx int) {
 	defer s.mu.Unlock()
 	p, ok := s.models[model]
 	if !ok {
-		p = s.defaults
+		p = s.defaultsLocked(model)
 	}
 	if p.SafeMaxCtx == 0 || usedCtx < p.SafeMaxCtx {
 		p.SafeMaxCtx = usedCtx
@@ -166,9 +406,35 @@ func (s *Store) RecordOOM(model string, usedCtx int) {
 		if s.file != "" {
 			_ = s.saveLocked()
 		}
+		s.scheduleBackendSaveLocked()
 	}
 }
 
+// RecordEvalRate folds a completion's generation speed, tokens generated in
+// d of Ollama's eval time, into the model's EvalTokensPerSec. It leaves the
+// estimation parameters and Samples alone.
+func (s *Store) RecordEvalRate(model string, tokens int, d time.Duration) {
+	if model == "" || tokens <= 0 || d <= 0 {
+		return
+	}
+	rate := float64(tokens) / d.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.models[model]
+	if !ok {
+		p = s.defaultsLocked(model)
+	}
+	if p.EvalTokensPerSec > 0 {
+		rate = ema(p.EvalTokensPerSec, rate, s.alpha)
+	}
+	p.EvalTokensPerSec = rate
+	s.models[model] = p
+	if s.file != "" {
+		_ = s.saveLocked()
+	}
+	s.scheduleBackendSaveLocked()
+}
+
 // Load reads calibration parameters from disk.
 func (s *Store) Load() error {
 	if s.file == "" {
@@ -188,19 +454,113 @@ func (s *Store) Load() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for k, v := range data {
-		// Fill any zero-values with defaults (useful across version upgrades).
-		if v.TokensPerByte <= 0 {
-			v.TokensPerByte = s.defaults.TokensPerByte
+		s.models[k] = s.fillDefaults(v)
+	}
+	return nil
+}
+
+// Snapshot returns a copy of the per-model parameters, in the format of the
+// calibration file.
+func (s *Store) Snapshot() map[string]Params {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshotLocked()
+}
+
+// Import loads models, in the format of the calibration file, and persists
+// the result like Flush. With replace every learned model is dropped first;
+// otherwise imported models overwrite same-named ones and the rest are
+// kept. Nothing is changed if any model fails Validate. It returns how many
+// models the store holds afterwards.
+func (s *Store) Import(models map[string]Params, replace bool) (int, error) {
+	for model, p := range models {
+		if model == "" {
+			return 0, fmt.Errorf("%w: empty model name", ErrInvalidParams)
 		}
-		if v.FixedOverhead <= 0 {
-			v.FixedOverhead = s.defaults.FixedOverhead
+		if err := p.Validate(); err != nil {
+			return 0, fmt.Errorf("model %q: %w", model, err)
 		}
-		if v.PerMessageOverhead <= 0 {
-			v.PerMessageOverhead = s.defaults.PerMessageOverhead
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if replace {
+		s.models = make(map[string]Params, len(models))
+	}
+	now := time.Now()
+	for model, p := range models {
+		if p.UpdatedAt.IsZero() {
+			p.UpdatedAt = now
 		}
-		s.models[k] = v
+		s.models[model] = s.fillDefaults(p)
 	}
-	return nil
+	return len(s.models), s.persistLocked()
+}
+
+// fillDefaults fills any zero-values with defaults (useful across version upgrades).
+func (s *Store) fillDefaults(v Params) Params {
+	if v.TokensPerByte <= 0 {
+		v.TokensPerByte = s.defaults.TokensPerByte
+	}
+	if v.FixedOverhead <= 0 {
+		v.FixedOverhead = s.defaults.FixedOverhead
+	}
+	if v.PerMessageOverhead <= 0 {
+		v.PerMessageOverhead = s.defaults.PerMessageOverhead
+	}
+	return v
+}
+
+// Flush writes the current parameters to disk and the backend (cancelling any
+// pending debounced save) and returns how many models were saved. It is a
+// no-op without a calibration file or backend.
+func (s *Store) Flush() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == "" && s.backend == nil {
+		return 0, nil
+	}
+	return len(s.models), s.persistLocked()
+}
+
+// persistLocked writes the parameters to the file and backend now, cancelling
+// any pending debounced save. Caller must hold s.mu.
+func (s *Store) persistLocked() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	var errs []error
+	if s.file != "" {
+		errs = append(errs, s.saveLocked())
+	}
+	if s.backend != nil {
+		errs = append(errs, s.backend.SaveCalibration(s.snapshotLocked()))
+	}
+	return errors.Join(errs...)
+}
+
+// scheduleBackendSaveLocked arranges a debounced backend save. Caller must hold s.mu.
+func (s *Store) scheduleBackendSaveLocked() {
+	if s.backend == nil || s.timer != nil {
+		return
+	}
+	s.timer = time.AfterFunc(s.debounce, func() {
+		s.mu.Lock()
+		s.timer = nil
+		data := s.snapshotLocked()
+		s.mu.Unlock()
+		_ = s.backend.SaveCalibration(data)
+	})
+}
+
+// snapshotLocked copies the per-model parameters. Caller must hold s.mu.
+func (s *Store) snapshotLocked() map[string]Params {
+	out := make(map[string]Params, len(s.models))
+	for k, v := range s.models {
+		out[k] = v
+	}
+	return out
 }
 
 // saveLocked persists calibration data. Caller must hold s.mu.
@@ -0,0 +1,158 @@
+package calibration
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// UtilizationStat describes what the utilization learner knows about a model.
+type UtilizationStat struct {
+	Model   string  `json:"model"`
+	Samples int     `json:"samples"`
+	P95     float64 `json:"p95"`    // p95 of actual used tokens / requested tokens
+	Factor  float64 `json:"factor"` // multiplier applied to requested tokens (1 = no change)
+}
+
+// UtilizationLearner tracks how much of the requested context each model
+// actually uses (prompt_eval_count + eval_count over the headroom-inclusive
+// token estimate) and shrinks future requests toward the observed p95 plus a
+// margin. The factor never drops below floor, and models stay unchanged until
+// minSamples observations are in. It is safe for concurrent use; a nil
+// learner ignores observations and never adjusts.
+type UtilizationLearner struct {
+	mu         sync.Mutex
+	window     int
+	minSamples int
+	margin     float64
+	floor      float64
+	models     map[string]*utilizationState
+}
+
+type utilizationState struct {
+	ratios []float64 // circular buffer of the last window observations
+	next   int
+	count  int
+}
+
+// NewUtilizationLearner creates a learner over the last window observations per model.
+func NewUtilizationLearner(window, minSamples int, margin, floor float64) *UtilizationLearner {
+	if window < 1 {
+		window = 1
+	}
+	if minSamples > window {
+		minSamples = window
+	}
+	return &UtilizationLearner{
+		window:     window,
+		minSamples: minSamples,
+		margin:     margin,
+		floor:      floor,
+		models:     make(map[string]*utilizationState),
+	}
+}
+
+// Observe records one request's actual token use against the tokens that were
+// requested for it before any utilization adjustment.
+func (l *UtilizationLearner) Observe(model string, used, requested int) {
+	if l == nil || model == "" || used <= 0 || requested <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.models[model]
+	if !ok {
+		st = &utilizationState{ratios: make([]float64, l.window)}
+		l.models[model] = st
+	}
+	st.ratios[st.next] = float64(used) / float64(requested)
+	st.next = (st.next + 1) % l.window
+	if st.count < l.window {
+		st.count++
+	}
+}
+
+// Factor returns the multiplier to apply to model's requested tokens, in [floor, 1].
+func (l *UtilizationLearner) Factor(model string) float64 {
+	if l == nil {
+		return 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.models[model]
+	if !ok {
+		return 1
+	}
+	_, factor := l.factorLocked(st)
+	return factor
+}
+
+// Adjust scales requested by model's factor, never going below minTokens.
+func (l *UtilizationLearner) Adjust(model string, requested, minTokens int) int {
+	factor := l.Factor(model)
+	if factor >= 1 {
+		return requested
+	}
+	adjusted := int(math.Ceil(float64(requested) * factor))
+	if adjusted < minTokens {
+		adjusted = minTokens
+	}
+	if adjusted > requested {
+		adjusted = requested
+	}
+	return adjusted
+}
+
+// Stats returns the learner state for every observed model, sorted by model.
+func (l *UtilizationLearner) Stats() []UtilizationStat {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]UtilizationStat, 0, len(l.models))
+	for model, st := range l.models {
+		p95, factor := l.factorLocked(st)
+		out = append(out, UtilizationStat{Model: model, Samples: st.count, P95: p95, Factor: factor})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
+
+// Reset forgets the history for model, or for every model when model is empty.
+// It returns how many models were reset.
+func (l *UtilizationLearner) Reset(model string) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if model == "" {
+		n := len(l.models)
+		l.models = make(map[string]*utilizationState)
+		return n
+	}
+	if _, ok := l.models[model]; !ok {
+		return 0
+	}
+	delete(l.models, model)
+	return 1
+}
+
+// factorLocked returns the p95 utilization and resulting factor. Caller must hold l.mu.
+func (l *UtilizationLearner) factorLocked(st *utilizationState) (float64, float64) {
+	if st.count == 0 {
+		return 0, 1
+	}
+	sorted := append([]float64(nil), st.ratios[:st.count]...)
+	sort.Float64s(sorted)
+	idx := int(float64(len(sorted)) * 0.95)
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	p95 := sorted[idx]
+	if st.count < l.minSamples {
+		return p95, 1
+	}
+	return p95, clampFloat(p95+l.margin, l.floor, 1)
+}
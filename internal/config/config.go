@@ -2,10 +2,14 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"ollama-auto-ctx/internal/family"
 )
 
 // Mode controls which features are enabled.
@@ -40,6 +44,143 @@ const (
 	OverrideIfTooSmall OverridePolicy = "if_too_small"
 )
 
+// ImageValidation controls checking of request images before sizing.
+type ImageValidation string
+
+const (
+	ImageValidationOff    ImageValidation = "off"    // count every entry (default)
+	ImageValidationCount  ImageValidation = "count"  // count only non-empty, base64-decodable images
+	ImageValidationReject ImageValidation = "reject" // fail closed: reject requests with invalid images
+)
+
+// ImageBudgetPolicy controls requests whose image tokens alone exceed
+// IMAGE_BUDGET_FRACTION of the largest context the model may get.
+type ImageBudgetPolicy string
+
+const (
+	ImageBudgetOff    ImageBudgetPolicy = "off"    // size as usual, even if the images can't fit (default)
+	ImageBudgetDrop   ImageBudgetPolicy = "drop"   // drop the oldest images until the rest fit, with a warning
+	ImageBudgetReject ImageBudgetPolicy = "reject" // answer 400 instead of forwarding
+)
+
+// ShowTimeoutPolicy controls sizing when the /api/show lookup fails or times out.
+type ShowTimeoutPolicy string
+
+const (
+	ShowTimeoutConfigMax  ShowTimeoutPolicy = "config_max" // ignore the model max, use MAX_CTX only (default)
+	ShowTimeoutStale      ShowTimeoutPolicy = "stale"      // use an expired cached /api/show entry if present
+	ShowTimeoutRemembered ShowTimeoutPolicy = "remembered" // use the model max from the last successful lookup
+	ShowTimeoutFailFast   ShowTimeoutPolicy = "fail_fast"  // answer 503 instead of forwarding unsized
+)
+
+// UnstableCalibrationPolicy controls sizing for a model whose calibration
+// confidence is below UNSTABLE_CALIBRATION_CONFIDENCE.
+type UnstableCalibrationPolicy string
+
+const (
+	UnstableCalibrationOff      UnstableCalibrationPolicy = "off"      // trust the params as they are (default)
+	UnstableCalibrationMaxCtx   UnstableCalibrationPolicy = "max_ctx"  // give requests the largest safe context
+	UnstableCalibrationHeadroom UnstableCalibrationPolicy = "headroom" // multiply the headroom by UNSTABLE_CALIBRATION_HEADROOM_FACTOR
+)
+
+// UpstreamDialect controls how upstream response bodies are parsed for token
+// counts and timings.
+type UpstreamDialect string
+
+const (
+	UpstreamAuto   UpstreamDialect = "auto"   // by Content-Type: NDJSON, SSE or JSON; bytes only for anything else (default)
+	UpstreamOllama UpstreamDialect = "ollama" // streams are NDJSON whatever their Content-Type
+	UpstreamOpenAI UpstreamDialect = "openai" // streams are SSE whatever their Content-Type
+	UpstreamRaw    UpstreamDialect = "raw"    // never parse; bytes only
+)
+
+// ErrorResponseStyle controls the body of errors the proxy answers itself.
+type ErrorResponseStyle string
+
+const (
+	ErrorStyleOllamaJSON ErrorResponseStyle = "ollama-json" // {"error": ..., "reason": ...} like Ollama (default)
+	ErrorStylePlain      ErrorResponseStyle = "plain"       // text/plain message
+)
+
+// ModelConcurrencyPolicy controls requests over their model's MODEL_MAX_CONCURRENCY.
+type ModelConcurrencyPolicy string
+
+const (
+	ModelConcurrencyQueue  ModelConcurrencyPolicy = "queue"  // wait up to MODEL_CONCURRENCY_QUEUE_TIMEOUT for a slot, then answer 503 (default)
+	ModelConcurrencyReject ModelConcurrencyPolicy = "reject" // answer 503 right away
+)
+
+// SeedPolicy controls seed injection for requests that don't set options.seed.
+type SeedPolicy string
+
+const (
+	SeedNone  SeedPolicy = "none"  // forward requests unchanged (default)
+	SeedFixed SeedPolicy = "fixed" // inject SEED
+	SeedHash  SeedPolicy = "hash"  // inject a seed derived from the request body
+)
+
+// NoSupervisePolicy controls who may bypass supervision with the
+// X-AutoCtx-No-Supervise request header.
+type NoSupervisePolicy string
+
+const (
+	NoSuperviseAdmin NoSupervisePolicy = "admin" // requests with admin credentials; nobody when admin auth is off (default)
+	NoSuperviseAny   NoSupervisePolicy = "any"   // every request
+	NoSuperviseOff   NoSupervisePolicy = "off"   // the header is ignored
+)
+
+// IdleEvictMode controls when idle models are unloaded from Ollama.
+type IdleEvictMode string
+
+const (
+	IdleEvictPressure IdleEvictMode = "pressure" // only while loaded VRAM exceeds IDLE_EVICT_PRESSURE of IDLE_EVICT_VRAM_BYTES (default)
+	IdleEvictAlways   IdleEvictMode = "always"   // whenever a model is idle beyond IDLE_EVICT_AFTER
+)
+
+// KVCacheType is the element type of Ollama's KV cache (OLLAMA_KV_CACHE_TYPE),
+// which sets how much VRAM each token of context takes.
+type KVCacheType string
+
+const (
+	KVCacheF16  KVCacheType = "f16" // Ollama's default
+	KVCacheQ8_0 KVCacheType = "q8_0"
+	KVCacheQ4_0 KVCacheType = "q4_0"
+)
+
+// BytesPerElement returns the bytes one KV cache element takes, or 0 for an
+// unknown type.
+func (t KVCacheType) BytesPerElement() float64 {
+	switch t {
+	case KVCacheF16:
+		return 2
+	case KVCacheQ8_0:
+		return 1
+	case KVCacheQ4_0:
+		return 0.5
+	}
+	return 0
+}
+
+// CalibrationBackend controls where learned calibration parameters are persisted.
+type CalibrationBackend string
+
+const (
+	CalibrationBackendFile    CalibrationBackend = "file"    // JSON file at CALIBRATION_FILE (default)
+	CalibrationBackendStorage CalibrationBackend = "storage" // calibration table in the SQLite store
+)
+
+// CalibrationImportPolicy controls how POST /calibration/import combines
+// uploaded parameters with the learned ones.
+type CalibrationImportPolicy string
+
+const (
+	CalibrationImportMerge   CalibrationImportPolicy = "merge"   // uploaded models overwrite same-named ones, others are kept (default)
+	CalibrationImportReplace CalibrationImportPolicy = "replace" // uploaded models replace all learned ones
+)
+
+// DashboardSectionNames lists the dashboard sections that DASHBOARD_SECTIONS can enable.
+var DashboardSectionNames = []string{"overview", "requests", "metrics"}
+
 // Features derived from MODE - centralized feature gating.
 type Features struct {
 	Dashboard bool
@@ -53,20 +194,76 @@ type Features struct {
 
 // Config contains all runtime configuration for the proxy.
 type Config struct {
+	// ConfigFile is the CONFIG_FILE the settings not set in the environment
+	// were read from ("" for none).
+	ConfigFile string
+
 	// Core
 	Mode        Mode
 	ListenAddr  string
 	UpstreamURL string
 	LogLevel    string
+	// LogStreamEnabled exposes live logs at /autoctx/api/v1/logs (admin only).
+	LogStreamEnabled bool
+	// SlowRequestThreshold, if > 0, logs a warning with the sizing decision,
+	// token counts and phase timings of each chat/generate request that
+	// completed but took longer. ModelSlowRequestThreshold replaces it for
+	// models matching a lowercase name prefix (0 = never). With
+	// SlowRequestEventEnabled a slow_request event is published too.
+	SlowRequestThreshold      time.Duration
+	ModelSlowRequestThreshold map[string]time.Duration
+	SlowRequestEventEnabled   bool
+
+	// AdminListenAddr optionally moves the dashboard, API, events and metrics
+	// onto a separate listener (e.g. "127.0.0.1:11436"). Empty keeps them on ListenAddr.
+	AdminListenAddr string
+
+	// Admin authentication (dashboard, API, events, metrics). Either a Bearer token,
+	// basic-auth credentials, or both may be configured; empty disables auth.
+	AdminAuthToken     string
+	AdminBasicUser     string
+	AdminBasicPassword string
+	// ProxyAuthRequired applies the admin credentials to proxied Ollama endpoints too.
+	ProxyAuthRequired bool
 
 	// Storage (enabled when MODE != off unless explicitly disabled)
 	Storage        StorageType
 	StoragePath    string
 	StorageMaxRows int
+	// StorageBackupDir is where POST /maintenance/backup writes SQLite
+	// snapshots; empty means next to StoragePath.
+	StorageBackupDir string
+	// StorageSampleRate is the fraction of successful requests persisted;
+	// failed ones always are. 1 persists everything.
+	StorageSampleRate float64
+	// StorageBreakerThreshold is how many consecutive storage write errors
+	// pause writes for StorageBreakerCooldown between probes; 0 disables.
+	StorageBreakerThreshold int
+	StorageBreakerCooldown  time.Duration
+	// StoreRequestOptions records the client's options object (temperature,
+	// num_predict, seed, ...) with each request. Values of RedactOptionKeys are
+	// replaced with "[redacted]" before anything is stored.
+	StoreRequestOptions bool
+	RedactOptionKeys    []string
+	// StoreRequestFingerprint records a hash of each request's normalized
+	// body, never the body itself, for GET /fingerprints.
+	StoreRequestFingerprint bool
+	// SnapshotOptionKeys are the options kept in the ctx decision log and the
+	// stored options ("*" keeps all), less ExcludeOptionKeys.
+	SnapshotOptionKeys []string
+	ExcludeOptionKeys  []string
 
 	// Retry (enabled when MODE in retry/protect)
 	RetryMax       int
 	RetryBackoffMs int
+	// RetryMinEvalCount treats a successful non-streaming response with fewer
+	// output tokens (eval_count) as a failure worth retrying. 0 disables.
+	RetryMinEvalCount int
+	// RetryOnModelLoading retries Ollama's model-still-loading errors after
+	// RetryLoadingBackoffMs, for streaming requests too (the error comes
+	// before any output).
+	RetryOnModelLoading   bool
+	RetryLoadingBackoffMs int
 
 	// Protect (enabled only when MODE=protect)
 	TimeoutTTFBMs        int
@@ -79,44 +276,434 @@ type Config struct {
 	LoopMinOutputBytes   int
 	OutputLimitEnabled   bool
 	OutputLimitMaxTokens int
+	// TimeoutProfiles names sets of watchdog timeouts, keyed by lowercase
+	// profile name with "ttfb:D|stall:D|hard:D" values (see
+	// ParseTimeoutProfile). A request gets the profile it names with
+	// X-AutoCtx-Timeout-Profile, else the one ModelTimeoutProfile assigns its
+	// model by name prefix, else the TIMEOUT_*_MS values.
+	TimeoutProfiles     map[string]string
+	ModelTimeoutProfile map[string]string
+	// NoSupervisePolicy decides whether X-AutoCtx-No-Supervise may turn off
+	// the watchdog, loop detection and output limit for a request. An empty
+	// value behaves like NoSuperviseOff.
+	NoSupervisePolicy NoSupervisePolicy
+	// LoopRetryEnabled resends a retry-eligible non-streaming request whose
+	// buffered completion loops, up to LoopRetryMax times, with its seed
+	// changed and its temperature raised by LoopRetryTemperatureBump so the
+	// resend needn't produce the same text.
+	LoopRetryEnabled         bool
+	LoopRetryMax             int
+	LoopRetryTemperatureBump float64
+	// ModelFallback maps a lowercase model-name prefix to the model a
+	// retry-eligible non-streaming request is resent to, sized afresh, when
+	// Ollama fails the requested model.
+	ModelFallback map[string]string
 
 	// Context window selection (always on)
 	MinCtx   int
 	MaxCtx   int
 	Buckets  []int
 	Headroom float64
+	// ModelBuckets replaces Buckets for models matching a lowercase
+	// model-name prefix; it takes precedence over FamilyBuckets.
+	ModelBuckets map[string][]int
+	// ModelPreferredCtx is a context per model-name prefix that requests
+	// needing at most it, and at least 1-PreferredCtxTolerance of it, are
+	// given instead of their bucket, so the model's num_ctx stays put and
+	// Ollama doesn't reallocate the KV cache between requests.
+	ModelPreferredCtx     map[string]int
+	PreferredCtxTolerance float64
+	// MinAbsoluteHeadroom is the least headroom added in tokens, so small
+	// prompts get a real margin too (0 = multiplier only).
+	MinAbsoluteHeadroom int
+	// HeadroomConfidenceEnabled scales Headroom by the model's calibration
+	// confidence, from HeadroomLowConfidenceFactor for an uncalibrated model
+	// to HeadroomHighConfidenceFactor for a well-calibrated one.
+	HeadroomConfidenceEnabled    bool
+	HeadroomLowConfidenceFactor  float64
+	HeadroomHighConfidenceFactor float64
+	// UnstableCalibrationPolicy sizes requests conservatively while their
+	// model's calibration confidence is below UnstableCalibrationConfidence:
+	// while it is still learning a new model, or relearning one whose
+	// estimates went off.
+	UnstableCalibrationPolicy         UnstableCalibrationPolicy
+	UnstableCalibrationConfidence     float64
+	UnstableCalibrationHeadroomFactor float64
 
 	// Output token budgeting
 	DefaultOutputBudget        int
 	MaxOutputBudget            int
 	StructuredOverhead         int
 	DynamicDefaultOutputBudget bool
+	// StructuredOverheads overrides StructuredOverhead per model-name prefix
+	// (e.g. "qwen3=64"), since constrained-decoding cost varies by model.
+	StructuredOverheads map[string]int
+	// StructuredJSONBump is added for structured requests without num_predict,
+	// on top of the per-schema-property budget.
+	StructuredJSONBump int
+	// StopBudgetFactor scales the default output budget (no num_predict)
+	// when the client sent options.stop, since stop sequences tend to end
+	// generation early; NoStopThinkBudgetFactor scales it for thinking
+	// requests without any. 1 (or 0 in a hand-built Config) leaves it as is.
+	StopBudgetFactor        float64
+	NoStopThinkBudgetFactor float64
 
 	// Estimation overhead defaults
 	DefaultFixedOverheadTokens    float64
 	DefaultPerMessageOverhead     float64
 	DefaultTokensPerByte          float64
 	DefaultTokensPerImageFallback int
+	// ModelFixedOverheadTokens and ModelPerMessageOverhead replace the
+	// overhead defaults per model-name prefix (longest wins) as where
+	// calibration starts; for the prefixes in ModelOverheadPinned they
+	// replace the learned values too, for good.
+	ModelFixedOverheadTokens map[string]float64
+	ModelPerMessageOverhead  map[string]float64
+	ModelOverheadPinned      []string
+
+	// CodeTokensPerByte, when > 0, estimates prompt bytes detected as code
+	// (fenced blocks, symbol-dense paragraphs) at this rate instead of the
+	// model's TokensPerByte. CodeTokensPerByteOverrides sets it per
+	// model-name prefix (0 turns detection off for that model).
+	CodeTokensPerByte          float64
+	CodeTokensPerByteOverrides map[string]float64
+
+	// RoleWeights scales estimated tokens per message role, as
+	// "role:weight|..." (see ParseRoleWeights); empty weighs all
+	// roles equally. FamilyRoleWeights replaces it per family.
+	RoleWeights string
+
+	// TemplateReplacesOverhead leaves the model's fixed overhead out of the
+	// estimate for a generate request with its own template, whose bytes are
+	// counted instead, and keeps calibration from learning it from them.
+	TemplateReplacesOverhead bool
 
 	OverrideNumCtx OverridePolicy
+	// EndpointOverrideNumCtx replaces OverrideNumCtx per endpoint (chat or
+	// generate), e.g. always for batch generate jobs.
+	EndpointOverrideNumCtx map[string]OverridePolicy
+
+	ImageValidation ImageValidation
+
+	// ImageBudgetPolicy applies when image tokens exceed ImageBudgetFraction
+	// of the effective max context.
+	ImageBudgetPolicy   ImageBudgetPolicy
+	ImageBudgetFraction float64
+
+	// SeedPolicy injects options.seed into chat/generate requests without
+	// one, for reproducible output; Seed is the value for SeedFixed.
+	SeedPolicy SeedPolicy
+	Seed       int64
+
+	// ErrorResponseStyle formats rejections, auth failures and upstream
+	// errors. An empty value behaves like ErrorStyleOllamaJSON.
+	ErrorResponseStyle ErrorResponseStyle
+
+	// OptionsAllowlist, when non-empty, limits the options forwarded to Ollama
+	// to these keys (num_ctx is always kept). Empty forwards everything.
+	OptionsAllowlist []string
 
 	// Safety + performance
 	RequestBodyMaxBytes  int64
+	// Bodies above RequestBodyMaxBytes are estimated from their first
+	// EstimateSampleBytes (scaled by Content-Length) when enabled.
+	SampledEstimation    bool
+	EstimateSampleBytes  int64
+	// SniffJSONBody parses chat/generate bodies as JSON regardless of Content-Type
+	// when they start with '{' (for clients that send text/plain etc.).
+	SniffJSONBody        bool
+	// StrictJSONBody skips sizing for bodies with content after their JSON
+	// object instead of ignoring and dropping that content.
+	StrictJSONBody bool
+	// ExplainEnabled lets chat/generate clients add ?autoctx_explain=true to
+	// get the sizing decision back as JSON instead of a proxied response.
+	ExplainEnabled       bool
 	ResponseTapMaxBytes  int64
+	// ResponseTapScanOverflow scans non-stream JSON bodies larger than
+	// ResponseTapMaxBytes for token counts and durations instead of dropping them.
+	ResponseTapScanOverflow bool
+	// UpstreamDialect tells the response tap how the upstream frames its
+	// streams, for Ollama-compatible backends that label them differently.
+	UpstreamDialect UpstreamDialect
 	ShowCacheTTL         time.Duration
+	// ShowCacheStale serves expired /api/show entries while refreshing them in the background.
+	ShowCacheStale       bool
+	// ShowTimeout bounds the /api/show lookup made before sizing; on failure
+	// ShowTimeoutPolicy decides which model max (if any) is used.
+	ShowTimeout          time.Duration
+	ShowTimeoutPolicy    ShowTimeoutPolicy
+	// UnverifiedMaxCtx, when > 0, caps the context chosen for a model whose
+	// max couldn't be learned because /api/show failed, instead of allowing
+	// up to MaxCtx.
+	UnverifiedMaxCtx   int
 	CalibrationEnabled   bool
 	CalibrationFile      string
+	// CalibrationBackend selects file or storage persistence; storage writes
+	// are debounced by CalibrationSaveDebounce.
+	CalibrationBackend      CalibrationBackend
+	CalibrationSaveDebounce time.Duration
+	// CalibrationMinSamples is how many observations a model needs before its
+	// calibration is trusted fully; fewer are blended with the defaults by
+	// sample count (0 trusts the first observation).
+	CalibrationMinSamples int
+	// CalibrationImportPolicy is the default policy of POST /calibration/import.
+	CalibrationImportPolicy CalibrationImportPolicy
+	// TruncationCheckEnabled flags requests whose actual prompt_eval_count
+	// exceeds the chosen num_ctx minus the output budget as likely truncated.
+	// Their observation updates calibration with TruncationCalibrationWeight
+	// times the usual EMA weight, since the estimate clearly undersized them.
+	TruncationCheckEnabled      bool
+	TruncationCalibrationWeight float64
+
+	// Utilization learner: shrink each model's requested tokens toward the p95
+	// of actual/requested over the last UtilizationWindow requests plus
+	// UtilizationMargin, never below UtilizationFloor of the normal sizing.
+	UtilizationLearnerEnabled bool
+	UtilizationWindow         int
+	UtilizationMinSamples     int
+	UtilizationMargin         float64
+	UtilizationFloor          float64
+
+	// Bucket analyzer: track how far each model's needed tokens fall below
+	// the bucket they were sized to over the last BucketAnalyzerWindow
+	// requests and report, at /buckets, the buckets where a finer one (a
+	// multiple of BucketAnalyzerGranularity) would save the most. With
+	// BucketAutoInsertEnabled such a bucket is added to the model's ladder
+	// once BucketAnalyzerMinSamples requests would each save at least
+	// BucketAutoInsertMinSaving tokens in it, at most BucketAutoInsertMax
+	// per model.
+	BucketAnalyzerEnabled     bool
+	BucketAnalyzerWindow      int
+	BucketAnalyzerMinSamples  int
+	BucketAnalyzerGranularity int
+	BucketAutoInsertEnabled   bool
+	BucketAutoInsertMinSaving int
+	BucketAutoInsertMax       int
+
+	// Session budget escalation: requests sharing an X-AutoCtx-Session value
+	// get their output budget multiplied by SessionBudgetEscalationFactor each
+	// time SessionBudgetEscalationAfter responses in a row stop at
+	// done_reason=length, up to MaxOutputBudget. Sessions are forgotten after
+	// SessionBudgetTTL; at most SessionBudgetMaxSessions are tracked.
+	SessionBudgetEscalationEnabled bool
+	SessionBudgetEscalationAfter   int
+	SessionBudgetEscalationFactor  float64
+	SessionBudgetTTL               time.Duration
+	SessionBudgetMaxSessions       int
 	ProgressInterval     time.Duration
 	RecentBuffer         int
+	// OverflowLogPath, if set, is a JSONL file that non-success requests
+	// evicted from the RecentBuffer are appended to, for error history
+	// without storage. It is rotated to <path>.1 past OverflowLogMaxBytes.
+	OverflowLogPath     string
+	OverflowLogMaxBytes int64
 	HealthCheckInterval  time.Duration
 	HealthCheckTimeout   time.Duration
 
+	// Estimate divergence detection: flag a model when the rolling median of
+	// actual/estimated prompt tokens over DivergenceWindow requests leaves
+	// [1/(1+DivergenceThreshold), 1+DivergenceThreshold].
+	DivergenceDetectEnabled bool
+	DivergenceWindow        int
+	DivergenceThreshold     float64
+
+	// Event bus drop logging: warn once per EventDropLogInterval in which more
+	// than EventDropLogThreshold lifecycle events were dropped. 0 disables it;
+	// drops are always counted in metrics.
+	EventDropLogThreshold int
+	EventDropLogInterval  time.Duration
+
+	// Idle model eviction: unload models that served no proxied chat/generate
+	// request for IdleEvictAfter (keep_alive 0), checked every
+	// IdleEvictInterval. In pressure mode only while Ollama's loaded VRAM
+	// exceeds IdleEvictPressure of IdleEvictVRAMBytes.
+	IdleEvictEnabled   bool
+	IdleEvictAfter     time.Duration
+	IdleEvictInterval  time.Duration
+	IdleEvictMode      IdleEvictMode
+	IdleEvictVRAMBytes int64
+	IdleEvictPressure  float64
+
+	// ResidencyPollInterval, if > 0, polls Ollama's /api/ps this often to
+	// track which models are loaded and their VRAM; the idle evictor then
+	// reads that instead of polling itself.
+	ResidencyPollInterval time.Duration
+
+	// VRAMHeadroomBytes is VRAM the VRAM gate and VRAM context cap keep
+	// free on top of what a model needs.
+	VRAMHeadroomBytes int64
+
+	// VRAM gate: hold back requests for a model that isn't loaded while the
+	// VRAM left free, IdleEvictVRAMBytes less what the residency poll saw
+	// loaded, can't fit it plus VRAMHeadroomBytes; they wait up to
+	// VRAMGateQueueTimeout for room (0 doesn't wait) and are then answered
+	// 503. Needs ResidencyPollInterval and IdleEvictVRAMBytes.
+	VRAMGateEnabled      bool
+	VRAMGateQueueTimeout time.Duration
+
+	// VRAMCtxCapEnabled caps each model's context at what fits in the VRAM
+	// left free beside the other loaded models: its weights plus the KV
+	// cache, KVCacheType elements per token. Needs ResidencyPollInterval and
+	// IdleEvictVRAMBytes.
+	VRAMCtxCapEnabled bool
+	KVCacheType       KVCacheType
+
+	// Shadow mode: mirror ShadowSampleRate of non-streaming chat/generate
+	// requests to ShadowUpstreamURL in the background and store how it
+	// answered next to the primary result. The client always gets the primary
+	// response; at most ShadowMaxInFlight mirrors run at once (extras are skipped).
+	ShadowEnabled     bool
+	ShadowUpstreamURL string
+	ShadowSampleRate  float64
+	ShadowTimeout     time.Duration
+	ShadowMaxInFlight int
+
+	// Outcome hooks: HookCommands maps a request outcome (lowercase event
+	// type, e.g. "loop_detected", from HOOK_CMD_<OUTCOME>) to a shell
+	// command. Each outcome runs at most once per HookCooldown and
+	// HookMaxPerHour times an hour, each run bounded by HookTimeout.
+	HookCommands   map[string]string
+	HookCooldown   time.Duration
+	HookMaxPerHour int
+	HookTimeout    time.Duration
+
+	// Latency SLO: SLOTarget of completed requests within SLOLatencyThreshold,
+	// measured over SLOWindow of stored requests. A zero threshold disables it.
+	SLOLatencyThreshold time.Duration
+	SLOTarget           float64
+	SLOWindow           time.Duration
+
+	// Metric snapshots: every MetricsSnapshotInterval the aggregate metrics
+	// are copied into storage and kept for MetricsSnapshotRetention. A zero
+	// interval disables them.
+	MetricsSnapshotInterval  time.Duration
+	MetricsSnapshotRetention time.Duration
+
+	// MetricsInFlightPeakEnabled adds the peak and time-weighted average
+	// in-flight count since the last /metrics scrape beside the instantaneous
+	// oac_requests_in_flight.
+	MetricsInFlightPeakEnabled bool
+
+	// DecisionCorpusPath, if set, is a JSONL file the features and decision
+	// of DecisionCorpusSampleRate of chat/generate requests are appended to,
+	// for replaying real traffic shapes through /estimate. It is rotated to
+	// <path>.1 past DecisionCorpusMaxBytes.
+	DecisionCorpusPath       string
+	DecisionCorpusMaxBytes   int64
+	DecisionCorpusSampleRate float64
+
+	// IdempotencyTTL, if > 0, replays the response of a non-streaming
+	// chat/generate request to repeats with the same Idempotency-Key and body
+	// for this long. At most IdempotencyCacheSize responses of up to
+	// IdempotencyMaxBodyBytes each are kept.
+	IdempotencyTTL          time.Duration
+	IdempotencyCacheSize    int
+	IdempotencyMaxBodyBytes int64
+
+	// ResponseCacheTTL, if > 0, answers a non-streaming chat/generate request
+	// whose output is deterministic (options.temperature 0 or a fixed
+	// options.seed) with the response to an identical earlier one, for this
+	// long. At most ResponseCacheSize responses of up to
+	// ResponseCacheMaxBodyBytes each are kept.
+	ResponseCacheTTL          time.Duration
+	ResponseCacheSize         int
+	ResponseCacheMaxBodyBytes int64
+
+	// Dashboard polling and layout, served to the dashboard via /ui-config.
+	DashboardOverviewRefresh time.Duration
+	DashboardRequestsRefresh time.Duration
+	DashboardHealthRefresh   time.Duration
+	DashboardRequestsLimit   int
+	DashboardSections        []string
+
 	// HTTP
 	CORSAllowOrigin string
 	FlushInterval   time.Duration
+	// StreamCoalesceBytes, if > 0, merges small NDJSON/SSE chunks from the
+	// upstream into reads of up to this many bytes, holding each for at most
+	// StreamCoalesceMaxLatency, so fast streams are written and flushed less often.
+	StreamCoalesceBytes      int
+	StreamCoalesceMaxLatency time.Duration
+	// ProgressSidebandEnabled injects a {"x_autoctx_progress": {...}} line
+	// into streamed NDJSON responses at most every ProgressSidebandInterval,
+	// with the tracker's estimated output tokens and ETA. It changes the
+	// stream, so it is off by default.
+	ProgressSidebandEnabled  bool
+	ProgressSidebandInterval time.Duration
+	// TokenTrailersEnabled sends a streamed chat/generate response's prompt
+	// and completion token counts as HTTP trailers once it ends.
+	TokenTrailersEnabled bool
+	// ShutdownGracePeriod bounds draining connections plus the final storage
+	// and calibration flush on SIGINT/SIGTERM.
+	ShutdownGracePeriod time.Duration
+	// GlobalRequestTimeout, if > 0, bounds every chat/generate request
+	// whatever the MODE or X-AutoCtx-No-Supervise say; the watchdog's
+	// timeouts, when on, sit inside it.
+	GlobalRequestTimeout time.Duration
 
 	// System prompt manipulation
 	StripSystemPromptText string
+
+	// ThinkDefaults maps lowercase model-name prefixes to the think verdict used
+	// when neither the client nor a __think= directive sets one (e.g. qwen3=false,gpt-oss=low).
+	ThinkDefaults map[string]string
+
+	// NumPredictCeilings caps a client's options.num_predict per model-name
+	// prefix (e.g. "qwen3=2048"), separately from the global MaxOutputBudget.
+	NumPredictCeilings map[string]int
+
+	// MaxPromptTokens, if > 0, answers 413 to chat/generate requests whose
+	// estimated prompt exceeds it, instead of sizing them. ModelMaxPromptTokens
+	// replaces it for models matching a lowercase name prefix (0 = no limit).
+	MaxPromptTokens      int
+	ModelMaxPromptTokens map[string]int
+
+	// TagQuotas caps what requests carrying a tag may use per TagQuotaWindow,
+	// keyed by "key=value" tag with "tokens:N|requests:N" values (see
+	// ParseTagQuota). Requests over a quota are answered 429.
+	TagQuotas      map[string]string
+	TagQuotaWindow time.Duration
+
+	// ModelInputTokenPrices and ModelOutputTokenPrices price a million
+	// prompt and completion tokens per model-name prefix, for the cost
+	// stored with each request (see TokenPricesFor). Unpriced models cost 0.
+	ModelInputTokenPrices  map[string]float64
+	ModelOutputTokenPrices map[string]float64
+
+	// ModelMaxConcurrency caps concurrent chat/generate requests per model,
+	// matched by model-name prefix (e.g. "llama3:70b=1"). Requests over the
+	// cap are handled per ModelConcurrencyPolicy.
+	ModelMaxConcurrency          map[string]int
+	ModelConcurrencyPolicy       ModelConcurrencyPolicy
+	ModelConcurrencyQueueTimeout time.Duration
+
+	// PromptScanFile lists regexp rules matched against chat/generate prompt
+	// text, each logging, tagging or rejecting matching requests (see
+	// LoadPromptScanRules); Load reads it into PromptScanRules. Only the first
+	// PromptScanMaxBytes of text, newest first, are scanned.
+	PromptScanFile     string
+	PromptScanRules    []PromptScanRule
+	PromptScanMaxBytes int
+
+	// LoadCoalesceEnabled holds chat/generate requests for a model that
+	// another request is loading, until that request's response starts or
+	// LoadCoalesceTimeout passes, so Ollama loads the model once rather than
+	// once per request. A model counts as loaded while a response for it
+	// started within LoadCoalesceWarmTTL.
+	LoadCoalesceEnabled bool
+	LoadCoalesceTimeout time.Duration
+	LoadCoalesceWarmTTL time.Duration
+
+	// Model families. ModelFamilyRules adds name-pattern -> family rules on top
+	// of the built-ins (see internal/family); the Family* maps tune behavior per
+	// family and are keyed by family name.
+	ModelFamilyRules          map[string]string
+	FamilyTokensPerByte       map[string]float64 // default tokens/byte before calibration has samples
+	FamilyTokensPerImage      map[string]int     // used when /api/show doesn't report image tokens
+	FamilyLoopRepeatThreshold map[string]int     // overrides LOOP_REPEAT_THRESHOLD
+	FamilyThinkEncodings      map[string]string  // none|bool|string|enum:a|b, overrides the built-in think encoding
+	FamilyBuckets             map[string][]int   // replaces BUCKETS unless MODEL_BUCKETS matches
+	FamilyRoleWeights         map[string]string  // role:weight|..., replaces ROLE_WEIGHTS
 }
 
 // Features returns the feature flags derived from the current MODE.
@@ -145,8 +732,189 @@ func (c *Config) Features() Features {
 	return f
 }
 
-// Load parses env vars and returns a validated Config.
+// AdminAuthEnabled reports whether admin routes require credentials.
+func (c *Config) AdminAuthEnabled() bool {
+	return c.AdminAuthToken != "" || c.AdminBasicUser != ""
+}
+
+// ThinkDefaultFor returns the configured think default for model, matching the
+// longest model-name prefix. It returns "" when none applies.
+func (c *Config) ThinkDefaultFor(model string) string {
+	model = strings.ToLower(model)
+	best, verdict := -1, ""
+	for prefix, v := range c.ThinkDefaults {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, verdict = len(prefix), v
+		}
+	}
+	return verdict
+}
+
+// ThinkEncodingFor returns how f's think field is validated and encoded:
+// the FAMILY_THINK_ENCODINGS entry for f, or the family's built-in encoding.
+func (c *Config) ThinkEncodingFor(f family.Family) family.ThinkEncoding {
+	if v, ok := c.FamilyThinkEncodings[string(f)]; ok && f != family.Unknown {
+		if enc, err := family.ParseThinkEncoding(v); err == nil {
+			return enc
+		}
+	}
+	return f.ThinkEncoding()
+}
+
+// RoleWeightsFor returns the per-role estimation weights for f: the
+// FAMILY_ROLE_WEIGHTS entry for f, or ROLE_WEIGHTS. Unparseable values
+// (rejected by Validate) weigh all roles equally.
+func (c *Config) RoleWeightsFor(f family.Family) RoleWeights {
+	raw := c.RoleWeights
+	if v, ok := c.FamilyRoleWeights[string(f)]; ok && f != family.Unknown {
+		raw = v
+	}
+	w, _ := ParseRoleWeights(raw)
+	return w
+}
+
+// OverridePolicyFor returns the num_ctx override policy for endpoint (chat or
+// generate): its ENDPOINT_OVERRIDE_NUM_CTX entry, or OVERRIDE_NUM_CTX.
+func (c *Config) OverridePolicyFor(endpoint string) OverridePolicy {
+	if p, ok := c.EndpointOverrideNumCtx[endpoint]; ok {
+		return p
+	}
+	return c.OverrideNumCtx
+}
+
+// NumPredictCeilingFor returns the num_predict ceiling for model, matching the
+// longest model-name prefix. It returns 0 (no ceiling) when none applies.
+func (c *Config) NumPredictCeilingFor(model string) int {
+	ceiling, _ := longestPrefixValue(c.NumPredictCeilings, model)
+	return ceiling
+}
+
+// FallbackModelFor returns the MODEL_FALLBACK model for model, matching the
+// longest model-name prefix, or "" when none applies.
+func (c *Config) FallbackModelFor(model string) string {
+	fallback, _ := longestPrefixValue(c.ModelFallback, model)
+	return fallback
+}
+
+// MaxPromptTokensFor returns the prompt token limit for model, matching the
+// longest model-name prefix of MODEL_MAX_PROMPT_TOKENS, else MAX_PROMPT_TOKENS.
+// It returns 0 when there is no limit.
+func (c *Config) MaxPromptTokensFor(model string) int {
+	if limit, ok := longestPrefixValue(c.ModelMaxPromptTokens, model); ok {
+		return limit
+	}
+	return c.MaxPromptTokens
+}
+
+// SlowRequestThresholdFor returns the duration past which a completed request
+// for model is logged as slow, matching the longest model-name prefix of
+// MODEL_SLOW_REQUEST_THRESHOLD, else SLOW_REQUEST_THRESHOLD. It returns 0
+// when slow requests aren't logged.
+func (c *Config) SlowRequestThresholdFor(model string) time.Duration {
+	if d, ok := longestPrefixValue(c.ModelSlowRequestThreshold, model); ok {
+		return d
+	}
+	return c.SlowRequestThreshold
+}
+
+// TokenPricesFor returns the price of a million prompt and completion tokens
+// of model, each matching the longest model-name prefix of
+// MODEL_INPUT_TOKEN_PRICES and MODEL_OUTPUT_TOKEN_PRICES (0 when none does).
+func (c *Config) TokenPricesFor(model string) (input, output float64) {
+	input, _ = longestPrefixValue(c.ModelInputTokenPrices, model)
+	output, _ = longestPrefixValue(c.ModelOutputTokenPrices, model)
+	return input, output
+}
+
+// CostEnabled reports whether any model has a token price.
+func (c *Config) CostEnabled() bool {
+	return len(c.ModelInputTokenPrices) > 0 || len(c.ModelOutputTokenPrices) > 0
+}
+
+// ModelMaxConcurrencyFor returns the concurrency cap for model, matching the
+// longest model-name prefix. It returns 0 (no cap) when none applies.
+func (c *Config) ModelMaxConcurrencyFor(model string) int {
+	limit, _ := longestPrefixValue(c.ModelMaxConcurrency, model)
+	return limit
+}
+
+// StructuredOverheadFor returns the structured-format overhead for model: the
+// STRUCTURED_OVERHEADS entry with the longest matching prefix, or StructuredOverhead.
+func (c *Config) StructuredOverheadFor(model string) int {
+	if v, ok := longestPrefixValue(c.StructuredOverheads, model); ok {
+		return v
+	}
+	return c.StructuredOverhead
+}
+
+// CodeTokensPerByteFor returns the tokens-per-byte for code in model's
+// prompts: the MODEL_CODE_TOKENS_PER_BYTE entry with the longest matching
+// prefix, or CodeTokensPerByte. 0 means code isn't estimated separately.
+func (c *Config) CodeTokensPerByteFor(model string) float64 {
+	if v, ok := longestPrefixValue(c.CodeTokensPerByteOverrides, model); ok {
+		return v
+	}
+	return c.CodeTokensPerByte
+}
+
+// Sources of the ladder returned by BucketsFor.
+const (
+	BucketsSourceModel  = "model"
+	BucketsSourceFamily = "family"
+	BucketsSourceGlobal = "global"
+)
+
+// BucketsFor returns the context bucket ladder for model, classified as f,
+// and which setting it came from: the MODEL_BUCKETS entry with the longest
+// matching prefix, else the FAMILY_BUCKETS entry for f, else BUCKETS.
+func (c *Config) BucketsFor(model string, f family.Family) ([]int, string) {
+	if v, ok := longestPrefixValue(c.ModelBuckets, model); ok {
+		return v, BucketsSourceModel
+	}
+	if v, ok := c.FamilyBuckets[string(f)]; ok && f != family.Unknown {
+		return v, BucketsSourceFamily
+	}
+	return c.Buckets, BucketsSourceGlobal
+}
+
+// PreferredCtxFor returns the MODEL_PREFERRED_CTX context for model,
+// matching the longest model-name prefix, or 0 when none applies.
+func (c *Config) PreferredCtxFor(model string) int {
+	ctx, _ := longestPrefixValue(c.ModelPreferredCtx, model)
+	return ctx
+}
+
+// longestPrefixValue returns the value for the longest lowercase key that
+// prefixes model.
+func longestPrefixValue[V any](m map[string]V, model string) (V, bool) {
+	model = strings.ToLower(model)
+	best := -1
+	var value V
+	for prefix, v := range m {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, value = len(prefix), v
+		}
+	}
+	return value, best >= 0
+}
+
+// maxLoopRetries bounds LOOP_RETRY_MAX: a model that loops on every resend
+// mustn't multiply a request's cost without end.
+const maxLoopRetries = 3
+
+// Load parses env vars, falling back to the settings of CONFIG_FILE if
+// set, and returns a validated Config.
 func Load() (Config, error) {
+	configFile := strings.TrimSpace(os.Getenv("CONFIG_FILE"))
+	if configFile != "" {
+		f, err := loadConfigFile(configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		file = f
+		defer func() { file = nil }()
+	}
+
 	// Parse MODE first as it affects defaults
 	mode := Mode(getEnvString("MODE", string(ModeRetry)))
 
@@ -160,20 +928,50 @@ func Load() (Config, error) {
 
 	cfg := Config{
 		// Core
+		ConfigFile:  configFile,
 		Mode:        mode,
 		ListenAddr:  getEnvString("LISTEN_ADDR", ":11435"),
 		UpstreamURL: getEnvString("UPSTREAM_URL", "http://127.0.0.1:11434"),
 		LogLevel:    getEnvString("LOG_LEVEL", "info"),
 
+		LogStreamEnabled: getEnvBool("LOG_STREAM_ENABLED", false),
+
+		SlowRequestThreshold:      getEnvDuration("SLOW_REQUEST_THRESHOLD", 0),
+		ModelSlowRequestThreshold: getEnvDurationMap("MODEL_SLOW_REQUEST_THRESHOLD"),
+		SlowRequestEventEnabled:   getEnvBool("SLOW_REQUEST_EVENT_ENABLED", false),
+
+		AdminListenAddr: getEnvString("ADMIN_LISTEN_ADDR", ""),
+
+		AdminAuthToken:     getEnvString("ADMIN_AUTH_TOKEN", ""),
+		AdminBasicUser:     getEnvString("ADMIN_BASIC_AUTH_USER", ""),
+		AdminBasicPassword: getEnvString("ADMIN_BASIC_AUTH_PASSWORD", ""),
+		ProxyAuthRequired:  getEnvBool("PROXY_AUTH_REQUIRED", false),
+
 		// Storage
 		Storage:        StorageType(getEnvString("STORAGE", string(storageDefault))),
 		StoragePath:    getEnvString("STORAGE_PATH", "/data/oac.sqlite"),
 		StorageMaxRows: getEnvInt("STORAGE_MAX_ROWS", 3000),
 
+		StorageBackupDir:        getEnvString("STORAGE_BACKUP_DIR", ""),
+		StorageSampleRate:       getEnvFloat("STORAGE_SAMPLE_RATE", 1),
+		StorageBreakerThreshold: getEnvInt("STORAGE_BREAKER_THRESHOLD", 5),
+		StorageBreakerCooldown:  getEnvDuration("STORAGE_BREAKER_COOLDOWN", 30*time.Second),
+		StoreRequestOptions:     getEnvBool("STORE_REQUEST_OPTIONS", true),
+		RedactOptionKeys:        getEnvStringList("REDACT_OPTION_KEYS", []string{"stop"}),
+		SnapshotOptionKeys:      getEnvStringList("SNAPSHOT_OPTION_KEYS", []string{"num_ctx", "num_predict"}),
+		ExcludeOptionKeys:       getEnvStringList("EXCLUDE_OPTION_KEYS", nil),
+
+		StoreRequestFingerprint: getEnvBool("STORE_REQUEST_FINGERPRINT", false),
+
 		// Retry
 		RetryMax:       getEnvInt("RETRY_MAX", 2),
 		RetryBackoffMs: getEnvInt("RETRY_BACKOFF_MS", 1000),
 
+		RetryMinEvalCount: getEnvInt("RETRY_MIN_EVAL_COUNT", 1),
+
+		RetryOnModelLoading:   getEnvBool("RETRY_ON_MODEL_LOADING", true),
+		RetryLoadingBackoffMs: getEnvInt("RETRY_LOADING_BACKOFF_MS", 500),
+
 		// Protect
 		TimeoutTTFBMs:        getEnvInt("TIMEOUT_TTFB_MS", 15000),
 		TimeoutStallMs:       getEnvInt("TIMEOUT_STALL_MS", 30000),
@@ -185,6 +983,14 @@ func Load() (Config, error) {
 		LoopMinOutputBytes:   getEnvInt("LOOP_MIN_OUTPUT_BYTES", 1024),
 		OutputLimitEnabled:   getEnvBool("OUTPUT_LIMIT_ENABLED", true),
 		OutputLimitMaxTokens: getEnvInt("OUTPUT_LIMIT_MAX_TOKENS", 4096),
+		NoSupervisePolicy:    NoSupervisePolicy(getEnvString("NO_SUPERVISE_POLICY", string(NoSuperviseAdmin))),
+		TimeoutProfiles:      getEnvStringMap("TIMEOUT_PROFILES", nil),
+		ModelTimeoutProfile:  getEnvStringMap("MODEL_TIMEOUT_PROFILE", nil),
+
+		LoopRetryEnabled:         getEnvBool("LOOP_RETRY_ENABLED", false),
+		LoopRetryMax:             getEnvInt("LOOP_RETRY_MAX", 1),
+		LoopRetryTemperatureBump: getEnvFloat("LOOP_RETRY_TEMPERATURE_BUMP", 0.1),
+		ModelFallback:            getEnvStringMap("MODEL_FALLBACK", nil),
 
 		// Context window
 		MinCtx:   getEnvInt("MIN_CTX", 1024),
@@ -192,39 +998,239 @@ func Load() (Config, error) {
 		Buckets:  getEnvIntList("BUCKETS", []int{1024, 2048, 4096, 8192, 9216, 10240, 11264, 12288, 13312, 14336, 15360, 16384, 20480, 24576, 28672, 32768, 36864, 40960, 45056, 49152, 53248, 57344, 61440, 65536, 69632, 73728, 77824, 81920, 86016, 90112, 94208, 98304, 102400}),
 		Headroom: getEnvFloat("HEADROOM", 1.25),
 
+		ModelBuckets:        getEnvIntListMap("MODEL_BUCKETS"),
+
+		ModelPreferredCtx:     getEnvIntMap("MODEL_PREFERRED_CTX"),
+		PreferredCtxTolerance: getEnvFloat("PREFERRED_CTX_TOLERANCE", 0.5),
+
+		MinAbsoluteHeadroom: getEnvInt("MIN_ABSOLUTE_HEADROOM_TOKENS", 0),
+
+		HeadroomConfidenceEnabled:    getEnvBool("HEADROOM_CONFIDENCE_ENABLED", false),
+		HeadroomLowConfidenceFactor:  getEnvFloat("HEADROOM_LOW_CONFIDENCE_FACTOR", 1.2),
+		HeadroomHighConfidenceFactor: getEnvFloat("HEADROOM_HIGH_CONFIDENCE_FACTOR", 0.9),
+
+		UnstableCalibrationPolicy:         UnstableCalibrationPolicy(getEnvString("UNSTABLE_CALIBRATION_POLICY", string(UnstableCalibrationOff))),
+		UnstableCalibrationConfidence:     getEnvFloat("UNSTABLE_CALIBRATION_CONFIDENCE", 0.5),
+		UnstableCalibrationHeadroomFactor: getEnvFloat("UNSTABLE_CALIBRATION_HEADROOM_FACTOR", 1.5),
+
 		// Output budgeting
 		DefaultOutputBudget:        getEnvInt("DEFAULT_OUTPUT_BUDGET", 1024),
 		MaxOutputBudget:            getEnvInt("MAX_OUTPUT_BUDGET", 10240),
 		StructuredOverhead:         getEnvInt("STRUCTURED_OVERHEAD", 128),
+		StructuredOverheads:        getEnvIntMap("STRUCTURED_OVERHEADS"),
+		StructuredJSONBump:         getEnvInt("STRUCTURED_JSON_BUMP", 256),
 		DynamicDefaultOutputBudget: getEnvBool("DYNAMIC_DEFAULT_OUTPUT_BUDGET", false),
+		StopBudgetFactor:           getEnvFloat("STOP_BUDGET_FACTOR", 1),
+		NoStopThinkBudgetFactor:    getEnvFloat("NO_STOP_THINK_BUDGET_FACTOR", 1),
 
 		// Estimation defaults
 		DefaultFixedOverheadTokens:    getEnvFloat("DEFAULT_FIXED_OVERHEAD_TOKENS", 32),
 		DefaultPerMessageOverhead:     getEnvFloat("DEFAULT_PER_MESSAGE_OVERHEAD_TOKENS", 8),
 		DefaultTokensPerByte:          getEnvFloat("DEFAULT_TOKENS_PER_BYTE", 0.25),
 		DefaultTokensPerImageFallback: getEnvInt("DEFAULT_TOKENS_PER_IMAGE", 768),
+		ModelFixedOverheadTokens:      getEnvFloatMap("MODEL_FIXED_OVERHEAD_TOKENS"),
+		ModelPerMessageOverhead:       getEnvFloatMap("MODEL_PER_MESSAGE_OVERHEAD_TOKENS"),
+		ModelOverheadPinned:           getEnvStringList("MODEL_OVERHEAD_PINNED", nil),
+
+		CodeTokensPerByte:          getEnvFloat("CODE_TOKENS_PER_BYTE", 0),
+		CodeTokensPerByteOverrides: getEnvFloatMap("MODEL_CODE_TOKENS_PER_BYTE"),
+
+		RoleWeights: getEnvString("ROLE_WEIGHTS", ""),
+
+		TemplateReplacesOverhead: getEnvBool("TEMPLATE_REPLACES_OVERHEAD", true),
 
 		OverrideNumCtx: OverridePolicy(getEnvString("OVERRIDE_NUM_CTX", string(OverrideIfTooSmall))),
+		EndpointOverrideNumCtx: getEnvOverridePolicyMap("ENDPOINT_OVERRIDE_NUM_CTX"),
+
+		ImageValidation: ImageValidation(getEnvString("IMAGE_VALIDATION", string(ImageValidationOff))),
+
+		ImageBudgetPolicy:   ImageBudgetPolicy(getEnvString("IMAGE_BUDGET_POLICY", string(ImageBudgetOff))),
+		ImageBudgetFraction: getEnvFloat("IMAGE_BUDGET_FRACTION", 0.75),
+		ErrorResponseStyle: ErrorResponseStyle(getEnvString("ERROR_RESPONSE_STYLE", string(ErrorStyleOllamaJSON))),
+		SeedPolicy:         SeedPolicy(getEnvString("SEED_POLICY", string(SeedNone))),
+		Seed:               getEnvInt64("SEED", 42),
+
+		OptionsAllowlist: getEnvStringList("OPTIONS_ALLOWLIST", nil),
 
 		// Safety + performance
 		RequestBodyMaxBytes: getEnvInt64("REQUEST_BODY_MAX_BYTES", 10*1024*1024),
+		SampledEstimation:   getEnvBool("SAMPLED_ESTIMATION", false),
+		EstimateSampleBytes: getEnvInt64("ESTIMATE_SAMPLE_BYTES", 1024*1024),
+		SniffJSONBody:       getEnvBool("SNIFF_JSON_BODY", false),
+		StrictJSONBody:      getEnvBool("STRICT_JSON_BODY", false),
+		ExplainEnabled:      getEnvBool("EXPLAIN_ENABLED", false),
 		ResponseTapMaxBytes: getEnvInt64("RESPONSE_TAP_MAX_BYTES", 5*1024*1024),
+		ResponseTapScanOverflow: getEnvBool("RESPONSE_TAP_SCAN_OVERFLOW", true),
+		UpstreamDialect:     UpstreamDialect(getEnvString("UPSTREAM_DIALECT", string(UpstreamAuto))),
 		ShowCacheTTL:        getEnvDuration("SHOW_CACHE_TTL", 5*time.Minute),
+		ShowCacheStale:      getEnvBool("SHOW_CACHE_STALE_WHILE_REVALIDATE", true),
+		ShowTimeout:         getEnvDuration("SHOW_TIMEOUT", 5*time.Second),
+		ShowTimeoutPolicy:   ShowTimeoutPolicy(getEnvString("SHOW_TIMEOUT_POLICY", string(ShowTimeoutConfigMax))),
+		UnverifiedMaxCtx:    getEnvInt("UNVERIFIED_MAX_CTX", 0),
 		CalibrationEnabled:  getEnvBool("CALIBRATION_ENABLED", true),
 		CalibrationFile:     getEnvString("CALIBRATION_FILE", ""),
+		CalibrationBackend:      CalibrationBackend(getEnvString("CALIBRATION_BACKEND", string(CalibrationBackendFile))),
+		CalibrationSaveDebounce: getEnvDuration("CALIBRATION_SAVE_DEBOUNCE", 5*time.Second),
+		CalibrationMinSamples:   getEnvInt("CALIBRATION_MIN_SAMPLES", 3),
+		CalibrationImportPolicy: CalibrationImportPolicy(getEnvString("CALIBRATION_IMPORT_POLICY", string(CalibrationImportMerge))),
+		TruncationCheckEnabled:      getEnvBool("TRUNCATION_CHECK_ENABLED", true),
+		TruncationCalibrationWeight: getEnvFloat("TRUNCATION_CALIBRATION_WEIGHT", 3),
+
+		UtilizationLearnerEnabled: getEnvBool("UTILIZATION_LEARNER_ENABLED", false),
+		UtilizationWindow:         getEnvInt("UTILIZATION_WINDOW", 100),
+		UtilizationMinSamples:     getEnvInt("UTILIZATION_MIN_SAMPLES", 20),
+		UtilizationMargin:         getEnvFloat("UTILIZATION_MARGIN", 0.10),
+		UtilizationFloor:          getEnvFloat("UTILIZATION_FLOOR", 0.5),
+
+		BucketAnalyzerEnabled:     getEnvBool("BUCKET_ANALYZER_ENABLED", false),
+		BucketAnalyzerWindow:      getEnvInt("BUCKET_ANALYZER_WINDOW", 500),
+		BucketAnalyzerMinSamples:  getEnvInt("BUCKET_ANALYZER_MIN_SAMPLES", 50),
+		BucketAnalyzerGranularity: getEnvInt("BUCKET_ANALYZER_GRANULARITY", 512),
+		BucketAutoInsertEnabled:   getEnvBool("BUCKET_AUTO_INSERT_ENABLED", false),
+		BucketAutoInsertMinSaving: getEnvInt("BUCKET_AUTO_INSERT_MIN_SAVING", 1024),
+		BucketAutoInsertMax:       getEnvInt("BUCKET_AUTO_INSERT_MAX", 4),
+
+		SessionBudgetEscalationEnabled: getEnvBool("SESSION_BUDGET_ESCALATION_ENABLED", false),
+		SessionBudgetEscalationAfter:   getEnvInt("SESSION_BUDGET_ESCALATION_AFTER", 2),
+		SessionBudgetEscalationFactor:  getEnvFloat("SESSION_BUDGET_ESCALATION_FACTOR", 2),
+		SessionBudgetTTL:               getEnvDuration("SESSION_BUDGET_TTL", 30*time.Minute),
+		SessionBudgetMaxSessions:       getEnvInt("SESSION_BUDGET_MAX_SESSIONS", 1024),
 		ProgressInterval:    getEnvDuration("PROGRESS_INTERVAL", 250*time.Millisecond),
 		RecentBuffer:        getEnvInt("RECENT_BUFFER", 200),
+		OverflowLogPath:     getEnvString("OVERFLOW_LOG_PATH", ""),
+		OverflowLogMaxBytes: getEnvInt64("OVERFLOW_LOG_MAX_BYTES", 10*1024*1024),
 		HealthCheckInterval: getEnvDuration("HEALTH_CHECK_INTERVAL", 30*time.Second),
 		HealthCheckTimeout:  getEnvDuration("HEALTH_CHECK_TIMEOUT", 5*time.Second),
 
+		// Divergence detection
+		DivergenceDetectEnabled: getEnvBool("DIVERGENCE_DETECT_ENABLED", true),
+		DivergenceWindow:        getEnvInt("DIVERGENCE_WINDOW", 20),
+		DivergenceThreshold:     getEnvFloat("DIVERGENCE_THRESHOLD", 0.3),
+
+		// Event drop logging
+		EventDropLogThreshold: getEnvInt("EVENT_DROP_LOG_THRESHOLD", 0),
+		EventDropLogInterval:  getEnvDuration("EVENT_DROP_LOG_INTERVAL", time.Minute),
+
+		// Idle model eviction
+		IdleEvictEnabled:   getEnvBool("IDLE_EVICT_ENABLED", false),
+		IdleEvictAfter:     getEnvDuration("IDLE_EVICT_AFTER", 30*time.Minute),
+		IdleEvictInterval:  getEnvDuration("IDLE_EVICT_INTERVAL", time.Minute),
+		IdleEvictMode:      IdleEvictMode(getEnvString("IDLE_EVICT_MODE", string(IdleEvictPressure))),
+		IdleEvictVRAMBytes: getEnvInt64("IDLE_EVICT_VRAM_BYTES", 0),
+		IdleEvictPressure:  getEnvFloat("IDLE_EVICT_PRESSURE", 0.8),
+
+		ResidencyPollInterval: getEnvDuration("RESIDENCY_POLL_INTERVAL", 0),
+
+		VRAMHeadroomBytes:    getEnvInt64("VRAM_HEADROOM_BYTES", 1<<30),
+		VRAMGateEnabled:      getEnvBool("VRAM_GATE_ENABLED", false),
+		VRAMGateQueueTimeout: getEnvDuration("VRAM_GATE_QUEUE_TIMEOUT", 30*time.Second),
+		VRAMCtxCapEnabled:    getEnvBool("VRAM_CTX_CAP_ENABLED", false),
+		KVCacheType:          KVCacheType(getEnvString("KV_CACHE_TYPE", string(KVCacheF16))),
+
+		// Shadow mode
+		ShadowEnabled:     getEnvBool("SHADOW_ENABLED", false),
+		ShadowUpstreamURL: getEnvString("SHADOW_UPSTREAM_URL", ""),
+		ShadowSampleRate:  getEnvFloat("SHADOW_SAMPLE_RATE", 0.05),
+		ShadowTimeout:     getEnvDuration("SHADOW_TIMEOUT", 2*time.Minute),
+		ShadowMaxInFlight: getEnvInt("SHADOW_MAX_IN_FLIGHT", 4),
+
+		// Outcome hooks
+		HookCommands:   getEnvPrefixMap("HOOK_CMD_"),
+		HookCooldown:   getEnvDuration("HOOK_COOLDOWN", time.Minute),
+		HookMaxPerHour: getEnvInt("HOOK_MAX_PER_HOUR", 10),
+		HookTimeout:    getEnvDuration("HOOK_TIMEOUT", 30*time.Second),
+
+		// Latency SLO
+		SLOLatencyThreshold: getEnvDuration("SLO_LATENCY_THRESHOLD", 0),
+		SLOTarget:           getEnvFloat("SLO_TARGET", 0.95),
+		SLOWindow:           getEnvDuration("SLO_WINDOW", time.Hour),
+
+		MetricsSnapshotInterval:  getEnvDuration("METRICS_SNAPSHOT_INTERVAL", 0),
+		MetricsSnapshotRetention: getEnvDuration("METRICS_SNAPSHOT_RETENTION", 30*24*time.Hour),
+
+		MetricsInFlightPeakEnabled: getEnvBool("METRICS_IN_FLIGHT_PEAK_ENABLED", false),
+
+		DecisionCorpusPath:       getEnvString("DECISION_CORPUS_PATH", ""),
+		DecisionCorpusMaxBytes:   getEnvInt64("DECISION_CORPUS_MAX_BYTES", 10*1024*1024),
+		DecisionCorpusSampleRate: getEnvFloat("DECISION_CORPUS_SAMPLE_RATE", 1),
+
+		IdempotencyTTL:          getEnvDuration("IDEMPOTENCY_TTL", 0),
+		IdempotencyCacheSize:    getEnvInt("IDEMPOTENCY_CACHE_SIZE", 256),
+		IdempotencyMaxBodyBytes: getEnvInt64("IDEMPOTENCY_MAX_BODY_BYTES", 1024*1024),
+
+		ResponseCacheTTL:          getEnvDuration("RESPONSE_CACHE_TTL", 0),
+		ResponseCacheSize:         getEnvInt("RESPONSE_CACHE_SIZE", 256),
+		ResponseCacheMaxBodyBytes: getEnvInt64("RESPONSE_CACHE_MAX_BODY_BYTES", 1024*1024),
+
+		// Dashboard (defaults match the embedded dashboard's built-in intervals)
+		DashboardOverviewRefresh: getEnvDuration("DASHBOARD_OVERVIEW_REFRESH", 5*time.Second),
+		DashboardRequestsRefresh: getEnvDuration("DASHBOARD_REQUESTS_REFRESH", 3*time.Second),
+		DashboardHealthRefresh:   getEnvDuration("DASHBOARD_HEALTH_REFRESH", 10*time.Second),
+		DashboardRequestsLimit:   getEnvInt("DASHBOARD_REQUESTS_LIMIT", 200),
+		DashboardSections:        getEnvStringList("DASHBOARD_SECTIONS", DashboardSectionNames),
+
 		// HTTP
 		CORSAllowOrigin: getEnvString("CORS_ALLOW_ORIGIN", "*"),
 		FlushInterval:   getEnvDuration("FLUSH_INTERVAL", 100*time.Millisecond),
 
+		StreamCoalesceBytes:      getEnvInt("STREAM_COALESCE_BYTES", 0),
+		StreamCoalesceMaxLatency: getEnvDuration("STREAM_COALESCE_MAX_LATENCY", 20*time.Millisecond),
+		ProgressSidebandEnabled:  getEnvBool("PROGRESS_SIDEBAND_ENABLED", false),
+		ProgressSidebandInterval: getEnvDuration("PROGRESS_SIDEBAND_INTERVAL", time.Second),
+		TokenTrailersEnabled:     getEnvBool("TOKEN_TRAILERS_ENABLED", false),
+
+		ShutdownGracePeriod: getEnvDuration("SHUTDOWN_GRACE_PERIOD", 10*time.Second),
+
+		GlobalRequestTimeout: getEnvDuration("GLOBAL_REQUEST_TIMEOUT", 0),
+
 		// System prompt
 		StripSystemPromptText: getEnvString("STRIP_SYSTEM_PROMPT_TEXT", ""),
+		ThinkDefaults:         getEnvStringMap("THINK_DEFAULTS", nil),
+
+		NumPredictCeilings: getEnvIntMap("NUM_PREDICT_CEILINGS"),
+
+		MaxPromptTokens:      getEnvInt("MAX_PROMPT_TOKENS", 0),
+		ModelMaxPromptTokens: getEnvIntMap("MODEL_MAX_PROMPT_TOKENS"),
+
+		TagQuotas:      getEnvTagQuotas("TAG_QUOTAS"),
+		TagQuotaWindow: getEnvDuration("TAG_QUOTA_WINDOW", 24*time.Hour),
+
+		ModelInputTokenPrices:  getEnvFloatMap("MODEL_INPUT_TOKEN_PRICES"),
+		ModelOutputTokenPrices: getEnvFloatMap("MODEL_OUTPUT_TOKEN_PRICES"),
+
+		ModelMaxConcurrency:          getEnvIntMap("MODEL_MAX_CONCURRENCY"),
+		ModelConcurrencyPolicy:       ModelConcurrencyPolicy(getEnvString("MODEL_CONCURRENCY_POLICY", string(ModelConcurrencyQueue))),
+		ModelConcurrencyQueueTimeout: getEnvDuration("MODEL_CONCURRENCY_QUEUE_TIMEOUT", 60*time.Second),
+
+		PromptScanFile:     getEnvString("PROMPT_SCAN_FILE", ""),
+		PromptScanMaxBytes: getEnvInt("PROMPT_SCAN_MAX_BYTES", 65536),
+
+		LoadCoalesceEnabled: getEnvBool("LOAD_COALESCE_ENABLED", false),
+		LoadCoalesceTimeout: getEnvDuration("LOAD_COALESCE_TIMEOUT", 30*time.Second),
+		LoadCoalesceWarmTTL: getEnvDuration("LOAD_COALESCE_WARM_TTL", 5*time.Minute),
+
+		// Model families
+		ModelFamilyRules:          getEnvStringMap("MODEL_FAMILY_RULES", nil),
+		FamilyTokensPerByte:       getEnvFloatMap("FAMILY_TOKENS_PER_BYTE"),
+		FamilyTokensPerImage:      getEnvIntMap("FAMILY_TOKENS_PER_IMAGE"),
+		FamilyLoopRepeatThreshold: getEnvIntMap("FAMILY_LOOP_REPEAT_THRESHOLD"),
+		FamilyThinkEncodings:      getEnvStringMap("FAMILY_THINK_ENCODINGS", nil),
+		FamilyBuckets:             getEnvIntListMap("FAMILY_BUCKETS"),
+		FamilyRoleWeights:         getEnvStringMap("FAMILY_ROLE_WEIGHTS", nil),
 	}
 
+	if file != nil {
+		if err := file.checkUsed(); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.PromptScanFile != "" {
+		rules, err := LoadPromptScanRules(cfg.PromptScanFile)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.PromptScanRules = rules
+	}
 	if err := cfg.Validate(); err != nil {
 		return Config{}, err
 	}
@@ -241,6 +1247,17 @@ func (c Config) Validate() error {
 		return fmt.Errorf("invalid MODE: %q (must be off|monitor|retry|protect)", c.Mode)
 	}
 
+	if c.AdminListenAddr != "" && c.AdminListenAddr == c.ListenAddr {
+		return fmt.Errorf("ADMIN_LISTEN_ADDR must differ from LISTEN_ADDR")
+	}
+
+	if (c.AdminBasicUser == "") != (c.AdminBasicPassword == "") {
+		return fmt.Errorf("ADMIN_BASIC_AUTH_USER and ADMIN_BASIC_AUTH_PASSWORD must be set together")
+	}
+	if c.ProxyAuthRequired && !c.AdminAuthEnabled() {
+		return fmt.Errorf("PROXY_AUTH_REQUIRED needs ADMIN_AUTH_TOKEN or ADMIN_BASIC_AUTH_USER/PASSWORD")
+	}
+
 	// Storage validation
 	switch c.Storage {
 	case StorageSQLite, StorageMemory, StorageOff:
@@ -249,9 +1266,97 @@ func (c Config) Validate() error {
 		return fmt.Errorf("invalid STORAGE: %q (must be sqlite|memory|off)", c.Storage)
 	}
 
+	switch c.CalibrationBackend {
+	case CalibrationBackendFile:
+		// ok
+	case CalibrationBackendStorage:
+		if c.Storage != StorageSQLite {
+			return fmt.Errorf("CALIBRATION_BACKEND=storage requires STORAGE=sqlite")
+		}
+	default:
+		return fmt.Errorf("invalid CALIBRATION_BACKEND: %q (must be file|storage)", c.CalibrationBackend)
+	}
+	if c.CalibrationSaveDebounce <= 0 {
+		return fmt.Errorf("CALIBRATION_SAVE_DEBOUNCE must be > 0")
+	}
+	if c.CalibrationMinSamples < 0 {
+		return fmt.Errorf("CALIBRATION_MIN_SAMPLES must be >= 0")
+	}
+	switch c.CalibrationImportPolicy {
+	case CalibrationImportMerge, CalibrationImportReplace:
+		// ok
+	default:
+		return fmt.Errorf("invalid CALIBRATION_IMPORT_POLICY: %q (must be merge|replace)", c.CalibrationImportPolicy)
+	}
+	if c.TruncationCheckEnabled && c.TruncationCalibrationWeight < 1 {
+		return fmt.Errorf("TRUNCATION_CALIBRATION_WEIGHT must be >= 1")
+	}
+
+	if c.UtilizationLearnerEnabled {
+		if c.UtilizationWindow < 1 {
+			return fmt.Errorf("UTILIZATION_WINDOW must be >= 1")
+		}
+		if c.UtilizationMinSamples < 1 || c.UtilizationMinSamples > c.UtilizationWindow {
+			return fmt.Errorf("UTILIZATION_MIN_SAMPLES must be between 1 and UTILIZATION_WINDOW")
+		}
+		if c.UtilizationMargin < 0 {
+			return fmt.Errorf("UTILIZATION_MARGIN must be >= 0")
+		}
+		if c.UtilizationFloor <= 0 || c.UtilizationFloor > 1 {
+			return fmt.Errorf("UTILIZATION_FLOOR must be in (0, 1]")
+		}
+	}
+
+	if c.BucketAutoInsertEnabled && !c.BucketAnalyzerEnabled {
+		return fmt.Errorf("BUCKET_AUTO_INSERT_ENABLED requires BUCKET_ANALYZER_ENABLED")
+	}
+	if c.BucketAnalyzerEnabled {
+		if c.BucketAnalyzerWindow < 1 {
+			return fmt.Errorf("BUCKET_ANALYZER_WINDOW must be >= 1")
+		}
+		if c.BucketAnalyzerMinSamples < 1 || c.BucketAnalyzerMinSamples > c.BucketAnalyzerWindow {
+			return fmt.Errorf("BUCKET_ANALYZER_MIN_SAMPLES must be between 1 and BUCKET_ANALYZER_WINDOW")
+		}
+		if c.BucketAnalyzerGranularity < 1 {
+			return fmt.Errorf("BUCKET_ANALYZER_GRANULARITY must be >= 1")
+		}
+	}
+	if c.BucketAutoInsertEnabled {
+		if c.BucketAutoInsertMinSaving < 1 {
+			return fmt.Errorf("BUCKET_AUTO_INSERT_MIN_SAVING must be >= 1")
+		}
+		if c.BucketAutoInsertMax < 1 {
+			return fmt.Errorf("BUCKET_AUTO_INSERT_MAX must be >= 1")
+		}
+	}
+
+	if c.SessionBudgetEscalationEnabled {
+		if c.SessionBudgetEscalationAfter < 1 {
+			return fmt.Errorf("SESSION_BUDGET_ESCALATION_AFTER must be >= 1")
+		}
+		if c.SessionBudgetEscalationFactor <= 1 {
+			return fmt.Errorf("SESSION_BUDGET_ESCALATION_FACTOR must be > 1")
+		}
+		if c.SessionBudgetTTL <= 0 {
+			return fmt.Errorf("SESSION_BUDGET_TTL must be > 0")
+		}
+		if c.SessionBudgetMaxSessions < 1 {
+			return fmt.Errorf("SESSION_BUDGET_MAX_SESSIONS must be >= 1")
+		}
+	}
+
 	if c.StorageMaxRows < 100 {
 		return fmt.Errorf("STORAGE_MAX_ROWS must be >= 100")
 	}
+	if c.StorageSampleRate < 0 || c.StorageSampleRate > 1 {
+		return fmt.Errorf("STORAGE_SAMPLE_RATE must be in [0, 1]")
+	}
+	if c.StorageBreakerThreshold < 0 {
+		return fmt.Errorf("STORAGE_BREAKER_THRESHOLD must be >= 0")
+	}
+	if c.StorageBreakerThreshold > 0 && c.StorageBreakerCooldown <= 0 {
+		return fmt.Errorf("STORAGE_BREAKER_COOLDOWN must be > 0")
+	}
 
 	// Context validation
 	if c.MinCtx <= 0 {
@@ -266,15 +1371,361 @@ func (c Config) Validate() error {
 	if c.Headroom < 1.0 {
 		return fmt.Errorf("HEADROOM must be >= 1.0")
 	}
-
-	// Output validation
-	if c.DefaultOutputBudget < 0 || c.MaxOutputBudget < 0 {
-		return fmt.Errorf("output budgets must be >= 0")
+	if c.MinAbsoluteHeadroom < 0 {
+		return fmt.Errorf("MIN_ABSOLUTE_HEADROOM_TOKENS must be >= 0")
 	}
-	if c.DefaultOutputBudget > c.MaxOutputBudget {
-		return fmt.Errorf("DEFAULT_OUTPUT_BUDGET must be <= MAX_OUTPUT_BUDGET")
+	if c.HeadroomConfidenceEnabled {
+		if c.HeadroomLowConfidenceFactor < 1 || c.HeadroomLowConfidenceFactor > 2 {
+			return fmt.Errorf("HEADROOM_LOW_CONFIDENCE_FACTOR must be between 1 and 2")
+		}
+		if c.HeadroomHighConfidenceFactor <= 0 || c.HeadroomHighConfidenceFactor > 1 {
+			return fmt.Errorf("HEADROOM_HIGH_CONFIDENCE_FACTOR must be > 0 and <= 1")
+		}
 	}
-
+	switch c.UnstableCalibrationPolicy {
+	case UnstableCalibrationOff, UnstableCalibrationMaxCtx, UnstableCalibrationHeadroom:
+		// ok
+	default:
+		return fmt.Errorf("invalid UNSTABLE_CALIBRATION_POLICY: %q (must be off|max_ctx|headroom)", c.UnstableCalibrationPolicy)
+	}
+	if c.UnstableCalibrationPolicy != UnstableCalibrationOff {
+		if c.UnstableCalibrationConfidence <= 0 || c.UnstableCalibrationConfidence > 1 {
+			return fmt.Errorf("UNSTABLE_CALIBRATION_CONFIDENCE must be > 0 and <= 1")
+		}
+		if c.UnstableCalibrationHeadroomFactor < 1 {
+			return fmt.Errorf("UNSTABLE_CALIBRATION_HEADROOM_FACTOR must be >= 1")
+		}
+	}
+
+	// Output validation
+	if c.DefaultOutputBudget < 0 || c.MaxOutputBudget < 0 {
+		return fmt.Errorf("output budgets must be >= 0")
+	}
+	if c.DefaultOutputBudget > c.MaxOutputBudget {
+		return fmt.Errorf("DEFAULT_OUTPUT_BUDGET must be <= MAX_OUTPUT_BUDGET")
+	}
+
+	if c.DivergenceDetectEnabled {
+		if c.DivergenceWindow < 1 {
+			return fmt.Errorf("DIVERGENCE_WINDOW must be >= 1")
+		}
+		if c.DivergenceThreshold <= 0 {
+			return fmt.Errorf("DIVERGENCE_THRESHOLD must be > 0")
+		}
+	}
+
+	if c.SlowRequestThreshold < 0 {
+		return fmt.Errorf("SLOW_REQUEST_THRESHOLD must be >= 0")
+	}
+	for prefix, d := range c.ModelSlowRequestThreshold {
+		if d < 0 {
+			return fmt.Errorf("MODEL_SLOW_REQUEST_THRESHOLD: threshold for %q must be a duration >= 0", prefix)
+		}
+	}
+	if c.EventDropLogThreshold < 0 {
+		return fmt.Errorf("EVENT_DROP_LOG_THRESHOLD must be >= 0")
+	}
+	if c.EventDropLogThreshold > 0 && c.EventDropLogInterval <= 0 {
+		return fmt.Errorf("EVENT_DROP_LOG_INTERVAL must be > 0")
+	}
+
+	if c.IdleEvictEnabled {
+		if c.IdleEvictAfter <= 0 {
+			return fmt.Errorf("IDLE_EVICT_AFTER must be > 0")
+		}
+		if c.IdleEvictInterval <= 0 {
+			return fmt.Errorf("IDLE_EVICT_INTERVAL must be > 0")
+		}
+		switch c.IdleEvictMode {
+		case IdleEvictAlways:
+			// ok
+		case IdleEvictPressure:
+			if c.IdleEvictVRAMBytes <= 0 {
+				return fmt.Errorf("IDLE_EVICT_VRAM_BYTES must be > 0 when IDLE_EVICT_MODE=pressure")
+			}
+			if c.IdleEvictPressure <= 0 || c.IdleEvictPressure > 1 {
+				return fmt.Errorf("IDLE_EVICT_PRESSURE must be in (0, 1]")
+			}
+		default:
+			return fmt.Errorf("invalid IDLE_EVICT_MODE: %q", c.IdleEvictMode)
+		}
+	}
+	if c.ResidencyPollInterval < 0 {
+		return fmt.Errorf("RESIDENCY_POLL_INTERVAL must be >= 0")
+	}
+	if c.VRAMHeadroomBytes < 0 {
+		return fmt.Errorf("VRAM_HEADROOM_BYTES must be >= 0")
+	}
+	for _, gate := range []struct {
+		name    string
+		enabled bool
+	}{{"VRAM_GATE_ENABLED", c.VRAMGateEnabled}, {"VRAM_CTX_CAP_ENABLED", c.VRAMCtxCapEnabled}} {
+		if !gate.enabled {
+			continue
+		}
+		if c.ResidencyPollInterval <= 0 {
+			return fmt.Errorf("RESIDENCY_POLL_INTERVAL must be > 0 when %s is set", gate.name)
+		}
+		if c.IdleEvictVRAMBytes <= 0 {
+			return fmt.Errorf("IDLE_EVICT_VRAM_BYTES must be > 0 when %s is set", gate.name)
+		}
+	}
+	if c.VRAMGateEnabled && c.VRAMGateQueueTimeout < 0 {
+		return fmt.Errorf("VRAM_GATE_QUEUE_TIMEOUT must be >= 0")
+	}
+	if c.VRAMCtxCapEnabled && c.KVCacheType.BytesPerElement() == 0 {
+		return fmt.Errorf("invalid KV_CACHE_TYPE: %q", c.KVCacheType)
+	}
+
+	if c.ShadowEnabled {
+		if u, err := url.Parse(c.ShadowUpstreamURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("SHADOW_UPSTREAM_URL must be an absolute URL when SHADOW_ENABLED=true")
+		}
+		if c.Storage == StorageOff {
+			return fmt.Errorf("SHADOW_ENABLED requires STORAGE=memory or STORAGE=sqlite")
+		}
+		if c.ShadowSampleRate <= 0 || c.ShadowSampleRate > 1 {
+			return fmt.Errorf("SHADOW_SAMPLE_RATE must be in (0, 1]")
+		}
+		if c.ShadowTimeout <= 0 {
+			return fmt.Errorf("SHADOW_TIMEOUT must be > 0")
+		}
+		if c.ShadowMaxInFlight < 1 {
+			return fmt.Errorf("SHADOW_MAX_IN_FLIGHT must be >= 1")
+		}
+	}
+
+	if len(c.HookCommands) > 0 {
+		if c.HookCooldown < 0 {
+			return fmt.Errorf("HOOK_COOLDOWN must be >= 0")
+		}
+		if c.HookMaxPerHour < 1 {
+			return fmt.Errorf("HOOK_MAX_PER_HOUR must be >= 1")
+		}
+		if c.HookTimeout <= 0 {
+			return fmt.Errorf("HOOK_TIMEOUT must be > 0")
+		}
+	}
+
+	if c.SLOLatencyThreshold < 0 {
+		return fmt.Errorf("SLO_LATENCY_THRESHOLD must be >= 0")
+	}
+	if c.SLOLatencyThreshold > 0 {
+		if c.SLOTarget <= 0 || c.SLOTarget >= 1 {
+			return fmt.Errorf("SLO_TARGET must be between 0 and 1 (exclusive)")
+		}
+		if c.SLOWindow <= 0 {
+			return fmt.Errorf("SLO_WINDOW must be > 0")
+		}
+	}
+
+	if c.MetricsSnapshotInterval < 0 {
+		return fmt.Errorf("METRICS_SNAPSHOT_INTERVAL must be >= 0")
+	}
+	if c.MetricsSnapshotInterval > 0 {
+		if c.MetricsSnapshotInterval < time.Second {
+			return fmt.Errorf("METRICS_SNAPSHOT_INTERVAL must be >= 1s")
+		}
+		if c.MetricsSnapshotRetention < c.MetricsSnapshotInterval {
+			return fmt.Errorf("METRICS_SNAPSHOT_RETENTION must be >= METRICS_SNAPSHOT_INTERVAL")
+		}
+	}
+
+	if c.DashboardOverviewRefresh <= 0 || c.DashboardRequestsRefresh <= 0 || c.DashboardHealthRefresh <= 0 {
+		return fmt.Errorf("DASHBOARD_*_REFRESH intervals must be > 0")
+	}
+	if c.DashboardRequestsLimit < 1 {
+		return fmt.Errorf("DASHBOARD_REQUESTS_LIMIT must be >= 1")
+	}
+	for _, sec := range c.DashboardSections {
+		if !slices.Contains(DashboardSectionNames, sec) {
+			return fmt.Errorf("DASHBOARD_SECTIONS: unknown section %q (want %s)", sec, strings.Join(DashboardSectionNames, ","))
+		}
+	}
+
+	for prefix, v := range c.NumPredictCeilings {
+		if v <= 0 {
+			return fmt.Errorf("NUM_PREDICT_CEILINGS: ceiling for %q must be > 0", prefix)
+		}
+	}
+	if c.MaxPromptTokens < 0 {
+		return fmt.Errorf("MAX_PROMPT_TOKENS must be >= 0")
+	}
+	for prefix, v := range c.ModelMaxPromptTokens {
+		if v < 0 {
+			return fmt.Errorf("MODEL_MAX_PROMPT_TOKENS: limit for %q must be >= 0", prefix)
+		}
+	}
+	for prefix, v := range c.ModelInputTokenPrices {
+		if v < 0 {
+			return fmt.Errorf("MODEL_INPUT_TOKEN_PRICES: price for %q must be >= 0", prefix)
+		}
+	}
+	for prefix, v := range c.ModelOutputTokenPrices {
+		if v < 0 {
+			return fmt.Errorf("MODEL_OUTPUT_TOKEN_PRICES: price for %q must be >= 0", prefix)
+		}
+	}
+	for tag, spec := range c.TagQuotas {
+		if k, v, ok := strings.Cut(tag, "="); !ok || k == "" || v == "" {
+			return fmt.Errorf("TAG_QUOTAS: %q is not a key=value tag", tag)
+		}
+		if _, err := ParseTagQuota(spec); err != nil {
+			return fmt.Errorf("TAG_QUOTAS: %s: %w", tag, err)
+		}
+	}
+	if len(c.TagQuotas) > 0 && c.TagQuotaWindow <= 0 {
+		return fmt.Errorf("TAG_QUOTA_WINDOW must be > 0")
+	}
+	for prefix, v := range c.ModelMaxConcurrency {
+		if v <= 0 {
+			return fmt.Errorf("MODEL_MAX_CONCURRENCY: cap for %q must be > 0", prefix)
+		}
+	}
+	switch c.ModelConcurrencyPolicy {
+	case ModelConcurrencyQueue, ModelConcurrencyReject:
+		// ok
+	default:
+		return fmt.Errorf("invalid MODEL_CONCURRENCY_POLICY: %q (must be queue|reject)", c.ModelConcurrencyPolicy)
+	}
+	if c.ModelConcurrencyPolicy == ModelConcurrencyQueue && c.ModelConcurrencyQueueTimeout <= 0 {
+		return fmt.Errorf("MODEL_CONCURRENCY_QUEUE_TIMEOUT must be > 0")
+	}
+	if err := validatePromptScanRules(c.PromptScanRules); err != nil {
+		return err
+	}
+	if len(c.PromptScanRules) > 0 && c.PromptScanMaxBytes <= 0 {
+		return fmt.Errorf("PROMPT_SCAN_MAX_BYTES must be > 0")
+	}
+	if c.LoadCoalesceEnabled && c.LoadCoalesceTimeout <= 0 {
+		return fmt.Errorf("LOAD_COALESCE_TIMEOUT must be > 0")
+	}
+	if c.LoadCoalesceEnabled && c.LoadCoalesceWarmTTL <= 0 {
+		return fmt.Errorf("LOAD_COALESCE_WARM_TTL must be > 0")
+	}
+	for prefix, v := range c.StructuredOverheads {
+		if v < 0 {
+			return fmt.Errorf("STRUCTURED_OVERHEADS: overhead for %q must be >= 0", prefix)
+		}
+	}
+	if c.StructuredJSONBump < 0 {
+		return fmt.Errorf("STRUCTURED_JSON_BUMP must be >= 0")
+	}
+	if c.StopBudgetFactor <= 0 || c.StopBudgetFactor > 1 {
+		return fmt.Errorf("STOP_BUDGET_FACTOR must be in (0, 1]")
+	}
+	if c.NoStopThinkBudgetFactor < 1 {
+		return fmt.Errorf("NO_STOP_THINK_BUDGET_FACTOR must be >= 1")
+	}
+	if c.CodeTokensPerByte < 0 {
+		return fmt.Errorf("CODE_TOKENS_PER_BYTE must be >= 0")
+	}
+	for prefix, v := range c.CodeTokensPerByteOverrides {
+		if v < 0 {
+			return fmt.Errorf("MODEL_CODE_TOKENS_PER_BYTE: rate for %q must be >= 0", prefix)
+		}
+	}
+	for prefix, v := range c.ModelFixedOverheadTokens {
+		if v < 0 || v > 256 {
+			return fmt.Errorf("MODEL_FIXED_OVERHEAD_TOKENS: overhead for %q must be in [0, 256]", prefix)
+		}
+	}
+	for prefix, v := range c.ModelPerMessageOverhead {
+		if v < 0 || v > 64 {
+			return fmt.Errorf("MODEL_PER_MESSAGE_OVERHEAD_TOKENS: overhead for %q must be in [0, 64]", prefix)
+		}
+	}
+	for _, prefix := range c.ModelOverheadPinned {
+		prefix = strings.ToLower(prefix)
+		_, fixed := c.ModelFixedOverheadTokens[prefix]
+		_, perMessage := c.ModelPerMessageOverhead[prefix]
+		if !fixed && !perMessage {
+			return fmt.Errorf("MODEL_OVERHEAD_PINNED: %q has no MODEL_FIXED_OVERHEAD_TOKENS or MODEL_PER_MESSAGE_OVERHEAD_TOKENS entry", prefix)
+		}
+	}
+	if _, err := ParseRoleWeights(c.RoleWeights); err != nil {
+		return fmt.Errorf("ROLE_WEIGHTS: %w", err)
+	}
+	for pattern, fam := range c.ModelFamilyRules {
+		if !family.IsKnown(fam) {
+			return fmt.Errorf("MODEL_FAMILY_RULES: unknown family %q for %q", fam, pattern)
+		}
+	}
+	for name, v := range c.FamilyTokensPerByte {
+		if !family.IsKnown(name) || v <= 0 {
+			return fmt.Errorf("FAMILY_TOKENS_PER_BYTE: invalid entry %s=%v", name, v)
+		}
+	}
+	for name, v := range c.FamilyTokensPerImage {
+		if !family.IsKnown(name) || v < 0 {
+			return fmt.Errorf("FAMILY_TOKENS_PER_IMAGE: invalid entry %s=%d", name, v)
+		}
+	}
+	for name, v := range c.FamilyLoopRepeatThreshold {
+		if !family.IsKnown(name) || v < 2 {
+			return fmt.Errorf("FAMILY_LOOP_REPEAT_THRESHOLD: invalid entry %s=%d", name, v)
+		}
+	}
+	for name, v := range c.FamilyRoleWeights {
+		if !family.IsKnown(name) {
+			return fmt.Errorf("FAMILY_ROLE_WEIGHTS: unknown family %q", name)
+		}
+		if _, err := ParseRoleWeights(v); err != nil {
+			return fmt.Errorf("FAMILY_ROLE_WEIGHTS: %s: %w", name, err)
+		}
+	}
+	for name, v := range c.FamilyThinkEncodings {
+		if !family.IsKnown(name) {
+			return fmt.Errorf("FAMILY_THINK_ENCODINGS: unknown family %q", name)
+		}
+		if _, err := family.ParseThinkEncoding(v); err != nil {
+			return fmt.Errorf("FAMILY_THINK_ENCODINGS: %s: %w", name, err)
+		}
+	}
+
+	if c.ShutdownGracePeriod <= 0 {
+		return fmt.Errorf("SHUTDOWN_GRACE_PERIOD must be > 0")
+	}
+	if c.GlobalRequestTimeout < 0 {
+		return fmt.Errorf("GLOBAL_REQUEST_TIMEOUT must be >= 0")
+	}
+	if c.Mode == ModeProtect && c.GlobalRequestTimeout > 0 && c.GlobalRequestTimeout < time.Duration(c.TimeoutHardMs)*time.Millisecond {
+		return fmt.Errorf("GLOBAL_REQUEST_TIMEOUT must be >= TIMEOUT_HARD_MS, as the outer bound")
+	}
+
+	if c.IdempotencyTTL < 0 {
+		return fmt.Errorf("IDEMPOTENCY_TTL must be >= 0")
+	}
+	if c.IdempotencyTTL > 0 && c.IdempotencyCacheSize < 1 {
+		return fmt.Errorf("IDEMPOTENCY_CACHE_SIZE must be >= 1")
+	}
+	if c.IdempotencyTTL > 0 && c.IdempotencyMaxBodyBytes <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_MAX_BODY_BYTES must be > 0")
+	}
+	if c.ResponseCacheTTL < 0 {
+		return fmt.Errorf("RESPONSE_CACHE_TTL must be >= 0")
+	}
+	if c.ResponseCacheTTL > 0 && c.ResponseCacheSize < 1 {
+		return fmt.Errorf("RESPONSE_CACHE_SIZE must be >= 1")
+	}
+	if c.ResponseCacheTTL > 0 && c.ResponseCacheMaxBodyBytes <= 0 {
+		return fmt.Errorf("RESPONSE_CACHE_MAX_BODY_BYTES must be > 0")
+	}
+
+	if c.StreamCoalesceBytes < 0 {
+		return fmt.Errorf("STREAM_COALESCE_BYTES must be >= 0")
+	}
+	if c.StreamCoalesceBytes > 0 && c.StreamCoalesceMaxLatency <= 0 {
+		return fmt.Errorf("STREAM_COALESCE_MAX_LATENCY must be > 0")
+	}
+	if c.ProgressSidebandEnabled && c.ProgressSidebandInterval <= 0 {
+		return fmt.Errorf("PROGRESS_SIDEBAND_INTERVAL must be > 0")
+	}
+
+	if c.SampledEstimation && c.EstimateSampleBytes <= 0 {
+		return fmt.Errorf("ESTIMATE_SAMPLE_BYTES must be > 0")
+	}
+
 	// Retry validation
 	if c.RetryMax < 1 {
 		return fmt.Errorf("RETRY_MAX must be >= 1")
@@ -282,6 +1733,12 @@ func (c Config) Validate() error {
 	if c.RetryBackoffMs < 0 {
 		return fmt.Errorf("RETRY_BACKOFF_MS must be >= 0")
 	}
+	if c.RetryMinEvalCount < 0 {
+		return fmt.Errorf("RETRY_MIN_EVAL_COUNT must be >= 0")
+	}
+	if c.RetryLoadingBackoffMs < 0 {
+		return fmt.Errorf("RETRY_LOADING_BACKOFF_MS must be >= 0")
+	}
 
 	// Protect validation
 	if c.TimeoutTTFBMs <= 0 {
@@ -293,6 +1750,22 @@ func (c Config) Validate() error {
 	if c.TimeoutHardMs <= 0 {
 		return fmt.Errorf("TIMEOUT_HARD_MS must be > 0")
 	}
+	for name, spec := range c.TimeoutProfiles {
+		if name == "" {
+			return fmt.Errorf("TIMEOUT_PROFILES: profile names must not be empty")
+		}
+		if _, err := ParseTimeoutProfile(spec); err != nil {
+			return fmt.Errorf("TIMEOUT_PROFILES: %s: %w", name, err)
+		}
+		if p, _ := c.TimeoutProfileNamed(name); c.Mode == ModeProtect && c.GlobalRequestTimeout > 0 && c.GlobalRequestTimeout < p.Hard {
+			return fmt.Errorf("TIMEOUT_PROFILES: %s: GLOBAL_REQUEST_TIMEOUT must be >= its hard timeout, as the outer bound", name)
+		}
+	}
+	for prefix, name := range c.ModelTimeoutProfile {
+		if _, ok := c.TimeoutProfiles[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("MODEL_TIMEOUT_PROFILE: %q names unknown profile %q", prefix, name)
+		}
+	}
 	if c.LoopWindowBytes < 256 {
 		return fmt.Errorf("LOOP_WINDOW_BYTES must be >= 256")
 	}
@@ -308,6 +1781,17 @@ func (c Config) Validate() error {
 	if c.OutputLimitMaxTokens < 0 {
 		return fmt.Errorf("OUTPUT_LIMIT_MAX_TOKENS must be >= 0")
 	}
+	if c.LoopRetryMax < 1 || c.LoopRetryMax > maxLoopRetries {
+		return fmt.Errorf("LOOP_RETRY_MAX must be between 1 and %d", maxLoopRetries)
+	}
+	if c.LoopRetryTemperatureBump < 0 {
+		return fmt.Errorf("LOOP_RETRY_TEMPERATURE_BUMP must be >= 0")
+	}
+	for prefix, fallback := range c.ModelFallback {
+		if fallback == "" {
+			return fmt.Errorf("MODEL_FALLBACK: empty fallback for %q", prefix)
+		}
+	}
 
 	// Override policy
 	switch c.OverrideNumCtx {
@@ -316,20 +1800,99 @@ func (c Config) Validate() error {
 	default:
 		return fmt.Errorf("invalid OVERRIDE_NUM_CTX: %q", c.OverrideNumCtx)
 	}
+	for endpoint, p := range c.EndpointOverrideNumCtx {
+		if endpoint != "chat" && endpoint != "generate" {
+			return fmt.Errorf("ENDPOINT_OVERRIDE_NUM_CTX: unknown endpoint %q (must be chat|generate)", endpoint)
+		}
+		switch p {
+		case OverrideAlways, OverrideIfMissing, OverrideIfTooSmall:
+			// ok
+		default:
+			return fmt.Errorf("ENDPOINT_OVERRIDE_NUM_CTX: invalid policy %q for %s", p, endpoint)
+		}
+	}
+
+	switch c.ImageValidation {
+	case ImageValidationOff, ImageValidationCount, ImageValidationReject:
+		// ok
+	default:
+		return fmt.Errorf("invalid IMAGE_VALIDATION: %q", c.ImageValidation)
+	}
+
+	switch c.ImageBudgetPolicy {
+	case ImageBudgetOff, ImageBudgetDrop, ImageBudgetReject:
+		// ok
+	default:
+		return fmt.Errorf("invalid IMAGE_BUDGET_POLICY: %q (must be off|drop|reject)", c.ImageBudgetPolicy)
+	}
+	if c.ImageBudgetPolicy != ImageBudgetOff && (c.ImageBudgetFraction <= 0 || c.ImageBudgetFraction > 1) {
+		return fmt.Errorf("IMAGE_BUDGET_FRACTION must be in (0, 1]")
+	}
+
+	switch c.SeedPolicy {
+	case SeedNone, SeedFixed, SeedHash:
+		// ok
+	default:
+		return fmt.Errorf("invalid SEED_POLICY: %q", c.SeedPolicy)
+	}
+
+	switch c.NoSupervisePolicy {
+	case NoSuperviseAdmin, NoSuperviseAny, NoSuperviseOff:
+		// ok
+	default:
+		return fmt.Errorf("invalid NO_SUPERVISE_POLICY: %q (must be admin|any|off)", c.NoSupervisePolicy)
+	}
+
+	switch c.ErrorResponseStyle {
+	case ErrorStyleOllamaJSON, ErrorStylePlain:
+		// ok
+	default:
+		return fmt.Errorf("invalid ERROR_RESPONSE_STYLE: %q", c.ErrorResponseStyle)
+	}
+	switch c.UpstreamDialect {
+	case UpstreamAuto, UpstreamOllama, UpstreamOpenAI, UpstreamRaw:
+		// ok
+	default:
+		return fmt.Errorf("invalid UPSTREAM_DIALECT: %q (must be auto|ollama|openai|raw)", c.UpstreamDialect)
+	}
+
+	if c.ShowTimeout <= 0 {
+		return fmt.Errorf("SHOW_TIMEOUT must be > 0")
+	}
+	switch c.ShowTimeoutPolicy {
+	case ShowTimeoutConfigMax, ShowTimeoutStale, ShowTimeoutRemembered, ShowTimeoutFailFast:
+		// ok
+	default:
+		return fmt.Errorf("invalid SHOW_TIMEOUT_POLICY: %q (must be config_max|stale|remembered|fail_fast)", c.ShowTimeoutPolicy)
+	}
+	if c.UnverifiedMaxCtx < 0 {
+		return fmt.Errorf("UNVERIFIED_MAX_CTX must be >= 0")
+	}
 
 	// Buckets validation
-	if len(c.Buckets) == 0 {
-		return fmt.Errorf("BUCKETS must not be empty")
+	if err := validateBuckets("BUCKETS", c.Buckets); err != nil {
+		return err
 	}
-	prev := 0
-	for _, b := range c.Buckets {
-		if b <= 0 {
-			return fmt.Errorf("BUCKETS values must be > 0")
+	for prefix, b := range c.ModelBuckets {
+		if err := validateBuckets("MODEL_BUCKETS: "+prefix, b); err != nil {
+			return err
 		}
-		if b < prev {
-			return fmt.Errorf("BUCKETS must be ascending")
+	}
+	for name, b := range c.FamilyBuckets {
+		if !family.IsKnown(name) {
+			return fmt.Errorf("FAMILY_BUCKETS: unknown family %q", name)
 		}
-		prev = b
+		if err := validateBuckets("FAMILY_BUCKETS: "+name, b); err != nil {
+			return err
+		}
+	}
+	for prefix, v := range c.ModelPreferredCtx {
+		if v <= 0 {
+			return fmt.Errorf("MODEL_PREFERRED_CTX: context for %q must be > 0", prefix)
+		}
+	}
+	if c.PreferredCtxTolerance < 0 || c.PreferredCtxTolerance > 1 {
+		return fmt.Errorf("PREFERRED_CTX_TOLERANCE must be >= 0 and <= 1")
 	}
 
 	// Progress interval
@@ -341,6 +1904,17 @@ func (c Config) Validate() error {
 	if c.RecentBuffer < 0 {
 		return fmt.Errorf("RECENT_BUFFER must be >= 0")
 	}
+	if c.OverflowLogPath != "" && c.OverflowLogMaxBytes <= 0 {
+		return fmt.Errorf("OVERFLOW_LOG_MAX_BYTES must be > 0")
+	}
+	if c.DecisionCorpusPath != "" {
+		if c.DecisionCorpusMaxBytes <= 0 {
+			return fmt.Errorf("DECISION_CORPUS_MAX_BYTES must be > 0")
+		}
+		if c.DecisionCorpusSampleRate <= 0 || c.DecisionCorpusSampleRate > 1 {
+			return fmt.Errorf("DECISION_CORPUS_SAMPLE_RATE must be > 0 and <= 1")
+		}
+	}
 
 	// Health check
 	if c.HealthCheckInterval <= 0 {
@@ -353,17 +1927,36 @@ func (c Config) Validate() error {
 	return nil
 }
 
+// validateBuckets checks that a bucket ladder is non-empty, positive and
+// ascending; name prefixes the error.
+func validateBuckets(name string, buckets []int) error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+	prev := 0
+	for _, b := range buckets {
+		if b <= 0 {
+			return fmt.Errorf("%s values must be > 0", name)
+		}
+		if b < prev {
+			return fmt.Errorf("%s must be ascending", name)
+		}
+		prev = b
+	}
+	return nil
+}
+
 // Helper functions for parsing environment variables
 
 func getEnvString(key, def string) string {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		return v
 	}
 	return def
 }
 
 func getEnvInt(key string, def int) int {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
 			return n
 		}
@@ -372,7 +1965,7 @@ func getEnvInt(key string, def int) int {
 }
 
 func getEnvInt64(key string, def int64) int64 {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
 			return n
 		}
@@ -381,7 +1974,7 @@ func getEnvInt64(key string, def int64) int64 {
 }
 
 func getEnvFloat(key string, def float64) float64 {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
 			return f
 		}
@@ -390,7 +1983,7 @@ func getEnvFloat(key string, def float64) float64 {
 }
 
 func getEnvBool(key string, def bool) bool {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
 			return b
 		}
@@ -399,7 +1992,7 @@ func getEnvBool(key string, def bool) bool {
 }
 
 func getEnvDuration(key string, def time.Duration) time.Duration {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
 			return d
 		}
@@ -408,7 +2001,7 @@ func getEnvDuration(key string, def time.Duration) time.Duration {
 }
 
 func getEnvIntList(key string, def []int) []int {
-	if v, ok := os.LookupEnv(key); ok {
+	if v, ok := lookupEnv(key); ok {
 		if parsed, err := parseIntList(v); err == nil && len(parsed) > 0 {
 			return parsed
 		}
@@ -436,3 +2029,194 @@ func parseIntList(s string) ([]int, error) {
 	}
 	return out, nil
 }
+
+// getEnvStringList parses a comma-separated list. Unlike the other list helpers
+// an explicitly empty value yields an empty list rather than the default.
+func getEnvStringList(key string, def []string) []string {
+	v, ok := lookupEnv(key)
+	if !ok {
+		return def
+	}
+	out := []string{}
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func getEnvStringMap(key string, def map[string]string) map[string]string {
+	if v, ok := lookupEnv(key); ok {
+		if parsed, err := parseStringMap(v); err == nil && len(parsed) > 0 {
+			return parsed
+		}
+	}
+	return def
+}
+
+// getEnvOverridePolicyMap parses "endpoint=policy,..." (see parseStringMap),
+// leaving the policies for Validate to check.
+func getEnvOverridePolicyMap(key string) map[string]OverridePolicy {
+	raw := getEnvStringMap(key, nil)
+	if raw == nil {
+		return nil
+	}
+	out := make(map[string]OverridePolicy, len(raw))
+	for k, v := range raw {
+		out[k] = OverridePolicy(v)
+	}
+	return out
+}
+
+// getEnvTagQuotas parses TAG_QUOTAS-style "key=value=spec,..." entries, where
+// key=value is a request tag and spec is for ParseTagQuota. Tag keys are
+// lowercased like request tags; values keep their case.
+func getEnvTagQuotas(key string) map[string]string {
+	s := strings.TrimSpace(getEnvString(key, ""))
+	if s == "" {
+		return nil
+	}
+	out := make(map[string]string)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		// Tags can't contain '=' in their value and specs never do, so the
+		// spec starts after the last one.
+		i := strings.LastIndex(p, "=")
+		if i < 0 {
+			out[p] = ""
+			continue
+		}
+		tag, spec := p[:i], strings.TrimSpace(p[i+1:])
+		k, v, _ := strings.Cut(tag, "=")
+		out[strings.ToLower(strings.TrimSpace(k))+"="+strings.TrimSpace(v)] = spec
+	}
+	return out
+}
+
+// getEnvFloatMap parses "key=float,..." (see parseStringMap). Malformed values
+// are kept as 0 so Validate can reject them instead of silently dropping them.
+func getEnvFloatMap(key string) map[string]float64 {
+	raw := getEnvStringMap(key, nil)
+	if raw == nil {
+		return nil
+	}
+	out := make(map[string]float64, len(raw))
+	for k, v := range raw {
+		out[k], _ = strconv.ParseFloat(v, 64)
+	}
+	return out
+}
+
+// getEnvIntMap parses "key=int,..." like getEnvFloatMap. Malformed values become -1.
+func getEnvIntMap(key string) map[string]int {
+	raw := getEnvStringMap(key, nil)
+	if raw == nil {
+		return nil
+	}
+	out := make(map[string]int, len(raw))
+	for k, v := range raw {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			n = -1
+		}
+		out[k] = n
+	}
+	return out
+}
+
+// getEnvDurationMap parses "key=duration,..." like getEnvFloatMap. Malformed
+// values become -1.
+func getEnvDurationMap(key string) map[string]time.Duration {
+	raw := getEnvStringMap(key, nil)
+	if raw == nil {
+		return nil
+	}
+	out := make(map[string]time.Duration, len(raw))
+	for k, v := range raw {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			d = -1
+		}
+		out[k] = d
+	}
+	return out
+}
+
+// getEnvIntListMap parses "key=int|int|...,..." (see parseStringMap).
+// Malformed lists become nil so Validate rejects them as empty.
+func getEnvIntListMap(key string) map[string][]int {
+	raw := getEnvStringMap(key, nil)
+	if raw == nil {
+		return nil
+	}
+	out := make(map[string][]int, len(raw))
+	for k, v := range raw {
+		list, err := parseIntList(strings.ReplaceAll(v, "|", ","))
+		if err != nil {
+			list = nil
+		}
+		out[k] = list
+	}
+	return out
+}
+
+// getEnvPrefixMap collects the non-empty variables named prefix+NAME, from
+// the environment and CONFIG_FILE, keyed by lowercase NAME
+// (HOOK_CMD_LOOP_DETECTED -> "loop_detected").
+func getEnvPrefixMap(prefix string) map[string]string {
+	var out map[string]string
+	if file != nil {
+		for name, v := range file.prefixed(prefix) {
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			if out == nil {
+				out = make(map[string]string)
+			}
+			out[strings.ToLower(name)] = v
+		}
+	}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(k, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		if strings.TrimSpace(v) == "" {
+			delete(out, strings.ToLower(name)) // a blank variable unsets the file's
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[strings.ToLower(name)] = v
+	}
+	return out
+}
+
+// parseStringMap parses "model=value,model2=value2". Keys are lowercased so they
+// can be matched case-insensitively against model names.
+func parseStringMap(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	out := make(map[string]string)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(p, "=")
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid entry %q (want key=value)", p)
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
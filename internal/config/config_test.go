@@ -2,7 +2,12 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/family"
 )
 
 func TestModeDefault(t *testing.T) {
@@ -205,3 +210,595 @@ func TestFeaturesMatrix(t *testing.T) {
 		})
 	}
 }
+
+func TestThinkDefaults(t *testing.T) {
+	os.Setenv("THINK_DEFAULTS", "qwen3=false, Qwen3-Coder=true,gpt-oss=low")
+	defer os.Unsetenv("THINK_DEFAULTS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"qwen3:8b", "false"},
+		{"qwen3-coder:30b", "true"}, // longest prefix wins
+		{"GPT-OSS:20b", "low"},
+		{"llama3", ""},
+	}
+	for _, tt := range tests {
+		if got := cfg.ThinkDefaultFor(tt.model); got != tt.want {
+			t.Errorf("ThinkDefaultFor(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestDashboardSections(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.DashboardSections) != len(DashboardSectionNames) {
+		t.Errorf("expected all sections by default, got %v", cfg.DashboardSections)
+	}
+
+	os.Setenv("DASHBOARD_SECTIONS", "overview,charts")
+	defer os.Unsetenv("DASHBOARD_SECTIONS")
+	if _, err := Load(); err == nil {
+		t.Error("expected unknown section to be rejected")
+	}
+}
+
+func TestModelFamilyConfig(t *testing.T) {
+	os.Setenv("MODEL_FAMILY_RULES", "my-tune=qwen3")
+	os.Setenv("FAMILY_TOKENS_PER_BYTE", "qwen3=0.3")
+	defer os.Unsetenv("MODEL_FAMILY_RULES")
+	defer os.Unsetenv("FAMILY_TOKENS_PER_BYTE")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ModelFamilyRules["my-tune"] != "qwen3" {
+		t.Errorf("ModelFamilyRules = %v", cfg.ModelFamilyRules)
+	}
+	if cfg.FamilyTokensPerByte["qwen3"] != 0.3 {
+		t.Errorf("FamilyTokensPerByte = %v", cfg.FamilyTokensPerByte)
+	}
+
+	os.Setenv("MODEL_FAMILY_RULES", "my-tune=falcon")
+	if _, err := Load(); err == nil {
+		t.Error("expected unknown family to be rejected")
+	}
+	os.Setenv("MODEL_FAMILY_RULES", "")
+	os.Setenv("FAMILY_TOKENS_PER_BYTE", "qwen3=abc")
+	if _, err := Load(); err == nil {
+		t.Error("expected malformed tokens-per-byte to be rejected")
+	}
+}
+
+func TestStructuredOverheads(t *testing.T) {
+	os.Setenv("STRUCTURED_OVERHEADS", "qwen3=64,Qwen3-Coder=0")
+	defer os.Unsetenv("STRUCTURED_OVERHEADS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.StructuredJSONBump != 256 {
+		t.Errorf("StructuredJSONBump = %d, want 256", cfg.StructuredJSONBump)
+	}
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"qwen3:8b", 64},
+		{"qwen3-coder:30b", 0}, // longest prefix wins, and 0 is a valid override
+		{"llama3", cfg.StructuredOverhead},
+	}
+	for _, tt := range tests {
+		if got := cfg.StructuredOverheadFor(tt.model); got != tt.want {
+			t.Errorf("StructuredOverheadFor(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+
+	os.Setenv("STRUCTURED_OVERHEADS", "qwen3=abc")
+	if _, err := Load(); err == nil {
+		t.Error("expected malformed overhead to be rejected")
+	}
+}
+
+func TestSlowRequestThresholds(t *testing.T) {
+	os.Setenv("SLOW_REQUEST_THRESHOLD", "30s")
+	os.Setenv("MODEL_SLOW_REQUEST_THRESHOLD", "llama3:70b=5m,Qwen3=0")
+	defer os.Unsetenv("SLOW_REQUEST_THRESHOLD")
+	defer os.Unsetenv("MODEL_SLOW_REQUEST_THRESHOLD")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	tests := []struct {
+		model string
+		want  time.Duration
+	}{
+		{"llama3:70b-instruct", 5 * time.Minute},
+		{"qwen3:8b", 0},
+		{"llama3:8b", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := cfg.SlowRequestThresholdFor(tt.model); got != tt.want {
+			t.Errorf("SlowRequestThresholdFor(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+
+	os.Setenv("MODEL_SLOW_REQUEST_THRESHOLD", "qwen3=soon")
+	if _, err := Load(); err == nil {
+		t.Error("expected malformed threshold to be rejected")
+	}
+}
+
+func TestCodeTokensPerByte(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.CodeTokensPerByteFor("qwen2.5-coder"); got != 0 {
+		t.Errorf("expected code estimation off by default, got %v", got)
+	}
+
+	os.Setenv("CODE_TOKENS_PER_BYTE", "0.4")
+	os.Setenv("MODEL_CODE_TOKENS_PER_BYTE", "Qwen2.5-Coder=0.5,llama3=0")
+	defer os.Unsetenv("CODE_TOKENS_PER_BYTE")
+	defer os.Unsetenv("MODEL_CODE_TOKENS_PER_BYTE")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{"qwen2.5-coder:7b", 0.5},
+		{"llama3:8b", 0}, // 0 turns detection off for the model
+		{"mistral", 0.4},
+	}
+	for _, tt := range tests {
+		if got := cfg.CodeTokensPerByteFor(tt.model); got != tt.want {
+			t.Errorf("CodeTokensPerByteFor(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+
+	os.Setenv("CODE_TOKENS_PER_BYTE", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("expected negative CODE_TOKENS_PER_BYTE to be rejected")
+	}
+}
+
+func TestOverheadOverrides(t *testing.T) {
+	os.Setenv("MODEL_FIXED_OVERHEAD_TOKENS", "Llama3=96,qwen3=80")
+	os.Setenv("MODEL_PER_MESSAGE_OVERHEAD_TOKENS", "llama3=12")
+	os.Setenv("MODEL_OVERHEAD_PINNED", "LLAMA3")
+	defer os.Unsetenv("MODEL_FIXED_OVERHEAD_TOKENS")
+	defer os.Unsetenv("MODEL_PER_MESSAGE_OVERHEAD_TOKENS")
+	defer os.Unsetenv("MODEL_OVERHEAD_PINNED")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ModelFixedOverheadTokens["llama3"] != 96 || cfg.ModelPerMessageOverhead["llama3"] != 12 {
+		t.Errorf("unexpected overrides %v, %v", cfg.ModelFixedOverheadTokens, cfg.ModelPerMessageOverhead)
+	}
+
+	os.Setenv("MODEL_OVERHEAD_PINNED", "mistral")
+	if _, err := Load(); err == nil {
+		t.Error("expected pinning a prefix without overrides to be rejected")
+	}
+	os.Setenv("MODEL_OVERHEAD_PINNED", "")
+	os.Setenv("MODEL_PER_MESSAGE_OVERHEAD_TOKENS", "llama3=100")
+	if _, err := Load(); err == nil {
+		t.Error("expected a per-message overhead above 64 to be rejected")
+	}
+}
+
+func TestStopBudgetFactors(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.StopBudgetFactor != 1 || cfg.NoStopThinkBudgetFactor != 1 {
+		t.Errorf("expected stop budget scaling off by default, got %v/%v", cfg.StopBudgetFactor, cfg.NoStopThinkBudgetFactor)
+	}
+
+	for env, bad := range map[string][]string{
+		"STOP_BUDGET_FACTOR":          {"0", "1.5"},
+		"NO_STOP_THINK_BUDGET_FACTOR": {"0.5"},
+	} {
+		for _, v := range bad {
+			os.Setenv(env, v)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected %s=%s to be rejected", env, v)
+			}
+		}
+		os.Unsetenv(env)
+	}
+}
+
+func TestFamilyThinkEncodings(t *testing.T) {
+	os.Setenv("FAMILY_THINK_ENCODINGS", "gemma=enum:on|off,qwen3=string")
+	defer os.Unsetenv("FAMILY_THINK_ENCODINGS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if enc := cfg.ThinkEncodingFor(family.Gemma); enc.Style != family.ThinkLevel || !enc.Valid("off") || enc.Valid("low") {
+		t.Errorf("gemma encoding = %+v", enc)
+	}
+	if enc := cfg.ThinkEncodingFor(family.Qwen3); enc.Style != family.ThinkString {
+		t.Errorf("qwen3 encoding = %+v, want string", enc)
+	}
+	if enc := cfg.ThinkEncodingFor(family.GPTOSS); enc.Style != family.ThinkLevel || !enc.Valid("medium") {
+		t.Errorf("gpt-oss should keep its built-in encoding, got %+v", enc)
+	}
+
+	for _, bad := range []string{"falcon=bool", "gemma=maybe", "gemma=enum:"} {
+		os.Setenv("FAMILY_THINK_ENCODINGS", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected FAMILY_THINK_ENCODINGS=%s to be rejected", bad)
+		}
+	}
+}
+
+func TestBucketsFor(t *testing.T) {
+	os.Setenv("BUCKETS", "2048,4096,8192")
+	os.Setenv("FAMILY_BUCKETS", "qwen3=4096|16384|32768,llama=8192|65536")
+	os.Setenv("MODEL_BUCKETS", "qwen3:0.6b=1024|2048")
+	defer os.Unsetenv("BUCKETS")
+	defer os.Unsetenv("FAMILY_BUCKETS")
+	defer os.Unsetenv("MODEL_BUCKETS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	tests := []struct {
+		model, fam string
+		want       []int
+		source     string
+	}{
+		{"qwen3:0.6b", "qwen3", []int{1024, 2048}, BucketsSourceModel}, // per-model beats per-family
+		{"qwen3:8b", "qwen3", []int{4096, 16384, 32768}, BucketsSourceFamily},
+		{"llama3:8b", "llama", []int{8192, 65536}, BucketsSourceFamily},
+		{"mistral:7b", "mistral", []int{2048, 4096, 8192}, BucketsSourceGlobal},
+		{"custom", "", []int{2048, 4096, 8192}, BucketsSourceGlobal},
+	}
+	for _, tt := range tests {
+		got, source := cfg.BucketsFor(tt.model, family.Family(tt.fam))
+		if !reflect.DeepEqual(got, tt.want) || source != tt.source {
+			t.Errorf("BucketsFor(%q, %q) = %v (%s), want %v (%s)", tt.model, tt.fam, got, source, tt.want, tt.source)
+		}
+	}
+
+	for env, bad := range map[string][]string{
+		"FAMILY_BUCKETS": {"nosuchfamily=1024", "qwen3=8192|4096", "qwen3=abc"},
+		"MODEL_BUCKETS":  {"qwen3=0|1024", "qwen3="},
+	} {
+		for _, v := range bad {
+			os.Setenv(env, v)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected %s=%s to be rejected", env, v)
+			}
+		}
+		os.Unsetenv(env)
+	}
+}
+
+func TestRoleWeights(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.RoleWeightsFor(family.Qwen3); got != (RoleWeights{}) {
+		t.Errorf("expected no role weighting by default, got %+v", got)
+	}
+
+	os.Setenv("ROLE_WEIGHTS", "assistant:0.9")
+	os.Setenv("FAMILY_ROLE_WEIGHTS", "qwen3=assistant:0.7|tool:1.2")
+	defer os.Unsetenv("ROLE_WEIGHTS")
+	defer os.Unsetenv("FAMILY_ROLE_WEIGHTS")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, want := cfg.RoleWeightsFor(family.Qwen3), (RoleWeights{Assistant: 0.7, Tool: 1.2}); got != want {
+		t.Errorf("qwen3 weights = %+v, want %+v", got, want)
+	}
+	if got, want := cfg.RoleWeightsFor(family.Llama), (RoleWeights{Assistant: 0.9}); got != want {
+		t.Errorf("llama weights = %+v, want global %+v", got, want)
+	}
+
+	for env, bad := range map[string]string{
+		"ROLE_WEIGHTS":        "assistant:-1",
+		"FAMILY_ROLE_WEIGHTS": "nosuchfamily=user:1",
+	} {
+		os.Setenv(env, bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected %s=%s to be rejected", env, bad)
+		}
+		os.Unsetenv(env)
+	}
+	if w, err := ParseRoleWeights("assistant:0.6|Tool:1.5"); err != nil || w != (RoleWeights{Assistant: 0.6, Tool: 1.5}) {
+		t.Errorf("ParseRoleWeights = %+v, %v", w, err)
+	}
+	for _, bad := range []string{"assistant", "assistant:0", "bot:1", "user:x"} {
+		if _, err := ParseRoleWeights(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestHookCommands(t *testing.T) {
+	os.Setenv("HOOK_CMD_LOOP_DETECTED", "echo loop")
+	os.Setenv("HOOK_CMD_TIMEOUT_HARD", " ")
+	defer os.Unsetenv("HOOK_CMD_LOOP_DETECTED")
+	defer os.Unsetenv("HOOK_CMD_TIMEOUT_HARD")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := map[string]string{"loop_detected": "echo loop"}; !reflect.DeepEqual(cfg.HookCommands, want) {
+		t.Errorf("HookCommands = %v, want %v", cfg.HookCommands, want)
+	}
+
+	os.Setenv("HOOK_MAX_PER_HOUR", "0")
+	defer os.Unsetenv("HOOK_MAX_PER_HOUR")
+	if _, err := Load(); err == nil {
+		t.Error("expected HOOK_MAX_PER_HOUR=0 to be rejected")
+	}
+}
+
+func TestEndpointOverrideNumCtx(t *testing.T) {
+	os.Setenv("OVERRIDE_NUM_CTX", "if_missing")
+	os.Setenv("ENDPOINT_OVERRIDE_NUM_CTX", "Generate=always")
+	defer os.Unsetenv("OVERRIDE_NUM_CTX")
+	defer os.Unsetenv("ENDPOINT_OVERRIDE_NUM_CTX")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.OverridePolicyFor("generate"); got != OverrideAlways {
+		t.Errorf("OverridePolicyFor(generate) = %q, want always", got)
+	}
+	if got := cfg.OverridePolicyFor("chat"); got != OverrideIfMissing {
+		t.Errorf("OverridePolicyFor(chat) = %q, want if_missing", got)
+	}
+
+	for _, v := range []string{"embed=always", "chat=sometimes"} {
+		os.Setenv("ENDPOINT_OVERRIDE_NUM_CTX", v)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected ENDPOINT_OVERRIDE_NUM_CTX=%s to be rejected", v)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	os.Setenv("CONFIG_FILE", write("autoctx.yaml", `
+max_ctx: 65536
+MIN_CTX: 2048
+BUCKETS: [2048, 4096, 8192, 65536]
+MODEL_BUCKETS: {qwen3:0.6b: [2048, 4096]}
+MODEL_MAX_CONCURRENCY: {llama3:70b: 1}
+FAMILY_ROLE_WEIGHTS: {qwen3: {assistant: 0.7, tool: 1.2}}
+SESSION_BUDGET_TTL: 10m
+HOOK_CMD_LOOP_DETECTED: echo loop
+ROLE_WEIGHTS: null
+`))
+	os.Setenv("MIN_CTX", "4096")
+	defer os.Unsetenv("CONFIG_FILE")
+	defer os.Unsetenv("MIN_CTX")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.MaxCtx != 65536 || cfg.MinCtx != 4096 {
+		t.Errorf("MaxCtx, MinCtx = %d, %d; want 65536 from the file and 4096 from the environment", cfg.MaxCtx, cfg.MinCtx)
+	}
+	if got, _ := cfg.BucketsFor("qwen3:0.6b", family.Qwen3); !reflect.DeepEqual(got, []int{2048, 4096}) {
+		t.Errorf("model buckets = %v", got)
+	}
+	if !reflect.DeepEqual(cfg.Buckets, []int{2048, 4096, 8192, 65536}) {
+		t.Errorf("Buckets = %v", cfg.Buckets)
+	}
+	if got := cfg.ModelMaxConcurrencyFor("llama3:70b"); got != 1 {
+		t.Errorf("ModelMaxConcurrencyFor = %d, want 1", got)
+	}
+	if got, want := cfg.RoleWeightsFor(family.Qwen3), (RoleWeights{Assistant: 0.7, Tool: 1.2}); got != want {
+		t.Errorf("qwen3 weights = %+v, want %+v", got, want)
+	}
+	if cfg.SessionBudgetTTL != 10*time.Minute {
+		t.Errorf("SessionBudgetTTL = %v, want 10m", cfg.SessionBudgetTTL)
+	}
+	if cfg.HookCommands["loop_detected"] != "echo loop" {
+		t.Errorf("HookCommands = %v", cfg.HookCommands)
+	}
+
+	os.Setenv("CONFIG_FILE", write("autoctx.json", `{"MAX_CTX": 32768, "BUCKETS": [2048, 32768], "STOP_BUDGET_FACTOR": 0.5}`))
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.MaxCtx != 32768 || cfg.StopBudgetFactor != 0.5 {
+		t.Errorf("MaxCtx, StopBudgetFactor = %d, %v from JSON", cfg.MaxCtx, cfg.StopBudgetFactor)
+	}
+
+	for name, body := range map[string]string{
+		"typo.yaml":    "MAX_CTXX: 4096\n",
+		"invalid.yaml": "MODE: sometimes\n",
+		"broken.json":  `{"MAX_CTX": `,
+		"autoctx.toml": "MAX_CTX = 4096\n",
+	} {
+		os.Setenv("CONFIG_FILE", write(name, body))
+		if _, err := Load(); err == nil {
+			t.Errorf("expected CONFIG_FILE %s to be rejected", name)
+		}
+	}
+	os.Setenv("CONFIG_FILE", filepath.Join(dir, "missing.yaml"))
+	if _, err := Load(); err == nil {
+		t.Error("expected a missing CONFIG_FILE to be rejected")
+	}
+}
+
+func TestTagQuotas(t *testing.T) {
+	os.Setenv("TAG_QUOTAS", "Team=search=tokens:1000000|requests:500, project=RAG=requests:10")
+	defer os.Unsetenv("TAG_QUOTAS")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := map[string]TagQuota{
+		"team=search": {Tokens: 1000000, Requests: 500},
+		"project=RAG": {Requests: 10},
+	}
+	if got := cfg.TagQuotaLimits(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TagQuotaLimits = %v, want %v", got, want)
+	}
+	if cfg.TagQuotaWindow != 24*time.Hour {
+		t.Errorf("TagQuotaWindow = %v, want the 24h default", cfg.TagQuotaWindow)
+	}
+
+	for _, bad := range []string{"team=search", "team=search=tokens:0", "team=search=bytes:10", "search=tokens:10"} {
+		os.Setenv("TAG_QUOTAS", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected TAG_QUOTAS=%s to be rejected", bad)
+		}
+	}
+	os.Unsetenv("TAG_QUOTAS")
+
+	path := filepath.Join(t.TempDir(), "autoctx.yaml")
+	if err := os.WriteFile(path, []byte("TAG_QUOTAS: {team=search: {tokens: 2000, requests: 5}}\nTAG_QUOTA_WINDOW: 1h\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONFIG_FILE", path)
+	defer os.Unsetenv("CONFIG_FILE")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.TagQuotaLimits()["team=search"]; got != (TagQuota{Tokens: 2000, Requests: 5}) || cfg.TagQuotaWindow != time.Hour {
+		t.Errorf("quota from CONFIG_FILE = %+v over %v", got, cfg.TagQuotaWindow)
+	}
+}
+
+func TestTimeoutProfiles(t *testing.T) {
+	os.Setenv("TIMEOUT_PROFILES", "Interactive=ttfb:10s|stall:15s|hard:60s,batch=hard:1h")
+	os.Setenv("MODEL_TIMEOUT_PROFILE", "llama3=interactive,llama3:70b=batch")
+	defer os.Unsetenv("TIMEOUT_PROFILES")
+	defer os.Unsetenv("MODEL_TIMEOUT_PROFILE")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Unset timeouts keep the global ones.
+	if p, ok := cfg.TimeoutProfileNamed("batch"); !ok || p != (TimeoutProfile{TTFB: 15 * time.Second, Stall: 30 * time.Second, Hard: time.Hour}) {
+		t.Errorf("batch profile = %+v, %v", p, ok)
+	}
+	if p, ok := cfg.TimeoutProfileNamed("Interactive"); !ok || p != (TimeoutProfile{TTFB: 10 * time.Second, Stall: 15 * time.Second, Hard: time.Minute}) {
+		t.Errorf("interactive profile = %+v, %v", p, ok)
+	}
+	if _, ok := cfg.TimeoutProfileNamed("missing"); ok {
+		t.Error("expected no profile for an unknown name")
+	}
+
+	for _, tt := range []struct {
+		model, requested, want string
+	}{
+		{"llama3:8b", "", "interactive"},
+		{"llama3:70b", "", "batch"},                  // longest prefix wins
+		{"llama3:70b", "Interactive", "interactive"}, // the request's profile beats the model's
+		{"llama3:70b", "missing", "batch"},           // unknown request profiles are ignored
+		{"qwen3", "", ""},                            // global timeouts
+		{"qwen3", "batch", "batch"},
+	} {
+		if got := cfg.TimeoutProfileFor(tt.model, tt.requested); got != tt.want {
+			t.Errorf("TimeoutProfileFor(%q, %q) = %q, want %q", tt.model, tt.requested, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"fast=ttfb:0s", "fast=ttfb", "fast=idle:10s", "fast="} {
+		os.Setenv("TIMEOUT_PROFILES", bad)
+		os.Setenv("MODEL_TIMEOUT_PROFILE", "")
+		if _, err := Load(); err == nil {
+			t.Errorf("expected TIMEOUT_PROFILES=%s to be rejected", bad)
+		}
+	}
+	os.Setenv("TIMEOUT_PROFILES", "fast=ttfb:5s")
+	os.Setenv("MODEL_TIMEOUT_PROFILE", "llama3=slow")
+	if _, err := Load(); err == nil {
+		t.Error("expected MODEL_TIMEOUT_PROFILE naming an unknown profile to be rejected")
+	}
+}
+
+func TestPromptScanRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.yaml")
+	rules := "- name: ignore-instructions\n  pattern: (?i)ignore (all )?previous instructions\n  action: reject\n- name: dan\n  pattern: \\bDAN\\b\n  action: tag\n"
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PROMPT_SCAN_FILE", path)
+	defer os.Unsetenv("PROMPT_SCAN_FILE")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := []PromptScanRule{
+		{Name: "ignore-instructions", Pattern: "(?i)ignore (all )?previous instructions", Action: PromptScanReject},
+		{Name: "dan", Pattern: `\bDAN\b`, Action: PromptScanTag},
+	}
+	if !reflect.DeepEqual(cfg.PromptScanRules, want) {
+		t.Errorf("PromptScanRules = %+v, want %+v", cfg.PromptScanRules, want)
+	}
+	if cfg.PromptScanMaxBytes != 65536 {
+		t.Errorf("PromptScanMaxBytes = %d, want the 64KiB default", cfg.PromptScanMaxBytes)
+	}
+
+	for name, body := range map[string]string{
+		"bad.json":    `[{"name": "x", "pattern": "(unclosed", "action": "log"}]`,
+		"action.json": `[{"name": "x", "pattern": "a", "action": "block"}]`,
+		"dup.json":    `[{"name": "x", "pattern": "a", "action": "log"}, {"name": "x", "pattern": "b", "action": "tag"}]`,
+		"name.json":   `[{"name": "has space", "pattern": "a", "action": "log"}]`,
+		"rules.txt":   `x`,
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("PROMPT_SCAN_FILE", p)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected PROMPT_SCAN_FILE %s to be rejected", name)
+		}
+	}
+	os.Setenv("PROMPT_SCAN_FILE", filepath.Join(dir, "missing.yaml"))
+	if _, err := Load(); err == nil {
+		t.Error("expected a missing PROMPT_SCAN_FILE to be rejected")
+	}
+}
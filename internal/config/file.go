@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile holds the settings of CONFIG_FILE as the strings their
+// environment variables would hold, so they are parsed and validated exactly
+// like env-derived config. The environment takes precedence over the file.
+type configFile struct {
+	path   string
+	values map[string]string // by variable name, e.g. "MAX_CTX"
+	used   map[string]bool
+}
+
+// file is the CONFIG_FILE being applied by Load, consulted by lookupEnv.
+var file *configFile
+
+// lookupEnv returns the environment's value of key, else CONFIG_FILE's.
+func lookupEnv(key string) (string, bool) {
+	var fv string
+	var inFile bool
+	if file != nil {
+		if fv, inFile = file.values[key]; inFile {
+			file.used[key] = true
+		}
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return v, true
+	}
+	return fv, inFile
+}
+
+// loadConfigFile reads a YAML (.yaml, .yml) or JSON (.json) file whose
+// top-level keys are config variable names, in any case. Values are
+// scalars or, for list and map variables, their structured form:
+//
+//	BUCKETS: [2048, 4096, 8192]
+//	MODEL_MAX_CONCURRENCY: {llama3:70b: 1}
+//	MODEL_BUCKETS: {qwen3:0.6b: [1024, 2048]}
+//	FAMILY_ROLE_WEIGHTS: {qwen3: {assistant: 0.7}}
+//
+// A null value leaves the variable unset.
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+	var raw map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	default:
+		return nil, fmt.Errorf("CONFIG_FILE: unsupported extension %q (want .yaml, .yml or .json)", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CONFIG_FILE %s: %w", path, err)
+	}
+
+	f := &configFile{path: path, values: make(map[string]string, len(raw)), used: make(map[string]bool)}
+	for k, v := range raw {
+		key := strings.ToUpper(strings.TrimSpace(k))
+		if key == "CONFIG_FILE" {
+			return nil, fmt.Errorf("CONFIG_FILE %s: CONFIG_FILE can't be set from the file", path)
+		}
+		if v == nil {
+			continue
+		}
+		s, err := fileValue(v, 0)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE %s: %s: %w", path, key, err)
+		}
+		f.values[key] = s
+	}
+	return f, nil
+}
+
+// fileValue renders v as an env value: lists are joined with "," and maps
+// become "key=value" pairs; inside a map, lists are joined with "|" and maps
+// become "key:value|..." (the ROLE_WEIGHTS form).
+func fileValue(v any, depth int) (string, error) {
+	switch v := v.(type) {
+	case []any:
+		if depth > 1 {
+			return "", fmt.Errorf("nested too deeply")
+		}
+		sep := ","
+		if depth == 1 {
+			sep = "|"
+		}
+		parts := make([]string, len(v))
+		for i, e := range v {
+			s, err := fileValue(e, 2)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, sep), nil
+	case map[string]any:
+		if depth > 1 {
+			return "", fmt.Errorf("nested too deeply")
+		}
+		sep, kv := ",", "="
+		if depth == 1 {
+			sep, kv = "|", ":"
+		}
+		keys := slices.Sorted(maps.Keys(v))
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			s, err := fileValue(v[k], depth+1)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = k + kv + s
+		}
+		return strings.Join(parts, sep), nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = e
+		}
+		return fileValue(m, depth)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// prefixed returns the file's variables named prefix+NAME, marking them used.
+func (f *configFile) prefixed(prefix string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range f.values {
+		if name, ok := strings.CutPrefix(k, prefix); ok && name != "" {
+			out[name] = v
+			f.used[k] = true
+		}
+	}
+	return out
+}
+
+// checkUsed rejects variables Load never read, e.g. a misspelt name.
+func (f *configFile) checkUsed() error {
+	var unknown []string
+	for k := range f.values {
+		if !f.used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("CONFIG_FILE %s: unknown settings: %s", f.path, strings.Join(unknown, ", "))
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PromptScanAction is what a PROMPT_SCAN_FILE rule does to a request whose
+// prompt matches it.
+type PromptScanAction string
+
+const (
+	PromptScanLog    PromptScanAction = "log"    // log and count the match
+	PromptScanTag    PromptScanAction = "tag"    // also add a prompt_scan=<rule> tag to the request
+	PromptScanReject PromptScanAction = "reject" // answer 400 instead of forwarding the request
+)
+
+// PromptScanRule is one PROMPT_SCAN_FILE rule: a regexp (Go RE2 syntax)
+// matched against each prompt text.
+type PromptScanRule struct {
+	Name    string           `json:"name" yaml:"name"`
+	Pattern string           `json:"pattern" yaml:"pattern"`
+	Action  PromptScanAction `json:"action" yaml:"action"`
+}
+
+// LoadPromptScanRules reads the rule list of a YAML (.yaml, .yml) or JSON
+// (.json) file: a list of objects each with a name, a pattern (a Go regexp)
+// and an action. Rules are checked by Validate.
+func LoadPromptScanRules(path string) ([]PromptScanRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("PROMPT_SCAN_FILE: %w", err)
+	}
+	var rules []PromptScanRule
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &rules)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&rules)
+	default:
+		return nil, fmt.Errorf("PROMPT_SCAN_FILE: unsupported extension %q (want .yaml, .yml or .json)", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PROMPT_SCAN_FILE %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// validatePromptScanRules checks that every rule has a unique name usable as
+// a tag value, a pattern that compiles and a known action.
+func validatePromptScanRules(rules []PromptScanRule) error {
+	seen := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if !validRuleName(rule.Name) {
+			return fmt.Errorf("PROMPT_SCAN_FILE: rule %d: name %q must be 1-64 letters, digits or _.-", i+1, rule.Name)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("PROMPT_SCAN_FILE: duplicate rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.Pattern == "" {
+			return fmt.Errorf("PROMPT_SCAN_FILE: rule %s: pattern must not be empty", rule.Name)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("PROMPT_SCAN_FILE: rule %s: %w", rule.Name, err)
+		}
+		switch rule.Action {
+		case PromptScanLog, PromptScanTag, PromptScanReject:
+		default:
+			return fmt.Errorf("PROMPT_SCAN_FILE: rule %s: invalid action %q (must be log|tag|reject)", rule.Name, rule.Action)
+		}
+	}
+	return nil
+}
+
+// validRuleName allows what request tag values allow, minus ":/@".
+func validRuleName(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TagQuota is one TAG_QUOTAS entry: what requests carrying the tag may use
+// per TAG_QUOTA_WINDOW. Zero leaves that dimension unlimited.
+type TagQuota struct {
+	Tokens   int64
+	Requests int64
+}
+
+// ParseTagQuota parses "tokens:N|requests:N", e.g. "tokens:1000000"; at
+// least one positive limit is required.
+func ParseTagQuota(s string) (TagQuota, error) {
+	var q TagQuota
+	for _, p := range strings.Split(s, "|") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kind, v, ok := strings.Cut(p, ":")
+		if !ok {
+			return TagQuota{}, fmt.Errorf("invalid quota %q (want tokens:N or requests:N)", p)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || n <= 0 {
+			return TagQuota{}, fmt.Errorf("invalid limit %q for %q (must be > 0)", v, kind)
+		}
+		switch strings.ToLower(strings.TrimSpace(kind)) {
+		case "tokens":
+			q.Tokens = n
+		case "requests":
+			q.Requests = n
+		default:
+			return TagQuota{}, fmt.Errorf("unknown quota %q (want tokens or requests)", kind)
+		}
+	}
+	if q.Tokens == 0 && q.Requests == 0 {
+		return TagQuota{}, fmt.Errorf("empty quota %q", s)
+	}
+	return q, nil
+}
+
+// TagQuotaLimits returns the parsed TAG_QUOTAS, keyed by "key=value" tag.
+// Entries Validate would reject are left out.
+func (c *Config) TagQuotaLimits() map[string]TagQuota {
+	out := make(map[string]TagQuota, len(c.TagQuotas))
+	for tag, spec := range c.TagQuotas {
+		if q, err := ParseTagQuota(spec); err == nil {
+			out[tag] = q
+		}
+	}
+	return out
+}
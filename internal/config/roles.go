@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RoleWeights scale the estimated tokens of each message role's bytes (see
+// estimate.RoleWeights, which it converts to). 0 means 1 (unweighted).
+type RoleWeights struct {
+	System    float64
+	User      float64
+	Assistant float64
+	Tool      float64
+}
+
+// ParseRoleWeights parses "role:weight|role:weight", e.g. "assistant:0.8|tool:1.2".
+// Roles are system, user, assistant and tool; weights must be > 0.
+func ParseRoleWeights(s string) (RoleWeights, error) {
+	var w RoleWeights
+	for _, p := range strings.Split(s, "|") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		role, v, ok := strings.Cut(p, ":")
+		if !ok {
+			return RoleWeights{}, fmt.Errorf("invalid role weight %q (want role:weight)", p)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f <= 0 {
+			return RoleWeights{}, fmt.Errorf("invalid weight %q for role %q (must be > 0)", v, role)
+		}
+		switch strings.ToLower(strings.TrimSpace(role)) {
+		case "system":
+			w.System = f
+		case "user":
+			w.User = f
+		case "assistant":
+			w.Assistant = f
+		case "tool":
+			w.Tool = f
+		default:
+			return RoleWeights{}, fmt.Errorf("unknown role %q (want system, user, assistant or tool)", role)
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeoutProfile is one TIMEOUT_PROFILES entry: the watchdog timeouts for
+// the requests it's resolved for. Zero keeps the global TIMEOUT_*_MS value.
+type TimeoutProfile struct {
+	TTFB  time.Duration
+	Stall time.Duration
+	Hard  time.Duration
+}
+
+// ParseTimeoutProfile parses "ttfb:D|stall:D|hard:D" with Go durations, e.g.
+// "ttfb:10s|hard:60s"; at least one timeout is required.
+func ParseTimeoutProfile(s string) (TimeoutProfile, error) {
+	var p TimeoutProfile
+	for _, part := range strings.Split(s, "|") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, v, ok := strings.Cut(part, ":")
+		if !ok {
+			return TimeoutProfile{}, fmt.Errorf("invalid timeout %q (want ttfb:D, stall:D or hard:D)", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			return TimeoutProfile{}, fmt.Errorf("invalid duration %q for %q (must be > 0)", v, kind)
+		}
+		switch strings.ToLower(strings.TrimSpace(kind)) {
+		case "ttfb":
+			p.TTFB = d
+		case "stall":
+			p.Stall = d
+		case "hard":
+			p.Hard = d
+		default:
+			return TimeoutProfile{}, fmt.Errorf("unknown timeout %q (want ttfb, stall or hard)", kind)
+		}
+	}
+	if p == (TimeoutProfile{}) {
+		return TimeoutProfile{}, fmt.Errorf("empty timeout profile %q", s)
+	}
+	return p, nil
+}
+
+// TimeoutProfileNamed returns the TIMEOUT_PROFILES profile called name
+// (case-insensitively), with the timeouts it leaves unset taken from
+// TIMEOUT_*_MS.
+func (c *Config) TimeoutProfileNamed(name string) (TimeoutProfile, bool) {
+	spec, ok := c.TimeoutProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return TimeoutProfile{}, false
+	}
+	p, err := ParseTimeoutProfile(spec)
+	if err != nil {
+		return TimeoutProfile{}, false
+	}
+	if p.TTFB == 0 {
+		p.TTFB = time.Duration(c.TimeoutTTFBMs) * time.Millisecond
+	}
+	if p.Stall == 0 {
+		p.Stall = time.Duration(c.TimeoutStallMs) * time.Millisecond
+	}
+	if p.Hard == 0 {
+		p.Hard = time.Duration(c.TimeoutHardMs) * time.Millisecond
+	}
+	return p, true
+}
+
+// TimeoutProfileFor returns the profile name for a request for model: the one
+// the request asked for, if TIMEOUT_PROFILES has it, else the longest
+// model-name prefix match of MODEL_TIMEOUT_PROFILE. It returns "" when the
+// global timeouts apply.
+func (c *Config) TimeoutProfileFor(model, requested string) string {
+	if requested = strings.ToLower(strings.TrimSpace(requested)); requested != "" {
+		if _, ok := c.TimeoutProfiles[requested]; ok {
+			return requested
+		}
+	}
+	name, _ := longestPrefixValue(c.ModelTimeoutProfile, model)
+	return strings.ToLower(name)
+}
@@ -0,0 +1,104 @@
+package estimate
+
+import (
+	"strings"
+
+	"ollama-auto-ctx/internal/util"
+)
+
+const (
+	// codeSymbolDensity is the share of non-space bytes that must be code
+	// punctuation for a paragraph outside fences to count as code.
+	codeSymbolDensity = 0.08
+	// minCodeParagraph keeps short lines like "a = b" from flipping either way.
+	minCodeParagraph = 24
+)
+
+// CountCodeBytes returns how many of the request's text bytes look like code:
+// everything inside ``` or ~~~ fences, plus paragraphs whose punctuation
+// density is typical of source code. It scans the same text as
+// ExtractFeatures except tool definitions and tool calls, which are JSON and
+// estimated at the normal rate. roles attributes the code bytes to message
+// roles like Features.Roles, for WeightedCodeBytes.
+func CountCodeBytes(endpoint string, req map[string]any) (n int, roles RoleBytes) {
+	switch endpoint {
+	case EndpointGenerate:
+		if s, ok := util.ToString(req["prompt"]); ok {
+			roles.User += codeBytes(s)
+		} else if parts, ok := util.ToStrings(req["prompt"]); ok {
+			for _, p := range parts {
+				roles.User += codeBytes(p)
+			}
+		}
+		if s, ok := util.ToString(req["system"]); ok {
+			roles.System += codeBytes(s)
+		}
+		n = roles.User + roles.System
+		if s, ok := util.ToString(req["suffix"]); ok {
+			n += codeBytes(s)
+		}
+	case EndpointChat:
+		msgs, _ := req["messages"].([]any)
+		for _, m := range msgs {
+			if mm, ok := m.(map[string]any); ok {
+				if s, ok := util.ToString(mm["content"]); ok {
+					c := codeBytes(s)
+					role, _ := util.ToString(mm["role"])
+					roles.addRole(role, c)
+					n += c
+				}
+			}
+		}
+	}
+	return n, roles
+}
+
+// codeBytes counts the code bytes in one text.
+func codeBytes(s string) int {
+	code := 0
+	inFence := false
+	var para strings.Builder // current paragraph outside fences, with its newlines
+	flush := func() {
+		if looksLikeCode(para.String()) {
+			code += para.Len()
+		}
+		para.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(s, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			inFence = !inFence
+			code += len(line)
+		case inFence:
+			code += len(line)
+		case trimmed == "":
+			flush()
+		default:
+			para.WriteString(line)
+		}
+	}
+	flush()
+	return code
+}
+
+// looksLikeCode reports whether an unfenced paragraph is dense in code punctuation.
+func looksLikeCode(p string) bool {
+	if len(p) < minCodeParagraph {
+		return false
+	}
+	symbols, nonSpace := 0, 0
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '{', '}', '[', ']', '(', ')', ';', '=', '<', '>', '&', '|', '*', '/', '\\', '$', '#', '_', '`', '^', '~', '%':
+			symbols++
+		}
+		nonSpace++
+	}
+	return nonSpace > 0 && float64(symbols) >= codeSymbolDensity*float64(nonSpace)
+}
@@ -28,12 +28,28 @@ type Features struct {
 	ImageCount   int
 	Structured   bool
 	Raw          bool
+	// CustomTemplate is set for a generate request with its own template,
+	// which replaces the model's, so the fixed overhead learned for the
+	// model's template doesn't apply.
+	CustomTemplate bool
+	// SchemaProperties counts properties in a JSON schema format, including
+	// ones reached through $ref (see CountSchemaProperties).
+	SchemaProperties int
+	// CodeBytes is the part of TextBytes detected as code (see
+	// CountCodeBytes), and CodeRoles the part of each role's; only set when
+	// code is estimated separately.
+	CodeBytes int
+	CodeRoles RoleBytes
+	// Roles attributes TextBytes to message roles, for RoleWeights.
+	Roles RoleBytes
 
 	// User-provided options.
 	ProvidedNumCtx   int
 	ProvidedNumCtxOK bool
 	NumPredict       int
 	NumPredictOK     bool
+	// StopSequences counts the non-empty options.stop strings.
+	StopSequences int
 }
 
 // extractThinkingFromText looks for __think=<verdict> in text and returns the verdict and cleaned text.
@@ -156,7 +172,7 @@ func StripSystemPromptText(reqMap map[string]any, endpoint, textToStrip string)
 // ExtractFeatures computes token-relevant features from an Ollama request payload.
 //
 // Supported inputs:
-// - /api/generate: fields like model, prompt, system, suffix, raw, images
+// - /api/generate: fields like model, prompt (string or array of strings), system, suffix, raw, images
 // - /api/chat: fields like model, messages, tools, format
 //
 // Returns ok=false if the request doesn't contain a model name.
@@ -180,6 +196,7 @@ func ExtractFeatures(endpoint string, req map[string]any) (Features, error) {
 			f.NumPredict = n
 			f.NumPredictOK = true
 		}
+		f.StopSequences = countStopSequences(opt["stop"])
 	}
 
 	// format (structured output tends to need more context headroom)
@@ -191,6 +208,7 @@ func ExtractFeatures(endpoint string, req map[string]any) (Features, error) {
 	case map[string]any:
 		// JSON schema object
 		f.Structured = true
+		f.SchemaProperties = CountSchemaProperties(v)
 	case []any:
 		// Uncommon, but treat as structured.
 		f.Structured = true
@@ -213,15 +231,26 @@ func ExtractFeatures(endpoint string, req map[string]any) (Features, error) {
 func extractGenerate(f Features, req map[string]any) Features {
 	if s, ok := util.ToString(req["prompt"]); ok {
 		f.TextBytes += len(s)
+		f.Roles.User += len(s)
+	} else if parts, ok := util.ToStrings(req["prompt"]); ok {
+		// Batched prompts: each element is templated separately, so it also
+		// carries the per-message overhead.
+		for _, p := range parts {
+			f.TextBytes += len(p)
+			f.Roles.User += len(p)
+		}
+		f.MessageCount += len(parts)
 	}
 	if s, ok := util.ToString(req["system"]); ok {
 		f.TextBytes += len(s)
+		f.Roles.System += len(s)
 	}
 	if s, ok := util.ToString(req["suffix"]); ok {
 		f.TextBytes += len(s)
 	}
 	if s, ok := util.ToString(req["template"]); ok {
 		f.TextBytes += len(s)
+		f.CustomTemplate = s != ""
 	}
 	if b, ok := util.ToBool(req["raw"]); ok {
 		f.Raw = b
@@ -242,13 +271,16 @@ func extractChat(f Features, req map[string]any) Features {
 				continue
 			}
 			f.MessageCount++
+			role, _ := mm["role"].(string)
 			if s, ok := util.ToString(mm["content"]); ok {
 				f.TextBytes += len(s)
+				f.Roles.addRole(role, len(s))
 			}
 			// Some clients include tool_calls in the message.
 			if tc, ok := mm["tool_calls"]; ok {
 				if b, err := json.Marshal(tc); err == nil {
 					f.TextBytes += len(b)
+					f.Roles.addRole(role, len(b))
 				}
 			}
 			if imgs, ok := mm["images"].([]any); ok {
@@ -270,7 +302,12 @@ func extractChat(f Features, req map[string]any) Features {
 // EstimatePromptTokens estimates how many tokens the prompt will consume.
 //
 // It uses per-model calibration parameters (TokensPerByte, overhead) and includes image tokens.
-func EstimatePromptTokens(f Features, params calibration.Params, tokensPerImage int) int {
+// Text is weighted per role by weights (see WeightedTextBytes). When
+// codeTokensPerByte > 0, f.CodeBytes are estimated at that rate, unweighted,
+// and the remaining text at TokensPerByte: the code's weighted bytes (see
+// WeightedCodeBytes) are taken off the weighted text. With f.CustomTemplate
+// the template's bytes stand in for FixedOverhead, which is left out.
+func EstimatePromptTokens(f Features, params calibration.Params, tokensPerImage int, codeTokensPerByte float64, weights RoleWeights) int {
 	imageTokens := 0
 	if f.ImageCount > 0 {
 		if tokensPerImage <= 0 {
@@ -279,32 +316,101 @@ func EstimatePromptTokens(f Features, params calibration.Params, tokensPerImage
 		imageTokens = tokensPerImage * f.ImageCount
 	}
 
-	est := params.FixedOverhead + params.PerMessageOverhead*float64(f.MessageCount) + params.TokensPerByte*float64(f.TextBytes) + float64(imageTokens)
+	textBytes := f.WeightedTextBytes(weights)
+	textTokens := params.TokensPerByte * textBytes
+	if codeTokensPerByte > 0 && f.CodeBytes > 0 {
+		codeBytes := float64(min(f.CodeBytes, f.TextBytes))
+		textTokens = params.TokensPerByte*max(textBytes-f.WeightedCodeBytes(weights), 0) + codeTokensPerByte*codeBytes
+	}
+
+	fixedOverhead := params.FixedOverhead
+	if f.CustomTemplate {
+		fixedOverhead = 0
+	}
+	est := fixedOverhead + params.PerMessageOverhead*float64(f.MessageCount) + textTokens + float64(imageTokens)
 	if est < 0 {
 		est = 0
 	}
 	return int(math.Ceil(est))
 }
 
+// countStopSequences counts the non-empty strings in an options.stop value,
+// which Ollama accepts as a single string or a list.
+func countStopSequences(v any) int {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return 1
+		}
+	case []any:
+		n := 0
+		for _, s := range v {
+			if s, ok := s.(string); ok && s != "" {
+				n++
+			}
+		}
+		return n
+	}
+	return 0
+}
+
+// StopBudget scales a defaulted output budget (no num_predict) by whether
+// the client sent stop sequences. Factors of 0 or 1 leave it unchanged.
+type StopBudget struct {
+	WithStop float64 // applied when options.stop is set; below 1 trims
+	NoStop   float64 // applied to thinking requests without options.stop; above 1 grows
+	Thinking bool    // thinking is enabled for the request
+}
+
+// factor returns the multiplier for f, or 1.
+func (s StopBudget) factor(f Features) float64 {
+	switch {
+	case f.StopSequences > 0 && s.WithStop > 0:
+		return s.WithStop
+	case f.StopSequences == 0 && s.Thinking && s.NoStop > 0:
+		return s.NoStop
+	}
+	return 1
+}
+
 // OutputBudgetResult contains the calculated output budget and its source.
 type OutputBudgetResult struct {
 	Budget int
 	Source string // "explicit_num_predict", "dynamic_default", or "fixed_default"
+	// NumPredictClamped is true when the client's num_predict exceeded the
+	// per-model ceiling (negative values mean unlimited to Ollama) and was capped.
+	NumPredictClamped bool
+	// StructuredOverhead is how many tokens the structured-format overhead
+	// and JSON bump added after clamping (0 for unstructured requests).
+	StructuredOverhead int
+	// StopAdjustment is how many tokens stop scaled the defaulted budget by
+	// (negative when trimmed, 0 when unchanged).
+	StopAdjustment int
 }
 
 // BudgetOutputTokens chooses how many tokens we should reserve for generation.
 //
-// If options.num_predict is present, it always wins (clamped to maxBudget).
+// If options.num_predict is present, it always wins (clamped to numPredictCeiling
+// when > 0, then to maxBudget).
 // Otherwise, if dynamicDefault is true, computes a dynamic default based on promptTokens.
 // Otherwise, uses the fixed defaultBudget.
-func BudgetOutputTokens(f Features, defaultBudget, maxBudget, structuredOverhead int, dynamicDefault bool, promptTokens int) OutputBudgetResult {
+// Defaulted budgets are then scaled by stop (see StopBudget).
+// Structured (format) requests then get structuredOverhead, plus jsonBump and
+// SchemaTokensPerProperty per schema property when num_predict is missing.
+func BudgetOutputTokens(f Features, defaultBudget, maxBudget, structuredOverhead, jsonBump int, dynamicDefault bool, promptTokens, numPredictCeiling int, stop StopBudget) OutputBudgetResult {
 	var budget int
 	var source string
+	var capped bool
+	var stopAdjust int
 
 	// options.num_predict always wins
 	if f.NumPredictOK {
 		budget = f.NumPredict
 		source = "explicit_num_predict"
+		if numPredictCeiling > 0 && (budget < 0 || budget > numPredictCeiling) {
+			budget = numPredictCeiling
+			capped = true
+		}
 	} else if dynamicDefault {
 		// Dynamic default: max(DEFAULT_OUTPUT_BUDGET, 256 + promptTokens/2)
 		dynamicDefault := int(math.Max(float64(defaultBudget), float64(256+promptTokens/2)))
@@ -324,12 +430,20 @@ func BudgetOutputTokens(f Features, defaultBudget, maxBudget, structuredOverhead
 		budget = maxBudget
 	}
 
+	if factor := stop.factor(f); !f.NumPredictOK && factor != 1 {
+		scaled := min(int(math.Ceil(float64(budget)*factor)), maxBudget)
+		stopAdjust = scaled - budget
+		budget = scaled
+	}
+
 	// Add structured overhead if format is JSON
+	base := budget
 	if f.Structured {
 		budget += structuredOverhead
-		// Optional: add extra bump for JSON when num_predict is not explicitly set
+		// Optional: add extra bump for JSON when num_predict is not explicitly set,
+		// growing with the number of schema properties to fill in.
 		if !f.NumPredictOK {
-			budget += 256
+			budget += jsonBump + f.SchemaProperties*SchemaTokensPerProperty
 			// Re-clamp after adding JSON bump
 			if budget > maxBudget {
 				budget = maxBudget
@@ -337,18 +451,31 @@ func BudgetOutputTokens(f Features, defaultBudget, maxBudget, structuredOverhead
 		}
 	}
 
-	return OutputBudgetResult{Budget: budget, Source: source}
+	return OutputBudgetResult{Budget: budget, Source: source, NumPredictClamped: capped, StructuredOverhead: budget - base, StopAdjustment: stopAdjust}
 }
 
-// ApplyHeadroom inflates needed tokens by a safety factor.
-func ApplyHeadroom(neededTokens int, headroom float64) int {
+// ConfidenceHeadroom scales headroom by how far the calibration behind an
+// estimate can be trusted: by lowFactor at confidence 0, by highFactor at 1
+// and linearly in between.
+func ConfidenceHeadroom(headroom, confidence, lowFactor, highFactor float64) float64 {
+	confidence = math.Max(0, math.Min(confidence, 1))
+	return headroom * (lowFactor + (highFactor-lowFactor)*confidence)
+}
+
+// ApplyHeadroom inflates needed tokens by a safety factor, adding at least
+// minAbsolute tokens: max(needed*headroom, needed+minAbsolute).
+func ApplyHeadroom(neededTokens int, headroom float64, minAbsolute int) int {
 	if neededTokens <= 0 {
 		return 0
 	}
 	if headroom < 1.0 {
 		headroom = 1.0
 	}
-	return int(math.Ceil(float64(neededTokens) * headroom))
+	withHeadroom := int(math.Ceil(float64(neededTokens) * headroom))
+	if minAbsolute > 0 && neededTokens+minAbsolute > withHeadroom {
+		return neededTokens + minAbsolute
+	}
+	return withHeadroom
 }
 
 // Bucketize picks the smallest bucket that is >= neededTokens.
@@ -362,6 +489,20 @@ func Bucketize(neededTokens int, buckets []int) int {
 	return neededTokens
 }
 
+// PreferCtx returns preferred in place of bucket when neededTokens fits in
+// it and is at least 1-tolerance of it, so requests of similar size share
+// one context instead of moving between buckets. ok reports whether
+// preferred replaced a different bucket; preferred <= 0 means none.
+func PreferCtx(neededTokens, bucket, preferred int, tolerance float64) (ctx int, ok bool) {
+	if preferred <= 0 || bucket == preferred || neededTokens > preferred {
+		return bucket, false
+	}
+	if float64(neededTokens) < float64(preferred)*(1-tolerance) {
+		return bucket, false
+	}
+	return preferred, true
+}
+
 // ClampCtx clamps ctx to [min,max]. max==0 means no upper bound.
 func ClampCtx(ctx, min, max int) int {
 	if ctx < min {
@@ -1,6 +1,15 @@
 package estimate
 
-import "testing"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/calibration"
+)
 
 func TestBucketize(t *testing.T) {
 	buckets := []int{2048, 4096, 8192}
@@ -18,6 +27,26 @@ func TestBucketize(t *testing.T) {
 	}
 }
 
+func TestPreferCtx(t *testing.T) {
+	cases := []struct {
+		needed, bucket, preferred int
+		want                      int
+		ok                        bool
+	}{
+		{3000, 4096, 8192, 4096, false}, // below the tolerance band
+		{5000, 8192, 8192, 8192, false}, // the bucket already is preferred
+		{4500, 6144, 8192, 8192, true},
+		{8192, 8192, 6000, 8192, false}, // needs more than preferred
+		{5000, 6144, 6000, 6000, true},  // preferred needn't be a bucket
+		{100, 1024, 0, 1024, false},
+	}
+	for _, c := range cases {
+		if got, ok := PreferCtx(c.needed, c.bucket, c.preferred, 0.5); got != c.want || ok != c.ok {
+			t.Errorf("PreferCtx(%d, %d, %d) = %d, %v; want %d, %v", c.needed, c.bucket, c.preferred, got, ok, c.want, c.ok)
+		}
+	}
+}
+
 func TestClampCtx(t *testing.T) {
 	if got := ClampCtx(1000, 2048, 8192); got != 2048 {
 		t.Fatalf("expected 2048, got %d", got)
@@ -29,3 +58,451 @@ func TestClampCtx(t *testing.T) {
 		t.Fatalf("expected 4096, got %d", got)
 	}
 }
+
+func TestSampleFeaturesImages(t *testing.T) {
+	img := strings.Repeat("A", 4000)
+	body := []byte(`{"model":"llava","messages":[{"role":"user","content":"what is this?","images":["` + img + `","` + img + `"]}]}`)
+
+	// Image data is counted as images, not text.
+	f := SampleFeatures(EndpointChat, body, int64(len(body)))
+	if f.ImageCount != 2 {
+		t.Fatalf("expected 2 images, got %d", f.ImageCount)
+	}
+	// "user" + "what is this?"
+	if f.TextBytes != 4+13 {
+		t.Fatalf("expected 17 text bytes, got %d", f.TextBytes)
+	}
+
+	// An image truncated at the end of the sample still counts, and is
+	// scaled like the other counts.
+	prefix := body[:len(body)-len(img)/2]
+	f = SampleFeatures(EndpointChat, prefix, int64(len(prefix))*2)
+	if f.ImageCount != 4 {
+		t.Fatalf("expected 2 images scaled 2x, got %d", f.ImageCount)
+	}
+	if f.TextBytes != (4+13)*2 {
+		t.Fatalf("expected 34 text bytes, got %d", f.TextBytes)
+	}
+}
+
+func TestSampleFeaturesScalesPrefix(t *testing.T) {
+	body := []byte(`{"model":"llama3","messages":[{"role":"user","content":"hello world"},{"role":"assistant","content":"hi there!!"}]}`)
+
+	// Whole body as the sample: no scaling.
+	f := SampleFeatures(EndpointChat, body, int64(len(body)))
+	if f.Model != "llama3" {
+		t.Fatalf("expected model llama3, got %q", f.Model)
+	}
+	if f.MessageCount != 2 {
+		t.Fatalf("expected 2 messages, got %d", f.MessageCount)
+	}
+	// "user" + "hello world" + "assistant" + "hi there!!"
+	if f.TextBytes != 4+11+9+10 {
+		t.Fatalf("expected 34 text bytes, got %d", f.TextBytes)
+	}
+
+	// A truncated prefix of a body 10x larger scales counts up.
+	prefix := body[:60]
+	full := SampleFeatures(EndpointChat, prefix, int64(len(prefix))*10)
+	single := SampleFeatures(EndpointChat, prefix, int64(len(prefix)))
+	if full.Model != "llama3" {
+		t.Fatalf("expected model from truncated prefix, got %q", full.Model)
+	}
+	if full.TextBytes != single.TextBytes*10 {
+		t.Fatalf("expected text bytes scaled 10x, got %d vs %d", full.TextBytes, single.TextBytes)
+	}
+	if full.MessageCount != single.MessageCount*10 {
+		t.Fatalf("expected message count scaled 10x, got %d vs %d", full.MessageCount, single.MessageCount)
+	}
+}
+
+func TestExtractGenerateArrayPrompt(t *testing.T) {
+	req := map[string]any{
+		"model":  "llama3",
+		"prompt": []any{"hello", "batched", "world!"},
+		"system": "sys",
+	}
+	f, err := ExtractFeatures(EndpointGenerate, req)
+	if err != nil {
+		t.Fatalf("ExtractFeatures error: %v", err)
+	}
+	if f.TextBytes != 5+7+6+3 {
+		t.Fatalf("expected 21 text bytes, got %d", f.TextBytes)
+	}
+	if f.MessageCount != 3 {
+		t.Fatalf("expected 3 messages, got %d", f.MessageCount)
+	}
+
+	// A plain string prompt still counts no messages.
+	req["prompt"] = "hello"
+	f, _ = ExtractFeatures(EndpointGenerate, req)
+	if f.TextBytes != 5+3 || f.MessageCount != 0 {
+		t.Fatalf("expected 8 bytes/0 messages, got %d/%d", f.TextBytes, f.MessageCount)
+	}
+}
+
+func TestEstimatePromptTokensCustomTemplate(t *testing.T) {
+	template := "{{ .System }} {{ .Prompt }}"
+	req := map[string]any{
+		"model":    "llama3",
+		"prompt":   strings.Repeat("x", 400),
+		"template": template,
+	}
+	f, err := ExtractFeatures(EndpointGenerate, req)
+	if err != nil {
+		t.Fatalf("ExtractFeatures error: %v", err)
+	}
+	if !f.CustomTemplate || f.TextBytes != 400+len(template) {
+		t.Fatalf("expected a custom template counted in text bytes, got %v/%d", f.CustomTemplate, f.TextBytes)
+	}
+
+	// The template replaces the model's, so its fixed overhead is dropped.
+	params := calibration.Params{TokensPerByte: 0.25, FixedOverhead: 32}
+	with := EstimatePromptTokens(f, params, 0, 0, RoleWeights{})
+	f.CustomTemplate = false
+	without := EstimatePromptTokens(f, params, 0, 0, RoleWeights{})
+	if want := int(math.Ceil(0.25 * float64(400+len(template)))); with != want || without != want+32 {
+		t.Fatalf("expected %d with the template adjustment and %d without, got %d and %d", want, want+32, with, without)
+	}
+
+	// An empty template keeps the model's.
+	req["template"] = ""
+	if f, _ := ExtractFeatures(EndpointGenerate, req); f.CustomTemplate {
+		t.Fatal("empty template treated as custom")
+	}
+}
+
+func TestApplyHeadroomAbsoluteFloor(t *testing.T) {
+	// Small prompt: 1.25x of 50 adds 13 tokens, the floor adds 256.
+	if got := ApplyHeadroom(50, 1.25, 256); got != 306 {
+		t.Fatalf("expected absolute floor to dominate (306), got %d", got)
+	}
+	// Large prompt: 1.25x of 8000 adds 2000, more than the floor.
+	if got := ApplyHeadroom(8000, 1.25, 256); got != 10000 {
+		t.Fatalf("expected multiplier to dominate (10000), got %d", got)
+	}
+	// No floor keeps the multiplier-only behavior.
+	if got := ApplyHeadroom(50, 1.25, 0); got != 63 {
+		t.Fatalf("expected 63, got %d", got)
+	}
+	if got := ApplyHeadroom(0, 1.25, 256); got != 0 {
+		t.Fatalf("expected 0 for no tokens, got %d", got)
+	}
+}
+
+func TestCountInvalidImages(t *testing.T) {
+	req := map[string]any{
+		"model": "llava",
+		"messages": []any{
+			map[string]any{"role": "user", "content": "compare", "images": []any{
+				"iVBORw0KGgo=", // valid
+				"",             // empty
+				"not base64!",  // bad alphabet
+				"aGVsbG8",      // bad length
+				"aGVs\nbG8=",   // valid with a line break
+				42,             // not a string
+			}},
+		},
+	}
+	if got := CountInvalidImages(EndpointChat, req); got != 4 {
+		t.Fatalf("expected 4 invalid images, got %d", got)
+	}
+
+	gen := map[string]any{"model": "llava", "images": []any{"aGk=", "a=b="}}
+	if got := CountInvalidImages(EndpointGenerate, gen); got != 1 {
+		t.Fatalf("expected 1 invalid image, got %d", got)
+	}
+}
+
+func TestDropImages(t *testing.T) {
+	req := map[string]any{
+		"model": "llava",
+		"messages": []any{
+			map[string]any{"role": "user", "content": "a", "images": []any{"a1", "a2"}},
+			map[string]any{"role": "assistant", "content": "ok"},
+			map[string]any{"role": "user", "content": "b", "images": []any{"b1", "b2"}},
+		},
+	}
+	if got := DropImages(EndpointChat, req, 3); got != 1 {
+		t.Fatalf("expected 1 dropped image, got %d", got)
+	}
+	msgs := req["messages"].([]any)
+	first := msgs[0].(map[string]any)["images"].([]any)
+	last := msgs[2].(map[string]any)["images"].([]any)
+	if len(first) != 1 || first[0] != "a2" || len(last) != 2 {
+		t.Fatalf("expected the oldest image dropped, got %v and %v", first, last)
+	}
+	if got := DropImages(EndpointChat, req, 3); got != 0 {
+		t.Fatalf("expected nothing dropped at the limit, got %d", got)
+	}
+
+	gen := map[string]any{"model": "llava", "images": []any{"g1", "g2", "g3"}}
+	if got := DropImages(EndpointGenerate, gen, 1); got != 2 {
+		t.Fatalf("expected 2 dropped images, got %d", got)
+	}
+	if imgs := gen["images"].([]any); len(imgs) != 1 || imgs[0] != "g1" {
+		t.Fatalf("expected the first image kept, got %v", imgs)
+	}
+}
+
+func TestCountCodeBytes(t *testing.T) {
+	prose := "Can you explain why this function is slow when the input list gets large?\n\n"
+	fenced := "```go\nfor i := range xs {\n\tsum += xs[i]\n}\n```\n"
+	unfenced := "func add(a, b int) int { return a + b }; var x = add(1, 2) // (x == 3)\n"
+	req := map[string]any{
+		"model": "qwen2.5-coder",
+		"messages": []any{
+			map[string]any{"role": "system", "content": "You are a helpful assistant."},
+			map[string]any{"role": "user", "content": prose + fenced + "\n" + unfenced},
+		},
+	}
+	got, roles := CountCodeBytes(EndpointChat, req)
+	if want := len(fenced) + len(unfenced); got != want || roles != (RoleBytes{User: want}) {
+		t.Fatalf("code bytes = %d (roles %+v), want %d from the user", got, roles, want)
+	}
+
+	gen := map[string]any{"model": "m", "prompt": prose, "system": fenced}
+	if got, roles := CountCodeBytes(EndpointGenerate, gen); got != len(fenced) || roles != (RoleBytes{System: len(fenced)}) {
+		t.Fatalf("generate code bytes = %d (roles %+v), want %d from the system", got, roles, len(fenced))
+	}
+
+	// An unterminated fence runs to the end of the text.
+	if got := codeBytes("see:\n```\nx = 1\ny = 2"); got != len("```\nx = 1\ny = 2") {
+		t.Fatalf("unterminated fence code bytes = %d", got)
+	}
+}
+
+func TestEstimatePromptTokensCodeSplit(t *testing.T) {
+	params := calibration.Params{TokensPerByte: 0.25}
+	f := Features{TextBytes: 1000, CodeBytes: 400}
+	if got := EstimatePromptTokens(f, params, 0, 0, RoleWeights{}); got != 250 {
+		t.Fatalf("without a code rate all bytes use TokensPerByte, got %d", got)
+	}
+	if got := EstimatePromptTokens(f, params, 0, 0.5, RoleWeights{}); got != 350 {
+		t.Fatalf("expected 600*0.25 + 400*0.5 = 350, got %d", got)
+	}
+
+	// Role weights apply to the code's bytes before they are taken off the
+	// text: 600 bytes of assistant text, 400 of them code, and 400 of user
+	// prose.
+	f = Features{
+		TextBytes: 1000, Roles: RoleBytes{User: 400, Assistant: 600},
+		CodeBytes: 400, CodeRoles: RoleBytes{Assistant: 400},
+	}
+	w := RoleWeights{Assistant: 0.5}
+	if got := EstimatePromptTokens(f, params, 0, 0.5, w); got != 325 {
+		t.Fatalf("expected (400 + 200*0.5)*0.25 + 400*0.5 = 325, got %d", got)
+	}
+}
+
+func TestRoleWeightsHistoryHeavyChat(t *testing.T) {
+	// A long conversation: a short instruction, then many turns of
+	// assistant history with tool output.
+	msgs := []any{map[string]any{"role": "system", "content": strings.Repeat("s", 200)}}
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs,
+			map[string]any{"role": "user", "content": strings.Repeat("u", 100)},
+			map[string]any{"role": "assistant", "content": strings.Repeat("a", 700)},
+			map[string]any{"role": "tool", "content": strings.Repeat("t", 200)},
+		)
+	}
+	f, err := ExtractFeatures(EndpointChat, map[string]any{"model": "m", "messages": msgs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RoleBytes{System: 200, User: 1000, Assistant: 7000, Tool: 2000}
+	if f.Roles != want || f.TextBytes != 10200 {
+		t.Fatalf("roles = %+v (text %d), want %+v (text 10200)", f.Roles, f.TextBytes, want)
+	}
+
+	params := calibration.Params{TokensPerByte: 0.25}
+	if got := EstimatePromptTokens(f, params, 0, 0, RoleWeights{}); got != 2550 {
+		t.Fatalf("unweighted estimate = %d, want 2550", got)
+	}
+	w := RoleWeights{Assistant: 0.6, Tool: 1.5}
+	// (200 + 1000 + 7000*0.6 + 2000*1.5) * 0.25
+	if got := EstimatePromptTokens(f, params, 0, 0, w); got != 2100 {
+		t.Fatalf("weighted estimate = %d, want 2100", got)
+	}
+}
+
+func TestBudgetOutputTokensNumPredictCeiling(t *testing.T) {
+	f := Features{NumPredict: 8000, NumPredictOK: true}
+
+	got := BudgetOutputTokens(f, 1024, 10240, 0, 256, false, 100, 2048, StopBudget{})
+	if got.Budget != 2048 || !got.NumPredictClamped {
+		t.Fatalf("expected ceiling to clamp to 2048, got %+v", got)
+	}
+	// No ceiling for this model: only the global max applies.
+	got = BudgetOutputTokens(f, 1024, 10240, 0, 256, false, 100, 0, StopBudget{})
+	if got.Budget != 8000 || got.NumPredictClamped {
+		t.Fatalf("expected 8000 unclamped, got %+v", got)
+	}
+	// -1 is unlimited to Ollama, so the ceiling applies.
+	got = BudgetOutputTokens(Features{NumPredict: -1, NumPredictOK: true}, 1024, 10240, 0, 256, false, 100, 2048, StopBudget{})
+	if got.Budget != 2048 || !got.NumPredictClamped {
+		t.Fatalf("expected unlimited num_predict clamped to 2048, got %+v", got)
+	}
+	// Defaults are not client values and are left alone.
+	got = BudgetOutputTokens(Features{}, 4096, 10240, 0, 256, false, 100, 2048, StopBudget{})
+	if got.Budget != 4096 || got.NumPredictClamped {
+		t.Fatalf("expected default budget untouched, got %+v", got)
+	}
+}
+
+func TestBudgetOutputTokensStructured(t *testing.T) {
+	f := Features{Structured: true, SchemaProperties: 2}
+
+	got := BudgetOutputTokens(f, 1024, 10240, 64, 100, false, 100, 0, StopBudget{})
+	if want := 64 + 100 + 2*SchemaTokensPerProperty; got.Budget != 1024+want || got.StructuredOverhead != want {
+		t.Errorf("got %+v, want budget %d with structured overhead %d", got, 1024+want, want)
+	}
+	// The recorded overhead reflects the re-clamp to maxBudget.
+	got = BudgetOutputTokens(f, 1024, 1100, 64, 100, false, 100, 0, StopBudget{})
+	if got.Budget != 1100 || got.StructuredOverhead != 76 {
+		t.Errorf("got %+v, want budget 1100 with structured overhead 76", got)
+	}
+	if got := BudgetOutputTokens(Features{}, 1024, 10240, 64, 100, false, 100, 0, StopBudget{}); got.StructuredOverhead != 0 {
+		t.Errorf("unstructured request got structured overhead %d", got.StructuredOverhead)
+	}
+}
+
+func TestBudgetOutputTokensStop(t *testing.T) {
+	var req map[string]any
+	if err := json.Unmarshal([]byte(`{"model":"qwen3","prompt":"hi","options":{"stop":["\n\n","","END"]}}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ExtractFeatures(EndpointGenerate, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.StopSequences != 2 {
+		t.Fatalf("StopSequences = %d, want 2 (empty strings ignored)", f.StopSequences)
+	}
+
+	stop := StopBudget{WithStop: 0.5, NoStop: 2, Thinking: true}
+	got := BudgetOutputTokens(f, 1024, 10240, 0, 0, false, 100, 0, stop)
+	if got.Budget != 512 || got.StopAdjustment != -512 {
+		t.Errorf("with stop: got %+v, want budget 512 trimmed by 512", got)
+	}
+
+	// Thinking without stop sequences grows, up to maxBudget.
+	got = BudgetOutputTokens(Features{}, 1024, 1500, 0, 0, false, 100, 0, stop)
+	if got.Budget != 1500 || got.StopAdjustment != 476 {
+		t.Errorf("thinking without stop: got %+v, want budget 1500 grown by 476", got)
+	}
+	stop.Thinking = false
+	if got := BudgetOutputTokens(Features{}, 1024, 10240, 0, 0, false, 100, 0, stop); got.Budget != 1024 || got.StopAdjustment != 0 {
+		t.Errorf("no thinking, no stop: got %+v, want unchanged 1024", got)
+	}
+
+	// An explicit num_predict is never scaled.
+	f.NumPredict, f.NumPredictOK = 800, true
+	if got := BudgetOutputTokens(f, 1024, 10240, 0, 0, false, 100, 0, stop); got.Budget != 800 || got.StopAdjustment != 0 {
+		t.Errorf("explicit num_predict: got %+v, want unchanged 800", got)
+	}
+}
+
+func TestSchemaPropertiesWithDefs(t *testing.T) {
+	const body = `{
+		"model": "llama3",
+		"messages": [{"role": "user", "content": "extract"}],
+		"format": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"home": {"$ref": "#/$defs/Address"},
+				"work": {"$ref": "#/$defs/Address"},
+				"tree": {"$ref": "#/$defs/Node"}
+			},
+			"$defs": {
+				"Address": {"type": "object", "properties": {"street": {"type": "string"}, "city": {"type": "string"}}},
+				"Node": {
+					"type": "object",
+					"properties": {
+						"value": {"type": "integer"},
+						"children": {"type": "array", "items": {"$ref": "#/$defs/Node"}}
+					}
+				},
+				"Unused": {"type": "object", "properties": {"a": {}, "b": {}, "c": {}}}
+			}
+		}
+	}`
+	var req map[string]any
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ExtractFeatures(EndpointChat, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 top-level + 2 per Address reference + 2 for Node; the cyclic
+	// children ref and the unreferenced definition add nothing.
+	if !f.Structured || f.SchemaProperties != 10 {
+		t.Fatalf("Structured/SchemaProperties = %v/%d, want true/10", f.Structured, f.SchemaProperties)
+	}
+
+	got := BudgetOutputTokens(f, 1024, 10240, 128, 256, false, 100, 0, StopBudget{})
+	if want := 1024 + 128 + 256 + 10*SchemaTokensPerProperty; got.Budget != want {
+		t.Errorf("budget = %d, want %d", got.Budget, want)
+	}
+
+	// An explicit num_predict still wins over the schema bump.
+	f.NumPredict, f.NumPredictOK = 512, true
+	if got := BudgetOutputTokens(f, 1024, 10240, 128, 256, false, 100, 0, StopBudget{}); got.Budget != 512+128 {
+		t.Errorf("budget with num_predict = %d, want %d", got.Budget, 512+128)
+	}
+}
+
+func TestCountSchemaPropertiesSelfReference(t *testing.T) {
+	// A linked list referencing the root is expanded once, then cut off.
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"next": map[string]any{"$ref": "#"},
+		},
+	}
+	if got := CountSchemaProperties(schema); got != 2 {
+		t.Errorf("CountSchemaProperties = %d, want 2", got)
+	}
+}
+
+func TestCountSchemaPropertiesDiamondRefs(t *testing.T) {
+	// 30 definitions, each an anyOf of four refs to the next: expanding every
+	// path would visit 4^30 nodes.
+	defs := map[string]any{}
+	for i := 0; i < 30; i++ {
+		next := map[string]any{"$ref": fmt.Sprintf("#/$defs/D%d", i+1)}
+		defs[fmt.Sprintf("D%d", i)] = map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"v": map[string]any{"type": "integer"}},
+			"anyOf":      []any{next, next, next, next},
+		}
+	}
+	defs["D30"] = map[string]any{"type": "object", "properties": map[string]any{"v": map[string]any{}}}
+	schema := map[string]any{"$ref": "#/$defs/D0", "$defs": defs}
+
+	done := make(chan int, 1)
+	go func() { done <- CountSchemaProperties(schema) }()
+	select {
+	case got := <-done:
+		if got != maxSchemaProperties {
+			t.Errorf("CountSchemaProperties = %d, want the %d cap", got, maxSchemaProperties)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CountSchemaProperties did not finish on a diamond-shaped schema")
+	}
+}
+
+func TestConfidenceHeadroom(t *testing.T) {
+	if got := ConfidenceHeadroom(1.25, 0, 1.2, 0.9); math.Abs(got-1.5) > 1e-9 {
+		t.Errorf("no confidence: headroom = %v, want 1.5", got)
+	}
+	if got := ConfidenceHeadroom(1.25, 1, 1.2, 0.9); math.Abs(got-1.125) > 1e-9 {
+		t.Errorf("full confidence: headroom = %v, want 1.125", got)
+	}
+	if got := ConfidenceHeadroom(1.25, 5, 1.2, 0.9); math.Abs(got-1.125) > 1e-9 {
+		t.Errorf("confidence above 1 should be bounded, got %v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package estimate
+
+// CountInvalidImages counts image entries that are not a non-empty base64
+// string: top-level "images" for generate, per-message "images" for chat.
+// Such entries add no image tokens and may make Ollama fail the request.
+func CountInvalidImages(endpoint string, req map[string]any) int {
+	invalid := 0
+	count := func(v any) {
+		imgs, ok := v.([]any)
+		if !ok {
+			return
+		}
+		for _, img := range imgs {
+			if s, ok := img.(string); !ok || !validBase64(s) {
+				invalid++
+			}
+		}
+	}
+
+	switch endpoint {
+	case EndpointGenerate:
+		count(req["images"])
+	case EndpointChat:
+		msgs, _ := req["messages"].([]any)
+		for _, m := range msgs {
+			if mm, ok := m.(map[string]any); ok {
+				count(mm["images"])
+			}
+		}
+	}
+	return invalid
+}
+
+// validBase64 reports whether s is non-empty standard base64. Line breaks are
+// ignored (as encoding/base64 does); padding may only appear at the end. It
+// scans in place so large images aren't decoded just to be checked.
+func validBase64(s string) bool {
+	n, pad := 0, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\r' || c == '\n':
+			continue
+		case c == '=':
+			pad++
+			if pad > 2 {
+				return false
+			}
+		case pad > 0:
+			return false
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '+', c == '/':
+		default:
+			return false
+		}
+		n++
+	}
+	return n > 0 && n%4 == 0 && n > pad
+}
+
+// DropImages removes image entries so at most keep remain and returns how
+// many were removed. Generate keeps the first entries of "images"; chat drops
+// from the oldest messages first, keeping the images of the latest turns.
+func DropImages(endpoint string, req map[string]any, keep int) int {
+	if keep < 0 {
+		keep = 0
+	}
+	switch endpoint {
+	case EndpointGenerate:
+		imgs, ok := req["images"].([]any)
+		if !ok || len(imgs) <= keep {
+			return 0
+		}
+		req["images"] = imgs[:keep]
+		return len(imgs) - keep
+	case EndpointChat:
+		msgs, _ := req["messages"].([]any)
+		dropped := 0
+		for i := len(msgs) - 1; i >= 0; i-- {
+			mm, ok := msgs[i].(map[string]any)
+			if !ok {
+				continue
+			}
+			imgs, ok := mm["images"].([]any)
+			if !ok {
+				continue
+			}
+			n := min(len(imgs), keep)
+			keep -= n
+			if n < len(imgs) {
+				dropped += len(imgs) - n
+				mm["images"] = imgs[len(imgs)-n:]
+			}
+		}
+		return dropped
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package estimate
+
+// RoleBytes splits a request's text bytes by the role they came from. Chat
+// messages count toward their role (tool_calls toward the message's role);
+// for generate, system is System and prompt is User. Tool definitions,
+// suffix and template belong to no role.
+type RoleBytes struct {
+	System    int
+	User      int
+	Assistant int
+	Tool      int
+}
+
+// RoleWeights scale the estimated tokens of each role's bytes relative to
+// the model's tokens per byte, e.g. Assistant 0.8 for chats whose history
+// tokenizes sparser than the instructions. 0 means 1 (unweighted).
+// config.RoleWeights parses them from ROLE_WEIGHTS and converts to this.
+type RoleWeights struct {
+	System    float64
+	User      float64
+	Assistant float64
+	Tool      float64
+}
+
+// addRole adds n bytes of text from a chat message with the given role.
+func (b *RoleBytes) addRole(role string, n int) {
+	switch role {
+	case "system":
+		b.System += n
+	case "user":
+		b.User += n
+	case "assistant":
+		b.Assistant += n
+	case "tool":
+		b.Tool += n
+	}
+}
+
+// WeightedTextBytes returns f.TextBytes with each role's bytes scaled by its
+// weight; bytes outside any role keep weight 1.
+func (f Features) WeightedTextBytes(w RoleWeights) float64 {
+	return weighBytes(f.TextBytes, f.Roles, w)
+}
+
+// WeightedCodeBytes is WeightedTextBytes for the code part of the text:
+// f.CodeBytes (at most f.TextBytes) with each role's code bytes scaled.
+func (f Features) WeightedCodeBytes(w RoleWeights) float64 {
+	return weighBytes(min(f.CodeBytes, f.TextBytes), f.CodeRoles, w)
+}
+
+// weighBytes scales the part of total attributed to each role by its weight.
+func weighBytes(total int, roles RoleBytes, w RoleWeights) float64 {
+	weighted := float64(total)
+	for _, r := range []struct {
+		bytes  int
+		weight float64
+	}{
+		{roles.System, w.System},
+		{roles.User, w.User},
+		{roles.Assistant, w.Assistant},
+		{roles.Tool, w.Tool},
+	} {
+		if r.weight > 0 {
+			weighted += (r.weight - 1) * float64(r.bytes)
+		}
+	}
+	return max(weighted, 0)
+}
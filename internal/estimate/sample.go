@@ -0,0 +1,97 @@
+package estimate
+
+// SampleFeatures approximates Features from the first bytes of a request body
+// that is too large to buffer and decode.
+//
+// The prefix is scanned lexically (it is usually truncated mid-document, so it
+// cannot be decoded): string values count as text bytes, strings under an
+// "images" key count as images, "role" keys count as messages, and the first
+// "model" value names the model. Counts are then scaled by totalLen/len(prefix)
+// so the estimate reflects the whole body. Options beyond the prefix are
+// invisible, so the result is a rough lower-confidence estimate only.
+func SampleFeatures(endpoint string, prefix []byte, totalLen int64) Features {
+	f := Features{Endpoint: endpoint}
+	if len(prefix) == 0 {
+		return f
+	}
+
+	var (
+		textBytes int
+		roles     int
+		images    int
+		lastKey   string
+	)
+
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] != '"' {
+			continue
+		}
+
+		// Scan to the closing quote, skipping escapes.
+		start := i + 1
+		end := -1
+		for j := start; j < len(prefix); j++ {
+			if prefix[j] == '\\' {
+				j++
+				continue
+			}
+			if prefix[j] == '"' {
+				end = j
+				break
+			}
+		}
+		if end == -1 {
+			// Truncated string at the end of the sample.
+			if lastKey == "images" {
+				images++
+			} else {
+				textBytes += len(prefix) - start
+			}
+			break
+		}
+		i = end
+
+		// A string followed by ':' is a key, otherwise a value.
+		next := end + 1
+		for next < len(prefix) && isJSONSpace(prefix[next]) {
+			next++
+		}
+		if next < len(prefix) && prefix[next] == ':' {
+			lastKey = string(prefix[start:end])
+			switch lastKey {
+			case "role":
+				roles++
+			case "format":
+				f.Structured = true
+			}
+			continue
+		}
+
+		if lastKey == "model" && f.Model == "" {
+			f.Model = string(prefix[start:end])
+			continue
+		}
+		// Base64 image data is sized per image, not as text.
+		if lastKey == "images" {
+			images++
+			continue
+		}
+		textBytes += end - start
+	}
+
+	scale := 1.0
+	if totalLen > int64(len(prefix)) {
+		scale = float64(totalLen) / float64(len(prefix))
+	}
+	f.TextBytes = int(float64(textBytes) * scale)
+	f.ImageCount = int(float64(images) * scale)
+	if endpoint == EndpointChat {
+		f.MessageCount = int(float64(roles) * scale)
+	}
+
+	return f
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
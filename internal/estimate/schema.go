@@ -0,0 +1,105 @@
+package estimate
+
+import "strings"
+
+// SchemaTokensPerProperty is the output budget reserved per JSON schema
+// property when the client didn't set num_predict: each one costs at least
+// its key, quotes and a value.
+const SchemaTokensPerProperty = 16
+
+const (
+	maxSchemaDepth      = 32
+	maxSchemaProperties = 1000
+)
+
+// CountSchemaProperties counts the properties a value matching schema will
+// contain, following local $ref pointers into $defs/definitions. Nested
+// objects, array items and anyOf/oneOf/allOf branches are included; a
+// definition is counted again each time it is referenced, except while it is
+// already being expanded (cyclic refs count once). Unreferenced definitions
+// add nothing. Each definition is expanded only once and its count reused,
+// so schemas whose refs fan out to shared definitions stay cheap; the count
+// is capped at maxSchemaProperties.
+func CountSchemaProperties(schema map[string]any) int {
+	w := schemaWalker{root: schema, expanding: make(map[string]bool), counted: make(map[string]int)}
+	return w.walk(schema, 0)
+}
+
+type schemaWalker struct {
+	root      map[string]any
+	expanding map[string]bool // refs on the current path
+	counted   map[string]int  // properties per expanded ref
+}
+
+// walk returns the properties under node, capped at maxSchemaProperties.
+func (w *schemaWalker) walk(node map[string]any, depth int) int {
+	if node == nil || depth > maxSchemaDepth {
+		return 0
+	}
+	count := 0
+	add := func(n int) { count = min(count+n, maxSchemaProperties) }
+
+	if ref, ok := node["$ref"].(string); ok {
+		if n, ok := w.counted[ref]; ok {
+			add(n)
+		} else if target := w.resolve(ref); target != nil && !w.expanding[ref] {
+			w.expanding[ref] = true
+			n := w.walk(target, depth+1)
+			delete(w.expanding, ref)
+			w.counted[ref] = n
+			add(n)
+		}
+	}
+
+	if props, ok := node["properties"].(map[string]any); ok {
+		for _, p := range props {
+			add(1)
+			if sub, ok := p.(map[string]any); ok {
+				add(w.walk(sub, depth+1))
+			}
+			if count >= maxSchemaProperties {
+				return count
+			}
+		}
+	}
+
+	for _, key := range []string{"items", "additionalProperties"} {
+		if sub, ok := node[key].(map[string]any); ok {
+			add(w.walk(sub, depth+1))
+		}
+	}
+	for _, key := range []string{"items", "prefixItems", "anyOf", "oneOf", "allOf"} {
+		if list, ok := node[key].([]any); ok {
+			for _, item := range list {
+				if count >= maxSchemaProperties {
+					return count
+				}
+				if sub, ok := item.(map[string]any); ok {
+					add(w.walk(sub, depth+1))
+				}
+			}
+		}
+	}
+	return count
+}
+
+// resolve looks up a local JSON pointer such as "#/$defs/Address" ("#" is the root).
+func (w *schemaWalker) resolve(ref string) map[string]any {
+	if ref == "#" {
+		return w.root
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur any = w.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	target, _ := cur.(map[string]any)
+	return target
+}
@@ -0,0 +1,229 @@
+// Package family classifies Ollama model names into model families so think
+// handling, estimation defaults and loop detection can be tuned per family
+// instead of with scattered prefix checks.
+package family
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Family is a model family name.
+type Family string
+
+const (
+	Unknown   Family = ""
+	Qwen3     Family = "qwen3"
+	Qwen      Family = "qwen"
+	DeepSeek  Family = "deepseek"
+	GPTOSS    Family = "gpt-oss"
+	Llama     Family = "llama"
+	Mistral   Family = "mistral"
+	Gemma     Family = "gemma"
+	Phi       Family = "phi"
+	Vision    Family = "vision"
+	Embedding Family = "embedding"
+)
+
+// Known lists every family a rule may map to.
+var Known = []Family{Qwen3, Qwen, DeepSeek, GPTOSS, Llama, Mistral, Gemma, Phi, Vision, Embedding}
+
+// IsKnown reports whether name is a known family.
+func IsKnown(name string) bool {
+	for _, f := range Known {
+		if string(f) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRules map name patterns to families. A pattern is a prefix of the
+// lowercased model name (after any "namespace/" part); a leading '*' makes it a
+// substring match. Vision and embedding patterns are substrings so they win over
+// the base family of e.g. "llama3.2-vision" or "nomic-embed-text".
+var DefaultRules = map[string]Family{
+	"qwen3":      Qwen3,
+	"qwen":       Qwen,
+	"deepseek":   DeepSeek,
+	"gpt-oss":    GPTOSS,
+	"llama":      Llama,
+	"codellama":  Llama,
+	"mistral":    Mistral,
+	"mixtral":    Mistral,
+	"codestral":  Mistral,
+	"gemma":      Gemma,
+	"phi":        Phi,
+	"llava":      Vision,
+	"moondream":  Vision,
+	"minicpm-v":  Vision,
+	"*-vision":   Vision,
+	"qwen2.5vl":  Vision,
+	"*embed":     Embedding,
+	"bge":        Embedding,
+	"all-minilm": Embedding,
+}
+
+type rule struct {
+	pattern  string
+	contains bool
+	family   Family
+	user     bool
+}
+
+// Classifier maps model names to families. It is immutable and safe for concurrent use.
+type Classifier struct {
+	rules []rule
+}
+
+// NewClassifier builds a classifier from DefaultRules plus user rules
+// (pattern -> family name). User rules are checked first.
+func NewClassifier(userRules map[string]string) *Classifier {
+	c := &Classifier{}
+	for p, f := range userRules {
+		c.rules = append(c.rules, newRule(p, Family(f), true))
+	}
+	for p, f := range DefaultRules {
+		c.rules = append(c.rules, newRule(p, f, false))
+	}
+	// User rules first, then substring rules (vision/embedding variants beat
+	// base families), then longest pattern; ties broken by pattern for stability.
+	sort.Slice(c.rules, func(i, j int) bool {
+		a, b := c.rules[i], c.rules[j]
+		if a.user != b.user {
+			return a.user
+		}
+		if a.contains != b.contains {
+			return a.contains
+		}
+		if len(a.pattern) != len(b.pattern) {
+			return len(a.pattern) > len(b.pattern)
+		}
+		return a.pattern < b.pattern
+	})
+	return c
+}
+
+func newRule(pattern string, f Family, user bool) rule {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	r := rule{pattern: pattern, family: f, user: user}
+	if strings.HasPrefix(pattern, "*") {
+		r.pattern = strings.TrimPrefix(pattern, "*")
+		r.contains = true
+	}
+	return r
+}
+
+// Classify returns the family of model, or Unknown. Tags (":8b") are ignored.
+// A nil classifier uses DefaultRules only.
+func (c *Classifier) Classify(model string) Family {
+	if c == nil {
+		c = defaultClassifier
+	}
+	name := strings.ToLower(model)
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		return Unknown
+	}
+	for _, r := range c.rules {
+		if r.pattern == "" {
+			continue
+		}
+		if r.contains && strings.Contains(name, r.pattern) || !r.contains && strings.HasPrefix(name, r.pattern) {
+			return r.family
+		}
+	}
+	return Unknown
+}
+
+var defaultClassifier = NewClassifier(nil)
+
+// ThinkStyle describes how a family's "think" field is encoded.
+type ThinkStyle int
+
+const (
+	ThinkNone   ThinkStyle = iota // family doesn't support think
+	ThinkBool                     // true|false (qwen3, deepseek)
+	ThinkLevel                    // one of a fixed set of strings (gpt-oss: low|medium|high)
+	ThinkString                   // any non-empty string
+)
+
+// ThinkEncoding is a family's think style plus, for ThinkLevel, the values it accepts.
+type ThinkEncoding struct {
+	Style  ThinkStyle
+	Levels []string
+}
+
+// Think returns the built-in think style for f.
+func (f Family) Think() ThinkStyle {
+	switch f {
+	case Qwen3, DeepSeek:
+		return ThinkBool
+	case GPTOSS:
+		return ThinkLevel
+	}
+	return ThinkNone
+}
+
+// ThinkEncoding returns the built-in think encoding for f.
+func (f Family) ThinkEncoding() ThinkEncoding {
+	if f.Think() == ThinkLevel {
+		return ThinkEncoding{Style: ThinkLevel, Levels: []string{"low", "medium", "high"}}
+	}
+	return ThinkEncoding{Style: f.Think()}
+}
+
+// ParseThinkEncoding parses "none", "bool", "string" or "enum:<v1>|<v2>|...".
+func ParseThinkEncoding(s string) (ThinkEncoding, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "none":
+		return ThinkEncoding{Style: ThinkNone}, nil
+	case "bool":
+		return ThinkEncoding{Style: ThinkBool}, nil
+	case "string":
+		return ThinkEncoding{Style: ThinkString}, nil
+	}
+	list, ok := strings.CutPrefix(s, "enum:")
+	if !ok {
+		return ThinkEncoding{}, fmt.Errorf("unknown think encoding %q (want none, bool, string or enum:a|b)", s)
+	}
+	var levels []string
+	for _, v := range strings.Split(list, "|") {
+		if v = strings.TrimSpace(v); v != "" {
+			levels = append(levels, v)
+		}
+	}
+	if len(levels) == 0 {
+		return ThinkEncoding{}, fmt.Errorf("think encoding %q lists no values", s)
+	}
+	return ThinkEncoding{Style: ThinkLevel, Levels: levels}, nil
+}
+
+// Valid reports whether verdict is a think value e accepts.
+func (e ThinkEncoding) Valid(verdict string) bool {
+	switch e.Style {
+	case ThinkBool:
+		return verdict == "true" || verdict == "false"
+	case ThinkLevel:
+		return slices.Contains(e.Levels, verdict)
+	case ThinkString:
+		return verdict != ""
+	}
+	return false
+}
+
+// Encode converts a valid verdict into the value of the request's "think" field.
+func (e ThinkEncoding) Encode(verdict string) any {
+	if e.Style == ThinkBool {
+		return verdict == "true"
+	}
+	return verdict
+}
@@ -0,0 +1,117 @@
+package family
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	c := NewClassifier(nil)
+	tests := []struct {
+		model string
+		want  Family
+	}{
+		{"qwen3:8b", Qwen3},
+		{"QWEN3-coder:30b", Qwen3},
+		{"qwen2.5:7b", Qwen},
+		{"qwen2.5vl:7b", Vision},
+		{"deepseek-r1:14b", DeepSeek},
+		{"gpt-oss:20b", GPTOSS},
+		{"llama3.1:8b", Llama},
+		{"llama3.2-vision:11b", Vision},
+		{"codellama:13b", Llama},
+		{"mixtral:8x7b", Mistral},
+		{"gemma3:4b", Gemma},
+		{"nomic-embed-text:latest", Embedding},
+		{"mxbai-embed-large", Embedding},
+		{"library/llava:13b", Vision},
+		{"hf.co/someone/phi4-mini:Q4_K_M", Phi},
+		{"falcon:7b", Unknown},
+		{"", Unknown},
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.model); got != tt.want {
+			t.Errorf("Classify(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyUserRules(t *testing.T) {
+	c := NewClassifier(map[string]string{
+		"my-reasoner": "qwen3",
+		"llama3.2":    "mistral", // user rules beat built-ins
+		"*distill":    "deepseek",
+	})
+	tests := []struct {
+		model string
+		want  Family
+	}{
+		{"my-reasoner:latest", Qwen3},
+		{"llama3.2:3b", Mistral},
+		{"llama3.1:8b", Llama},
+		{"qwen2.5-distill:7b", DeepSeek},
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.model); got != tt.want {
+			t.Errorf("Classify(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestNilClassifierUsesDefaults(t *testing.T) {
+	var c *Classifier
+	if got := c.Classify("gpt-oss:120b"); got != GPTOSS {
+		t.Errorf("Classify = %q, want %q", got, GPTOSS)
+	}
+}
+
+func TestThinkStyle(t *testing.T) {
+	if Qwen3.Think() != ThinkBool || DeepSeek.Think() != ThinkBool {
+		t.Error("qwen3/deepseek should take boolean think")
+	}
+	if GPTOSS.Think() != ThinkLevel {
+		t.Error("gpt-oss should take think levels")
+	}
+	if Llama.Think() != ThinkNone || Unknown.Think() != ThinkNone {
+		t.Error("other families should not take think")
+	}
+}
+
+func TestParseThinkEncoding(t *testing.T) {
+	tests := []struct {
+		in      string
+		valid   []string
+		invalid []string
+		encoded any // encoding of valid[0]
+	}{
+		{"bool", []string{"true", "false"}, []string{"high", ""}, true},
+		{"string", []string{"anything"}, []string{""}, "anything"},
+		{"Enum:minimal|low| high", []string{"minimal", "high"}, []string{"medium", "true"}, "minimal"},
+		{"none", nil, []string{"true", "low"}, nil},
+	}
+	for _, tt := range tests {
+		enc, err := ParseThinkEncoding(tt.in)
+		if err != nil {
+			t.Fatalf("ParseThinkEncoding(%q) error = %v", tt.in, err)
+		}
+		for _, v := range tt.valid {
+			if !enc.Valid(v) {
+				t.Errorf("%q: %q should be valid", tt.in, v)
+			}
+		}
+		for _, v := range tt.invalid {
+			if enc.Valid(v) {
+				t.Errorf("%q: %q should be invalid", tt.in, v)
+			}
+		}
+		if len(tt.valid) > 0 && enc.Encode(tt.valid[0]) != tt.encoded {
+			t.Errorf("%q: Encode(%q) = %v, want %v", tt.in, tt.valid[0], enc.Encode(tt.valid[0]), tt.encoded)
+		}
+	}
+
+	for _, bad := range []string{"", "yes", "enum:", "enum:|"} {
+		if _, err := ParseThinkEncoding(bad); err == nil {
+			t.Errorf("ParseThinkEncoding(%q) should fail", bad)
+		}
+	}
+	if !GPTOSS.ThinkEncoding().Valid("high") || GPTOSS.ThinkEncoding().Valid("true") {
+		t.Error("gpt-oss built-in encoding should take low|medium|high")
+	}
+}
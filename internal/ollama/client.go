@@ -16,7 +16,9 @@ import (
 
 // Client is a minimal Ollama API client used for model introspection.
 //
-// The proxy only needs /api/show (for model limits and template metadata).
+// The proxy mostly needs /api/show (for model limits and template metadata);
+// idle eviction also uses /api/ps and keep_alive unloads, and the bench
+// command counts prompt tokens through /api/chat.
 type Client struct {
 	BaseURL *url.URL
 	HTTP    *http.Client
@@ -92,6 +94,124 @@ func (c *Client) Show(ctx context.Context, model string, verbose bool) (ShowResp
 	return out, nil
 }
 
+// RunningModel is one entry of GET /api/ps: a model currently loaded by Ollama.
+type RunningModel struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Size      int64     `json:"size"`
+	SizeVRAM  int64     `json:"size_vram"`
+	Digest    string    `json:"digest"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ContextLength is the context the model was loaded with; 0 from Ollama
+	// versions that don't report it.
+	ContextLength int `json:"context_length,omitempty"`
+}
+
+// Running lists the models Ollama currently has loaded.
+func (c *Client) Running(ctx context.Context) ([]RunningModel, error) {
+	u := c.BaseURL.ResolveReference(&url.URL{Path: "/api/ps"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		buf, _ := ioReadAllLimit(resp.Body, 1024*1024)
+		return nil, fmt.Errorf("/api/ps status %d: %s", resp.StatusCode, string(buf))
+	}
+
+	var out struct {
+		Models []RunningModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Models, nil
+}
+
+// ChatMessage is one message of an /api/chat request.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// PromptEvalCount sends messages to /api/chat without streaming, generating
+// a single token with the given num_ctx, and returns Ollama's
+// prompt_eval_count: the number of tokens the model's template and
+// tokenizer made of the prompt.
+func (c *Client) PromptEvalCount(ctx context.Context, model string, messages []ChatMessage, numCtx int) (int, error) {
+	b, err := json.Marshal(map[string]any{
+		"model":    model,
+		"messages": messages,
+		"stream":   false,
+		"options":  map[string]any{"num_ctx": numCtx, "num_predict": 1},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	u := c.BaseURL.ResolveReference(&url.URL{Path: "/api/chat"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	buf, _ := ioReadAllLimit(resp.Body, 1024*1024)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("/api/chat status %d: %s", resp.StatusCode, string(buf))
+	}
+	var out struct {
+		PromptEvalCount int `json:"prompt_eval_count"`
+	}
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return 0, err
+	}
+	if out.PromptEvalCount <= 0 {
+		return 0, fmt.Errorf("/api/chat response has no prompt_eval_count")
+	}
+	return out.PromptEvalCount, nil
+}
+
+// Unload asks Ollama to unload model now, via an empty generate call with keep_alive 0.
+func (c *Client) Unload(ctx context.Context, model string) error {
+	b, err := json.Marshal(map[string]any{"model": model, "keep_alive": 0})
+	if err != nil {
+		return err
+	}
+
+	u := c.BaseURL.ResolveReference(&url.URL{Path: "/api/generate"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, _ := ioReadAllLimit(resp.Body, 1024*1024)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unload %s: status %d: %s", model, resp.StatusCode, string(buf))
+	}
+	return nil
+}
+
 // MaxContextLength returns the maximum context length reported by the model (if present).
 //
 // In /api/show, Ollama puts this in model_info as e.g. "qwen2.context_length".
@@ -132,6 +252,38 @@ func (s ShowResponse) TokensPerImage() (int, bool) {
 	return 0, false
 }
 
+// KVBytesPerToken returns how many bytes of KV cache each token of context
+// takes, from the architecture's block count, KV head count and key/value
+// lengths in model_info, with bytesPerElement bytes per cache element (2 for
+// Ollama's default f16 cache). It returns (0,false) when model_info lacks
+// them, e.g. for models with per-layer head counts.
+func (s ShowResponse) KVBytesPerToken(bytesPerElement float64) (float64, bool) {
+	arch, _ := s.ModelInfo["general.architecture"].(string)
+	if arch == "" {
+		return 0, false
+	}
+	info := func(key string) int {
+		n, _ := util.ToInt(s.ModelInfo[arch+"."+key])
+		return n
+	}
+	blocks := info("block_count")
+	kvHeads := info("attention.head_count_kv")
+	if _, ok := s.ModelInfo[arch+".attention.head_count_kv"]; !ok {
+		kvHeads = info("attention.head_count") // no grouped-query attention
+	}
+	keyLen, valueLen := info("attention.key_length"), info("attention.value_length")
+	if keyLen <= 0 || valueLen <= 0 {
+		if heads := info("attention.head_count"); heads > 0 {
+			keyLen = info("embedding_length") / heads
+			valueLen = keyLen
+		}
+	}
+	if blocks <= 0 || kvHeads <= 0 || keyLen <= 0 || valueLen <= 0 {
+		return 0, false
+	}
+	return float64(blocks*kvHeads*(keyLen+valueLen)) * bytesPerElement, true
+}
+
 func ioReadAllLimit(r io.Reader, max int64) ([]byte, error) {
 	buf := &bytes.Buffer{}
 	if max <= 0 {
@@ -6,15 +6,24 @@ import (
 	"time"
 )
 
+// showFetchTimeout bounds a shared /api/show fetch. Fetches are detached from
+// the caller that started them so one canceled request can't fail the others
+// waiting on the same model.
+const showFetchTimeout = 10 * time.Second
+
 // ShowCache caches /api/show results per model to avoid repeated upstream calls.
 //
-// This keeps latency low and reduces load on Ollama.
+// This keeps latency low and reduces load on Ollama. Concurrent misses for the
+// same model share a single upstream call, and with stale-while-revalidate an
+// expired entry is served while it is refreshed in the background.
 type ShowCache struct {
 	client *Client
 	ttl    time.Duration
+	stale  bool // serve expired entries while refreshing
 
-	mu      sync.Mutex
-	entries map[string]cacheEntry
+	mu       sync.Mutex
+	entries  map[string]cacheEntry
+	inflight map[string]*showCall
 }
 
 type cacheEntry struct {
@@ -22,14 +31,30 @@ type cacheEntry struct {
 	expires time.Time
 }
 
+// showCall is an in-progress fetch that other callers can wait on.
+type showCall struct {
+	done  chan struct{}
+	value ShowResponse
+	err   error
+}
+
 func NewShowCache(client *Client, ttl time.Duration) *ShowCache {
 	return &ShowCache{
-		client:  client,
-		ttl:     ttl,
-		entries: make(map[string]cacheEntry),
+		client:   client,
+		ttl:      ttl,
+		entries:  make(map[string]cacheEntry),
+		inflight: make(map[string]*showCall),
 	}
 }
 
+// SetStaleWhileRevalidate makes Get return an expired entry immediately and
+// refresh it in the background instead of blocking on the upstream call.
+func (c *ShowCache) SetStaleWhileRevalidate(enabled bool) {
+	c.mu.Lock()
+	c.stale = enabled
+	c.mu.Unlock()
+}
+
 // Get returns the cached /api/show result or fetches a fresh one.
 func (c *ShowCache) Get(ctx context.Context, model string) (ShowResponse, error) {
 	if model == "" {
@@ -39,24 +64,61 @@ func (c *ShowCache) Get(ctx context.Context, model string) (ShowResponse, error)
 		return c.client.Show(ctx, model, false)
 	}
 
-	now := time.Now()
 	c.mu.Lock()
 	ent, ok := c.entries[model]
-	if ok && now.Before(ent.expires) {
-		v := ent.value
+	if ok && time.Now().Before(ent.expires) {
 		c.mu.Unlock()
-		return v, nil
+		return ent.value, nil
 	}
+	if ok && c.stale {
+		c.startFetchLocked(model)
+		c.mu.Unlock()
+		return ent.value, nil
+	}
+	call := c.startFetchLocked(model)
 	c.mu.Unlock()
 
-	// Fetch without holding the lock.
-	v, err := c.client.Show(ctx, model, false)
-	if err != nil {
-		return ShowResponse{}, err
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return ShowResponse{}, ctx.Err()
 	}
+}
 
+// Peek returns the cached entry for model without fetching, even if it has
+// expired. It is the fallback when a fresh lookup fails.
+func (c *ShowCache) Peek(model string) (ShowResponse, bool) {
 	c.mu.Lock()
-	c.entries[model] = cacheEntry{value: v, expires: now.Add(c.ttl)}
-	c.mu.Unlock()
-	return v, nil
+	defer c.mu.Unlock()
+	ent, ok := c.entries[model]
+	return ent.value, ok
+}
+
+// startFetchLocked returns the in-flight fetch for model, starting one if
+// needed. Caller must hold c.mu.
+func (c *ShowCache) startFetchLocked(model string) *showCall {
+	if call, ok := c.inflight[model]; ok {
+		return call
+	}
+	call := &showCall{done: make(chan struct{})}
+	c.inflight[model] = call
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), showFetchTimeout)
+		defer cancel()
+		v, err := c.client.Show(ctx, model, false)
+
+		c.mu.Lock()
+		// Errors aren't cached; a stale entry stays until a refresh succeeds.
+		if err == nil {
+			c.entries[model] = cacheEntry{value: v, expires: time.Now().Add(c.ttl)}
+		}
+		delete(c.inflight, model)
+		c.mu.Unlock()
+
+		call.value, call.err = v, err
+		close(call.done)
+	}()
+	return call
 }
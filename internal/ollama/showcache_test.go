@@ -0,0 +1,101 @@
+package ollama
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newShowServer(t *testing.T, calls *int32, delay time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model_info":{"llama.context_length":8192}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestShowCache_ConcurrentMissesShareOneCall(t *testing.T) {
+	var calls int32
+	srv := newShowServer(t, &calls, 50*time.Millisecond)
+	client, _ := NewClient(srv.URL)
+	cache := NewShowCache(client, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.Get(context.Background(), "llama3")
+			if err != nil {
+				t.Errorf("Get error: %v", err)
+				return
+			}
+			if n, _ := v.MaxContextLength(); n != 8192 {
+				t.Errorf("MaxContextLength = %d, want 8192", n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected 1 upstream /api/show call, got %d", got)
+	}
+}
+
+func TestShowCache_StaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	srv := newShowServer(t, &calls, 0)
+	client, _ := NewClient(srv.URL)
+	cache := NewShowCache(client, 20*time.Millisecond)
+	cache.SetStaleWhileRevalidate(true)
+
+	if _, err := cache.Get(context.Background(), "llama3"); err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// Expired: served immediately from cache while a refresh runs.
+	if _, err := cache.Get(context.Background(), "llama3"); err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected background refresh, got %d calls", got)
+	}
+}
+
+func TestKVBytesPerToken(t *testing.T) {
+	tests := []struct {
+		name string
+		info map[string]any
+		want float64
+		ok   bool
+	}{
+		{name: "grouped-query attention", info: map[string]any{"general.architecture": "llama", "llama.block_count": 32,
+			"llama.attention.head_count": 32, "llama.attention.head_count_kv": 8, "llama.embedding_length": 4096}, want: 131072, ok: true},
+		{name: "explicit key and value lengths", info: map[string]any{"general.architecture": "gemma", "gemma.block_count": 10,
+			"gemma.attention.head_count": 8, "gemma.attention.head_count_kv": 4, "gemma.attention.key_length": 256, "gemma.attention.value_length": 256}, want: 10 * 4 * 512 * 2, ok: true},
+		{name: "multi-head attention", info: map[string]any{"general.architecture": "phi", "phi.block_count": 2,
+			"phi.attention.head_count": 4, "phi.embedding_length": 256}, want: 2 * 4 * 128 * 2, ok: true},
+		{name: "per-layer head counts", info: map[string]any{"general.architecture": "x", "x.block_count": 2,
+			"x.attention.head_count": 4, "x.attention.head_count_kv": []any{1, 2}, "x.embedding_length": 256}},
+		{name: "no architecture", info: map[string]any{"llama.block_count": 32}},
+	}
+	for _, tt := range tests {
+		got, ok := ShowResponse{ModelInfo: tt.info}.KVBytesPerToken(2)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: KVBytesPerToken = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
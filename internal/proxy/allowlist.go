@@ -0,0 +1,32 @@
+package proxy
+
+import "sort"
+
+// filterOptions removes keys from reqMap["options"] that aren't in allow and
+// returns the stripped keys, sorted. An empty allow-list forwards everything.
+// num_ctx is always kept since the proxy manages it.
+func filterOptions(reqMap map[string]any, allow []string) []string {
+	if len(allow) == 0 {
+		return nil
+	}
+	opts, ok := reqMap["options"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(allow)+1)
+	for _, k := range allow {
+		allowed[k] = true
+	}
+	allowed["num_ctx"] = true
+
+	var stripped []string
+	for k := range opts {
+		if !allowed[k] {
+			stripped = append(stripped, k)
+			delete(opts, k)
+		}
+	}
+	sort.Strings(stripped)
+	return stripped
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin wraps an admin handler with the configured token/basic auth.
+// When no credentials are configured, next is called directly.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !h.cfg.AdminAuthEnabled() || h.authorized(r) {
+		next(w, r)
+		return
+	}
+	h.writeUnauthorized(w)
+}
+
+// authorized checks the request's Authorization header against the configured
+// Bearer token or basic-auth credentials using constant-time comparison.
+func (h *Handler) authorized(r *http.Request) bool {
+	authz := r.Header.Get("Authorization")
+
+	if h.cfg.AdminAuthToken != "" {
+		if token, ok := strings.CutPrefix(authz, "Bearer "); ok && secureEqual(strings.TrimSpace(token), h.cfg.AdminAuthToken) {
+			return true
+		}
+	}
+
+	if h.cfg.AdminBasicUser != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			// Evaluate both comparisons so timing doesn't reveal which one failed.
+			userOK := secureEqual(user, h.cfg.AdminBasicUser)
+			passOK := secureEqual(pass, h.cfg.AdminBasicPassword)
+			if userOK && passOK {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (h *Handler) writeUnauthorized(w http.ResponseWriter) {
+	if h.cfg.AdminBasicUser != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ollama-auto-ctx"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ollama-auto-ctx"`)
+	}
+	h.writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized", 0)
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// coalescingReader merges the small chunks of a fast upstream stream into
+// fewer, larger reads. The reverse proxy flushes every streamed write, so a
+// model producing hundreds of tokens per second otherwise costs a write and
+// a flush per token.
+//
+// A goroutine reads the upstream body ahead; Read waits for the first
+// chunk, then keeps gathering until it holds size bytes, maxLatency has
+// passed since that first chunk, or the upstream ends or fails. No chunk is
+// held back longer than maxLatency.
+type coalescingReader struct {
+	rc         io.ReadCloser
+	size       int
+	maxLatency time.Duration
+
+	chunks chan coalescedChunk
+	free   chan []byte
+	done   chan struct{}
+	close  sync.Once
+
+	buf     []byte // gathered bytes not yet returned
+	pending []byte // unread part of buf
+	err     error
+	timer   *time.Timer
+}
+
+type coalescedChunk struct {
+	b   []byte
+	err error
+}
+
+// newCoalescingReader starts reading rc ahead and returns the coalesced reader.
+func newCoalescingReader(rc io.ReadCloser, size int, maxLatency time.Duration) *coalescingReader {
+	c := &coalescingReader{
+		rc:         rc,
+		size:       size,
+		maxLatency: maxLatency,
+		chunks:     make(chan coalescedChunk),
+		free:       make(chan []byte, 2),
+		done:       make(chan struct{}),
+		buf:        make([]byte, 0, size),
+	}
+	// Two buffers let the goroutine read the next chunk while Read copies
+	// the previous one.
+	c.free <- make([]byte, size)
+	c.free <- make([]byte, size)
+	go c.readAhead()
+	return c
+}
+
+func (c *coalescingReader) readAhead() {
+	for {
+		var b []byte
+		select {
+		case b = <-c.free:
+		case <-c.done:
+			return
+		}
+		n, err := c.rc.Read(b)
+		select {
+		case c.chunks <- coalescedChunk{b: b[:n], err: err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *coalescingReader) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 && c.err == nil {
+		c.buf = c.buf[:0]
+		select {
+		case chunk := <-c.chunks:
+			c.receive(chunk)
+		case <-c.done:
+			return 0, io.ErrClosedPipe
+		}
+		if c.err == nil && len(c.buf) < c.size {
+			if c.timer == nil {
+				c.timer = time.NewTimer(c.maxLatency)
+			} else {
+				c.timer.Reset(c.maxLatency)
+			}
+		gather:
+			for c.err == nil && len(c.buf) < c.size {
+				select {
+				case chunk := <-c.chunks:
+					c.receive(chunk)
+				case <-c.timer.C:
+					break gather
+				}
+			}
+			c.timer.Stop()
+		}
+		c.pending = c.buf
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return 0, c.err
+}
+
+// receive appends a chunk to buf and hands its buffer back to readAhead.
+func (c *coalescingReader) receive(chunk coalescedChunk) {
+	c.buf = append(c.buf, chunk.b...)
+	if chunk.err != nil {
+		c.err = chunk.err
+		return
+	}
+	c.free <- chunk.b[:cap(chunk.b)]
+}
+
+// Close stops reading ahead and closes the upstream body, which unblocks a
+// pending upstream read.
+func (c *coalescingReader) Close() error {
+	c.close.Do(func() { close(c.done) })
+	return c.rc.Close()
+}
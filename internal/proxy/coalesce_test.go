@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// chunkReader returns one chunk per Read, like a stream of single tokens.
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func tokenLines(n int) [][]byte {
+	lines := make([][]byte, n)
+	for i := range lines {
+		lines[i] = []byte(fmt.Sprintf(`{"model":"m","response":"tok%d","done":false}`+"\n", i))
+	}
+	return lines
+}
+
+func TestCoalescingReader(t *testing.T) {
+	t.Run("merges fast chunks", func(t *testing.T) {
+		lines := tokenLines(200)
+		c := newCoalescingReader(io.NopCloser(&chunkReader{chunks: lines}), 4096, time.Second)
+		defer c.Close()
+
+		var got bytes.Buffer
+		buf := make([]byte, 32*1024)
+		reads := 0
+		for {
+			n, err := c.Read(buf)
+			if n > 0 {
+				reads++
+				got.Write(buf[:n])
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := bytes.Join(lines, nil); !bytes.Equal(got.Bytes(), want) {
+			t.Fatalf("stream changed: got %d bytes, want %d", got.Len(), len(want))
+		}
+		if max := got.Len()/4096 + 1; reads > max {
+			t.Errorf("%d reads for %d bytes, want at most %d", reads, got.Len(), max)
+		}
+	})
+
+	t.Run("holds a chunk at most max latency", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		c := newCoalescingReader(pr, 4096, 20*time.Millisecond)
+		defer c.Close()
+		go pw.Write([]byte("{\"response\":\"a\"}\n"))
+
+		start := time.Now()
+		buf := make([]byte, 1024)
+		n, err := c.Read(buf)
+		if err != nil || string(buf[:n]) != "{\"response\":\"a\"}\n" {
+			t.Fatalf("read %q, %v", buf[:n], err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("a lone chunk was held for %v", elapsed)
+		}
+
+		boom := errors.New("connection reset")
+		pw.CloseWithError(boom)
+		if _, err := c.Read(buf); !errors.Is(err, boom) {
+			t.Errorf("expected the upstream error, got %v", err)
+		}
+	})
+
+	t.Run("close unblocks", func(t *testing.T) {
+		pr, _ := io.Pipe()
+		c := newCoalescingReader(pr, 4096, 20*time.Millisecond)
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Read(make([]byte, 16)); err == nil {
+			t.Error("expected an error reading a closed stream")
+		}
+	})
+}
+
+// BenchmarkStreamCoalescing proxies a fast NDJSON stream (one flushed line
+// per token) through a reverse proxy, as the handler does, with and without
+// coalescing.
+func BenchmarkStreamCoalescing(b *testing.B) {
+	lines := tokenLines(2000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		f := w.(http.Flusher)
+		for _, line := range lines {
+			w.Write(line)
+			f.Flush()
+		}
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	for _, size := range []int{0, 4096} {
+		b.Run(fmt.Sprintf("coalesce=%d", size), func(b *testing.B) {
+			rp := httputil.NewSingleHostReverseProxy(u)
+			if size > 0 {
+				rp.ModifyResponse = func(resp *http.Response) error {
+					resp.Body = newCoalescingReader(resp.Body, size, 20*time.Millisecond)
+					return nil
+				}
+			}
+			front := httptest.NewServer(rp)
+			defer front.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(front.URL + "/api/generate")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
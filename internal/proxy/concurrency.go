@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// defaultModelQueueTimeout applies when MODEL_CONCURRENCY_QUEUE_TIMEOUT is
+// unset in a hand-built Config.
+const defaultModelQueueTimeout = 60 * time.Second
+
+// errModelBusy is returned by acquireModelSlot when no slot freed up in time.
+var errModelBusy = errors.New("model is at its concurrency limit")
+
+// modelSlots holds one semaphore per model with a MODEL_MAX_CONCURRENCY cap,
+// so a heavy model at its cap doesn't hold up requests for other models.
+type modelSlots struct {
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+// get returns model's semaphore, creating it with room for limit requests.
+func (s *modelSlots) get(model string, limit int) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sems == nil {
+		s.sems = make(map[string]chan struct{})
+	}
+	sem, ok := s.sems[model]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		s.sems[model] = sem
+	}
+	return sem
+}
+
+// acquireModelSlot takes one of model's MODEL_MAX_CONCURRENCY slots. When all
+// are taken it waits up to MODEL_CONCURRENCY_QUEUE_TIMEOUT (or not at all
+// with MODEL_CONCURRENCY_POLICY=reject) and returns errModelBusy, or ctx's
+// error if the request ends first. The returned func frees the slot; models
+// without a cap always get one.
+func (h *Handler) acquireModelSlot(ctx context.Context, model string) (release func(), err error) {
+	limit := h.cfg.ModelMaxConcurrencyFor(model)
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	sem := h.modelSlots.get(model, limit)
+	release = func() { <-sem }
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	start := time.Now()
+	if h.cfg.ModelConcurrencyPolicy == config.ModelConcurrencyReject {
+		h.metrics.RecordModelQueued(model, 0, true)
+		return nil, errModelBusy
+	}
+	timeout := h.cfg.ModelConcurrencyQueueTimeout
+	if timeout <= 0 {
+		timeout = defaultModelQueueTimeout
+	}
+	h.logger.Debug("model at concurrency limit; queueing", "model", model, "limit", limit)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		h.metrics.RecordModelQueued(model, time.Since(start), false)
+		return release, nil
+	case <-timer.C:
+		h.metrics.RecordModelQueued(model, time.Since(start), true)
+		return nil, errModelBusy
+	case <-ctx.Done():
+		h.metrics.RecordModelQueued(model, time.Since(start), false)
+		return nil, ctx.Err()
+	}
+}
+
+// modelBusy is the rejection for a request that got no slot under its
+// model's cap: 503 when the model stayed busy, or canceled when the client
+// went away while queued.
+func (h *Handler) modelBusy(model string, err error) rejection {
+	if !errors.Is(err, errModelBusy) {
+		return rejection{
+			code:   http.StatusServiceUnavailable,
+			status: supervisor.StatusCanceled,
+			reason: storage.ReasonModelBusy,
+			msg:    "request ended while queued for model " + model,
+		}
+	}
+	h.logger.Warn("rejecting request: model at concurrency limit", "model", model,
+		"limit", h.cfg.ModelMaxConcurrencyFor(model), "policy", h.cfg.ModelConcurrencyPolicy)
+	return rejection{
+		code:       http.StatusServiceUnavailable,
+		status:     supervisor.StatusModelBusy,
+		reason:     storage.ReasonModelBusy,
+		msg:        "model " + model + " is at its concurrency limit; try again later",
+		retryAfter: time.Second,
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"ollama-auto-ctx/internal/api"
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// corpusEntry is one line of the decision corpus. Features is in the form
+// POST /estimate takes, so a corpus replays against a changed config as is.
+type corpusEntry struct {
+	Time     time.Time            `json:"ts"`
+	Features api.EstimateFeatures `json:"features"`
+	Decision Decision             `json:"decision"`
+}
+
+// DecisionCorpus appends the features and sizing decision of a sampled
+// share of chat/generate requests to a JSONL file, as a corpus of real
+// request shapes for regression-testing config changes with /estimate. No
+// prompt content is written, only byte and message counts. A nil corpus
+// records nothing.
+type DecisionCorpus struct {
+	file   *supervisor.JSONLFile
+	rate   float64
+	sample func() float64 // uniform in [0, 1); decides which requests are written
+}
+
+// NewDecisionCorpus writes sampleRate of decisions to file.
+func NewDecisionCorpus(file *supervisor.JSONLFile, sampleRate float64) *DecisionCorpus {
+	return &DecisionCorpus{file: file, rate: sampleRate, sample: rand.Float64}
+}
+
+// record writes the features a request was sized from and its decision,
+// when the request is sampled.
+func (c *DecisionCorpus) record(f estimate.Features, dec Decision) {
+	if c == nil || c.sample() >= c.rate {
+		return
+	}
+	c.file.Append(corpusEntry{Time: time.Now(), Features: api.FeaturesFrom(f), Decision: dec})
+}
+
+// Close closes the corpus file.
+func (c *DecisionCorpus) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import "ollama-auto-ctx/internal/supervisor"
+
+// requestCost prices the tokens of the request info tracks at its model's
+// MODEL_INPUT_TOKEN_PRICES and MODEL_OUTPUT_TOKEN_PRICES (per million
+// tokens). Counts the upstream didn't report are estimated, the prompt from
+// its sizing estimate and the completion from the bytes forwarded, and
+// estimated says so. Requests never handed to the upstream cost nothing;
+// ok is false for them and for unpriced models.
+func (h *Handler) requestCost(info *supervisor.RequestInfo) (cost float64, estimated, ok bool) {
+	inPrice, outPrice := h.cfg.TokenPricesFor(info.Model)
+	if (inPrice == 0 && outPrice == 0) || info.ForwardTime == nil {
+		return 0, false, false
+	}
+	prompt, completion := int64(info.PromptEvalCount), int64(info.EvalCount)
+	if prompt == 0 && info.EstimatedPromptTokens > 0 {
+		prompt = int64(info.EstimatedPromptTokens)
+		estimated = true
+	}
+	if completion == 0 && info.BytesForwarded > 0 {
+		completion = supervisor.EstimateOutputTokens(info.BytesForwarded, info.Model, h.calib, h.cfg.DefaultTokensPerByte)
+		estimated = true
+	}
+	return (float64(prompt)*inPrice + float64(completion)*outPrice) / 1e6, estimated, true
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ollama-auto-ctx/internal/config"
+)
+
+// errorBody is the JSON shape of proxy-generated errors. "error" matches
+// Ollama's own error responses so clients can parse both the same way.
+type errorBody struct {
+	Error             string `json:"error"`
+	Reason            string `json:"reason,omitempty"`
+	RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+}
+
+// writeError answers a request the proxy handles itself (rejections, auth
+// failures, upstream errors) in the configured ERROR_RESPONSE_STYLE. reason
+// is a short machine-readable cause; a positive retryAfter also sets
+// Retry-After.
+func (h *Handler) writeError(w http.ResponseWriter, code int, reason, msg string, retryAfter time.Duration) {
+	retrySeconds := 0
+	if retryAfter > 0 {
+		retrySeconds = int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retrySeconds))
+	}
+
+	if h.cfg.ErrorResponseStyle == config.ErrorStylePlain {
+		http.Error(w, msg, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(errorBody{Error: msg, Reason: reason, RetryAfterSeconds: retrySeconds})
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ollama-auto-ctx/internal/estimate"
+)
+
+// Estimate returns the Decision a chat or generate request with features
+// would get under the current config, without sending anything upstream or
+// recording it. Model limits are looked up as for a live request (through
+// the /api/show cache); think is resolved as if the client sent no think
+// field and no directive. It only fails for an unknown endpoint, a missing
+// model, or an /api/show timeout under SHOW_TIMEOUT_POLICY=fail_fast.
+func (h *Handler) Estimate(ctx context.Context, features estimate.Features) (Decision, error) {
+	if features.Endpoint != estimate.EndpointChat && features.Endpoint != estimate.EndpointGenerate {
+		return Decision{}, fmt.Errorf("endpoint must be %q or %q", estimate.EndpointChat, estimate.EndpointGenerate)
+	}
+	if features.Model == "" {
+		return Decision{}, errors.New("model is required")
+	}
+	lim, err := h.resolveLimits(ctx, features.Model)
+	if err != nil {
+		return Decision{}, err
+	}
+	return h.size(features, lim, "", "", nil).dec, nil
+}
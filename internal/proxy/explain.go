@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// ExplainParam is the query parameter (value "true") that asks for an
+// Explanation instead of a proxied response when EXPLAIN_ENABLED is set.
+const ExplainParam = "autoctx_explain"
+
+// Explanation is the response to an explain request: how the proxy would
+// size and rewrite the request under the current config.
+type Explanation struct {
+	// Decision is nil when the request would be forwarded unsized, e.g. for
+	// a body that isn't a JSON object or names no model.
+	Decision *Decision `json:"decision"`
+	// Options and Think are the options object and think field that would
+	// be sent upstream (omitted for sampled bodies, which aren't decoded).
+	Options map[string]any `json:"options,omitempty"`
+	Think   any            `json:"think,omitempty"`
+	// Rejection is set when the proxy would refuse the request instead.
+	Rejection *ExplainRejection `json:"rejection,omitempty"`
+}
+
+// ExplainRejection describes the error response a request would get.
+type ExplainRejection struct {
+	Status  int    `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// wantsExplain reports whether r asks for an explanation and explaining is enabled.
+func (h *Handler) wantsExplain(r *http.Request) bool {
+	return h.cfg.ExplainEnabled && r.URL.Query().Get(ExplainParam) == "true"
+}
+
+// serveExplain sizes a chat/generate request exactly as it would be proxied
+// and answers with the Explanation. r carries no request ID, so nothing is
+// tracked or stored, and nothing is sent upstream.
+func (h *Handler) serveExplain(w http.ResponseWriter, r *http.Request, endpoint string) {
+	h.rewriteRequestIfPossible(endpoint, r)
+
+	var out Explanation
+	if rej, ok := r.Context().Value(ctxRejectKey).(rejection); ok {
+		out.Rejection = &ExplainRejection{Status: rej.code, Reason: string(rej.reason), Message: rej.msg}
+	} else if dec, ok := r.Context().Value(ctxDecisionKey).(Decision); ok {
+		out.Decision = &dec
+		if !dec.Sampled && r.Body != nil {
+			var fwd struct {
+				Options map[string]any `json:"options"`
+				Think   any            `json:"think"`
+			}
+			if body, err := io.ReadAll(r.Body); err == nil && json.Unmarshal(body, &fwd) == nil {
+				out.Options, out.Think = fwd.Options, fwd.Think
+			}
+		}
+	}
+	if r.Body != nil {
+		_ = r.Body.Close()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		h.logger.Error("failed to encode explanation", "err", err)
+	}
+}
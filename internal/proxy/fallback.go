@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+	"ollama-auto-ctx/internal/util"
+)
+
+// fallbackFor returns MODEL_FALLBACK's model for model, or "" when it has
+// none other than itself.
+func (h *Handler) fallbackFor(model string) string {
+	fallback := h.cfg.FallbackModelFor(model)
+	if strings.EqualFold(fallback, model) {
+		return ""
+	}
+	return fallback
+}
+
+// modelFailed reports whether res is Ollama failing the model itself rather
+// than the request: a 404 (model not found) or a 5xx, such as a model that
+// couldn't load. Connection errors say nothing about the model.
+func modelFailed(res supervisor.RetryResult) bool {
+	if res.Response == nil {
+		return false
+	}
+	code := res.Response.StatusCode
+	return code == http.StatusNotFound || code >= 500
+}
+
+// retryFallback resends req to fallback, MODEL_FALLBACK's model for the
+// requested one, once res has shown that model failing. It returns the
+// response to deliver, counting the attempts of both sends, and the request
+// it answers, whose context carries the fallback's sizing so calibration
+// learns from the model that actually served. When the body can't be resized
+// or the fallback can't be reached, res is delivered as it is.
+func (t *retryTransport) retryFallback(req *http.Request, body []byte, res supervisor.RetryResult, fallback string) (supervisor.RetryResult, *http.Request) {
+	fbReq, fbBody, ok := t.h.fallbackRequest(req, body, fallback)
+	if !ok {
+		return res, req
+	}
+	next := t.h.retryer.RoundTripEmpty(t.base, fbReq, fbBody)
+	attempts, empty, loading := res.Attempts+next.Attempts, res.Empty+next.Empty, res.Loading+next.Loading
+	if next.Response == nil {
+		res.Attempts, res.Empty, res.Loading = attempts, empty, loading
+		return res, req
+	}
+	_ = res.Response.Body.Close()
+	next.Attempts, next.Empty, next.Loading = attempts, empty, loading
+	t.h.recordFallback(req, fallback, res.Response.StatusCode)
+	return next, fbReq
+}
+
+// fallbackRequest returns a copy of req whose body, body with its model set
+// to fallback, is sized afresh for that model. num_ctx goes back to what the
+// client sent before sizing, so the fallback isn't held to the failed
+// model's context.
+func (h *Handler) fallbackRequest(req *http.Request, body []byte, fallback string) (*http.Request, []byte, bool) {
+	dec, _ := req.Context().Value(ctxDecisionKey).(Decision)
+	reqMap, err := util.DecodeJSONMap(body)
+	if err != nil {
+		return nil, nil, false
+	}
+	reqMap["model"] = fallback
+	if opts, ok := reqMap["options"].(map[string]any); ok && (dec.OverrideApplied || dec.Clamped) {
+		if dec.UserCtxProvided {
+			opts["num_ctx"] = dec.UserCtx
+		} else {
+			delete(opts, "num_ctx")
+		}
+	}
+
+	features, err := estimate.ExtractFeatures(dec.Endpoint, reqMap)
+	if err != nil {
+		return nil, nil, false
+	}
+	lim, err := h.resolveLimits(req.Context(), fallback)
+	if err != nil {
+		return nil, nil, false
+	}
+	if h.cfg.CodeTokensPerByteFor(fallback) > 0 {
+		features.CodeBytes, features.CodeRoles = estimate.CountCodeBytes(dec.Endpoint, reqMap)
+	}
+	session, _ := req.Context().Value(ctxSessionKey).(string)
+	sz := h.size(features, lim, "", session, reqMap)
+	sz.apply(reqMap)
+	fbBody, err := util.EncodeJSON(reqMap)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	ctx := context.WithValue(req.Context(), ctxSampleKey, sz.sample)
+	ctx = context.WithValue(ctx, ctxDecisionKey, sz.dec)
+	fbReq := req.Clone(ctx)
+	fbReq.Body = io.NopCloser(bytes.NewReader(fbBody))
+	fbReq.ContentLength = int64(len(fbBody))
+	fbReq.Header.Set("Content-Length", strconv.Itoa(len(fbBody)))
+	h.recordDecision(fbReq, sz.dec, sz.bucket, "")
+	return fbReq, fbBody, true
+}
+
+// recordFallback stores and counts a request served by its fallback model
+// after the requested one failed with status.
+func (h *Handler) recordFallback(req *http.Request, fallback string, status int) {
+	dec, _ := req.Context().Value(ctxDecisionKey).(Decision)
+	reqID, _ := req.Context().Value(ctxRequestIDKey).(string)
+
+	h.metrics.RecordModelFallback(dec.Model, fallback)
+	if h.tracker != nil && reqID != "" {
+		h.tracker.UpdateModel(reqID, fallback)
+	}
+	if h.store != nil && reqID != "" {
+		if err := h.store.Update(reqID, storage.RequestUpdate{FallbackModel: &fallback}); err != nil {
+			h.logger.Error("failed to record model fallback", "err", err, "id", reqID)
+		}
+	}
+	h.logger.Warn("requested model failed; resent to its fallback", "id", reqID, "model", dec.Model, "fallback", fallback, "upstream_status", status)
+}
@@ -3,24 +3,32 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"ollama-auto-ctx/internal/api"
 	"ollama-auto-ctx/internal/calibration"
 	"ollama-auto-ctx/internal/config"
 	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/family"
 	"ollama-auto-ctx/internal/ollama"
 	"ollama-auto-ctx/internal/storage"
 	"ollama-auto-ctx/internal/supervisor"
@@ -31,33 +39,102 @@ import (
 type ctxKey string
 
 const (
-	ctxSampleKey     ctxKey = "sample"
-	ctxDecisionKey   ctxKey = "decision"
-	ctxClampedKey    ctxKey = "clamped"
-	ctxRequestIDKey  ctxKey = "request_id"
-	ctxStartTimeKey  ctxKey = "start_time"
-	ctxCancelFuncKey ctxKey = "cancel_func"
-	ctxMetadataKey   ctxKey = "metadata"
+	ctxSampleKey       ctxKey = "sample"
+	ctxDecisionKey     ctxKey = "decision"
+	ctxClampedKey      ctxKey = "clamped"
+	ctxRequestIDKey    ctxKey = "request_id"
+	ctxRetryKey        ctxKey = "retry_eligible"
+	ctxLoadingRetryKey ctxKey = "loading_retry" // only model-loading errors are retried (e.g. streaming requests)
+	ctxStartTimeKey    ctxKey = "start_time"
+	ctxCancelFuncKey   ctxKey = "cancel_func"
+	ctxMetadataKey     ctxKey = "metadata"
+	ctxRejectKey       ctxKey = "reject"       // rejection; the request is answered directly instead of forwarded
+	ctxTagsKey         ctxKey = "tags"         // canonical X-AutoCtx-Tags value
+	ctxShadowKey       ctxKey = "shadow"       // *shadowJob for requests mirrored to the shadow upstream
+	ctxUnsupervisedKey ctxKey = "unsupervised" // true when X-AutoCtx-No-Supervise bypasses supervision
+	ctxIdempotencyKey  ctxKey = "idempotency"  // idempotencyRequest when the client sent an Idempotency-Key
+	ctxSessionKey      ctxKey = "session"      // SessionHeader value when session budget escalation is on
+	ctxLoadTicketKey   ctxKey = "load_ticket"  // *loadTicket when LOAD_COALESCE_ENABLED
+	ctxTimeoutsKey     ctxKey = "timeouts"     // TIMEOUT_PROFILES profile named by X-AutoCtx-Timeout-Profile
+	ctxRespCacheKey    ctxKey = "resp_cache"   // responseCacheKey of a request RESPONSE_CACHE_TTL may answer
 )
 
+// rejection describes a request answered by the proxy instead of Ollama.
+type rejection struct {
+	code       int
+	status     supervisor.RequestStatus
+	reason     storage.Reason
+	msg        string
+	retryAfter time.Duration
+}
+
 // Decision captures how the proxy chose a context size.
 type Decision struct {
-	Model                 string
-	Endpoint              string
-	EstimatedPromptTokens int
-	OutputBudgetTokens    int
-	OutputBudgetSource    string
-	NeededTokens          int
-	NeededWithHeadroom    int
-	ChosenCtx             int
-	UserCtx               int
-	UserCtxProvided       bool
-	OverrideApplied       bool
-	Clamped               bool
-	MaxConfigCtx          int
-	MaxModelCtx           int
-	MaxSafeCtx            int
-	ThinkVerdict          string
+	Model                 string `json:"model"`
+	Endpoint              string `json:"endpoint"`
+	EstimatedPromptTokens int    `json:"estimated_prompt_tokens"`
+	OutputBudgetTokens    int    `json:"output_budget_tokens"`
+	OutputBudgetSource    string `json:"output_budget_source"`
+	StructuredOverhead    int    `json:"structured_overhead,omitempty"` // output tokens added for a structured format (included in OutputBudgetTokens)
+	StopAdjustment        int    `json:"stop_adjustment,omitempty"`     // output tokens STOP_BUDGET_FACTOR/NO_STOP_THINK_BUDGET_FACTOR added (negative when trimmed)
+	NeededTokens          int    `json:"needed_tokens"`
+	NeededWithHeadroom    int    `json:"needed_with_headroom"`
+	ChosenCtx             int    `json:"chosen_ctx"`
+	UserCtx               int    `json:"user_ctx,omitempty"`
+	UserCtxProvided       bool   `json:"user_ctx_provided"`
+	OverrideApplied       bool   `json:"override_applied"`
+	OverridePolicy        string `json:"override_policy,omitempty"` // OVERRIDE_NUM_CTX policy consulted for the endpoint
+	Clamped               bool   `json:"clamped"`
+	NumPredictClamped     bool   `json:"num_predict_clamped"` // client num_predict capped by NUM_PREDICT_CEILINGS
+	MaxConfigCtx          int    `json:"max_config_ctx"`
+	MaxModelCtx           int    `json:"max_model_ctx"`
+	MaxSafeCtx            int    `json:"max_safe_ctx"`
+	ThinkVerdict          string `json:"think_verdict,omitempty"`
+	ThinkSource           string `json:"think_source,omitempty"`
+	ThinkValue            string `json:"think_value,omitempty"`    // JSON-encoded "think" field sent upstream
+	ImagesDropped         int    `json:"images_dropped,omitempty"` // images removed by IMAGE_BUDGET_POLICY=drop
+	// CustomTemplate is set when a generate request's own template stood in
+	// for the model's fixed overhead (TEMPLATE_REPLACES_OVERHEAD).
+	CustomTemplate bool `json:"custom_template,omitempty"`
+	// TextBytes is the prompt text that was estimated; CodeBytes of it were
+	// detected as code and estimated at CODE_TOKENS_PER_BYTE (0 when off).
+	TextBytes int `json:"text_bytes"`
+	CodeBytes int `json:"code_bytes,omitempty"`
+	// ShowFallback is set when /api/show failed or timed out: "stale" or
+	// "remembered" when an older model max was used, "none" when it was ignored.
+	ShowFallback string `json:"show_fallback,omitempty"`
+	// UnverifiedCtxCap is UNVERIFIED_MAX_CTX when it capped the context
+	// because the model max couldn't be learned.
+	UnverifiedCtxCap int `json:"unverified_ctx_cap,omitempty"`
+	// MaxVRAMCtx is the largest context whose KV cache fit in the free VRAM
+	// beside the model's weights (VRAM_CTX_CAP_ENABLED; 0 when unknown).
+	MaxVRAMCtx int `json:"max_vram_ctx,omitempty"`
+	// Headroom is the multiplier applied to NeededTokens; with
+	// HEADROOM_CONFIDENCE_ENABLED it is scaled by Confidence, the model's
+	// calibration confidence.
+	Headroom   float64 `json:"headroom"`
+	Confidence float64 `json:"confidence,omitempty"`
+	// UnstableCalibration is the UNSTABLE_CALIBRATION_POLICY applied because
+	// Confidence was below UNSTABLE_CALIBRATION_CONFIDENCE: "max_ctx" when
+	// ChosenCtx was raised to the largest safe context, "headroom" when
+	// Headroom was multiplied.
+	UnstableCalibration string `json:"unstable_calibration,omitempty"`
+	// PreferredCtx is true when the model's MODEL_PREFERRED_CTX was used in
+	// place of the bucket NeededWithHeadroom fell in.
+	PreferredCtx bool `json:"preferred_ctx,omitempty"`
+	// UtilizationFactor is below 1 when the utilization learner shrank
+	// NeededWithHeadroom before bucketing.
+	UtilizationFactor float64 `json:"utilization_factor,omitempty"`
+	// SessionEscalation is how many times the output budget was multiplied
+	// by SESSION_BUDGET_ESCALATION_FACTOR for the request's session.
+	SessionEscalation int `json:"session_escalation,omitempty"`
+	// SeedPolicy names the SEED_POLICY that injected Seed ("" when the
+	// client's seed, or none, was forwarded).
+	SeedPolicy string `json:"seed_policy,omitempty"`
+	Seed       int64  `json:"seed,omitempty"`
+	// Sampled is true when the estimate came from a body prefix rather than
+	// the full request, so it is lower confidence.
+	Sampled bool `json:"sampled,omitempty"`
 }
 
 // Handler is an http.Handler that proxies to Ollama and injects options.num_ctx.
@@ -76,9 +153,31 @@ type Handler struct {
 	retryer       *supervisor.Retryer
 	metrics       *supervisor.Metrics
 	healthChecker *supervisor.HealthChecker
-	upstream      *url.URL
-	nextID        int64
-	dashboardFS   fs.FS
+	families      *family.Classifier
+	// showMax remembers each model's max context from its last successful
+	// /api/show lookup, for SHOW_TIMEOUT_POLICY=remembered.
+	showMaxMu   sync.Mutex
+	showMax     map[string]int
+	utilization *calibration.UtilizationLearner
+	// bucketAnalyzer reports wasteful buckets and may add finer ones.
+	bucketAnalyzer *calibration.BucketAnalyzer
+	// sessionBudgets escalates output budgets per SessionHeader value.
+	sessionBudgets *calibration.SessionEscalator
+	idleEvictor    *supervisor.IdleEvictor
+	vramGate       *supervisor.VRAMGate
+	residency      *supervisor.ResidencyPoller
+	modelSlots     modelSlots
+	modelLoads     modelLoads
+	idempotency    *idempotencyCache
+	respCache      *responseCache
+	shadow         *ShadowMirror
+	corpus         *DecisionCorpus
+	breaker        *storage.BreakerStore
+	tagQuotas      *supervisor.TagQuotas
+	promptScan     *promptScanner
+	upstream       *url.URL
+	nextID         int64
+	dashboardFS    fs.FS
 }
 
 // NewHandler constructs the proxy handler.
@@ -123,12 +222,29 @@ func NewHandler(
 		retryer:       retryer,
 		metrics:       metrics,
 		healthChecker: healthChecker,
+		families:      family.NewClassifier(cfg.ModelFamilyRules),
+		promptScan:    newPromptScanner(cfg.PromptScanRules, cfg.PromptScanMaxBytes),
+		showMax:       make(map[string]int),
 		dashboardFS:   dashboardAssets,
 	}
+	if cfg.IdempotencyTTL > 0 {
+		h.idempotency = newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyCacheSize, cfg.IdempotencyMaxBodyBytes)
+	}
+	if cfg.ResponseCacheTTL > 0 {
+		h.respCache = newResponseCache(cfg.ResponseCacheTTL, cfg.ResponseCacheSize, cfg.ResponseCacheMaxBodyBytes)
+	}
 
 	rp.ModifyResponse = h.modifyResponse
+	if retryer != nil {
+		rp.Transport = &retryTransport{base: http.DefaultTransport, h: h}
+	}
 
 	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// Recorded by serveProxy once the proxy returns.
+		if globalTimedOut(r.Context()) {
+			h.writeError(w, http.StatusGatewayTimeout, string(storage.ReasonTimeoutGlobal), errGlobalTimeout.Error(), 0)
+			return
+		}
 		logger.Error("upstream proxy error", "err", err, "path", r.URL.Path)
 
 		if reqIDVal := r.Context().Value(ctxRequestIDKey); reqIDVal != nil {
@@ -159,8 +275,13 @@ func NewHandler(
 			}
 		}
 
-		w.WriteHeader(http.StatusBadGateway)
-		_, _ = w.Write([]byte("bad gateway"))
+		// While the health checker reports upstream down, hint clients to
+		// come back after its next probe.
+		var retryAfter time.Duration
+		if healthChecker != nil && !healthChecker.Healthy() {
+			retryAfter = cfg.HealthCheckInterval
+		}
+		h.writeError(w, http.StatusBadGateway, string(storage.ReasonUpstreamError), "bad gateway", retryAfter)
 	}
 
 	return h
@@ -170,6 +291,9 @@ func (h *Handler) modifyResponse(resp *http.Response) error {
 	if clamped, ok := resp.Request.Context().Value(ctxClampedKey).(bool); ok && clamped {
 		resp.Header.Set("X-Ollama-CtxProxy-Clamped", "true")
 	}
+	if dec, ok := resp.Request.Context().Value(ctxDecisionKey).(Decision); ok && dec.Sampled {
+		resp.Header.Set("X-Ollama-CtxProxy-Sampled", "true")
+	}
 
 	// Get request ID
 	reqID := ""
@@ -179,29 +303,34 @@ func (h *Handler) modifyResponse(resp *http.Response) error {
 		}
 	}
 
+	// Record the upstream status for every stored request, successes included
+	if h.store != nil && reqID != "" {
+		status := resp.StatusCode
+		if err := h.store.Update(reqID, storage.RequestUpdate{UpstreamHTTPStatus: &status}); err != nil {
+			h.logger.Error("failed to store upstream status", "err", err, "id", reqID)
+		}
+	}
+
 	// Get sample (may be empty if not an Ollama endpoint)
 	sample, _ := resp.Request.Context().Value(ctxSampleKey).(calibration.Sample)
 
+	// Ollama answers once the model is loaded; a 5xx may be a failed load.
+	if t, ok := resp.Request.Context().Value(ctxLoadTicketKey).(*loadTicket); ok && t.model == sample.Model && resp.StatusCode < 500 {
+		t.started()
+	}
+
 	ct := resp.Header.Get("Content-Type")
 
+	unsupervised, _ := resp.Request.Context().Value(ctxUnsupervisedKey).(bool)
+
 	// Loop detector for protect mode
 	var loopDetector *supervisor.LoopDetector
 	var cancelFunc func()
-	if h.features.Protect && h.cfg.LoopDetectEnabled {
+	if h.features.Protect && h.cfg.LoopDetectEnabled && !unsupervised {
 		if cancelFuncVal := resp.Request.Context().Value(ctxCancelFuncKey); cancelFuncVal != nil {
 			if cancel, ok := cancelFuncVal.(context.CancelFunc); ok {
 				cancelFunc = cancel
-				loopDetector = supervisor.NewLoopDetector(
-					supervisor.LoopDetectorConfig{
-						WindowBytes:     h.cfg.LoopWindowBytes,
-						NgramBytes:      h.cfg.LoopNgramBytes,
-						RepeatThreshold: h.cfg.LoopRepeatThreshold,
-						MinOutputBytes:  h.cfg.LoopMinOutputBytes,
-					},
-					reqID,
-					cancel,
-					h.tracker,
-				)
+				loopDetector = supervisor.NewLoopDetector(h.loopDetectorConfig(sample.Model), reqID, cancel, h.tracker)
 			}
 		}
 	}
@@ -218,7 +347,7 @@ func (h *Handler) modifyResponse(resp *http.Response) error {
 	var outputTokenLimit int64
 	var outputLimitAction string
 	var minOutputBytes int64
-	if h.features.Protect && h.cfg.OutputLimitEnabled && h.cfg.OutputLimitMaxTokens > 0 {
+	if h.features.Protect && h.cfg.OutputLimitEnabled && h.cfg.OutputLimitMaxTokens > 0 && !unsupervised {
 		outputTokenLimit = int64(h.cfg.OutputLimitMaxTokens)
 		outputLimitAction = "cancel"
 		minOutputBytes = int64(h.cfg.LoopMinOutputBytes)
@@ -236,27 +365,94 @@ func (h *Handler) modifyResponse(resp *http.Response) error {
 			calibStore = h.calib
 		}
 
-		resp.Body = NewTapReadCloser(resp.Body, ct, resp.ContentLength, h.cfg.ResponseTapMaxBytes,
+		tap := NewTapReadCloser(resp.Body, ct, resp.ContentLength, h.cfg.ResponseTapMaxBytes,
 			sample, calibStore, h.tracker, loopDetector, reqID, h.logger,
 			outputTokenLimit, outputLimitAction, cancelFunc, minOutputBytes, h.store)
+		if t, ok := tap.(*TapReadCloser); ok {
+			t.scanOverflow = h.cfg.ResponseTapScanOverflow
+			t.setDialect(h.cfg.UpstreamDialect)
+		}
+		if t, ok := tap.(*TapReadCloser); ok && resp.StatusCode == http.StatusOK {
+			t.minEvalCount = h.cfg.RetryMinEvalCount
+			t.utilization = h.utilization
+			if session, ok := resp.Request.Context().Value(ctxSessionKey).(string); ok {
+				t.onDone = func(doneReason string) { h.sessionBudgets.Observe(session, doneReason == "length") }
+			}
+			if h.cfg.StreamCoalesceBytes > 0 && (t.isNDJSON || t.isSSE) {
+				t.rc = newCoalescingReader(t.rc, h.cfg.StreamCoalesceBytes, h.cfg.StreamCoalesceMaxLatency)
+			}
+			if h.cfg.TokenTrailersEnabled && (t.isNDJSON || t.isSSE) {
+				t.trailer = announceTokenTrailers(resp)
+			}
+		}
+		if job, ok := resp.Request.Context().Value(ctxShadowKey).(*shadowJob); ok && resp.StatusCode == http.StatusOK {
+			if t, ok := tap.(*TapReadCloser); ok {
+				t.onJSONBody = job.observePrimaryBody
+			}
+		}
+		if dec, ok := resp.Request.Context().Value(ctxDecisionKey).(Decision); ok && h.cfg.TruncationCheckEnabled && resp.StatusCode == http.StatusOK {
+			if t, ok := tap.(*TapReadCloser); ok && dec.ChosenCtx > dec.OutputBudgetTokens {
+				t.truncationLimit = dec.ChosenCtx - dec.OutputBudgetTokens
+				// A client-chosen num_ctx says nothing about our estimate, so
+				// it is flagged but not weighted.
+				if dec.OverrideApplied {
+					t.truncationWeight = h.cfg.TruncationCalibrationWeight
+				}
+				t.onTruncated = func(promptTokens int) { h.promptTruncated(reqID, dec, promptTokens) }
+			}
+		}
+		if t, ok := tap.(*TapReadCloser); ok && reqID != "" {
+			req := resp.Request
+			t.onReadError = func(err error) { h.upstreamStreamFailed(req, reqID, sample.Model, err) }
+		}
+		resp.Body = tap
+		if t, ok := tap.(*TapReadCloser); ok && h.cfg.ProgressSidebandEnabled && h.tracker != nil && reqID != "" && t.isNDJSON && resp.StatusCode == http.StatusOK {
+			resp.Body = newProgressSideband(tap, h.tracker, reqID, h.cfg.ProgressSidebandInterval)
+		}
 	}
 	return nil
 }
 
-// ServeHTTP implements the proxy + rewrite logic.
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// API endpoints (when enabled)
-	if h.features.API && h.apiServer != nil && h.apiServer.Handles(r.URL.Path) {
-		h.apiServer.ServeHTTP(w, r)
+// promptTruncated records a response whose prompt_eval_count exceeded the
+// chosen context minus the output budget: the upstream most likely dropped
+// part of the prompt, or left less room for output than was planned.
+func (h *Handler) promptTruncated(reqID string, dec Decision, promptTokens int) {
+	h.metrics.RecordTruncationSuspected(dec.Model)
+	h.logger.Warn("prompt likely truncated: prompt_eval_count exceeded chosen context minus output budget",
+		"id", reqID,
+		"model", dec.Model,
+		"prompt_eval_count", promptTokens,
+		"estimated_prompt_tokens", dec.EstimatedPromptTokens,
+		"chosen_ctx", dec.ChosenCtx,
+		"output_budget", dec.OutputBudgetTokens)
+}
+
+// upstreamStreamFailed records an upstream body that broke after the response
+// started (e.g. Ollama crashed or reset the connection mid-generation). The
+// ErrorHandler never sees these, so without this the request would be
+// finalized as a success. Reads failing because the request context was
+// canceled (watchdog, loop detector, output limit or client) are left to
+// their own handling.
+func (h *Handler) upstreamStreamFailed(r *http.Request, reqID, model string, err error) {
+	if r.Context().Err() != nil {
 		return
 	}
+	h.logger.Warn("upstream stream failed mid-response", "id", reqID, "model", model, "err", err)
+	h.metrics.RecordUpstreamStreamError(model)
 
-	// Metrics endpoint
-	if h.features.Metrics && r.URL.Path == "/metrics" && r.Method == http.MethodGet {
-		h.handleMetrics(w, r)
-		return
+	if startTime, ok := r.Context().Value(ctxStartTimeKey).(time.Time); ok {
+		h.finalizeStorageFromTracker(reqID, supervisor.StatusUpstreamError, "", startTime)
 	}
+	if h.tracker != nil && h.tracker.GetRequestInfo(reqID) != nil {
+		h.tracker.Finish(reqID, supervisor.StatusUpstreamError, err)
+	}
+}
 
+// ServeHTTP implements the proxy + rewrite logic.
+//
+// When ADMIN_LISTEN_ADDR is set, admin routes (dashboard, API, events, metrics)
+// are served by AdminHandler instead and this handler only proxies + reports health.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Health endpoints
 	if r.URL.Path == "/healthz" {
 		h.handleHealthz(w, r)
@@ -267,42 +463,157 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.cfg.AdminListenAddr == "" && h.serveAdmin(w, r) {
+		return
+	}
+
+	h.serveProxy(w, r)
+}
+
+// AdminHandler returns the handler for the separate admin listener.
+// Unknown paths return 404 rather than being proxied upstream.
+func (h *Handler) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			h.handleHealthz(w, r)
+			return
+		}
+		if r.URL.Path == "/healthz/upstream" {
+			h.handleHealthzUpstream(w, r)
+			return
+		}
+		if !h.serveAdmin(w, r) {
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// serveAdmin routes dashboard, API, metrics, events and debug requests.
+// It returns false if the request is not an admin route.
+func (h *Handler) serveAdmin(w http.ResponseWriter, r *http.Request) bool {
+	// API endpoints (when enabled)
+	if h.features.API && h.apiServer != nil && h.apiServer.Handles(r.URL.Path) {
+		h.requireAdmin(w, r, h.apiServer.ServeHTTP)
+		return true
+	}
+
+	// Metrics endpoint
+	if h.features.Metrics && r.URL.Path == "/metrics" && r.Method == http.MethodGet {
+		h.requireAdmin(w, r, h.handleMetrics)
+		return true
+	}
+
 	// Dashboard (when enabled) - serves SPA at /dashboard and /dashboard/*
 	if h.features.Dashboard && r.Method == http.MethodGet && (r.URL.Path == "/dashboard" || strings.HasPrefix(r.URL.Path, "/dashboard/")) {
-		h.handleDashboard(w, r)
-		return
+		h.requireAdmin(w, r, h.handleDashboard)
+		return true
 	}
 
 	// Events SSE (when enabled)
 	if h.features.Events && r.URL.Path == "/events" && r.Method == http.MethodGet {
-		h.handleSSEEvents(w, r)
-		return
+		h.requireAdmin(w, r, h.handleSSEEvents)
+		return true
 	}
 
 	// Legacy debug endpoint (redirect to new API)
 	if r.URL.Path == "/debug/requests" && r.Method == http.MethodGet {
-		if h.features.API && h.apiServer != nil {
-			http.Redirect(w, r, "/autoctx/api/v1/requests", http.StatusTemporaryRedirect)
+		// Filtered queries keep the legacy shape; see filterSnapshot.
+		if h.features.API && h.apiServer != nil && !hasSnapshotFilter(r.URL.Query()) {
+			target := "/autoctx/api/v1/requests"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+			return true
+		}
+		h.requireAdmin(w, r, h.handleDebugRequests)
+		return true
+	}
+
+	return false
+}
+
+// serveProxy forwards a request upstream, rewriting /api/chat and /api/generate.
+func (h *Handler) serveProxy(w http.ResponseWriter, r *http.Request) {
+	// Checked before the proxy credentials are stripped below; never forwarded.
+	unsupervised := h.noSupervise(r)
+	r.Header.Del(NoSuperviseHeader)
+
+	// Optional auth for proxied endpoints (CORS preflight stays open)
+	if h.cfg.ProxyAuthRequired && r.Method != http.MethodOptions {
+		if !h.authorized(r) {
+			h.writeUnauthorized(w)
 			return
 		}
-		h.handleDebugRequests(w, r)
-		return
+		// The credentials are ours; don't leak them upstream.
+		r.Header.Del("Authorization")
 	}
 
 	// Only track Ollama API endpoints
 	isOllamaEndpoint := (r.Method == http.MethodPost && r.URL.Path == "/api/chat") ||
 		(r.Method == http.MethodPost && r.URL.Path == "/api/generate")
 
+	if isOllamaEndpoint && h.wantsExplain(r) {
+		endpoint := estimate.EndpointChat
+		if r.URL.Path == "/api/generate" {
+			endpoint = estimate.EndpointGenerate
+		}
+		h.serveExplain(w, r, endpoint)
+		return
+	}
+
 	var reqID string
 	if isOllamaEndpoint {
 		reqID = h.generateRequestID()
 	}
 
+	// Tags are ours too; record them for chat/generate and never forward them.
+	tags, droppedTags := parseTags(r.Header.Get(TagsHeader))
+	r.Header.Del(TagsHeader)
+	if droppedTags > 0 {
+		h.logger.Warn("dropped invalid request tags", "path", r.URL.Path, "dropped", droppedTags)
+	}
+
+	// With IDEMPOTENCY_TTL the key is ours as well.
+	var idempotencyKey string
+	if h.idempotency != nil {
+		idempotencyKey = r.Header.Get(IdempotencyKeyHeader)
+		r.Header.Del(IdempotencyKeyHeader)
+	}
+
+	var session string
+	if h.sessionBudgets != nil {
+		session = h.sessionID(r)
+	}
+	r.Header.Del(SessionHeader)
+
+	var timeoutProfile string
+	if isOllamaEndpoint {
+		timeoutProfile = h.requestedTimeoutProfile(r)
+	}
+	r.Header.Del(TimeoutProfileHeader)
+
 	ctx := r.Context()
 	startTime := time.Now()
 	if isOllamaEndpoint {
 		ctx = context.WithValue(ctx, ctxRequestIDKey, reqID)
 		ctx = context.WithValue(ctx, ctxStartTimeKey, startTime)
+		if tags != "" {
+			ctx = context.WithValue(ctx, ctxTagsKey, tags)
+		}
+		if idempotencyKey != "" {
+			ctx = context.WithValue(ctx, ctxIdempotencyKey, idempotencyRequest{key: idempotencyKey})
+		}
+		if session != "" {
+			ctx = context.WithValue(ctx, ctxSessionKey, session)
+		}
+		if timeoutProfile != "" {
+			ctx = context.WithValue(ctx, ctxTimeoutsKey, timeoutProfile)
+		}
+		if unsupervised {
+			ctx = context.WithValue(ctx, ctxUnsupervisedKey, true)
+			h.logger.Info("supervision bypassed", "id", reqID, "path", r.URL.Path)
+		}
 	}
 
 	var alreadyFinished bool
@@ -324,14 +635,30 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				// Update storage with final data from tracker BEFORE finishing the request
 				// Note: TapReadCloser.Close() will also update storage with Ollama timing data
 				h.finalizeStorageFromTracker(reqID, supervisor.StatusSuccess, "", startTime)
+				h.logSlowRequest(r, reqID, startTime)
 				h.tracker.Finish(reqID, supervisor.StatusSuccess, nil)
 			}
 		}()
 	}
 
+	// The outermost bound, whatever the supervisor does. Its check is
+	// deferred after the tracker's so it runs first.
+	if isOllamaEndpoint && h.cfg.GlobalRequestTimeout > 0 {
+		var cancelGlobal context.CancelFunc
+		ctx, cancelGlobal = context.WithTimeoutCause(ctx, h.cfg.GlobalRequestTimeout, errGlobalTimeout)
+		defer cancelGlobal()
+		globalCtx := ctx
+		defer func() {
+			if !alreadyFinished && globalTimedOut(globalCtx) {
+				alreadyFinished = true
+				h.recordGlobalTimeout(reqID, r.URL.Path, startTime)
+			}
+		}()
+	}
+
 	// Context cancellation for watchdog/loop detection
 	var cancel context.CancelFunc
-	needsCancel := isOllamaEndpoint && (h.watchdog != nil || (h.features.Protect && h.cfg.LoopDetectEnabled))
+	needsCancel := isOllamaEndpoint && !unsupervised && (h.watchdog != nil || (h.features.Protect && h.cfg.LoopDetectEnabled))
 	if needsCancel {
 		ctx, cancel = context.WithCancel(ctx)
 		ctx = context.WithValue(ctx, ctxCancelFuncKey, cancel)
@@ -339,6 +666,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if h.watchdog != nil {
 			h.watchdog.Start(reqID, cancel)
 			defer h.watchdog.Stop(reqID)
+			h.setWatchdogProfile(reqID, timeoutProfile)
 		}
 	}
 
@@ -348,9 +676,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// CORS
 	if h.cfg.CORSAllowOrigin != "" {
 		w.Header().Set("Access-Control-Allow-Origin", h.cfg.CORSAllowOrigin)
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+TagsHeader+", "+NoSuperviseHeader+", "+IdempotencyKeyHeader+", "+SessionHeader+", "+TimeoutProfileHeader)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Expose-Headers", "X-Ollama-CtxProxy-Clamped")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Ollama-CtxProxy-Clamped, X-Ollama-CtxProxy-Sampled, "+IdempotentReplayHeader+", "+ResponseCacheHeader)
 	}
 	if r.Method == http.MethodOptions {
 		if r.Header.Get("Access-Control-Request-Method") != "" {
@@ -368,39 +696,222 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		endpoint = estimate.EndpointGenerate
 	}
 
+	var estimateDur time.Duration
 	if endpoint != "" {
+		estimateStart := time.Now()
 		h.rewriteRequestIfPossible(endpoint, r)
+		estimateDur = time.Since(estimateStart)
+	}
+
+	if rej, ok := r.Context().Value(ctxRejectKey).(rejection); ok {
+		alreadyFinished = true
+		h.reject(w, reqID, rej, startTime)
+		return
+	}
+
+	// proxied is set once the response was copied to the client in full; the
+	// reverse proxy panics with http.ErrAbortHandler when the copy fails.
+	proxied := false
+	if idem, ok := r.Context().Value(ctxIdempotencyKey).(idempotencyRequest); ok && idem.nonStream {
+		entry, first, mismatch := h.idempotency.begin(idem.key, idem.bodyHash)
+		switch {
+		case mismatch:
+			alreadyFinished = true
+			h.reject(w, reqID, rejection{
+				code:   http.StatusUnprocessableEntity,
+				status: supervisor.StatusIdempotencyMismatch,
+				reason: storage.ReasonIdempotencyMismatch,
+				msg:    IdempotencyKeyHeader + " was already used for a different request",
+			}, startTime)
+			return
+		case first:
+			rec := &idempotencyRecorder{ResponseWriter: w, max: h.idempotency.maxBodyBytes}
+			w = rec
+			defer func() {
+				var resp *cachedResponse
+				if proxied {
+					resp = rec.response()
+				}
+				h.idempotency.finish(idem.key, entry, resp)
+			}()
+		default:
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				alreadyFinished = true
+				rej := rejection{
+					code:   http.StatusServiceUnavailable,
+					status: supervisor.StatusCanceled,
+					msg:    "request ended while waiting for the earlier request with its " + IdempotencyKeyHeader,
+				}
+				if globalTimedOut(r.Context()) {
+					rej = globalTimeoutRejection
+				}
+				h.reject(w, reqID, rej, startTime)
+				return
+			}
+			if entry.resp != nil {
+				h.logger.Info("replaying response for repeated "+IdempotencyKeyHeader, "id", reqID)
+				replay(w, entry.resp)
+				return
+			}
+			// The earlier request couldn't be replayed; this one runs uncached.
+		}
+	}
+
+	if key, ok := r.Context().Value(ctxRespCacheKey).(responseCacheKey); ok {
+		if resp := h.respCache.get(key); resp != nil {
+			h.metrics.RecordResponseCacheLookup(key.model, true)
+			h.logger.Debug("answering deterministic request from the response cache", "id", reqID, "model", key.model)
+			serveCached(w, resp)
+			proxied = true
+			return
+		}
+		h.metrics.RecordResponseCacheLookup(key.model, false)
+		rec := &idempotencyRecorder{ResponseWriter: w, max: h.respCache.maxBodyBytes}
+		w = rec
+		defer func() {
+			if proxied {
+				h.respCache.put(key, rec.response())
+			}
+		}()
+	}
+
+	if sample, ok := r.Context().Value(ctxSampleKey).(calibration.Sample); ok {
+		if h.cfg.LoadCoalesceEnabled {
+			ticket, err := h.holdForLoad(r.Context(), reqID, sample.Model)
+			if err != nil {
+				alreadyFinished = true
+				rej := loadCanceled(sample.Model)
+				if globalTimedOut(r.Context()) {
+					rej = globalTimeoutRejection
+				}
+				h.reject(w, reqID, rej, startTime)
+				return
+			}
+			defer ticket.done()
+			*r = *r.WithContext(context.WithValue(r.Context(), ctxLoadTicketKey, ticket))
+		}
+
+		release, err := h.acquireModelSlot(r.Context(), sample.Model)
+		if err != nil {
+			alreadyFinished = true
+			rej := h.modelBusy(sample.Model, err)
+			if globalTimedOut(r.Context()) {
+				rej = globalTimeoutRejection
+			}
+			h.reject(w, reqID, rej, startTime)
+			return
+		}
+		defer release()
+
+		releaseVRAM, err := h.vramGate.Admit(r.Context(), sample.Model, func() int64 { return h.modelSize(r.Context(), sample.Model) })
+		if err != nil {
+			alreadyFinished = true
+			rej := h.vramInsufficient(sample.Model, err)
+			if globalTimedOut(r.Context()) {
+				rej = globalTimeoutRejection
+			}
+			h.reject(w, reqID, rej, startTime)
+			return
+		}
+		defer releaseVRAM()
+
+		h.idleEvictor.Begin(sample.Model)
+		defer h.idleEvictor.End(sample.Model)
+
+		if r.ContentLength <= h.cfg.RequestBodyMaxBytes {
+			if job := h.shadow.start(r, reqID); job != nil {
+				*r = *r.WithContext(context.WithValue(r.Context(), ctxShadowKey, job))
+				defer job.primaryFinished()
+			}
+		}
 	}
 
+	if h.tracker != nil && reqID != "" {
+		h.tracker.MarkForwarded(reqID, estimateDur)
+	}
 	h.proxy.ServeHTTP(w, r)
+	proxied = true
 }
 
 func (h *Handler) rewriteRequestIfPossible(endpoint string, r *http.Request) {
 	if r.Body == nil {
 		return
 	}
-	if r.ContentLength < 0 || r.ContentLength > h.cfg.RequestBodyMaxBytes {
+	// Ollama decodes every body as JSON regardless of Content-Type, so with an
+	// OPTIONS_ALLOWLIST every body must be decoded and filtered; one that
+	// can't be (too large, unreadable or not a JSON object) is rejected.
+	enforce := len(h.cfg.OptionsAllowlist) > 0
+
+	// A missing Content-Type is treated as JSON. Other types are only
+	// considered when sniffing is enabled and the body looks like an object.
+	ct := r.Header.Get("Content-Type")
+	declaredJSON := ct == "" || strings.Contains(ct, "application/json") || enforce
+	if !declaredJSON && !h.cfg.SniffJSONBody {
 		return
 	}
-	ct := r.Header.Get("Content-Type")
-	if ct != "" && !strings.Contains(ct, "application/json") {
+
+	if r.ContentLength > h.cfg.RequestBodyMaxBytes && h.cfg.SampledEstimation && !enforce {
+		h.rewriteSampled(endpoint, r)
+		return
+	}
+	if r.ContentLength > h.cfg.RequestBodyMaxBytes || (r.ContentLength < 0 && !enforce) {
+		if enforce {
+			h.rejectUnfiltered(r, fmt.Sprintf("request body is larger than %d bytes", h.cfg.RequestBodyMaxBytes))
+		}
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	// Chunked bodies are only read when the allow-list must be enforced,
+	// up to RequestBodyMaxBytes.
+	body, err := io.ReadAll(io.LimitReader(r.Body, h.cfg.RequestBodyMaxBytes+1))
 	_ = r.Body.Close()
 	if err != nil {
+		if enforce {
+			h.rejectUnfiltered(r, "request body could not be read")
+		} else {
+			setBody(r, body)
+		}
+		return
+	}
+	if int64(len(body)) > h.cfg.RequestBodyMaxBytes {
+		h.rejectUnfiltered(r, fmt.Sprintf("request body is larger than %d bytes", h.cfg.RequestBodyMaxBytes))
 		return
 	}
 
 	setBody(r, body)
 
-	reqMap, err := util.DecodeJSONMap(body)
+	if !declaredJSON && !looksLikeJSONObject(body) {
+		return
+	}
+
+	reqMap, err := h.decodeRequestBody(r, &body)
 	if err != nil {
+		if enforce {
+			h.rejectUnfiltered(r, "request body is not a JSON object")
+		}
 		return
 	}
 
+	// Repeats of a non-streaming request are matched on the body the client sent.
+	if idem, ok := r.Context().Value(ctxIdempotencyKey).(idempotencyRequest); ok {
+		if stream, ok := reqMap["stream"].(bool); ok && !stream {
+			idem.bodyHash, idem.nonStream = sha256.Sum256(body), true
+			*r = *r.WithContext(context.WithValue(r.Context(), ctxIdempotencyKey, idem))
+		}
+	}
+
+	// Snapshot the options before filtering, so logs and storage show what the client sent
+	clientOptions, _ := reqMap["options"].(map[string]any)
+	optionsSnap := optionsSnapshot(clientOptions, h.optionsFilter())
+
+	model, _ := reqMap["model"].(string)
+	timeoutProfile := h.applyTimeoutProfile(r, model)
+	promptScan, promptRejected := h.applyPromptScan(r, endpoint, reqMap)
+
 	// Parse metadata for storage
+	var storageReq *storage.Request
 	if h.store != nil {
 		meta := ParseRequestMetadata(endpoint, reqMap, len(body))
 		reqID := ""
@@ -408,10 +919,64 @@ func (h *Handler) rewriteRequestIfPossible(endpoint string, r *http.Request) {
 			reqID, _ = reqIDVal.(string)
 		}
 		if reqID != "" {
-			storageReq := meta.ToStorageRequest(reqID, time.Now().UnixMilli())
-			if err := h.store.Insert(storageReq); err != nil {
-				h.logger.Error("failed to insert request to storage", "err", err)
+			storageReq = meta.ToStorageRequest(reqID, time.Now().UnixMilli())
+			storageReq.Family = string(h.families.Classify(meta.Model))
+			storageReq.Tags, _ = r.Context().Value(ctxTagsKey).(string)
+			storageReq.Unsupervised, _ = r.Context().Value(ctxUnsupervisedKey).(bool)
+			storageReq.TimeoutProfile = timeoutProfile
+			storageReq.PromptScan = promptScan
+			if h.cfg.StoreRequestOptions {
+				storageReq.OptionsJSON = optionsSnap
+			}
+			if h.cfg.StoreRequestFingerprint {
+				storageReq.Fingerprint = meta.Fingerprint
+			}
+		}
+	}
+
+	// Strip options outside the allow-list. The body is re-encoded right away so
+	// the guardrail holds even if sizing bails out below.
+	stripped := filterOptions(reqMap, h.cfg.OptionsAllowlist)
+	if len(stripped) > 0 {
+		h.logger.Debug("stripped options not in allow-list", "path", r.URL.Path, "keys", stripped)
+	}
+	seed, seedInjected := injectSeed(reqMap, body, h.cfg)
+	if len(stripped) > 0 || seedInjected {
+		if newBody, err := util.EncodeJSON(reqMap); err == nil {
+			setBody(r, newBody)
+		}
+	}
+
+	invalidImages := 0
+	if h.cfg.ImageValidation == config.ImageValidationCount || h.cfg.ImageValidation == config.ImageValidationReject {
+		invalidImages = estimate.CountInvalidImages(endpoint, reqMap)
+	}
+
+	if storageReq != nil {
+		storageReq.StrippedOptions = strings.Join(stripped, ",")
+		storageReq.InvalidImages = invalidImages
+		if seedInjected {
+			storageReq.InjectedSeed = &seed
+		}
+		if err := h.store.Insert(storageReq); err != nil {
+			h.logger.Error("failed to insert request to storage", "err", err)
+		}
+	}
+	if promptRejected {
+		return
+	}
+
+	if invalidImages > 0 {
+		h.logger.Warn("request contains invalid images", "path", r.URL.Path, "count", invalidImages)
+		if h.cfg.ImageValidation == config.ImageValidationReject {
+			rej := rejection{
+				code:   http.StatusBadRequest,
+				status: supervisor.StatusInvalidImages,
+				reason: storage.ReasonInvalidImages,
+				msg:    fmt.Sprintf("%d invalid image(s): images must be non-empty base64", invalidImages),
 			}
+			*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+			return
 		}
 	}
 
@@ -428,6 +993,8 @@ func (h *Handler) rewriteRequestIfPossible(endpoint string, r *http.Request) {
 	if features.Model == "" {
 		return
 	}
+	// Invalid images carry no image tokens.
+	features.ImageCount -= invalidImages
 
 	// Update tracker with model
 	if h.tracker != nil {
@@ -438,20 +1005,334 @@ func (h *Handler) rewriteRequestIfPossible(endpoint string, r *http.Request) {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	lim, err := h.resolveLimits(r.Context(), features.Model)
+	if err != nil {
+		h.rejectShowTimeout(r, features.Model, err)
+		return
+	}
+	imagesDropped, ok := h.applyImageBudget(r, endpoint, reqMap, &features, invalidImages, lim.tokensPerImage, lim.effMax)
+	if !ok {
+		return
+	}
+
+	if h.cfg.CodeTokensPerByteFor(features.Model) > 0 {
+		features.CodeBytes, features.CodeRoles = estimate.CountCodeBytes(endpoint, reqMap)
+	}
+	session, _ := r.Context().Value(ctxSessionKey).(string)
+	sz := h.size(features, lim, systemPromptThinkVerdict, session, reqMap)
+	dec, bucket := sz.dec, sz.bucket
+	if h.rejectOversizePrompt(r, features.Model, dec.EstimatedPromptTokens) {
+		return
+	}
+	if h.rejectOverQuota(r, dec.EstimatedPromptTokens+dec.OutputBudgetTokens) {
+		return
+	}
+	dec.ImagesDropped = imagesDropped
+
+	if sz.apply(reqMap) || imagesDropped > 0 {
+		newBody, err := util.EncodeJSON(reqMap)
+		if err != nil {
+			return
+		}
+		setBody(r, newBody)
+	}
+
+	if seedInjected {
+		dec.SeedPolicy, dec.Seed = string(h.cfg.SeedPolicy), seed
+	}
+
+	ctx2 := context.WithValue(r.Context(), ctxSampleKey, sz.sample)
+	ctx2 = context.WithValue(ctx2, ctxDecisionKey, dec)
+	if dec.Clamped {
+		ctx2 = context.WithValue(ctx2, ctxClampedKey, true)
+	}
+	// Ollama streams unless the client sends stream:false.
+	stream, ok := reqMap["stream"].(bool)
+	if h.retryer != nil && h.retryer.IsEligible(r, !ok || stream, endpoint) {
+		ctx2 = context.WithValue(ctx2, ctxRetryKey, true)
+	} else if h.retryer != nil && h.retryer.RetriesLoading(endpoint) {
+		ctx2 = context.WithValue(ctx2, ctxLoadingRetryKey, true)
+	}
+	if h.respCache != nil {
+		if key, ok := responseCacheKeyFor(reqMap); ok {
+			ctx2 = context.WithValue(ctx2, ctxRespCacheKey, key)
+		}
+	}
+	*r = *r.WithContext(ctx2)
+
+	// Explain requests carry no request ID and aren't recorded.
+	if _, ok := r.Context().Value(ctxRequestIDKey).(string); ok {
+		h.corpus.record(features, dec)
+	}
+	h.recordDecision(r, dec, bucket, optionsSnap)
+}
+
+// sizing is the context decision for one request before its body is
+// rewritten, with what the rewrite needs to apply it.
+type sizing struct {
+	dec               Decision
+	bucket            int
+	sample            calibration.Sample
+	numPredictCeiling int
+	thinkValue        any
+	applyThink        bool
+}
+
+// apply writes the sizing's num_ctx, num_predict and think into reqMap,
+// reporting whether it changed anything.
+func (sz sizing) apply(reqMap map[string]any) bool {
+	dec := sz.dec
+	if !dec.OverrideApplied && !dec.Clamped && !dec.NumPredictClamped && !sz.applyThink {
+		return false
+	}
+	if dec.OverrideApplied || dec.Clamped || dec.NumPredictClamped {
+		opt, ok := reqMap["options"].(map[string]any)
+		if !ok || opt == nil {
+			opt = make(map[string]any)
+		}
+		if dec.OverrideApplied || dec.Clamped {
+			opt["num_ctx"] = dec.ChosenCtx
+		}
+		if dec.NumPredictClamped {
+			opt["num_predict"] = sz.numPredictCeiling
+		}
+		reqMap["options"] = opt
+	}
+
+	if sz.applyThink {
+		if sz.thinkValue != nil {
+			reqMap["think"] = sz.thinkValue
+		} else {
+			delete(reqMap, "think")
+		}
+	}
+	return true
+}
+
+// size estimates features and picks a context size within lim under the
+// current config, escalating the output budget for session ("" for none). It
+// reads reqMap only for the client's think field and never modifies it;
+// features.CodeBytes is ignored unless code is estimated separately for the
+// model, and features.CustomTemplate without TEMPLATE_REPLACES_OVERHEAD.
+func (h *Handler) size(features estimate.Features, lim ctxLimits, systemPromptThinkVerdict, session string, reqMap map[string]any) sizing {
+	codeTokensPerByte := h.cfg.CodeTokensPerByteFor(features.Model)
+	if codeTokensPerByte <= 0 {
+		features.CodeBytes, features.CodeRoles = 0, estimate.RoleBytes{}
+	}
+	if !h.cfg.TemplateReplacesOverhead {
+		features.CustomTemplate = false
+	}
+	fam := h.families.Classify(features.Model)
+	roleWeights := estimate.RoleWeights(h.cfg.RoleWeightsFor(fam))
+	promptTokens := estimate.EstimatePromptTokens(features, lim.params, lim.tokensPerImage, codeTokensPerByte, roleWeights)
+	thinkVerdict, thinkSource, thinkValue, applyThink := h.resolveThink(features.Model, systemPromptThinkVerdict, reqMap)
+
+	numPredictCeiling := h.cfg.NumPredictCeilingFor(features.Model)
+	stop := estimate.StopBudget{
+		WithStop: h.cfg.StopBudgetFactor,
+		NoStop:   h.cfg.NoStopThinkBudgetFactor,
+		Thinking: thinkVerdict != "" && thinkVerdict != "false",
+	}
+	budgetResult := estimate.BudgetOutputTokens(features, h.cfg.DefaultOutputBudget, h.cfg.MaxOutputBudget, h.cfg.StructuredOverheadFor(features.Model), h.cfg.StructuredJSONBump, h.cfg.DynamicDefaultOutputBudget, promptTokens, numPredictCeiling, stop)
+	outputBudget, sessionLevel := h.escalateBudget(session, features.Model, budgetResult)
+	needed := promptTokens + outputBudget
+	headroom, confidence := h.headroomFor(lim.params)
+	headroom, confidence, unstable := h.unstableSizing(lim.params, headroom, confidence)
+	neededHeadroom := estimate.ApplyHeadroom(needed, headroom, h.cfg.MinAbsoluteHeadroom)
+	buckets := h.bucketsFor(features.Model, fam)
+	adjusted := h.utilization.Adjust(features.Model, neededHeadroom, promptTokens)
+	bucket, preferred := estimate.PreferCtx(adjusted, estimate.Bucketize(adjusted, buckets), h.cfg.PreferredCtxFor(features.Model), h.cfg.PreferredCtxTolerance)
+	desiredCtx := estimate.ClampCtx(bucket, lim.effMin, lim.effMax)
+	if unstable == config.UnstableCalibrationMaxCtx {
+		desiredCtx = estimate.ClampCtx(lim.effMax, lim.effMin, lim.effMax)
+	}
+
+	overridePolicy := h.cfg.OverridePolicyFor(features.Endpoint)
+	finalCtx, override, clamped := chooseFinalCtx(desiredCtx, lim.effMax, features.ProvidedNumCtx, features.ProvidedNumCtxOK, overridePolicy)
+
+	sample := calibration.Sample{
+		Model:        features.Model,
+		Endpoint:     features.Endpoint,
+		TextBytes:    int(math.Round(max(features.WeightedTextBytes(roleWeights)-features.WeightedCodeBytes(roleWeights), 0))),
+		MessageCount: features.MessageCount,
+		ImageTokens:  lim.tokensPerImage * features.ImageCount,
+		UsedCtx:      finalCtx,
+		CreatedAt:    time.Now(),
+
+		RequestedTokens: neededHeadroom,
+		CustomTemplate:  features.CustomTemplate,
+	}
+	if features.CodeBytes > 0 {
+		sample.CodeTokens = int(math.Ceil(codeTokensPerByte * float64(features.CodeBytes)))
+	}
+	return sizing{
+		dec: Decision{
+			Model:                 features.Model,
+			Endpoint:              features.Endpoint,
+			EstimatedPromptTokens: promptTokens,
+			OutputBudgetTokens:    outputBudget,
+			OutputBudgetSource:    budgetResult.Source,
+			StructuredOverhead:    budgetResult.StructuredOverhead,
+			StopAdjustment:        budgetResult.StopAdjustment,
+			NeededTokens:          needed,
+			NeededWithHeadroom:    neededHeadroom,
+			Headroom:              headroom,
+			Confidence:            confidence,
+			UnstableCalibration:   string(unstable),
+			PreferredCtx:          preferred,
+			ChosenCtx:             finalCtx,
+			UserCtx:               features.ProvidedNumCtx,
+			UserCtxProvided:       features.ProvidedNumCtxOK,
+			OverrideApplied:       override,
+			OverridePolicy:        string(overridePolicy),
+			Clamped:               clamped,
+			NumPredictClamped:     budgetResult.NumPredictClamped,
+			MaxConfigCtx:          h.cfg.MaxCtx,
+			MaxModelCtx:           lim.maxModelCtx,
+			MaxSafeCtx:            lim.maxSafe,
+			ThinkVerdict:          thinkVerdict,
+			ThinkSource:           thinkSource,
+			ThinkValue:            thinkValueJSON(thinkValue),
+			TextBytes:             features.TextBytes,
+			CodeBytes:             features.CodeBytes,
+			CustomTemplate:        features.CustomTemplate,
+			ShowFallback:          lim.showFallback,
+			UnverifiedCtxCap:      lim.unverifiedCap,
+			MaxVRAMCtx:            lim.maxVRAM,
+			UtilizationFactor:     utilizationFactor(h.utilization, features.Model),
+			SessionEscalation:     sessionLevel,
+		},
+		bucket:            bucket,
+		sample:            sample,
+		numPredictCeiling: numPredictCeiling,
+		thinkValue:        thinkValue,
+		applyThink:        applyThink,
+	}
+}
+
+// SetUtilizationLearner enables utilization-based downsizing: chat/generate
+// requests are sized with the learner's per-model factor and successful
+// responses feed it.
+func (h *Handler) SetUtilizationLearner(l *calibration.UtilizationLearner) {
+	h.utilization = l
+}
+
+// SetBucketAnalyzer has chat/generate decisions feed a, and sizes them with
+// the buckets it inserted into their model's ladder.
+func (h *Handler) SetBucketAnalyzer(a *calibration.BucketAnalyzer) {
+	h.bucketAnalyzer = a
+}
+
+// bucketsFor returns model's bucket ladder with any buckets the bucket
+// analyzer inserted.
+func (h *Handler) bucketsFor(model string, f family.Family) []int {
+	buckets, _ := h.cfg.BucketsFor(model, f)
+	return h.bucketAnalyzer.Buckets(model, buckets)
+}
+
+// SetIdleEvictor feeds chat/generate activity to the idle evictor so it can
+// tell which loaded models are idle.
+func (h *Handler) SetIdleEvictor(e *supervisor.IdleEvictor) {
+	h.idleEvictor = e
+}
+
+// SetVRAMGate holds back requests for models that don't fit in the free
+// VRAM.
+func (h *Handler) SetVRAMGate(g *supervisor.VRAMGate) {
+	h.vramGate = g
+}
+
+// SetResidencyPoller supplies the loaded models for VRAM_CTX_CAP_ENABLED.
+func (h *Handler) SetResidencyPoller(p *supervisor.ResidencyPoller) {
+	h.residency = p
+}
+
+// SetShadowMirror mirrors sampled non-streaming chat/generate requests to a
+// shadow upstream for comparison.
+func (h *Handler) SetShadowMirror(m *ShadowMirror) {
+	h.shadow = m
+}
+
+// SetDecisionCorpus writes the features and decisions of sampled
+// chat/generate requests to c.
+func (h *Handler) SetDecisionCorpus(c *DecisionCorpus) {
+	h.corpus = c
+}
+
+// SetStorageBreaker reports b's degraded state in /healthz.
+func (h *Handler) SetStorageBreaker(b *storage.BreakerStore) {
+	h.breaker = b
+}
+
+// utilizationFactor returns l's factor for model for the Decision, or 0 when
+// it leaves sizing unchanged.
+func utilizationFactor(l *calibration.UtilizationLearner, model string) float64 {
+	if f := l.Factor(model); f < 1 {
+		return f
+	}
+	return 0
+}
+
+// defaultShowTimeout applies when SHOW_TIMEOUT is unset in a hand-built Config.
+const defaultShowTimeout = 5 * time.Second
+
+// ctxLimits holds the per-model bounds used to clamp a context decision.
+type ctxLimits struct {
+	params         calibration.Params
+	tokensPerImage int
+	maxModelCtx    int
+	maxSafe        int
+	effMin         int
+	effMax         int
+	showFallback   string
+	unverifiedCap  int
+	maxVRAM        int
+}
+
+// resolveLimits looks up model metadata and calibration and derives the
+// effective [min,max] context range for model. It only fails when the
+// /api/show lookup timed out under SHOW_TIMEOUT_POLICY=fail_fast.
+func (h *Handler) resolveLimits(parent context.Context, model string) (ctxLimits, error) {
+	timeout := h.cfg.ShowTimeout
+	if timeout <= 0 {
+		timeout = defaultShowTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
-	show, showErr := h.showCache.Get(ctx, features.Model)
+	show, showErr := h.showCache.Get(ctx, model)
 	maxModelCtx, _ := show.MaxContextLength()
-	if showErr != nil {
-		h.logger.Debug("/api/show failed; using config max only", "model", features.Model, "err", showErr)
+	showFallback := ""
+	if showErr == nil {
+		if maxModelCtx > 0 {
+			h.showMaxMu.Lock()
+			h.showMax[model] = maxModelCtx
+			h.showMaxMu.Unlock()
+		}
+	} else {
+		if h.cfg.ShowTimeoutPolicy == config.ShowTimeoutFailFast && errors.Is(showErr, context.DeadlineExceeded) {
+			return ctxLimits{}, showErr
+		}
+		show, maxModelCtx, showFallback = h.showFallback(model)
+		h.logger.Debug("/api/show failed", "model", model, "err", showErr, "fallback", showFallback)
 	}
 
+	fam := string(h.families.Classify(model))
 	tokensPerImage, ok := show.TokensPerImage()
 	if !ok {
 		tokensPerImage = h.cfg.DefaultTokensPerImageFallback
+		if v, ok := h.cfg.FamilyTokensPerImage[fam]; ok {
+			tokensPerImage = v
+		}
 	}
 
-	params := h.calib.Get(features.Model)
+	// Until calibration has learned this model, start from the family's ratio
+	// (and its overhead override), and only trust the learned values fully
+	// after CalibrationMinSamples.
+	prior := h.calib.DefaultsFor(model)
+	if v, ok := h.cfg.FamilyTokensPerByte[fam]; ok {
+		prior.TokensPerByte = v
+	}
+	params := calibration.Blend(h.calib.Get(model), prior, h.cfg.CalibrationMinSamples)
 
 	effMax := h.cfg.MaxCtx
 	maxSafe := 0
@@ -464,99 +1345,104 @@ func (h *Handler) rewriteRequestIfPossible(endpoint string, r *http.Request) {
 	if maxModelCtx > 0 && maxModelCtx < effMax {
 		effMax = maxModelCtx
 	}
+	// A model whose max couldn't be learned gets no more than the
+	// conservative UNVERIFIED_MAX_CTX.
+	unverifiedCap := 0
+	if showErr != nil && maxModelCtx <= 0 && h.cfg.UnverifiedMaxCtx > 0 && h.cfg.UnverifiedMaxCtx < effMax {
+		effMax = h.cfg.UnverifiedMaxCtx
+		unverifiedCap = effMax
+	}
+	maxVRAM := h.vramCtxCap(model, show)
+	if maxVRAM > 0 && maxVRAM < effMax {
+		effMax = maxVRAM
+	}
 	effMin := h.cfg.MinCtx
 	if effMax > 0 && effMin > effMax {
 		effMin = effMax
 	}
 
-	promptTokens := estimate.EstimatePromptTokens(features, params, tokensPerImage)
-	budgetResult := estimate.BudgetOutputTokens(features, h.cfg.DefaultOutputBudget, h.cfg.MaxOutputBudget, h.cfg.StructuredOverhead, h.cfg.DynamicDefaultOutputBudget, promptTokens)
-	outputBudget := budgetResult.Budget
-	needed := promptTokens + outputBudget
-	neededHeadroom := estimate.ApplyHeadroom(needed, h.cfg.Headroom)
-	bucket := estimate.Bucketize(neededHeadroom, h.cfg.Buckets)
-	desiredCtx := estimate.ClampCtx(bucket, effMin, effMax)
-
-	finalCtx, override, clamped := chooseFinalCtx(desiredCtx, effMax, features.ProvidedNumCtx, features.ProvidedNumCtxOK, h.cfg.OverrideNumCtx)
+	return ctxLimits{
+		params:         params,
+		tokensPerImage: tokensPerImage,
+		maxModelCtx:    maxModelCtx,
+		maxSafe:        maxSafe,
+		effMin:         effMin,
+		effMax:         effMax,
+		showFallback:   showFallback,
+		unverifiedCap:  unverifiedCap,
+		maxVRAM:        maxVRAM,
+	}, nil
+}
 
-	finalThinkVerdict := ""
-	if systemPromptThinkVerdict != "" {
-		modelLower := strings.ToLower(features.Model)
-		if strings.HasPrefix(modelLower, "qwen3") || strings.HasPrefix(modelLower, "deepseek") {
-			if systemPromptThinkVerdict == "true" || systemPromptThinkVerdict == "false" {
-				finalThinkVerdict = systemPromptThinkVerdict
-			}
-		} else if strings.HasPrefix(modelLower, "gpt-oss") {
-			if systemPromptThinkVerdict == "low" || systemPromptThinkVerdict == "medium" || systemPromptThinkVerdict == "high" {
-				finalThinkVerdict = systemPromptThinkVerdict
-			}
+// showFallback picks the model metadata to size with after a failed /api/show
+// lookup, per SHOW_TIMEOUT_POLICY, and names its source for the Decision.
+func (h *Handler) showFallback(model string) (ollama.ShowResponse, int, string) {
+	switch h.cfg.ShowTimeoutPolicy {
+	case config.ShowTimeoutStale:
+		if show, ok := h.showCache.Peek(model); ok {
+			maxModelCtx, _ := show.MaxContextLength()
+			return show, maxModelCtx, "stale"
 		}
-	}
-
-	needsRewrite := override || clamped || finalThinkVerdict != ""
-
-	if needsRewrite {
-		if override || clamped {
-			opt, ok := reqMap["options"].(map[string]any)
-			if !ok || opt == nil {
-				opt = make(map[string]any)
-			}
-			opt["num_ctx"] = finalCtx
-			reqMap["options"] = opt
+	case config.ShowTimeoutRemembered:
+		h.showMaxMu.Lock()
+		maxModelCtx, ok := h.showMax[model]
+		h.showMaxMu.Unlock()
+		if ok {
+			return ollama.ShowResponse{}, maxModelCtx, "remembered"
 		}
+	}
+	return ollama.ShowResponse{}, 0, "none"
+}
 
-		if finalThinkVerdict != "" {
-			modelLower := strings.ToLower(features.Model)
-			if strings.HasPrefix(modelLower, "qwen3") || strings.HasPrefix(modelLower, "deepseek") {
-				reqMap["think"] = (finalThinkVerdict == "true")
-			} else if strings.HasPrefix(modelLower, "gpt-oss") {
-				reqMap["think"] = finalThinkVerdict
-			}
-		}
+// rejectShowTimeout marks r to be answered 503 because model metadata could
+// not be fetched in time (SHOW_TIMEOUT_POLICY=fail_fast).
+func (h *Handler) rejectShowTimeout(r *http.Request, model string, err error) {
+	h.logger.Warn("/api/show timed out; failing fast", "path", r.URL.Path, "model", model, "err", err)
+	rej := rejection{
+		code:   http.StatusServiceUnavailable,
+		status: supervisor.StatusShowTimeout,
+		reason: storage.ReasonShowTimeout,
+		msg:    fmt.Sprintf("model metadata lookup for %q timed out", model),
+	}
+	*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+}
 
-		newBody, err := util.EncodeJSON(reqMap)
-		if err != nil {
-			return
-		}
-		setBody(r, newBody)
-	}
+// rejectOversizePrompt marks r to be answered 413 when its estimated prompt
+// exceeds MAX_PROMPT_TOKENS for model, whatever context the model allows,
+// and reports whether it did.
+func (h *Handler) rejectOversizePrompt(r *http.Request, model string, promptTokens int) bool {
+	limit := h.cfg.MaxPromptTokensFor(model)
+	if limit <= 0 || promptTokens <= limit {
+		return false
+	}
+	h.logger.Warn("rejecting request: estimated prompt exceeds MAX_PROMPT_TOKENS", "path", r.URL.Path, "model", model,
+		"prompt_tokens_est", promptTokens, "limit", limit)
+	rej := rejection{
+		code:   http.StatusRequestEntityTooLarge,
+		status: supervisor.StatusPromptTooLarge,
+		reason: storage.ReasonPromptTooLarge,
+		msg:    fmt.Sprintf("prompt of ~%d tokens exceeds the %d-token limit for %q", promptTokens, limit, model),
+	}
+	*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+	return true
+}
 
-	imageTokens := tokensPerImage * features.ImageCount
-	sample := calibration.Sample{
-		Model:        features.Model,
-		Endpoint:     endpoint,
-		TextBytes:    features.TextBytes,
-		MessageCount: features.MessageCount,
-		ImageTokens:  imageTokens,
-		UsedCtx:      finalCtx,
-		CreatedAt:    time.Now(),
-	}
-	dec := Decision{
-		Model:                 features.Model,
-		Endpoint:              endpoint,
-		EstimatedPromptTokens: promptTokens,
-		OutputBudgetTokens:    outputBudget,
-		OutputBudgetSource:    budgetResult.Source,
-		NeededTokens:          needed,
-		NeededWithHeadroom:    neededHeadroom,
-		ChosenCtx:             finalCtx,
-		UserCtx:               features.ProvidedNumCtx,
-		UserCtxProvided:       features.ProvidedNumCtxOK,
-		OverrideApplied:       override,
-		Clamped:               clamped,
-		MaxConfigCtx:          h.cfg.MaxCtx,
-		MaxModelCtx:           maxModelCtx,
-		MaxSafeCtx:            maxSafe,
-		ThinkVerdict:          finalThinkVerdict,
-	}
-
-	ctx2 := context.WithValue(r.Context(), ctxSampleKey, sample)
-	ctx2 = context.WithValue(ctx2, ctxDecisionKey, dec)
-	if clamped {
-		ctx2 = context.WithValue(ctx2, ctxClampedKey, true)
-	}
-	*r = *r.WithContext(ctx2)
+// rejectUnfiltered rejects a chat/generate body OPTIONS_ALLOWLIST couldn't
+// be applied to, so unlisted options can't reach the upstream unfiltered.
+func (h *Handler) rejectUnfiltered(r *http.Request, why string) {
+	h.logger.Warn("rejecting request: options allow-list can't be applied", "path", r.URL.Path, "why", why)
+	rej := rejection{
+		code:   http.StatusBadRequest,
+		status: supervisor.StatusOptionsUnfiltered,
+		reason: storage.ReasonOptionsUnfiltered,
+		msg:    why + "; OPTIONS_ALLOWLIST requires a JSON object body the proxy can filter",
+	}
+	*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+	setBody(r, nil)
+}
 
+// recordDecision pushes a context decision to the tracker and storage and logs it.
+func (h *Handler) recordDecision(r *http.Request, dec Decision, bucket int, options string) {
 	// Update tracker and storage with context data
 	if reqIDVal := r.Context().Value(ctxRequestIDKey); reqIDVal != nil {
 		if reqID, ok := reqIDVal.(string); ok {
@@ -578,19 +1464,114 @@ func (h *Handler) rewriteRequestIfPossible(endpoint string, r *http.Request) {
 				if upstreamInBytes > 0 {
 					upd.UpstreamInBytes = &upstreamInBytes
 				}
+				if dec.ThinkSource != "" {
+					thinkVerdict, thinkSource, thinkValue := dec.ThinkVerdict, dec.ThinkSource, dec.ThinkValue
+					upd.ThinkVerdict = &thinkVerdict
+					upd.ThinkSource = &thinkSource
+					upd.ThinkValue = &thinkValue
+				}
+				if dec.ImagesDropped > 0 {
+					imagesDropped := dec.ImagesDropped
+					upd.ImagesDropped = &imagesDropped
+				}
 				h.store.Update(reqID, upd)
 			}
 		}
 	}
+	if dec.UnstableCalibration != "" {
+		h.metrics.RecordUnstableCalibration(dec.Model, dec.UnstableCalibration)
+	}
+	if dec.PreferredCtx {
+		h.metrics.RecordPreferredCtx(dec.Model)
+	}
+	if added := h.bucketAnalyzer.Observe(dec.Model, dec.NeededWithHeadroom, bucket); added > 0 {
+		h.logger.Info("inserted context bucket", "model", dec.Model, "bucket", added, "needed", dec.NeededWithHeadroom, "previous_bucket", bucket)
+	}
 
 	h.logger.Info("ctx decision",
 		"path", r.URL.Path,
 		"model", dec.Model,
 		"prompt_tokens_est", dec.EstimatedPromptTokens,
 		"output_budget", dec.OutputBudgetTokens,
+		"structured_overhead", dec.StructuredOverhead,
+		"stop_adjust", dec.StopAdjustment,
+		"headroom", dec.Headroom,
+		"headroom_tokens", dec.NeededWithHeadroom-dec.NeededTokens,
+		"unstable_calibration", dec.UnstableCalibration,
+		"preferred_ctx", dec.PreferredCtx,
 		"chosen_ctx", dec.ChosenCtx,
+		"override_policy", dec.OverridePolicy,
 		"clamped", dec.Clamped,
+		"num_predict_clamped", dec.NumPredictClamped,
+		"sampled", dec.Sampled,
+		"think", dec.ThinkVerdict,
+		"think_source", dec.ThinkSource,
+		"think_value", dec.ThinkValue,
+		"images_dropped", dec.ImagesDropped,
+		"code_bytes", dec.CodeBytes,
+		"prose_bytes", dec.TextBytes-dec.CodeBytes,
+		"show_fallback", dec.ShowFallback,
+		"unverified_ctx_cap", dec.UnverifiedCtxCap,
+		"max_vram_ctx", dec.MaxVRAMCtx,
+		"utilization_factor", dec.UtilizationFactor,
+		"session_escalation", dec.SessionEscalation,
+		"seed_policy", dec.SeedPolicy,
+		"seed", dec.Seed,
+		"options", options,
 	)
+
+	if h.eventBus != nil {
+		reqID, _ := r.Context().Value(ctxRequestIDKey).(string)
+		if raw, err := json.Marshal(dec); err == nil {
+			h.eventBus.Publish(supervisor.Event{
+				Type:      supervisor.EventDecision,
+				RequestID: reqID,
+				Timestamp: time.Now(),
+				Endpoint:  dec.Endpoint,
+				Model:     dec.Model,
+				Decision:  raw,
+			})
+		}
+	}
+}
+
+// headroomFor returns the headroom multiplier for an estimate made with
+// params, and the calibration confidence it was scaled by (0 when
+// HEADROOM_CONFIDENCE_ENABLED is off).
+func (h *Handler) headroomFor(params calibration.Params) (float64, float64) {
+	if !h.cfg.HeadroomConfidenceEnabled {
+		return h.cfg.Headroom, 0
+	}
+	confidence := params.Confidence()
+	headroom := estimate.ConfidenceHeadroom(h.cfg.Headroom, confidence, h.cfg.HeadroomLowConfidenceFactor, h.cfg.HeadroomHighConfidenceFactor)
+	return max(headroom, 1), confidence
+}
+
+// unstableSizing applies UNSTABLE_CALIBRATION_POLICY to an estimate made
+// with params while its model's calibration confidence is below
+// UNSTABLE_CALIBRATION_CONFIDENCE, returning the headroom and confidence to
+// record and the policy applied; otherwise it returns them unchanged and "".
+// Under max_ctx the caller sizes the request to the largest safe context.
+func (h *Handler) unstableSizing(params calibration.Params, headroom, confidence float64) (float64, float64, config.UnstableCalibrationPolicy) {
+	policy := h.cfg.UnstableCalibrationPolicy
+	if policy != config.UnstableCalibrationMaxCtx && policy != config.UnstableCalibrationHeadroom {
+		return headroom, confidence, ""
+	}
+	c := params.Confidence()
+	if c >= h.cfg.UnstableCalibrationConfidence {
+		return headroom, confidence, ""
+	}
+	if policy == config.UnstableCalibrationHeadroom {
+		headroom *= h.cfg.UnstableCalibrationHeadroomFactor
+	}
+	return headroom, c, policy
+}
+
+// optionsFilter returns the options kept in decision logs and stored
+// snapshots, per SNAPSHOT_OPTION_KEYS, EXCLUDE_OPTION_KEYS and
+// REDACT_OPTION_KEYS.
+func (h *Handler) optionsFilter() OptionsFilter {
+	return OptionsFilter{Keys: h.cfg.SnapshotOptionKeys, Exclude: h.cfg.ExcludeOptionKeys, Redact: h.cfg.RedactOptionKeys}
 }
 
 func chooseFinalCtx(desiredCtx, hardMax int, userCtx int, userProvided bool, policy config.OverridePolicy) (finalCtx int, override bool, clamped bool) {
@@ -625,6 +1606,31 @@ func chooseFinalCtx(desiredCtx, hardMax int, userCtx int, userProvided bool, pol
 	}
 }
 
+// decodeRequestBody decodes a chat/generate body. Unless STRICT_JSON_BODY is
+// set, content after the first JSON value is ignored and dropped from the
+// forwarded body, so a client's stray trailing bytes don't skip sizing.
+func (h *Handler) decodeRequestBody(r *http.Request, body *[]byte) (map[string]any, error) {
+	if h.cfg.StrictJSONBody {
+		return util.DecodeJSONMap(*body)
+	}
+	reqMap, end, err := util.DecodeFirstJSONMap(*body)
+	if err != nil {
+		return nil, err
+	}
+	if trailing := bytes.TrimSpace((*body)[end:]); len(trailing) > 0 {
+		h.logger.Debug("ignoring content after the JSON body", "path", r.URL.Path, "trailing_bytes", len(trailing))
+		*body = (*body)[:end]
+		setBody(r, *body)
+	}
+	return reqMap, nil
+}
+
+// looksLikeJSONObject reports whether the first non-whitespace byte of b is '{'.
+func looksLikeJSONObject(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	return len(b) > 0 && b[0] == '{'
+}
+
 func setBody(r *http.Request, b []byte) {
 	r.Body = io.NopCloser(bytes.NewReader(b))
 	r.ContentLength = int64(len(b))
@@ -636,6 +1642,31 @@ func (h *Handler) generateRequestID() string {
 	return strconv.FormatInt(id, 10)
 }
 
+// reject answers a request the proxy refused to forward with an error
+// response instead of forwarding it, and closes out its tracking.
+func (h *Handler) reject(w http.ResponseWriter, reqID string, rej rejection, startTime time.Time) {
+	h.finalizeStorageFromTracker(reqID, rej.status, "", startTime)
+	if h.tracker != nil && h.tracker.GetRequestInfo(reqID) != nil {
+		h.tracker.Finish(reqID, rej.status, errors.New(rej.msg))
+	}
+	h.writeError(w, rej.code, string(rej.reason), rej.msg, rej.retryAfter)
+}
+
+// loopDetectorConfig returns the loop detection settings for model, with its
+// family's FAMILY_LOOP_REPEAT_THRESHOLD applied.
+func (h *Handler) loopDetectorConfig(model string) supervisor.LoopDetectorConfig {
+	repeatThreshold := h.cfg.LoopRepeatThreshold
+	if v, ok := h.cfg.FamilyLoopRepeatThreshold[string(h.families.Classify(model))]; ok {
+		repeatThreshold = v
+	}
+	return supervisor.LoopDetectorConfig{
+		WindowBytes:     h.cfg.LoopWindowBytes,
+		NgramBytes:      h.cfg.LoopNgramBytes,
+		RepeatThreshold: repeatThreshold,
+		MinOutputBytes:  h.cfg.LoopMinOutputBytes,
+	}
+}
+
 // finalizeStorageFromTracker updates the storage with final request data from tracker.
 func (h *Handler) finalizeStorageFromTracker(reqID string, status supervisor.RequestStatus, reason string, startTime time.Time) {
 	if h.store == nil || reqID == "" {
@@ -663,6 +1694,9 @@ func (h *Handler) finalizeStorageFromTracker(reqID string, status supervisor.Req
 	case supervisor.StatusTimeoutHard:
 		storageStatus = storage.StatusError
 		storageReason = storage.ReasonTimeoutHard
+	case supervisor.StatusTimeoutGlobal:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonTimeoutGlobal
 	case supervisor.StatusUpstreamError:
 		storageStatus = storage.StatusError
 		storageReason = storage.ReasonUpstreamError
@@ -672,6 +1706,36 @@ func (h *Handler) finalizeStorageFromTracker(reqID string, status supervisor.Req
 	case supervisor.StatusOutputLimitExceeded:
 		storageStatus = storage.StatusError
 		storageReason = storage.ReasonOutputLimitExceeded
+	case supervisor.StatusInvalidImages:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonInvalidImages
+	case supervisor.StatusShowTimeout:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonShowTimeout
+	case supervisor.StatusImageBudgetExceeded:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonImageBudgetExceeded
+	case supervisor.StatusOptionsUnfiltered:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonOptionsUnfiltered
+	case supervisor.StatusModelBusy:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonModelBusy
+	case supervisor.StatusIdempotencyMismatch:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonIdempotencyMismatch
+	case supervisor.StatusPromptTooLarge:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonPromptTooLarge
+	case supervisor.StatusQuotaExceeded:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonQuotaExceeded
+	case supervisor.StatusPromptRejected:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonPromptRejected
+	case supervisor.StatusVRAMInsufficient:
+		storageStatus = storage.StatusError
+		storageReason = storage.ReasonVRAMInsufficient
 	default:
 		storageStatus = storage.StatusError
 	}
@@ -696,6 +1760,16 @@ func (h *Handler) finalizeStorageFromTracker(reqID string, status supervisor.Req
 					upd.TTFBMs = &ttfb
 				}
 			}
+			if info.ForwardTime != nil {
+				estimateMs := int(info.EstimateDuration.Milliseconds())
+				forwardMs := int(info.ForwardTime.Sub(info.StartTime).Milliseconds())
+				upd.EstimateMs = &estimateMs
+				upd.ForwardMs = &forwardMs
+			}
+			if info.UpstreamDoneTime != nil {
+				upstreamDoneMs := int(info.UpstreamDoneTime.Sub(info.StartTime).Milliseconds())
+				upd.UpstreamDoneMs = &upstreamDoneMs
+			}
 			if info.PromptEvalCount > 0 {
 				pt := info.PromptEvalCount
 				upd.PromptTokens = &pt
@@ -708,6 +1782,10 @@ func (h *Handler) finalizeStorageFromTracker(reqID string, status supervisor.Req
 				bytes := info.BytesForwarded
 				upd.ClientOutBytes = &bytes
 			}
+			if cost, estimated, ok := h.requestCost(info); ok {
+				upd.Cost = &cost
+				upd.CostEstimated = &estimated
+			}
 		}
 	}
 
@@ -726,11 +1804,13 @@ func (h *Handler) handleDebugRequests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snapshot := h.tracker.Snapshot()
+	snapshot := filterSnapshot(h.tracker.Snapshot(), r.URL.Query())
 
 	type EnrichedRequestInfo struct {
 		supervisor.RequestInfo
 		EstimatedOutputTokens int64 `json:"estimated_output_tokens"`
+		// Progress (percent complete, ETA) is only set for in-flight requests.
+		*supervisor.Progress
 	}
 
 	type Response struct {
@@ -743,11 +1823,14 @@ func (h *Handler) handleDebugRequests(w http.ResponseWriter, r *http.Request) {
 		Recent:   make([]EnrichedRequestInfo, 0, len(snapshot.Recent)),
 	}
 
+	now := time.Now()
 	for id, req := range snapshot.InFlight {
 		estTokens := supervisor.EstimateOutputTokens(req.BytesForwarded, req.Model, h.calib, h.cfg.DefaultTokensPerByte)
+		progress := h.tracker.Progress(req, now)
 		resp.InFlight[id] = EnrichedRequestInfo{
 			RequestInfo:           req,
 			EstimatedOutputTokens: estTokens,
+			Progress:              &progress,
 		}
 	}
 
@@ -763,6 +1846,69 @@ func (h *Handler) handleDebugRequests(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// hasSnapshotFilter reports whether q sets any filter filterSnapshot applies.
+func hasSnapshotFilter(q url.Values) bool {
+	return q.Has("status") || q.Has("model") || q.Has("limit")
+}
+
+// filterSnapshot applies the /autoctx/api/v1/requests filters (status, model,
+// limit) to a tracker snapshot. status=in_flight keeps only in-flight requests,
+// status=error matches every failure status, and limit keeps the newest
+// in-flight and recent entries.
+func filterSnapshot(snap supervisor.Snapshot, q url.Values) supervisor.Snapshot {
+	status, model := q.Get("status"), q.Get("model")
+	match := func(req supervisor.RequestInfo) bool {
+		return model == "" || req.Model == model
+	}
+
+	inFlight := make(map[string]supervisor.RequestInfo, len(snap.InFlight))
+	if status == "" || status == string(storage.StatusInFlight) {
+		for id, req := range snap.InFlight {
+			if match(req) {
+				inFlight[id] = req
+			}
+		}
+	}
+
+	recent := make([]supervisor.RequestInfo, 0, len(snap.Recent))
+	if status != string(storage.StatusInFlight) {
+		for _, req := range snap.Recent {
+			if !match(req) {
+				continue
+			}
+			switch status {
+			case "", string(req.Status):
+			case string(storage.StatusError):
+				if req.Status == supervisor.StatusSuccess || req.Status == supervisor.StatusCanceled {
+					continue
+				}
+			default:
+				continue
+			}
+			recent = append(recent, req)
+		}
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit >= 0 {
+		if limit < len(recent) {
+			recent = recent[len(recent)-limit:]
+		}
+		if limit < len(inFlight) {
+			ids := make([]string, 0, len(inFlight))
+			for id := range inFlight {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return inFlight[ids[i]].StartTime.After(inFlight[ids[j]].StartTime) })
+			for _, id := range ids[limit:] {
+				delete(inFlight, id)
+			}
+		}
+	}
+
+	snap.InFlight = inFlight
+	snap.Recent = recent
+	return snap
+}
+
 func (h *Handler) handleSSEEvents(w http.ResponseWriter, r *http.Request) {
 	if h.eventBus == nil {
 		http.Error(w, "event bus not available", http.StatusServiceUnavailable)
@@ -783,7 +1929,12 @@ func (h *Handler) handleSSEEvents(w http.ResponseWriter, r *http.Request) {
 	}
 
 	eventCh := h.eventBus.Subscribe()
-	defer h.eventBus.Unsubscribe(eventCh)
+	defer func() {
+		if dropped := h.eventBus.Dropped(eventCh); dropped > 0 {
+			h.logger.Debug("events subscriber disconnected after missing events", "remote", r.RemoteAddr, "dropped", dropped)
+		}
+		h.eventBus.Unsubscribe(eventCh)
+	}()
 
 	_, _ = w.Write([]byte(": connected\n\n"))
 	flusher.Flush()
@@ -797,6 +1948,9 @@ func (h *Handler) handleSSEEvents(w http.ResponseWriter, r *http.Request) {
 			if !ok {
 				return
 			}
+			if !event.Lifecycle() {
+				continue // see /autoctx/api/v1/decisions
+			}
 			sseData, err := supervisor.FormatSSEEvent(event)
 			if err != nil {
 				continue
@@ -814,7 +1968,9 @@ func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "metrics not enabled", http.StatusServiceUnavailable)
 		return
 	}
-	promhttp.Handler().ServeHTTP(w, r)
+	// promhttp.Handler, plus any scrape-only metrics.
+	promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(h.metrics.Gatherer(), promhttp.HandlerOpts{})).ServeHTTP(w, r)
 }
 
 func (h *Handler) handleHealthz(w http.ResponseWriter, r *http.Request) {
@@ -823,8 +1979,13 @@ func (h *Handler) handleHealthz(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("upstream unhealthy"))
 		return
 	}
+	// The proxy keeps serving without storage, so this stays a 200.
+	body := "ok"
+	if h.breaker.Degraded() {
+		body = "ok (storage degraded)"
+	}
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("ok"))
+	_, _ = w.Write([]byte(body))
 }
 
 func (h *Handler) handleHealthzUpstream(w http.ResponseWriter, r *http.Request) {
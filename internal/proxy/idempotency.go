@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader lets clients retry a non-streaming chat/generate
+// request without the model running again: a repeat of the same key and body
+// within IDEMPOTENCY_TTL is answered with the first response. It is never
+// forwarded to Ollama.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set on responses replayed for a repeated key.
+const IdempotentReplayHeader = "X-Ollama-CtxProxy-Idempotent-Replay"
+
+// idempotencyRequest is the client's Idempotency-Key, with the hash of its
+// body once the body was read and found to be non-streaming.
+type idempotencyRequest struct {
+	key       string
+	bodyHash  [sha256.Size]byte
+	nonStream bool
+}
+
+// cachedResponse is a complete upstream response kept for replay.
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	trailer http.Header
+}
+
+// idempotentEntry is the first request for a key. done is closed when it
+// finishes; resp is then set if its response can be replayed.
+type idempotentEntry struct {
+	bodyHash [sha256.Size]byte
+	done     chan struct{}
+	resp     *cachedResponse
+	expires  time.Time
+}
+
+// idempotencyCache holds up to maxEntries responses for ttl after they
+// complete. Entries are evicted oldest first. It is safe for concurrent use.
+type idempotencyCache struct {
+	ttl          time.Duration
+	maxEntries   int
+	maxBodyBytes int
+	now          func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+	order   []string // keys by insertion, oldest first
+}
+
+func newIdempotencyCache(ttl time.Duration, maxEntries int, maxBodyBytes int64) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:          ttl,
+		maxEntries:   maxEntries,
+		maxBodyBytes: int(maxBodyBytes),
+		now:          time.Now,
+		entries:      make(map[string]*idempotentEntry),
+	}
+}
+
+// begin returns the entry for key. first is true when there was none and the
+// caller must run the request and call finish; otherwise the caller waits on
+// the entry. mismatch is true when key was used for a different body.
+func (c *idempotencyCache) begin(key string, bodyHash [sha256.Size]byte) (entry *idempotentEntry, first, mismatch bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked()
+	if e, ok := c.entries[key]; ok && (e.resp == nil || !c.now().After(e.expires)) {
+		return e, false, e.bodyHash != bodyHash
+	}
+	e := &idempotentEntry{bodyHash: bodyHash, done: make(chan struct{})}
+	c.entries[key] = e
+	c.order = append(c.order, key)
+	c.evictLocked()
+	return e, true, false
+}
+
+// finish completes the first request for key. A response that can't be
+// replayed (nil) drops the entry, so the next try runs the model again.
+func (c *idempotencyCache) finish(key string, e *idempotentEntry, resp *cachedResponse) {
+	c.mu.Lock()
+	if resp != nil {
+		e.resp = resp
+		e.expires = c.now().Add(c.ttl)
+	} else if c.entries[key] == e {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
+
+// evictLocked drops expired entries and the oldest ones over maxEntries.
+// Keys in order whose entry was dropped or replaced are skipped.
+func (c *idempotencyCache) evictLocked() {
+	now := c.now()
+	for len(c.order) > 0 {
+		key := c.order[0]
+		e, ok := c.entries[key]
+		switch {
+		case !ok:
+		case len(c.entries) > c.maxEntries:
+			delete(c.entries, key)
+		case e.resp != nil && now.After(e.expires):
+			delete(c.entries, key)
+		default:
+			return
+		}
+		c.order = c.order[1:]
+	}
+}
+
+// idempotencyRecorder passes a response through to the client while keeping
+// a copy of it, up to maxBodyBytes, for replay.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	max      int
+	status   int
+	header   http.Header
+	declared []string // the "Trailer" header, which replays send afresh
+	body     []byte
+	overflow bool
+}
+
+func (w *idempotencyRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		w.header = w.ResponseWriter.Header().Clone()
+		w.declared = w.header.Values("Trailer")
+		w.header.Del("Trailer")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if len(w.body)+len(b) > w.max {
+			w.overflow, w.body = true, nil
+		} else {
+			w.body = append(w.body, b...)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the client's writer to flush.
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// response returns the recorded response if it can be replayed: a complete
+// 200 within the size limit. Its trailers are in the client's header by now.
+func (w *idempotencyRecorder) response() *cachedResponse {
+	if w.status != http.StatusOK || w.overflow {
+		return nil
+	}
+	trailer := responseTrailers(w.ResponseWriter.Header(), w.declared)
+	return &cachedResponse{status: w.status, header: w.header, body: w.body, trailer: trailer}
+}
+
+// replay writes a cached response for a repeated Idempotency-Key.
+func replay(w http.ResponseWriter, resp *cachedResponse) {
+	for k, v := range resp.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(IdempotentReplayHeader, "true")
+	announceTrailers(w, resp.trailer)
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body)
+	setTrailers(w, resp.trailer)
+}
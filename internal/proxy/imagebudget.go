@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// applyImageBudget enforces IMAGE_BUDGET_POLICY when the request's image
+// tokens alone exceed IMAGE_BUDGET_FRACTION of effMax, so a vision request is
+// never silently sized to a context that can't hold all of its images.
+// Under drop the oldest images are removed from reqMap (always keeping at
+// least one) and features.ImageCount is updated; it returns how many were
+// dropped. Under reject it stores a rejection on r and returns ok=false.
+func (h *Handler) applyImageBudget(r *http.Request, endpoint string, reqMap map[string]any, features *estimate.Features, invalidImages, tokensPerImage, effMax int) (dropped int, ok bool) {
+	policy := h.cfg.ImageBudgetPolicy
+	if policy != config.ImageBudgetDrop && policy != config.ImageBudgetReject {
+		return 0, true
+	}
+	if tokensPerImage <= 0 || effMax <= 0 || features.ImageCount == 0 {
+		return 0, true
+	}
+	budget := int(h.cfg.ImageBudgetFraction * float64(effMax))
+	imageTokens := tokensPerImage * features.ImageCount
+	if imageTokens <= budget {
+		return 0, true
+	}
+
+	if policy == config.ImageBudgetReject {
+		h.logger.Warn("image tokens exceed budget; rejecting", "path", r.URL.Path, "model", features.Model,
+			"images", features.ImageCount, "image_tokens", imageTokens, "budget", budget)
+		rej := rejection{
+			code:   http.StatusBadRequest,
+			status: supervisor.StatusImageBudgetExceeded,
+			reason: storage.ReasonImageBudgetExceeded,
+			msg: fmt.Sprintf("%d image(s) need ~%d tokens, more than %d of the %d-token context for %q; send at most %d",
+				features.ImageCount, imageTokens, budget, effMax, features.Model, max(budget/tokensPerImage, 1)),
+		}
+		*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+		return 0, false
+	}
+
+	// keep counts entries, so invalid ones kept only leave more room.
+	keep := max(budget/tokensPerImage, 1)
+	dropped = estimate.DropImages(endpoint, reqMap, keep)
+	if dropped == 0 {
+		return 0, true
+	}
+	before := features.ImageCount
+	features.ImageCount = before + invalidImages - dropped
+	if invalidImages > 0 {
+		features.ImageCount -= estimate.CountInvalidImages(endpoint, reqMap)
+	}
+	h.logger.Warn("image tokens exceed budget; dropped oldest images", "path", r.URL.Path, "model", features.Model,
+		"images", before, "kept", features.ImageCount, "dropped", dropped, "budget", budget)
+	return dropped, true
+}
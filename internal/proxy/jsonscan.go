@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// maxScanDepth bounds the nesting jsonFieldScanner follows; deeper bodies
+// stop being scanned.
+const maxScanDepth = 32
+
+// jsonFieldScanner picks scalar fields out of a JSON object fed in chunks,
+// without holding the body. Only object keys and number or literal tokens
+// are kept while scanning; string values (a long completion) are skipped.
+//
+// The tap uses it for non-stream responses larger than RESPONSE_TAP_MAX_BYTES,
+// so the token counts and durations Ollama sends after the response text
+// survive when the body can't be buffered and decoded whole.
+type jsonFieldScanner struct {
+	want   map[string]bool // dotted paths of wanted fields, e.g. "usage.prompt_tokens"
+	fields map[string]any  // found values: json.Number or bool
+
+	stack    []scanFrame
+	inString bool
+	escape   bool
+	isKey    bool // the string being read is an object key
+	key      []byte
+	lit      []byte // number or literal being read
+	inLit    bool
+	broken   bool
+}
+
+type scanFrame struct {
+	object    bool
+	expectKey bool
+	key       string
+}
+
+// tapScanFields are the fields tryParseJSON reads from a non-stream body.
+var tapScanFields = []string{
+	"prompt_eval_count", "eval_count", "done",
+	"total_duration", "load_duration", "prompt_eval_duration", "eval_duration",
+	"usage.prompt_tokens", "usage.completion_tokens",
+}
+
+func newJSONFieldScanner(paths []string) *jsonFieldScanner {
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[p] = true
+	}
+	return &jsonFieldScanner{want: want, fields: make(map[string]any)}
+}
+
+// Write scans the next chunk of the body.
+func (s *jsonFieldScanner) Write(b []byte) {
+	for i := 0; i < len(b) && !s.broken; i++ {
+		c := b[i]
+		if s.inString {
+			if !s.isKey && !s.escape {
+				// Skip ahead to the next quote or escape in a string value.
+				j := bytes.IndexAny(b[i:], `"\`)
+				if j < 0 {
+					return
+				}
+				i += j
+				c = b[i]
+			}
+			switch {
+			case s.escape:
+				s.escape = false
+			case c == '\\':
+				s.escape = true
+			case c == '"':
+				s.inString = false
+				if s.isKey {
+					s.top().key = string(s.key)
+					continue
+				}
+			}
+			if s.isKey && s.inString && len(s.key) < 64 {
+				s.key = append(s.key, c)
+			}
+			continue
+		}
+		if s.inLit {
+			if !strings.ContainsRune(",}] \t\r\n", rune(c)) {
+				if len(s.lit) < 32 {
+					s.lit = append(s.lit, c)
+				}
+				continue
+			}
+			s.inLit = false
+			s.emit()
+		}
+		switch c {
+		case '{', '[':
+			if len(s.stack) == maxScanDepth {
+				s.broken = true
+				return
+			}
+			s.stack = append(s.stack, scanFrame{object: c == '{', expectKey: c == '{'})
+		case '}', ']':
+			if len(s.stack) > 0 {
+				s.stack = s.stack[:len(s.stack)-1]
+			}
+		case '"':
+			s.inString = true
+			top := s.top()
+			s.isKey = top != nil && top.object && top.expectKey
+			s.key = s.key[:0]
+		case ':':
+			if top := s.top(); top != nil {
+				top.expectKey = false
+			}
+		case ',':
+			if top := s.top(); top != nil && top.object {
+				top.expectKey = true
+				top.key = ""
+			}
+		case ' ', '\t', '\r', '\n':
+		default:
+			s.inLit = true
+			s.lit = append(s.lit[:0], c)
+		}
+	}
+}
+
+func (s *jsonFieldScanner) top() *scanFrame {
+	if len(s.stack) == 0 {
+		return nil
+	}
+	return &s.stack[len(s.stack)-1]
+}
+
+// emit records the literal just read if its path is wanted. Values inside
+// arrays are never wanted.
+func (s *jsonFieldScanner) emit() {
+	keys := make([]string, 0, len(s.stack))
+	for _, f := range s.stack {
+		if !f.object || f.key == "" {
+			return
+		}
+		keys = append(keys, f.key)
+	}
+	path := strings.Join(keys, ".")
+	if !s.want[path] {
+		return
+	}
+	switch lit := string(s.lit); lit {
+	case "true", "false":
+		s.fields[path] = lit == "true"
+	case "null":
+	default:
+		s.fields[path] = json.Number(lit)
+	}
+}
+
+// Fields returns the wanted fields found so far as a decoded JSON object
+// would hold them, with dotted paths nested ("usage.prompt_tokens" becomes
+// m["usage"]["prompt_tokens"]).
+func (s *jsonFieldScanner) Fields() map[string]any {
+	if s.inLit {
+		// A body cut off right after a top-level number.
+		s.inLit = false
+		s.emit()
+	}
+	m := make(map[string]any, len(s.fields))
+	for path, v := range s.fields {
+		parent := m
+		keys := strings.Split(path, ".")
+		for _, k := range keys[:len(keys)-1] {
+			child, ok := parent[k].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				parent[k] = child
+			}
+			parent = child
+		}
+		parent[keys[len(keys)-1]] = v
+	}
+	return m
+}
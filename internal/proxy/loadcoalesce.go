@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// defaultLoadCoalesceTimeout and defaultLoadCoalesceWarmTTL apply when
+// LOAD_COALESCE_TIMEOUT or LOAD_COALESCE_WARM_TTL is unset in a hand-built
+// Config.
+const (
+	defaultLoadCoalesceTimeout = 30 * time.Second
+	defaultLoadCoalesceWarmTTL = 5 * time.Minute
+)
+
+// modelLoads tracks, per model, the request loading it, so that with
+// LOAD_COALESCE_ENABLED the requests arriving meanwhile wait for that load
+// instead of each making Ollama start one. A model counts as loading from
+// when a request for it is forwarded, unless a response for it started
+// within the warm TTL, until that request's response starts.
+type modelLoads struct {
+	mu     sync.Mutex
+	models map[string]*modelLoad
+}
+
+type modelLoad struct {
+	loading chan struct{} // closed when the loading request's response starts or it ends; nil when none is loading
+	warmAt  time.Time     // when a response for the model last started
+}
+
+// loadTicket is a forwarded request's part in its model's load state.
+type loadTicket struct {
+	loads   *modelLoads
+	model   string
+	loading chan struct{} // the model's loading channel while this request is the one loading it
+}
+
+// enter returns the ticket for a request for model, waiting first, up to
+// timeout, while another request is loading it. held is how long it waited.
+// If that load ends without a response, one of the waiting requests loads
+// the model instead; when timeout passes, the request goes ahead without
+// waiting any longer.
+func (l *modelLoads) enter(ctx context.Context, model string, warmTTL, timeout time.Duration) (t *loadTicket, held time.Duration, err error) {
+	var start time.Time
+	var expired <-chan time.Time
+	for {
+		l.mu.Lock()
+		if l.models == nil {
+			l.models = make(map[string]*modelLoad)
+		}
+		m, ok := l.models[model]
+		if !ok {
+			m = &modelLoad{}
+			l.models[model] = m
+		}
+		t = &loadTicket{loads: l, model: model}
+		if !m.warmAt.IsZero() && time.Since(m.warmAt) < warmTTL {
+			l.mu.Unlock()
+			return t, held, nil
+		}
+		if m.loading == nil {
+			m.loading = make(chan struct{})
+			t.loading = m.loading
+			l.mu.Unlock()
+			return t, held, nil
+		}
+		wait := m.loading
+		l.mu.Unlock()
+
+		if expired == nil {
+			start = time.Now()
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-wait:
+			held = time.Since(start)
+		case <-expired:
+			return t, time.Since(start), nil
+		case <-ctx.Done():
+			return nil, time.Since(start), ctx.Err()
+		}
+	}
+}
+
+// started records that a response for the ticket's model started, so the
+// model is loaded and the requests waiting for it may go ahead.
+func (t *loadTicket) started() {
+	if t == nil {
+		return
+	}
+	t.loads.mu.Lock()
+	defer t.loads.mu.Unlock()
+	t.loads.models[t.model].warmAt = time.Now()
+	t.releaseLocked()
+}
+
+// done ends the ticket's request. If it was loading the model and got no
+// response, the requests waiting for it are woken for one of them to load
+// the model instead.
+func (t *loadTicket) done() {
+	if t == nil {
+		return
+	}
+	t.loads.mu.Lock()
+	defer t.loads.mu.Unlock()
+	t.releaseLocked()
+}
+
+func (t *loadTicket) releaseLocked() {
+	if t.loading == nil {
+		return
+	}
+	if m := t.loads.models[t.model]; m.loading == t.loading {
+		close(m.loading)
+		m.loading = nil
+	}
+	t.loading = nil
+}
+
+// holdForLoad waits while another request is loading model, recording how
+// long the request reqID was held. The returned ticket must be done once the
+// request ends; it is nil when the request ended while held.
+func (h *Handler) holdForLoad(ctx context.Context, reqID, model string) (*loadTicket, error) {
+	timeout := h.cfg.LoadCoalesceTimeout
+	if timeout <= 0 {
+		timeout = defaultLoadCoalesceTimeout
+	}
+	warmTTL := h.cfg.LoadCoalesceWarmTTL
+	if warmTTL <= 0 {
+		warmTTL = defaultLoadCoalesceWarmTTL
+	}
+	t, held, err := h.modelLoads.enter(ctx, model, warmTTL, timeout)
+	if held <= 0 {
+		return t, err
+	}
+
+	h.metrics.RecordLoadWait(model, held)
+	if h.store != nil && reqID != "" {
+		ms := int(held.Milliseconds())
+		if err := h.store.Update(reqID, storage.RequestUpdate{LoadWaitMs: &ms}); err != nil {
+			h.logger.Error("failed to store load wait", "err", err, "id", reqID)
+		}
+	}
+	h.logger.Debug("held request while its model loaded", "id", reqID, "model", model, "held_ms", held.Milliseconds())
+	return t, err
+}
+
+// loadCanceled is the rejection for a request whose client went away while
+// it was held for its model's load.
+func loadCanceled(model string) rejection {
+	return rejection{
+		code:   http.StatusServiceUnavailable,
+		status: supervisor.StatusCanceled,
+		msg:    "request ended while waiting for model " + model + " to load",
+	}
+}
@@ -1,9 +1,19 @@
 package proxy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+
 	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/util"
 )
 
+// redactedValue replaces option values listed in REDACT_OPTION_KEYS.
+const redactedValue = "[redacted]"
+
 // RequestMeta holds parsed metadata from request body.
 // No content is stored - only structural metrics.
 type RequestMeta struct {
@@ -17,6 +27,49 @@ type RequestMeta struct {
 	ToolChoice      string
 	StreamRequested bool
 	ClientInBytes   int64
+	// Options is the client's options object, if any. It aliases reqMap, so
+	// snapshot it before the request is rewritten.
+	Options map[string]any
+	// Fingerprint is requestFingerprint of the body, or "" if it couldn't be
+	// encoded.
+	Fingerprint string
+}
+
+// fingerprintIgnoredKeys and fingerprintIgnoredOptions are left out of a
+// request's fingerprint: they change how the answer is delivered or are
+// chosen by the proxy, not what is asked.
+var (
+	fingerprintIgnoredKeys    = []string{"stream", "keep_alive"}
+	fingerprintIgnoredOptions = []string{"num_ctx"}
+)
+
+// requestFingerprint hashes the normalized request body: re-encoded with
+// sorted keys, without fingerprintIgnoredKeys or fingerprintIgnoredOptions.
+// Requests with the same fingerprint ask the same model the same thing. Only
+// the first 16 bytes of the SHA-256 are kept, hex-encoded; the body can't be
+// recovered from it.
+func requestFingerprint(reqMap map[string]any) string {
+	norm := maps.Clone(reqMap)
+	for _, k := range fingerprintIgnoredKeys {
+		delete(norm, k)
+	}
+	if opts, ok := norm["options"].(map[string]any); ok {
+		opts = maps.Clone(opts)
+		for _, k := range fingerprintIgnoredOptions {
+			delete(opts, k)
+		}
+		if len(opts) == 0 {
+			delete(norm, "options")
+		} else {
+			norm["options"] = opts
+		}
+	}
+	b, err := util.EncodeJSON(norm)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
 }
 
 // ParseRequestMetadata extracts metadata from a parsed request.
@@ -39,6 +92,11 @@ func ParseRequestMetadata(endpoint string, reqMap map[string]any, bodyLen int) R
 		meta.StreamRequested = stream
 	}
 
+	if opts, ok := reqMap["options"].(map[string]any); ok {
+		meta.Options = opts
+	}
+	meta.Fingerprint = requestFingerprint(reqMap)
+
 	// Parse based on endpoint type
 	switch endpoint {
 	case "chat":
@@ -101,6 +159,10 @@ func parseGenerateMetadata(meta *RequestMeta, reqMap map[string]any) {
 	// Main prompt
 	if prompt, ok := reqMap["prompt"].(string); ok {
 		meta.UserChars = len(prompt)
+	} else if parts, ok := util.ToStrings(reqMap["prompt"]); ok {
+		for _, p := range parts {
+			meta.UserChars += len(p)
+		}
 	}
 
 	// Generate doesn't have messages
@@ -128,6 +190,42 @@ func extractContentLength(content any) int {
 	}
 }
 
+// OptionsFilter selects the options an options snapshot keeps.
+type OptionsFilter struct {
+	Keys    []string // keys kept; "*" keeps every key
+	Exclude []string // keys dropped even when Keys has them
+	Redact  []string // keys whose values are replaced with redactedValue
+}
+
+// OptionsSnapshot returns the client's options as JSON filtered by f, or ""
+// when none are left.
+func (m *RequestMeta) OptionsSnapshot(f OptionsFilter) string {
+	return optionsSnapshot(m.Options, f)
+}
+
+func optionsSnapshot(opts map[string]any, f OptionsFilter) string {
+	all := slices.Contains(f.Keys, "*")
+	snap := make(map[string]any, len(opts))
+	for k, v := range opts {
+		if (all || slices.Contains(f.Keys, k)) && !slices.Contains(f.Exclude, k) {
+			snap[k] = v
+		}
+	}
+	if len(snap) == 0 {
+		return ""
+	}
+	for _, k := range f.Redact {
+		if _, ok := snap[k]; ok {
+			snap[k] = redactedValue
+		}
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
 // ToStorageRequest creates a storage.Request from metadata.
 func (m *RequestMeta) ToStorageRequest(id string, tsStart int64) *storage.Request {
 	return &storage.Request{
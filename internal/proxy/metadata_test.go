@@ -153,3 +153,78 @@ func TestToStorageRequest(t *testing.T) {
 		t.Error("StreamRequested should be true")
 	}
 }
+
+func TestRequestFingerprint(t *testing.T) {
+	base := map[string]any{
+		"model":    "llama2",
+		"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+		"options":  map[string]any{"temperature": 0.2},
+	}
+	fp := ParseRequestMetadata("chat", base, 100).Fingerprint
+	if len(fp) != 32 {
+		t.Fatalf("Fingerprint = %q, want 32 hex chars", fp)
+	}
+
+	same := map[string]any{
+		"model":      "llama2",
+		"messages":   []any{map[string]any{"content": "hi", "role": "user"}},
+		"options":    map[string]any{"temperature": 0.2, "num_ctx": float64(8192)},
+		"stream":     true,
+		"keep_alive": "10m",
+	}
+	if got := requestFingerprint(same); got != fp {
+		t.Errorf("fingerprint changed with stream, keep_alive or num_ctx: %s != %s", got, fp)
+	}
+	if _, ok := same["options"].(map[string]any)["num_ctx"]; !ok {
+		t.Error("fingerprinting modified the request's options")
+	}
+	if _, ok := same["stream"]; !ok {
+		t.Error("fingerprinting modified the request")
+	}
+
+	for name, other := range map[string]map[string]any{
+		"model":   {"model": "mistral", "messages": base["messages"], "options": base["options"]},
+		"content": {"model": "llama2", "messages": []any{map[string]any{"role": "user", "content": "hello"}}, "options": base["options"]},
+		"options": {"model": "llama2", "messages": base["messages"], "options": map[string]any{"temperature": 0.7}},
+	} {
+		if requestFingerprint(other) == fp {
+			t.Errorf("different %s gave the same fingerprint", name)
+		}
+	}
+}
+
+func TestOptionsSnapshot(t *testing.T) {
+	reqMap := map[string]any{
+		"model": "llama2",
+		"options": map[string]any{
+			"temperature": 0.7,
+			"num_predict": float64(256),
+			"stop":        []any{"secret"},
+		},
+	}
+
+	meta := ParseRequestMetadata("generate", reqMap, 100)
+	got := meta.OptionsSnapshot(OptionsFilter{Keys: []string{"*"}, Redact: []string{"stop", "seed"}})
+	want := `{"num_predict":256,"stop":"[redacted]","temperature":0.7}`
+	if got != want {
+		t.Errorf("OptionsSnapshot = %s, want %s", got, want)
+	}
+
+	got = meta.OptionsSnapshot(OptionsFilter{Keys: []string{"num_ctx", "num_predict", "stop"}, Exclude: []string{"stop"}})
+	if want := `{"num_predict":256}`; got != want {
+		t.Errorf("filtered OptionsSnapshot = %s, want %s", got, want)
+	}
+	if snap := meta.OptionsSnapshot(OptionsFilter{Keys: []string{"seed"}}); snap != "" {
+		t.Errorf("expected empty snapshot when no key is kept, got %q", snap)
+	}
+
+	// Redaction must not touch the request that is forwarded upstream.
+	if _, ok := reqMap["options"].(map[string]any)["stop"].([]any); !ok {
+		t.Error("redaction modified the original options map")
+	}
+
+	empty := ParseRequestMetadata("generate", map[string]any{"model": "llama2"}, 10)
+	if snap := empty.OptionsSnapshot(OptionsFilter{Keys: []string{"*"}}); snap != "" {
+		t.Errorf("expected empty snapshot without options, got %q", snap)
+	}
+}
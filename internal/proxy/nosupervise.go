@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ollama-auto-ctx/internal/config"
+)
+
+// NoSuperviseHeader turns off the watchdog, loop detection and output limit
+// for one request, e.g. a known-long legitimate generation. The request is
+// still tracked and stored. Who may send it is set by NO_SUPERVISE_POLICY; it
+// is consumed by the proxy, never forwarded.
+const NoSuperviseHeader = "X-AutoCtx-No-Supervise"
+
+// noSupervise reports whether r asks for supervision to be bypassed and is
+// allowed to. Values other than a boolean are ignored.
+func (h *Handler) noSupervise(r *http.Request) bool {
+	v := strings.TrimSpace(r.Header.Get(NoSuperviseHeader))
+	if v == "" {
+		return false
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		h.logger.Warn("ignoring invalid "+NoSuperviseHeader+" header", "path", r.URL.Path, "value", v)
+		return false
+	}
+	if !on {
+		return false
+	}
+	switch h.cfg.NoSupervisePolicy {
+	case config.NoSuperviseAny:
+		return true
+	case config.NoSuperviseAdmin:
+		// Without admin auth nobody can prove they're an admin, so the
+		// header is ignored rather than honoured for every client.
+		if h.cfg.AdminAuthEnabled() && h.authorized(r) {
+			return true
+		}
+		h.logger.Warn("ignoring "+NoSuperviseHeader+" header without admin credentials", "path", r.URL.Path)
+	}
+	return false
+}
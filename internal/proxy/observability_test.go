@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/api"
 	"ollama-auto-ctx/internal/config"
 	"ollama-auto-ctx/internal/ollama"
 	"ollama-auto-ctx/internal/supervisor"
@@ -263,3 +265,85 @@ func TestSSEEndpoint_SlowConsumer(t *testing.T) {
 	// The fact that we got here without blocking is the test
 	// In a real scenario, slow consumers would cause events to be dropped (fail-open)
 }
+
+func TestDebugRequestsEndpoint_Filters(t *testing.T) {
+	cfg := config.Config{
+		Mode:                 config.ModeRetry,
+		RecentBuffer:         10,
+		DefaultTokensPerByte: 0.25,
+		ProgressInterval:     250 * time.Millisecond,
+	}
+
+	handler := createTestHandlerWithObs(cfg)
+	handler.tracker.Start("ok1", "/api/chat", "llama2", false)
+	handler.tracker.Finish("ok1", supervisor.StatusSuccess, nil)
+	handler.tracker.Start("bad1", "/api/chat", "llama2", false)
+	handler.tracker.Finish("bad1", supervisor.StatusTimeoutTTFB, errors.New("ttfb"))
+	handler.tracker.Start("ok2", "/api/chat", "qwen3", false)
+	handler.tracker.Finish("ok2", supervisor.StatusSuccess, nil)
+	handler.tracker.Start("live", "/api/chat", "qwen3", false)
+	time.Sleep(2 * time.Millisecond)
+	handler.tracker.Start("live2", "/api/chat", "llama2", false)
+
+	get := func(query string) (inFlight []string, recent []string) {
+		w := httptest.NewRecorder()
+		handler.handleDebugRequests(w, httptest.NewRequest("GET", "/debug/requests?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", query, w.Code)
+		}
+		var response struct {
+			InFlight map[string]supervisor.RequestInfo `json:"in_flight"`
+			Recent   []supervisor.RequestInfo          `json:"recent"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		for id := range response.InFlight {
+			inFlight = append(inFlight, id)
+		}
+		for _, req := range response.Recent {
+			recent = append(recent, req.ID)
+		}
+		return inFlight, recent
+	}
+
+	tests := []struct {
+		query        string
+		wantInFlight int
+		wantRecent   string
+	}{
+		{"", 2, "ok1,bad1,ok2"},
+		{"model=qwen3", 1, "ok2"},
+		{"status=success", 0, "ok1,ok2"},
+		{"status=error", 0, "bad1"},
+		{"status=in_flight", 2, ""},
+		{"limit=1", 1, "ok2"},
+	}
+	for _, tt := range tests {
+		inFlight, recent := get(tt.query)
+		if len(inFlight) != tt.wantInFlight {
+			t.Errorf("%q: in_flight = %v, want %d entries", tt.query, inFlight, tt.wantInFlight)
+		}
+		if got := strings.Join(recent, ","); got != tt.wantRecent {
+			t.Errorf("%q: recent = %q, want %q", tt.query, got, tt.wantRecent)
+		}
+	}
+	if inFlight, _ := get("limit=1"); len(inFlight) != 1 || inFlight[0] != "live2" {
+		t.Errorf("limit=1 should keep the newest in-flight request, got %v", inFlight)
+	}
+
+	// With the API enabled only unfiltered requests are redirected; filtered
+	// ones keep the legacy shape.
+	handler.features.API = true
+	handler.apiServer = api.NewServer(nil, cfg, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/requests", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("unfiltered: expected a redirect, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/requests?model=qwen3", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"in_flight"`) {
+		t.Errorf("filtered: expected the legacy shape, got %d %s", w.Code, w.Body.String())
+	}
+}
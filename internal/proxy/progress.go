@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// ProgressSidebandKey is the only key of the progress lines that
+// PROGRESS_SIDEBAND_ENABLED injects into NDJSON streams.
+const ProgressSidebandKey = "x_autoctx_progress"
+
+// SidebandProgress is the value of a ProgressSidebandKey line.
+type SidebandProgress struct {
+	RequestID             string `json:"request_id"`
+	BytesOut              int64  `json:"bytes_out"`
+	EstimatedOutputTokens int64  `json:"estimated_output_tokens"`
+	OutputBudget          int    `json:"output_budget,omitempty"`
+	ElapsedMs             int64  `json:"elapsed_ms"`
+	supervisor.Progress
+}
+
+// doneMarker ends an Ollama stream. JSON escapes quotes inside strings, so
+// generated text can't contain it.
+var doneMarker = []byte(`"done":true`)
+
+// progressSideband passes an NDJSON stream through, inserting a progress line
+// from the tracker at most every interval. Lines are only inserted where a
+// line of the stream has just ended, and none after the done line, so
+// clients that skip unknown lines see the stream unchanged.
+type progressSideband struct {
+	rc       io.ReadCloser
+	tracker  *supervisor.Tracker
+	reqID    string
+	interval time.Duration
+	now      func() time.Time
+
+	last      time.Time // when the last progress line was inserted, or the stream started
+	atLineEnd bool      // the last byte passed on ended a line
+	done      bool      // the done line was passed on
+	pending   []byte    // unread part of an inserted line
+}
+
+func newProgressSideband(rc io.ReadCloser, tracker *supervisor.Tracker, reqID string, interval time.Duration) *progressSideband {
+	return &progressSideband{
+		rc:       rc,
+		tracker:  tracker,
+		reqID:    reqID,
+		interval: interval,
+		now:      time.Now,
+		last:     time.Now(),
+	}
+}
+
+func (s *progressSideband) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 && s.atLineEnd && !s.done {
+		if now := s.now(); now.Sub(s.last) >= s.interval {
+			s.last = now
+			s.pending = s.line(now)
+			s.atLineEnd = false // one line per stretch of upstream lines
+		}
+	}
+	if len(s.pending) > 0 {
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		return n, nil
+	}
+
+	n, err := s.rc.Read(p)
+	if n > 0 {
+		s.atLineEnd = p[n-1] == '\n'
+		if bytes.Contains(p[:n], doneMarker) {
+			s.done = true
+		}
+	}
+	return n, err
+}
+
+// line renders the request's progress as an NDJSON line, or nil once the
+// tracker no longer has it in flight.
+func (s *progressSideband) line(now time.Time) []byte {
+	info := s.tracker.GetRequestInfo(s.reqID)
+	if info == nil {
+		return nil
+	}
+	b, err := json.Marshal(map[string]SidebandProgress{ProgressSidebandKey: {
+		RequestID:             s.reqID,
+		BytesOut:              info.BytesForwarded,
+		EstimatedOutputTokens: s.tracker.EstimatedOutputTokens(*info),
+		OutputBudget:          info.OutputBudgetTokens,
+		ElapsedMs:             now.Sub(info.StartTime).Milliseconds(),
+		Progress:              s.tracker.Progress(*info, now),
+	}})
+	if err != nil {
+		return nil
+	}
+	return append(b, '\n')
+}
+
+func (s *progressSideband) Close() error {
+	return s.rc.Close()
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+func TestProgressSideband(t *testing.T) {
+	tracker := supervisor.NewTracker(10, nil, nil, 0.25, time.Second, nil)
+	tracker.Start("r1", "generate", "m", true)
+	tracker.UpdateContextData("r1", 10, 2048, 100)
+
+	lines := tokenLines(3)
+	chunks := [][]byte{
+		lines[0],
+		lines[1][:10], lines[1][10:], // a line split across reads
+		lines[2],
+		[]byte(`{"model":"m","response":"","done":true}` + "\n"),
+	}
+	s := newProgressSideband(io.NopCloser(&chunkReader{chunks: chunks}), tracker, "r1", time.Second)
+	clock := time.Now()
+	s.now = func() time.Time {
+		clock = clock.Add(2 * time.Second) // every check is past the interval
+		return clock
+	}
+
+	out, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var upstream, progress int
+	var lastWasDone bool
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("stream has a broken line %q: %v", sc.Text(), err)
+		}
+		if raw, ok := m[ProgressSidebandKey]; ok {
+			if lastWasDone {
+				t.Error("progress line after the done line")
+			}
+			var p SidebandProgress
+			if err := json.Unmarshal(raw, &p); err != nil || p.RequestID != "r1" || p.OutputBudget != 100 {
+				t.Errorf("progress line %s: %+v, %v", raw, p, err)
+			}
+			progress++
+			continue
+		}
+		upstream++
+		lastWasDone = bytes.Contains(sc.Bytes(), doneMarker)
+	}
+	if upstream != 4 {
+		t.Errorf("expected the 4 upstream lines intact, got %d in %q", upstream, out)
+	}
+	// One after each of the three token lines, but not inside the split one.
+	if progress != 3 {
+		t.Errorf("expected 3 progress lines, got %d in %q", progress, out)
+	}
+
+	// Nothing is inserted once the tracker has finished the request.
+	tracker.Finish("r1", supervisor.StatusSuccess, nil)
+	s = newProgressSideband(io.NopCloser(&chunkReader{chunks: tokenLines(2)}), tracker, "r1", 0)
+	out, _ = io.ReadAll(s)
+	if bytes.Contains(out, []byte(ProgressSidebandKey)) {
+		t.Errorf("progress line for a finished request: %q", out)
+	}
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+	"ollama-auto-ctx/internal/util"
+)
+
+// promptScanTag is the tag key PROMPT_SCAN_FILE's tag rules set; its value is
+// the first matching rule's name.
+const promptScanTag = "prompt_scan"
+
+// promptScanner matches PROMPT_SCAN_FILE's rules against prompt text.
+type promptScanner struct {
+	rules    []promptScanRule
+	maxBytes int
+}
+
+type promptScanRule struct {
+	name   string
+	re     *regexp.Regexp
+	action config.PromptScanAction
+}
+
+// newPromptScanner compiles rules, or returns nil without any. Rules that
+// don't compile, which Validate rejects, are left out.
+func newPromptScanner(rules []config.PromptScanRule, maxBytes int) *promptScanner {
+	s := &promptScanner{maxBytes: maxBytes}
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			continue
+		}
+		s.rules = append(s.rules, promptScanRule{name: rule.Name, re: re, action: rule.Action})
+	}
+	if len(s.rules) == 0 {
+		return nil
+	}
+	return s
+}
+
+// scan returns the rules matching any of the request's texts, in rule order.
+func (s *promptScanner) scan(endpoint string, reqMap map[string]any) []promptScanRule {
+	texts := promptTexts(endpoint, reqMap, s.maxBytes)
+	var matched []promptScanRule
+	for _, rule := range s.rules {
+		for _, text := range texts {
+			if rule.re.MatchString(text) {
+				matched = append(matched, rule)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// promptTexts returns the request's message contents (chat) or prompt and
+// system prompt (generate), newest first, cut off once they add up to
+// maxBytes so huge prompts cost a bounded scan.
+func promptTexts(endpoint string, reqMap map[string]any, maxBytes int) []string {
+	var all []string
+	switch endpoint {
+	case estimate.EndpointChat:
+		msgs, _ := reqMap["messages"].([]any)
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if mm, ok := msgs[i].(map[string]any); ok {
+				if s, ok := util.ToString(mm["content"]); ok {
+					all = append(all, s)
+				}
+			}
+		}
+	case estimate.EndpointGenerate:
+		if s, ok := util.ToString(reqMap["prompt"]); ok {
+			all = append(all, s)
+		} else if parts, ok := util.ToStrings(reqMap["prompt"]); ok {
+			for i := len(parts) - 1; i >= 0; i-- {
+				all = append(all, parts[i])
+			}
+		}
+		if s, ok := util.ToString(reqMap["system"]); ok {
+			all = append(all, s)
+		}
+	}
+
+	texts := all[:0]
+	left := maxBytes
+	for _, s := range all {
+		if left <= 0 {
+			break
+		}
+		if len(s) > left {
+			s = s[:left]
+		}
+		texts = append(texts, s)
+		left -= len(s)
+	}
+	return texts
+}
+
+// applyPromptScan runs PROMPT_SCAN_FILE's rules over the request's prompt
+// text. Every match is logged and counted, the first tag rule to match is
+// added to the request's tags as prompt_scan=<rule>, and a reject rule
+// matching has the request answered 400. It returns the matched rule names
+// for storage, comma-separated, and whether the request was rejected.
+func (h *Handler) applyPromptScan(r *http.Request, endpoint string, reqMap map[string]any) (string, bool) {
+	if h.promptScan == nil {
+		return "", false
+	}
+	matched := h.promptScan.scan(endpoint, reqMap)
+	if len(matched) == 0 {
+		return "", false
+	}
+
+	reqID, _ := r.Context().Value(ctxRequestIDKey).(string)
+	model, _ := reqMap["model"].(string)
+	names := make([]string, len(matched))
+	var tagRule, rejectRule string
+	for i, rule := range matched {
+		names[i] = rule.name
+		h.metrics.RecordPromptScanMatch(rule.name, string(rule.action))
+		switch {
+		case rule.action == config.PromptScanTag && tagRule == "":
+			tagRule = rule.name
+		case rule.action == config.PromptScanReject && rejectRule == "":
+			rejectRule = rule.name
+		}
+	}
+	h.logger.Warn("prompt matched scan rules", "id", reqID, "path", r.URL.Path, "model", model, "rules", names)
+
+	if tagRule != "" {
+		tags, _ := r.Context().Value(ctxTagsKey).(string)
+		parsed := storage.ParseTags(tags)
+		if parsed == nil {
+			parsed = make(map[string]string, 1)
+		}
+		parsed[promptScanTag] = tagRule
+		*r = *r.WithContext(context.WithValue(r.Context(), ctxTagsKey, storage.FormatTags(parsed)))
+	}
+	if rejectRule != "" {
+		rej := rejection{
+			code:   http.StatusBadRequest,
+			status: supervisor.StatusPromptRejected,
+			reason: storage.ReasonPromptRejected,
+			msg:    "prompt rejected by scan rule " + rejectRule,
+		}
+		*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+	}
+	return strings.Join(names, ","), rejectRule != ""
+}
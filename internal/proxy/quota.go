@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// SetTagQuotas enforces q's per-tag quotas on tagged chat/generate requests.
+func (h *Handler) SetTagQuotas(q *supervisor.TagQuotas) {
+	h.tagQuotas = q
+}
+
+// rejectOverQuota charges r's estimated tokens to the quotas of its tags and
+// marks it to be answered 429 when one of them is exhausted, reporting
+// whether it did. Retry-After is the time left until the quota window resets.
+func (h *Handler) rejectOverQuota(r *http.Request, tokens int) bool {
+	tags, _ := r.Context().Value(ctxTagsKey).(string)
+	if h.tagQuotas == nil || tags == "" {
+		return false
+	}
+	ex, ok := h.tagQuotas.Admit(tags, tokens, time.Now())
+	if ok {
+		return false
+	}
+	h.logger.Warn("rejecting request: tag quota exhausted", "path", r.URL.Path, "tag", ex.Tag, "quota", ex.Kind,
+		"tokens_est", tokens, "reset_at", ex.ResetAt)
+	rej := rejection{
+		code:       http.StatusTooManyRequests,
+		status:     supervisor.StatusQuotaExceeded,
+		reason:     storage.ReasonQuotaExceeded,
+		msg:        fmt.Sprintf("%s quota for tag %s is exhausted until %s", ex.Kind, ex.Tag, ex.ResetAt.UTC().Format(time.RFC3339)),
+		retryAfter: time.Until(ex.ResetAt),
+	}
+	*r = *r.WithContext(context.WithValue(r.Context(), ctxRejectKey, rej))
+	return true
+}
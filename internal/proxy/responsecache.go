@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/util"
+)
+
+// ResponseCacheHeader is set to "hit" on a response answered from the
+// RESPONSE_CACHE_TTL cache.
+const ResponseCacheHeader = "X-Ollama-CtxProxy-Cache"
+
+// responseCacheKey identifies a deterministic request: its model and the
+// hash of its body as forwarded, re-encoded with sorted keys so key order
+// and whitespace don't matter.
+type responseCacheKey struct {
+	model    string
+	bodyHash [sha256.Size]byte
+}
+
+type responseCacheEntry struct {
+	resp    *cachedResponse
+	expires time.Time
+}
+
+// responseCache holds up to maxEntries responses for ttl. Entries are evicted
+// oldest first. It is safe for concurrent use.
+type responseCache struct {
+	ttl          time.Duration
+	maxEntries   int
+	maxBodyBytes int
+	now          func() time.Time
+
+	mu      sync.Mutex
+	entries map[responseCacheKey]*responseCacheEntry
+	order   []responseCacheKey // keys by insertion, oldest first
+}
+
+func newResponseCache(ttl time.Duration, maxEntries int, maxBodyBytes int64) *responseCache {
+	return &responseCache{
+		ttl:          ttl,
+		maxEntries:   maxEntries,
+		maxBodyBytes: int(maxBodyBytes),
+		now:          time.Now,
+		entries:      make(map[responseCacheKey]*responseCacheEntry),
+	}
+}
+
+// get returns the unexpired response for key, or nil.
+func (c *responseCache) get(key responseCacheKey) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked()
+	if e, ok := c.entries[key]; ok {
+		return e.resp
+	}
+	return nil
+}
+
+// put keeps resp for key, replacing any earlier response. A nil resp, one
+// that couldn't be recorded whole, is not kept.
+func (c *responseCache) put(key responseCacheKey, resp *cachedResponse) {
+	if resp == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &responseCacheEntry{resp: resp, expires: c.now().Add(c.ttl)}
+	c.order = append(c.order, key)
+	c.evictLocked()
+}
+
+// evictLocked drops expired entries and the oldest ones over maxEntries.
+// Keys in order whose entry was dropped, or replaced by a later put, are
+// skipped.
+func (c *responseCache) evictLocked() {
+	now := c.now()
+	for len(c.order) > 0 {
+		key := c.order[0]
+		e, ok := c.entries[key]
+		switch {
+		case !ok:
+		case len(c.entries) > c.maxEntries, now.After(e.expires):
+			delete(c.entries, key)
+		default:
+			return
+		}
+		c.order = c.order[1:]
+	}
+}
+
+// deterministicOutput reports whether Ollama's output for the request body
+// reqMap depends on nothing else: options.temperature is 0 (greedy
+// sampling) or options.seed fixes the sampler's randomness. A negative seed
+// asks for a random one.
+func deterministicOutput(reqMap map[string]any) bool {
+	opts, _ := reqMap["options"].(map[string]any)
+	if temp, ok := opts["temperature"].(json.Number); ok {
+		if v, err := temp.Float64(); err == nil && v == 0 {
+			return true
+		}
+	}
+	seed, ok := util.ToInt64(opts["seed"])
+	return ok && seed >= 0
+}
+
+// responseCacheKeyFor returns the cache key of the non-streaming,
+// deterministic request body reqMap, as it is about to be forwarded.
+func responseCacheKeyFor(reqMap map[string]any) (responseCacheKey, bool) {
+	if stream, ok := reqMap["stream"].(bool); !ok || stream {
+		return responseCacheKey{}, false
+	}
+	if !deterministicOutput(reqMap) {
+		return responseCacheKey{}, false
+	}
+	model, _ := reqMap["model"].(string)
+	body, err := util.EncodeJSON(reqMap)
+	if err != nil {
+		return responseCacheKey{}, false
+	}
+	return responseCacheKey{model: model, bodyHash: sha256.Sum256(body)}, true
+}
+
+// serveCached writes a response answered from the response cache.
+func serveCached(w http.ResponseWriter, resp *cachedResponse) {
+	for k, v := range resp.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(ResponseCacheHeader, "hit")
+	announceTrailers(w, resp.trailer)
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body)
+	setTrailers(w, resp.trailer)
+}
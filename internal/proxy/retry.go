@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// maxLoopRetryTemperature caps the temperature nudgeLoopRetry raises.
+const maxLoopRetryTemperature = 2.0
+
+// retryTransport sends retry-eligible requests (non-streaming chat/generate,
+// flagged by rewriteRequestIfPossible) through the Retryer so empty
+// completions are retried before the client sees them. Connection errors and
+// 5xx are passed on as before. Other sized chat/generate requests only have
+// model-loading errors retried, unbuffered. Everything else goes straight to
+// base. With LOOP_RETRY_ENABLED, a buffered completion that loops is resent
+// too (see retryLoop), and with MODEL_FALLBACK a failed model's request is
+// resent to its fallback (see retryFallback).
+type retryTransport struct {
+	base http.RoundTripper
+	h    *Handler
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	eligible, _ := req.Context().Value(ctxRetryKey).(bool)
+	loadingOnly, _ := req.Context().Value(ctxLoadingRetryKey).(bool)
+	if (!eligible && !loadingOnly) || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if !eligible {
+		res := t.h.retryer.RoundTripLoading(t.base, req, body)
+		t.h.recordRetries(req, res.Attempts, res.Empty, res.Loading)
+		return res.Response, res.LastError
+	}
+
+	res := t.h.retryer.RoundTripEmpty(t.base, req, body)
+	if t.h.loopRetryApplies(req) {
+		var outcome string
+		res, outcome = t.retryLoop(req, body, res)
+		t.h.recordLoopRetry(req, outcome)
+	}
+	served := req
+	dec, _ := req.Context().Value(ctxDecisionKey).(Decision)
+	if fallback := t.h.fallbackFor(dec.Model); fallback != "" && modelFailed(res) {
+		res, served = t.retryFallback(req, body, res, fallback)
+	}
+	t.h.recordRetries(req, res.Attempts, res.Empty, res.Loading)
+
+	if res.Response == nil {
+		if res.LastError == nil {
+			res.LastError = errors.New("upstream request failed")
+		}
+		return nil, res.LastError
+	}
+
+	// Responses too large to buffer (and non-200s) come back unbuffered.
+	resp := res.Response
+	if res.Body != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(res.Body))
+		resp.ContentLength = int64(len(res.Body))
+	}
+	resp.Request = served
+	return resp, nil
+}
+
+// loopRetryApplies reports whether req's completion is checked for loops
+// before it's delivered: LOOP_RETRY_ENABLED in protect mode with loop
+// detection on, for a supervised request.
+func (h *Handler) loopRetryApplies(req *http.Request) bool {
+	unsupervised, _ := req.Context().Value(ctxUnsupervisedKey).(bool)
+	return h.cfg.LoopRetryEnabled && h.features.Protect && h.cfg.LoopDetectEnabled && !unsupervised
+}
+
+// retryLoop resends req when the completion buffered in res loops, up to
+// LOOP_RETRY_MAX times, each with a nudged body (see nudgeLoopRetry). It
+// returns the response to deliver, counting the attempts of every send, and
+// the outcome: "" when res didn't loop, else storage.LoopRetryRescued or
+// storage.LoopRetryLooped. A resend that fails or can't be checked ends the
+// retries, and the looping response is delivered rather than the failure.
+func (t *retryTransport) retryLoop(req *http.Request, body []byte, res supervisor.RetryResult) (supervisor.RetryResult, string) {
+	if res.Body == nil || res.Response.StatusCode != http.StatusOK {
+		return res, ""
+	}
+	dec, _ := req.Context().Value(ctxDecisionKey).(Decision)
+	cfg := t.h.loopDetectorConfig(dec.Model)
+	if !supervisor.CompletionLoops(res.Body, cfg) {
+		return res, ""
+	}
+
+	for n := 1; n <= max(t.h.cfg.LoopRetryMax, 1); n++ {
+		next := t.h.retryer.RoundTripEmpty(t.base, req, nudgeLoopRetry(body, n, t.h.cfg.LoopRetryTemperatureBump))
+		attempts, empty, loading := res.Attempts+next.Attempts, res.Empty+next.Empty, res.Loading+next.Loading
+		if next.Response == nil || next.Body == nil || next.Response.StatusCode != http.StatusOK {
+			if next.Response != nil {
+				_ = next.Response.Body.Close()
+			}
+			res.Attempts, res.Empty, res.Loading = attempts, empty, loading
+			return res, storage.LoopRetryLooped
+		}
+		res = next
+		res.Attempts, res.Empty, res.Loading = attempts, empty, loading
+		if !supervisor.CompletionLoops(res.Body, cfg) {
+			return res, storage.LoopRetryRescued
+		}
+	}
+	return res, storage.LoopRetryLooped
+}
+
+// nudgeLoopRetry returns body for the n-th loop retry. A client's seed is
+// offset by n, since the same seed would sample the same tokens again, and a
+// client's temperature is raised by bump per retry, up to
+// maxLoopRetryTemperature. Without a seed Ollama samples afresh anyway, and an
+// unset temperature is left to the model's default. A body that can't be
+// decoded is returned as it is.
+func nudgeLoopRetry(body []byte, n int, bump float64) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return body
+	}
+	opts, _ := m["options"].(map[string]any)
+	if opts == nil {
+		return body
+	}
+	if seed, ok := opts["seed"].(json.Number); ok {
+		if v, err := seed.Int64(); err == nil {
+			opts["seed"] = v + int64(n)
+		}
+	}
+	if temp, ok := opts["temperature"].(json.Number); ok && bump > 0 {
+		if v, err := temp.Float64(); err == nil {
+			opts["temperature"] = min(v+bump*float64(n), maxLoopRetryTemperature)
+		}
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// recordLoopRetry stores and counts the outcome of retryLoop. A completion
+// that kept looping is delivered with status success, like an empty one, but
+// with reason loop_detected so it can be found.
+func (h *Handler) recordLoopRetry(req *http.Request, outcome string) {
+	if outcome == "" {
+		return
+	}
+	dec, _ := req.Context().Value(ctxDecisionKey).(Decision)
+	reqID, _ := req.Context().Value(ctxRequestIDKey).(string)
+
+	h.metrics.RecordLoopRetry(dec.Model, outcome)
+	if h.store != nil && reqID != "" {
+		upd := storage.RequestUpdate{LoopRetry: &outcome}
+		if outcome == storage.LoopRetryLooped {
+			reason := storage.ReasonLoopDetected
+			upd.Reason = &reason
+		}
+		if err := h.store.Update(reqID, upd); err != nil {
+			h.logger.Error("failed to record loop retry", "err", err, "id", reqID)
+		}
+	}
+	if outcome == storage.LoopRetryLooped {
+		h.logger.Warn("completion kept looping after a loop retry", "id", reqID, "model", dec.Model)
+		return
+	}
+	h.logger.Info("loop retry rescued a looping completion", "id", reqID, "model", dec.Model)
+}
+
+// recordRetries stores the retry counts and bumps retry metrics for a request;
+// loading of the retries were for model-still-loading errors.
+func (h *Handler) recordRetries(req *http.Request, attempts, empty, loading int) {
+	retries := attempts - 1
+	if retries <= 0 {
+		return
+	}
+	dec, _ := req.Context().Value(ctxDecisionKey).(Decision)
+	reqID, _ := req.Context().Value(ctxRequestIDKey).(string)
+
+	for i := 0; i < retries; i++ {
+		h.metrics.RecordRetry(dec.Model)
+	}
+	for i := 0; i < loading; i++ {
+		h.metrics.RecordModelLoadingRetry(dec.Model)
+	}
+	if h.store != nil && reqID != "" {
+		upd := storage.RequestUpdate{RetryCount: &retries}
+		if loading > 0 {
+			upd.LoadingRetries = &loading
+		}
+		if err := h.store.Update(reqID, upd); err != nil {
+			h.logger.Error("failed to record retries", "err", err, "id", reqID)
+		}
+	}
+	h.logger.Info("retried upstream request", "id", reqID, "model", dec.Model, "retries", retries, "empty_responses", empty, "loading_retries", loading)
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/estimate"
+	"ollama-auto-ctx/internal/util"
+)
+
+// rewriteSampled sizes num_ctx for a body larger than RequestBodyMaxBytes.
+//
+// Only the first EstimateSampleBytes are read; features are approximated from
+// that prefix and scaled by Content-Length. The body is never fully buffered:
+// num_ctx is injected as an extra "options" object and the rest of the body is
+// streamed through untouched. Ollama decodes duplicate "options" keys into the
+// same map, later keys winning, so the client's other options still apply.
+//
+// The endpoint's OVERRIDE_NUM_CTX policy (see OverridePolicyFor) decides where
+// it goes. When the client's options are within the prefix, the policy is
+// applied to its num_ctx as usual and, if ours wins, it is appended after the
+// client's. Otherwise "always" appends it, and the other policies put it
+// first, so a num_ctx the client sends later in the body is kept: for bodies
+// like that if_too_small behaves like if_missing.
+func (h *Handler) rewriteSampled(endpoint string, r *http.Request) {
+	n := h.cfg.EstimateSampleBytes
+	if n > r.ContentLength {
+		n = r.ContentLength
+	}
+	prefix := make([]byte, n)
+	read, err := io.ReadFull(r.Body, prefix)
+	prefix = prefix[:read]
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		r.Body = resumeBody(prefix, r.Body)
+		return
+	}
+
+	// The injection point is the opening brace of the top-level object.
+	start := bytes.IndexByte(prefix, '{')
+	if start == -1 || len(bytes.TrimSpace(prefix[:start])) != 0 {
+		r.Body = resumeBody(prefix, r.Body)
+		return
+	}
+
+	features := estimate.SampleFeatures(endpoint, prefix, r.ContentLength)
+	if features.Model == "" {
+		r.Body = resumeBody(prefix, r.Body)
+		return
+	}
+
+	reqID, _ := r.Context().Value(ctxRequestIDKey).(string)
+	timeoutProfile := h.applyTimeoutProfile(r, features.Model)
+	if reqID != "" {
+		if h.tracker != nil {
+			h.tracker.UpdateModel(reqID, features.Model)
+		}
+		if h.store != nil {
+			meta := RequestMeta{
+				Model:         features.Model,
+				Endpoint:      endpoint,
+				MessagesCount: features.MessageCount,
+				ClientInBytes: r.ContentLength,
+			}
+			storageReq := meta.ToStorageRequest(reqID, time.Now().UnixMilli())
+			storageReq.Family = string(h.families.Classify(features.Model))
+			storageReq.Tags, _ = r.Context().Value(ctxTagsKey).(string)
+			storageReq.Unsupervised, _ = r.Context().Value(ctxUnsupervisedKey).(bool)
+			storageReq.TimeoutProfile = timeoutProfile
+			if err := h.store.Insert(storageReq); err != nil {
+				h.logger.Error("failed to insert request to storage", "err", err)
+			}
+		}
+	}
+
+	lim, err := h.resolveLimits(r.Context(), features.Model)
+	if err != nil {
+		h.rejectShowTimeout(r, features.Model, err)
+		return
+	}
+
+	// Code detection, role weights and options.stop need the decoded body,
+	// so sampled estimates use one rate and an unscaled output budget.
+	promptTokens := estimate.EstimatePromptTokens(features, lim.params, lim.tokensPerImage, 0, estimate.RoleWeights{})
+	if h.rejectOversizePrompt(r, features.Model, promptTokens) {
+		return
+	}
+	budgetResult := estimate.BudgetOutputTokens(features, h.cfg.DefaultOutputBudget, h.cfg.MaxOutputBudget, h.cfg.StructuredOverheadFor(features.Model), h.cfg.StructuredJSONBump, h.cfg.DynamicDefaultOutputBudget, promptTokens, h.cfg.NumPredictCeilingFor(features.Model), estimate.StopBudget{})
+	session, _ := r.Context().Value(ctxSessionKey).(string)
+	outputBudget, sessionLevel := h.escalateBudget(session, features.Model, budgetResult)
+	if h.rejectOverQuota(r, promptTokens+outputBudget) {
+		return
+	}
+	needed := promptTokens + outputBudget
+	headroom, confidence := h.headroomFor(lim.params)
+	headroom, confidence, unstable := h.unstableSizing(lim.params, headroom, confidence)
+	neededHeadroom := estimate.ApplyHeadroom(needed, headroom, h.cfg.MinAbsoluteHeadroom)
+	buckets := h.bucketsFor(features.Model, h.families.Classify(features.Model))
+	adjusted := h.utilization.Adjust(features.Model, neededHeadroom, promptTokens)
+	bucket, preferred := estimate.PreferCtx(adjusted, estimate.Bucketize(adjusted, buckets), h.cfg.PreferredCtxFor(features.Model), h.cfg.PreferredCtxTolerance)
+	desiredCtx := estimate.ClampCtx(bucket, lim.effMin, lim.effMax)
+	if unstable == config.UnstableCalibrationMaxCtx {
+		desiredCtx = estimate.ClampCtx(lim.effMax, lim.effMin, lim.effMax)
+	}
+
+	policy := h.cfg.OverridePolicyFor(endpoint)
+	finalCtx, override, clamped := desiredCtx, true, false
+	opts, known := prefixOptions(prefix[start:])
+	if known {
+		if n, ok := util.ToInt(opts["num_ctx"]); ok {
+			features.ProvidedNumCtx, features.ProvidedNumCtxOK = n, true
+		}
+		finalCtx, override, clamped = chooseFinalCtx(desiredCtx, lim.effMax, features.ProvidedNumCtx, features.ProvidedNumCtxOK, policy)
+	}
+
+	switch {
+	case !override:
+		r.Body = resumeBody(prefix, r.Body)
+	case known || policy == config.OverrideAlways:
+		inject := []byte(`,"options":{"num_ctx":` + strconv.Itoa(finalCtx) + `}`)
+		r.Body = &appendBody{r: resumeBody(prefix, r.Body), rc: r.Body, inject: inject}
+		r.ContentLength += int64(len(inject))
+	default:
+		inject := []byte(`"options":{"num_ctx":` + strconv.Itoa(finalCtx) + `},`)
+		head := make([]byte, 0, len(prefix)+len(inject))
+		head = append(head, prefix[:start+1]...)
+		head = append(head, inject...)
+		head = append(head, prefix[start+1:]...)
+		r.Body = resumeBody(head, r.Body)
+		r.ContentLength += int64(len(inject))
+	}
+	r.Header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+
+	sample := calibration.Sample{
+		Model:        features.Model,
+		Endpoint:     endpoint,
+		TextBytes:    features.TextBytes,
+		MessageCount: features.MessageCount,
+		UsedCtx:      finalCtx,
+		CreatedAt:    time.Now(),
+		Sampled:      true,
+	}
+	dec := Decision{
+		Model:                 features.Model,
+		Endpoint:              endpoint,
+		EstimatedPromptTokens: promptTokens,
+		OutputBudgetTokens:    outputBudget,
+		OutputBudgetSource:    budgetResult.Source,
+		StructuredOverhead:    budgetResult.StructuredOverhead,
+		NeededTokens:          needed,
+		NeededWithHeadroom:    neededHeadroom,
+		Headroom:              headroom,
+		Confidence:            confidence,
+		UnstableCalibration:   string(unstable),
+		PreferredCtx:          preferred,
+		ChosenCtx:             finalCtx,
+		UserCtx:               features.ProvidedNumCtx,
+		UserCtxProvided:       features.ProvidedNumCtxOK,
+		OverrideApplied:       override,
+		OverridePolicy:        string(policy),
+		Clamped:               clamped,
+		MaxConfigCtx:          h.cfg.MaxCtx,
+		MaxModelCtx:           lim.maxModelCtx,
+		MaxSafeCtx:            lim.maxSafe,
+		ShowFallback:          lim.showFallback,
+		UnverifiedCtxCap:      lim.unverifiedCap,
+		MaxVRAMCtx:            lim.maxVRAM,
+		UtilizationFactor:     utilizationFactor(h.utilization, features.Model),
+		SessionEscalation:     sessionLevel,
+		TextBytes:             features.TextBytes,
+		Sampled:               true,
+	}
+
+	ctx := context.WithValue(r.Context(), ctxSampleKey, sample)
+	ctx = context.WithValue(ctx, ctxDecisionKey, dec)
+	*r = *r.WithContext(ctx)
+
+	// Only the body's prefix was read, so there is no options snapshot.
+	h.recordDecision(r, dec, bucket, "")
+}
+
+// prefixOptions returns the top-level "options" object of a JSON body from
+// its prefix. known is false when the prefix ends before options (or the end
+// of the body) is reached, so the client's options can't be seen; opts is nil
+// when known and the body has none.
+func prefixOptions(prefix []byte) (opts map[string]any, known bool) {
+	dec := json.NewDecoder(bytes.NewReader(prefix))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		if key, _ := tok.(string); key == "options" {
+			if err := dec.Decode(&opts); err != nil {
+				return nil, false
+			}
+			return opts, true
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, false
+		}
+	}
+	_, err := dec.Token()
+	return nil, err == nil
+}
+
+// appendBody streams r, writing inject just before the closing brace of the
+// top-level object so its keys come after the client's. The brace and any
+// whitespace after it are held back until EOF. A body not ending in '}' gets
+// spaces instead, keeping Content-Length right.
+type appendBody struct {
+	r      io.Reader
+	rc     io.Closer
+	inject []byte
+	held   []byte // a '}' and the whitespace after it
+	out    []byte // ready to be returned
+	eof    bool
+}
+
+func (b *appendBody) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		if b.eof {
+			return 0, io.EOF
+		}
+		buf := make([]byte, max(len(p), 4096))
+		n, err := b.r.Read(buf)
+		b.push(buf[:n])
+		if err == io.EOF {
+			b.eof = true
+			b.finish()
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+// push queues data, holding back a trailing '}' and whitespace.
+func (b *appendBody) push(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	data = append(b.held, data...)
+	b.held = nil
+	if i := bytes.LastIndexByte(data, '}'); i >= 0 && len(bytes.TrimSpace(data[i+1:])) == 0 {
+		b.held = append([]byte(nil), data[i:]...)
+		data = data[:i]
+	}
+	b.out = append(b.out, data...)
+}
+
+func (b *appendBody) finish() {
+	if len(b.held) == 0 {
+		b.out = append(b.out, bytes.Repeat([]byte(" "), len(b.inject))...)
+		return
+	}
+	b.out = append(b.out, b.inject...)
+	b.out = append(b.out, b.held...)
+	b.held = nil
+}
+
+func (b *appendBody) Close() error { return b.rc.Close() }
+
+// resumeBody returns a body that replays head and then streams the rest of rc.
+func resumeBody(head []byte, rc io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), rc), rc}
+}
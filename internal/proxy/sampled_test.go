@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ollama-auto-ctx/internal/config"
+)
+
+func TestRewriteSampled_InjectsNumCtxForOversizedBody(t *testing.T) {
+	var got struct {
+		Model   string         `json:"model"`
+		Options map[string]any `json:"options"`
+	}
+	var gotLen int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotLen = len(body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("content-length %d does not match body %d", r.ContentLength, len(body))
+		}
+		// Mirrors how Ollama decodes: duplicate "options" keys merge into one map.
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("upstream got invalid JSON: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"done":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := config.Config{
+		Mode:                config.ModeOff,
+		Storage:             config.StorageOff,
+		MinCtx:              1024,
+		MaxCtx:              65536,
+		Buckets:             []int{1024, 2048, 4096, 8192, 16384, 32768, 65536},
+		Headroom:            1.0,
+		DefaultOutputBudget: 256,
+		MaxOutputBudget:     1024,
+		RequestBodyMaxBytes: 1024,
+		SampledEstimation:   true,
+		EstimateSampleBytes: 512,
+	}
+	handler := newRewriteTestHandler(cfg, upstream.URL)
+
+	content := strings.Repeat("lorem ipsum ", 4000) // ~48KB, far above the 1KB limit
+	body := `{"model":"llama3","messages":[{"role":"user","content":"` + content + `"}],"options":{"temperature":0.5}}`
+
+	req := httptest.NewRequest("POST", "/api/chat", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w.Header().Get("X-Ollama-CtxProxy-Sampled") != "true" {
+		t.Fatal("expected sampled header on response")
+	}
+	if gotLen <= len(body) {
+		t.Fatalf("expected injected body to be larger than original (%d <= %d)", gotLen, len(body))
+	}
+	if got.Model != "llama3" {
+		t.Fatalf("expected model llama3 upstream, got %q", got.Model)
+	}
+	// ~48KB * 0.25 tok/byte ≈ 12k tokens -> 16384 bucket.
+	if n, _ := got.Options["num_ctx"].(float64); n != 16384 {
+		t.Fatalf("expected num_ctx 16384, got %v", got.Options["num_ctx"])
+	}
+	if temp, _ := got.Options["temperature"].(float64); temp != 0.5 {
+		t.Fatalf("expected client options preserved, got %v", got.Options)
+	}
+}
+
+// The endpoint's policy, not OVERRIDE_NUM_CTX, applies to sampled bodies.
+func TestRewriteSampled_OverridePolicy(t *testing.T) {
+	var got struct {
+		Options map[string]any `json:"options"`
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("content-length %d does not match body %d", r.ContentLength, len(body))
+		}
+		got.Options = nil
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("upstream got invalid JSON: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"done":true}`))
+	}))
+	defer upstream.Close()
+
+	content := strings.Repeat("lorem ipsum ", 4000) // sized to 16384
+	messages := `"messages":[{"role":"user","content":"` + content + `"}]`
+	tests := []struct {
+		name    string
+		policy  config.OverridePolicy
+		options string
+		first   bool // options before messages, i.e. within the sample
+		wantCtx float64
+	}{
+		{"known smaller ctx is raised", config.OverrideIfTooSmall, `{"num_ctx":2048,"temperature":0.5}`, true, 16384},
+		{"known larger ctx is kept", config.OverrideIfTooSmall, `{"num_ctx":32768,"temperature":0.5}`, true, 32768},
+		{"known ctx over max is clamped", config.OverrideIfTooSmall, `{"num_ctx":1000000,"temperature":0.5}`, true, 65536},
+		{"known ctx with if_missing is kept", config.OverrideIfMissing, `{"num_ctx":2048,"temperature":0.5}`, true, 2048},
+		{"unseen ctx with always is replaced", config.OverrideAlways, `{"num_ctx":2048,"temperature":0.5}`, false, 16384},
+		{"unseen ctx with if_missing is kept", config.OverrideIfMissing, `{"num_ctx":2048,"temperature":0.5}`, false, 2048},
+		{"no ctx with if_missing is set", config.OverrideIfMissing, `{"temperature":0.5}`, false, 16384},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				Mode:                   config.ModeOff,
+				Storage:                config.StorageOff,
+				MinCtx:                 1024,
+				MaxCtx:                 65536,
+				Buckets:                []int{1024, 2048, 4096, 8192, 16384, 32768, 65536},
+				Headroom:               1.0,
+				DefaultOutputBudget:    256,
+				MaxOutputBudget:        1024,
+				RequestBodyMaxBytes:    1024,
+				SampledEstimation:      true,
+				EstimateSampleBytes:    512,
+				OverrideNumCtx:         config.OverrideIfTooSmall,
+				EndpointOverrideNumCtx: map[string]config.OverridePolicy{"chat": tt.policy},
+			}
+			handler := newRewriteTestHandler(cfg, upstream.URL)
+
+			body := `{"model":"llama3",` + messages + `,"options":` + tt.options + "}\n"
+			if tt.first {
+				body = `{"model":"llama3","options":` + tt.options + `,` + messages + "}\n"
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", w.Code)
+			}
+			if n, _ := got.Options["num_ctx"].(float64); n != tt.wantCtx {
+				t.Errorf("num_ctx = %v, want %v", got.Options["num_ctx"], tt.wantCtx)
+			}
+			if temp, _ := got.Options["temperature"].(float64); temp != 0.5 {
+				t.Errorf("expected client options preserved, got %v", got.Options)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"slices"
+
+	"ollama-auto-ctx/internal/config"
+)
+
+// injectSeed sets options.seed per SEED_POLICY when the client didn't send
+// one, returning the seed and whether it was injected. The hash policy
+// derives the seed from the client's body, so identical requests get
+// identical seeds. A seed is never added when OPTIONS_ALLOWLIST would strip it.
+func injectSeed(reqMap map[string]any, body []byte, cfg config.Config) (int64, bool) {
+	if cfg.SeedPolicy == "" || cfg.SeedPolicy == config.SeedNone {
+		return 0, false
+	}
+	if len(cfg.OptionsAllowlist) > 0 && !slices.Contains(cfg.OptionsAllowlist, "seed") {
+		return 0, false
+	}
+	opts, ok := reqMap["options"].(map[string]any)
+	if !ok || opts == nil {
+		opts = make(map[string]any)
+	}
+	if _, set := opts["seed"]; set {
+		return 0, false
+	}
+
+	seed := cfg.Seed
+	if cfg.SeedPolicy == config.SeedHash {
+		h := fnv.New32a()
+		_, _ = h.Write(body)
+		seed = int64(h.Sum32() & 0x7fffffff) // keep it a positive int32 for llama.cpp
+	}
+	opts["seed"] = seed
+	reqMap["options"] = opts
+	return seed, true
+}
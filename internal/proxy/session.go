@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/estimate"
+)
+
+// SessionHeader groups requests of one multi-turn task, e.g. an agent run.
+// With SESSION_BUDGET_ESCALATION_ENABLED, a session whose responses keep
+// stopping at done_reason=length gets a larger output budget. It is consumed
+// by the proxy, never forwarded.
+const SessionHeader = "X-AutoCtx-Session"
+
+const maxSessionIDLen = 128
+
+// sessionID returns r's SessionHeader value, or "" when it is missing or
+// longer than maxSessionIDLen.
+func (h *Handler) sessionID(r *http.Request) string {
+	id := strings.TrimSpace(r.Header.Get(SessionHeader))
+	if len(id) > maxSessionIDLen {
+		h.logger.Warn("ignoring oversized "+SessionHeader+" header", "path", r.URL.Path, "bytes", len(id))
+		return ""
+	}
+	return id
+}
+
+// SetSessionEscalator enables session budget escalation: requests with a
+// SessionHeader have their output budget scaled by e, and how their
+// responses end feeds it.
+func (h *Handler) SetSessionEscalator(e *calibration.SessionEscalator) {
+	h.sessionBudgets = e
+}
+
+// escalateBudget scales a proxy-chosen output budget for session, up to
+// MAX_OUTPUT_BUDGET, returning the budget and the escalation level applied.
+// A client's num_predict is left alone: its length stops are what it asked for.
+func (h *Handler) escalateBudget(session, model string, budget estimate.OutputBudgetResult) (int, int) {
+	if budget.Source == "explicit_num_predict" {
+		return budget.Budget, 0
+	}
+	scaled, level := h.sessionBudgets.Escalate(session, budget.Budget, h.cfg.MaxOutputBudget)
+	if level > 0 {
+		h.metrics.RecordSessionEscalation(model)
+	}
+	return scaled, level
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// defaultShadowMaxBodyBytes caps how much of a shadow response is read when
+// ShadowConfig.MaxBodyBytes is unset.
+const defaultShadowMaxBodyBytes = 5 * 1024 * 1024
+
+// ShadowConfig holds configuration for shadow mode.
+type ShadowConfig struct {
+	SampleRate   float64       // SHADOW_SAMPLE_RATE
+	Timeout      time.Duration // SHADOW_TIMEOUT, per mirrored request
+	MaxInFlight  int           // SHADOW_MAX_IN_FLIGHT
+	MaxBodyBytes int64         // largest shadow response read for comparison
+}
+
+// ShadowMirror sends copies of sampled non-streaming chat/generate requests to
+// a second ("canary") upstream and stores how it answered on the primary's
+// request record, for comparing Ollama versions. Mirrors run in the background
+// on their own timeout: the client's response is never delayed or altered,
+// and when MaxInFlight mirrors are already running new ones are skipped.
+// A nil mirror mirrors nothing.
+type ShadowMirror struct {
+	target  *url.URL
+	cfg     ShadowConfig
+	client  *http.Client
+	store   storage.Store
+	metrics *supervisor.Metrics
+	logger  *slog.Logger
+
+	sem    chan struct{}
+	sample func() float64 // uniform in [0, 1); decides which requests are mirrored
+}
+
+// NewShadowMirror creates a mirror to target. Results are written to store.
+func NewShadowMirror(target *url.URL, cfg ShadowConfig, store storage.Store, metrics *supervisor.Metrics, logger *slog.Logger) *ShadowMirror {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.MaxInFlight < 1 {
+		cfg.MaxInFlight = 1
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = defaultShadowMaxBodyBytes
+	}
+	return &ShadowMirror{
+		target:  target,
+		cfg:     cfg,
+		client:  &http.Client{},
+		store:   store,
+		metrics: metrics,
+		logger:  logger,
+		sem:     make(chan struct{}, cfg.MaxInFlight),
+		sample:  rand.Float64,
+	}
+}
+
+// start mirrors r (whose body has already been rewritten) when it is sampled
+// and non-streaming, returning the job the primary side reports to, or nil.
+// r.Body is left readable.
+func (m *ShadowMirror) start(r *http.Request, reqID string) *shadowJob {
+	if m == nil || m.store == nil || reqID == "" || r.Body == nil || m.sample() >= m.cfg.SampleRate {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	setBody(r, body)
+	if err != nil {
+		return nil
+	}
+	// Ollama streams unless the client sends stream:false.
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.Stream == nil || *req.Stream {
+		return nil
+	}
+
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		m.metrics.RecordShadowResult("skipped")
+		return nil
+	}
+	job := &shadowJob{m: m, reqID: reqID}
+	go job.run(r.URL.Path, body)
+	return job
+}
+
+// shadowJob pairs one mirrored request with its primary. Whichever side
+// finishes last writes the comparison.
+type shadowJob struct {
+	m     *ShadowMirror
+	reqID string
+
+	mu           sync.Mutex
+	primaryDone  bool
+	primaryOut   string
+	primaryOutOK bool
+	shadowDone   bool
+	shadowOut    string
+	shadowOutOK  bool
+	shadowResult storage.ShadowResult
+}
+
+// observePrimaryBody records the primary's buffered non-stream response body.
+func (j *shadowJob) observePrimaryBody(body []byte) {
+	out, _, ok := shadowOutput(body)
+	j.mu.Lock()
+	j.primaryOut, j.primaryOutOK = out, ok
+	j.mu.Unlock()
+}
+
+// primaryFinished marks the primary response as fully sent to the client.
+func (j *shadowJob) primaryFinished() {
+	j.mu.Lock()
+	j.primaryDone = true
+	ready := j.shadowDone
+	j.mu.Unlock()
+	if ready {
+		j.record()
+	}
+}
+
+func (j *shadowJob) run(path string, body []byte) {
+	defer func() { <-j.m.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), j.m.cfg.Timeout)
+	defer cancel()
+
+	target := *j.m.target
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	start := time.Now()
+	var res storage.ShadowResult
+	var out string
+	var outOK bool
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		var resp *http.Response
+		if resp, err = j.m.client.Do(req); err == nil {
+			res.HTTPStatus = resp.StatusCode
+			var data []byte
+			data, err = io.ReadAll(io.LimitReader(resp.Body, j.m.cfg.MaxBodyBytes+1))
+			resp.Body.Close()
+			if err == nil && resp.StatusCode == http.StatusOK && int64(len(data)) <= j.m.cfg.MaxBodyBytes {
+				out, res.CompletionTokens, outOK = shadowOutput(data)
+			}
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+		j.m.logger.Debug("shadow request failed", "id", j.reqID, "err", err)
+	}
+	res.DurationMs = int(time.Since(start).Milliseconds())
+
+	j.mu.Lock()
+	j.shadowDone = true
+	j.shadowOut, j.shadowOutOK, j.shadowResult = out, outOK, res
+	ready := j.primaryDone
+	j.mu.Unlock()
+	if ready {
+		j.record()
+	}
+}
+
+func (j *shadowJob) record() {
+	j.mu.Lock()
+	res := j.shadowResult
+	if j.primaryOutOK && j.shadowOutOK {
+		match := j.primaryOut == j.shadowOut
+		res.OutputMatch = &match
+	}
+	j.mu.Unlock()
+
+	result := "uncompared"
+	switch {
+	case res.Error != "" || res.HTTPStatus != http.StatusOK:
+		result = "error"
+	case res.OutputMatch != nil && *res.OutputMatch:
+		result = "match"
+	case res.OutputMatch != nil:
+		result = "mismatch"
+	}
+	j.m.metrics.RecordShadowResult(result)
+
+	if err := j.m.store.Update(j.reqID, storage.RequestUpdate{Shadow: &res}); err != nil {
+		j.m.logger.Error("failed to store shadow result", "err", err, "id", j.reqID)
+	}
+}
+
+// shadowOutput extracts the output text and eval_count from a non-stream
+// /api/generate ("response") or /api/chat ("message.content") body.
+func shadowOutput(body []byte) (string, int, bool) {
+	var resp struct {
+		Response *string `json:"response"`
+		Message  *struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		EvalCount int `json:"eval_count"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", 0, false
+	}
+	switch {
+	case resp.Message != nil:
+		return resp.Message.Content, resp.EvalCount, true
+	case resp.Response != nil:
+		return *resp.Response, resp.EvalCount, true
+	}
+	return "", resp.EvalCount, false
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// logSlowRequest warns when the completed request reqID took longer than
+// its model's SLOW_REQUEST_THRESHOLD, with how it was sized, the tokens it
+// actually used and where its time went, so tail latency can be looked into
+// without debug logging. With SLOW_REQUEST_EVENT_ENABLED it also publishes a
+// slow_request event. It must run before the tracker finishes the request.
+func (h *Handler) logSlowRequest(r *http.Request, reqID string, startTime time.Time) {
+	if h.tracker == nil {
+		return
+	}
+	info := h.tracker.GetRequestInfo(reqID)
+	if info == nil {
+		return
+	}
+	dec, sized := r.Context().Value(ctxDecisionKey).(Decision)
+	model := info.Model
+	if sized {
+		model = dec.Model
+	}
+	threshold := h.cfg.SlowRequestThresholdFor(model)
+	elapsed := time.Since(startTime)
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+
+	var ttfbMs int64
+	if info.FirstByteTime != nil {
+		ttfbMs = info.FirstByteTime.Sub(info.StartTime).Milliseconds()
+	}
+	timings := []any{"ttfb_ms", ttfbMs, "estimate_ms", info.EstimateDuration.Milliseconds()}
+	if info.ForwardTime != nil {
+		timings = append(timings, "forward_ms", info.ForwardTime.Sub(info.StartTime).Milliseconds())
+		if info.UpstreamDoneTime != nil {
+			timings = append(timings, "upstream_ms", info.UpstreamDoneTime.Sub(*info.ForwardTime).Milliseconds())
+		}
+	}
+	timings = append(timings,
+		"load_ms", info.LoadDuration.Milliseconds(),
+		"prompt_eval_ms", info.PromptEvalDuration.Milliseconds(),
+		"eval_ms", info.EvalDuration.Milliseconds())
+
+	attrs := []any{
+		"id", reqID,
+		"path", r.URL.Path,
+		"model", model,
+		"duration_ms", elapsed.Milliseconds(),
+		"threshold_ms", threshold.Milliseconds(),
+		"prompt_tokens", info.PromptEvalCount,
+		"completion_tokens", info.EvalCount,
+		"bytes_out", info.BytesForwarded,
+		slog.Group("timings", timings...),
+	}
+	if sized {
+		attrs = append(attrs, slog.Group("decision",
+			"prompt_tokens_est", dec.EstimatedPromptTokens,
+			"output_budget", dec.OutputBudgetTokens,
+			"output_budget_source", dec.OutputBudgetSource,
+			"headroom", dec.Headroom,
+			"chosen_ctx", dec.ChosenCtx,
+			"user_ctx", dec.UserCtx,
+			"override_applied", dec.OverrideApplied,
+			"clamped", dec.Clamped,
+			"max_model_ctx", dec.MaxModelCtx,
+			"max_vram_ctx", dec.MaxVRAMCtx,
+			"sampled", dec.Sampled))
+	}
+	h.logger.Warn("slow request", attrs...)
+
+	if !h.cfg.SlowRequestEventEnabled || h.eventBus == nil {
+		return
+	}
+	ev := supervisor.Event{
+		Type:       supervisor.EventSlowRequest,
+		RequestID:  reqID,
+		Timestamp:  time.Now(),
+		Endpoint:   info.Endpoint,
+		Model:      model,
+		BytesOut:   info.BytesForwarded,
+		TTFBMs:     ttfbMs,
+		DurationMs: elapsed.Milliseconds(),
+	}
+	if sized {
+		if raw, err := json.Marshal(dec); err == nil {
+			ev.Decision = raw
+		}
+	}
+	h.eventBus.Publish(ev)
+}
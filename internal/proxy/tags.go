@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"strings"
+
+	"ollama-auto-ctx/internal/storage"
+)
+
+// TagsHeader carries client-supplied request tags for attribution, e.g.
+// "team=search,project=rag". It is consumed by the proxy, never forwarded.
+const TagsHeader = "X-AutoCtx-Tags"
+
+const (
+	maxTagsHeaderBytes = 512
+	maxTags            = 8
+	maxTagKeyLen       = 32
+	maxTagValueLen     = 64
+)
+
+// parseTags validates a TagsHeader value and returns the accepted tags in
+// canonical storage form plus the number of pairs dropped. Keys are
+// lowercased; a repeated key keeps its last value. Oversized headers are
+// dropped whole.
+func parseTags(header string) (string, int) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return "", 0
+	}
+	pairs := strings.Split(header, ",")
+	if len(header) > maxTagsHeaderBytes {
+		return "", len(pairs)
+	}
+
+	tags := make(map[string]string)
+	dropped := 0
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if !ok || !validTagPart(k, maxTagKeyLen, false) || !validTagPart(v, maxTagValueLen, true) {
+			dropped++
+			continue
+		}
+		if _, seen := tags[k]; !seen && len(tags) == maxTags {
+			dropped++
+			continue
+		}
+		tags[k] = v
+	}
+	return storage.FormatTags(tags), dropped
+}
+
+// validTagPart allows letters, digits and "_.-" (plus ":/@" in values), so
+// stored tags never contain the ',' and '=' separators.
+func validTagPart(s string, maxLen int, value bool) bool {
+	if s == "" || len(s) > maxLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		case value && (c >= 'A' && c <= 'Z' || c == ':' || c == '/' || c == '@'):
+		default:
+			return false
+		}
+	}
+	return true
+}
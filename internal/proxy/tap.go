@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"io"
 	"log/slog"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/config"
 	"ollama-auto-ctx/internal/storage"
 	"ollama-auto-ctx/internal/supervisor"
 	"ollama-auto-ctx/internal/util"
@@ -22,10 +26,14 @@ import (
 // - future proof (it ignores unknown fields)
 //
 // Note: streaming in Ollama is typically newline-delimited JSON (NDJSON), not WebSockets.
+// OpenAI-compatible upstreams stream Server-Sent Events instead; their
+// "data: {json}" lines are parsed the same way. UPSTREAM_DIALECT fixes the
+// framing for backends whose Content-Type doesn't say (see setDialect).
 type TapReadCloser struct {
 	rc io.ReadCloser
 
 	isNDJSON bool
+	isSSE    bool
 	isJSON   bool
 
 	maxBuffer int64
@@ -45,18 +53,65 @@ type TapReadCloser struct {
 	limitExceeded     bool
 	minOutputBytes    int64 // minimum bytes before checking limit
 
-	// ndjsonBuf holds any incomplete line between reads.
+	// minEvalCount flags a finished non-stream 200 with fewer output tokens as
+	// an empty response (0 disables; set by the handler for 200s only).
+	minEvalCount  int
+	emptyReported bool
+
+	// utilization, if set, learns actual/requested tokens from successful,
+	// fully-estimated responses (set by the handler for 200s only).
+	utilization *calibration.UtilizationLearner
+
+	// onDone, if set, is called once on Close with done_reason when the
+	// response finished and reported one (set by the handler for 200s in an
+	// escalating session).
+	onDone func(doneReason string)
+
+	// truncationLimit, if > 0, is the chosen num_ctx minus the output budget:
+	// a prompt_eval_count above it means the estimate undersized the request
+	// and the prompt was likely truncated. Such observations update
+	// calibration with truncationWeight (unset when the client chose num_ctx),
+	// and onTruncated, if set, is called
+	// once with the count (set by the handler when TRUNCATION_CHECK_ENABLED).
+	truncationLimit  int
+	truncationWeight float64
+	onTruncated      func(promptTokens int)
+	truncated        bool
+
+	// onReadError, if set, is called once when the upstream body fails with
+	// anything other than io.EOF, e.g. a connection reset mid-stream.
+	onReadError func(error)
+
+	// trailer, if set, is the response's trailer map, in which Close sets the
+	// token-count trailers (set by the handler for streamed 200s when
+	// TOKEN_TRAILERS_ENABLED).
+	trailer http.Header
+
+	// onJSONBody, if set, receives the complete non-stream JSON body once
+	// (set by the handler for requests mirrored to the shadow upstream).
+	onJSONBody func([]byte)
+
+	// ndjsonBuf holds any incomplete NDJSON or SSE line between reads.
 	ndjsonBuf []byte
 
 	// jsonBuf buffers non-stream JSON bodies up to maxBuffer.
 	jsonBuf          []byte
 	jsonBufTruncated bool
 
-	observed      bool
-	firstByteSent bool
-	totalBytes    int64 // total bytes read for limit checking
+	// scanOverflow, if set, has bodies larger than maxBuffer scanned for
+	// their token counts and durations by overflowScan instead of dropped
+	// (set by the handler when RESPONSE_TAP_SCAN_OVERFLOW).
+	scanOverflow bool
+	overflowScan *jsonFieldScanner
+
+	observed         bool
+	evalRateObserved bool
+	firstByteSent    bool
+	totalBytes       int64 // total bytes read for limit checking
 
 	// Parsed Ollama response data
+	done                 bool
+	doneReason           string // done_reason, or finish_reason for OpenAI-compatible chunks
 	promptEvalCount      int
 	evalCount            int
 	loadDurationNs       int64
@@ -69,11 +124,13 @@ type TapReadCloser struct {
 func NewTapReadCloser(rc io.ReadCloser, contentType string, _ int64, maxBuffer int64, sample calibration.Sample, calibStore *calibration.Store, tracker *supervisor.Tracker, loopDetector *supervisor.LoopDetector, requestID string, logger *slog.Logger, outputTokenLimit int64, outputLimitAction string, cancelFunc func(), minOutputBytes int64, dataStore storage.Store) io.ReadCloser {
 	ctLower := strings.ToLower(contentType)
 	isNDJSON := strings.Contains(ctLower, "application/x-ndjson")
+	isSSE := strings.Contains(ctLower, "text/event-stream")
 	isJSON := strings.Contains(ctLower, "application/json")
 
 	return &TapReadCloser{
 		rc:                rc,
 		isNDJSON:          isNDJSON,
+		isSSE:             isSSE,
 		isJSON:            isJSON,
 		maxBuffer:         maxBuffer,
 		sample:            sample,
@@ -90,6 +147,25 @@ func NewTapReadCloser(rc io.ReadCloser, contentType string, _ int64, maxBuffer i
 	}
 }
 
+// setDialect frames the body per UPSTREAM_DIALECT rather than by its
+// Content-Type alone: a non-JSON body is NDJSON under ollama and SSE under
+// openai, and raw parses nothing. A body whose framing is unknown only has
+// its bytes counted. It must be called before the first Read.
+func (t *TapReadCloser) setDialect(d config.UpstreamDialect) {
+	switch d {
+	case config.UpstreamOllama:
+		t.isNDJSON, t.isSSE = !t.isJSON, false
+	case config.UpstreamOpenAI:
+		t.isNDJSON, t.isSSE = false, !t.isJSON
+	case config.UpstreamRaw:
+		t.isNDJSON, t.isSSE, t.isJSON = false, false, false
+		return
+	}
+	if !t.isNDJSON && !t.isSSE && !t.isJSON && t.logger != nil {
+		t.logger.Debug("upstream response framing unknown; counting bytes only", "id", t.requestID, "dialect", d)
+	}
+}
+
 func (t *TapReadCloser) Read(p []byte) (int, error) {
 	n, err := t.rc.Read(p)
 	if n > 0 {
@@ -141,15 +217,22 @@ func (t *TapReadCloser) Read(p []byte) (int, error) {
 		t.process(p[:n])
 	}
 	if err == io.EOF {
+		if t.tracker != nil && t.requestID != "" {
+			t.tracker.MarkUpstreamDone(t.requestID)
+		}
 		// Best-effort parse of any trailing bytes
 		t.finish()
 		// Update storage with parsed data
 		t.updateStorage()
+	} else if err != nil && t.onReadError != nil {
+		onReadError := t.onReadError
+		t.onReadError = nil
+		onReadError(err)
 	}
 	return n, err
 }
 
-// feedLoopDetector extracts text content from NDJSON and feeds it to the loop detector.
+// feedLoopDetector extracts text content from a streamed JSON chunk and feeds it to the loop detector.
 // It returns true if a loop was detected (and the request was cancelled).
 func (t *TapReadCloser) feedLoopDetector(line []byte) bool {
 	if t.loopDetector == nil {
@@ -180,6 +263,20 @@ func (t *TapReadCloser) feedLoopDetector(line []byte) bool {
 		}
 	}
 
+	// Check for OpenAI-compatible format: {"choices": [{"delta": {"content": "..."}}]}
+	// or {"choices": [{"text": "..."}]} for completions
+	if choices, ok := m["choices"].([]any); ok && len(choices) > 0 {
+		if choice, ok := choices[0].(map[string]any); ok {
+			if delta, ok := choice["delta"].(map[string]any); ok {
+				if content, ok := delta["content"].(string); ok {
+					textDelta = content
+				}
+			} else if text, ok := choice["text"].(string); ok {
+				textDelta = text
+			}
+		}
+	}
+
 	if textDelta != "" {
 		return t.loopDetector.Feed([]byte(textDelta))
 	}
@@ -191,6 +288,10 @@ func (t *TapReadCloser) Close() error {
 	// Ensure we parse any remaining data and update storage
 	t.finish()
 	t.updateStorage()
+	t.observeUtilization()
+	t.observeEvalRate()
+	t.observeDone()
+	t.setTokenTrailers()
 	if t.logger != nil && t.requestID != "" {
 		t.logger.Debug("TapReadCloser closed, storage updated", "id", t.requestID,
 			"prompt_tokens", t.promptEvalCount, "completion_tokens", t.evalCount,
@@ -200,7 +301,7 @@ func (t *TapReadCloser) Close() error {
 }
 
 func (t *TapReadCloser) process(chunk []byte) {
-	if t.isNDJSON {
+	if t.isNDJSON || t.isSSE {
 		t.ndjsonBuf = append(t.ndjsonBuf, chunk...)
 		for {
 			idx := bytes.IndexByte(t.ndjsonBuf, '\n')
@@ -215,6 +316,9 @@ func (t *TapReadCloser) process(chunk []byte) {
 			t.ndjsonBuf = t.ndjsonBuf[idx+1:]
 
 			line = bytes.TrimSpace(bytes.TrimSuffix(line, []byte{'\r'}))
+			if t.isSSE {
+				line = t.sseData(line)
+			}
 			if len(line) == 0 {
 				continue
 			}
@@ -230,39 +334,75 @@ func (t *TapReadCloser) process(chunk []byte) {
 
 	if t.isJSON {
 		// Buffer non-stream JSON bodies (up to maxBuffer).
-		if t.maxBuffer <= 0 || t.jsonBufTruncated {
+		if t.overflowScan != nil {
+			t.overflowScan.Write(chunk)
 			return
 		}
-		remaining := t.maxBuffer - int64(len(t.jsonBuf))
-		if remaining <= 0 {
-			t.jsonBufTruncated = true
+		if t.jsonBufTruncated {
 			return
 		}
+		remaining := t.maxBuffer - int64(len(t.jsonBuf))
 		if int64(len(chunk)) <= remaining {
 			t.jsonBuf = append(t.jsonBuf, chunk...)
-		} else {
-			t.jsonBuf = append(t.jsonBuf, chunk[:remaining]...)
-			t.jsonBufTruncated = true
+			return
+		}
+		t.jsonBufTruncated = true
+		if t.scanOverflow {
+			// Too large to decode whole: scan what was buffered and the rest
+			// as it arrives instead.
+			t.overflowScan = newJSONFieldScanner(tapScanFields)
+			t.overflowScan.Write(t.jsonBuf)
+			t.overflowScan.Write(chunk)
 		}
+		t.jsonBuf = nil
 	}
 }
 
 func (t *TapReadCloser) finish() {
 	// Parse any remaining data regardless of observation status
-	if t.isNDJSON {
+	if t.isNDJSON || t.isSSE {
 		// Try parsing any trailing partial line.
 		line := bytes.TrimSpace(bytes.TrimSuffix(t.ndjsonBuf, []byte{'\r'}))
+		if t.isSSE {
+			line = t.sseData(line)
+		}
 		if len(line) > 0 {
 			t.tryParseJSON(line)
 		}
 		return
 	}
+	if t.overflowScan != nil {
+		t.applyFields(t.overflowScan.Fields())
+		t.overflowScan = nil // finish may run more than once
+		return
+	}
 	if t.isJSON && !t.jsonBufTruncated && len(t.jsonBuf) > 0 {
 		line := bytes.TrimSpace(t.jsonBuf)
 		t.tryParseJSON(line)
+		if t.onJSONBody != nil {
+			onJSONBody := t.onJSONBody
+			t.onJSONBody = nil
+			onJSONBody(line)
+		}
 	}
 }
 
+// sseData returns the JSON payload of an SSE "data:" line, or nil for
+// comments, other fields, blank lines and the "[DONE]" terminator (which
+// marks the stream finished).
+func (t *TapReadCloser) sseData(line []byte) []byte {
+	payload, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return nil
+	}
+	payload = bytes.TrimSpace(payload)
+	if bytes.Equal(payload, []byte("[DONE]")) {
+		t.done = true
+		return nil
+	}
+	return payload
+}
+
 func (t *TapReadCloser) tryParseJSON(line []byte) {
 	// Parse into a map for forward compatibility.
 	dec := json.NewDecoder(bytes.NewReader(line))
@@ -271,21 +411,60 @@ func (t *TapReadCloser) tryParseJSON(line []byte) {
 	if err := dec.Decode(&m); err != nil {
 		return
 	}
+	t.applyFields(m)
+}
 
+// applyFields records the token counts, timing and done flag of one decoded
+// response object or stream chunk.
+func (t *TapReadCloser) applyFields(m map[string]any) {
 	// Extract prompt_eval_count (input tokens)
 	if v, ok := m["prompt_eval_count"]; ok {
 		if n, ok := util.ToInt(v); ok && n > 0 {
-			t.promptEvalCount = n
-			if t.calibStore != nil && t.sample.Model != "" {
-				t.calibStore.Update(t.sample, calibration.Observed{PromptEvalCount: n})
-			}
-			t.observed = true
-			if t.logger != nil {
-				t.logger.Debug("calibration observation", "model", t.sample.Model, "prompt_eval_count", n)
+			t.observePromptTokens(n)
+		}
+	}
+
+	// OpenAI-compatible chunks report token counts under "usage" instead
+	if usage, ok := m["usage"].(map[string]any); ok {
+		if n, ok := util.ToInt(usage["prompt_tokens"]); ok && n > 0 {
+			t.observePromptTokens(n)
+		}
+		if n, ok := util.ToInt(usage["completion_tokens"]); ok && n > 0 {
+			t.evalCount = n
+		}
+	}
+
+	if v, ok := m["done"].(bool); ok && v {
+		t.done = true
+	}
+	if v, ok := m["done_reason"].(string); ok && v != "" {
+		t.doneReason = v
+	}
+	if choices, ok := m["choices"].([]any); ok && len(choices) > 0 {
+		if c, ok := choices[0].(map[string]any); ok {
+			if v, ok := c["finish_reason"].(string); ok && v != "" {
+				t.doneReason = v
 			}
 		}
 	}
 
+	// llama-server reports its counts and timings (in ms) under "timings",
+	// possibly with every chunk; only the first prompt count is calibrated.
+	if timings, ok := m["timings"].(map[string]any); ok {
+		if n, ok := util.ToInt(timings["prompt_n"]); ok && n > 0 && t.promptEvalCount == 0 {
+			t.observePromptTokens(n)
+		}
+		if n, ok := util.ToInt(timings["predicted_n"]); ok && n > 0 {
+			t.evalCount = n
+		}
+		if ns := msToNs(timings["prompt_ms"]); ns > 0 {
+			t.promptEvalDurationNs = ns
+		}
+		if ns := msToNs(timings["predicted_ms"]); ns > 0 {
+			t.evalDurationNs = ns
+		}
+	}
+
 	// Extract eval_count (output tokens)
 	if v, ok := m["eval_count"]; ok {
 		if n, ok := util.ToInt(v); ok && n > 0 {
@@ -319,6 +498,90 @@ func (t *TapReadCloser) tryParseJSON(line []byte) {
 	if (t.promptEvalCount > 0 || t.evalCount > 0) && t.tracker != nil && t.requestID != "" {
 		t.tracker.UpdateTokenCounts(t.requestID, t.promptEvalCount, t.evalCount)
 	}
+	if (t.loadDurationNs > 0 || t.promptEvalDurationNs > 0 || t.evalDurationNs > 0) && t.tracker != nil && t.requestID != "" {
+		t.tracker.UpdateUpstreamTimings(t.requestID, time.Duration(t.loadDurationNs), time.Duration(t.promptEvalDurationNs), time.Duration(t.evalDurationNs))
+	}
+}
+
+// msToNs converts a JSON millisecond value, which may be fractional, to
+// nanoseconds; 0 when it isn't a number.
+func msToNs(v any) int64 {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0
+	}
+	ms, err := n.Float64()
+	if err != nil {
+		return 0
+	}
+	return int64(ms * float64(time.Millisecond))
+}
+
+// observePromptTokens records the upstream's input token count and feeds calibration.
+func (t *TapReadCloser) observePromptTokens(n int) {
+	t.promptEvalCount = n
+	obs := calibration.Observed{PromptEvalCount: n}
+	if t.truncationLimit > 0 && n > t.truncationLimit {
+		obs.Weight = t.truncationWeight
+		if !t.truncated && t.onTruncated != nil {
+			t.onTruncated(n)
+		}
+		t.truncated = true
+	}
+	if t.calibStore != nil && t.sample.Model != "" && !t.sample.Sampled {
+		t.calibStore.Update(t.sample, obs)
+	}
+	t.observed = true
+	if t.logger != nil {
+		t.logger.Debug("calibration observation", "model", t.sample.Model, "prompt_eval_count", n)
+	}
+}
+
+// observeUtilization feeds the utilization learner once the final chunk with
+// Ollama's token counts has been seen.
+func (t *TapReadCloser) observeUtilization() {
+	if t.utilization == nil || !t.done || t.sample.Sampled || t.promptEvalCount <= 0 {
+		return
+	}
+	t.utilization.Observe(t.sample.Model, t.promptEvalCount+t.evalCount, t.sample.RequestedTokens)
+	t.utilization = nil // Close may be called more than once
+}
+
+// observeEvalRate feeds the model's generation speed to calibration once the
+// final chunk reported eval_count and eval_duration.
+func (t *TapReadCloser) observeEvalRate() {
+	if t.calibStore == nil || !t.done || t.evalRateObserved || t.sample.Model == "" {
+		return
+	}
+	if t.evalCount <= 0 || t.evalDurationNs <= 0 {
+		return
+	}
+	t.calibStore.RecordEvalRate(t.sample.Model, t.evalCount, time.Duration(t.evalDurationNs))
+	t.evalRateObserved = true // Close may be called more than once
+}
+
+// setTokenTrailers fills in the token-count trailers, if the handler
+// announced them, with the counts the response reported.
+func (t *TapReadCloser) setTokenTrailers() {
+	if t.trailer == nil {
+		return
+	}
+	if t.promptEvalCount > 0 {
+		t.trailer.Set(PromptTokensTrailer, strconv.Itoa(t.promptEvalCount))
+	}
+	if t.evalCount > 0 {
+		t.trailer.Set(CompletionTokensTrailer, strconv.Itoa(t.evalCount))
+	}
+}
+
+// observeDone reports how a finished response ended to onDone.
+func (t *TapReadCloser) observeDone() {
+	if t.onDone == nil || !t.done || t.doneReason == "" {
+		return
+	}
+	onDone := t.onDone
+	t.onDone = nil // Close may be called more than once
+	onDone(t.doneReason)
 }
 
 // updateStorage updates the storage with parsed Ollama response data.
@@ -341,6 +604,11 @@ func (t *TapReadCloser) updateStorage() {
 	}
 
 	// Timing data (convert nanoseconds to milliseconds)
+	if t.totalDurationNs > 0 {
+		totalMs := int(t.totalDurationNs / 1_000_000)
+		upd.UpstreamTotalMs = &totalMs
+		hasUpdate = true
+	}
 	if t.loadDurationNs > 0 {
 		loadMs := int(t.loadDurationNs / 1_000_000)
 		upd.UpstreamLoadMs = &loadMs
@@ -357,6 +625,22 @@ func (t *TapReadCloser) updateStorage() {
 		hasUpdate = true
 	}
 
+	// Empty completion: status stays success, but the reason makes it findable
+	if t.isEmptyCompletion() {
+		reason := storage.ReasonEmptyResponse
+		upd.Reason = &reason
+		hasUpdate = true
+		if t.logger != nil && !t.emptyReported {
+			t.logger.Warn("upstream returned an empty completion", "id", t.requestID, "model", t.sample.Model, "eval_count", t.evalCount)
+		}
+		t.emptyReported = true
+	}
+
+	if t.truncated {
+		upd.TruncationSuspected = &t.truncated
+		hasUpdate = true
+	}
+
 	// Bytes transferred (upstream out = bytes we received from upstream)
 	if t.totalBytes > 0 {
 		upd.UpstreamOutBytes = &t.totalBytes
@@ -372,6 +656,11 @@ func (t *TapReadCloser) updateStorage() {
 	}
 }
 
+// isEmptyCompletion reports a finished non-stream response below minEvalCount output tokens.
+func (t *TapReadCloser) isEmptyCompletion() bool {
+	return t.minEvalCount > 0 && t.isJSON && t.done && t.evalCount < t.minEvalCount
+}
+
 // estimateOutputTokens estimates output tokens from bytes using calibration store.
 func (t *TapReadCloser) estimateOutputTokens() int64 {
 	// Use calibration store if available, otherwise use default
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"ollama-auto-ctx/internal/calibration"
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+func TestTapReadCloser_SSE(t *testing.T) {
+	var stream strings.Builder
+	stream.WriteString(": keep-alive\n\n")
+	for i := 0; i < 40; i++ {
+		stream.WriteString("event: message\n")
+		stream.WriteString(`data: {"choices":[{"delta":{"content":"the same sentence over and over again. "}}]}` + "\n\n")
+	}
+	stream.WriteString(`data: {"choices":[],"usage":{"prompt_tokens":120,"completion_tokens":45}}` + "\r\n\r\n")
+	stream.WriteString("data: [DONE]\n\n")
+
+	store := storage.NewMemoryStore(10)
+	if err := store.Insert(&storage.Request{ID: "sse-1", Status: storage.StatusInFlight}); err != nil {
+		t.Fatal(err)
+	}
+	calibStore := calibration.NewStore(0.2, calibration.Params{TokensPerByte: 0.25, FixedOverhead: 32, PerMessageOverhead: 8}, "")
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	loop := supervisor.NewLoopDetector(supervisor.LoopDetectorConfig{WindowBytes: 512, NgramBytes: 32, RepeatThreshold: 3, MinOutputBytes: 256}, "sse-1", cancel, nil)
+
+	// Read a few bytes at a time so lines are split across reads.
+	rc := io.NopCloser(iotest.HalfReader(strings.NewReader(stream.String())))
+	tap := NewTapReadCloser(rc, "text/event-stream; charset=utf-8", 0, 1<<20,
+		calibration.Sample{Model: "m", TextBytes: 400, MessageCount: 2}, calibStore,
+		nil, loop, "sse-1", nil, 0, "", nil, 0, store).(*TapReadCloser)
+	buf := make([]byte, 16)
+	for {
+		if _, err := tap.Read(buf); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	_ = tap.Close()
+
+	if tap.promptEvalCount != 120 || tap.evalCount != 45 {
+		t.Errorf("tokens = %d/%d, want 120/45", tap.promptEvalCount, tap.evalCount)
+	}
+	if !tap.done {
+		t.Error("expected [DONE] to mark the stream finished")
+	}
+	if !loop.Triggered() {
+		t.Error("expected repeated SSE deltas to reach the loop detector")
+	}
+	if got := calibStore.Get("m").Samples; got != 1 {
+		t.Errorf("calibration samples = %d, want 1", got)
+	}
+	rec, _ := store.GetByID("sse-1")
+	if rec == nil || rec.PromptTokens != 120 || rec.CompletionTokens != 45 {
+		t.Fatalf("expected stored tokens 120/45, got %+v", rec)
+	}
+}
+
+func TestTapReadCloser_SSEIgnoresNonData(t *testing.T) {
+	tap := &TapReadCloser{isSSE: true}
+	for _, line := range []string{": comment", "event: message", "id: 7", "retry: 100"} {
+		if got := tap.sseData([]byte(line)); got != nil {
+			t.Errorf("sseData(%q) = %q, want nil", line, got)
+		}
+	}
+	if got := string(tap.sseData([]byte(`data:{"a":1}`))); got != `{"a":1}` {
+		t.Errorf("payload without space = %q", got)
+	}
+	if tap.done {
+		t.Error("done set before [DONE]")
+	}
+	if got := tap.sseData([]byte("data: [DONE]")); got != nil || !tap.done {
+		t.Errorf("[DONE] = %q, done=%v", got, tap.done)
+	}
+}
+
+func TestTapReadCloser_JSONOverflow(t *testing.T) {
+	// A non-stream chat response several times RESPONSE_TAP_MAX_BYTES, whose
+	// content includes escaped quotes, braces and a decoy field name.
+	content := strings.Repeat(`code {\"eval_count\": 1} [x] `, 400)
+	body := `{"model":"m","message":{"role":"assistant","content":"` + content + `","eval_count":7},` +
+		`"done":true,"total_duration":5000000000,"load_duration":1000000,"prompt_eval_count":120,` +
+		`"prompt_eval_duration":2000000,"eval_count":45,"eval_duration":3000000000}`
+	const maxBuffer = 1024
+	if len(body) < 8*maxBuffer {
+		t.Fatalf("body is only %d bytes", len(body))
+	}
+
+	read := func(t *testing.T, body string, scan bool) (*TapReadCloser, *storage.MemoryStore) {
+		store := storage.NewMemoryStore(10)
+		if err := store.Insert(&storage.Request{ID: "json-1", Status: storage.StatusInFlight}); err != nil {
+			t.Fatal(err)
+		}
+		rc := io.NopCloser(iotest.HalfReader(strings.NewReader(body)))
+		tap := NewTapReadCloser(rc, "application/json; charset=utf-8", int64(len(body)), maxBuffer,
+			calibration.Sample{Model: "m", TextBytes: 400, MessageCount: 1}, nil,
+			nil, nil, "json-1", nil, 0, "", nil, 0, store).(*TapReadCloser)
+		tap.scanOverflow = scan
+		if _, err := io.Copy(io.Discard, tap); err != nil {
+			t.Fatal(err)
+		}
+		_ = tap.Close()
+		return tap, store
+	}
+
+	t.Run("scanned", func(t *testing.T) {
+		tap, store := read(t, body, true)
+		if tap.promptEvalCount != 120 || tap.evalCount != 45 || !tap.done {
+			t.Errorf("tokens = %d/%d done=%v, want 120/45 done", tap.promptEvalCount, tap.evalCount, tap.done)
+		}
+		if tap.totalDurationNs != 5000000000 || tap.evalDurationNs != 3000000000 || tap.loadDurationNs != 1000000 {
+			t.Errorf("durations = %d/%d/%d", tap.totalDurationNs, tap.evalDurationNs, tap.loadDurationNs)
+		}
+		if len(tap.jsonBuf) != 0 {
+			t.Errorf("kept %d bytes of an overflowing body", len(tap.jsonBuf))
+		}
+		rec, _ := store.GetByID("json-1")
+		if rec == nil || rec.PromptTokens != 120 || rec.CompletionTokens != 45 || rec.UpstreamTotalMs != 5000 {
+			t.Fatalf("expected stored tokens 120/45 and 5000ms, got %+v", rec)
+		}
+	})
+
+	t.Run("openai usage", func(t *testing.T) {
+		body := `{"choices":[{"message":{"content":"` + content + `"}}],"usage":{"prompt_tokens":11,"completion_tokens":3}}`
+		tap, _ := read(t, body, true)
+		if tap.promptEvalCount != 11 || tap.evalCount != 3 {
+			t.Errorf("tokens = %d/%d, want 11/3", tap.promptEvalCount, tap.evalCount)
+		}
+	})
+
+	t.Run("not scanned", func(t *testing.T) {
+		tap, _ := read(t, body, false)
+		if tap.promptEvalCount != 0 || tap.evalCount != 0 {
+			t.Errorf("tokens = %d/%d without scanning, want none", tap.promptEvalCount, tap.evalCount)
+		}
+	})
+}
+
+func TestTapReadCloser_EvalRate(t *testing.T) {
+	calibStore := calibration.NewStore(0.5, calibration.Params{TokensPerByte: 0.25, FixedOverhead: 32, PerMessageOverhead: 8}, "")
+	read := func(body string) {
+		tap := NewTapReadCloser(io.NopCloser(strings.NewReader(body)), "application/x-ndjson", 0, 1<<20,
+			calibration.Sample{Model: "m"}, calibStore, nil, nil, "", nil, 0, "", nil, 0, nil)
+		if _, err := io.ReadAll(tap); err != nil {
+			t.Fatal(err)
+		}
+		_ = tap.Close()
+		_ = tap.Close()
+	}
+
+	// 100 tokens in 2s of eval time, then 200 in 2s: the EMA moves halfway.
+	read(`{"message":{"content":"a"},"done":false}` + "\n" + `{"done":true,"prompt_eval_count":10,"eval_count":100,"eval_duration":2000000000}` + "\n")
+	if got := calibStore.Get("m").EvalTokensPerSec; got != 50 {
+		t.Fatalf("EvalTokensPerSec = %v, want 50", got)
+	}
+	read(`{"done":true,"prompt_eval_count":10,"eval_count":200,"eval_duration":2000000000}` + "\n")
+	if got := calibStore.Get("m").EvalTokensPerSec; got != 75 {
+		t.Errorf("EvalTokensPerSec = %v, want 75", got)
+	}
+
+	// A response without eval timings, or cut off before done, teaches nothing.
+	read(`{"done":true,"eval_count":200}` + "\n")
+	read(`{"message":{"content":"a"},"eval_count":5,"eval_duration":1000}` + "\n")
+	if got := calibStore.Get("m").EvalTokensPerSec; got != 75 {
+		t.Errorf("EvalTokensPerSec = %v after incomplete responses, want 75", got)
+	}
+}
+
+func TestTapReadCloser_Dialect(t *testing.T) {
+	ndjson := `{"message":{"content":"a"},"done":false}` + "\n" + `{"done":true,"prompt_eval_count":10,"eval_count":4}` + "\n"
+	// llama-server's final chunk, with its own counts and timings.
+	sse := `data: {"choices":[{"delta":{"content":"a"}}]}` + "\n\n" +
+		`data: {"choices":[{"finish_reason":"stop"}],"timings":{"prompt_n":12,"prompt_ms":3.5,"predicted_n":6,"predicted_ms":20}}` + "\n\n" +
+		"data: [DONE]\n\n"
+	read := func(t *testing.T, body, contentType string, dialect config.UpstreamDialect) *TapReadCloser {
+		t.Helper()
+		tap := NewTapReadCloser(io.NopCloser(strings.NewReader(body)), contentType, 0, 1<<20,
+			calibration.Sample{}, nil, nil, nil, "", nil, 0, "", nil, 0, nil).(*TapReadCloser)
+		tap.setDialect(dialect)
+		if _, err := io.ReadAll(tap); err != nil {
+			t.Fatal(err)
+		}
+		_ = tap.Close()
+		return tap
+	}
+
+	// An unlabeled stream is only counted under auto.
+	if tap := read(t, ndjson, "text/plain", config.UpstreamAuto); tap.promptEvalCount != 0 || tap.done {
+		t.Errorf("auto parsed a text/plain body: %d tokens", tap.promptEvalCount)
+	}
+	if tap := read(t, ndjson, "text/plain", config.UpstreamOllama); tap.promptEvalCount != 10 || tap.evalCount != 4 || !tap.done {
+		t.Errorf("ollama: tokens %d/%d done %v, want 10/4 done", tap.promptEvalCount, tap.evalCount, tap.done)
+	}
+	tap := read(t, sse, "application/octet-stream", config.UpstreamOpenAI)
+	if tap.promptEvalCount != 12 || tap.evalCount != 6 || !tap.done || tap.doneReason != "stop" {
+		t.Errorf("openai: tokens %d/%d done %v (%q), want 12/6 done (stop)", tap.promptEvalCount, tap.evalCount, tap.done, tap.doneReason)
+	}
+	if tap.promptEvalDurationNs != 3_500_000 || tap.evalDurationNs != 20_000_000 {
+		t.Errorf("openai: durations %d/%d ns, want 3500000/20000000", tap.promptEvalDurationNs, tap.evalDurationNs)
+	}
+	// raw never parses, even a body labeled NDJSON.
+	if tap := read(t, ndjson, "application/x-ndjson", config.UpstreamRaw); tap.promptEvalCount != 0 || tap.totalBytes != int64(len(ndjson)) {
+		t.Errorf("raw: %d tokens, %d bytes; want none and %d", tap.promptEvalCount, tap.totalBytes, len(ndjson))
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"ollama-auto-ctx/internal/family"
+)
+
+// Think sources recorded with each decision.
+const (
+	thinkSourceClient    = "client"    // "think" field sent by the client
+	thinkSourceDirective = "directive" // __think= in the system prompt
+	thinkSourceDefault   = "default"   // THINK_DEFAULTS entry for the model
+)
+
+// thinkVerdictString converts a client's "think" field into a verdict string.
+func thinkVerdictString(v any) (string, bool) {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return v, true
+	}
+	return "", false
+}
+
+// thinkValueJSON encodes the "think" value sent upstream for storage ("" for none).
+func thinkValueJSON(v any) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// resolveThink picks the effective think verdict for a request and the value
+// of its "think" field, using the model family's think encoding
+// (FAMILY_THINK_ENCODINGS, else the built-in one). A valid __think= directive
+// wins, then a valid client-supplied "think" field, then the per-model default
+// (falling back to a THINK_DEFAULTS entry named after the model's family).
+// A client value the family doesn't accept is dropped; for families without a
+// think encoding it is passed through unchecked. apply is true when the body's
+// "think" field must be rewritten, and a nil value then removes it.
+func (h *Handler) resolveThink(model, directive string, reqMap map[string]any) (verdict, source string, value any, apply bool) {
+	fam := h.families.Classify(model)
+	enc := h.cfg.ThinkEncodingFor(fam)
+	if directive != "" && enc.Valid(directive) {
+		return directive, thinkSourceDirective, enc.Encode(directive), true
+	}
+
+	dropped := false
+	if raw, sent := reqMap["think"]; sent {
+		clientVerdict, ok := thinkVerdictString(raw)
+		switch {
+		case enc.Style == family.ThinkNone:
+			if ok {
+				return clientVerdict, thinkSourceClient, raw, false
+			}
+		case ok && enc.Valid(clientVerdict):
+			// Re-encode e.g. "true" for a boolean family.
+			encoded := enc.Encode(clientVerdict)
+			return clientVerdict, thinkSourceClient, encoded, encoded != raw
+		default:
+			dropped = true
+			h.logger.Debug("dropping think value the model family doesn't accept",
+				"model", model, "family", string(fam), "think", raw)
+		}
+	}
+
+	def := h.cfg.ThinkDefaultFor(model)
+	if def == "" && fam != family.Unknown {
+		def = h.cfg.ThinkDefaults[string(fam)]
+	}
+	if def != "" && enc.Valid(def) {
+		return def, thinkSourceDefault, enc.Encode(def), true
+	}
+	return "", "", nil, dropped
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// errGlobalTimeout is the cause of a request context ended by
+// GLOBAL_REQUEST_TIMEOUT, telling it apart from the watchdog's and the
+// client's cancellations.
+var errGlobalTimeout = errors.New("request exceeded GLOBAL_REQUEST_TIMEOUT")
+
+// globalTimeoutRejection answers a request whose GLOBAL_REQUEST_TIMEOUT
+// expired while it waited to be forwarded.
+var globalTimeoutRejection = rejection{
+	code:   http.StatusGatewayTimeout,
+	status: supervisor.StatusTimeoutGlobal,
+	reason: storage.ReasonTimeoutGlobal,
+	msg:    errGlobalTimeout.Error(),
+}
+
+// globalTimedOut reports whether ctx was ended by GLOBAL_REQUEST_TIMEOUT.
+func globalTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errGlobalTimeout)
+}
+
+// recordGlobalTimeout finalizes a request cut off by GLOBAL_REQUEST_TIMEOUT,
+// mid-stream or before Ollama answered.
+func (h *Handler) recordGlobalTimeout(reqID, path string, startTime time.Time) {
+	h.logger.Warn("request exceeded GLOBAL_REQUEST_TIMEOUT", "id", reqID, "path", path, "timeout", h.cfg.GlobalRequestTimeout)
+	h.finalizeStorageFromTracker(reqID, supervisor.StatusTimeoutGlobal, "", startTime)
+	if h.tracker != nil && h.tracker.GetRequestInfo(reqID) != nil {
+		h.tracker.Finish(reqID, supervisor.StatusTimeoutGlobal, errGlobalTimeout)
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// TimeoutProfileHeader picks one of TIMEOUT_PROFILES for the request, ahead
+// of its model's MODEL_TIMEOUT_PROFILE. It is consumed by the proxy, never
+// forwarded.
+const TimeoutProfileHeader = "X-AutoCtx-Timeout-Profile"
+
+// requestedTimeoutProfile returns the TIMEOUT_PROFILES profile r names with
+// TimeoutProfileHeader, or "" without one. Unknown names are ignored.
+func (h *Handler) requestedTimeoutProfile(r *http.Request) string {
+	v := strings.ToLower(strings.TrimSpace(r.Header.Get(TimeoutProfileHeader)))
+	if v == "" {
+		return ""
+	}
+	if _, ok := h.cfg.TimeoutProfiles[v]; !ok {
+		h.logger.Warn("ignoring unknown "+TimeoutProfileHeader+" profile", "path", r.URL.Path, "value", v)
+		return ""
+	}
+	return v
+}
+
+// applyTimeoutProfile holds the request to the timeout profile resolved for
+// it now that its model is known, and returns the profile's name, or "" when
+// the global timeouts apply or there is no watchdog.
+func (h *Handler) applyTimeoutProfile(r *http.Request, model string) string {
+	if h.watchdog == nil {
+		return ""
+	}
+	requested, _ := r.Context().Value(ctxTimeoutsKey).(string)
+	name := h.cfg.TimeoutProfileFor(model, requested)
+	if name != requested {
+		reqID, _ := r.Context().Value(ctxRequestIDKey).(string)
+		h.setWatchdogProfile(reqID, name)
+	}
+	return name
+}
+
+// setWatchdogProfile holds reqID to the timeouts of the profile called name.
+func (h *Handler) setWatchdogProfile(reqID, name string) {
+	if h.watchdog == nil || name == "" {
+		return
+	}
+	p, ok := h.cfg.TimeoutProfileNamed(name)
+	if !ok {
+		return
+	}
+	h.watchdog.SetTimeouts(reqID, supervisor.Timeouts{TTFB: p.TTFB, Stall: p.Stall, Hard: p.Hard})
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PromptTokensTrailer and CompletionTokensTrailer carry a streamed
+// response's prompt_eval_count and eval_count as HTTP trailers with
+// TOKEN_TRAILERS_ENABLED, as they are only known once the stream ends.
+const (
+	PromptTokensTrailer     = "X-Ollama-CtxProxy-Prompt-Tokens"
+	CompletionTokensTrailer = "X-Ollama-CtxProxy-Completion-Tokens"
+)
+
+// announceTokenTrailers declares the token-count trailers on resp, next to
+// any the upstream declared, and returns the trailer map for the tap to fill
+// in on Close. The reverse proxy announces resp.Trailer's keys with the
+// headers and copies their values once it has closed the body.
+func announceTokenTrailers(resp *http.Response) http.Header {
+	if resp.Trailer == nil {
+		resp.Trailer = make(http.Header)
+	}
+	resp.Trailer[PromptTokensTrailer] = nil
+	resp.Trailer[CompletionTokensTrailer] = nil
+	return resp.Trailer
+}
+
+// responseTrailers returns the trailers a handler set on header after its
+// body: the keys announced in declared (the "Trailer" header sent with the
+// response) and any set with http.TrailerPrefix.
+func responseTrailers(header http.Header, declared []string) http.Header {
+	var trailer http.Header
+	set := func(k string, v []string) {
+		if len(v) == 0 {
+			return
+		}
+		if trailer == nil {
+			trailer = make(http.Header)
+		}
+		trailer[k] = v
+	}
+	for _, keys := range declared {
+		for _, k := range strings.Split(keys, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			set(k, header.Values(k))
+		}
+	}
+	for k, v := range header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			set(http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix)), v)
+		}
+	}
+	return trailer
+}
+
+// announceTrailers declares trailer's keys on w before its headers are
+// written; setTrailers sets their values once the body is.
+func announceTrailers(w http.ResponseWriter, trailer http.Header) {
+	if len(trailer) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	w.Header().Set("Trailer", strings.Join(keys, ", "))
+}
+
+func setTrailers(w http.ResponseWriter, trailer http.Header) {
+	for k, v := range trailer {
+		w.Header()[k] = v
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/config"
+	"ollama-auto-ctx/internal/storage"
+)
+
+// newTrailerUpstream is an HTTP/2 upstream answering chat with a declared
+// trailer and an undeclared one set with http.TrailerPrefix.
+func newTrailerUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.ProtoMajor != 2 {
+			t.Errorf("upstream request over %s, want HTTP/2", r.Proto)
+		}
+		w.Header().Set("Trailer", "X-Upstream-Checksum")
+		raw, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(raw), `"stream":false`) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"message":{"content":"hi"},"done":true,"prompt_eval_count":12,"eval_count":3}`)
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			io.WriteString(w, `{"message":{"content":"h"},"done":false}`+"\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, `{"message":{"content":"i"},"done":false}`+"\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, `{"done":true,"prompt_eval_count":12,"eval_count":2}`+"\n")
+		}
+		w.Header().Set("X-Upstream-Checksum", "abc123")
+		w.Header().Set(http.TrailerPrefix+"X-Upstream-Late", "late")
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	return upstream
+}
+
+func newTrailerTestHandler(cfg config.Config, upstream *httptest.Server) *Handler {
+	h := newRewriteTestHandlerWithStore(cfg, upstream.URL, storage.NewMemoryStore(10))
+	h.proxy.Transport = upstream.Client().Transport
+	return h
+}
+
+func trailerTestConfig() config.Config {
+	return config.Config{
+		Mode:                     config.ModeProtect,
+		MinCtx:                   1024,
+		MaxCtx:                   8192,
+		Buckets:                  []int{1024, 2048, 4096, 8192},
+		Headroom:                 1.0,
+		DefaultOutputBudget:      256,
+		MaxOutputBudget:          1024,
+		RequestBodyMaxBytes:      1 << 20,
+		CalibrationEnabled:       true,
+		StreamCoalesceBytes:      4096,
+		StreamCoalesceMaxLatency: 5 * time.Millisecond,
+	}
+}
+
+func TestUpstreamTrailersPassThrough(t *testing.T) {
+	upstream := newTrailerUpstream(t)
+	defer upstream.Close()
+
+	for _, tokenTrailers := range []bool{false, true} {
+		cfg := trailerTestConfig()
+		cfg.TokenTrailersEnabled = tokenTrailers
+		front := httptest.NewUnstartedServer(newTrailerTestHandler(cfg, upstream))
+		front.EnableHTTP2 = true
+		front.StartTLS()
+
+		resp, err := front.Client().Post(front.URL+"/api/chat", "application/json",
+			strings.NewReader(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		front.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"eval_count":2`) {
+			t.Fatalf("got %d %q, want the full stream", resp.StatusCode, body)
+		}
+		if got := resp.Trailer.Get("X-Upstream-Checksum"); got != "abc123" {
+			t.Errorf("declared upstream trailer = %q, want abc123", got)
+		}
+		if got := resp.Trailer.Get("X-Upstream-Late"); got != "late" {
+			t.Errorf("undeclared upstream trailer = %q, want late", got)
+		}
+
+		prompt, completion := resp.Trailer.Get(PromptTokensTrailer), resp.Trailer.Get(CompletionTokensTrailer)
+		if tokenTrailers && (prompt != "12" || completion != "2") {
+			t.Errorf("token trailers = %q/%q, want 12/2", prompt, completion)
+		}
+		if !tokenTrailers && (prompt != "" || completion != "") {
+			t.Errorf("token trailers sent while disabled: %q/%q", prompt, completion)
+		}
+	}
+}
+
+func TestReplayedResponseTrailers(t *testing.T) {
+	upstream := newTrailerUpstream(t)
+	defer upstream.Close()
+
+	cfg := trailerTestConfig()
+	cfg.TokenTrailersEnabled = true
+	cfg.IdempotencyTTL = time.Minute
+	cfg.IdempotencyCacheSize = 8
+	cfg.IdempotencyMaxBodyBytes = 1 << 20
+	handler := newTrailerTestHandler(cfg, upstream)
+
+	send := func() *http.Response {
+		req := httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"model":"m","stream":false,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set(IdempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+	first, again := send(), send()
+	if again.Header.Get(IdempotentReplayHeader) != "true" {
+		t.Fatal("second request wasn't replayed")
+	}
+	for name, resp := range map[string]*http.Response{"first": first, "replay": again} {
+		if got := resp.Trailer.Get("X-Upstream-Checksum"); got != "abc123" {
+			t.Errorf("%s: declared trailer = %q, want abc123", name, got)
+		}
+		if got := resp.Trailer.Get("X-Upstream-Late"); got != "late" {
+			t.Errorf("%s: undeclared trailer = %q, want late", name, got)
+		}
+		// Token trailers are only for streams.
+		if got := resp.Trailer.Get(PromptTokensTrailer); got != "" {
+			t.Errorf("%s: token trailer %q on a non-streamed response", name, got)
+		}
+	}
+}
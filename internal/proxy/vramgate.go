@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"ollama-auto-ctx/internal/ollama"
+	"ollama-auto-ctx/internal/storage"
+	"ollama-auto-ctx/internal/supervisor"
+)
+
+// modelSize returns model's size from /api/show, the VRAM gate's estimate of
+// what loading a model it never saw loaded takes, or 0 when unknown.
+func (h *Handler) modelSize(parent context.Context, model string) int64 {
+	timeout := h.cfg.ShowTimeout
+	if timeout <= 0 {
+		timeout = defaultShowTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	show, err := h.showCache.Get(ctx, model)
+	if err != nil {
+		return 0
+	}
+	return show.Size
+}
+
+// vramInsufficient is the rejection for a request the VRAM gate held back:
+// 503 when its model didn't fit in time, or canceled when the client went
+// away while it waited.
+func (h *Handler) vramInsufficient(model string, err error) rejection {
+	if !errors.Is(err, supervisor.ErrVRAMInsufficient) {
+		return rejection{
+			code:   http.StatusServiceUnavailable,
+			status: supervisor.StatusCanceled,
+			reason: storage.ReasonVRAMInsufficient,
+			msg:    "request ended while waiting for free VRAM to load model " + model,
+		}
+	}
+	return rejection{
+		code:       http.StatusServiceUnavailable,
+		status:     supervisor.StatusVRAMInsufficient,
+		reason:     storage.ReasonVRAMInsufficient,
+		msg:        "not enough free VRAM to load model " + model + "; try again later",
+		retryAfter: h.cfg.ResidencyPollInterval,
+	}
+}
+
+// vramCtxCap returns, with VRAM_CTX_CAP_ENABLED, the largest context model's
+// KV cache can take in the VRAM left free beside the other loaded models and
+// the model's weights, but no less than MIN_CTX; 0 when it can't be worked
+// out. The weights are show's size, or the VRAM the model held when last
+// seen loaded less the KV cache of the context it had then.
+func (h *Handler) vramCtxCap(model string, show ollama.ShowResponse) int {
+	if !h.cfg.VRAMCtxCapEnabled {
+		return 0
+	}
+	perToken, ok := show.KVBytesPerToken(h.cfg.KVCacheType.BytesPerElement())
+	if !ok {
+		return 0
+	}
+	others, ok := h.residency.LoadedExcept(model, 3*h.cfg.ResidencyPollInterval)
+	if !ok {
+		return 0
+	}
+	weights := show.Size
+	if weights <= 0 {
+		seen, ok := h.residency.Seen(model)
+		if !ok {
+			return 0
+		}
+		weights = seen.SizeVRAM - int64(perToken*float64(seen.ContextLength))
+	}
+	free := h.cfg.IdleEvictVRAMBytes - h.cfg.VRAMHeadroomBytes - others - weights
+	return max(int(float64(free)/perToken), h.cfg.MinCtx)
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BreakerConfig holds configuration for BreakerStore.
+type BreakerConfig struct {
+	Threshold int           // STORAGE_BREAKER_THRESHOLD, consecutive write errors before pausing
+	Cooldown  time.Duration // STORAGE_BREAKER_COOLDOWN, how long writes stay paused between probes
+}
+
+// BreakerStore stops writing to the underlying Store after Threshold
+// consecutive Insert/Update errors (disk full, read-only file, ...), so a
+// broken database doesn't fail and log on every proxied request. While it
+// is degraded, writes are skipped and report success; after each Cooldown
+// the next Insert is let through as a probe, and a successful one resumes
+// writes. Updates are never used as probes, since updating a row that was
+// never inserted succeeds even on a broken database. Rows inserted just
+// before the breaker opened may keep their in-flight status. Reads always
+// go to the underlying store. It is safe for concurrent use.
+type BreakerStore struct {
+	Store
+	cfg      BreakerConfig
+	logger   *slog.Logger
+	onChange func(degraded bool) // called when writes are paused or resume
+	now      func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	degraded  bool
+	nextProbe time.Time
+	skipped   int
+}
+
+// NewBreakerStore wraps inner with a write breaker. onChange, if non-nil,
+// is called whenever the degraded state changes.
+func NewBreakerStore(inner Store, cfg BreakerConfig, logger *slog.Logger, onChange func(degraded bool)) *BreakerStore {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.Threshold < 1 {
+		cfg.Threshold = 1
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	return &BreakerStore{
+		Store:    inner,
+		cfg:      cfg,
+		logger:   logger,
+		onChange: onChange,
+		now:      time.Now,
+	}
+}
+
+// Insert inserts req unless writes are paused; after the cooldown it probes
+// whether the underlying store has recovered.
+func (s *BreakerStore) Insert(req *Request) error {
+	return s.write(true, func() error { return s.Store.Insert(req) })
+}
+
+// Update updates the request unless writes are paused.
+func (s *BreakerStore) Update(id string, upd RequestUpdate) error {
+	return s.write(false, func() error { return s.Store.Update(id, upd) })
+}
+
+// Degraded reports whether writes are currently paused. A nil store is
+// never degraded.
+func (s *BreakerStore) Degraded() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.degraded
+}
+
+func (s *BreakerStore) write(canProbe bool, fn func() error) error {
+	s.mu.Lock()
+	if s.degraded {
+		if !canProbe || s.now().Before(s.nextProbe) {
+			s.skipped++
+			s.mu.Unlock()
+			return nil
+		}
+		// One probe per cooldown; concurrent writes keep being skipped.
+		s.nextProbe = s.now().Add(s.cfg.Cooldown)
+	}
+	s.mu.Unlock()
+
+	err := fn()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failures = 0
+		if s.degraded {
+			s.degraded = false
+			s.logger.Info("storage writes resumed", "skipped", s.skipped)
+			s.skipped = 0
+			if s.onChange != nil {
+				s.onChange(false)
+			}
+		}
+		return nil
+	}
+	if s.degraded {
+		s.skipped++
+		s.logger.Debug("storage probe write failed", "err", err)
+		return nil
+	}
+	s.failures++
+	if s.failures < s.cfg.Threshold {
+		return err
+	}
+	s.degraded = true
+	s.nextProbe = s.now().Add(s.cfg.Cooldown)
+	s.logger.Warn("storage writes failing; pausing them", "err", err, "failures", s.failures, "retry_in", s.cfg.Cooldown)
+	if s.onChange != nil {
+		s.onChange(true)
+	}
+	return err
+}
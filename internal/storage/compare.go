@@ -0,0 +1,91 @@
+package storage
+
+import "sort"
+
+// ModelComparison holds side-by-side efficiency stats for one model.
+type ModelComparison struct {
+	Model             string  `json:"model"`
+	RequestCount      int     `json:"request_count"`
+	SuccessRate       float64 `json:"success_rate"`
+	DurationP95Ms     int     `json:"duration_p95_ms"`
+	AvgGenTokPerS     float64 `json:"avg_gen_tok_per_s"`
+	AvgCtxUtilization float64 `json:"avg_ctx_utilization"`
+	RetryRate         float64 `json:"retry_rate"`
+}
+
+// compareAccumulator aggregates ModelComparison fields in a single pass over
+// request rows. It is shared by both stores so the definitions stay identical.
+type compareAccumulator struct {
+	count, success, retries int
+	durations               []int
+	tokPerSSum              float64
+	tokPerSN                int
+	utilSum                 float64
+	utilN                   int
+}
+
+func (a *compareAccumulator) add(req *Request) {
+	a.count++
+	a.retries += req.RetryCount
+	if req.Status != StatusInFlight {
+		a.durations = append(a.durations, req.DurationMs)
+	}
+	if req.Status != StatusSuccess {
+		return
+	}
+	a.success++
+
+	// Prefer Ollama's own eval time; fall back to wall-clock duration.
+	if req.CompletionTokens > 0 {
+		ms := req.UpstreamEvalMs
+		if ms <= 0 {
+			ms = req.DurationMs
+		}
+		if ms > 0 {
+			a.tokPerSSum += float64(req.CompletionTokens) * 1000 / float64(ms)
+			a.tokPerSN++
+		}
+	}
+	if req.CtxSelected > 0 {
+		a.utilSum += float64(req.PromptTokens+req.CompletionTokens) / float64(req.CtxSelected)
+		a.utilN++
+	}
+}
+
+func (a *compareAccumulator) result(model string) ModelComparison {
+	mc := ModelComparison{Model: model, RequestCount: a.count}
+	if a.count == 0 {
+		return mc
+	}
+	mc.SuccessRate = float64(a.success) / float64(a.count)
+	mc.RetryRate = float64(a.retries) / float64(a.count)
+	if len(a.durations) > 0 {
+		sort.Ints(a.durations)
+		idx := int(float64(len(a.durations)) * 0.95)
+		if idx >= len(a.durations) {
+			idx = len(a.durations) - 1
+		}
+		mc.DurationP95Ms = a.durations[idx]
+	}
+	if a.tokPerSN > 0 {
+		mc.AvgGenTokPerS = a.tokPerSSum / float64(a.tokPerSN)
+	}
+	if a.utilN > 0 {
+		mc.AvgCtxUtilization = a.utilSum / float64(a.utilN)
+	}
+	return mc
+}
+
+// compareResults returns one ModelComparison per requested model, in request
+// order; models without data are included with zero counts.
+func compareResults(models []string, accs map[string]*compareAccumulator) []ModelComparison {
+	out := make([]ModelComparison, 0, len(models))
+	for _, m := range models {
+		acc := accs[m]
+		if acc == nil {
+			acc = &compareAccumulator{}
+		}
+		out = append(out, acc.result(m))
+	}
+	return out
+}
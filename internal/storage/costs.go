@@ -0,0 +1,55 @@
+package storage
+
+import "sort"
+
+// CostStat sums what the requests in a window for one model, or with one
+// value of a tag key, cost.
+type CostStat struct {
+	Key              string  `json:"key"` // model, or tag value
+	RequestCount     int     `json:"request_count"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+	// EstimatedCount is how many of the requests were priced from estimates.
+	EstimatedCount int `json:"estimated_count"`
+}
+
+// addCost adds req to the stat for its model, or for its value of tag key
+// when key is not empty, if it has one.
+func addCost(accs map[string]*CostStat, key string, req *Request) {
+	group := req.Model
+	if key != "" {
+		value, ok := ParseTags(req.Tags)[key]
+		if !ok {
+			return
+		}
+		group = value
+	}
+	st := accs[group]
+	if st == nil {
+		st = &CostStat{Key: group}
+		accs[group] = st
+	}
+	st.RequestCount++
+	st.PromptTokens += req.PromptTokens
+	st.CompletionTokens += req.CompletionTokens
+	st.Cost += req.Cost
+	if req.CostEstimated {
+		st.EstimatedCount++
+	}
+}
+
+// costResults returns one CostStat per group, costliest first.
+func costResults(accs map[string]*CostStat) []CostStat {
+	out := make([]CostStat, 0, len(accs))
+	for _, st := range accs {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Cost != out[j].Cost {
+			return out[i].Cost > out[j].Cost
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
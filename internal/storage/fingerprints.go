@@ -0,0 +1,54 @@
+package storage
+
+import "sort"
+
+// FingerprintStat counts the requests in a window that carried one
+// fingerprint. A fingerprint covers the model, so each belongs to one.
+type FingerprintStat struct {
+	Fingerprint  string `json:"fingerprint"`
+	Model        string `json:"model"`
+	RequestCount int    `json:"request_count"`
+	FirstSeen    int64  `json:"first_seen"` // unix ms
+	LastSeen     int64  `json:"last_seen"`  // unix ms
+}
+
+type fingerprintKey struct {
+	fingerprint, model string
+}
+
+// addFingerprint counts req under its fingerprint, if it has one.
+func addFingerprint(accs map[fingerprintKey]*FingerprintStat, req *Request) {
+	if req.Fingerprint == "" {
+		return
+	}
+	key := fingerprintKey{req.Fingerprint, req.Model}
+	st := accs[key]
+	if st == nil {
+		st = &FingerprintStat{Fingerprint: req.Fingerprint, Model: req.Model, FirstSeen: req.TSStart, LastSeen: req.TSStart}
+		accs[key] = st
+	}
+	st.RequestCount++
+	st.FirstSeen = min(st.FirstSeen, req.TSStart)
+	st.LastSeen = max(st.LastSeen, req.TSStart)
+}
+
+// fingerprintResults returns up to limit of the fingerprints counted more
+// than once, most repeated first, in the order SQLite's query returns them.
+func fingerprintResults(accs map[fingerprintKey]*FingerprintStat, limit int) []FingerprintStat {
+	out := []FingerprintStat{}
+	for _, st := range accs {
+		if st.RequestCount > 1 {
+			out = append(out, *st)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].RequestCount != out[j].RequestCount {
+			return out[i].RequestCount > out[j].RequestCount
+		}
+		return out[i].Fingerprint < out[j].Fingerprint
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package storage
+
+// LatencyBreakdown splits a request's client-observed duration into phases.
+// The phases always sum to TotalMs: each is capped at what the earlier ones
+// left over, and time no phase accounts for is reported as OtherMs.
+type LatencyBreakdown struct {
+	TotalMs      int `json:"total_ms"`
+	EstimationMs int `json:"estimation_ms"` // proxy reading and sizing the body, incl. /api/show
+	LoadWaitMs   int `json:"load_wait_ms"`  // held while another request loaded the model
+	QueueMs      int `json:"queue_ms"`      // inside Ollama but not loading or computing (waiting for a runner)
+	LoadMs       int `json:"load_ms"`
+	PromptEvalMs int `json:"prompt_eval_ms"`
+	GenerationMs int `json:"generation_ms"`
+	NetworkMs    int `json:"network_ms"` // proxy-observed upstream time beyond Ollama's total_duration
+	TapMs        int `json:"tap_ms"`     // after the upstream's last byte until the response was finished
+	OtherMs      int `json:"other_ms"`
+}
+
+// LatencyBreakdown reconciles the request's timing fields, or returns nil
+// while the request has no duration yet. Phases that weren't recorded (e.g.
+// without a tracker, or when the upstream never finished) count toward
+// OtherMs.
+func (r *Request) LatencyBreakdown() *LatencyBreakdown {
+	if r.DurationMs <= 0 {
+		return nil
+	}
+	b := &LatencyBreakdown{TotalMs: r.DurationMs}
+	remaining := r.DurationMs
+	take := func(ms int) int {
+		ms = min(max(ms, 0), remaining)
+		remaining -= ms
+		return ms
+	}
+
+	b.EstimationMs = take(r.EstimateMs)
+	b.LoadWaitMs = take(r.LoadWaitMs)
+	b.LoadMs = take(r.UpstreamLoadMs)
+	b.PromptEvalMs = take(r.UpstreamPromptEvalMs)
+	b.GenerationMs = take(r.UpstreamEvalMs)
+	if r.UpstreamTotalMs > 0 {
+		b.QueueMs = take(r.UpstreamTotalMs - r.UpstreamLoadMs - r.UpstreamPromptEvalMs - r.UpstreamEvalMs)
+	}
+	if r.UpstreamDoneMs > 0 && r.UpstreamDoneMs >= r.ForwardMs {
+		upstreamMs := r.UpstreamDoneMs - r.ForwardMs
+		if r.UpstreamTotalMs > 0 {
+			b.NetworkMs = take(upstreamMs - r.UpstreamTotalMs)
+		} else {
+			// Without total_duration, queueing in Ollama can't be told apart from the network.
+			b.NetworkMs = take(upstreamMs - r.UpstreamLoadMs - r.UpstreamPromptEvalMs - r.UpstreamEvalMs)
+		}
+	}
+	if r.UpstreamDoneMs > 0 {
+		b.TapMs = take(r.DurationMs - r.UpstreamDoneMs)
+	}
+	b.OtherMs = remaining
+	return b
+}
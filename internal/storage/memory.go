@@ -15,6 +15,8 @@ type MemoryStore struct {
 	maxRows  int
 	head     int // next write position
 	count    int // actual count (may be less than len(requests) initially)
+
+	snapshots []MetricSnapshot // oldest first
 }
 
 // NewMemoryStore creates a new in-memory store.
@@ -60,8 +62,12 @@ func (s *MemoryStore) Update(id string, upd RequestUpdate) error {
 		return nil // not found is not an error
 	}
 
-	req := &s.requests[idx]
+	applyUpdate(&s.requests[idx], upd)
+	return nil
+}
 
+// applyUpdate copies the fields set in upd onto req.
+func applyUpdate(req *Request, upd RequestUpdate) {
 	if upd.TSEnd != nil {
 		req.TSEnd = upd.TSEnd
 	}
@@ -107,6 +113,15 @@ func (s *MemoryStore) Update(id string, upd RequestUpdate) error {
 	if upd.UpstreamEvalMs != nil {
 		req.UpstreamEvalMs = *upd.UpstreamEvalMs
 	}
+	if upd.EstimateMs != nil {
+		req.EstimateMs = *upd.EstimateMs
+	}
+	if upd.ForwardMs != nil {
+		req.ForwardMs = *upd.ForwardMs
+	}
+	if upd.UpstreamDoneMs != nil {
+		req.UpstreamDoneMs = *upd.UpstreamDoneMs
+	}
 	if upd.ClientOutBytes != nil {
 		req.ClientOutBytes = *upd.ClientOutBytes
 	}
@@ -119,14 +134,49 @@ func (s *MemoryStore) Update(id string, upd RequestUpdate) error {
 	if upd.RetryCount != nil {
 		req.RetryCount = *upd.RetryCount
 	}
+	if upd.LoadingRetries != nil {
+		req.LoadingRetries = *upd.LoadingRetries
+	}
+	if upd.LoopRetry != nil {
+		req.LoopRetry = *upd.LoopRetry
+	}
+	if upd.FallbackModel != nil {
+		req.FallbackModel = *upd.FallbackModel
+	}
+	if upd.LoadWaitMs != nil {
+		req.LoadWaitMs = *upd.LoadWaitMs
+	}
 	if upd.UpstreamHTTPStatus != nil {
 		req.UpstreamHTTPStatus = *upd.UpstreamHTTPStatus
 	}
 	if upd.ErrorClass != nil {
 		req.ErrorClass = *upd.ErrorClass
 	}
-
-	return nil
+	if upd.ThinkVerdict != nil {
+		req.ThinkVerdict = *upd.ThinkVerdict
+	}
+	if upd.ThinkSource != nil {
+		req.ThinkSource = *upd.ThinkSource
+	}
+	if upd.ThinkValue != nil {
+		req.ThinkValue = *upd.ThinkValue
+	}
+	if upd.ImagesDropped != nil {
+		req.ImagesDropped = *upd.ImagesDropped
+	}
+	if upd.TruncationSuspected != nil {
+		req.TruncationSuspected = *upd.TruncationSuspected
+	}
+	if upd.Cost != nil {
+		req.Cost = *upd.Cost
+	}
+	if upd.CostEstimated != nil {
+		req.CostEstimated = *upd.CostEstimated
+	}
+	if upd.Shadow != nil {
+		shadow := *upd.Shadow
+		req.Shadow = &shadow
+	}
 }
 
 // GetByID retrieves a single request.
@@ -168,6 +218,12 @@ func (s *MemoryStore) List(opts ListOptions) ([]Request, error) {
 		if opts.Reason != nil && req.Reason != *opts.Reason {
 			continue
 		}
+		if opts.Tag != "" && !HasTag(req.Tags, opts.Tag) {
+			continue
+		}
+		if opts.Shadow && req.Shadow == nil {
+			continue
+		}
 		if cutoff > 0 && req.TSStart < cutoff {
 			continue
 		}
@@ -216,6 +272,12 @@ func (s *MemoryStore) Overview(window time.Duration) (*Overview, error) {
 		o.TotalBytes += req.ClientOutBytes
 		o.TotalTokens += req.CompletionTokens
 		o.Retries += req.RetryCount
+		if req.UpstreamHTTPStatus > 0 {
+			if o.StatusCodes == nil {
+				o.StatusCodes = make(map[int]int)
+			}
+			o.StatusCodes[req.UpstreamHTTPStatus]++
+		}
 
 		switch req.Reason {
 		case ReasonTimeoutTTFB, ReasonTimeoutStall, ReasonTimeoutHard:
@@ -404,6 +466,172 @@ func (s *MemoryStore) InFlightCount() (int, error) {
 	return count, nil
 }
 
+// LatencyCompliance counts completed requests in the window and the successful
+// ones that finished within threshold.
+func (s *MemoryStore) LatencyCompliance(window, threshold time.Duration) (int, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	thresholdMs := threshold.Milliseconds()
+
+	good, total := 0, 0
+	for i := 0; i < s.count; i++ {
+		idx := (s.head - 1 - i + s.maxRows) % s.maxRows
+		req := s.requests[idx]
+		if req.TSStart < cutoff || req.Status == StatusInFlight {
+			continue
+		}
+		total++
+		if req.Status == StatusSuccess && int64(req.DurationMs) <= thresholdMs {
+			good++
+		}
+	}
+	return good, total, nil
+}
+
+// CompareModels returns side-by-side stats for models in one pass over the buffer.
+func (s *MemoryStore) CompareModels(window time.Duration, models []string) ([]ModelComparison, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	accs := make(map[string]*compareAccumulator, len(models))
+	for _, m := range models {
+		accs[m] = &compareAccumulator{}
+	}
+
+	for i := 0; i < s.count; i++ {
+		idx := (s.head - 1 - i + s.maxRows) % s.maxRows
+		req := &s.requests[idx]
+		if req.TSStart < cutoff {
+			continue
+		}
+		if acc := accs[req.Model]; acc != nil {
+			acc.add(req)
+		}
+	}
+	return compareResults(models, accs), nil
+}
+
+// TagStats groups requests in the window by their value for tag key.
+func (s *MemoryStore) TagStats(window time.Duration, key string) ([]TagStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	accs := make(map[string]*tagAccumulator)
+	for i := 0; i < s.count; i++ {
+		idx := (s.head - 1 - i + s.maxRows) % s.maxRows
+		req := &s.requests[idx]
+		if req.TSStart < cutoff {
+			continue
+		}
+		addTagStat(accs, key, req)
+	}
+	return tagResults(accs), nil
+}
+
+// TopFingerprints counts the fingerprints of requests in the window.
+func (s *MemoryStore) TopFingerprints(window time.Duration, limit int) ([]FingerprintStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	accs := make(map[fingerprintKey]*FingerprintStat)
+	for i := 0; i < s.count; i++ {
+		idx := (s.head - 1 - i + s.maxRows) % s.maxRows
+		req := &s.requests[idx]
+		if req.TSStart < cutoff {
+			continue
+		}
+		addFingerprint(accs, req)
+	}
+	return fingerprintResults(accs, limit), nil
+}
+
+// CostStats sums the cost of requests in the window per model or tag value.
+func (s *MemoryStore) CostStats(window time.Duration, key string) ([]CostStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	accs := make(map[string]*CostStat)
+	for i := 0; i < s.count; i++ {
+		idx := (s.head - 1 - i + s.maxRows) % s.maxRows
+		req := &s.requests[idx]
+		if req.TSStart < cutoff {
+			continue
+		}
+		addCost(accs, key, req)
+	}
+	return costResults(accs), nil
+}
+
+// UsagePattern buckets requests in the window by weekday and hour of day.
+func (s *MemoryStore) UsagePattern(window, utcOffset time.Duration) (*UsagePattern, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	var acc usageAccumulator
+	for i := 0; i < s.count; i++ {
+		idx := (s.head - 1 - i + s.maxRows) % s.maxRows
+		req := &s.requests[idx]
+		if req.TSStart < cutoff {
+			continue
+		}
+		acc.addTime(req.TSStart, utcOffset, req.DurationMs, req.Status != StatusInFlight)
+	}
+	return acc.result(), nil
+}
+
+// InsertMetricSnapshot stores one periodic metrics snapshot.
+func (s *MemoryStore) InsertMetricSnapshot(snap MetricSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap.Values = filterSnapshotValues(snap.Values, nil)
+	s.snapshots = append(s.snapshots, snap)
+	return nil
+}
+
+// MetricSnapshots returns the snapshots taken in the window, oldest first.
+func (s *MemoryStore) MetricSnapshots(window time.Duration, names []string) ([]MetricSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	out := []MetricSnapshot{}
+	for _, snap := range s.snapshots {
+		if snap.TS < cutoff {
+			continue
+		}
+		// Like SQLite, snapshots holding none of the requested names are omitted.
+		if values := filterSnapshotValues(snap.Values, names); len(values) > 0 {
+			out = append(out, MetricSnapshot{TS: snap.TS, Values: values})
+		}
+	}
+	return out, nil
+}
+
+// PruneMetricSnapshots deletes snapshots taken before the cutoff.
+func (s *MemoryStore) PruneMetricSnapshots(before int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for n < len(s.snapshots) && s.snapshots[n].TS < before {
+		n++
+	}
+	s.snapshots = append([]MetricSnapshot(nil), s.snapshots[n:]...)
+	return n, nil
+}
+
+// Backup is not supported by the memory store.
+func (s *MemoryStore) Backup(path string) (int64, error) {
+	return 0, ErrBackupUnsupported
+}
+
 // Close is a no-op for memory store.
 func (s *MemoryStore) Close() error {
 	return nil
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// maxSampledPending bounds how many sampled-out requests are held while in
+// flight; beyond it new requests are persisted normally.
+const maxSampledPending = 10000
+
+// maxSampledAge is how long a sampled-out request is held waiting for its
+// final status (which it may never get, e.g. without a tracker or after an
+// early exit) before it is persisted as it is. Later updates then reach the
+// underlying store like any other request's.
+const maxSampledAge = 30 * time.Minute
+
+// SampledStore persists only a fraction of successful requests to the
+// underlying Store (STORAGE_SAMPLE_RATE), to keep per-request writes off a
+// very busy proxy. Whether a request is kept is decided when it is
+// inserted, so kept requests stay representative. The others are held in
+// memory until their final status arrives: errors, timeouts, loops and
+// cancellations are then persisted in full, as are successes suspected of
+// prompt truncation, and other successes are dropped.
+// Held requests are invisible to reads until then, and updates arriving
+// after a dropped request finished are ignored by the underlying store.
+// Requests held longer than maxSampledAge, and all of them on Close, are
+// persisted as they are. It is safe for concurrent use.
+type SampledStore struct {
+	Store
+	rate      float64
+	onDropped func() // called for each success that isn't persisted
+	sample    func() float64
+	now       func() time.Time
+	mu        sync.Mutex
+	pending   map[string]*heldRequest
+	lastSweep time.Time
+}
+
+type heldRequest struct {
+	req   Request
+	since time.Time
+}
+
+// NewSampledStore wraps inner so successful requests are persisted with
+// probability rate. onDropped, if non-nil, is called for every success that
+// was sampled out.
+func NewSampledStore(inner Store, rate float64, onDropped func()) *SampledStore {
+	return &SampledStore{
+		Store:     inner,
+		rate:      rate,
+		onDropped: onDropped,
+		sample:    rand.Float64,
+		now:       time.Now,
+		pending:   make(map[string]*heldRequest),
+	}
+}
+
+// Insert persists req now if it is sampled in, or holds it until it finishes.
+func (s *SampledStore) Insert(req *Request) error {
+	if s.sample() < s.rate {
+		return s.Store.Insert(req)
+	}
+	now := s.now()
+	s.mu.Lock()
+	var stale []*Request
+	if now.Sub(s.lastSweep) >= time.Minute {
+		stale = s.takeLocked(now.Add(-maxSampledAge))
+		s.lastSweep = now
+	}
+	full := len(s.pending) >= maxSampledPending
+	if !full {
+		s.pending[req.ID] = &heldRequest{req: *req, since: now}
+	}
+	s.mu.Unlock()
+
+	err := s.persist(stale)
+	if full {
+		return s.Store.Insert(req)
+	}
+	return err
+}
+
+// takeLocked removes and returns the held requests held since before cutoff;
+// a zero cutoff takes them all. s.mu must be held.
+func (s *SampledStore) takeLocked(cutoff time.Time) []*Request {
+	var out []*Request
+	for id, h := range s.pending {
+		if cutoff.IsZero() || h.since.Before(cutoff) {
+			out = append(out, &h.req)
+			delete(s.pending, id)
+		}
+	}
+	return out
+}
+
+// persist inserts reqs into the underlying store, returning the first error.
+func (s *SampledStore) persist(reqs []*Request) error {
+	var first error
+	for _, req := range reqs {
+		if err := s.Store.Insert(req); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Update applies upd to a held request, persisting or dropping it once upd
+// carries its final status; other requests are updated in the underlying store.
+func (s *SampledStore) Update(id string, upd RequestUpdate) error {
+	s.mu.Lock()
+	h, ok := s.pending[id]
+	if !ok {
+		s.mu.Unlock()
+		return s.Store.Update(id, upd)
+	}
+	req := &h.req
+	applyUpdate(req, upd)
+	if upd.Status == nil || *upd.Status == StatusInFlight {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.pending, id)
+	s.mu.Unlock()
+
+	if req.Status != StatusSuccess || req.TruncationSuspected {
+		return s.Store.Insert(req)
+	}
+	if s.onDropped != nil {
+		s.onDropped()
+	}
+	return nil
+}
+
+// InFlightCount counts in-flight requests in the underlying store and those
+// held here.
+func (s *SampledStore) InFlightCount() (int, error) {
+	n, err := s.Store.InFlightCount()
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	for _, h := range s.pending {
+		if h.req.Status == StatusInFlight {
+			n++
+		}
+	}
+	s.mu.Unlock()
+	return n, nil
+}
+
+// Close persists every held request, so none is lost on shutdown, then closes
+// the underlying store.
+func (s *SampledStore) Close() error {
+	s.mu.Lock()
+	held := s.takeLocked(time.Time{})
+	s.mu.Unlock()
+	err := s.persist(held)
+	if cerr := s.Store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+package storage
+
+// MetricSnapshot is one periodic snapshot of aggregate proxy metrics, kept for
+// trend charts independent of Prometheus retention.
+type MetricSnapshot struct {
+	TS     int64              `json:"ts"` // Unix ms
+	Values map[string]float64 `json:"values"`
+}
+
+// filterSnapshotValues returns values restricted to names (all when names is empty).
+func filterSnapshotValues(values map[string]float64, names []string) map[string]float64 {
+	out := make(map[string]float64, len(values))
+	if len(names) == 0 {
+		for k, v := range values {
+			out[k] = v
+		}
+		return out
+	}
+	for _, n := range names {
+		if v, ok := values[n]; ok {
+			out[n] = v
+		}
+	}
+	return out
+}
@@ -4,14 +4,18 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"ollama-auto-ctx/internal/calibration"
+
 	_ "modernc.org/sqlite" // Pure Go SQLite driver (no CGO required)
 )
 
@@ -62,12 +66,82 @@ CREATE INDEX IF NOT EXISTS idx_requests_model_ts ON requests(model, ts_start);
 CREATE INDEX IF NOT EXISTS idx_requests_status_ts ON requests(status, ts_start);
 `
 
+// migrations add columns and tables introduced after the initial schema. Each
+// statement is applied on every open; "duplicate column" errors mean it already ran.
+var migrations = []string{
+	`ALTER TABLE requests ADD COLUMN think_verdict TEXT`,
+	`ALTER TABLE requests ADD COLUMN think_source TEXT`,
+	`ALTER TABLE requests ADD COLUMN options_json TEXT`,
+	`ALTER TABLE requests ADD COLUMN stripped_options TEXT`,
+	`ALTER TABLE requests ADD COLUMN family TEXT`,
+	`ALTER TABLE requests ADD COLUMN invalid_images INTEGER`,
+	`ALTER TABLE requests ADD COLUMN tags TEXT`,
+	`ALTER TABLE requests ADD COLUMN injected_seed INTEGER`,
+	`ALTER TABLE requests ADD COLUMN shadow_json TEXT`,
+	`ALTER TABLE requests ADD COLUMN think_value TEXT`,
+	`ALTER TABLE requests ADD COLUMN unsupervised INTEGER`,
+	`ALTER TABLE requests ADD COLUMN images_dropped INTEGER`,
+	`ALTER TABLE requests ADD COLUMN estimate_ms INTEGER`,
+	`ALTER TABLE requests ADD COLUMN forward_ms INTEGER`,
+	`ALTER TABLE requests ADD COLUMN upstream_done_ms INTEGER`,
+	`ALTER TABLE requests ADD COLUMN loading_retries INTEGER`,
+	`ALTER TABLE requests ADD COLUMN truncation_suspected INTEGER`,
+	`ALTER TABLE requests ADD COLUMN loop_retry TEXT`,
+	`ALTER TABLE requests ADD COLUMN fallback_model TEXT`,
+	`ALTER TABLE requests ADD COLUMN load_wait_ms INTEGER`,
+	`ALTER TABLE requests ADD COLUMN timeout_profile TEXT`,
+	`ALTER TABLE requests ADD COLUMN prompt_scan TEXT`,
+	`ALTER TABLE requests ADD COLUMN fingerprint TEXT`,
+	`ALTER TABLE requests ADD COLUMN cost REAL`,
+	`ALTER TABLE requests ADD COLUMN cost_estimated INTEGER`,
+	`CREATE TABLE IF NOT EXISTS metric_snapshots (
+		ts INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value REAL NOT NULL,
+		PRIMARY KEY (ts, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS calibration (
+		model TEXT PRIMARY KEY,
+		tokens_per_byte REAL NOT NULL,
+		fixed_overhead REAL NOT NULL,
+		per_message_overhead REAL NOT NULL,
+		safe_max_ctx INTEGER NOT NULL DEFAULT 0,
+		samples INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS tag_quota_usage (
+		tag TEXT NOT NULL,
+		window_start INTEGER NOT NULL,
+		tokens INTEGER NOT NULL,
+		requests INTEGER NOT NULL,
+		PRIMARY KEY (tag, window_start)
+	)`,
+	`ALTER TABLE calibration ADD COLUMN eval_tokens_per_sec REAL NOT NULL DEFAULT 0`,
+}
+
+// requestColumns is the column list shared by Insert and the SELECTs that feed scanRequest.
+const requestColumns = `id, ts_start, ts_end, status, reason, model, endpoint,
+	messages_count, system_chars, user_chars, assistant_chars,
+	tools_count, tool_choice, stream_requested,
+	ctx_est, ctx_selected, ctx_bucket, output_budget,
+	prompt_tokens, completion_tokens,
+	duration_ms, ttfb_ms, upstream_total_ms, upstream_load_ms,
+	upstream_prompt_eval_ms, upstream_eval_ms,
+	client_in_bytes, client_out_bytes, upstream_in_bytes, upstream_out_bytes,
+	retry_count, upstream_http_status, error_class,
+	think_verdict, think_source, options_json, stripped_options, family,
+	invalid_images, tags, injected_seed, shadow_json, think_value, unsupervised,
+	images_dropped, estimate_ms, forward_ms, upstream_done_ms, loading_retries, truncation_suspected,
+	loop_retry, fallback_model, load_wait_ms, timeout_profile, prompt_scan, fingerprint,
+	cost, cost_estimated`
+
 // SQLiteStore implements Store using SQLite with WAL mode.
 type SQLiteStore struct {
 	db         *sql.DB
 	maxRows    int
 	pruneMu    sync.Mutex
 	pruneOnce  bool
+	pruneWG    sync.WaitGroup
 	logger     *slog.Logger
 }
 
@@ -99,6 +173,10 @@ func NewSQLiteStore(path string, maxRows int, logger *slog.Logger) (*SQLiteStore
 		db.Close()
 		return nil, fmt.Errorf("failed to create schema: %w", err)
 	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
 
 	if logger == nil {
 		logger = slog.Default()
@@ -114,17 +192,8 @@ func NewSQLiteStore(path string, maxRows int, logger *slog.Logger) (*SQLiteStore
 // Insert creates a new request record.
 func (s *SQLiteStore) Insert(req *Request) error {
 	_, err := s.db.Exec(`
-		INSERT INTO requests (
-			id, ts_start, ts_end, status, reason, model, endpoint,
-			messages_count, system_chars, user_chars, assistant_chars,
-			tools_count, tool_choice, stream_requested,
-			ctx_est, ctx_selected, ctx_bucket, output_budget,
-			prompt_tokens, completion_tokens,
-			duration_ms, ttfb_ms, upstream_total_ms, upstream_load_ms,
-			upstream_prompt_eval_ms, upstream_eval_ms,
-			client_in_bytes, client_out_bytes, upstream_in_bytes, upstream_out_bytes,
-			retry_count, upstream_http_status, error_class
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO requests (` + requestColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		req.ID, req.TSStart, req.TSEnd, req.Status, req.Reason, req.Model, req.Endpoint,
 		req.MessagesCount, req.SystemChars, req.UserChars, req.AssistantChars,
@@ -135,13 +204,22 @@ func (s *SQLiteStore) Insert(req *Request) error {
 		req.UpstreamPromptEvalMs, req.UpstreamEvalMs,
 		req.ClientInBytes, req.ClientOutBytes, req.UpstreamInBytes, req.UpstreamOutBytes,
 		req.RetryCount, req.UpstreamHTTPStatus, req.ErrorClass,
+		req.ThinkVerdict, req.ThinkSource, req.OptionsJSON, req.StrippedOptions, req.Family,
+		req.InvalidImages, req.Tags, req.InjectedSeed, shadowJSON(req.Shadow), req.ThinkValue,
+		boolToInt(req.Unsupervised), req.ImagesDropped, req.EstimateMs, req.ForwardMs, req.UpstreamDoneMs,
+		req.LoadingRetries, boolToInt(req.TruncationSuspected), req.LoopRetry, req.FallbackModel, req.LoadWaitMs, req.TimeoutProfile, req.PromptScan, req.Fingerprint,
+		req.Cost, boolToInt(req.CostEstimated),
 	)
 	if err != nil {
 		return fmt.Errorf("insert request: %w", err)
 	}
 
 	// Trigger pruning check (best effort, non-blocking)
-	go s.maybePrune()
+	s.pruneWG.Add(1)
+	go func() {
+		defer s.pruneWG.Done()
+		s.maybePrune()
+	}()
 
 	return nil
 }
@@ -212,6 +290,18 @@ func (s *SQLiteStore) Update(id string, upd RequestUpdate) error {
 		sets = append(sets, "upstream_eval_ms = ?")
 		args = append(args, *upd.UpstreamEvalMs)
 	}
+	if upd.EstimateMs != nil {
+		sets = append(sets, "estimate_ms = ?")
+		args = append(args, *upd.EstimateMs)
+	}
+	if upd.ForwardMs != nil {
+		sets = append(sets, "forward_ms = ?")
+		args = append(args, *upd.ForwardMs)
+	}
+	if upd.UpstreamDoneMs != nil {
+		sets = append(sets, "upstream_done_ms = ?")
+		args = append(args, *upd.UpstreamDoneMs)
+	}
 	if upd.ClientOutBytes != nil {
 		sets = append(sets, "client_out_bytes = ?")
 		args = append(args, *upd.ClientOutBytes)
@@ -228,6 +318,22 @@ func (s *SQLiteStore) Update(id string, upd RequestUpdate) error {
 		sets = append(sets, "retry_count = ?")
 		args = append(args, *upd.RetryCount)
 	}
+	if upd.LoadingRetries != nil {
+		sets = append(sets, "loading_retries = ?")
+		args = append(args, *upd.LoadingRetries)
+	}
+	if upd.LoopRetry != nil {
+		sets = append(sets, "loop_retry = ?")
+		args = append(args, *upd.LoopRetry)
+	}
+	if upd.FallbackModel != nil {
+		sets = append(sets, "fallback_model = ?")
+		args = append(args, *upd.FallbackModel)
+	}
+	if upd.LoadWaitMs != nil {
+		sets = append(sets, "load_wait_ms = ?")
+		args = append(args, *upd.LoadWaitMs)
+	}
 	if upd.UpstreamHTTPStatus != nil {
 		sets = append(sets, "upstream_http_status = ?")
 		args = append(args, *upd.UpstreamHTTPStatus)
@@ -236,6 +342,38 @@ func (s *SQLiteStore) Update(id string, upd RequestUpdate) error {
 		sets = append(sets, "error_class = ?")
 		args = append(args, *upd.ErrorClass)
 	}
+	if upd.ThinkVerdict != nil {
+		sets = append(sets, "think_verdict = ?")
+		args = append(args, *upd.ThinkVerdict)
+	}
+	if upd.ThinkSource != nil {
+		sets = append(sets, "think_source = ?")
+		args = append(args, *upd.ThinkSource)
+	}
+	if upd.ThinkValue != nil {
+		sets = append(sets, "think_value = ?")
+		args = append(args, *upd.ThinkValue)
+	}
+	if upd.ImagesDropped != nil {
+		sets = append(sets, "images_dropped = ?")
+		args = append(args, *upd.ImagesDropped)
+	}
+	if upd.TruncationSuspected != nil {
+		sets = append(sets, "truncation_suspected = ?")
+		args = append(args, boolToInt(*upd.TruncationSuspected))
+	}
+	if upd.Cost != nil {
+		sets = append(sets, "cost = ?")
+		args = append(args, *upd.Cost)
+	}
+	if upd.CostEstimated != nil {
+		sets = append(sets, "cost_estimated = ?")
+		args = append(args, boolToInt(*upd.CostEstimated))
+	}
+	if upd.Shadow != nil {
+		sets = append(sets, "shadow_json = ?")
+		args = append(args, shadowJSON(upd.Shadow))
+	}
 
 	if len(sets) == 0 {
 		return nil // nothing to update
@@ -262,15 +400,7 @@ func (s *SQLiteStore) Update(id string, upd RequestUpdate) error {
 // GetByID retrieves a single request.
 func (s *SQLiteStore) GetByID(id string) (*Request, error) {
 	row := s.db.QueryRow(`
-		SELECT id, ts_start, ts_end, status, reason, model, endpoint,
-			messages_count, system_chars, user_chars, assistant_chars,
-			tools_count, tool_choice, stream_requested,
-			ctx_est, ctx_selected, ctx_bucket, output_budget,
-			prompt_tokens, completion_tokens,
-			duration_ms, ttfb_ms, upstream_total_ms, upstream_load_ms,
-			upstream_prompt_eval_ms, upstream_eval_ms,
-			client_in_bytes, client_out_bytes, upstream_in_bytes, upstream_out_bytes,
-			retry_count, upstream_http_status, error_class
+		SELECT ` + requestColumns + `
 		FROM requests WHERE id = ?
 	`, id)
 
@@ -287,15 +417,7 @@ func (s *SQLiteStore) GetByID(id string) (*Request, error) {
 // List retrieves requests with filtering.
 func (s *SQLiteStore) List(opts ListOptions) ([]Request, error) {
 	query := `
-		SELECT id, ts_start, ts_end, status, reason, model, endpoint,
-			messages_count, system_chars, user_chars, assistant_chars,
-			tools_count, tool_choice, stream_requested,
-			ctx_est, ctx_selected, ctx_bucket, output_budget,
-			prompt_tokens, completion_tokens,
-			duration_ms, ttfb_ms, upstream_total_ms, upstream_load_ms,
-			upstream_prompt_eval_ms, upstream_eval_ms,
-			client_in_bytes, client_out_bytes, upstream_in_bytes, upstream_out_bytes,
-			retry_count, upstream_http_status, error_class
+		SELECT ` + requestColumns + `
 		FROM requests WHERE 1=1
 	`
 	var args []any
@@ -312,6 +434,13 @@ func (s *SQLiteStore) List(opts ListOptions) ([]Request, error) {
 		query += " AND reason = ?"
 		args = append(args, string(*opts.Reason))
 	}
+	if opts.Tag != "" {
+		query += " AND instr(',' || tags || ',', ?) > 0"
+		args = append(args, ","+opts.Tag+",")
+	}
+	if opts.Shadow {
+		query += " AND shadow_json IS NOT NULL"
+	}
 	if opts.Window > 0 {
 		cutoff := time.Now().UnixMilli() - opts.Window.Milliseconds()
 		query += " AND ts_start >= ?"
@@ -390,6 +519,29 @@ func (s *SQLiteStore) Overview(window time.Duration) (*Overview, error) {
 		o.P95DurationMs = p95
 	}
 
+	codes, err := s.db.Query(`
+		SELECT upstream_http_status, COUNT(*) FROM requests
+		WHERE ts_start >= ? AND upstream_http_status > 0
+		GROUP BY upstream_http_status
+	`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("status code query: %w", err)
+	}
+	defer codes.Close()
+	for codes.Next() {
+		var code, count int
+		if err := codes.Scan(&code, &count); err != nil {
+			return nil, fmt.Errorf("scan status code: %w", err)
+		}
+		if o.StatusCodes == nil {
+			o.StatusCodes = make(map[int]int)
+		}
+		o.StatusCodes[code] = count
+	}
+	if err := codes.Err(); err != nil {
+		return nil, fmt.Errorf("status code rows: %w", err)
+	}
+
 	return &o, nil
 }
 
@@ -549,8 +701,422 @@ func (s *SQLiteStore) InFlightCount() (int, error) {
 	return count, err
 }
 
-// Close closes the database connection.
+// LatencyCompliance counts completed requests in the window and the successful
+// ones with duration_ms <= threshold.
+func (s *SQLiteStore) LatencyCompliance(window, threshold time.Duration) (int, int, error) {
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+
+	var good, total int
+	err := s.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN status = 'success' AND duration_ms <= ? THEN 1 ELSE 0 END), 0),
+			COUNT(*)
+		FROM requests
+		WHERE ts_start >= ? AND status != 'in_flight'
+	`, threshold.Milliseconds(), cutoff).Scan(&good, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("latency compliance query: %w", err)
+	}
+	return good, total, nil
+}
+
+// CompareModels returns side-by-side stats for models. It reads the matching
+// rows once and aggregates them in Go, since SQLite has no percentile function.
+func (s *SQLiteStore) CompareModels(window time.Duration, models []string) ([]ModelComparison, error) {
+	if len(models) == 0 {
+		return []ModelComparison{}, nil
+	}
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+
+	args := []any{cutoff}
+	for _, m := range models {
+		args = append(args, m)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(models)), ", ")
+
+	rows, err := s.db.Query(`
+		SELECT model, status, duration_ms, upstream_eval_ms,
+			prompt_tokens, completion_tokens, ctx_selected, retry_count
+		FROM requests
+		WHERE ts_start >= ? AND model IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("compare models query: %w", err)
+	}
+	defer rows.Close()
+
+	accs := make(map[string]*compareAccumulator, len(models))
+	for _, m := range models {
+		accs[m] = &compareAccumulator{}
+	}
+	for rows.Next() {
+		var req Request
+		if err := rows.Scan(&req.Model, &req.Status, &req.DurationMs, &req.UpstreamEvalMs,
+			&req.PromptTokens, &req.CompletionTokens, &req.CtxSelected, &req.RetryCount); err != nil {
+			return nil, fmt.Errorf("scan compare row: %w", err)
+		}
+		accs[req.Model].add(&req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return compareResults(models, accs), nil
+}
+
+// TagStats groups requests in the window by their value for tag key. Like
+// CompareModels it filters in SQL and aggregates in Go.
+func (s *SQLiteStore) TagStats(window time.Duration, key string) ([]TagStat, error) {
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	rows, err := s.db.Query(`
+		SELECT tags, status, duration_ms, prompt_tokens, completion_tokens
+		FROM requests
+		WHERE ts_start >= ? AND instr(',' || tags, ?) > 0
+	`, cutoff, ","+key+"=")
+	if err != nil {
+		return nil, fmt.Errorf("tag stats query: %w", err)
+	}
+	defer rows.Close()
+
+	accs := make(map[string]*tagAccumulator)
+	for rows.Next() {
+		var req Request
+		if err := rows.Scan(&req.Tags, &req.Status, &req.DurationMs, &req.PromptTokens, &req.CompletionTokens); err != nil {
+			return nil, fmt.Errorf("scan tag stats row: %w", err)
+		}
+		addTagStat(accs, key, &req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tagResults(accs), nil
+}
+
+// TopFingerprints returns the fingerprints seen more than once in the
+// window, most repeated first. SQLite groups and counts them.
+func (s *SQLiteStore) TopFingerprints(window time.Duration, limit int) ([]FingerprintStat, error) {
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	rows, err := s.db.Query(`
+		SELECT fingerprint, model, COUNT(*), MIN(ts_start), MAX(ts_start)
+		FROM requests
+		WHERE ts_start >= ? AND fingerprint != ''
+		GROUP BY fingerprint, model
+		HAVING COUNT(*) > 1
+		ORDER BY COUNT(*) DESC, fingerprint
+		LIMIT ?
+	`, cutoff, limit)
+	if err != nil {
+		return nil, fmt.Errorf("top fingerprints query: %w", err)
+	}
+	defer rows.Close()
+
+	out := []FingerprintStat{}
+	for rows.Next() {
+		var st FingerprintStat
+		if err := rows.Scan(&st.Fingerprint, &st.Model, &st.RequestCount, &st.FirstSeen, &st.LastSeen); err != nil {
+			return nil, fmt.Errorf("scan fingerprint row: %w", err)
+		}
+		out = append(out, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CostStats sums the cost of requests in the window per model or tag value.
+// Rows are grouped in Go so tag values are parsed like TagStats does.
+func (s *SQLiteStore) CostStats(window time.Duration, key string) ([]CostStat, error) {
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	query := `
+		SELECT model, tags, prompt_tokens, completion_tokens, cost, cost_estimated
+		FROM requests
+		WHERE ts_start >= ?`
+	args := []any{cutoff}
+	if key != "" {
+		query += ` AND instr(',' || tags, ?) > 0`
+		args = append(args, ","+key+"=")
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cost stats query: %w", err)
+	}
+	defer rows.Close()
+
+	accs := make(map[string]*CostStat)
+	for rows.Next() {
+		var req Request
+		var tags sql.NullString
+		var cost sql.NullFloat64
+		var estimated sql.NullInt64
+		if err := rows.Scan(&req.Model, &tags, &req.PromptTokens, &req.CompletionTokens, &cost, &estimated); err != nil {
+			return nil, fmt.Errorf("scan cost stats row: %w", err)
+		}
+		req.Tags = tags.String
+		req.Cost = cost.Float64
+		req.CostEstimated = estimated.Int64 != 0
+		addCost(accs, key, &req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return costResults(accs), nil
+}
+
+// UsagePattern buckets requests in the window by weekday and hour of day.
+// SQLite derives the buckets with strftime; percentiles are computed in Go.
+func (s *SQLiteStore) UsagePattern(window, utcOffset time.Duration) (*UsagePattern, error) {
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	shift := fmt.Sprintf("%+d minutes", int(utcOffset.Minutes()))
+	rows, err := s.db.Query(`
+		SELECT CAST(strftime('%w', ts_start / 1000, 'unixepoch', ?) AS INTEGER),
+			CAST(strftime('%H', ts_start / 1000, 'unixepoch', ?) AS INTEGER),
+			duration_ms, status
+		FROM requests
+		WHERE ts_start >= ?
+	`, shift, shift, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("usage pattern query: %w", err)
+	}
+	defer rows.Close()
+
+	var acc usageAccumulator
+	for rows.Next() {
+		var weekday, hour, durationMs int
+		var status Status
+		if err := rows.Scan(&weekday, &hour, &durationMs, &status); err != nil {
+			return nil, fmt.Errorf("scan usage pattern row: %w", err)
+		}
+		acc.add(weekday, hour, durationMs, status != StatusInFlight)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return acc.result(), nil
+}
+
+// LoadCalibration returns the calibration parameters stored per model.
+func (s *SQLiteStore) LoadCalibration() (map[string]calibration.Params, error) {
+	rows, err := s.db.Query(`
+		SELECT model, tokens_per_byte, fixed_overhead, per_message_overhead,
+			safe_max_ctx, samples, updated_at, eval_tokens_per_sec
+		FROM calibration
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("load calibration: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]calibration.Params)
+	for rows.Next() {
+		var model string
+		var p calibration.Params
+		var updatedAt sql.NullInt64
+		if err := rows.Scan(&model, &p.TokensPerByte, &p.FixedOverhead, &p.PerMessageOverhead,
+			&p.SafeMaxCtx, &p.Samples, &updatedAt, &p.EvalTokensPerSec); err != nil {
+			return nil, fmt.Errorf("scan calibration row: %w", err)
+		}
+		if updatedAt.Valid {
+			p.UpdatedAt = time.UnixMilli(updatedAt.Int64)
+		}
+		out[model] = p
+	}
+	return out, rows.Err()
+}
+
+// SaveCalibration upserts the given per-model parameters in one transaction.
+func (s *SQLiteStore) SaveCalibration(models map[string]calibration.Params) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("save calibration: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO calibration (model, tokens_per_byte, fixed_overhead, per_message_overhead,
+			safe_max_ctx, samples, updated_at, eval_tokens_per_sec)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(model) DO UPDATE SET
+			tokens_per_byte = excluded.tokens_per_byte,
+			fixed_overhead = excluded.fixed_overhead,
+			per_message_overhead = excluded.per_message_overhead,
+			safe_max_ctx = excluded.safe_max_ctx,
+			samples = excluded.samples,
+			updated_at = excluded.updated_at,
+			eval_tokens_per_sec = excluded.eval_tokens_per_sec
+	`)
+	if err != nil {
+		return fmt.Errorf("save calibration: %w", err)
+	}
+	defer stmt.Close()
+
+	for model, p := range models {
+		var updatedAt sql.NullInt64
+		if !p.UpdatedAt.IsZero() {
+			updatedAt = sql.NullInt64{Int64: p.UpdatedAt.UnixMilli(), Valid: true}
+		}
+		if _, err := stmt.Exec(model, p.TokensPerByte, p.FixedOverhead, p.PerMessageOverhead,
+			p.SafeMaxCtx, p.Samples, updatedAt, p.EvalTokensPerSec); err != nil {
+			return fmt.Errorf("save calibration for %s: %w", model, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadTagQuotaUsage returns the per-tag quota usage stored for the window
+// starting at windowStart (Unix ms).
+func (s *SQLiteStore) LoadTagQuotaUsage(windowStart int64) (map[string]TagQuotaUsage, error) {
+	rows, err := s.db.Query(`SELECT tag, tokens, requests FROM tag_quota_usage WHERE window_start = ?`, windowStart)
+	if err != nil {
+		return nil, fmt.Errorf("load tag quota usage: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]TagQuotaUsage)
+	for rows.Next() {
+		var tag string
+		var u TagQuotaUsage
+		if err := rows.Scan(&tag, &u.Tokens, &u.Requests); err != nil {
+			return nil, fmt.Errorf("scan tag quota usage row: %w", err)
+		}
+		out[tag] = u
+	}
+	return out, rows.Err()
+}
+
+// SaveTagQuotaUsage upserts the usage of the window starting at windowStart
+// and deletes that of earlier windows, in one transaction.
+func (s *SQLiteStore) SaveTagQuotaUsage(windowStart int64, usage map[string]TagQuotaUsage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("save tag quota usage: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM tag_quota_usage WHERE window_start < ?`, windowStart); err != nil {
+		return fmt.Errorf("save tag quota usage: %w", err)
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO tag_quota_usage (tag, window_start, tokens, requests)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(tag, window_start) DO UPDATE SET
+			tokens = excluded.tokens,
+			requests = excluded.requests
+	`)
+	if err != nil {
+		return fmt.Errorf("save tag quota usage: %w", err)
+	}
+	defer stmt.Close()
+
+	for tag, u := range usage {
+		if _, err := stmt.Exec(tag, windowStart, u.Tokens, u.Requests); err != nil {
+			return fmt.Errorf("save tag quota usage for %s: %w", tag, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// InsertMetricSnapshot stores one periodic metrics snapshot, one row per value.
+func (s *SQLiteStore) InsertMetricSnapshot(snap MetricSnapshot) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("insert metric snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO metric_snapshots (ts, name, value) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("insert metric snapshot: %w", err)
+	}
+	defer stmt.Close()
+
+	for name, value := range snap.Values {
+		if _, err := stmt.Exec(snap.TS, name, value); err != nil {
+			return fmt.Errorf("insert metric snapshot %s: %w", name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// MetricSnapshots returns the snapshots taken in the window, oldest first.
+func (s *SQLiteStore) MetricSnapshots(window time.Duration, names []string) ([]MetricSnapshot, error) {
+	cutoff := time.Now().UnixMilli() - window.Milliseconds()
+	query := `SELECT ts, name, value FROM metric_snapshots WHERE ts >= ?`
+	args := []any{cutoff}
+	if len(names) > 0 {
+		query += ` AND name IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ") + `)`
+		for _, n := range names {
+			args = append(args, n)
+		}
+	}
+	query += ` ORDER BY ts`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("metric snapshots query: %w", err)
+	}
+	defer rows.Close()
+
+	out := []MetricSnapshot{}
+	for rows.Next() {
+		var ts int64
+		var name string
+		var value float64
+		if err := rows.Scan(&ts, &name, &value); err != nil {
+			return nil, fmt.Errorf("scan metric snapshot row: %w", err)
+		}
+		if len(out) == 0 || out[len(out)-1].TS != ts {
+			out = append(out, MetricSnapshot{TS: ts, Values: make(map[string]float64)})
+		}
+		out[len(out)-1].Values[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PruneMetricSnapshots deletes snapshots taken before the cutoff.
+func (s *SQLiteStore) PruneMetricSnapshots(before int64) (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(DISTINCT ts) FROM metric_snapshots WHERE ts < ?`, before).Scan(&n); err != nil {
+		return 0, fmt.Errorf("prune metric snapshots: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if _, err := s.db.Exec(`DELETE FROM metric_snapshots WHERE ts < ?`, before); err != nil {
+		return 0, fmt.Errorf("prune metric snapshots: %w", err)
+	}
+	return n, nil
+}
+
+// Backup copies the database to path with VACUUM INTO. The copy is a
+// consistent snapshot taken in one read transaction, so concurrent writes
+// (queued behind it on the single connection) are either fully in or out.
+func (s *SQLiteStore) Backup(path string) (int64, error) {
+	if _, err := os.Stat(path); err == nil {
+		return 0, fmt.Errorf("backup target %s already exists", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if _, err := s.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return 0, fmt.Errorf("backup: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("backup: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// Close waits for background pruning, checkpoints the WAL into the main
+// database file and closes the connection.
 func (s *SQLiteStore) Close() error {
+	s.pruneWG.Wait()
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		s.logger.Warn("wal checkpoint on close failed", "err", err)
+	}
 	return s.db.Close()
 }
 
@@ -590,6 +1156,16 @@ func (s *SQLiteStore) maybePrune() {
 
 // Helper functions
 
+// migrate applies the column migrations, skipping ones already present.
+func migrate(db *sql.DB) error {
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -603,9 +1179,11 @@ type rowScanner interface {
 
 func scanRequest(row rowScanner) (*Request, error) {
 	var req Request
-	var tsEnd sql.NullInt64
-	var reason, toolChoice, errorClass sql.NullString
+	var tsEnd, injectedSeed sql.NullInt64
+	var reason, toolChoice, errorClass, thinkVerdict, thinkSource, optionsJSON, strippedOptions, family, tags, shadow, thinkValue, loopRetry, fallbackModel, timeoutProfile, promptScan, fingerprint sql.NullString
 	var streamInt int
+	var invalidImages, unsupervised, imagesDropped, estimateMs, forwardMs, upstreamDoneMs, loadingRetries, truncationSuspected, loadWaitMs, costEstimated sql.NullInt64
+	var cost sql.NullFloat64
 
 	err := row.Scan(
 		&req.ID, &req.TSStart, &tsEnd, &req.Status, &reason, &req.Model, &req.Endpoint,
@@ -617,6 +1195,11 @@ func scanRequest(row rowScanner) (*Request, error) {
 		&req.UpstreamPromptEvalMs, &req.UpstreamEvalMs,
 		&req.ClientInBytes, &req.ClientOutBytes, &req.UpstreamInBytes, &req.UpstreamOutBytes,
 		&req.RetryCount, &req.UpstreamHTTPStatus, &errorClass,
+		&thinkVerdict, &thinkSource, &optionsJSON, &strippedOptions, &family,
+		&invalidImages, &tags, &injectedSeed, &shadow, &thinkValue,
+		&unsupervised, &imagesDropped, &estimateMs, &forwardMs, &upstreamDoneMs,
+		&loadingRetries, &truncationSuspected, &loopRetry, &fallbackModel, &loadWaitMs, &timeoutProfile, &promptScan, &fingerprint,
+		&cost, &costEstimated,
 	)
 	if err != nil {
 		return nil, err
@@ -625,14 +1208,58 @@ func scanRequest(row rowScanner) (*Request, error) {
 	if tsEnd.Valid {
 		req.TSEnd = &tsEnd.Int64
 	}
+	if injectedSeed.Valid {
+		req.InjectedSeed = &injectedSeed.Int64
+	}
 	req.Reason = Reason(reason.String)
 	req.ToolChoice = toolChoice.String
 	req.ErrorClass = errorClass.String
+	req.ThinkVerdict = thinkVerdict.String
+	req.ThinkSource = thinkSource.String
+	req.ThinkValue = thinkValue.String
+	req.OptionsJSON = optionsJSON.String
+	req.StrippedOptions = strippedOptions.String
+	req.Family = family.String
+	req.InvalidImages = int(invalidImages.Int64)
+	req.ImagesDropped = int(imagesDropped.Int64)
+	req.EstimateMs = int(estimateMs.Int64)
+	req.ForwardMs = int(forwardMs.Int64)
+	req.UpstreamDoneMs = int(upstreamDoneMs.Int64)
+	req.LoadingRetries = int(loadingRetries.Int64)
+	req.LoopRetry = loopRetry.String
+	req.FallbackModel = fallbackModel.String
+	req.LoadWaitMs = int(loadWaitMs.Int64)
+	req.TimeoutProfile = timeoutProfile.String
+	req.PromptScan = promptScan.String
+	req.Fingerprint = fingerprint.String
+	req.Cost = cost.Float64
+	req.CostEstimated = costEstimated.Int64 != 0
+	req.Tags = tags.String
 	req.StreamRequested = streamInt != 0
+	req.Unsupervised = unsupervised.Int64 != 0
+	req.TruncationSuspected = truncationSuspected.Int64 != 0
+	if shadow.Valid && shadow.String != "" {
+		var res ShadowResult
+		if err := json.Unmarshal([]byte(shadow.String), &res); err == nil {
+			req.Shadow = &res
+		}
+	}
 
 	return &req, nil
 }
 
+// shadowJSON encodes a shadow result for the shadow_json column (NULL when nil).
+func shadowJSON(res *ShadowResult) any {
+	if res == nil {
+		return nil
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
 func scanRequestRows(rows *sql.Rows) (*Request, error) {
 	return scanRequest(rows)
 }
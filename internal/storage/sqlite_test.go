@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"database/sql"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"ollama-auto-ctx/internal/calibration"
 )
 
 func TestSQLiteStore_InsertAndGet(t *testing.T) {
@@ -30,6 +35,9 @@ func TestSQLiteStore_InsertAndGet(t *testing.T) {
 		SystemChars:   100,
 		UserChars:     200,
 		ClientInBytes: 500,
+		OptionsJSON:   `{"temperature":0.2}`,
+		Family:        "llama",
+		InvalidImages: 2,
 	}
 
 	if err := store.Insert(req); err != nil {
@@ -53,6 +61,15 @@ func TestSQLiteStore_InsertAndGet(t *testing.T) {
 	if got.MessagesCount != req.MessagesCount {
 		t.Errorf("MessagesCount = %v, want %v", got.MessagesCount, req.MessagesCount)
 	}
+	if got.OptionsJSON != req.OptionsJSON {
+		t.Errorf("OptionsJSON = %v, want %v", got.OptionsJSON, req.OptionsJSON)
+	}
+	if got.Family != req.Family {
+		t.Errorf("Family = %v, want %v", got.Family, req.Family)
+	}
+	if got.InvalidImages != req.InvalidImages {
+		t.Errorf("InvalidImages = %v, want %v", got.InvalidImages, req.InvalidImages)
+	}
 }
 
 func TestSQLiteStore_Update(t *testing.T) {
@@ -211,15 +228,17 @@ func TestSQLiteStore_Overview(t *testing.T) {
 
 	// Insert mix of statuses
 	statuses := []Status{StatusSuccess, StatusSuccess, StatusSuccess, StatusError, StatusCanceled}
+	httpStatuses := []int{200, 200, 206, 500, 0} // the canceled request never got a response
 	for i, status := range statuses {
 		req := &Request{
-			ID:               "overview-" + string(rune('0'+i)),
-			TSStart:          time.Now().UnixMilli(),
-			Status:           status,
-			Model:            "llama2",
-			DurationMs:       100 + i*10,
-			CompletionTokens: 50,
-			ClientOutBytes:   1000,
+			ID:                 "overview-" + string(rune('0'+i)),
+			TSStart:            time.Now().UnixMilli(),
+			Status:             status,
+			Model:              "llama2",
+			DurationMs:         100 + i*10,
+			CompletionTokens:   50,
+			ClientOutBytes:     1000,
+			UpstreamHTTPStatus: httpStatuses[i],
 		}
 		if err := store.Insert(req); err != nil {
 			t.Fatalf("Insert error: %v", err)
@@ -240,6 +259,375 @@ func TestSQLiteStore_Overview(t *testing.T) {
 	if overview.TotalTokens != 250 {
 		t.Errorf("TotalTokens = %d, want 250", overview.TotalTokens)
 	}
+	wantCodes := map[int]int{200: 2, 206: 1, 500: 1}
+	if len(overview.StatusCodes) != len(wantCodes) {
+		t.Errorf("StatusCodes = %v, want %v", overview.StatusCodes, wantCodes)
+	}
+	for code, n := range wantCodes {
+		if overview.StatusCodes[code] != n {
+			t.Errorf("StatusCodes[%d] = %d, want %d", code, overview.StatusCodes[code], n)
+		}
+	}
+}
+
+func TestSQLiteStore_LatencyCompliance(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UnixMilli()
+	reqs := []Request{
+		{ID: "fast", TSStart: now, Status: StatusSuccess, DurationMs: 500},
+		{ID: "edge", TSStart: now, Status: StatusSuccess, DurationMs: 1000},
+		{ID: "slow", TSStart: now, Status: StatusSuccess, DurationMs: 5000},
+		{ID: "failed", TSStart: now, Status: StatusError, DurationMs: 100},
+		{ID: "running", TSStart: now, Status: StatusInFlight},
+		{ID: "old", TSStart: now - 2*time.Hour.Milliseconds(), Status: StatusSuccess, DurationMs: 100},
+	}
+	for i := range reqs {
+		reqs[i].Model = "llama2"
+		reqs[i].Endpoint = "chat"
+		if err := store.Insert(&reqs[i]); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+	}
+
+	good, total, err := store.LatencyCompliance(time.Hour, time.Second)
+	if err != nil {
+		t.Fatalf("LatencyCompliance error: %v", err)
+	}
+	if good != 2 || total != 4 {
+		t.Errorf("LatencyCompliance = %d/%d, want 2/4", good, total)
+	}
+
+	mem := NewMemoryStore(10)
+	for i := range reqs {
+		mem.Insert(&reqs[i])
+	}
+	good, total, _ = mem.LatencyCompliance(time.Hour, time.Second)
+	if good != 2 || total != 4 {
+		t.Errorf("MemoryStore LatencyCompliance = %d/%d, want 2/4", good, total)
+	}
+}
+
+func TestCompareModels(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now().UnixMilli()
+	reqs := []Request{
+		{ID: "a1", Model: "a", Status: StatusSuccess, DurationMs: 1000, UpstreamEvalMs: 500, CompletionTokens: 50, PromptTokens: 950, CtxSelected: 2000},
+		{ID: "a2", Model: "a", Status: StatusSuccess, DurationMs: 2000, CompletionTokens: 40, PromptTokens: 460, CtxSelected: 1000, RetryCount: 1},
+		{ID: "a3", Model: "a", Status: StatusError, DurationMs: 3000},
+		{ID: "b1", Model: "b", Status: StatusSuccess, DurationMs: 400, UpstreamEvalMs: 200, CompletionTokens: 10, PromptTokens: 90, CtxSelected: 1000},
+		{ID: "b2", Model: "b", Status: StatusInFlight},
+		{ID: "other", Model: "c", Status: StatusSuccess, DurationMs: 10},
+		{ID: "old", Model: "a", Status: StatusError, DurationMs: 9000, TSStart: now - 48*time.Hour.Milliseconds()},
+	}
+	mem := NewMemoryStore(10)
+	for i := range reqs {
+		reqs[i].Endpoint = "chat"
+		if reqs[i].TSStart == 0 {
+			reqs[i].TSStart = now
+		}
+		if err := sqlite.Insert(&reqs[i]); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+		mem.Insert(&reqs[i])
+	}
+
+	for name, store := range map[string]Store{"sqlite": sqlite, "memory": mem} {
+		got, err := store.CompareModels(24*time.Hour, []string{"b", "a", "missing"})
+		if err != nil {
+			t.Fatalf("%s: CompareModels error: %v", name, err)
+		}
+		if len(got) != 3 || got[0].Model != "b" || got[1].Model != "a" || got[2].Model != "missing" {
+			t.Fatalf("%s: unexpected models/order %+v", name, got)
+		}
+
+		a := got[1]
+		if a.RequestCount != 3 || a.DurationP95Ms != 3000 {
+			t.Errorf("%s: a count/p95 = %d/%d, want 3/3000", name, a.RequestCount, a.DurationP95Ms)
+		}
+		if math.Abs(a.SuccessRate-2.0/3) > 1e-9 || math.Abs(a.RetryRate-1.0/3) > 1e-9 {
+			t.Errorf("%s: a success/retry = %v/%v", name, a.SuccessRate, a.RetryRate)
+		}
+		// (50/0.5s + 40/2s) / 2 = 60 tok/s; (0.5 + 0.5) / 2 utilization.
+		if math.Abs(a.AvgGenTokPerS-60) > 1e-9 || math.Abs(a.AvgCtxUtilization-0.5) > 1e-9 {
+			t.Errorf("%s: a tok/s / util = %v/%v, want 60/0.5", name, a.AvgGenTokPerS, a.AvgCtxUtilization)
+		}
+
+		b := got[0]
+		if b.RequestCount != 2 || b.SuccessRate != 0.5 || b.DurationP95Ms != 400 || b.AvgGenTokPerS != 50 {
+			t.Errorf("%s: unexpected b stats %+v", name, b)
+		}
+		if got[2].RequestCount != 0 {
+			t.Errorf("%s: missing model should be empty, got %+v", name, got[2])
+		}
+	}
+}
+
+func TestRequestTags(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now().UnixMilli()
+	reqs := []Request{
+		{ID: "s1", Tags: "project=rag,team=search", Status: StatusSuccess, DurationMs: 100, PromptTokens: 10, CompletionTokens: 5},
+		{ID: "s2", Tags: "team=search", Status: StatusError, DurationMs: 300, PromptTokens: 20},
+		{ID: "a1", Tags: "team=ads", Status: StatusSuccess, DurationMs: 50, PromptTokens: 7, CompletionTokens: 3},
+		{ID: "lookalike", Tags: "subteam=search", Status: StatusSuccess},
+		{ID: "untagged", Status: StatusSuccess},
+		{ID: "old", Tags: "team=ads", Status: StatusSuccess, TSStart: now - 48*time.Hour.Milliseconds()},
+	}
+	mem := NewMemoryStore(10)
+	for i := range reqs {
+		reqs[i].Model, reqs[i].Endpoint = "llama3", "chat"
+		if reqs[i].TSStart == 0 {
+			reqs[i].TSStart = now
+		}
+		if err := sqlite.Insert(&reqs[i]); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+		mem.Insert(&reqs[i])
+	}
+
+	for name, store := range map[string]Store{"sqlite": sqlite, "memory": mem} {
+		list, err := store.List(ListOptions{Tag: "team=search", Window: 24 * time.Hour})
+		if err != nil {
+			t.Fatalf("%s: List error: %v", name, err)
+		}
+		if len(list) != 2 {
+			t.Errorf("%s: expected 2 team=search requests, got %d", name, len(list))
+		}
+		for _, req := range list {
+			if ParseTags(req.Tags)["team"] != "search" {
+				t.Errorf("%s: unexpected tags %q on %s", name, req.Tags, req.ID)
+			}
+		}
+
+		stats, err := store.TagStats(24*time.Hour, "team")
+		if err != nil {
+			t.Fatalf("%s: TagStats error: %v", name, err)
+		}
+		if len(stats) != 2 || stats[0].Value != "search" || stats[1].Value != "ads" {
+			t.Fatalf("%s: unexpected tag groups %+v", name, stats)
+		}
+		search := stats[0]
+		if search.RequestCount != 2 || search.SuccessRate != 0.5 || search.AvgDurationMs != 200 ||
+			search.PromptTokens != 30 || search.CompletionTokens != 5 {
+			t.Errorf("%s: unexpected search stats %+v", name, search)
+		}
+		if stats[1].RequestCount != 1 {
+			t.Errorf("%s: ads outside the window was counted: %+v", name, stats[1])
+		}
+	}
+}
+
+func TestTopFingerprints(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now().UnixMilli()
+	reqs := []Request{
+		{ID: "a1", Fingerprint: "aaaa", TSStart: now - 3000},
+		{ID: "a2", Fingerprint: "aaaa", TSStart: now - 2000},
+		{ID: "a3", Fingerprint: "aaaa", TSStart: now - 1000},
+		{ID: "b1", Fingerprint: "bbbb", TSStart: now - 500},
+		{ID: "b2", Fingerprint: "bbbb", TSStart: now},
+		{ID: "c1", Fingerprint: "cccc", TSStart: now},
+		{ID: "old", Fingerprint: "cccc", TSStart: now - 48*time.Hour.Milliseconds()},
+		{ID: "none1", TSStart: now},
+		{ID: "none2", TSStart: now},
+	}
+	mem := NewMemoryStore(20)
+	for i := range reqs {
+		reqs[i].Model, reqs[i].Endpoint, reqs[i].Status = "llama3", "chat", StatusSuccess
+		if err := sqlite.Insert(&reqs[i]); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+		mem.Insert(&reqs[i])
+	}
+
+	got, err := sqlite.GetByID("a1")
+	if err != nil || got.Fingerprint != "aaaa" {
+		t.Fatalf("fingerprint not stored: %+v, %v", got, err)
+	}
+
+	for name, store := range map[string]Store{"sqlite": sqlite, "memory": mem} {
+		stats, err := store.TopFingerprints(24*time.Hour, 10)
+		if err != nil {
+			t.Fatalf("%s: TopFingerprints error: %v", name, err)
+		}
+		// cccc was seen twice, but once outside the window; requests without
+		// a fingerprint are not grouped together.
+		if len(stats) != 2 || stats[0].Fingerprint != "aaaa" || stats[1].Fingerprint != "bbbb" {
+			t.Fatalf("%s: unexpected fingerprints %+v", name, stats)
+		}
+		want := FingerprintStat{Fingerprint: "aaaa", Model: "llama3", RequestCount: 3, FirstSeen: now - 3000, LastSeen: now - 1000}
+		if stats[0] != want {
+			t.Errorf("%s: got %+v, want %+v", name, stats[0], want)
+		}
+
+		stats, err = store.TopFingerprints(24*time.Hour, 1)
+		if err != nil {
+			t.Fatalf("%s: TopFingerprints error: %v", name, err)
+		}
+		if len(stats) != 1 || stats[0].Fingerprint != "aaaa" {
+			t.Errorf("%s: limit not applied: %+v", name, stats)
+		}
+	}
+}
+
+func TestCostStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now().UnixMilli()
+	reqs := []Request{
+		{ID: "1", Model: "llama3", Tags: "team=search", PromptTokens: 1000, CompletionTokens: 100, Cost: 0.5, TSStart: now},
+		{ID: "2", Model: "llama3", Tags: "team=ads", PromptTokens: 2000, CompletionTokens: 200, Cost: 1, TSStart: now},
+		{ID: "3", Model: "qwen3", Tags: "team=search", PromptTokens: 500, CompletionTokens: 50, Cost: 0.25, CostEstimated: true, TSStart: now},
+		{ID: "4", Model: "qwen3", PromptTokens: 500, Cost: 0.25, TSStart: now},
+		{ID: "old", Model: "qwen3", Tags: "team=search", Cost: 100, TSStart: now - 48*time.Hour.Milliseconds()},
+	}
+	mem := NewMemoryStore(20)
+	for i := range reqs {
+		reqs[i].Endpoint, reqs[i].Status = "chat", StatusSuccess
+		cost, estimated := reqs[i].Cost, reqs[i].CostEstimated
+		reqs[i].Cost, reqs[i].CostEstimated = 0, false
+		for _, store := range []Store{sqlite, mem} {
+			if err := store.Insert(&reqs[i]); err != nil {
+				t.Fatalf("Insert error: %v", err)
+			}
+			if err := store.Update(reqs[i].ID, RequestUpdate{Cost: &cost, CostEstimated: &estimated}); err != nil {
+				t.Fatalf("Update error: %v", err)
+			}
+		}
+	}
+
+	got, err := sqlite.GetByID("3")
+	if err != nil || got.Cost != 0.25 || !got.CostEstimated {
+		t.Fatalf("cost not stored: %+v, %v", got, err)
+	}
+
+	for name, store := range map[string]Store{"sqlite": sqlite, "memory": mem} {
+		stats, err := store.CostStats(24*time.Hour, "")
+		if err != nil {
+			t.Fatalf("%s: CostStats error: %v", name, err)
+		}
+		want := []CostStat{
+			{Key: "llama3", RequestCount: 2, PromptTokens: 3000, CompletionTokens: 300, Cost: 1.5},
+			{Key: "qwen3", RequestCount: 2, PromptTokens: 1000, CompletionTokens: 50, Cost: 0.5, EstimatedCount: 1},
+		}
+		if len(stats) != len(want) || stats[0] != want[0] || stats[1] != want[1] {
+			t.Errorf("%s: by model got %+v, want %+v", name, stats, want)
+		}
+
+		// By tag, requests without the tag are left out.
+		stats, err = store.CostStats(24*time.Hour, "team")
+		if err != nil {
+			t.Fatalf("%s: CostStats error: %v", name, err)
+		}
+		if len(stats) != 2 || stats[0].Key != "ads" || stats[0].Cost != 1 || stats[1].Key != "search" || stats[1].Cost != 0.75 || stats[1].RequestCount != 2 {
+			t.Errorf("%s: by tag got %+v", name, stats)
+		}
+	}
+}
+
+func TestSQLiteStore_CalibrationPersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	store, err := NewSQLiteStore(dbPath, 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+
+	defaults := calibration.Params{TokensPerByte: 0.25, FixedOverhead: 32, PerMessageOverhead: 8}
+	calib := calibration.NewStore(0.2, defaults, "")
+	if err := calib.SetBackend(store, time.Hour); err != nil {
+		t.Fatalf("SetBackend error: %v", err)
+	}
+	calib.Update(calibration.Sample{Model: "llama3", TextBytes: 4000, MessageCount: 2}, calibration.Observed{PromptEvalCount: 1500})
+	calib.RecordOOM("llama3", 16384)
+	calib.RecordEvalRate("llama3", 200, 4*time.Second)
+
+	// The debounce is long, so nothing is written until Flush.
+	if got, err := store.LoadCalibration(); err != nil || len(got) != 0 {
+		t.Fatalf("expected no rows before flush, got %v (err %v)", got, err)
+	}
+	if n, err := calib.Flush(); err != nil || n != 1 {
+		t.Fatalf("Flush = %d, %v", n, err)
+	}
+	want := calib.Get("llama3")
+	store.Close()
+
+	// Reopen and load into a fresh calibration store.
+	store, err = NewSQLiteStore(dbPath, 1000, nil)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer store.Close()
+
+	reloaded := calibration.NewStore(0.2, defaults, "")
+	if err := reloaded.SetBackend(store, time.Hour); err != nil {
+		t.Fatalf("SetBackend after reopen error: %v", err)
+	}
+	got := reloaded.Get("llama3")
+	if got.TokensPerByte != want.TokensPerByte || got.FixedOverhead != want.FixedOverhead ||
+		got.PerMessageOverhead != want.PerMessageOverhead || got.SafeMaxCtx != 16384 ||
+		got.Samples != 1 || got.UpdatedAt.UnixMilli() != want.UpdatedAt.UnixMilli() || got.EvalTokensPerSec != 50 {
+		t.Errorf("reloaded params = %+v, want %+v", got, want)
+	}
 }
 
 func TestSQLiteStore_WALMode(t *testing.T) {
@@ -275,3 +663,397 @@ func TestSQLiteStore_WALMode(t *testing.T) {
 	// WAL file may or may not exist depending on SQLite version/config
 	// The important thing is that the store opened without error with WAL mode requested
 }
+
+func TestSQLiteStore_MigratesExistingDatabase(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "test.db")
+
+	// Simulate a database created before the think columns existed.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("create base schema: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO requests (id, ts_start, status, model, endpoint) VALUES ('old-1', 1, 'success', 'llama2', 'chat')`); err != nil {
+		t.Fatalf("insert legacy row: %v", err)
+	}
+	db.Close()
+
+	// Opening twice checks that re-applying migrations is harmless.
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLiteStore(path, 1000, nil)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore (open %d) error: %v", i+1, err)
+		}
+		store.Close()
+	}
+
+	store, err := NewSQLiteStore(path, 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer store.Close()
+
+	old, err := store.GetByID("old-1")
+	if err != nil || old == nil {
+		t.Fatalf("GetByID legacy row: %v, %v", old, err)
+	}
+	if old.ThinkVerdict != "" || old.ThinkSource != "" {
+		t.Errorf("legacy row think = %q/%q, want empty", old.ThinkVerdict, old.ThinkSource)
+	}
+
+	if err := store.Insert(&Request{ID: "new-1", TSStart: 2, Status: StatusInFlight, Model: "qwen3"}); err != nil {
+		t.Fatalf("Insert error: %v", err)
+	}
+	verdict, source := "false", "default"
+	if err := store.Update("new-1", RequestUpdate{ThinkVerdict: &verdict, ThinkSource: &source}); err != nil {
+		t.Fatalf("Update error: %v", err)
+	}
+	got, err := store.GetByID("new-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ThinkVerdict != "false" || got.ThinkSource != "default" {
+		t.Errorf("think = %q/%q, want false/default", got.ThinkVerdict, got.ThinkSource)
+	}
+}
+
+func TestSQLiteStore_CloseFlushesWrites(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "test.db")
+
+	store, err := NewSQLiteStore(path, 100, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	// Exceed maxRows so Close has to wait for a background prune.
+	for i := 0; i < 120; i++ {
+		if err := store.Insert(&Request{ID: fmt.Sprintf("req-%d", i), TSStart: int64(i), Status: StatusSuccess, Model: "llama2", Endpoint: "chat"}); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	// The WAL is checkpointed on close, so it is empty or gone.
+	if fi, err := os.Stat(path + "-wal"); err == nil && fi.Size() > 0 {
+		t.Errorf("WAL not checkpointed on close, size %d", fi.Size())
+	}
+
+	store, err = NewSQLiteStore(path, 100, nil)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer store.Close()
+	got, err := store.GetByID("req-119")
+	if err != nil || got == nil {
+		t.Fatalf("last write not persisted: %v, %v", got, err)
+	}
+}
+
+func TestSQLiteStore_InjectedSeed(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	seed := int64(0) // zero is a valid seed and must survive the round trip
+	now := time.Now().UnixMilli()
+	if err := sqlite.Insert(&Request{ID: "seeded", TSStart: now, Status: StatusSuccess, InjectedSeed: &seed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlite.Insert(&Request{ID: "plain", TSStart: now, Status: StatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sqlite.GetByID("seeded")
+	if err != nil || got == nil || got.InjectedSeed == nil || *got.InjectedSeed != 0 {
+		t.Fatalf("expected injected seed 0, got %+v (err %v)", got, err)
+	}
+	got, err = sqlite.GetByID("plain")
+	if err != nil || got == nil || got.InjectedSeed != nil {
+		t.Fatalf("expected no injected seed, got %+v (err %v)", got, err)
+	}
+}
+
+func TestSQLiteStore_Shadow(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now().UnixMilli()
+	for _, id := range []string{"mirrored", "plain"} {
+		if err := sqlite.Insert(&Request{ID: id, TSStart: now, Status: StatusSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	match := false
+	res := ShadowResult{HTTPStatus: 200, DurationMs: 120, CompletionTokens: 7, OutputMatch: &match}
+	if err := sqlite.Update("mirrored", RequestUpdate{Shadow: &res}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sqlite.GetByID("mirrored")
+	if err != nil || got == nil || got.Shadow == nil {
+		t.Fatalf("expected a shadow result, got %+v (err %v)", got, err)
+	}
+	if got.Shadow.HTTPStatus != 200 || got.Shadow.CompletionTokens != 7 || got.Shadow.OutputMatch == nil || *got.Shadow.OutputMatch {
+		t.Errorf("shadow result = %+v, want %+v", got.Shadow, res)
+	}
+
+	list, err := sqlite.List(ListOptions{Shadow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "mirrored" {
+		t.Errorf("List(Shadow) = %+v, want only the mirrored request", list)
+	}
+}
+
+func TestSQLiteStore_Unsupervised(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now().UnixMilli()
+	if err := sqlite.Insert(&Request{ID: "bypassed", TSStart: now, Status: StatusSuccess, Unsupervised: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlite.Insert(&Request{ID: "supervised", TSStart: now, Status: StatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	thinkValue := `"high"`
+	if err := sqlite.Update("bypassed", RequestUpdate{ThinkValue: &thinkValue}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sqlite.GetByID("bypassed")
+	if err != nil || got == nil {
+		t.Fatalf("GetByID error: %v", err)
+	}
+	if !got.Unsupervised || got.ThinkValue != thinkValue {
+		t.Errorf("got unsupervised=%v think_value=%s", got.Unsupervised, got.ThinkValue)
+	}
+	if got, _ := sqlite.GetByID("supervised"); got == nil || got.Unsupervised {
+		t.Error("expected supervised request to stay supervised")
+	}
+}
+
+func TestUsagePattern(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now()
+	busy := now.Add(-3 * 24 * time.Hour).UnixMilli()
+	quiet := now.Add(-5*24*time.Hour - 7*time.Hour).UnixMilli()
+	reqs := []Request{
+		{ID: "b1", TSStart: busy, Status: StatusSuccess, DurationMs: 100},
+		{ID: "b2", TSStart: busy + 1000, Status: StatusSuccess, DurationMs: 300},
+		{ID: "b3", TSStart: busy + 2000, Status: StatusInFlight},
+		{ID: "q1", TSStart: quiet, Status: StatusError, DurationMs: 50},
+		{ID: "old", TSStart: now.Add(-40 * 24 * time.Hour).UnixMilli(), Status: StatusSuccess, DurationMs: 9000},
+	}
+	mem := NewMemoryStore(10)
+	for i := range reqs {
+		reqs[i].Model, reqs[i].Endpoint = "llama3", "chat"
+		if err := sqlite.Insert(&reqs[i]); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+		mem.Insert(&reqs[i])
+	}
+
+	// UTC+5:30 exercises a non-hour offset.
+	offset := 330 * time.Minute
+	zone := time.FixedZone("", int(offset.Seconds()))
+	slot := func(ts int64) (int, int) {
+		t := time.UnixMilli(ts).In(zone)
+		return int(t.Weekday()), t.Hour()
+	}
+	bd, bh := slot(busy)
+	qd, qh := slot(quiet)
+
+	for name, store := range map[string]Store{"sqlite": sqlite, "memory": mem} {
+		p, err := store.UsagePattern(28*24*time.Hour, offset)
+		if err != nil {
+			t.Fatalf("%s: UsagePattern error: %v", name, err)
+		}
+		if c := p.Cells[bd][bh]; c.RequestCount != 3 || c.DurationP95Ms != 300 {
+			t.Errorf("%s: busy cell = %+v, want 3 requests, p95 300", name, c)
+		}
+		if c := p.Cells[qd][qh]; c.RequestCount != 1 || c.DurationP95Ms != 50 {
+			t.Errorf("%s: quiet cell = %+v, want 1 request, p95 50", name, c)
+		}
+		total := 0
+		for _, c := range p.ByHour {
+			total += c.RequestCount
+		}
+		if total != 4 {
+			t.Errorf("%s: counted %d requests, want 4 (old one is outside the window)", name, total)
+		}
+		if p.ByWeekday[bd].RequestCount != 3 || p.ByHour[bh].DurationP95Ms != 300 {
+			t.Errorf("%s: unexpected totals %+v / %+v", name, p.ByWeekday[bd], p.ByHour[bh])
+		}
+	}
+}
+
+func TestMetricSnapshots(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sqlite, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer sqlite.Close()
+
+	now := time.Now()
+	snaps := []MetricSnapshot{
+		{TS: now.Add(-10 * 24 * time.Hour).UnixMilli(), Values: map[string]float64{"oac_requests_total": 1}},
+		{TS: now.Add(-2 * time.Hour).UnixMilli(), Values: map[string]float64{"oac_requests_total": 5, "oac_requests_in_flight": 2}},
+		{TS: now.Add(-time.Hour).UnixMilli(), Values: map[string]float64{"oac_requests_total": 9, "rate(oac_requests_total)": 0.5}},
+	}
+	for name, store := range map[string]Store{"sqlite": sqlite, "memory": NewMemoryStore(10)} {
+		for _, snap := range snaps {
+			if err := store.InsertMetricSnapshot(snap); err != nil {
+				t.Fatalf("%s: InsertMetricSnapshot error: %v", name, err)
+			}
+		}
+
+		got, err := store.MetricSnapshots(7*24*time.Hour, nil)
+		if err != nil {
+			t.Fatalf("%s: MetricSnapshots error: %v", name, err)
+		}
+		if len(got) != 2 || got[0].TS != snaps[1].TS || got[1].TS != snaps[2].TS {
+			t.Fatalf("%s: expected the two recent snapshots oldest first, got %+v", name, got)
+		}
+		if got[0].Values["oac_requests_in_flight"] != 2 || got[1].Values["rate(oac_requests_total)"] != 0.5 {
+			t.Fatalf("%s: unexpected values %+v", name, got)
+		}
+
+		got, err = store.MetricSnapshots(7*24*time.Hour, []string{"oac_requests_in_flight"})
+		if err != nil {
+			t.Fatalf("%s: MetricSnapshots error: %v", name, err)
+		}
+		if len(got) != 1 || len(got[0].Values) != 1 || got[0].Values["oac_requests_in_flight"] != 2 {
+			t.Fatalf("%s: expected only the in-flight gauge, got %+v", name, got)
+		}
+
+		n, err := store.PruneMetricSnapshots(now.Add(-90 * time.Minute).UnixMilli())
+		if err != nil {
+			t.Fatalf("%s: PruneMetricSnapshots error: %v", name, err)
+		}
+		if n != 2 {
+			t.Fatalf("%s: pruned %d snapshots, want 2", name, n)
+		}
+		got, _ = store.MetricSnapshots(30*24*time.Hour, nil)
+		if len(got) != 1 || got[0].TS != snaps[2].TS {
+			t.Fatalf("%s: expected only the newest snapshot after pruning, got %+v", name, got)
+		}
+	}
+}
+
+func TestSQLiteStore_LatencyPhases(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewSQLiteStore(filepath.Join(tmpDir, "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Insert(&Request{ID: "r1", TSStart: time.Now().UnixMilli(), Status: StatusInFlight, Model: "llama3", Endpoint: "chat"}); err != nil {
+		t.Fatalf("Insert error: %v", err)
+	}
+	estimateMs, forwardMs, doneMs, totalMs := 12, 15, 480, 420
+	if err := store.Update("r1", RequestUpdate{EstimateMs: &estimateMs, ForwardMs: &forwardMs, UpstreamDoneMs: &doneMs, UpstreamTotalMs: &totalMs}); err != nil {
+		t.Fatalf("Update error: %v", err)
+	}
+
+	got, err := store.GetByID("r1")
+	if err != nil {
+		t.Fatalf("GetByID error: %v", err)
+	}
+	if got.EstimateMs != 12 || got.ForwardMs != 15 || got.UpstreamDoneMs != 480 || got.UpstreamTotalMs != 420 {
+		t.Fatalf("unexpected phases %+v", got)
+	}
+}
+
+func TestSQLiteStore_TagQuotaUsage(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), 1000, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore error: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.SaveTagQuotaUsage(1000, map[string]TagQuotaUsage{"team=search": {Tokens: 10, Requests: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveTagQuotaUsage(1000, map[string]TagQuotaUsage{"team=search": {Tokens: 30, Requests: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.LoadTagQuotaUsage(1000)
+	if err != nil || got["team=search"] != (TagQuotaUsage{Tokens: 30, Requests: 2}) {
+		t.Fatalf("usage = %v, %v", got, err)
+	}
+
+	// Saving a later window drops the earlier one.
+	if err := store.SaveTagQuotaUsage(2000, map[string]TagQuotaUsage{"team=ads": {Tokens: 5, Requests: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.LoadTagQuotaUsage(1000); len(got) != 0 {
+		t.Errorf("earlier window kept: %v", got)
+	}
+	if got, _ := store.LoadTagQuotaUsage(2000); got["team=ads"].Tokens != 5 {
+		t.Errorf("later window = %v", got)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"log/slog"
 	"time"
+
+	"ollama-auto-ctx/internal/calibration"
 )
 
 // SQLiteStore implements Store using SQLite with WAL mode.
@@ -58,6 +60,76 @@ func (s *SQLiteStore) InFlightCount() (int, error) {
 	return 0, errors.New("SQLite storage not available")
 }
 
+// LatencyCompliance counts requests within a latency threshold.
+func (s *SQLiteStore) LatencyCompliance(window, threshold time.Duration) (int, int, error) {
+	return 0, 0, errors.New("SQLite storage not available")
+}
+
+// CompareModels returns side-by-side model stats.
+func (s *SQLiteStore) CompareModels(window time.Duration, models []string) ([]ModelComparison, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// TagStats groups requests in the window by their value for tag key.
+func (s *SQLiteStore) TagStats(window time.Duration, key string) ([]TagStat, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// TopFingerprints returns the most repeated fingerprints.
+func (s *SQLiteStore) TopFingerprints(window time.Duration, limit int) ([]FingerprintStat, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// CostStats sums request costs per model or tag value.
+func (s *SQLiteStore) CostStats(window time.Duration, key string) ([]CostStat, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// UsagePattern buckets requests by weekday and hour of day.
+func (s *SQLiteStore) UsagePattern(window, utcOffset time.Duration) (*UsagePattern, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// InsertMetricSnapshot stores one periodic metrics snapshot.
+func (s *SQLiteStore) InsertMetricSnapshot(snap MetricSnapshot) error {
+	return errors.New("SQLite storage not available")
+}
+
+// MetricSnapshots returns the snapshots taken in the window.
+func (s *SQLiteStore) MetricSnapshots(window time.Duration, names []string) ([]MetricSnapshot, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// PruneMetricSnapshots deletes snapshots taken before the cutoff.
+func (s *SQLiteStore) PruneMetricSnapshots(before int64) (int, error) {
+	return 0, errors.New("SQLite storage not available")
+}
+
+// LoadCalibration returns the calibration parameters stored per model.
+func (s *SQLiteStore) LoadCalibration() (map[string]calibration.Params, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// SaveCalibration upserts the given per-model parameters.
+func (s *SQLiteStore) SaveCalibration(models map[string]calibration.Params) error {
+	return errors.New("SQLite storage not available")
+}
+
+// LoadTagQuotaUsage returns the per-tag quota usage stored for a window.
+func (s *SQLiteStore) LoadTagQuotaUsage(windowStart int64) (map[string]TagQuotaUsage, error) {
+	return nil, errors.New("SQLite storage not available")
+}
+
+// SaveTagQuotaUsage upserts the usage of a window.
+func (s *SQLiteStore) SaveTagQuotaUsage(windowStart int64, usage map[string]TagQuotaUsage) error {
+	return errors.New("SQLite storage not available")
+}
+
+// Backup copies the database to path.
+func (s *SQLiteStore) Backup(path string) (int64, error) {
+	return 0, errors.New("SQLite storage not available")
+}
+
 // Close closes the database connection.
 func (s *SQLiteStore) Close() error {
 	return nil
@@ -3,6 +3,7 @@
 package storage
 
 import (
+	"errors"
 	"time"
 )
 
@@ -24,9 +25,27 @@ const (
 	ReasonTimeoutTTFB       Reason = "timeout_ttfb"
 	ReasonTimeoutStall      Reason = "timeout_stall"
 	ReasonTimeoutHard       Reason = "timeout_hard"
+	ReasonTimeoutGlobal     Reason = "timeout_global" // GLOBAL_REQUEST_TIMEOUT expired
 	ReasonUpstreamError     Reason = "upstream_error"
 	ReasonLoopDetected      Reason = "loop_detected"
 	ReasonOutputLimitExceeded Reason = "output_limit_exceeded"
+	ReasonEmptyResponse       Reason = "empty_response" // 200 with eval_count below RETRY_MIN_EVAL_COUNT
+	ReasonInvalidImages       Reason = "invalid_images" // rejected by IMAGE_VALIDATION=reject
+	ReasonShowTimeout         Reason = "show_timeout"   // rejected by SHOW_TIMEOUT_POLICY=fail_fast
+	ReasonImageBudgetExceeded Reason = "image_budget_exceeded" // rejected by IMAGE_BUDGET_POLICY=reject
+	ReasonOptionsUnfiltered   Reason = "options_unfiltered"    // rejected because OPTIONS_ALLOWLIST couldn't be applied
+	ReasonModelBusy           Reason = "model_busy"            // rejected because the model was at MODEL_MAX_CONCURRENCY
+	ReasonIdempotencyMismatch Reason = "idempotency_mismatch"  // rejected because its Idempotency-Key was used for another body
+	ReasonPromptTooLarge      Reason = "prompt_too_large"      // rejected because its estimated prompt exceeded MAX_PROMPT_TOKENS
+	ReasonQuotaExceeded       Reason = "quota_exceeded"        // rejected because a TAG_QUOTAS quota of one of its tags was exhausted
+	ReasonPromptRejected      Reason = "prompt_rejected"       // rejected because its prompt matched a PROMPT_SCAN_FILE reject rule
+	ReasonVRAMInsufficient    Reason = "vram_insufficient"     // rejected because its model didn't fit in the free VRAM (VRAM_GATE_ENABLED)
+)
+
+// Outcomes of LOOP_RETRY_ENABLED, stored in Request.LoopRetry.
+const (
+	LoopRetryRescued = "rescued" // a resend came back without looping
+	LoopRetryLooped  = "looped"  // every resend looped too (or failed)
 )
 
 // Request represents a single request's telemetry data.
@@ -64,6 +83,15 @@ type Request struct {
 	UpstreamLoadMs       int `json:"upstream_load_ms"`
 	UpstreamPromptEvalMs int `json:"upstream_prompt_eval_ms"`
 	UpstreamEvalMs       int `json:"upstream_eval_ms"`
+	// Proxy phases, from arrival: EstimateMs spent reading and sizing the
+	// body, ForwardMs until it was handed to the upstream, UpstreamDoneMs
+	// until the upstream's last byte arrived (0 when unknown). LoadWaitMs,
+	// part of ForwardMs, was spent held for another request's load of the
+	// model (LOAD_COALESCE_ENABLED).
+	EstimateMs     int `json:"estimate_ms,omitempty"`
+	ForwardMs      int `json:"forward_ms,omitempty"`
+	UpstreamDoneMs int `json:"upstream_done_ms,omitempty"`
+	LoadWaitMs     int `json:"load_wait_ms,omitempty"`
 
 	// Bytes
 	ClientInBytes    int64 `json:"client_in_bytes"`
@@ -73,8 +101,77 @@ type Request struct {
 
 	// Reliability
 	RetryCount         int    `json:"retry_count"`
+	// LoadingRetries counts the retries (of RetryCount) made because the
+	// model was still loading.
+	LoadingRetries     int    `json:"loading_retries,omitempty"`
+	// LoopRetry is how a non-streaming response that looped was retried:
+	// LoopRetryRescued or LoopRetryLooped (empty when it didn't loop).
+	LoopRetry          string `json:"loop_retry,omitempty"`
+	// FallbackModel is the MODEL_FALLBACK model that served the request
+	// after Model failed (empty when Model served it).
+	FallbackModel      string `json:"fallback_model,omitempty"`
+	// PromptScan lists the PROMPT_SCAN_FILE rules the prompt matched,
+	// comma-separated.
+	PromptScan         string `json:"prompt_scan,omitempty"`
+	// TimeoutProfile is the TIMEOUT_PROFILES profile the watchdog held the
+	// request to (empty for the global timeouts).
+	TimeoutProfile     string `json:"timeout_profile,omitempty"`
 	UpstreamHTTPStatus int    `json:"upstream_http_status"`
 	ErrorClass         string `json:"error_class,omitempty"`
+
+	// Think decision: effective verdict and where it came from (client|directive|default)
+	ThinkVerdict string `json:"think_verdict,omitempty"`
+	ThinkSource  string `json:"think_source,omitempty"`
+	// ThinkValue is the JSON-encoded "think" field sent upstream (e.g. true or "high").
+	ThinkValue string `json:"think_value,omitempty"`
+
+	// OptionsJSON is the client's options object as sent (redacted per config), or empty.
+	OptionsJSON string `json:"options_json,omitempty"`
+	// StrippedOptions lists option keys removed by OPTIONS_ALLOWLIST, comma-separated.
+	StrippedOptions string `json:"stripped_options,omitempty"`
+	// InvalidImages counts image entries that were empty or not valid base64
+	// (only checked when IMAGE_VALIDATION is enabled).
+	InvalidImages int `json:"invalid_images,omitempty"`
+	// ImagesDropped counts images removed by IMAGE_BUDGET_POLICY=drop so the
+	// rest fit the context.
+	ImagesDropped int `json:"images_dropped,omitempty"`
+	// Family is the model family the proxy classified the model as (see internal/family).
+	Family string `json:"family,omitempty"`
+	// Tags are client-supplied key=value pairs (X-AutoCtx-Tags) in canonical
+	// FormatTags form, e.g. "project=rag,team=search".
+	Tags string `json:"tags,omitempty"`
+	// InjectedSeed is the options.seed the proxy added per SEED_POLICY, or nil
+	// when the request was forwarded with the client's seed (or none).
+	InjectedSeed *int64 `json:"injected_seed,omitempty"`
+	// Shadow is the result of mirroring this request to SHADOW_UPSTREAM_URL,
+	// or nil when it wasn't mirrored.
+	Shadow *ShadowResult `json:"shadow,omitempty"`
+	// Unsupervised is set when X-AutoCtx-No-Supervise turned off the
+	// watchdog, loop detection and output limit for this request.
+	Unsupervised bool `json:"unsupervised,omitempty"`
+	// TruncationSuspected is set when PromptTokens exceeded CtxSelected minus
+	// OutputBudget, i.e. the prompt was likely truncated by the upstream.
+	TruncationSuspected bool `json:"truncation_suspected,omitempty"`
+	// Fingerprint is a hash of the normalized request body, stored with
+	// STORE_REQUEST_FINGERPRINT; equal fingerprints mean repeated requests.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Cost is what the request's tokens cost at MODEL_INPUT_TOKEN_PRICES and
+	// MODEL_OUTPUT_TOKEN_PRICES; CostEstimated is set when the upstream's
+	// token counts were missing, so estimates were priced instead.
+	Cost          float64 `json:"cost,omitempty"`
+	CostEstimated bool    `json:"cost_estimated,omitempty"`
+}
+
+// ShadowResult describes how the shadow upstream answered a mirrored request,
+// for comparison with the primary fields of the same record.
+type ShadowResult struct {
+	HTTPStatus       int `json:"http_status,omitempty"`
+	DurationMs       int `json:"duration_ms"`
+	CompletionTokens int `json:"completion_tokens"`
+	// OutputMatch reports whether the shadow's output text equals the
+	// primary's; nil when either side's output wasn't captured.
+	OutputMatch *bool  `json:"output_match,omitempty"`
+	Error       string `json:"error,omitempty"`
 }
 
 // RequestUpdate contains fields that can be updated after insert.
@@ -94,12 +191,27 @@ type RequestUpdate struct {
 	UpstreamLoadMs       *int
 	UpstreamPromptEvalMs *int
 	UpstreamEvalMs       *int
+	EstimateMs           *int
+	ForwardMs            *int
+	UpstreamDoneMs       *int
+	LoadWaitMs           *int
 	ClientOutBytes       *int64
 	UpstreamInBytes      *int64
 	UpstreamOutBytes     *int64
 	RetryCount           *int
+	LoadingRetries       *int
+	LoopRetry            *string
+	FallbackModel        *string
 	UpstreamHTTPStatus   *int
 	ErrorClass           *string
+	ThinkVerdict         *string
+	ThinkSource          *string
+	ThinkValue           *string
+	ImagesDropped        *int
+	Shadow               *ShadowResult
+	TruncationSuspected  *bool
+	Cost                 *float64
+	CostEstimated        *bool
 }
 
 // ListOptions filters for listing requests.
@@ -109,6 +221,8 @@ type ListOptions struct {
 	Status *Status
 	Model  string
 	Reason *Reason
+	Tag    string        // "key=value"; only requests carrying this tag
+	Shadow bool          // only requests with a shadow result
 	Window time.Duration // only requests within this window
 }
 
@@ -125,6 +239,9 @@ type Overview struct {
 	Retries       int     `json:"retries"`
 	Timeouts      int     `json:"timeouts"`
 	Loops         int     `json:"loops"`
+	// StatusCodes counts requests by upstream HTTP status; requests that never
+	// got an upstream response are left out.
+	StatusCodes map[int]int `json:"status_codes,omitempty"`
 }
 
 // ModelStat contains per-model rollup statistics.
@@ -180,10 +297,55 @@ type Store interface {
 	// InFlightCount returns the number of in-flight requests.
 	InFlightCount() (int, error)
 
+	// LatencyCompliance counts completed requests in the window (total) and the
+	// successful ones that finished within threshold (good).
+	LatencyCompliance(window, threshold time.Duration) (good, total int, err error)
+
+	// CompareModels returns side-by-side stats for the given models over the
+	// window, one entry per model in the order given.
+	CompareModels(window time.Duration, models []string) ([]ModelComparison, error)
+
+	// TagStats groups requests in the window by their value for tag key,
+	// busiest first. Requests without the tag are left out.
+	TagStats(window time.Duration, key string) ([]TagStat, error)
+
+	// TopFingerprints returns up to limit fingerprints that more than one
+	// request in the window carried, most repeated first.
+	TopFingerprints(window time.Duration, limit int) ([]FingerprintStat, error)
+
+	// CostStats sums the cost of requests in the window per model, or per
+	// value of tag key when key is not empty, costliest first. Requests
+	// without the tag are left out.
+	CostStats(window time.Duration, key string) ([]CostStat, error)
+
+	// UsagePattern buckets requests in the window by weekday and hour of day
+	// in the time zone utcOffset east of UTC.
+	UsagePattern(window, utcOffset time.Duration) (*UsagePattern, error)
+
+	// InsertMetricSnapshot stores one periodic metrics snapshot.
+	InsertMetricSnapshot(snap MetricSnapshot) error
+
+	// MetricSnapshots returns the snapshots taken in the window, oldest first,
+	// with values restricted to names (all values when names is empty).
+	MetricSnapshots(window time.Duration, names []string) ([]MetricSnapshot, error)
+
+	// PruneMetricSnapshots deletes snapshots taken before the cutoff (Unix ms)
+	// and returns how many were removed.
+	PruneMetricSnapshots(before int64) (int, error)
+
+	// Backup writes a consistent copy of the store to path, which must not
+	// exist, and returns its size in bytes. Stores without an on-disk form
+	// return ErrBackupUnsupported.
+	Backup(path string) (int64, error)
+
 	// Close releases resources.
 	Close() error
 }
 
+// ErrBackupUnsupported is returned by Backup for stores that cannot be copied
+// to a file (use List for a dump instead).
+var ErrBackupUnsupported = errors.New("backup not supported by this store")
+
 // GetBinConfig returns the number of bins and interval for a time window.
 // Used for consistent chart layouts.
 func GetBinConfig(window time.Duration) (bins int, interval time.Duration) {
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -31,3 +33,201 @@ func TestGetBinConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLatencyBreakdown(t *testing.T) {
+	if (&Request{}).LatencyBreakdown() != nil {
+		t.Fatal("expected no breakdown before the request finished")
+	}
+
+	req := &Request{
+		DurationMs:           1000,
+		EstimateMs:           20,
+		ForwardMs:            30,
+		UpstreamDoneMs:       960,
+		UpstreamTotalMs:      900,
+		UpstreamLoadMs:       300,
+		UpstreamPromptEvalMs: 100,
+		UpstreamEvalMs:       450,
+	}
+	got := req.LatencyBreakdown()
+	want := LatencyBreakdown{TotalMs: 1000, EstimationMs: 20, QueueMs: 50, LoadMs: 300, PromptEvalMs: 100, GenerationMs: 450, NetworkMs: 30, TapMs: 40, OtherMs: 10}
+	if *got != want {
+		t.Fatalf("breakdown = %+v, want %+v", *got, want)
+	}
+
+	// Without proxy phases (no tracker) the rest is other.
+	req = &Request{DurationMs: 500, UpstreamTotalMs: 400, UpstreamEvalMs: 350}
+	got = req.LatencyBreakdown()
+	if got.GenerationMs != 350 || got.QueueMs != 50 || got.NetworkMs != 0 || got.TapMs != 0 || got.OtherMs != 100 {
+		t.Fatalf("unexpected breakdown without phases %+v", *got)
+	}
+
+	// Components from different clocks never add up past the total.
+	req = &Request{DurationMs: 100, EstimateMs: 10, UpstreamLoadMs: 80, UpstreamEvalMs: 50, ForwardMs: 5, UpstreamDoneMs: 99}
+	got = req.LatencyBreakdown()
+	sum := got.EstimationMs + got.QueueMs + got.LoadMs + got.PromptEvalMs + got.GenerationMs + got.NetworkMs + got.TapMs + got.OtherMs
+	if sum != 100 || got.GenerationMs != 10 || got.OtherMs != 0 {
+		t.Fatalf("expected phases capped to the total, got %+v (sum %d)", *got, sum)
+	}
+}
+
+func TestSampledStore(t *testing.T) {
+	inner := NewMemoryStore(100)
+	dropped := 0
+	s := NewSampledStore(inner, 0.5, func() { dropped++ })
+	draws := []float64{0.9, 0.9, 0.1}
+	s.sample = func() float64 { v := draws[0]; draws = draws[1:]; return v }
+
+	finish := func(id string, status Status) {
+		t.Helper()
+		ttfb := 12
+		if err := s.Update(id, RequestUpdate{TTFBMs: &ttfb}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Update(id, RequestUpdate{Status: &status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"ok-out", "err-out", "ok-in"} {
+		if err := s.Insert(&Request{ID: id, Status: StatusInFlight, Model: "llama3"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sampled-out requests aren't written while in flight.
+	if req, _ := inner.GetByID("ok-out"); req != nil {
+		t.Fatal("sampled-out request persisted before it finished")
+	}
+	finish("ok-out", StatusSuccess)
+	finish("err-out", StatusError)
+	finish("ok-in", StatusSuccess)
+
+	if req, _ := inner.GetByID("ok-out"); req != nil || dropped != 1 {
+		t.Errorf("sampled-out success should be dropped, got %+v (dropped=%d)", req, dropped)
+	}
+	if req, _ := inner.GetByID("err-out"); req == nil || req.Status != StatusError || req.TTFBMs != 12 || req.Model != "llama3" {
+		t.Errorf("sampled-out error should be persisted with its updates, got %+v", req)
+	}
+	if req, _ := inner.GetByID("ok-in"); req == nil || req.Status != StatusSuccess || req.TTFBMs != 12 {
+		t.Errorf("sampled-in success should be persisted, got %+v", req)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("expected no held requests, got %d", len(s.pending))
+	}
+}
+
+func TestSampledStoreUnfinished(t *testing.T) {
+	inner := NewMemoryStore(100)
+	s := NewSampledStore(inner, 0, nil)
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+
+	if err := s.Insert(&Request{ID: "stale", Status: StatusInFlight}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.InFlightCount(); n != 1 {
+		t.Errorf("InFlightCount = %d, want the held request counted", n)
+	}
+
+	// A request that never gets a final status is persisted once it's old.
+	now = now.Add(maxSampledAge + time.Minute)
+	if err := s.Insert(&Request{ID: "fresh", Status: StatusInFlight}); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := inner.GetByID("stale"); req == nil {
+		t.Error("stale held request should have been persisted")
+	}
+	if n, _ := s.InFlightCount(); n != 2 {
+		t.Errorf("InFlightCount = %d, want 2", n)
+	}
+	// Its final status then goes straight to the underlying store.
+	status := StatusError
+	if err := s.Update("stale", RequestUpdate{Status: &status}); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := inner.GetByID("stale"); req == nil || req.Status != StatusError {
+		t.Errorf("late update not applied, got %+v", req)
+	}
+
+	// Close drains whatever is still held.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := inner.GetByID("fresh"); req == nil {
+		t.Error("held request lost on Close")
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("expected no held requests after Close, got %d", len(s.pending))
+	}
+}
+
+// failingStore fails every write while fail is set.
+type failingStore struct {
+	*MemoryStore
+	fail bool
+}
+
+func (s *failingStore) Insert(req *Request) error {
+	if s.fail {
+		return errors.New("disk I/O error")
+	}
+	return s.MemoryStore.Insert(req)
+}
+
+func (s *failingStore) Update(id string, upd RequestUpdate) error {
+	if s.fail {
+		return errors.New("disk I/O error")
+	}
+	return s.MemoryStore.Update(id, upd)
+}
+
+func TestBreakerStore(t *testing.T) {
+	inner := &failingStore{MemoryStore: NewMemoryStore(100), fail: true}
+	var changes []bool
+	s := NewBreakerStore(inner, BreakerConfig{Threshold: 3, Cooldown: time.Minute}, nil, func(d bool) { changes = append(changes, d) })
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	// Errors are returned until the threshold trips the breaker.
+	for i := 0; i < 3; i++ {
+		if err := s.Insert(&Request{ID: fmt.Sprintf("r%d", i)}); err == nil {
+			t.Fatalf("write %d: expected the store's error", i)
+		}
+	}
+	if !s.Degraded() || len(changes) != 1 || !changes[0] {
+		t.Fatalf("expected breaker to open after 3 errors, degraded=%v changes=%v", s.Degraded(), changes)
+	}
+	// While open, writes are skipped without error.
+	if err := s.Insert(&Request{ID: "skipped"}); err != nil {
+		t.Errorf("expected skipped insert to succeed, got %v", err)
+	}
+
+	// Updates never probe, even after the cooldown.
+	inner.fail = false
+	now = now.Add(2 * time.Minute)
+	if err := s.Update("r0", RequestUpdate{}); err != nil || !s.Degraded() {
+		t.Errorf("update should be skipped without probing, err=%v degraded=%v", err, s.Degraded())
+	}
+	// A failing probe keeps the breaker open until the next cooldown.
+	inner.fail = true
+	if err := s.Insert(&Request{ID: "probe1"}); err != nil || !s.Degraded() {
+		t.Errorf("failed probe should stay degraded, err=%v degraded=%v", err, s.Degraded())
+	}
+	inner.fail = false
+	if err := s.Insert(&Request{ID: "early"}); err != nil || !s.Degraded() {
+		t.Errorf("insert before the next cooldown should be skipped, err=%v degraded=%v", err, s.Degraded())
+	}
+	if req, _ := inner.GetByID("early"); req != nil {
+		t.Error("insert during cooldown reached the store")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := s.Insert(&Request{ID: "probe2"}); err != nil || s.Degraded() {
+		t.Fatalf("successful probe should close the breaker, err=%v degraded=%v", err, s.Degraded())
+	}
+	if req, _ := inner.GetByID("probe2"); req == nil {
+		t.Error("probe insert was not persisted")
+	}
+	if len(changes) != 2 || changes[1] {
+		t.Errorf("expected degraded then recovered, got %v", changes)
+	}
+}
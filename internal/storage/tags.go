@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// TagStat holds request stats for one value of a tag key (e.g. team=search).
+type TagStat struct {
+	Value            string  `json:"value"`
+	RequestCount     int     `json:"request_count"`
+	SuccessRate      float64 `json:"success_rate"`
+	AvgDurationMs    int     `json:"avg_duration_ms"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+}
+
+// TagQuotaUsage is what requests carrying one tag were charged against the
+// tag's quota in one quota window.
+type TagQuotaUsage struct {
+	Tokens   int64 `json:"tokens"`
+	Requests int64 `json:"requests"`
+}
+
+// FormatTags renders tags in the canonical stored form: key=value pairs
+// sorted by key and joined with commas.
+func FormatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseTags splits a stored tag string back into a map.
+func ParseTags(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether the stored tag string contains the "key=value" pair.
+func HasTag(tags, pair string) bool {
+	return strings.Contains(","+tags+",", ","+pair+",")
+}
+
+type tagAccumulator struct {
+	count, success      int
+	durationSum         int
+	promptTok, complTok int
+}
+
+// addTagStat adds req to the accumulator for its value of key, if it has one.
+func addTagStat(accs map[string]*tagAccumulator, key string, req *Request) {
+	value, ok := ParseTags(req.Tags)[key]
+	if !ok {
+		return
+	}
+	acc := accs[value]
+	if acc == nil {
+		acc = &tagAccumulator{}
+		accs[value] = acc
+	}
+	acc.count++
+	if req.Status == StatusSuccess {
+		acc.success++
+	}
+	acc.durationSum += req.DurationMs
+	acc.promptTok += req.PromptTokens
+	acc.complTok += req.CompletionTokens
+}
+
+// tagResults returns one TagStat per value, busiest first.
+func tagResults(accs map[string]*tagAccumulator) []TagStat {
+	out := make([]TagStat, 0, len(accs))
+	for value, acc := range accs {
+		out = append(out, TagStat{
+			Value:            value,
+			RequestCount:     acc.count,
+			SuccessRate:      float64(acc.success) / float64(acc.count),
+			AvgDurationMs:    acc.durationSum / acc.count,
+			PromptTokens:     acc.promptTok,
+			CompletionTokens: acc.complTok,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].RequestCount != out[j].RequestCount {
+			return out[i].RequestCount > out[j].RequestCount
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out
+}
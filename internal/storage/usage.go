@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// UsageCell holds request stats for one slot of a UsagePattern.
+type UsageCell struct {
+	RequestCount  int `json:"request_count"`
+	DurationP95Ms int `json:"duration_p95_ms"` // over completed requests
+}
+
+// UsagePattern buckets requests by local weekday and hour of day, for a
+// heatmap of peak hours. Weekdays run from Sunday (0) to Saturday (6).
+type UsagePattern struct {
+	Cells     [7][24]UsageCell `json:"cells"` // [weekday][hour]
+	ByHour    [24]UsageCell    `json:"by_hour"`
+	ByWeekday [7]UsageCell     `json:"by_weekday"`
+}
+
+// usageAccumulator builds a UsagePattern from request rows. It is shared by
+// both stores so the buckets and percentiles stay identical.
+type usageAccumulator struct {
+	counts    [7][24]int
+	durations [7][24][]int
+}
+
+// add records a request started at weekday/hour; completed is false for
+// in-flight requests, which count but have no duration yet.
+func (a *usageAccumulator) add(weekday, hour, durationMs int, completed bool) {
+	if weekday < 0 || weekday > 6 || hour < 0 || hour > 23 {
+		return
+	}
+	a.counts[weekday][hour]++
+	if completed {
+		a.durations[weekday][hour] = append(a.durations[weekday][hour], durationMs)
+	}
+}
+
+// addTime records a request started at tsStart (Unix ms), shifted by utcOffset.
+func (a *usageAccumulator) addTime(tsStart int64, utcOffset time.Duration, durationMs int, completed bool) {
+	t := time.UnixMilli(tsStart).UTC().Add(utcOffset)
+	a.add(int(t.Weekday()), t.Hour(), durationMs, completed)
+}
+
+func (a *usageAccumulator) result() *UsagePattern {
+	p := &UsagePattern{}
+	var byHour [24][]int
+	var byWeekday [7][]int
+	for d := 0; d < 7; d++ {
+		for h := 0; h < 24; h++ {
+			durs := a.durations[d][h]
+			p.Cells[d][h] = UsageCell{RequestCount: a.counts[d][h], DurationP95Ms: p95(durs)}
+			p.ByHour[h].RequestCount += a.counts[d][h]
+			p.ByWeekday[d].RequestCount += a.counts[d][h]
+			byHour[h] = append(byHour[h], durs...)
+			byWeekday[d] = append(byWeekday[d], durs...)
+		}
+	}
+	for h := range byHour {
+		p.ByHour[h].DurationP95Ms = p95(byHour[h])
+	}
+	for d := range byWeekday {
+		p.ByWeekday[d].DurationP95Ms = p95(byWeekday[d])
+	}
+	return p
+}
+
+// p95 returns the 95th percentile of vals (0 when empty), sorting vals in place.
+func p95(vals []int) int {
+	if len(vals) == 0 {
+		return 0
+	}
+	sort.Ints(vals)
+	idx := int(float64(len(vals)) * 0.95)
+	if idx >= len(vals) {
+		idx = len(vals) - 1
+	}
+	return vals[idx]
+}
@@ -0,0 +1,146 @@
+package supervisor
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DivergenceAnomaly describes a model whose prompt estimates have drifted from
+// what Ollama reports, usually after a model update changed tokenization.
+type DivergenceAnomaly struct {
+	Model   string    `json:"model"`
+	Ratio   float64   `json:"ratio"` // rolling median of actual/estimated
+	Samples int       `json:"samples"`
+	Since   time.Time `json:"since"`
+}
+
+// DivergenceDetector watches the rolling actual/estimated prompt token ratio
+// per model and flags models whose median ratio leaves [1/(1+threshold), 1+threshold].
+// It is safe for concurrent use; a nil detector ignores observations.
+type DivergenceDetector struct {
+	mu        sync.Mutex
+	window    int
+	threshold float64
+	models    map[string]*divergenceState
+	eventBus  *EventBus
+	metrics   *Metrics
+	logger    *slog.Logger
+}
+
+type divergenceState struct {
+	ratios []float64 // circular buffer of the last window observations
+	next   int
+	count  int
+	active bool
+	since  time.Time
+	median float64
+}
+
+// NewDivergenceDetector creates a detector over the last window observations per model.
+func NewDivergenceDetector(window int, threshold float64, eventBus *EventBus, metrics *Metrics, logger *slog.Logger) *DivergenceDetector {
+	if window < 1 {
+		window = 1
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DivergenceDetector{
+		window:    window,
+		threshold: threshold,
+		models:    make(map[string]*divergenceState),
+		eventBus:  eventBus,
+		metrics:   metrics,
+		logger:    logger,
+	}
+}
+
+// Observe records one request's estimated and actual prompt tokens.
+// Detection only starts once the model has a full window of observations.
+func (d *DivergenceDetector) Observe(model string, estimated, actual int) {
+	if d == nil || model == "" || estimated <= 0 || actual <= 0 {
+		return
+	}
+	ratio := float64(actual) / float64(estimated)
+
+	d.mu.Lock()
+	st, ok := d.models[model]
+	if !ok {
+		st = &divergenceState{ratios: make([]float64, d.window)}
+		d.models[model] = st
+	}
+	st.ratios[st.next] = ratio
+	st.next = (st.next + 1) % d.window
+	if st.count < d.window {
+		st.count++
+	}
+	if st.count < d.window {
+		d.mu.Unlock()
+		return
+	}
+
+	st.median = medianOf(st.ratios)
+	diverged := st.median > 1+d.threshold || st.median < 1/(1+d.threshold)
+	fired := diverged && !st.active
+	resolved := !diverged && st.active
+	st.active = diverged
+	if fired {
+		st.since = time.Now()
+	}
+	median := st.median
+	d.mu.Unlock()
+
+	d.metrics.SetEstimateRatio(model, median)
+
+	if fired {
+		d.metrics.RecordEstimateDivergence(model)
+		d.logger.Warn("prompt estimates diverging from actuals; model may need recalibration",
+			"model", model, "ratio", median, "window", d.window)
+		if d.eventBus != nil {
+			d.eventBus.Publish(Event{
+				Type:      EventEstimateDivergence,
+				Timestamp: time.Now(),
+				Model:     model,
+				Ratio:     median,
+			})
+		}
+	}
+	if resolved {
+		d.logger.Info("prompt estimate divergence resolved", "model", model, "ratio", median)
+	}
+}
+
+// Active returns the models currently flagged as diverging, sorted by model.
+func (d *DivergenceDetector) Active() []DivergenceAnomaly {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []DivergenceAnomaly
+	for model, st := range d.models {
+		if !st.active {
+			continue
+		}
+		out = append(out, DivergenceAnomaly{
+			Model:   model,
+			Ratio:   st.median,
+			Samples: st.count,
+			Since:   st.since,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
+
+func medianOf(vals []float64) float64 {
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
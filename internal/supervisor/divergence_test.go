@@ -0,0 +1,88 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDivergenceDetector_FiresAndResolves(t *testing.T) {
+	bus := NewEventBus(10)
+	defer bus.Shutdown()
+	ch := bus.Subscribe()
+	defer bus.Unsubscribe(ch)
+
+	d := NewDivergenceDetector(4, 0.3, bus, nil, nil)
+
+	// Window not full yet: no verdict even though every ratio is off.
+	for i := 0; i < 3; i++ {
+		d.Observe("llama2", 100, 200)
+	}
+	if got := d.Active(); len(got) != 0 {
+		t.Fatalf("expected no anomalies before window fills, got %+v", got)
+	}
+
+	d.Observe("llama2", 100, 200)
+	active := d.Active()
+	if len(active) != 1 || active[0].Model != "llama2" || active[0].Ratio != 2 {
+		t.Fatalf("expected llama2 diverging at ratio 2, got %+v", active)
+	}
+
+	select {
+	case ev := <-ch:
+		if ev.Type != EventEstimateDivergence || ev.Model != "llama2" || ev.Ratio != 2 {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected divergence event")
+	}
+
+	// Staying diverged must not publish again.
+	d.Observe("llama2", 100, 200)
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected repeat event %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Accurate estimates push the median back inside the band.
+	for i := 0; i < 4; i++ {
+		d.Observe("llama2", 100, 105)
+	}
+	if got := d.Active(); len(got) != 0 {
+		t.Fatalf("expected divergence to resolve, got %+v", got)
+	}
+}
+
+func TestDivergenceDetector_UnderEstimateBand(t *testing.T) {
+	d := NewDivergenceDetector(3, 0.3, nil, nil, nil)
+
+	// Ratio 0.8 is inside [1/1.3, 1.3]; 0.5 is not.
+	for i := 0; i < 3; i++ {
+		d.Observe("mistral", 100, 80)
+	}
+	if got := d.Active(); len(got) != 0 {
+		t.Fatalf("ratio 0.8 should be within band, got %+v", got)
+	}
+	for i := 0; i < 3; i++ {
+		d.Observe("mistral", 100, 50)
+	}
+	if got := d.Active(); len(got) != 1 {
+		t.Fatalf("ratio 0.5 should diverge, got %+v", got)
+	}
+}
+
+func TestDivergenceDetector_NilAndInvalid(t *testing.T) {
+	var d *DivergenceDetector
+	d.Observe("llama2", 100, 200)
+	if d.Active() != nil {
+		t.Fatal("nil detector should report no anomalies")
+	}
+
+	d = NewDivergenceDetector(1, 0.3, nil, nil, nil)
+	d.Observe("llama2", 0, 200)
+	d.Observe("", 100, 200)
+	d.Observe("llama2", 100, 0)
+	if got := d.Active(); len(got) != 0 {
+		t.Fatalf("invalid observations should be ignored, got %+v", got)
+	}
+}
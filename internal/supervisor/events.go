@@ -2,7 +2,10 @@ package supervisor
 
 import (
 	"encoding/json"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"ollama-auto-ctx/internal/calibration"
@@ -20,9 +23,13 @@ const (
 	EventTimeoutTTFB          EventType = "timeout_ttfb"
 	EventTimeoutStall         EventType = "timeout_stall"
 	EventTimeoutHard          EventType = "timeout_hard"
+	EventTimeoutGlobal        EventType = "timeout_global"
 	EventUpstreamError        EventType = "upstream_error"
 	EventLoopDetected         EventType = "loop_detected"
 	EventOutputLimitExceeded  EventType = "output_limit_exceeded"
+	EventEstimateDivergence   EventType = "estimate_divergence"
+	EventSlowRequest          EventType = "slow_request" // a completed request took longer than SLOW_REQUEST_THRESHOLD
+	EventDecision             EventType = "decision" // a request's context sizing decision
 )
 
 // Event represents a lifecycle event for a request.
@@ -38,22 +45,77 @@ type Event struct {
 	LastActivityAgeMs    int64         `json:"last_activity_age_ms"` // milliseconds since last activity
 	Status               RequestStatus `json:"status,omitempty"`
 	Error                string        `json:"error,omitempty"`
+	// Ratio is the actual/estimated prompt token ratio (estimate_divergence only).
+	Ratio float64 `json:"ratio,omitempty"`
+	// Decision is the proxy's sizing decision as JSON (decision and
+	// slow_request).
+	Decision json.RawMessage `json:"decision,omitempty"`
+	// DurationMs is how long the request took (slow_request only).
+	DurationMs int64 `json:"duration_ms,omitempty"`
+}
+
+// Lifecycle reports whether e is about a request's progress rather than how
+// it was sized, i.e. anything but a decision event.
+func (e Event) Lifecycle() bool {
+	return e.Type != EventDecision
+}
+
+// Final reports whether e is the event Tracker.Finish publishes when its
+// request ends, the only one that carries a Status.
+func (e Event) Final() bool {
+	return e.Status != ""
+}
+
+// Drop reasons reported by the event bus.
+const (
+	DropReasonBuffer     = "buffer"     // the bus buffer was full on Publish
+	DropReasonSubscriber = "subscriber" // a subscriber's channel was full
+)
+
+// EventDropStats counts events the bus has dropped since it was created.
+type EventDropStats struct {
+	Buffer        int64             `json:"buffer"`
+	Subscriber    int64             `json:"subscriber"`
+	PerSubscriber []SubscriberDrops `json:"per_subscriber,omitempty"` // current subscribers only
+}
+
+// SubscriberDrops counts the events dropped for one current subscriber.
+type SubscriberDrops struct {
+	ID      int64 `json:"id"`
+	Dropped int64 `json:"dropped"`
 }
 
 // EventBus manages event publishing and subscription for SSE consumers.
 type EventBus struct {
 	events     chan Event
-	subscribers map[chan Event]struct{}
+	subscribers map[chan Event]*subscriber
 	mu         sync.RWMutex
 	shutdown   chan struct{}
 	once       sync.Once
+	nextID     int64
+
+	bufferDrops     atomic.Int64
+	subscriberDrops atomic.Int64
+	dropMu          sync.Mutex
+	metrics         *Metrics
+	logger          *slog.Logger
+	logThreshold    int
+	logInterval     time.Duration
+	windowStart     time.Time
+	windowDrops     int
+	windowLogged    bool
+}
+
+type subscriber struct {
+	id      int64
+	dropped atomic.Int64
 }
 
 // NewEventBus creates a new event bus with the specified buffer size.
 func NewEventBus(bufferSize int) *EventBus {
 	eb := &EventBus{
 		events:      make(chan Event, bufferSize),
-		subscribers: make(map[chan Event]struct{}),
+		subscribers: make(map[chan Event]*subscriber),
 		shutdown:    make(chan struct{}),
 	}
 
@@ -72,21 +134,20 @@ func (eb *EventBus) forward() {
 				// Channel closed, shutdown
 				return
 			}
+			// Send to all subscribers (non-blocking). The read lock is held
+			// so Unsubscribe cannot close a channel mid-send.
 			eb.mu.RLock()
-			subs := make([]chan Event, 0, len(eb.subscribers))
-			for ch := range eb.subscribers {
-				subs = append(subs, ch)
-			}
-			eb.mu.RUnlock()
-
-			// Send to all subscribers (non-blocking)
-			for _, ch := range subs {
+			for ch, sub := range eb.subscribers {
 				select {
 				case ch <- event:
 				default:
 					// Subscriber channel is full, skip (fail-open)
+					sub.dropped.Add(1)
+					eb.subscriberDrops.Add(1)
+					eb.recordDrop(DropReasonSubscriber)
 				}
 			}
+			eb.mu.RUnlock()
 		case <-eb.shutdown:
 			// Shutdown signal received
 			return
@@ -101,14 +162,98 @@ func (eb *EventBus) Publish(event Event) {
 		// Event published successfully
 	default:
 		// Buffer full, drop event (fail-open)
+		eb.bufferDrops.Add(1)
+		eb.recordDrop(DropReasonBuffer)
+	}
+}
+
+// SetDropReporting makes dropped events observable: each drop is counted in
+// metrics (if non-nil), and when logThreshold > 0 a warning is logged once per
+// logInterval in which more than logThreshold events were dropped.
+// Dropping stays fail-open either way.
+func (eb *EventBus) SetDropReporting(metrics *Metrics, logger *slog.Logger, logThreshold int, logInterval time.Duration) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if logInterval <= 0 {
+		logInterval = time.Minute
+	}
+	eb.dropMu.Lock()
+	defer eb.dropMu.Unlock()
+	eb.metrics = metrics
+	eb.logger = logger
+	eb.logThreshold = logThreshold
+	eb.logInterval = logInterval
+	eb.windowStart = time.Time{}
+	eb.windowDrops = 0
+	eb.windowLogged = false
+}
+
+// recordDrop reports one dropped event to metrics and the rate-limited log.
+func (eb *EventBus) recordDrop(reason string) {
+	eb.dropMu.Lock()
+	metrics := eb.metrics
+	var logDrops int
+	if eb.logThreshold > 0 {
+		now := time.Now()
+		if eb.windowStart.IsZero() || now.Sub(eb.windowStart) >= eb.logInterval {
+			eb.windowStart = now
+			eb.windowDrops = 0
+			eb.windowLogged = false
+		}
+		eb.windowDrops++
+		if eb.windowDrops > eb.logThreshold && !eb.windowLogged {
+			eb.windowLogged = true
+			logDrops = eb.windowDrops
+		}
+	}
+	logger, interval := eb.logger, eb.logInterval
+	eb.dropMu.Unlock()
+
+	metrics.RecordEventDropped(reason)
+	if logDrops > 0 {
+		logger.Warn("event bus dropping events; SSE consumers are missing data",
+			"dropped", logDrops,
+			"interval", interval,
+			"last_reason", reason,
+			"total_buffer", eb.bufferDrops.Load(),
+			"total_subscriber", eb.subscriberDrops.Load(),
+		)
+	}
+}
+
+// DropStats returns how many events have been dropped, overall and for each
+// current subscriber.
+func (eb *EventBus) DropStats() EventDropStats {
+	stats := EventDropStats{
+		Buffer:     eb.bufferDrops.Load(),
+		Subscriber: eb.subscriberDrops.Load(),
+	}
+	eb.mu.RLock()
+	for _, sub := range eb.subscribers {
+		stats.PerSubscriber = append(stats.PerSubscriber, SubscriberDrops{ID: sub.id, Dropped: sub.dropped.Load()})
+	}
+	eb.mu.RUnlock()
+	sort.Slice(stats.PerSubscriber, func(i, j int) bool { return stats.PerSubscriber[i].ID < stats.PerSubscriber[j].ID })
+	return stats
+}
+
+// Dropped returns how many events have been dropped for the subscription ch.
+func (eb *EventBus) Dropped(ch chan Event) int64 {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	if sub, ok := eb.subscribers[ch]; ok {
+		return sub.dropped.Load()
 	}
+	return 0
 }
 
 // Subscribe creates a new subscription channel for SSE consumers.
 func (eb *EventBus) Subscribe() chan Event {
 	ch := make(chan Event, 10) // Small buffer for subscriber
 	eb.mu.Lock()
-	eb.subscribers[ch] = struct{}{}
+	eb.nextID++
+	eb.subscribers[ch] = &subscriber{id: eb.nextID}
 	eb.mu.Unlock()
 	return ch
 }
@@ -134,7 +279,7 @@ func (eb *EventBus) Shutdown() {
 		for ch := range eb.subscribers {
 			close(ch)
 		}
-		eb.subscribers = make(map[chan Event]struct{})
+		eb.subscribers = make(map[chan Event]*subscriber)
 		eb.mu.Unlock()
 	})
 }
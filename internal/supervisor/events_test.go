@@ -1,7 +1,9 @@
 package supervisor
 
 import (
+	"bytes"
 	"encoding/json"
+	"log/slog"
 	"strings"
 	"testing"
 	"time"
@@ -175,4 +177,66 @@ func TestFormatSSEEvent(t *testing.T) {
 
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || strings.Contains(s, substr))
-}
\ No newline at end of file
+}
+func TestEventBus_DropStats(t *testing.T) {
+	bus := NewEventBus(100)
+	defer bus.Shutdown()
+
+	var logBuf bytes.Buffer
+	bus.SetDropReporting(NewMetrics(), slog.New(slog.NewTextHandler(&logBuf, nil)), 5, time.Minute)
+
+	slow := bus.Subscribe() // never read
+	fast := bus.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range fast {
+		}
+	}()
+
+	// The slow subscriber's buffer holds 10 events; the rest are dropped for it only.
+	for i := 0; i < 30; i++ {
+		bus.Publish(Event{Type: EventProgress, RequestID: "test", Timestamp: time.Now()})
+		time.Sleep(time.Millisecond)
+	}
+	deadline := time.Now().Add(time.Second)
+	for bus.Dropped(slow) < 20 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := bus.Dropped(slow); got != 20 {
+		t.Errorf("slow subscriber dropped = %d, want 20", got)
+	}
+	if got := bus.Dropped(fast); got != 0 {
+		t.Errorf("fast subscriber dropped = %d, want 0", got)
+	}
+	stats := bus.DropStats()
+	if stats.Subscriber != 20 || stats.Buffer != 0 {
+		t.Errorf("stats = %+v, want 20 subscriber drops and 0 buffer drops", stats)
+	}
+	if len(stats.PerSubscriber) != 2 || stats.PerSubscriber[0].Dropped != 20 {
+		t.Errorf("per-subscriber stats = %+v", stats.PerSubscriber)
+	}
+	if n := strings.Count(logBuf.String(), "event bus dropping events"); n != 1 {
+		t.Errorf("expected one rate-limited warning, got %d:\n%s", n, logBuf.String())
+	}
+
+	bus.Unsubscribe(fast)
+	<-done
+}
+
+func TestEventBus_DropStatsBuffer(t *testing.T) {
+	bus := NewEventBus(1)
+	defer bus.Shutdown()
+
+	// No reporting configured: drops are still counted, nothing is logged.
+	bus.mu.Lock() // hold forward() before it can drain the buffer
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Type: EventProgress, RequestID: "test", Timestamp: time.Now()})
+	}
+	bus.mu.Unlock()
+
+	if got := bus.DropStats().Buffer; got < 3 {
+		t.Errorf("buffer drops = %d, want at least 3", got)
+	}
+}
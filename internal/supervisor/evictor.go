@@ -0,0 +1,274 @@
+package supervisor
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/ollama"
+)
+
+// maxEvictionHistory bounds how many eviction records the evictor keeps.
+const maxEvictionHistory = 50
+
+// ModelRuntime lists the models Ollama has loaded and unloads them.
+// *ollama.Client satisfies it.
+type ModelRuntime interface {
+	Running(ctx context.Context) ([]ollama.RunningModel, error)
+	Unload(ctx context.Context, model string) error
+}
+
+// Eviction records one unload the evictor attempted.
+type Eviction struct {
+	Model       string    `json:"model"`
+	At          time.Time `json:"at"`
+	IdleSeconds int64     `json:"idle_seconds"`
+	SizeVRAM    int64     `json:"size_vram"`
+	Pressure    float64   `json:"pressure,omitempty"` // loaded VRAM / budget before the unload (pressure mode)
+	Error       string    `json:"error,omitempty"`
+}
+
+// IdleEvictorConfig holds configuration for idle model eviction.
+type IdleEvictorConfig struct {
+	IdleAfter  time.Duration // IDLE_EVICT_AFTER
+	Interval   time.Duration // IDLE_EVICT_INTERVAL
+	Always     bool          // IDLE_EVICT_MODE=always; otherwise only under VRAM pressure
+	VRAMBytes  int64         // IDLE_EVICT_VRAM_BYTES (pressure mode)
+	Pressure   float64       // IDLE_EVICT_PRESSURE (pressure mode)
+	RPCTimeout time.Duration // per /api/ps or unload call
+}
+
+// IdleEvictor unloads models that have not served a request through the proxy
+// for IdleAfter, to free VRAM for others. It is conservative: models the proxy
+// has never seen a request for, and models with requests in flight, are never
+// evicted, and in pressure mode the idlest models are unloaded only until the
+// loaded VRAM drops back under the pressure ratio.
+// It is safe for concurrent use; a nil evictor ignores activity.
+type IdleEvictor struct {
+	runtime ModelRuntime
+	cfg     IdleEvictorConfig
+	metrics *Metrics
+	logger  *slog.Logger
+	// residency, if set, supplies the loaded models so sweeps don't call
+	// /api/ps themselves while its result is fresh.
+	residency *ResidencyPoller
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+	inFlight map[string]int
+	history  []Eviction // oldest first
+
+	stopCh chan struct{}
+	once   sync.Once
+}
+
+// NewIdleEvictor creates an evictor. Call Start to sweep in the background.
+func NewIdleEvictor(runtime ModelRuntime, cfg IdleEvictorConfig, metrics *Metrics, logger *slog.Logger) *IdleEvictor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.RPCTimeout <= 0 {
+		cfg.RPCTimeout = 10 * time.Second
+	}
+	return &IdleEvictor{
+		runtime:  runtime,
+		cfg:      cfg,
+		metrics:  metrics,
+		logger:   logger,
+		lastUsed: make(map[string]time.Time),
+		inFlight: make(map[string]int),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// SetResidencyPoller has sweeps use p's loaded models when p polled within
+// the sweep interval, falling back to /api/ps otherwise.
+func (e *IdleEvictor) SetResidencyPoller(p *ResidencyPoller) {
+	e.residency = p
+}
+
+// Begin marks a request for model as started; pair it with End.
+func (e *IdleEvictor) Begin(model string) {
+	if e == nil || model == "" {
+		return
+	}
+	model = canonicalModelName(model)
+	e.mu.Lock()
+	e.lastUsed[model] = time.Now()
+	e.inFlight[model]++
+	e.mu.Unlock()
+}
+
+// End marks a request for model as finished.
+func (e *IdleEvictor) End(model string) {
+	if e == nil || model == "" {
+		return
+	}
+	model = canonicalModelName(model)
+	e.mu.Lock()
+	e.lastUsed[model] = time.Now()
+	if e.inFlight[model] <= 1 {
+		delete(e.inFlight, model)
+	} else {
+		e.inFlight[model]--
+	}
+	e.mu.Unlock()
+}
+
+// Start sweeps every Interval until Shutdown.
+func (e *IdleEvictor) Start() {
+	go func() {
+		ticker := time.NewTicker(e.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.Sweep()
+			case <-e.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops the background sweep.
+func (e *IdleEvictor) Shutdown() {
+	e.once.Do(func() { close(e.stopCh) })
+}
+
+// Sweep checks the loaded models once and unloads idle ones, returning the
+// evictions it attempted.
+func (e *IdleEvictor) Sweep() []Eviction {
+	return e.sweep(time.Now())
+}
+
+func (e *IdleEvictor) sweep(now time.Time) []Eviction {
+	running, ok := e.residency.Fresh(e.cfg.Interval)
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RPCTimeout)
+		var err error
+		running, err = e.runtime.Running(ctx)
+		cancel()
+		if err != nil {
+			e.logger.Debug("idle eviction: listing loaded models failed", "err", err)
+			return nil
+		}
+	}
+
+	var loadedVRAM int64
+	for _, m := range running {
+		loadedVRAM += m.SizeVRAM
+	}
+	if !e.cfg.Always && !e.underPressure(loadedVRAM) {
+		return nil
+	}
+
+	type candidate struct {
+		model ollama.RunningModel
+		name  string
+		last  time.Time
+		idle  time.Duration
+	}
+	var candidates []candidate
+	e.mu.Lock()
+	for _, m := range running {
+		name := canonicalModelName(m.Name)
+		last, seen := e.lastUsed[name]
+		if !seen || e.inFlight[name] > 0 {
+			continue
+		}
+		if idle := now.Sub(last); idle >= e.cfg.IdleAfter {
+			candidates = append(candidates, candidate{model: m, name: name, last: last, idle: idle})
+		}
+	}
+	e.mu.Unlock()
+	// Idlest first, so pressure mode frees the least useful models.
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].idle > candidates[j].idle })
+
+	var out []Eviction
+	for _, c := range candidates {
+		if !e.cfg.Always && !e.underPressure(loadedVRAM) {
+			break
+		}
+		// Earlier unloads may have taken a while; skip the model if a request
+		// for it started since the candidates were picked.
+		e.mu.Lock()
+		busy := e.inFlight[c.name] > 0 || e.lastUsed[c.name].After(c.last)
+		e.mu.Unlock()
+		if busy {
+			continue
+		}
+		ev := Eviction{
+			Model:       c.model.Name,
+			At:          now,
+			IdleSeconds: int64(c.idle.Seconds()),
+			SizeVRAM:    c.model.SizeVRAM,
+		}
+		if !e.cfg.Always {
+			ev.Pressure = float64(loadedVRAM) / float64(e.cfg.VRAMBytes)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RPCTimeout)
+		err := e.runtime.Unload(ctx, c.model.Name)
+		cancel()
+		if err != nil {
+			ev.Error = err.Error()
+			e.logger.Warn("idle eviction: unload failed", "model", ev.Model, "err", err)
+		} else {
+			loadedVRAM -= c.model.SizeVRAM
+			// Forget the model until the proxy serves it again, so a slow
+			// unload or an out-of-band reload isn't evicted repeatedly.
+			e.mu.Lock()
+			if last, ok := e.lastUsed[c.name]; ok && !last.After(c.last) {
+				delete(e.lastUsed, c.name)
+			}
+			e.mu.Unlock()
+			e.metrics.RecordModelEviction(ev.Model)
+			e.logger.Info("evicted idle model", "model", ev.Model, "idle", c.idle.Round(time.Second), "size_vram", ev.SizeVRAM)
+		}
+		out = append(out, ev)
+	}
+
+	if len(out) > 0 {
+		e.mu.Lock()
+		e.history = append(e.history, out...)
+		if n := len(e.history) - maxEvictionHistory; n > 0 {
+			e.history = append([]Eviction(nil), e.history[n:]...)
+		}
+		e.mu.Unlock()
+	}
+	return out
+}
+
+func (e *IdleEvictor) underPressure(loadedVRAM int64) bool {
+	return e.cfg.VRAMBytes > 0 && float64(loadedVRAM) > e.cfg.Pressure*float64(e.cfg.VRAMBytes)
+}
+
+// Evictions returns the most recent eviction attempts, newest first.
+func (e *IdleEvictor) Evictions() []Eviction {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]Eviction, len(e.history))
+	for i, ev := range e.history {
+		out[len(out)-1-i] = ev
+	}
+	return out
+}
+
+// canonicalModelName adds Ollama's implicit ":latest" tag so request model
+// names match /api/ps names.
+func canonicalModelName(model string) string {
+	if !strings.Contains(model, ":") {
+		return model + ":latest"
+	}
+	return model
+}
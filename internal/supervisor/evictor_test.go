@@ -0,0 +1,125 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/ollama"
+)
+
+type fakeRuntime struct {
+	running  []ollama.RunningModel
+	unloaded []string
+	onUnload func(model string) // runs before the unload is recorded
+}
+
+func (f *fakeRuntime) Running(ctx context.Context) ([]ollama.RunningModel, error) {
+	return f.running, nil
+}
+
+func (f *fakeRuntime) Unload(ctx context.Context, model string) error {
+	if f.onUnload != nil {
+		f.onUnload(model)
+	}
+	f.unloaded = append(f.unloaded, model)
+	return nil
+}
+
+const gib = int64(1) << 30
+
+func TestIdleEvictor_AlwaysEvictsIdleSeenModels(t *testing.T) {
+	rt := &fakeRuntime{running: []ollama.RunningModel{
+		{Name: "idle:latest", SizeVRAM: 4 * gib},
+		{Name: "busy:7b", SizeVRAM: 4 * gib},
+		{Name: "unknown:latest", SizeVRAM: 4 * gib}, // loaded outside the proxy
+	}}
+	e := NewIdleEvictor(rt, IdleEvictorConfig{IdleAfter: time.Minute, Always: true}, nil, nil)
+
+	e.Begin("idle") // matches "idle:latest"
+	e.End("idle")
+	e.Begin("busy:7b") // still in flight
+
+	if got := e.sweep(time.Now()); len(got) != 0 {
+		t.Fatalf("evicted recently used models: %+v", got)
+	}
+
+	got := e.sweep(time.Now().Add(time.Hour))
+	if len(got) != 1 || got[0].Model != "idle:latest" || got[0].Error != "" {
+		t.Fatalf("expected only idle:latest evicted, got %+v", got)
+	}
+	if len(rt.unloaded) != 1 || rt.unloaded[0] != "idle:latest" {
+		t.Fatalf("unexpected unload calls %v", rt.unloaded)
+	}
+
+	// An evicted model is not evicted again until the proxy serves it.
+	if got := e.sweep(time.Now().Add(2 * time.Hour)); len(got) != 0 {
+		t.Fatalf("re-evicted model: %+v", got)
+	}
+	if hist := e.Evictions(); len(hist) != 1 || hist[0].Model != "idle:latest" {
+		t.Fatalf("unexpected history %+v", hist)
+	}
+}
+
+func TestIdleEvictor_PressureMode(t *testing.T) {
+	rt := &fakeRuntime{running: []ollama.RunningModel{
+		{Name: "a:latest", SizeVRAM: 8 * gib},
+		{Name: "b:latest", SizeVRAM: 8 * gib},
+		{Name: "c:latest", SizeVRAM: 4 * gib},
+	}}
+	e := NewIdleEvictor(rt, IdleEvictorConfig{IdleAfter: time.Minute, VRAMBytes: 24 * gib, Pressure: 0.8}, nil, nil)
+
+	base := time.Now()
+	for _, m := range []string{"a", "b", "c"} {
+		e.Begin(m)
+		e.End(m)
+	}
+	// a is the idlest, b next.
+	e.mu.Lock()
+	e.lastUsed["a:latest"] = base.Add(-3 * time.Hour)
+	e.lastUsed["b:latest"] = base.Add(-2 * time.Hour)
+	e.lastUsed["c:latest"] = base.Add(-1 * time.Hour)
+	e.mu.Unlock()
+
+	// 20 GiB of 24 GiB loaded is above 80%: unloading a (8 GiB) is enough.
+	got := e.sweep(base)
+	if len(got) != 1 || got[0].Model != "a:latest" {
+		t.Fatalf("expected only a:latest evicted, got %+v", got)
+	}
+	if got[0].Pressure < 0.83 || got[0].Pressure > 0.84 {
+		t.Errorf("pressure = %v, want 20/24", got[0].Pressure)
+	}
+
+	// Below the pressure ratio nothing is evicted, however idle.
+	rt.running = rt.running[1:]
+	if got := e.sweep(base.Add(time.Hour)); len(got) != 0 {
+		t.Fatalf("evicted without pressure: %+v", got)
+	}
+}
+
+func TestIdleEvictor_SkipsModelUsedDuringSweep(t *testing.T) {
+	rt := &fakeRuntime{running: []ollama.RunningModel{
+		{Name: "older:latest", SizeVRAM: 4 * gib},
+		{Name: "newer:latest", SizeVRAM: 4 * gib},
+	}}
+	e := NewIdleEvictor(rt, IdleEvictorConfig{IdleAfter: time.Minute, Always: true}, nil, nil)
+	e.Begin("older")
+	e.End("older")
+	time.Sleep(time.Millisecond)
+	e.Begin("newer")
+	e.End("newer")
+
+	// A request for newer arrives while older is being unloaded.
+	rt.onUnload = func(model string) {
+		if model == "older:latest" {
+			e.Begin("newer")
+		}
+	}
+	got := e.sweep(time.Now().Add(time.Hour))
+	if len(got) != 1 || got[0].Model != "older:latest" {
+		t.Fatalf("expected only older:latest evicted, got %+v", got)
+	}
+	if len(rt.unloaded) != 1 {
+		t.Fatalf("unloaded a model with a request in flight: %v", rt.unloaded)
+	}
+}
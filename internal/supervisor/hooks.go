@@ -0,0 +1,252 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hookRunner runs one shell command with the guards shared by the restart
+// and outcome hooks: one run at a time, a cooldown after each run, at most
+// maxPerHour runs in a rolling hour, and a timeout per run.
+type hookRunner struct {
+	name       string // prefixes log messages, e.g. "restart"
+	command    string
+	cooldown   time.Duration
+	maxPerHour int
+	timeout    time.Duration
+	logger     *slog.Logger
+
+	mu      sync.Mutex
+	history []time.Time // end times of runs in the last hour
+	last    time.Time
+	running bool
+}
+
+// tryRun starts the command in the background with env added to the
+// proxy's environment, unless a guard blocks it. It reports whether the
+// command was started.
+func (r *hookRunner) tryRun(env []string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		r.logger.Debug(r.name+" already in progress, skipping", "command", r.command)
+		return false
+	}
+	if time.Since(r.last) < r.cooldown {
+		r.logger.Debug(r.name+" cooldown not elapsed",
+			"since_last", time.Since(r.last),
+			"cooldown", r.cooldown)
+		return false
+	}
+	r.pruneHistoryLocked()
+	if len(r.history) >= r.maxPerHour {
+		r.logger.Warn(r.name+" rate limit reached",
+			"runs_this_hour", len(r.history),
+			"max_per_hour", r.maxPerHour)
+		return false
+	}
+
+	r.running = true
+	go r.execute(env)
+	return true
+}
+
+// pruneHistoryLocked removes runs older than 1 hour. Caller must hold mu.
+func (r *hookRunner) pruneHistoryLocked() {
+	cutoff := time.Now().Add(-time.Hour)
+	kept := r.history[:0]
+	for _, t := range r.history {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	r.history = kept
+}
+
+func (r *hookRunner) execute(env []string) {
+	defer func() {
+		// Fail-open: if we panic, mark the run as finished
+		if p := recover(); p != nil {
+			r.logger.Error(r.name+" panic recovered", "panic", p)
+		}
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	r.logger.Info("executing "+r.name+" command", "command", r.command)
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	// Execute via shell
+	cmd := exec.CommandContext(ctx, "sh", "-c", r.command)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, err := cmd.CombinedOutput()
+
+	r.mu.Lock()
+	r.last = time.Now()
+	r.history = append(r.history, r.last)
+	r.mu.Unlock()
+
+	if err != nil {
+		r.logger.Error(r.name+" command failed",
+			"command", r.command,
+			"error", err,
+			"output", string(output))
+		return
+	}
+
+	r.logger.Info(r.name+" command completed successfully",
+		"command", r.command,
+		"output", string(output))
+}
+
+// stats returns the runs in the last hour, the end of the last run (zero if
+// none) and whether a run is in progress.
+func (r *hookRunner) stats() (runsThisHour int, last time.Time, running bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneHistoryLocked()
+	return len(r.history), r.last, r.running
+}
+
+// outcomeEvents are the events an outcome hook can run on: how a request
+// ended, plus estimate divergence and slow requests.
+var outcomeEvents = map[EventType]bool{
+	EventDone:                true,
+	EventCanceled:            true,
+	EventTimeoutTTFB:         true,
+	EventTimeoutStall:        true,
+	EventTimeoutHard:         true,
+	EventTimeoutGlobal:       true,
+	EventUpstreamError:       true,
+	EventLoopDetected:        true,
+	EventOutputLimitExceeded: true,
+	EventEstimateDivergence:  true,
+	EventSlowRequest:         true,
+}
+
+// OutcomeHookConfig holds configuration for outcome hooks.
+type OutcomeHookConfig struct {
+	Commands       map[string]string // HOOK_CMD_<OUTCOME>, keyed by lowercase event type
+	Cooldown       time.Duration     // HOOK_COOLDOWN, per outcome
+	MaxPerHour     int               // HOOK_MAX_PER_HOUR, per outcome
+	CommandTimeout time.Duration     // HOOK_TIMEOUT
+}
+
+// OutcomeHooks runs a shell command when a request ends with a configured
+// outcome (e.g. loop_detected), for automation without a webhook server.
+// The command gets the request through OAC_OUTCOME, OAC_REQUEST_ID,
+// OAC_MODEL, OAC_ENDPOINT, OAC_STATUS and OAC_REASON (the error, if any),
+// and is guarded per outcome like the restart hook. Events come from the
+// event bus, so like SSE consumers a hook may miss events when the bus is
+// saturated.
+type OutcomeHooks struct {
+	bus     *EventBus
+	runners map[EventType]*hookRunner
+	logger  *slog.Logger
+
+	ch   chan Event
+	done chan struct{}
+	once sync.Once
+}
+
+// NewOutcomeHooks creates hooks for cfg.Commands. It fails for an outcome
+// that isn't a request outcome. Call Start to begin handling events.
+func NewOutcomeHooks(bus *EventBus, cfg OutcomeHookConfig, logger *slog.Logger) (*OutcomeHooks, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.MaxPerHour < 1 {
+		cfg.MaxPerHour = 1
+	}
+	if cfg.CommandTimeout <= 0 {
+		cfg.CommandTimeout = 30 * time.Second
+	}
+	h := &OutcomeHooks{
+		bus:     bus,
+		runners: make(map[EventType]*hookRunner, len(cfg.Commands)),
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+	for outcome, command := range cfg.Commands {
+		t := EventType(strings.ToLower(outcome))
+		if !outcomeEvents[t] {
+			return nil, fmt.Errorf("unknown hook outcome %q (want one of %s)", outcome, strings.Join(OutcomeNames(), ", "))
+		}
+		if strings.TrimSpace(command) == "" {
+			continue
+		}
+		h.runners[t] = &hookRunner{
+			name:       string(t) + " hook",
+			command:    command,
+			cooldown:   cfg.Cooldown,
+			maxPerHour: cfg.MaxPerHour,
+			timeout:    cfg.CommandTimeout,
+			logger:     logger,
+		}
+	}
+	return h, nil
+}
+
+// OutcomeNames returns the outcomes hooks can be configured for, sorted.
+func OutcomeNames() []string {
+	names := make([]string, 0, len(outcomeEvents))
+	for t := range outcomeEvents {
+		names = append(names, string(t))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start subscribes to the event bus and runs hooks until Shutdown.
+func (h *OutcomeHooks) Start() {
+	if len(h.runners) == 0 || h.bus == nil {
+		close(h.done)
+		return
+	}
+	h.ch = h.bus.Subscribe()
+	go func() {
+		defer close(h.done)
+		for ev := range h.ch {
+			h.handle(ev)
+		}
+	}()
+}
+
+// Shutdown stops handling events. Commands already running finish on
+// their own timeout.
+func (h *OutcomeHooks) Shutdown() {
+	if h.ch == nil {
+		return
+	}
+	h.once.Do(func() { h.bus.Unsubscribe(h.ch) })
+	<-h.done
+}
+
+// handle runs the hook for ev's outcome, if any, reporting whether it started.
+func (h *OutcomeHooks) handle(ev Event) bool {
+	r := h.runners[ev.Type]
+	if r == nil {
+		return false
+	}
+	return r.tryRun([]string{
+		"OAC_OUTCOME=" + string(ev.Type),
+		"OAC_REQUEST_ID=" + ev.RequestID,
+		"OAC_MODEL=" + ev.Model,
+		"OAC_ENDPOINT=" + ev.Endpoint,
+		"OAC_STATUS=" + string(ev.Status),
+		"OAC_REASON=" + ev.Error,
+	})
+}
@@ -0,0 +1,52 @@
+package supervisor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutcomeHooks(t *testing.T) {
+	if _, err := NewOutcomeHooks(nil, OutcomeHookConfig{Commands: map[string]string{"progress": "true"}}, nil); err == nil {
+		t.Fatal("expected non-outcome events to be rejected")
+	}
+
+	out := filepath.Join(t.TempDir(), "hook.out")
+	bus := NewEventBus(10)
+	defer bus.Shutdown()
+	hooks, err := NewOutcomeHooks(bus, OutcomeHookConfig{
+		Commands:       map[string]string{"LOOP_DETECTED": `echo "$OAC_OUTCOME $OAC_REQUEST_ID $OAC_MODEL $OAC_REASON" > ` + out},
+		Cooldown:       time.Hour,
+		MaxPerHour:     5,
+		CommandTimeout: 5 * time.Second,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks.Start()
+	defer hooks.Shutdown()
+
+	bus.Publish(Event{Type: EventDone, RequestID: "req-0", Model: "llama3"})
+	bus.Publish(Event{Type: EventLoopDetected, RequestID: "req-1", Model: "qwen3", Error: "repeating"})
+
+	var got []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if runs, _, _ := hooks.runners[EventLoopDetected].stats(); runs > 0 {
+			got, _ = os.ReadFile(out)
+			break
+		}
+	}
+	if want := "loop_detected req-1 qwen3 repeating"; strings.TrimSpace(string(got)) != want {
+		t.Fatalf("hook output = %q, want %q", got, want)
+	}
+
+	// The cooldown blocks a second run of the same outcome.
+	if hooks.handle(Event{Type: EventLoopDetected, RequestID: "req-2"}) {
+		t.Error("expected the cooldown to block a second run")
+	}
+	if hooks.handle(Event{Type: EventDone, RequestID: "req-3"}) {
+		t.Error("outcomes without a command should not run anything")
+	}
+}
@@ -0,0 +1,68 @@
+package supervisor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	inFlightMaxDesc = prometheus.NewDesc("oac_requests_in_flight_max",
+		"Most requests in flight at once since the last /metrics scrape", nil, nil)
+	inFlightAvgDesc = prometheus.NewDesc("oac_requests_in_flight_avg",
+		"Time-weighted average of requests in flight since the last /metrics scrape", nil, nil)
+)
+
+// inFlightCollector follows the in-flight count between scrapes so a scrape
+// sees the peak and the time-weighted average since the one before, not
+// just whatever the count was at that instant. Collecting starts a new
+// window, so it is registered only with the registry /metrics serves.
+type inFlightCollector struct {
+	mu       sync.Mutex
+	current  int
+	peak     int
+	weighted float64   // integral of the count over the window, in count*seconds
+	since    time.Time // when the window started
+	changed  time.Time // when current last changed
+	now      func() time.Time
+}
+
+func newInFlightCollector(now func() time.Time) *inFlightCollector {
+	t := now()
+	return &inFlightCollector{since: t, changed: t, now: now}
+}
+
+// set records the in-flight count changing to count.
+func (c *inFlightCollector) set(count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.now()
+	c.weighted += float64(c.current) * t.Sub(c.changed).Seconds()
+	c.current, c.changed = count, t
+	c.peak = max(c.peak, count)
+}
+
+// Describe implements prometheus.Collector.
+func (c *inFlightCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- inFlightMaxDesc
+	ch <- inFlightAvgDesc
+}
+
+// Collect implements prometheus.Collector, reporting the window since the
+// last Collect and starting a new one at the current count.
+func (c *inFlightCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	t := c.now()
+	weighted := c.weighted + float64(c.current)*t.Sub(c.changed).Seconds()
+	avg := float64(c.current)
+	if elapsed := t.Sub(c.since).Seconds(); elapsed > 0 {
+		avg = weighted / elapsed
+	}
+	peak := c.peak
+	c.peak, c.weighted, c.since, c.changed = c.current, 0, t, t
+	c.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(inFlightMaxDesc, prometheus.GaugeValue, float64(peak))
+	ch <- prometheus.MustNewConstMetric(inFlightAvgDesc, prometheus.GaugeValue, avg)
+}
@@ -0,0 +1,113 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// JSONLFile appends values to a file as JSON, one per line. When a line
+// would take the file past maxBytes, the file is renamed to <path>.1
+// (replacing the previous one) and a new one is started, so at most about
+// twice maxBytes is kept. It is safe for concurrent use.
+type JSONLFile struct {
+	path     string
+	maxBytes int64
+	name     string // for warnings, e.g. "overflow log"
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	failing bool // the last write failed; logged once until one succeeds
+	closed  bool
+}
+
+// OpenJSONLFile opens path for appending, creating it if needed. name
+// identifies the file in warnings.
+func OpenJSONLFile(path string, maxBytes int64, name string, logger *slog.Logger) (*JSONLFile, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := &JSONLFile{path: path, maxBytes: maxBytes, name: name, logger: logger}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *JSONLFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.f, l.size = f, st.Size()
+	return nil
+}
+
+// Append writes v as one line, rotating the file first if it's full.
+// Failures are logged rather than returned; a nil file writes nothing.
+func (l *JSONLFile) Append(v any) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if err := l.writeLocked(line); err != nil {
+		if !l.failing {
+			l.logger.Warn(l.name+": write failed; entries are being dropped", "path", l.path, "err", err)
+		}
+		l.failing = true
+		return
+	}
+	l.failing = false
+}
+
+func (l *JSONLFile) writeLocked(line []byte) error {
+	if l.f != nil && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		_ = l.f.Close()
+		l.f = nil
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	}
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Close closes the file; later writes are dropped.
+func (l *JSONLFile) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,218 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRecord is a structured log record as streamed to log subscribers.
+type LogRecord struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+
+	level slog.Level
+}
+
+// LogFilter selects the records a subscriber receives.
+type LogFilter struct {
+	MinLevel slog.Level
+	Contains string // optional substring of the message or any attribute value
+}
+
+func (f LogFilter) match(rec LogRecord) bool {
+	if rec.level < f.MinLevel {
+		return false
+	}
+	if f.Contains == "" || strings.Contains(rec.Message, f.Contains) {
+		return true
+	}
+	b, err := json.Marshal(rec.Attrs)
+	return err == nil && strings.Contains(string(b), f.Contains)
+}
+
+// LogBroadcaster fans slog records out to live subscribers (the /logs SSE
+// endpoint). Like EventBus it never blocks logging: records are dropped when
+// the buffer or a subscriber's channel is full.
+type LogBroadcaster struct {
+	records     chan LogRecord
+	subscribers map[chan LogRecord]LogFilter
+	mu          sync.RWMutex
+	shutdown    chan struct{}
+	once        sync.Once
+}
+
+// NewLogBroadcaster creates a broadcaster with the specified buffer size.
+func NewLogBroadcaster(bufferSize int) *LogBroadcaster {
+	b := &LogBroadcaster{
+		records:     make(chan LogRecord, bufferSize),
+		subscribers: make(map[chan LogRecord]LogFilter),
+		shutdown:    make(chan struct{}),
+	}
+	go b.forward()
+	return b
+}
+
+func (b *LogBroadcaster) forward() {
+	for {
+		select {
+		case rec := <-b.records:
+			b.mu.RLock()
+			for ch, filter := range b.subscribers {
+				if !filter.match(rec) {
+					continue
+				}
+				select {
+				case ch <- rec:
+				default:
+					// Slow consumer, drop (fail-open)
+				}
+			}
+			b.mu.RUnlock()
+		case <-b.shutdown:
+			return
+		}
+	}
+}
+
+// Subscribe creates a subscription channel receiving records that match filter.
+func (b *LogBroadcaster) Subscribe(filter LogFilter) chan LogRecord {
+	ch := make(chan LogRecord, 64)
+	b.mu.Lock()
+	b.subscribers[ch] = filter
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes a subscription channel and closes it.
+func (b *LogBroadcaster) Unsubscribe(ch chan LogRecord) {
+	b.mu.Lock()
+	if _, exists := b.subscribers[ch]; exists {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	b.mu.Unlock()
+}
+
+// Shutdown stops forwarding and closes all subscriber channels.
+func (b *LogBroadcaster) Shutdown() {
+	b.once.Do(func() {
+		close(b.shutdown)
+		b.mu.Lock()
+		for ch := range b.subscribers {
+			close(ch)
+		}
+		b.subscribers = make(map[chan LogRecord]LogFilter)
+		b.mu.Unlock()
+	})
+}
+
+func (b *LogBroadcaster) publish(rec LogRecord) {
+	select {
+	case <-b.shutdown:
+	case b.records <- rec:
+	default:
+		// Buffer full, drop (fail-open)
+	}
+}
+
+// Handler wraps next so every record it handles is also published to subscribers.
+func (b *LogBroadcaster) Handler(next slog.Handler) slog.Handler {
+	return &broadcastHandler{next: next, b: b}
+}
+
+// broadcastHandler tees records to the wrapped handler and the broadcaster.
+type broadcastHandler struct {
+	next   slog.Handler
+	b      *LogBroadcaster
+	attrs  []slog.Attr // from WithAttrs, already prefixed with their group
+	prefix string      // current group path, e.g. "http."
+}
+
+func (h *broadcastHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *broadcastHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.b.mu.RLock()
+	listening := len(h.b.subscribers) > 0
+	h.b.mu.RUnlock()
+	if listening {
+		rec := LogRecord{
+			Time:    r.Time,
+			Level:   r.Level.String(),
+			Message: r.Message,
+			level:   r.Level,
+		}
+		if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+			rec.Attrs = make(map[string]any, len(h.attrs)+r.NumAttrs())
+			for _, a := range h.attrs {
+				addAttr(rec.Attrs, "", a)
+			}
+			r.Attrs(func(a slog.Attr) bool {
+				addAttr(rec.Attrs, h.prefix, a)
+				return true
+			})
+		}
+		h.b.publish(rec)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *broadcastHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *broadcastHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// addAttr flattens a into m, joining group keys with '.'.
+func addAttr(m map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			addAttr(m, p, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	val := v.Any()
+	if err, ok := val.(error); ok {
+		val = err.Error()
+	}
+	m[prefix+a.Key] = val
+}
+
+// FormatSSELog formats a log record for SSE transmission.
+func FormatSSELog(rec LogRecord) (string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	return "data: " + string(data) + "\n\n", nil
+}
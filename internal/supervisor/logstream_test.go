@@ -0,0 +1,77 @@
+package supervisor
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogBroadcaster_TeesAndFilters(t *testing.T) {
+	b := NewLogBroadcaster(10)
+	defer b.Shutdown()
+
+	var out bytes.Buffer
+	logger := slog.New(b.Handler(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	warnings := b.Subscribe(LogFilter{MinLevel: slog.LevelWarn})
+	upstream := b.Subscribe(LogFilter{MinLevel: slog.LevelDebug, Contains: "11434"})
+
+	logger.Info("ctx decision", "model", "llama3")
+	logger.With("component", "health").WithGroup("upstream").Warn("health check failed", "url", "http://127.0.0.1:11434", "err", errors.New("refused"))
+
+	select {
+	case rec := <-warnings:
+		if rec.Message != "health check failed" || rec.Level != "WARN" {
+			t.Errorf("unexpected record %+v", rec)
+		}
+		if rec.Attrs["component"] != "health" || rec.Attrs["upstream.url"] != "http://127.0.0.1:11434" || rec.Attrs["upstream.err"] != "refused" {
+			t.Errorf("unexpected attrs %v", rec.Attrs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not receive warning")
+	}
+	select {
+	case rec := <-warnings:
+		t.Errorf("info record passed warn filter: %+v", rec)
+	default:
+	}
+
+	select {
+	case rec := <-upstream:
+		if rec.Message != "health check failed" {
+			t.Errorf("substring filter matched %q", rec.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not receive substring match")
+	}
+
+	// The wrapped handler still gets every record.
+	if got := strings.Count(out.String(), "\n"); got != 2 {
+		t.Errorf("expected 2 lines written to the wrapped handler, got %d", got)
+	}
+}
+
+func TestLogBroadcaster_SlowConsumerDoesNotBlock(t *testing.T) {
+	b := NewLogBroadcaster(4)
+	defer b.Shutdown()
+
+	logger := slog.New(b.Handler(slog.NewJSONHandler(&bytes.Buffer{}, nil)))
+	sub := b.Subscribe(LogFilter{})
+	defer b.Unsubscribe(sub)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			logger.Info("spam", "i", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("logging blocked on a slow subscriber")
+	}
+}
@@ -2,9 +2,14 @@ package supervisor
 
 import (
 	"context"
+	"encoding/json"
 	"sync"
 )
 
+// completionFeedBytes is how much of a buffered completion CompletionLoops
+// feeds the detector at a time, roughly a streamed chunk's worth.
+const completionFeedBytes = 64
+
 // LoopDetector detects repetitive output patterns in streaming responses.
 // It uses a rolling n-gram detection approach to identify when a model is
 // producing degenerate repeating output.
@@ -180,3 +185,39 @@ func (ld *LoopDetector) Reset() {
 	ld.totalBytes = 0
 	ld.triggered = false
 }
+
+// CompletionLoops reports whether the completion text of a non-streaming
+// Ollama or OpenAI-compatible response body repeats the way a LoopDetector
+// with cfg would have flagged it had it been streamed. Bodies without a
+// completion never loop.
+func CompletionLoops(body []byte, cfg LoopDetectorConfig) bool {
+	var r struct {
+		Response string `json:"response"`
+		Message  struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Choices []struct {
+			Text    string `json:"text"`
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return false
+	}
+	text := r.Response + r.Message.Content
+	if len(r.Choices) > 0 {
+		text += r.Choices[0].Text + r.Choices[0].Message.Content
+	}
+
+	ld := NewLoopDetector(cfg, "", nil, nil)
+	for len(text) > 0 {
+		n := min(completionFeedBytes, len(text))
+		if ld.Feed([]byte(text[:n])) {
+			return true
+		}
+		text = text[n:]
+	}
+	return false
+}
@@ -174,3 +174,30 @@ func TestLoopDetector_ConcurrentAccess(t *testing.T) {
 	// Just verify it didn't panic
 	_ = detector.Triggered()
 }
+
+func TestCompletionLoops(t *testing.T) {
+	cfg := LoopDetectorConfig{WindowBytes: 512, NgramBytes: 16, RepeatThreshold: 3, MinOutputBytes: 100}
+	looping := strings.Repeat("I will now answer the question. ", 40)
+	prose := "Hello, this is a test. The quick brown fox jumps over the lazy dog. " +
+		"Pack my box with five dozen liquor jugs. How vexingly quick daft zebras jump! " +
+		"Sphinx of black quartz, judge my vow. Jackdaws love my big sphinx of quartz. " +
+		"The five boxing wizards jump quickly. Waltz, bad nymph, for quick jigs vex."
+
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"generate", `{"response":"` + looping + `","done":true}`, true},
+		{"chat", `{"message":{"role":"assistant","content":"` + looping + `"},"done":true}`, true},
+		{"openai", `{"choices":[{"message":{"content":"` + looping + `"}}]}`, true},
+		{"prose", `{"response":"` + prose + `","done":true}`, false},
+		{"short", `{"response":"yes yes yes","done":true}`, false},
+		{"not json", looping, false},
+	}
+	for _, tt := range tests {
+		if got := CompletionLoops([]byte(tt.body), cfg); got != tt.want {
+			t.Errorf("%s: CompletionLoops = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,38 @@
 package supervisor
 
 import (
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ollama-auto-ctx/internal/ollama"
 )
 
+// MetricsPrefix is the namespace prefix shared by all exported metric names.
+const MetricsPrefix = "oac_"
+
 // Metrics collects Prometheus metrics for the proxy.
 // Labels are kept to low-cardinality: model, status, reason.
 type Metrics struct {
 	// Counters
 	requestsTotal   *prometheus.CounterVec // model, status, reason
 	retriesTotal    *prometheus.CounterVec // model
+	loadingRetries  *prometheus.CounterVec // model
+	loopRetries     *prometheus.CounterVec // model, result
+	modelFallbacks  *prometheus.CounterVec // model, fallback
+	quotaRejections *prometheus.CounterVec // tag, kind
+	promptScans     *prometheus.CounterVec // rule, action
+	responseCache   *prometheus.CounterVec // model, result
+	vramRejections  *prometheus.CounterVec // model
+
+	// Storage sampling (STORAGE_SAMPLE_RATE)
+	storageSampledOut prometheus.Counter
+
+	// Storage write breaker (STORAGE_BREAKER_THRESHOLD)
+	storageDegraded prometheus.Gauge
 
 	// Histograms
 	requestDuration *prometheus.HistogramVec // model
@@ -22,6 +41,50 @@ type Metrics struct {
 	// Gauges
 	inFlightRequests prometheus.Gauge
 	upstreamHealthy  prometheus.Gauge
+
+	// Estimate accuracy
+	estimateRatio           *prometheus.GaugeVec   // model
+	estimateDivergenceTotal *prometheus.CounterVec // model
+	truncationSuspected     *prometheus.CounterVec // model
+
+	// Latency SLO
+	sloCompliance prometheus.Gauge
+	sloBurnRate   prometheus.Gauge
+
+	// Event bus
+	eventsDroppedTotal *prometheus.CounterVec // reason
+
+	// Idle model eviction
+	modelEvictionsTotal *prometheus.CounterVec // model
+
+	// Upstream body failures after the response started
+	upstreamStreamErrorsTotal *prometheus.CounterVec // model
+
+	// Shadow mode
+	shadowRequestsTotal *prometheus.CounterVec // result
+
+	// Per-model concurrency caps (MODEL_MAX_CONCURRENCY)
+	modelQueueWait      *prometheus.HistogramVec // model
+	modelBusyRejections *prometheus.CounterVec   // model
+
+	// Model load coalescing (LOAD_COALESCE_ENABLED)
+	loadCoalesceWait *prometheus.HistogramVec // model
+
+	// Session budget escalation (SESSION_BUDGET_ESCALATION_ENABLED)
+	sessionEscalationsTotal *prometheus.CounterVec // model
+
+	// Loaded models from the residency poller (RESIDENCY_POLL_INTERVAL)
+	modelVRAMBytes *prometheus.GaugeVec // model
+
+	// Conservative sizing while calibration is unstable (UNSTABLE_CALIBRATION_POLICY)
+	unstableCalibration *prometheus.CounterVec // model, policy
+	// Requests given their model's MODEL_PREFERRED_CTX instead of a bucket
+	preferredCtx *prometheus.CounterVec // model
+
+	// Peak and average in flight between scrapes (METRICS_IN_FLIGHT_PEAK_ENABLED),
+	// served from scrape alongside the default registry.
+	inFlightWindow *inFlightCollector
+	scrape         *prometheus.Registry
 }
 
 var (
@@ -48,6 +111,67 @@ func NewMetrics() *Metrics {
 				},
 				[]string{"model"},
 			),
+			storageSampledOut: promauto.NewCounter(
+				prometheus.CounterOpts{
+					Name: "oac_storage_sampled_out_total",
+					Help: "Successful requests not persisted because of STORAGE_SAMPLE_RATE",
+				},
+			),
+			storageDegraded: promauto.NewGauge(
+				prometheus.GaugeOpts{
+					Name: "oac_storage_degraded",
+					Help: "Whether storage writes are paused after repeated errors (1 = paused, 0 = writing)",
+				},
+			),
+			loadingRetries: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_model_loading_retries_total",
+					Help: "Retries made because the model was still loading (included in oac_retries_total)",
+				},
+				[]string{"model"},
+			),
+			loopRetries: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_loop_retries_total",
+					Help: "Non-streaming requests resent because their completion looped, by whether the resend stopped looping (result=rescued|looped)",
+				},
+				[]string{"model", "result"},
+			),
+			modelFallbacks: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_model_fallbacks_total",
+					Help: "Non-streaming requests resent to their MODEL_FALLBACK model after the requested model failed",
+				},
+				[]string{"model", "fallback"},
+			),
+			quotaRejections: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_tag_quota_rejections_total",
+					Help: "Requests rejected because a TAG_QUOTAS quota of one of their tags was exhausted",
+				},
+				[]string{"tag", "kind"},
+			),
+			promptScans: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_prompt_scan_matches_total",
+					Help: "Requests whose prompt matched a PROMPT_SCAN_FILE rule, by rule and its action",
+				},
+				[]string{"rule", "action"},
+			),
+			responseCache: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_response_cache_lookups_total",
+					Help: "Deterministic requests looked up in the RESPONSE_CACHE_TTL cache, by whether they were answered from it (hit) or not (miss)",
+				},
+				[]string{"model", "result"},
+			),
+			vramRejections: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_vram_gate_rejections_total",
+					Help: "Requests the VRAM gate rejected because their model didn't fit in the free VRAM",
+				},
+				[]string{"model"},
+			),
 			requestDuration: promauto.NewHistogramVec(
 				prometheus.HistogramOpts{
 					Name:    "oac_request_duration_seconds",
@@ -76,6 +200,118 @@ func NewMetrics() *Metrics {
 					Help: "Upstream Ollama health status (1 = healthy, 0 = unhealthy)",
 				},
 			),
+			estimateRatio: promauto.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "oac_estimate_ratio",
+					Help: "Rolling median of actual/estimated prompt tokens",
+				},
+				[]string{"model"},
+			),
+			estimateDivergenceTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_estimate_divergence_total",
+					Help: "Times a model's estimate/actual ratio drifted beyond the divergence threshold",
+				},
+				[]string{"model"},
+			),
+			truncationSuspected: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_truncation_suspected_total",
+					Help: "Requests whose actual prompt tokens exceeded the chosen context minus the output budget",
+				},
+				[]string{"model"},
+			),
+			sloCompliance: promauto.NewGauge(
+				prometheus.GaugeOpts{
+					Name: "oac_slo_compliance_ratio",
+					Help: "Fraction of completed requests in the SLO window that met the latency threshold",
+				},
+			),
+			sloBurnRate: promauto.NewGauge(
+				prometheus.GaugeOpts{
+					Name: "oac_slo_burn_rate",
+					Help: "Latency SLO error-budget burn rate (1 = budget exactly consumed over the window)",
+				},
+			),
+			eventsDroppedTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_events_dropped_total",
+					Help: "Lifecycle events dropped by the event bus (buffer = bus full, subscriber = slow SSE consumer)",
+				},
+				[]string{"reason"},
+			),
+			modelEvictionsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_model_evictions_total",
+					Help: "Models unloaded from Ollama by the idle evictor",
+				},
+				[]string{"model"},
+			),
+			upstreamStreamErrorsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_upstream_stream_errors_total",
+					Help: "Upstream responses whose body failed mid-stream (connection reset, upstream crash)",
+				},
+				[]string{"model"},
+			),
+			shadowRequestsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_shadow_requests_total",
+					Help: "Requests mirrored to the shadow upstream by result (match, mismatch, uncompared, error, skipped)",
+				},
+				[]string{"result"},
+			),
+			modelQueueWait: promauto.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "oac_model_queue_wait_seconds",
+					Help:    "Time requests waited for a slot under their model's MODEL_MAX_CONCURRENCY",
+					Buckets: []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+				},
+				[]string{"model"},
+			),
+			modelBusyRejections: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_model_busy_rejections_total",
+					Help: "Requests answered 503 because their model was at MODEL_MAX_CONCURRENCY",
+				},
+				[]string{"model"},
+			),
+			loadCoalesceWait: promauto.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "oac_load_coalesce_wait_seconds",
+					Help:    "Time requests were held while another request loaded their model (LOAD_COALESCE_ENABLED)",
+					Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+				},
+				[]string{"model"},
+			),
+			sessionEscalationsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_session_budget_escalations_total",
+					Help: "Requests whose output budget was raised because earlier responses in their session stopped at done_reason=length",
+				},
+				[]string{"model"},
+			),
+			modelVRAMBytes: promauto.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: "oac_model_vram_bytes",
+					Help: "VRAM held by each model Ollama has loaded, as of the last /api/ps poll",
+				},
+				[]string{"model"},
+			),
+			unstableCalibration: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_unstable_calibration_sizings_total",
+					Help: "Requests sized conservatively under UNSTABLE_CALIBRATION_POLICY because their model's calibration confidence was low",
+				},
+				[]string{"model", "policy"},
+			),
+			preferredCtx: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "oac_preferred_ctx_sizings_total",
+					Help: "Requests given their model's MODEL_PREFERRED_CTX instead of the bucket they needed",
+				},
+				[]string{"model"},
+			),
 		}
 	})
 	return metricsInst
@@ -109,6 +345,9 @@ func (m *Metrics) RecordRequest(endpoint, model string, status RequestStatus, du
 	case StatusTimeoutHard:
 		statusLabel = "error"
 		reasonLabel = "timeout_hard"
+	case StatusTimeoutGlobal:
+		statusLabel = "error"
+		reasonLabel = "timeout_global"
 	case StatusUpstreamError:
 		statusLabel = "error"
 		reasonLabel = "upstream_error"
@@ -154,6 +393,96 @@ func (m *Metrics) RecordRetry(model string) {
 	m.retriesTotal.WithLabelValues(modelLabel).Inc()
 }
 
+// RecordStorageSampledOut records a successful request that storage sampling
+// didn't persist.
+func (m *Metrics) RecordStorageSampledOut() {
+	if m == nil {
+		return
+	}
+	m.storageSampledOut.Inc()
+}
+
+// SetStorageDegraded records whether storage writes are paused.
+func (m *Metrics) SetStorageDegraded(degraded bool) {
+	if m == nil {
+		return
+	}
+	if degraded {
+		m.storageDegraded.Set(1)
+	} else {
+		m.storageDegraded.Set(0)
+	}
+}
+
+// RecordModelLoadingRetry records a retry of a model-loading error. It is
+// counted on top of RecordRetry.
+func (m *Metrics) RecordModelLoadingRetry(model string) {
+	if m == nil {
+		return
+	}
+	if model == "" {
+		model = "unknown"
+	}
+	m.loadingRetries.WithLabelValues(model).Inc()
+}
+
+// RecordLoopRetry records a request resent because its completion looped;
+// result is "rescued" or "looped".
+func (m *Metrics) RecordLoopRetry(model, result string) {
+	if m == nil {
+		return
+	}
+	m.loopRetries.WithLabelValues(modelLabel(model), result).Inc()
+}
+
+// RecordModelFallback records a request resent to fallback after model failed.
+func (m *Metrics) RecordModelFallback(model, fallback string) {
+	if m == nil {
+		return
+	}
+	m.modelFallbacks.WithLabelValues(modelLabel(model), modelLabel(fallback)).Inc()
+}
+
+// RecordQuotaRejected records a request refused by tag's quota of kind
+// (tokens or requests).
+func (m *Metrics) RecordQuotaRejected(tag, kind string) {
+	if m == nil {
+		return
+	}
+	m.quotaRejections.WithLabelValues(tag, kind).Inc()
+}
+
+// RecordPromptScanMatch records a prompt matching rule, whose action is
+// log, tag or reject.
+func (m *Metrics) RecordPromptScanMatch(rule, action string) {
+	if m == nil {
+		return
+	}
+	m.promptScans.WithLabelValues(rule, action).Inc()
+}
+
+// RecordResponseCacheLookup records a deterministic request for model being
+// answered from the response cache (hit) or sent on to Ollama (miss).
+func (m *Metrics) RecordResponseCacheLookup(model string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.responseCache.WithLabelValues(modelLabel(model), result).Inc()
+}
+
+// RecordVRAMGateRejected records a request for model rejected by the VRAM
+// gate.
+func (m *Metrics) RecordVRAMGateRejected(model string) {
+	if m == nil {
+		return
+	}
+	m.vramRejections.WithLabelValues(modelLabel(model)).Inc()
+}
+
 // RecordTimeout records a timeout event (deprecated, use RecordRequest).
 func (m *Metrics) RecordTimeout(timeoutType RequestStatus) {
 	// Now handled by RecordRequest with reason label
@@ -175,6 +504,32 @@ func (m *Metrics) UpdateInFlight(count int) {
 		return
 	}
 	m.inFlightRequests.Set(float64(count))
+	if m.inFlightWindow != nil {
+		m.inFlightWindow.set(count)
+	}
+}
+
+// EnableInFlightPeak adds oac_requests_in_flight_max and
+// oac_requests_in_flight_avg, the peak and time-weighted average in-flight
+// count since the previous scrape, to what Gatherer returns. Each gather
+// starts a new window, so they live in a registry of their own that metric
+// snapshots don't read. Call it before requests are tracked.
+func (m *Metrics) EnableInFlightPeak() {
+	if m == nil || m.inFlightWindow != nil {
+		return
+	}
+	m.inFlightWindow = newInFlightCollector(time.Now)
+	m.scrape = prometheus.NewRegistry()
+	m.scrape.MustRegister(m.inFlightWindow)
+}
+
+// Gatherer returns what /metrics serves: the default registry, plus the
+// scrape-only metrics when EnableInFlightPeak was called.
+func (m *Metrics) Gatherer() prometheus.Gatherer {
+	if m == nil || m.scrape == nil {
+		return prometheus.DefaultGatherer
+	}
+	return prometheus.Gatherers{prometheus.DefaultGatherer, m.scrape}
 }
 
 // UpdateUpstreamHealth updates the upstream health gauge.
@@ -188,3 +543,176 @@ func (m *Metrics) UpdateUpstreamHealth(healthy bool) {
 		m.upstreamHealthy.Set(0)
 	}
 }
+
+// SetEstimateRatio updates the rolling actual/estimated ratio for a model.
+func (m *Metrics) SetEstimateRatio(model string, ratio float64) {
+	if m == nil {
+		return
+	}
+	m.estimateRatio.WithLabelValues(modelLabel(model)).Set(ratio)
+}
+
+// RecordEstimateDivergence records a model's estimates diverging from actuals.
+func (m *Metrics) RecordEstimateDivergence(model string) {
+	if m == nil {
+		return
+	}
+	m.estimateDivergenceTotal.WithLabelValues(modelLabel(model)).Inc()
+}
+
+// RecordTruncationSuspected records a request whose prompt was likely truncated.
+func (m *Metrics) RecordTruncationSuspected(model string) {
+	if m == nil {
+		return
+	}
+	m.truncationSuspected.WithLabelValues(modelLabel(model)).Inc()
+}
+
+// SetSLO updates the latency SLO compliance ratio and burn rate.
+func (m *Metrics) SetSLO(compliance, burnRate float64) {
+	if m == nil {
+		return
+	}
+	m.sloCompliance.Set(compliance)
+	m.sloBurnRate.Set(burnRate)
+}
+
+// RecordEventDropped records an event the event bus dropped.
+func (m *Metrics) RecordEventDropped(reason string) {
+	if m == nil {
+		return
+	}
+	m.eventsDroppedTotal.WithLabelValues(reason).Inc()
+}
+
+// RecordModelEviction records the idle evictor unloading a model.
+func (m *Metrics) RecordModelEviction(model string) {
+	if m == nil {
+		return
+	}
+	m.modelEvictionsTotal.WithLabelValues(modelLabel(model)).Inc()
+}
+
+// RecordUpstreamStreamError records an upstream body failing after the response started.
+func (m *Metrics) RecordUpstreamStreamError(model string) {
+	if m == nil {
+		return
+	}
+	m.upstreamStreamErrorsTotal.WithLabelValues(modelLabel(model)).Inc()
+}
+
+// RecordShadowResult records the outcome of one shadow mirror.
+func (m *Metrics) RecordShadowResult(result string) {
+	if m == nil {
+		return
+	}
+	m.shadowRequestsTotal.WithLabelValues(result).Inc()
+}
+
+// Snapshot returns the current value of every oac_ metric summed over its
+// labels: counters and gauges under their name, histograms as <name>_sum and
+// <name>_count, plus one <name>{status="..."} entry per status label value.
+// A nil collector returns nil.
+func (m *Metrics) Snapshot() (map[string]float64, error) {
+	if m == nil {
+		return nil, nil
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64)
+	for _, mf := range families {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, MetricsPrefix) {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			var v float64
+			switch {
+			case metric.GetCounter() != nil:
+				v = metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				v = metric.GetGauge().GetValue()
+			case metric.GetHistogram() != nil:
+				out[name+"_sum"] += metric.GetHistogram().GetSampleSum()
+				out[name+"_count"] += float64(metric.GetHistogram().GetSampleCount())
+				continue
+			default:
+				continue
+			}
+			out[name] += v
+			for _, lp := range metric.GetLabel() {
+				if lp.GetName() == "status" {
+					out[name+`{status="`+lp.GetValue()+`"}`] += v
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func modelLabel(model string) string {
+	if model == "" {
+		return "unknown"
+	}
+	return model
+}
+
+// RecordModelQueued records how long a request waited for a slot under its
+// model's concurrency cap, and whether it was turned away.
+func (m *Metrics) RecordModelQueued(model string, wait time.Duration, rejected bool) {
+	if m == nil {
+		return
+	}
+	m.modelQueueWait.WithLabelValues(modelLabel(model)).Observe(wait.Seconds())
+	if rejected {
+		m.modelBusyRejections.WithLabelValues(modelLabel(model)).Inc()
+	}
+}
+
+// RecordLoadWait records how long a request was held while another request
+// loaded its model.
+func (m *Metrics) RecordLoadWait(model string, wait time.Duration) {
+	if m == nil {
+		return
+	}
+	m.loadCoalesceWait.WithLabelValues(modelLabel(model)).Observe(wait.Seconds())
+}
+
+// RecordResidency replaces the loaded-model VRAM gauges with models.
+func (m *Metrics) RecordResidency(models []ollama.RunningModel) {
+	if m == nil {
+		return
+	}
+	m.modelVRAMBytes.Reset()
+	for _, rm := range models {
+		m.modelVRAMBytes.WithLabelValues(modelLabel(rm.Name)).Set(float64(rm.SizeVRAM))
+	}
+}
+
+// RecordSessionEscalation records a request sized with an escalated session
+// output budget.
+func (m *Metrics) RecordSessionEscalation(model string) {
+	if m == nil {
+		return
+	}
+	m.sessionEscalationsTotal.WithLabelValues(modelLabel(model)).Inc()
+}
+
+// RecordUnstableCalibration records a request for model sized under
+// UNSTABLE_CALIBRATION_POLICY policy.
+func (m *Metrics) RecordUnstableCalibration(model, policy string) {
+	if m == nil {
+		return
+	}
+	m.unstableCalibration.WithLabelValues(modelLabel(model), policy).Inc()
+}
+
+// RecordPreferredCtx records a request for model given its preferred ctx.
+func (m *Metrics) RecordPreferredCtx(model string) {
+	if m == nil {
+		return
+	}
+	m.preferredCtx.WithLabelValues(modelLabel(model)).Inc()
+}
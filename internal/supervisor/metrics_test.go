@@ -3,6 +3,8 @@ package supervisor
 import (
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestMetrics_RecordRequest(t *testing.T) {
@@ -56,6 +58,47 @@ func TestMetrics_UpdateInFlight(t *testing.T) {
 	// Verify no panic
 }
 
+func TestInFlightCollector(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newInFlightCollector(func() time.Time { return now })
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	scrape := func() (peak, avg float64) {
+		t.Helper()
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range families {
+			v := mf.GetMetric()[0].GetGauge().GetValue()
+			switch mf.GetName() {
+			case "oac_requests_in_flight_max":
+				peak = v
+			case "oac_requests_in_flight_avg":
+				avg = v
+			}
+		}
+		return peak, avg
+	}
+
+	// A burst to 10 lasting 1s of a 10s window, back to 2 by the scrape.
+	c.set(2)
+	now = now.Add(5 * time.Second)
+	c.set(10)
+	now = now.Add(time.Second)
+	c.set(2)
+	now = now.Add(4 * time.Second)
+	if peak, avg := scrape(); peak != 10 || avg != 2.8 {
+		t.Errorf("got max %v avg %v, want 10 and 2.8", peak, avg)
+	}
+
+	// The next window starts at the count at the scrape.
+	now = now.Add(10 * time.Second)
+	if peak, avg := scrape(); peak != 2 || avg != 2 {
+		t.Errorf("after scrape got max %v avg %v, want 2 and 2", peak, avg)
+	}
+}
+
 func TestMetrics_UpdateUpstreamHealth(t *testing.T) {
 	metrics := NewMetrics()
 
@@ -0,0 +1,39 @@
+package supervisor
+
+import (
+	"log/slog"
+)
+
+// OverflowLog appends the non-success requests the tracker evicts from its
+// recent buffer to a JSONL file, one RequestInfo per line, so deployments
+// without storage keep their error history. The file is rotated to
+// <path>.1 past maxBytes (see JSONLFile). It is safe for concurrent use.
+type OverflowLog struct {
+	file *JSONLFile
+}
+
+// NewOverflowLog opens path for appending, creating it if needed.
+func NewOverflowLog(path string, maxBytes int64, logger *slog.Logger) (*OverflowLog, error) {
+	f, err := OpenJSONLFile(path, maxBytes, "overflow log", logger)
+	if err != nil {
+		return nil, err
+	}
+	return &OverflowLog{file: f}, nil
+}
+
+// Write appends info as one line, rotating the file first if it's full.
+// Failures are logged rather than returned; a nil log writes nothing.
+func (l *OverflowLog) Write(info RequestInfo) {
+	if l == nil {
+		return
+	}
+	l.file.Append(info)
+}
+
+// Close closes the file; later writes are dropped.
+func (l *OverflowLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
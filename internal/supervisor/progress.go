@@ -0,0 +1,104 @@
+package supervisor
+
+import (
+	"math"
+	"time"
+)
+
+// rateAlpha is the EMA weight for per-model generation speed.
+const rateAlpha = 0.2
+
+// minRateWindow is how long a request must have been generating before its own
+// speed is trusted as an ETA fallback.
+const minRateWindow = time.Second
+
+// Progress estimates how far along an in-flight request is.
+// Fields are omitted when there is not enough data to compute them.
+type Progress struct {
+	PercentComplete *float64 `json:"percent_complete,omitempty"`
+	ETASeconds      *float64 `json:"eta_seconds,omitempty"`
+	TokensPerSec    *float64 `json:"tokens_per_sec,omitempty"`
+	RateSource      string   `json:"rate_source,omitempty"` // model|request
+}
+
+// Progress estimates percent complete and remaining generation time for req
+// from its output budget and the model's observed tokens/sec. When the model
+// has no history yet, the request's own speed so far is used instead.
+func (t *Tracker) Progress(req RequestInfo, now time.Time) Progress {
+	var p Progress
+	est := float64(t.EstimatedOutputTokens(req))
+	budget := float64(req.OutputBudgetTokens)
+
+	if budget > 0 {
+		// Never report 100% while the request is still running.
+		pct := round1(math.Min(100*est/budget, 99))
+		p.PercentComplete = &pct
+	}
+
+	rate, ok := t.ModelTokensPerSec(req.Model)
+	source := "model"
+	if !ok && req.FirstByteTime != nil {
+		if elapsed := now.Sub(*req.FirstByteTime); elapsed >= minRateWindow && est > 0 {
+			rate, ok = est/elapsed.Seconds(), true
+			source = "request"
+		}
+	}
+	if !ok || rate <= 0 {
+		return p
+	}
+
+	r := round1(rate)
+	p.TokensPerSec = &r
+	p.RateSource = source
+	if budget > 0 {
+		eta := round1(math.Max(budget-est, 0) / rate)
+		p.ETASeconds = &eta
+	}
+	return p
+}
+
+// EstimatedOutputTokens converts a request's forwarded bytes into output tokens
+// using the tracker's calibration store.
+func (t *Tracker) EstimatedOutputTokens(req RequestInfo) int64 {
+	return EstimateOutputTokens(req.BytesForwarded, req.Model, t.calibStore, t.defaultTokensPerByte)
+}
+
+// ModelTokensPerSec returns the learned generation speed for model: the
+// calibration store's EvalTokensPerSec, from Ollama's own eval timings, or
+// else the speed learned here from finished requests' time since first byte.
+func (t *Tracker) ModelTokensPerSec(model string) (float64, bool) {
+	if t.calibStore != nil {
+		if rate := t.calibStore.Get(model).EvalTokensPerSec; rate > 0 {
+			return rate, true
+		}
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rate, ok := t.modelRates[model]
+	return rate, ok
+}
+
+// learnRateLocked folds a finished request's generation speed into the model's
+// EMA. Caller must hold t.mu.
+func (t *Tracker) learnRateLocked(req *RequestInfo, estimatedTokens int64, now time.Time) {
+	if req.Model == "" || req.FirstByteTime == nil {
+		return
+	}
+	tokens := float64(req.EvalCount)
+	if tokens <= 0 {
+		tokens = float64(estimatedTokens)
+	}
+	secs := now.Sub(*req.FirstByteTime).Seconds()
+	if tokens <= 0 || secs <= 0 {
+		return
+	}
+	rate := tokens / secs
+	if old, ok := t.modelRates[req.Model]; ok {
+		rate = old*(1-rateAlpha) + rate*rateAlpha
+	}
+	t.modelRates[req.Model] = rate
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
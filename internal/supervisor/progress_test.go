@@ -0,0 +1,107 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/calibration"
+)
+
+func TestTrackerProgress_NoData(t *testing.T) {
+	tracker := NewTracker(10, nil, nil, 0.25, 250*time.Millisecond, nil)
+
+	// No budget, no first byte, no model history: nothing can be estimated.
+	p := tracker.Progress(RequestInfo{Model: "llama2"}, time.Now())
+	if p.PercentComplete != nil || p.ETASeconds != nil || p.TokensPerSec != nil || p.RateSource != "" {
+		t.Fatalf("expected empty progress, got %+v", p)
+	}
+
+	// Budget only: percent but no ETA.
+	p = tracker.Progress(RequestInfo{Model: "llama2", OutputBudgetTokens: 100, BytesForwarded: 200}, time.Now())
+	if p.PercentComplete == nil || *p.PercentComplete != 50 {
+		t.Fatalf("expected 50%% complete, got %+v", p.PercentComplete)
+	}
+	if p.ETASeconds != nil {
+		t.Fatalf("expected no ETA without a rate, got %v", *p.ETASeconds)
+	}
+}
+
+func TestTrackerProgress_RequestRateFallback(t *testing.T) {
+	tracker := NewTracker(10, nil, nil, 0.25, 250*time.Millisecond, nil)
+
+	now := time.Now()
+	first := now.Add(-2 * time.Second)
+	req := RequestInfo{
+		Model:              "llama2",
+		FirstByteTime:      &first,
+		BytesForwarded:     400, // 100 tokens at 0.25 tok/byte
+		OutputBudgetTokens: 300,
+	}
+
+	p := tracker.Progress(req, now)
+	if p.RateSource != "request" || p.TokensPerSec == nil || *p.TokensPerSec != 50 {
+		t.Fatalf("expected 50 tok/s from request, got %+v", p)
+	}
+	if p.ETASeconds == nil || *p.ETASeconds != 4 { // 200 remaining / 50
+		t.Fatalf("expected ETA 4s, got %v", p.ETASeconds)
+	}
+
+	// Overrunning the budget caps percent below 100 and ETA at 0.
+	req.BytesForwarded = 4000
+	p = tracker.Progress(req, now)
+	if *p.PercentComplete != 99 || *p.ETASeconds != 0 {
+		t.Fatalf("expected 99%%/0s when over budget, got %v/%v", *p.PercentComplete, *p.ETASeconds)
+	}
+}
+
+func TestTrackerProgress_LearnsModelRate(t *testing.T) {
+	tracker := NewTracker(10, nil, nil, 0.25, 250*time.Millisecond, nil)
+
+	tracker.Start("req1", "chat", "llama2", true)
+	tracker.MarkFirstByte("req1")
+	tracker.UpdateTokenCounts("req1", 10, 100)
+	time.Sleep(20 * time.Millisecond)
+	tracker.Finish("req1", StatusSuccess, nil)
+
+	rate, ok := tracker.ModelTokensPerSec("llama2")
+	if !ok || rate <= 0 {
+		t.Fatalf("expected learned model rate, got %v (ok=%v)", rate, ok)
+	}
+
+	p := tracker.Progress(RequestInfo{Model: "llama2", OutputBudgetTokens: 100}, time.Now())
+	if p.RateSource != "model" || p.ETASeconds == nil {
+		t.Fatalf("expected ETA from model rate, got %+v", p)
+	}
+
+	// Failed requests don't teach a rate.
+	tracker.Start("req2", "chat", "other", true)
+	tracker.MarkFirstByte("req2")
+	tracker.Finish("req2", StatusTimeoutStall, nil)
+	if _, ok := tracker.ModelTokensPerSec("other"); ok {
+		t.Fatal("expected no rate learned from failed request")
+	}
+}
+
+func TestTrackerProgress_PrefersEvalRate(t *testing.T) {
+	calib := calibration.NewStore(0.2, calibration.Params{TokensPerByte: 0.25, FixedOverhead: 32, PerMessageOverhead: 8}, "")
+	tracker := NewTracker(10, nil, calib, 0.25, 250*time.Millisecond, nil)
+
+	tracker.Start("req1", "chat", "llama2", true)
+	tracker.MarkFirstByte("req1")
+	tracker.UpdateTokenCounts("req1", 10, 100)
+	time.Sleep(20 * time.Millisecond)
+	tracker.Finish("req1", StatusSuccess, nil)
+	if rate, ok := tracker.ModelTokensPerSec("llama2"); !ok || rate <= 0 {
+		t.Fatalf("expected the wall-clock rate before any eval rate, got %v (ok=%v)", rate, ok)
+	}
+
+	// Ollama's eval timings, once calibration has them, win.
+	calib.RecordEvalRate("llama2", 100, 4*time.Second)
+	p := tracker.Progress(RequestInfo{Model: "llama2", OutputBudgetTokens: 100}, time.Now())
+	if p.RateSource != "model" || p.TokensPerSec == nil || *p.TokensPerSec != 25 {
+		t.Fatalf("expected 25 tok/s from the eval rate, got %+v", p)
+	}
+	if p.ETASeconds == nil || *p.ETASeconds != 4 {
+		t.Errorf("expected ETA 4s, got %v", p.ETASeconds)
+	}
+}
@@ -0,0 +1,208 @@
+package supervisor
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+)
+
+// quotaSaveDebounce bounds how often quota usage is written to the backend;
+// a restart loses at most this much of it.
+const quotaSaveDebounce = 5 * time.Second
+
+// QuotaLimit caps what requests carrying one tag may use per quota window.
+// Zero leaves that dimension unlimited.
+type QuotaLimit struct {
+	Tokens   int64 `json:"tokens,omitempty"`
+	Requests int64 `json:"requests,omitempty"`
+}
+
+// QuotaBackend persists quota usage across restarts. *storage.SQLiteStore
+// satisfies it.
+type QuotaBackend interface {
+	LoadTagQuotaUsage(windowStart int64) (map[string]storage.TagQuotaUsage, error)
+	SaveTagQuotaUsage(windowStart int64, usage map[string]storage.TagQuotaUsage) error
+}
+
+// QuotaExceeded describes why TagQuotas refused a request.
+type QuotaExceeded struct {
+	Tag     string    // the "key=value" tag whose quota ran out
+	Kind    string    // "tokens" or "requests"
+	ResetAt time.Time // when the window, and the quota, restarts
+}
+
+// TagQuotaStatus is one tag's quota and its usage in the current window.
+type TagQuotaStatus struct {
+	Tag          string     `json:"tag"`
+	Limit        QuotaLimit `json:"limit"`
+	UsedTokens   int64      `json:"used_tokens"`
+	UsedRequests int64      `json:"used_requests"`
+	Rejected     int64      `json:"rejected"`
+	WindowStart  time.Time  `json:"window_start"`
+	ResetAt      time.Time  `json:"reset_at"`
+}
+
+// TagQuotas enforces per-tag token and request quotas over fixed windows
+// aligned as by time.Truncate, so a 24h window starts at UTC midnight.
+// Requests are charged their estimated tokens when admitted, so a quota can
+// be overrun by at most what the estimates fell short. Usage is kept in
+// memory and, with a backend, saved through it so it survives restarts. It
+// is safe for concurrent use.
+type TagQuotas struct {
+	limits  map[string]QuotaLimit
+	window  time.Duration
+	metrics *Metrics
+	logger  *slog.Logger
+
+	mu          sync.Mutex
+	windowStart time.Time
+	used        map[string]storage.TagQuotaUsage
+	rejected    map[string]int64
+	backend     QuotaBackend
+	timer       *time.Timer
+}
+
+// NewTagQuotas creates quotas for the "key=value" tags in limits.
+func NewTagQuotas(limits map[string]QuotaLimit, window time.Duration, metrics *Metrics, logger *slog.Logger) *TagQuotas {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &TagQuotas{
+		limits:      limits,
+		window:      window,
+		metrics:     metrics,
+		logger:      logger,
+		windowStart: time.Now().Truncate(window),
+		used:        make(map[string]storage.TagQuotaUsage),
+		rejected:    make(map[string]int64),
+	}
+}
+
+// SetBackend loads the current window's usage from b and saves later usage
+// through it, at most once per quotaSaveDebounce.
+func (q *TagQuotas) SetBackend(b QuotaBackend) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.backend = b
+	stored, err := b.LoadTagQuotaUsage(q.windowStart.UnixMilli())
+	if err != nil {
+		return err
+	}
+	for tag, u := range stored {
+		if _, ok := q.limits[tag]; ok {
+			q.used[tag] = u
+		}
+	}
+	return nil
+}
+
+// Admit charges a request carrying tags (in canonical stored form) and
+// estimated to use tokens against the quota of each of its tags that has
+// one. If any of them lacks room, nothing is charged and Admit reports that
+// quota instead.
+func (q *TagQuotas) Admit(tags string, tokens int, now time.Time) (QuotaExceeded, bool) {
+	if q == nil || tags == "" {
+		return QuotaExceeded{}, true
+	}
+	q.mu.Lock()
+	q.rollLocked(now)
+	var charged []string
+	for k, v := range storage.ParseTags(tags) {
+		tag := k + "=" + v
+		limit, ok := q.limits[tag]
+		if !ok {
+			continue
+		}
+		u := q.used[tag]
+		kind := ""
+		switch {
+		case limit.Requests > 0 && u.Requests+1 > limit.Requests:
+			kind = "requests"
+		case limit.Tokens > 0 && u.Tokens+int64(tokens) > limit.Tokens:
+			kind = "tokens"
+		}
+		if kind != "" {
+			q.rejected[tag]++
+			resetAt := q.windowStart.Add(q.window)
+			q.mu.Unlock()
+			q.metrics.RecordQuotaRejected(tag, kind)
+			return QuotaExceeded{Tag: tag, Kind: kind, ResetAt: resetAt}, false
+		}
+		charged = append(charged, tag)
+	}
+	for _, tag := range charged {
+		u := q.used[tag]
+		u.Tokens += int64(tokens)
+		u.Requests++
+		q.used[tag] = u
+	}
+	if len(charged) > 0 && q.backend != nil && q.timer == nil {
+		q.timer = time.AfterFunc(quotaSaveDebounce, func() {
+			if err := q.Flush(); err != nil {
+				q.logger.Warn("tag quotas: saving usage failed", "err", err)
+			}
+		})
+	}
+	q.mu.Unlock()
+	return QuotaExceeded{}, true
+}
+
+// rollLocked starts a new window, with nothing used, once now is past the
+// current one.
+func (q *TagQuotas) rollLocked(now time.Time) {
+	if now.Before(q.windowStart.Add(q.window)) {
+		return
+	}
+	q.windowStart = now.Truncate(q.window)
+	q.used = make(map[string]storage.TagQuotaUsage)
+	q.rejected = make(map[string]int64)
+}
+
+// Status returns every quota with its usage in the current window, by tag.
+func (q *TagQuotas) Status() []TagQuotaStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollLocked(time.Now())
+	out := make([]TagQuotaStatus, 0, len(q.limits))
+	for tag, limit := range q.limits {
+		u := q.used[tag]
+		out = append(out, TagQuotaStatus{
+			Tag:          tag,
+			Limit:        limit,
+			UsedTokens:   u.Tokens,
+			UsedRequests: u.Requests,
+			Rejected:     q.rejected[tag],
+			WindowStart:  q.windowStart,
+			ResetAt:      q.windowStart.Add(q.window),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tag < out[j].Tag })
+	return out
+}
+
+// Flush saves the current window's usage to the backend now, if there is one.
+func (q *TagQuotas) Flush() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	backend := q.backend
+	windowStart := q.windowStart.UnixMilli()
+	usage := make(map[string]storage.TagQuotaUsage, len(q.used))
+	for tag, u := range q.used {
+		usage[tag] = u
+	}
+	q.mu.Unlock()
+
+	if backend == nil {
+		return nil
+	}
+	return backend.SaveTagQuotaUsage(windowStart, usage)
+}
@@ -0,0 +1,90 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+)
+
+type memQuotaBackend struct {
+	windowStart int64
+	usage       map[string]storage.TagQuotaUsage
+}
+
+func (b *memQuotaBackend) LoadTagQuotaUsage(windowStart int64) (map[string]storage.TagQuotaUsage, error) {
+	if windowStart != b.windowStart {
+		return nil, nil
+	}
+	return b.usage, nil
+}
+
+func (b *memQuotaBackend) SaveTagQuotaUsage(windowStart int64, usage map[string]storage.TagQuotaUsage) error {
+	b.windowStart, b.usage = windowStart, usage
+	return nil
+}
+
+func TestTagQuotas(t *testing.T) {
+	q := NewTagQuotas(map[string]QuotaLimit{
+		"team=search": {Tokens: 1000},
+		"project=rag": {Requests: 2},
+	}, time.Hour, nil, nil)
+	now := time.Now()
+
+	if _, ok := q.Admit("team=search", 600, now); !ok {
+		t.Fatal("first request refused")
+	}
+	if _, ok := q.Admit("env=dev", 1<<30, now); !ok {
+		t.Fatal("a request without quota'd tags was refused")
+	}
+	ex, ok := q.Admit("project=rag,team=search", 500, now)
+	if ok || ex.Tag != "team=search" || ex.Kind != "tokens" || !ex.ResetAt.After(now) {
+		t.Fatalf("expected the token quota exhausted, got %+v, %v", ex, ok)
+	}
+	// The refused request charged neither quota.
+	if _, ok := q.Admit("project=rag,team=search", 400, now); !ok {
+		t.Fatal("request within both quotas refused")
+	}
+	if _, ok := q.Admit("project=rag", 1, now); !ok {
+		t.Fatal("second rag request refused")
+	}
+	if ex, ok := q.Admit("project=rag", 1, now); ok || ex.Kind != "requests" {
+		t.Fatalf("expected the request quota exhausted, got %+v, %v", ex, ok)
+	}
+
+	status := q.Status()
+	if len(status) != 2 || status[0].Tag != "project=rag" || status[0].UsedRequests != 2 || status[0].Rejected != 1 ||
+		status[1].UsedTokens != 1000 || status[1].Rejected != 1 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// A new window starts from nothing.
+	if _, ok := q.Admit("project=rag,team=search", 1000, now.Add(time.Hour)); !ok {
+		t.Error("request refused in the next window")
+	}
+}
+
+func TestTagQuotasBackend(t *testing.T) {
+	limits := map[string]QuotaLimit{"team=search": {Tokens: 1000}}
+	b := &memQuotaBackend{}
+	q := NewTagQuotas(limits, time.Hour, nil, nil)
+	if err := q.SetBackend(b); err != nil {
+		t.Fatal(err)
+	}
+	q.Admit("team=search", 700, time.Now())
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted proxy picks up where the last one left off.
+	restarted := NewTagQuotas(limits, time.Hour, nil, nil)
+	if err := restarted.SetBackend(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.Admit("team=search", 400, time.Now()); ok {
+		t.Error("restored usage not counted against the quota")
+	}
+	if s := restarted.Status(); s[0].UsedTokens != 700 {
+		t.Errorf("restored usage = %+v", s)
+	}
+}
@@ -0,0 +1,170 @@
+package supervisor
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/ollama"
+)
+
+// ModelLister lists the models Ollama has loaded. *ollama.Client satisfies it.
+type ModelLister interface {
+	Running(ctx context.Context) ([]ollama.RunningModel, error)
+}
+
+// Residency is what the residency poller last saw of Ollama's loaded models.
+// When the latest poll failed, Available is false and Models and VRAMBytes
+// are from the last successful poll at PolledAt, if any.
+type Residency struct {
+	Available bool                  `json:"available"`
+	Error     string                `json:"error,omitempty"`
+	PolledAt  time.Time             `json:"polled_at,omitzero"`
+	VRAMBytes int64                 `json:"vram_bytes"` // loaded VRAM across Models
+	Models    []ollama.RunningModel `json:"models"`
+}
+
+// ResidencyPoller polls /api/ps every interval so the proxy knows which
+// models are resident and the VRAM they hold, without each consumer asking
+// Ollama itself. A failing /api/ps (an old Ollama, or Ollama down) is logged
+// once and leaves the last successful result in place, marked unavailable.
+// It is safe for concurrent use.
+type ResidencyPoller struct {
+	lister   ModelLister
+	interval time.Duration
+	timeout  time.Duration
+	metrics  *Metrics
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	last Residency
+	seen map[string]ollama.RunningModel // each model as last seen loaded
+
+	stopCh chan struct{}
+	once   sync.Once
+}
+
+// NewResidencyPoller creates a poller. Call Start to poll in the background.
+func NewResidencyPoller(lister ModelLister, interval time.Duration, metrics *Metrics, logger *slog.Logger) *ResidencyPoller {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &ResidencyPoller{
+		lister:   lister,
+		interval: interval,
+		timeout:  min(interval, 10*time.Second),
+		metrics:  metrics,
+		logger:   logger,
+		seen:     make(map[string]ollama.RunningModel),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start polls now and then every interval until Shutdown.
+func (p *ResidencyPoller) Start() {
+	go func() {
+		p.Poll()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.Poll()
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops background polling.
+func (p *ResidencyPoller) Shutdown() {
+	p.once.Do(func() { close(p.stopCh) })
+}
+
+// Poll lists the loaded models once and returns the updated residency.
+func (p *ResidencyPoller) Poll() Residency {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	models, err := p.lister.Running(ctx)
+	cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		if p.last.Available || p.last.Error == "" {
+			p.logger.Warn("residency: listing loaded models failed; keeping the last result", "err", err)
+		}
+		p.last.Available = false
+		p.last.Error = err.Error()
+		return p.copyLocked()
+	}
+	if !p.last.Available && p.last.Error != "" {
+		p.logger.Info("residency: listing loaded models recovered")
+	}
+	var vram int64
+	for _, m := range models {
+		vram += m.SizeVRAM
+		p.seen[canonicalModelName(m.Name)] = m
+	}
+	p.last = Residency{Available: true, PolledAt: time.Now(), VRAMBytes: vram, Models: models}
+	p.metrics.RecordResidency(models)
+	return p.copyLocked()
+}
+
+// Snapshot returns the latest residency; a nil poller has none.
+func (p *ResidencyPoller) Snapshot() Residency {
+	if p == nil {
+		return Residency{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.copyLocked()
+}
+
+// Fresh returns the loaded models if the latest poll succeeded within
+// maxAge.
+func (p *ResidencyPoller) Fresh(maxAge time.Duration) ([]ollama.RunningModel, bool) {
+	if p == nil {
+		return nil, false
+	}
+	r := p.Snapshot()
+	if !r.Available || time.Since(r.PolledAt) > maxAge {
+		return nil, false
+	}
+	return r.Models, true
+}
+
+// LoadedExcept returns the VRAM held by the loaded models other than model,
+// if the latest poll succeeded within maxAge.
+func (p *ResidencyPoller) LoadedExcept(model string, maxAge time.Duration) (int64, bool) {
+	models, ok := p.Fresh(maxAge)
+	if !ok {
+		return 0, false
+	}
+	model = canonicalModelName(model)
+	var vram int64
+	for _, m := range models {
+		if canonicalModelName(m.Name) != model {
+			vram += m.SizeVRAM
+		}
+	}
+	return vram, true
+}
+
+// Seen returns model as a poll last saw it loaded, with the VRAM it held.
+func (p *ResidencyPoller) Seen(model string) (ollama.RunningModel, bool) {
+	if p == nil {
+		return ollama.RunningModel{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m, ok := p.seen[canonicalModelName(model)]
+	return m, ok
+}
+
+func (p *ResidencyPoller) copyLocked() Residency {
+	r := p.last
+	r.Models = append([]ollama.RunningModel{}, p.last.Models...)
+	return r
+}
@@ -0,0 +1,85 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/ollama"
+)
+
+type flakyLister struct {
+	models []ollama.RunningModel
+	err    error
+	calls  int
+}
+
+func (f *flakyLister) Running(ctx context.Context) ([]ollama.RunningModel, error) {
+	f.calls++
+	return f.models, f.err
+}
+
+func TestResidencyPoller(t *testing.T) {
+	l := &flakyLister{models: []ollama.RunningModel{
+		{Name: "a:latest", SizeVRAM: 4 * gib},
+		{Name: "b:7b", SizeVRAM: 2 * gib},
+	}}
+	p := NewResidencyPoller(l, time.Minute, nil, nil)
+
+	if r := p.Snapshot(); r.Available || len(r.Models) != 0 {
+		t.Fatalf("residency before the first poll = %+v", r)
+	}
+	r := p.Poll()
+	if !r.Available || r.VRAMBytes != 6*gib || len(r.Models) != 2 || r.PolledAt.IsZero() {
+		t.Fatalf("residency = %+v", r)
+	}
+
+	// A failing /api/ps keeps the last result, marked unavailable.
+	l.err = errors.New("404 page not found")
+	r = p.Poll()
+	if r.Available || r.Error == "" || len(r.Models) != 2 || r.VRAMBytes != 6*gib {
+		t.Fatalf("residency after a failed poll = %+v", r)
+	}
+	if _, ok := p.Fresh(time.Hour); ok {
+		t.Error("Fresh reported an unavailable residency")
+	}
+
+	l.err = nil
+	l.models = l.models[:1]
+	p.Poll()
+	if models, ok := p.Fresh(time.Hour); !ok || len(models) != 1 {
+		t.Errorf("Fresh after recovery = %v, %v", models, ok)
+	}
+
+	var nilPoller *ResidencyPoller
+	if _, ok := nilPoller.Fresh(time.Hour); ok {
+		t.Error("a nil poller reported models")
+	}
+}
+
+func TestIdleEvictor_UsesFreshResidency(t *testing.T) {
+	rt := &fakeRuntime{running: []ollama.RunningModel{{Name: "stale:latest"}}}
+	l := &flakyLister{models: []ollama.RunningModel{{Name: "idle:latest", SizeVRAM: gib}}}
+	p := NewResidencyPoller(l, time.Minute, nil, nil)
+	e := NewIdleEvictor(rt, IdleEvictorConfig{IdleAfter: time.Minute, Always: true}, nil, nil)
+	e.SetResidencyPoller(p)
+	e.Begin("idle")
+	e.End("idle")
+	e.Begin("stale")
+	e.End("stale")
+
+	p.Poll()
+	got := e.sweep(time.Now().Add(time.Hour))
+	if len(got) != 1 || got[0].Model != "idle:latest" {
+		t.Fatalf("expected the poller's idle:latest evicted, got %+v", got)
+	}
+
+	// Without a fresh poll the evictor asks Ollama itself.
+	l.err = errors.New("connection refused")
+	p.Poll()
+	got = e.sweep(time.Now().Add(time.Hour))
+	if len(got) != 1 || got[0].Model != "stale:latest" {
+		t.Fatalf("expected the runtime's stale:latest evicted, got %+v", got)
+	}
+}
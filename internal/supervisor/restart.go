@@ -1,9 +1,7 @@
 package supervisor
 
 import (
-	"context"
 	"log/slog"
-	"os/exec"
 	"sync"
 	"time"
 )
@@ -21,21 +19,25 @@ type RestartConfig struct {
 // RestartHook manages Ollama restart operations with safety guards.
 // It tracks consecutive timeouts and triggers restarts when thresholds are met.
 type RestartHook struct {
-	cfg                RestartConfig
-	logger             *slog.Logger
-	mu                 sync.Mutex
+	cfg                 RestartConfig
+	runner              *hookRunner
+	mu                  sync.Mutex
 	consecutiveTimeouts int
-	restartHistory     []time.Time // timestamps of restarts in the last hour
-	lastRestart        time.Time
-	restartInProgress  bool
 }
 
 // NewRestartHook creates a new restart hook with the given configuration.
 func NewRestartHook(cfg RestartConfig, logger *slog.Logger) *RestartHook {
 	return &RestartHook{
-		cfg:            cfg,
-		logger:         logger,
-		restartHistory: make([]time.Time, 0, cfg.MaxPerHour+1),
+		cfg: cfg,
+		runner: &hookRunner{
+			name:       "restart",
+			command:    cfg.Command,
+			cooldown:   cfg.Cooldown,
+			maxPerHour: cfg.MaxPerHour,
+			timeout:    cfg.CommandTimeout,
+			logger:     logger,
+			history:    make([]time.Time, 0, cfg.MaxPerHour+1),
+		},
 	}
 }
 
@@ -50,9 +52,10 @@ func (rh *RestartHook) RecordTimeout() bool {
 	defer rh.mu.Unlock()
 
 	rh.consecutiveTimeouts++
-	
-	if rh.consecutiveTimeouts >= rh.cfg.TriggerConsecTimeouts {
-		return rh.triggerRestartLocked()
+
+	if rh.consecutiveTimeouts >= rh.cfg.TriggerConsecTimeouts && rh.runner.tryRun(nil) {
+		rh.consecutiveTimeouts = 0
+		return true
 	}
 	return false
 }
@@ -64,92 +67,6 @@ func (rh *RestartHook) RecordSuccess() {
 	rh.consecutiveTimeouts = 0
 }
 
-// triggerRestartLocked attempts to trigger a restart. Caller must hold mutex.
-func (rh *RestartHook) triggerRestartLocked() bool {
-	// Check if restart is already in progress
-	if rh.restartInProgress {
-		rh.logger.Debug("restart already in progress, skipping")
-		return false
-	}
-
-	// Check cooldown
-	if time.Since(rh.lastRestart) < rh.cfg.Cooldown {
-		rh.logger.Debug("restart cooldown not elapsed",
-			"since_last", time.Since(rh.lastRestart),
-			"cooldown", rh.cfg.Cooldown)
-		return false
-	}
-
-	// Check rate limit (max per hour)
-	rh.pruneHistoryLocked()
-	if len(rh.restartHistory) >= rh.cfg.MaxPerHour {
-		rh.logger.Warn("restart rate limit reached",
-			"restarts_this_hour", len(rh.restartHistory),
-			"max_per_hour", rh.cfg.MaxPerHour)
-		return false
-	}
-
-	// Mark restart in progress and reset counter
-	rh.restartInProgress = true
-	rh.consecutiveTimeouts = 0
-
-	// Execute restart asynchronously
-	go rh.executeRestart()
-
-	return true
-}
-
-// pruneHistoryLocked removes restart entries older than 1 hour. Caller must hold mutex.
-func (rh *RestartHook) pruneHistoryLocked() {
-	cutoff := time.Now().Add(-time.Hour)
-	newHistory := rh.restartHistory[:0]
-	for _, t := range rh.restartHistory {
-		if t.After(cutoff) {
-			newHistory = append(newHistory, t)
-		}
-	}
-	rh.restartHistory = newHistory
-}
-
-// executeRestart runs the restart command asynchronously.
-func (rh *RestartHook) executeRestart() {
-	defer func() {
-		// Fail-open: if we panic, mark restart as not in progress
-		if r := recover(); r != nil {
-			rh.logger.Error("restart panic recovered", "panic", r)
-		}
-		rh.mu.Lock()
-		rh.restartInProgress = false
-		rh.mu.Unlock()
-	}()
-
-	rh.logger.Info("executing restart command", "command", rh.cfg.Command)
-
-	ctx, cancel := context.WithTimeout(context.Background(), rh.cfg.CommandTimeout)
-	defer cancel()
-
-	// Execute via shell
-	cmd := exec.CommandContext(ctx, "sh", "-c", rh.cfg.Command)
-	output, err := cmd.CombinedOutput()
-
-	rh.mu.Lock()
-	rh.lastRestart = time.Now()
-	rh.restartHistory = append(rh.restartHistory, rh.lastRestart)
-	rh.mu.Unlock()
-
-	if err != nil {
-		rh.logger.Error("restart command failed",
-			"command", rh.cfg.Command,
-			"error", err,
-			"output", string(output))
-		return
-	}
-
-	rh.logger.Info("restart command completed successfully",
-		"command", rh.cfg.Command,
-		"output", string(output))
-}
-
 // GetStats returns current restart hook statistics.
 type RestartStats struct {
 	ConsecutiveTimeouts int
@@ -162,15 +79,14 @@ func (rh *RestartHook) GetStats() RestartStats {
 	rh.mu.Lock()
 	defer rh.mu.Unlock()
 
-	rh.pruneHistoryLocked()
-
+	runs, last, running := rh.runner.stats()
 	stats := RestartStats{
 		ConsecutiveTimeouts: rh.consecutiveTimeouts,
-		RestartsThisHour:    len(rh.restartHistory),
-		RestartInProgress:   rh.restartInProgress,
+		RestartsThisHour:    runs,
+		RestartInProgress:   running,
 	}
-	if !rh.lastRestart.IsZero() {
-		stats.LastRestart = &rh.lastRestart
+	if !last.IsZero() {
+		stats.LastRestart = &last
 	}
 	return stats
 }
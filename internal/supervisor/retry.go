@@ -3,8 +3,10 @@ package supervisor
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -15,6 +17,9 @@ type RetryConfig struct {
 	Backoff           time.Duration // SUPERVISOR_RETRY_BACKOFF (default 250ms)
 	OnlyNonStreaming  bool          // SUPERVISOR_RETRY_ONLY_NON_STREAMING (default true)
 	MaxResponseBytes  int64         // SUPERVISOR_RETRY_MAX_RESPONSE_BYTES (default 8MB)
+	MinEvalCount      int           // RETRY_MIN_EVAL_COUNT: retry 200s with fewer output tokens (0 disables)
+	RetryLoading      bool          // RETRY_ON_MODEL_LOADING: retry model-loading errors, streaming or not
+	LoadingBackoff    time.Duration // RETRY_LOADING_BACKOFF_MS (default Backoff)
 }
 
 // RetryResult represents the outcome of a retried request.
@@ -24,6 +29,8 @@ type RetryResult struct {
 	Attempts   int
 	LastError  error
 	TooLarge   bool   // response exceeded MaxResponseBytes
+	Empty      int    // attempts that returned an empty completion
+	Loading    int    // attempts retried because the model was still loading
 }
 
 // Retryer handles retry logic for non-streaming requests.
@@ -43,19 +50,82 @@ func NewRetryer(cfg RetryConfig) *Retryer {
 	}
 }
 
+// Retry reasons returned by ClassifyRetry.
+const (
+	RetryReasonConnection   = "connection_error"
+	RetryReasonServerError  = "server_error"
+	RetryReasonModelLoading = "model_loading"
+)
+
+// ClassifyRetry says why a response/error warrants a retry, or "" when it
+// doesn't. Model-loading errors are told apart from other 5xx (see
+// IsModelLoading).
+func ClassifyRetry(resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		return RetryReasonConnection
+	case IsModelLoading(resp):
+		return RetryReasonModelLoading
+	case resp != nil && resp.StatusCode >= 500:
+		return RetryReasonServerError
+	}
+	return ""
+}
+
 // ShouldRetry determines if a response/error warrants a retry.
 func ShouldRetry(resp *http.Response, err error) bool {
-	// Retry on connection errors
-	if err != nil {
-		return true
+	return ClassifyRetry(resp, err) != ""
+}
+
+// modelLoadingPhrases mark Ollama errors for a model that is still loading.
+var modelLoadingPhrases = []string{"loading model", "model is loading", "still loading"}
+
+// IsModelLoading reports whether resp is the transient error Ollama answers
+// with while a model is being loaded: a 503, or a 5xx whose error mentions
+// loading. The start of the body is read to check and put back, so resp
+// can still be forwarded.
+func IsModelLoading(resp *http.Response) bool {
+	if resp == nil || resp.StatusCode < 500 {
+		return false
 	}
-	// Retry on 5xx errors
-	if resp != nil && resp.StatusCode >= 500 {
+	if resp.StatusCode == http.StatusServiceUnavailable {
 		return true
 	}
+	if resp.Body == nil {
+		return false
+	}
+	peek, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+	msg := strings.ToLower(string(peek))
+	for _, phrase := range modelLoadingPhrases {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
 	return false
 }
 
+// IsEmptyCompletion reports whether a non-streaming Ollama response body is a
+// finished completion with fewer than minEvalCount output tokens. A missing
+// eval_count on a done response counts as zero. Bodies that aren't a finished
+// completion (errors, other endpoints) are never empty.
+func IsEmptyCompletion(body []byte, minEvalCount int) bool {
+	if minEvalCount <= 0 {
+		return false
+	}
+	var r struct {
+		Done      bool `json:"done"`
+		EvalCount int  `json:"eval_count"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil || !r.Done {
+		return false
+	}
+	return r.EvalCount < minEvalCount
+}
+
 // IsEligible checks if a request is eligible for retry.
 // Only non-streaming requests to /api/chat and /api/generate are eligible.
 func (r *Retryer) IsEligible(req *http.Request, clientStream bool, endpoint string) bool {
@@ -73,6 +143,123 @@ func (r *Retryer) IsEligible(req *http.Request, clientStream bool, endpoint stri
 	return true
 }
 
+// RetriesLoading reports whether requests to endpoint that aren't otherwise
+// eligible (e.g. streaming ones) still have model-loading errors retried.
+// Those errors arrive before any output, so retrying them never replays a
+// partial stream.
+func (r *Retryer) RetriesLoading(endpoint string) bool {
+	return r.cfg.Enabled && r.cfg.RetryLoading && (endpoint == "chat" || endpoint == "generate")
+}
+
+func (r *Retryer) loadingBackoff() time.Duration {
+	if r.cfg.LoadingBackoff > 0 {
+		return r.cfg.LoadingBackoff
+	}
+	return r.cfg.Backoff
+}
+
+// RoundTripLoading sends req through rt, resending it after LoadingBackoff
+// while the upstream answers that the model is still loading, up to
+// MaxAttempts in all. body must hold req's full body. The final response is
+// returned unbuffered (Body is nil in the result), so streams pass through.
+func (r *Retryer) RoundTripLoading(rt http.RoundTripper, req *http.Request, body []byte) RetryResult {
+	result := RetryResult{}
+	for attempt := 1; attempt <= r.cfg.MaxAttempts; attempt++ {
+		result.Attempts = attempt
+		try := req.Clone(req.Context())
+		try.Body = io.NopCloser(bytes.NewReader(body))
+		try.ContentLength = int64(len(body))
+
+		resp, err := rt.RoundTrip(try)
+		if err != nil {
+			result.LastError = err
+			return result
+		}
+		if attempt == r.cfg.MaxAttempts || !IsModelLoading(resp) {
+			result.Response = resp
+			return result
+		}
+		_ = resp.Body.Close()
+		result.Loading++
+		select {
+		case <-req.Context().Done():
+			result.LastError = req.Context().Err()
+			return result
+		case <-time.After(r.loadingBackoff()):
+		}
+	}
+	return result
+}
+
+// RoundTripEmpty sends req through rt, resending it after Backoff while a 200
+// comes back as an empty completion (see IsEmptyCompletion), up to
+// MaxAttempts in all. Model-loading errors are also resent when RetryLoading
+// is set; other errors and non-200 responses are returned as they are. body
+// must hold req's full body. A 200 is buffered into Body to be checked unless
+// it exceeds MaxResponseBytes, in which case it's returned unbuffered (Body
+// is nil) without a retry.
+func (r *Retryer) RoundTripEmpty(rt http.RoundTripper, req *http.Request, body []byte) RetryResult {
+	result := RetryResult{}
+	for attempt := 1; attempt <= r.cfg.MaxAttempts; attempt++ {
+		result.Attempts = attempt
+		try := req.Clone(req.Context())
+		try.Body = io.NopCloser(bytes.NewReader(body))
+		try.ContentLength = int64(len(body))
+
+		resp, err := rt.RoundTrip(try)
+		if err != nil {
+			result.LastError = err
+			return result
+		}
+		backoff := r.cfg.Backoff
+		switch {
+		case r.cfg.RetryLoading && attempt < r.cfg.MaxAttempts && IsModelLoading(resp):
+			_ = resp.Body.Close()
+			result.Loading++
+			backoff = r.loadingBackoff()
+		case resp.StatusCode != http.StatusOK:
+			result.Response = resp
+			return result
+		default:
+			var src io.Reader = resp.Body
+			if r.cfg.MaxResponseBytes > 0 {
+				src = io.LimitReader(resp.Body, r.cfg.MaxResponseBytes+1)
+			}
+			peek, err := io.ReadAll(src)
+			if err != nil {
+				_ = resp.Body.Close()
+				result.LastError = err
+				return result
+			}
+			result.Response = resp
+			if r.cfg.MaxResponseBytes > 0 && int64(len(peek)) > r.cfg.MaxResponseBytes {
+				resp.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+				result.Body = nil
+				return result
+			}
+			_ = resp.Body.Close()
+			result.Body = peek
+			if !IsEmptyCompletion(peek, r.cfg.MinEvalCount) {
+				return result
+			}
+			result.Empty++
+			if attempt == r.cfg.MaxAttempts {
+				return result
+			}
+		}
+		select {
+		case <-req.Context().Done():
+			result.LastError = req.Context().Err()
+			return result
+		case <-time.After(backoff):
+		}
+	}
+	return result
+}
+
 // DoWithRetry executes a request with retry logic.
 // The requestBody should be the complete body bytes to send.
 // Returns the result including buffered response body on success.
@@ -115,14 +302,19 @@ func (r *Retryer) DoWithRetry(ctx context.Context, upstreamURL string, method st
 		}
 
 		// Check if we should retry based on response
-		if ShouldRetry(resp, nil) && attempt < r.cfg.MaxAttempts {
+		if reason := ClassifyRetry(resp, nil); reason != "" && attempt < r.cfg.MaxAttempts {
 			_ = resp.Body.Close()
 			result.LastError = nil
+			backoff := r.cfg.Backoff
+			if reason == RetryReasonModelLoading {
+				result.Loading++
+				backoff = r.loadingBackoff()
+			}
 			// Wait before retry
 			select {
 			case <-ctx.Done():
 				return result
-			case <-time.After(r.cfg.Backoff):
+			case <-time.After(backoff):
 			}
 			continue
 		}
@@ -140,6 +332,19 @@ func (r *Retryer) DoWithRetry(ctx context.Context, upstreamURL string, method st
 			return result
 		}
 		result.Body = body
+
+		// A 200 with no output is a silent failure; retry it like a 5xx.
+		if resp.StatusCode == http.StatusOK && IsEmptyCompletion(body, r.cfg.MinEvalCount) {
+			result.Empty++
+			if attempt < r.cfg.MaxAttempts {
+				select {
+				case <-ctx.Done():
+					return result
+				case <-time.After(r.cfg.Backoff):
+				}
+				continue
+			}
+		}
 		return result
 	}
 
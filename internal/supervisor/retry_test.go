@@ -2,8 +2,10 @@ package supervisor
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -318,3 +320,180 @@ func TestRetryer_DoWithRetry_ResponseTooLarge(t *testing.T) {
 		t.Errorf("expected TooLarge flag to be set")
 	}
 }
+
+func TestIsEmptyCompletion(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		min  int
+		want bool
+	}{
+		{"zero eval_count", `{"done":true,"eval_count":0}`, 1, true},
+		{"missing eval_count", `{"done":true}`, 1, true},
+		{"below threshold", `{"done":true,"eval_count":2}`, 3, true},
+		{"at threshold", `{"done":true,"eval_count":3}`, 3, false},
+		{"not done", `{"done":false,"eval_count":0}`, 1, false},
+		{"error body", `{"error":"model not found"}`, 1, false},
+		{"invalid json", `not json`, 1, false},
+		{"disabled", `{"done":true,"eval_count":0}`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEmptyCompletion([]byte(tt.body), tt.min); got != tt.want {
+				t.Errorf("IsEmptyCompletion(%s, %d) = %v, want %v", tt.body, tt.min, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryer_DoWithRetry_RetryOnEmptyCompletion(t *testing.T) {
+	attempts := 0
+	// Server that returns an empty completion once then a real one
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusOK)
+		if attempts == 1 {
+			w.Write([]byte(`{"done":true,"eval_count":0}`))
+			return
+		}
+		w.Write([]byte(`{"done":true,"eval_count":12}`))
+	}))
+	defer server.Close()
+
+	retryer := NewRetryer(RetryConfig{
+		Enabled:          true,
+		MaxAttempts:      3,
+		Backoff:          10 * time.Millisecond,
+		MaxResponseBytes: 1024,
+		MinEvalCount:     1,
+	})
+	result := retryer.DoWithRetry(context.Background(), server.URL, http.MethodPost, []byte(`{}`), nil)
+
+	if result.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", result.Attempts)
+	}
+	if result.Empty != 1 {
+		t.Errorf("expected 1 empty response, got %d", result.Empty)
+	}
+	if string(result.Body) != `{"done":true,"eval_count":12}` {
+		t.Errorf("unexpected body %s", result.Body)
+	}
+}
+
+func TestClassifyRetry(t *testing.T) {
+	resp := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+	}
+	tests := []struct {
+		name string
+		resp *http.Response
+		err  error
+		want string
+	}{
+		{"connection error", nil, context.DeadlineExceeded, RetryReasonConnection},
+		{"503", resp(503, `{"error":"server busy"}`), nil, RetryReasonModelLoading},
+		{"500 loading", resp(500, `{"error":"llm server Loading Model"}`), nil, RetryReasonModelLoading},
+		{"500 other", resp(500, `{"error":"out of memory"}`), nil, RetryReasonServerError},
+		{"500 without body", &http.Response{StatusCode: 500}, nil, RetryReasonServerError},
+		{"200", resp(200, `{"done":true}`), nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyRetry(tt.resp, tt.err); got != tt.want {
+				t.Errorf("ClassifyRetry() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The peeked body is still readable in full.
+	r := resp(500, `{"error":"model is loading"}`)
+	IsModelLoading(r)
+	if body, _ := io.ReadAll(r.Body); string(body) != `{"error":"model is loading"}` {
+		t.Errorf("body after IsModelLoading = %q", body)
+	}
+}
+
+func TestRetryer_RoundTripLoading(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if body, _ := io.ReadAll(r.Body); string(body) != `{"stream":true}` {
+			t.Errorf("attempt %d got body %q", attempts, body)
+		}
+		if attempts <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("{\"done\":false}\n{\"done\":true}\n"))
+	}))
+	defer server.Close()
+
+	retryer := NewRetryer(RetryConfig{Enabled: true, MaxAttempts: 3, Backoff: time.Hour, RetryLoading: true, LoadingBackoff: time.Millisecond})
+	if !retryer.RetriesLoading("chat") || retryer.RetriesLoading("embed") {
+		t.Fatal("RetriesLoading should cover chat/generate only")
+	}
+	req, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+	result := retryer.RoundTripLoading(http.DefaultTransport, req, []byte(`{"stream":true}`))
+	if result.LastError != nil || result.Response == nil || result.Response.StatusCode != http.StatusOK {
+		t.Fatalf("expected a 200 after retries, got %+v", result)
+	}
+	defer result.Response.Body.Close()
+	if result.Attempts != 3 || result.Loading != 2 || result.Body != nil {
+		t.Errorf("got attempts=%d loading=%d buffered=%v, want 3, 2 and an unbuffered body", result.Attempts, result.Loading, result.Body != nil)
+	}
+
+	// The last loading error is returned as is.
+	attempts = -10
+	result = retryer.RoundTripLoading(http.DefaultTransport, req, []byte(`{"stream":true}`))
+	if result.Response == nil || result.Response.StatusCode != http.StatusServiceUnavailable || result.Loading != 2 {
+		t.Errorf("expected the final 503 after 2 loading retries, got %+v", result)
+	}
+}
+
+func TestRetryer_RoundTripEmpty(t *testing.T) {
+	var attempts int
+	var reply func(w http.ResponseWriter)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		reply(w)
+	}))
+	defer server.Close()
+
+	retryer := NewRetryer(RetryConfig{Enabled: true, MaxAttempts: 3, Backoff: time.Millisecond, MaxResponseBytes: 64, MinEvalCount: 1})
+	req, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+
+	// Empty completions are retried and the final body is buffered.
+	attempts = 0
+	reply = func(w http.ResponseWriter) {
+		if attempts == 1 {
+			w.Write([]byte(`{"done":true,"eval_count":0}`))
+			return
+		}
+		w.Write([]byte(`{"done":true,"eval_count":5}`))
+	}
+	result := retryer.RoundTripEmpty(http.DefaultTransport, req, []byte(`{}`))
+	if result.Attempts != 2 || result.Empty != 1 || string(result.Body) != `{"done":true,"eval_count":5}` {
+		t.Errorf("empty retry: got attempts=%d empty=%d body=%q", result.Attempts, result.Empty, result.Body)
+	}
+
+	// 5xx are not retried.
+	attempts = 0
+	reply = func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) }
+	result = retryer.RoundTripEmpty(http.DefaultTransport, req, []byte(`{}`))
+	if attempts != 1 || result.Response == nil || result.Response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("5xx: expected a single attempt returned as is, got %d attempts, %+v", attempts, result)
+	}
+
+	// Responses over MaxResponseBytes pass through unbuffered and whole.
+	attempts = 0
+	large := `{"done":true,"eval_count":1,"response":"` + strings.Repeat("x", 200) + `"}`
+	reply = func(w http.ResponseWriter) { w.Write([]byte(large)) }
+	result = retryer.RoundTripEmpty(http.DefaultTransport, req, []byte(`{}`))
+	if result.Response == nil || result.Body != nil || result.TooLarge {
+		t.Fatalf("large response: expected an unbuffered response, got %+v", result)
+	}
+	defer result.Response.Body.Close()
+	if body, _ := io.ReadAll(result.Response.Body); string(body) != large {
+		t.Errorf("large response body = %q", body)
+	}
+}
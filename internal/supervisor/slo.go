@@ -0,0 +1,136 @@
+package supervisor
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sloEvalInterval is how often the SLO monitor recomputes compliance.
+const sloEvalInterval = 30 * time.Second
+
+// LatencySource reports how many completed requests in a window met a latency
+// threshold. storage.Store satisfies it.
+type LatencySource interface {
+	LatencyCompliance(window, threshold time.Duration) (good, total int, err error)
+}
+
+// SLOStatus is the latest evaluation of the latency SLO.
+type SLOStatus struct {
+	Target      float64   `json:"target"`       // e.g. 0.95 = 95% of requests
+	ThresholdMs int64     `json:"threshold_ms"` // latency those requests must finish within
+	Window      string    `json:"window"`
+	Good        int       `json:"good"`
+	Total       int       `json:"total"`
+	Compliance  *float64  `json:"compliance,omitempty"` // good/total; nil without traffic
+	BurnRate    *float64  `json:"burn_rate,omitempty"`  // (1-compliance)/(1-target); >1 exhausts the budget early
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
+
+// SLOMonitor periodically computes latency SLO compliance and error-budget burn
+// rate from stored request durations and exports them as metrics.
+type SLOMonitor struct {
+	source    LatencySource
+	target    float64
+	threshold time.Duration
+	window    time.Duration
+	metrics   *Metrics
+	logger    *slog.Logger
+
+	mu     sync.RWMutex
+	status SLOStatus
+
+	stopCh chan struct{}
+	done   chan struct{} // closed when the Start goroutine returns; nil before Start
+	once   sync.Once
+}
+
+// NewSLOMonitor creates a monitor for "target of requests finish within threshold"
+// over a rolling window. Call Start to evaluate in the background.
+func NewSLOMonitor(source LatencySource, target float64, threshold, window time.Duration, metrics *Metrics, logger *slog.Logger) *SLOMonitor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SLOMonitor{
+		source:    source,
+		target:    target,
+		threshold: threshold,
+		window:    window,
+		metrics:   metrics,
+		logger:    logger,
+		status: SLOStatus{
+			Target:      target,
+			ThresholdMs: threshold.Milliseconds(),
+			Window:      window.String(),
+		},
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start evaluates immediately and then every sloEvalInterval until Shutdown.
+func (m *SLOMonitor) Start() {
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		m.Evaluate()
+
+		ticker := time.NewTicker(sloEvalInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Evaluate()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Evaluate recomputes compliance and burn rate and updates metrics.
+func (m *SLOMonitor) Evaluate() SLOStatus {
+	good, total, err := m.source.LatencyCompliance(m.window, m.threshold)
+	if err != nil {
+		m.logger.Warn("slo evaluation failed", "err", err)
+		return m.Status()
+	}
+
+	st := SLOStatus{
+		Target:      m.target,
+		ThresholdMs: m.threshold.Milliseconds(),
+		Window:      m.window.String(),
+		Good:        good,
+		Total:       total,
+		EvaluatedAt: time.Now(),
+	}
+	if total > 0 {
+		compliance := float64(good) / float64(total)
+		burn := (1 - compliance) / (1 - m.target)
+		st.Compliance = &compliance
+		st.BurnRate = &burn
+		m.metrics.SetSLO(compliance, burn)
+	}
+
+	m.mu.Lock()
+	m.status = st
+	m.mu.Unlock()
+	return st
+}
+
+// Status returns the most recent evaluation. A nil monitor returns the zero value.
+func (m *SLOMonitor) Status() SLOStatus {
+	if m == nil {
+		return SLOStatus{}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Shutdown stops background evaluation, waiting for one in progress.
+func (m *SLOMonitor) Shutdown() {
+	m.once.Do(func() { close(m.stopCh) })
+	if m.done != nil {
+		<-m.done
+	}
+}
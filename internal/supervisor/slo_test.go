@@ -0,0 +1,57 @@
+package supervisor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeLatencySource struct {
+	good, total int
+	err         error
+}
+
+func (f *fakeLatencySource) LatencyCompliance(window, threshold time.Duration) (int, int, error) {
+	return f.good, f.total, f.err
+}
+
+func TestSLOMonitor_Evaluate(t *testing.T) {
+	src := &fakeLatencySource{}
+	m := NewSLOMonitor(src, 0.95, 30*time.Second, time.Hour, nil, nil)
+
+	// No traffic: no compliance or burn rate.
+	st := m.Evaluate()
+	if st.Compliance != nil || st.BurnRate != nil {
+		t.Fatalf("expected no ratios without traffic, got %+v", st)
+	}
+	if st.ThresholdMs != 30000 || st.Window != "1h0m0s" {
+		t.Fatalf("unexpected SLO definition %+v", st)
+	}
+
+	// 90% within threshold against a 95% target burns budget at 2x.
+	src.good, src.total = 90, 100
+	st = m.Evaluate()
+	if st.Compliance == nil || *st.Compliance != 0.9 {
+		t.Fatalf("expected compliance 0.9, got %+v", st.Compliance)
+	}
+	if st.BurnRate == nil || *st.BurnRate < 1.99 || *st.BurnRate > 2.01 {
+		t.Fatalf("expected burn rate 2, got %+v", st.BurnRate)
+	}
+	if got := m.Status(); got.Good != 90 || got.Total != 100 {
+		t.Fatalf("Status not updated: %+v", got)
+	}
+
+	// A failed evaluation keeps the last good status.
+	src.err = errors.New("db closed")
+	st = m.Evaluate()
+	if st.Total != 100 {
+		t.Fatalf("expected previous status on error, got %+v", st)
+	}
+}
+
+func TestSLOMonitor_NilStatus(t *testing.T) {
+	var m *SLOMonitor
+	if st := m.Status(); st.Total != 0 || st.BurnRate != nil {
+		t.Fatalf("nil monitor should return zero status, got %+v", st)
+	}
+}
@@ -0,0 +1,130 @@
+package supervisor
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+)
+
+// SnapshotSink stores periodic metric snapshots. storage.Store satisfies it.
+type SnapshotSink interface {
+	InsertMetricSnapshot(snap storage.MetricSnapshot) error
+	PruneMetricSnapshots(before int64) (int, error)
+}
+
+// MetricsSnapshotter periodically copies the aggregate metrics into storage so
+// lightweight deployments keep metric history without a Prometheus server.
+// Besides the raw totals (see Metrics.Snapshot), each snapshot holds
+// rate(<name>) per second for every _total counter and <name>_avg for every
+// histogram, both over the interval since the previous snapshot. Snapshots
+// older than the retention are pruned.
+type MetricsSnapshotter struct {
+	metrics   *Metrics
+	sink      SnapshotSink
+	interval  time.Duration
+	retention time.Duration
+	logger    *slog.Logger
+
+	mu     sync.Mutex
+	prev   map[string]float64
+	prevAt time.Time
+
+	stopCh chan struct{}
+	done   chan struct{} // closed when the Start goroutine returns; nil before Start
+	once   sync.Once
+}
+
+// NewMetricsSnapshotter creates a snapshotter. Call Start to snapshot in the background.
+func NewMetricsSnapshotter(metrics *Metrics, sink SnapshotSink, interval, retention time.Duration, logger *slog.Logger) *MetricsSnapshotter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &MetricsSnapshotter{
+		metrics:   metrics,
+		sink:      sink,
+		interval:  interval,
+		retention: retention,
+		logger:    logger,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start snapshots every interval until Shutdown.
+func (s *MetricsSnapshotter) Start() {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Snapshot()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops background snapshots, waiting for one in progress.
+func (s *MetricsSnapshotter) Shutdown() {
+	s.once.Do(func() { close(s.stopCh) })
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// Snapshot takes and stores one snapshot now and prunes expired ones.
+func (s *MetricsSnapshotter) Snapshot() (storage.MetricSnapshot, error) {
+	return s.snapshot(time.Now())
+}
+
+func (s *MetricsSnapshotter) snapshot(now time.Time) (storage.MetricSnapshot, error) {
+	values, err := s.metrics.Snapshot()
+	if err != nil {
+		s.logger.Warn("metrics snapshot failed", "err", err)
+		return storage.MetricSnapshot{}, err
+	}
+
+	s.mu.Lock()
+	prev, prevAt := s.prev, s.prevAt
+	s.prev, s.prevAt = values, now
+	s.mu.Unlock()
+
+	snap := storage.MetricSnapshot{TS: now.UnixMilli(), Values: make(map[string]float64, len(values))}
+	for name, v := range values {
+		snap.Values[name] = v
+	}
+	if elapsed := now.Sub(prevAt).Seconds(); prev != nil && elapsed > 0 {
+		for name, v := range values {
+			last, ok := prev[name]
+			if !ok {
+				continue
+			}
+			switch {
+			case strings.HasSuffix(name, "_total"):
+				snap.Values["rate("+name+")"] = (v - last) / elapsed
+			case strings.HasSuffix(name, "_sum"):
+				base := strings.TrimSuffix(name, "_sum")
+				if count := values[base+"_count"] - prev[base+"_count"]; count > 0 {
+					snap.Values[base+"_avg"] = (v - last) / count
+				}
+			}
+		}
+	}
+
+	if err := s.sink.InsertMetricSnapshot(snap); err != nil {
+		s.logger.Error("failed to store metrics snapshot", "err", err)
+		return snap, err
+	}
+	if n, err := s.sink.PruneMetricSnapshots(now.Add(-s.retention).UnixMilli()); err != nil {
+		s.logger.Warn("failed to prune metric snapshots", "err", err)
+	} else if n > 0 {
+		s.logger.Debug("pruned metric snapshots", "removed", n)
+	}
+	return snap, nil
+}
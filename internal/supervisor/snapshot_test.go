@@ -0,0 +1,77 @@
+package supervisor
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/storage"
+)
+
+type fakeSnapshotSink struct {
+	snaps       []storage.MetricSnapshot
+	pruneBefore int64
+	err         error
+}
+
+func (f *fakeSnapshotSink) InsertMetricSnapshot(snap storage.MetricSnapshot) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.snaps = append(f.snaps, snap)
+	return nil
+}
+
+func (f *fakeSnapshotSink) PruneMetricSnapshots(before int64) (int, error) {
+	f.pruneBefore = before
+	return 0, nil
+}
+
+func TestMetricsSnapshotter_Snapshot(t *testing.T) {
+	metrics := NewMetrics()
+	sink := &fakeSnapshotSink{}
+	s := NewMetricsSnapshotter(metrics, sink, time.Minute, time.Hour, nil)
+
+	now := time.Now()
+	metrics.RecordModelEviction("snapshot-test")
+	first, err := s.snapshot(now)
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if _, ok := first.Values["oac_model_evictions_total"]; !ok {
+		t.Fatalf("expected oac_model_evictions_total in %v", first.Values)
+	}
+	if _, ok := first.Values["rate(oac_model_evictions_total)"]; ok {
+		t.Fatal("first snapshot should have no rates")
+	}
+	if sink.pruneBefore != now.Add(-time.Hour).UnixMilli() {
+		t.Fatalf("pruned before %d, want %d", sink.pruneBefore, now.Add(-time.Hour).UnixMilli())
+	}
+
+	metrics.RecordModelEviction("snapshot-test")
+	metrics.RecordModelEviction("snapshot-test")
+	metrics.RecordRequest("chat", "snapshot-test", StatusSuccess, 2*time.Second, 0, 0)
+	metrics.RecordRequest("chat", "snapshot-test", StatusSuccess, 4*time.Second, 0, 0)
+	second, err := s.snapshot(now.Add(10 * time.Second))
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if got := second.Values["rate(oac_model_evictions_total)"]; math.Abs(got-0.2) > 1e-9 {
+		t.Fatalf("eviction rate = %v, want 0.2", got)
+	}
+	if got := second.Values["oac_request_duration_seconds_avg"]; math.Abs(got-3) > 1e-9 {
+		t.Fatalf("duration avg = %v, want 3", got)
+	}
+	if _, ok := second.Values[`oac_requests_total{status="success"}`]; !ok {
+		t.Fatalf("expected per-status request total in %v", second.Values)
+	}
+	if len(sink.snaps) != 2 {
+		t.Fatalf("stored %d snapshots, want 2", len(sink.snaps))
+	}
+
+	sink.err = errors.New("disk full")
+	if _, err := s.snapshot(now.Add(20 * time.Second)); err == nil {
+		t.Fatal("expected sink error")
+	}
+}
@@ -17,9 +17,20 @@ const (
 	StatusTimeoutTTFB          RequestStatus = "timeout_ttfb"
 	StatusTimeoutStall         RequestStatus = "timeout_stall"
 	StatusTimeoutHard          RequestStatus = "timeout_hard"
+	StatusTimeoutGlobal        RequestStatus = "timeout_global" // GLOBAL_REQUEST_TIMEOUT expired
 	StatusUpstreamError        RequestStatus = "upstream_error"
 	StatusLoopDetected         RequestStatus = "loop_detected"
 	StatusOutputLimitExceeded  RequestStatus = "output_limit_exceeded"
+	StatusInvalidImages        RequestStatus = "invalid_images" // rejected before forwarding
+	StatusShowTimeout          RequestStatus = "show_timeout"   // rejected by SHOW_TIMEOUT_POLICY=fail_fast
+	StatusImageBudgetExceeded  RequestStatus = "image_budget_exceeded" // rejected by IMAGE_BUDGET_POLICY=reject
+	StatusOptionsUnfiltered    RequestStatus = "options_unfiltered"    // rejected because OPTIONS_ALLOWLIST couldn't be applied
+	StatusModelBusy            RequestStatus = "model_busy"            // rejected because the model was at MODEL_MAX_CONCURRENCY
+	StatusIdempotencyMismatch  RequestStatus = "idempotency_mismatch"  // rejected because its Idempotency-Key was used for another body
+	StatusPromptTooLarge       RequestStatus = "prompt_too_large"      // rejected because its estimated prompt exceeded MAX_PROMPT_TOKENS
+	StatusQuotaExceeded        RequestStatus = "quota_exceeded"        // rejected because a TAG_QUOTAS quota of one of its tags was exhausted
+	StatusPromptRejected       RequestStatus = "prompt_rejected"       // rejected because its prompt matched a PROMPT_SCAN_FILE reject rule
+	StatusVRAMInsufficient     RequestStatus = "vram_insufficient"     // rejected because its model didn't fit in the free VRAM (VRAM_GATE_ENABLED)
 )
 
 // RequestInfo tracks the lifecycle of a single request.
@@ -41,6 +52,16 @@ type RequestInfo struct {
 	// Actual token counts from Ollama (if available)
 	PromptEvalCount int `json:"prompt_eval_count,omitempty"` // Actual input tokens
 	EvalCount       int `json:"eval_count,omitempty"`         // Actual output tokens
+	// Proxy phases for the latency breakdown: time spent reading and sizing
+	// the body, when it was handed to the upstream, and when the upstream's
+	// last byte arrived.
+	EstimateDuration time.Duration `json:"estimate_duration,omitempty"`
+	ForwardTime      *time.Time    `json:"forward_time,omitempty"`
+	UpstreamDoneTime *time.Time    `json:"upstream_done_time,omitempty"`
+	// Ollama's own timings from its final chunk (if available)
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
 	// internal: last time a progress event was published (not exported in JSON)
 	lastProgressEventTime time.Time
 	// internal: whether output limit was exceeded (for warn mode)
@@ -61,6 +82,12 @@ type Tracker struct {
 	calibStore           *calibration.Store
 	defaultTokensPerByte float64
 	progressInterval     time.Duration
+	// modelRates is an EMA of generation tokens/sec per model, learned from finished requests.
+	modelRates map[string]float64
+	// divergence, if set, is fed estimated vs actual prompt tokens on success.
+	divergence *DivergenceDetector
+	// overflow, if set, receives the non-success requests evicted from recent.
+	overflow *OverflowLog
 }
 
 // NewTracker creates a new request tracker with the specified maximum recent buffer size.
@@ -76,9 +103,26 @@ func NewTracker(maxRecent int, eventBus *EventBus, calibStore *calibration.Store
 		calibStore:           calibStore,
 		defaultTokensPerByte: defaultTokensPerByte,
 		progressInterval:     progressInterval,
+		modelRates:           make(map[string]float64),
 	}
 }
 
+// SetDivergenceDetector attaches a detector that receives estimate/actual
+// prompt token pairs from successful requests.
+func (t *Tracker) SetDivergenceDetector(d *DivergenceDetector) {
+	t.mu.Lock()
+	t.divergence = d
+	t.mu.Unlock()
+}
+
+// SetOverflowLog attaches a log that receives the non-success requests
+// evicted from the recent buffer.
+func (t *Tracker) SetOverflowLog(l *OverflowLog) {
+	t.mu.Lock()
+	t.overflow = l
+	t.mu.Unlock()
+}
+
 // Start registers a new request as in-flight.
 func (t *Tracker) Start(reqID string, endpoint string, model string, stream bool) {
 	t.mu.Lock()
@@ -153,6 +197,25 @@ func (t *Tracker) UpdateTokenCounts(reqID string, promptEvalCount, evalCount int
 	}
 }
 
+// UpdateUpstreamTimings records the load, prompt eval and eval durations
+// Ollama reported for a request. Zero durations leave what was recorded.
+func (t *Tracker) UpdateUpstreamTimings(reqID string, load, promptEval, eval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if req, exists := t.inFlight[reqID]; exists {
+		if load > 0 {
+			req.LoadDuration = load
+		}
+		if promptEval > 0 {
+			req.PromptEvalDuration = promptEval
+		}
+		if eval > 0 {
+			req.EvalDuration = eval
+		}
+	}
+}
+
 // MarkOutputLimitExceeded marks that the output limit was exceeded for a request (warn mode).
 func (t *Tracker) MarkOutputLimitExceeded(reqID string) {
 	t.mu.Lock()
@@ -192,6 +255,28 @@ func (t *Tracker) MarkFirstByte(reqID string) {
 	}
 }
 
+// MarkForwarded records that the request was handed to the upstream after
+// estimate spent reading and sizing it.
+func (t *Tracker) MarkForwarded(reqID string, estimate time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if req, exists := t.inFlight[reqID]; exists {
+		now := time.Now()
+		req.EstimateDuration = estimate
+		req.ForwardTime = &now
+	}
+}
+
+// MarkUpstreamDone records that the upstream response body ended.
+func (t *Tracker) MarkUpstreamDone(reqID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if req, exists := t.inFlight[reqID]; exists && req.UpstreamDoneTime == nil {
+		now := time.Now()
+		req.UpstreamDoneTime = &now
+	}
+}
+
 // MarkProgress updates the bytes forwarded and last activity time for a request.
 func (t *Tracker) MarkProgress(reqID string, bytesDelta int64) {
 	t.mu.Lock()
@@ -268,7 +353,19 @@ func (t *Tracker) Finish(reqID string, status RequestStatus, err error) {
 		req.Error = err.Error()
 	}
 
-	// Add to recent buffer using O(1) circular buffer
+	// Add to recent buffer using O(1) circular buffer; with no buffer the
+	// request is evicted as soon as it finishes.
+	var evicted *RequestInfo
+	if t.maxRecent == 0 {
+		evicted = req
+	} else if t.recentCount == t.maxRecent {
+		old := t.recent[t.recentHead]
+		evicted = &old
+	}
+	if evicted != nil && (evicted.Status == StatusSuccess || t.overflow == nil) {
+		evicted = nil
+	}
+	overflow := t.overflow
 	if t.maxRecent > 0 {
 		t.recent[t.recentHead] = *req
 		t.recentHead = (t.recentHead + 1) % t.maxRecent
@@ -280,15 +377,26 @@ func (t *Tracker) Finish(reqID string, status RequestStatus, err error) {
 	inFlightCount := len(t.inFlight)
 	duration := now.Sub(req.StartTime)
 	estimatedTokens := EstimateOutputTokens(req.BytesForwarded, req.Model, t.calibStore, t.defaultTokensPerByte)
+	if status == StatusSuccess {
+		t.learnRateLocked(req, estimatedTokens, now)
+	}
+	divergence := t.divergence
 	t.mu.Unlock()
 
+	if status == StatusSuccess {
+		divergence.Observe(req.Model, req.EstimatedPromptTokens, req.PromptEvalCount)
+	}
+	if evicted != nil {
+		overflow.Write(*evicted)
+	}
+
 	// Record metrics
 	if t.metrics != nil {
 		t.metrics.RecordRequest(req.Endpoint, req.Model, status, duration, req.BytesForwarded, estimatedTokens)
 		t.metrics.UpdateInFlight(inFlightCount)
 
 		// Record timeout metrics
-		if status == StatusTimeoutTTFB || status == StatusTimeoutStall || status == StatusTimeoutHard {
+		if status == StatusTimeoutTTFB || status == StatusTimeoutStall || status == StatusTimeoutHard || status == StatusTimeoutGlobal {
 			t.metrics.RecordTimeout(status)
 		}
 
@@ -322,6 +430,8 @@ func (t *Tracker) Finish(reqID string, status RequestStatus, err error) {
 			eventType = EventTimeoutStall
 		case StatusTimeoutHard:
 			eventType = EventTimeoutHard
+		case StatusTimeoutGlobal:
+			eventType = EventTimeoutGlobal
 		case StatusUpstreamError:
 			eventType = EventUpstreamError
 		case StatusLoopDetected:
@@ -1,6 +1,11 @@
 package supervisor
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -226,4 +231,90 @@ func TestTracker_NonExistentRequest(t *testing.T) {
 	if len(snapshot.InFlight) != 0 {
 		t.Errorf("expected 0 in-flight requests, got %d", len(snapshot.InFlight))
 	}
-}
\ No newline at end of file
+}
+func TestTracker_Phases(t *testing.T) {
+	tracker := NewTracker(10, nil, nil, 0.25, time.Second, nil)
+	tracker.Start("req1", "chat", "llama3", true)
+
+	tracker.MarkForwarded("req1", 15*time.Millisecond)
+	tracker.MarkUpstreamDone("req1")
+	first := tracker.GetRequestInfo("req1").UpstreamDoneTime
+	time.Sleep(2 * time.Millisecond)
+	tracker.MarkUpstreamDone("req1")
+
+	info := tracker.GetRequestInfo("req1")
+	if info.EstimateDuration != 15*time.Millisecond || info.ForwardTime == nil {
+		t.Fatalf("expected forward phase recorded, got %+v", info)
+	}
+	if info.UpstreamDoneTime == nil || !info.UpstreamDoneTime.Equal(*first) {
+		t.Fatalf("expected the first upstream end to be kept, got %v want %v", info.UpstreamDoneTime, first)
+	}
+
+	// Unknown requests are ignored.
+	tracker.MarkForwarded("missing", time.Second)
+	tracker.MarkUpstreamDone("missing")
+}
+
+func TestTrackerOverflowLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overflow.jsonl")
+	l, err := NewOverflowLog(path, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tracker := NewTracker(2, nil, nil, 0.25, time.Second, nil)
+	tracker.SetOverflowLog(l)
+
+	finish := func(id string, status RequestStatus) {
+		tracker.Start(id, "/api/chat", "m", false)
+		tracker.Finish(id, status, nil)
+	}
+	finish("1", StatusUpstreamError)
+	finish("2", StatusSuccess)
+	finish("3", StatusSuccess)     // evicts 1
+	finish("4", StatusTimeoutTTFB) // evicts 2, a success
+	finish("5", StatusSuccess)     // evicts 3, a success
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the evicted error logged, got %q", b)
+	}
+	var info RequestInfo
+	if err := json.Unmarshal([]byte(lines[0]), &info); err != nil || info.ID != "1" || info.Status != StatusUpstreamError {
+		t.Errorf("overflow line %s: %+v, %v", lines[0], info, err)
+	}
+}
+
+func TestOverflowLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overflow.jsonl")
+	l, err := NewOverflowLog(path, 300, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 6 {
+		l.Write(RequestInfo{ID: strconv.Itoa(i), Status: StatusCanceled, Error: strings.Repeat("x", 60)})
+	}
+	l.Close()
+
+	cur, _ := os.ReadFile(path)
+	prev, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("expected a rotated file: %v", err)
+	}
+	if len(cur) > 300 || len(prev) > 300 {
+		t.Errorf("files past the limit: %d and %d bytes", len(cur), len(prev))
+	}
+	if !strings.Contains(string(cur), `"id":"5"`) {
+		t.Errorf("expected the newest entry in the current file, got %q", cur)
+	}
+
+	// Writes after Close are dropped.
+	l.Write(RequestInfo{ID: "late", Status: StatusCanceled})
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "late") {
+		t.Error("write after Close reached the file")
+	}
+}
@@ -0,0 +1,193 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// vramRecheckInterval is how often a request held by the VRAM gate checks
+// for room again, besides when another held load is released.
+const vramRecheckInterval = time.Second
+
+// ErrVRAMInsufficient is returned by VRAMGate.Admit when the model didn't
+// fit in the free VRAM in time.
+var ErrVRAMInsufficient = errors.New("not enough free VRAM to load the model")
+
+// VRAMGateConfig holds configuration for the VRAM admission gate.
+type VRAMGateConfig struct {
+	TotalBytes    int64         // IDLE_EVICT_VRAM_BYTES: VRAM available to Ollama
+	HeadroomBytes int64         // VRAM_HEADROOM_BYTES kept free on top of the model
+	QueueTimeout  time.Duration // VRAM_GATE_QUEUE_TIMEOUT; 0 rejects without waiting
+	MaxAge        time.Duration // oldest residency poll trusted; older ones admit everything
+}
+
+// vramLoad is a model admitted by the gate that the residency poll hasn't
+// seen loaded yet.
+type vramLoad struct {
+	bytes    int64
+	requests int
+}
+
+// VRAMGate holds back requests for a model that isn't loaded while the VRAM
+// left free, TotalBytes less what the residency poller saw loaded and what
+// admitted loads will take, can't fit it plus HeadroomBytes, so Ollama
+// doesn't run out of memory loading it. A model's need is the VRAM it held
+// when last seen loaded, or the size hint for models never seen. Requests
+// for loaded models, for models of unknown size, and any request while the
+// residency poll is failing or stale are let through. Idle loaded models
+// count as used until they are unloaded, so pair it with the idle evictor
+// to make room. It is safe for concurrent use; a nil gate admits everything.
+type VRAMGate struct {
+	residency *ResidencyPoller
+	cfg       VRAMGateConfig
+	metrics   *Metrics
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	loads   map[string]*vramLoad // admitted loads of models not yet seen loaded
+	changed chan struct{}        // closed when a load is released
+}
+
+// NewVRAMGate creates a gate reading the loaded models from residency.
+func NewVRAMGate(residency *ResidencyPoller, cfg VRAMGateConfig, metrics *Metrics, logger *slog.Logger) *VRAMGate {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &VRAMGate{
+		residency: residency,
+		cfg:       cfg,
+		metrics:   metrics,
+		logger:    logger,
+		loads:     make(map[string]*vramLoad),
+		changed:   make(chan struct{}),
+	}
+}
+
+// Admit returns once a request for model may go ahead, waiting up to
+// QueueTimeout for room. sizeHint gives the model's size when it has never
+// been seen loaded (0 if unknown); it is only called then. The returned func
+// must be called once the request ends. Admit returns ErrVRAMInsufficient
+// when the model still didn't fit, or ctx's error if the request ended
+// first.
+func (g *VRAMGate) Admit(ctx context.Context, model string, sizeHint func() int64) (release func(), err error) {
+	if g == nil || model == "" {
+		return func() {}, nil
+	}
+	name := canonicalModelName(model)
+	var expired <-chan time.Time
+	for {
+		release, need, free, ok := g.tryAdmit(name, sizeHint)
+		if ok {
+			return release, nil
+		}
+		if expired == nil {
+			if g.cfg.QueueTimeout <= 0 {
+				return nil, g.rejected(model, need, free)
+			}
+			g.logger.Debug("not enough free VRAM to load model; queueing", "model", model, "need_bytes", need, "free_bytes", free)
+			timer := time.NewTimer(g.cfg.QueueTimeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		g.mu.Lock()
+		changed := g.changed
+		g.mu.Unlock()
+		recheck := time.NewTimer(vramRecheckInterval)
+		select {
+		case <-changed:
+		case <-recheck.C:
+		case <-expired:
+			recheck.Stop()
+			return nil, g.rejected(model, need, free)
+		case <-ctx.Done():
+			recheck.Stop()
+			return nil, ctx.Err()
+		}
+		recheck.Stop()
+	}
+}
+
+// tryAdmit admits a request for the canonical model name if it fits now,
+// reporting otherwise what it needs and what is free.
+func (g *VRAMGate) tryAdmit(name string, sizeHint func() int64) (release func(), need, free int64, ok bool) {
+	noop := func() {}
+	r := g.residency.Snapshot()
+	if !r.Available || time.Since(r.PolledAt) > g.cfg.MaxAge {
+		return noop, 0, 0, true
+	}
+
+	resident := make(map[string]bool, len(r.Models))
+	for _, m := range r.Models {
+		resident[canonicalModelName(m.Name)] = true
+	}
+	if resident[name] {
+		return noop, 0, 0, true
+	}
+	g.mu.Lock()
+	if l := g.loads[name]; l != nil {
+		// Another request is loading it; this one needs no more room.
+		l.requests++
+		g.mu.Unlock()
+		return g.releaser(name), 0, 0, true
+	}
+	g.mu.Unlock()
+	seen, ok := g.residency.Seen(name)
+	need = seen.SizeVRAM
+	if !ok && sizeHint != nil {
+		need = sizeHint()
+	}
+	if need <= 0 {
+		return noop, 0, 0, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	used := r.VRAMBytes
+	for n, l := range g.loads {
+		if !resident[n] {
+			used += l.bytes
+		}
+	}
+	free = g.cfg.TotalBytes - used
+	if need+g.cfg.HeadroomBytes > free {
+		return nil, need, free, false
+	}
+	if l := g.loads[name]; l != nil {
+		l.requests++
+	} else {
+		g.loads[name] = &vramLoad{bytes: need, requests: 1}
+	}
+	return g.releaser(name), need, free, true
+}
+
+// releaser returns the func ending one admitted request's share of name's
+// load, waking held requests once the last one ends.
+func (g *VRAMGate) releaser(name string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			l := g.loads[name]
+			if l == nil {
+				return
+			}
+			if l.requests--; l.requests > 0 {
+				return
+			}
+			delete(g.loads, name)
+			close(g.changed)
+			g.changed = make(chan struct{})
+		})
+	}
+}
+
+func (g *VRAMGate) rejected(model string, need, free int64) error {
+	g.metrics.RecordVRAMGateRejected(model)
+	g.logger.Warn("rejecting request: not enough free VRAM to load model", "model", model,
+		"need_bytes", need, "free_bytes", free, "headroom_bytes", g.cfg.HeadroomBytes)
+	return ErrVRAMInsufficient
+}
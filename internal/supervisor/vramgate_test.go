@@ -0,0 +1,118 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ollama-auto-ctx/internal/ollama"
+)
+
+func TestVRAMGate(t *testing.T) {
+	l := &flakyLister{models: []ollama.RunningModel{{Name: "big:latest", SizeVRAM: 12 * gib}}}
+	p := NewResidencyPoller(l, time.Minute, nil, nil)
+	p.Poll()
+	g := NewVRAMGate(p, VRAMGateConfig{TotalBytes: 24 * gib, HeadroomBytes: gib, MaxAge: time.Hour}, nil, nil)
+	size := func(n int64) func() int64 { return func() int64 { return n } }
+	ctx := context.Background()
+
+	// Loaded models need no room.
+	release, err := g.Admit(ctx, "big", size(100*gib))
+	if err != nil {
+		t.Fatalf("loaded model held back: %v", err)
+	}
+	release()
+
+	// 12 GiB free: an 8 GiB model fits, a second one doesn't while the first
+	// is loading, but more requests for the loading model do.
+	releaseA, err := g.Admit(ctx, "a", size(8*gib))
+	if err != nil {
+		t.Fatalf("fitting model held back: %v", err)
+	}
+	releaseA2, err := g.Admit(ctx, "a:latest", size(8*gib))
+	if err != nil {
+		t.Fatalf("second request for a loading model held back: %v", err)
+	}
+	if _, err := g.Admit(ctx, "b", size(8*gib)); !errors.Is(err, ErrVRAMInsufficient) {
+		t.Fatalf("expected ErrVRAMInsufficient, got %v", err)
+	}
+	releaseA()
+	if _, err := g.Admit(ctx, "b", size(8*gib)); !errors.Is(err, ErrVRAMInsufficient) {
+		t.Fatal("load released while a request for it was still running")
+	}
+	releaseA2()
+	releaseB, err := g.Admit(ctx, "b", size(8*gib))
+	if err != nil {
+		t.Fatalf("model held back after the other load ended: %v", err)
+	}
+	releaseB()
+
+	// Once seen loaded, a model needs what it held then, not its size hint.
+	l.models = append(l.models, ollama.RunningModel{Name: "c:latest", SizeVRAM: 14 * gib})
+	p.Poll()
+	l.models = l.models[:1]
+	p.Poll()
+	if _, err := g.Admit(ctx, "c", size(gib)); !errors.Is(err, ErrVRAMInsufficient) {
+		t.Fatalf("expected the learned 14 GiB to be too much, got %v", err)
+	}
+
+	// Unknown sizes and a failing poll let requests through.
+	if _, err := g.Admit(ctx, "d", size(0)); err != nil {
+		t.Errorf("model of unknown size held back: %v", err)
+	}
+	l.err = errors.New("connection refused")
+	p.Poll()
+	if _, err := g.Admit(ctx, "c", size(gib)); err != nil {
+		t.Errorf("request held back without residency: %v", err)
+	}
+
+	var nilGate *VRAMGate
+	if _, err := nilGate.Admit(ctx, "c", nil); err != nil {
+		t.Errorf("nil gate held back a request: %v", err)
+	}
+}
+
+func TestVRAMGate_Queue(t *testing.T) {
+	l := &flakyLister{}
+	p := NewResidencyPoller(l, time.Minute, nil, nil)
+	p.Poll()
+	g := NewVRAMGate(p, VRAMGateConfig{TotalBytes: 10 * gib, QueueTimeout: 5 * time.Second, MaxAge: time.Hour}, nil, nil)
+	size := func() int64 { return 6 * gib }
+	ctx := context.Background()
+
+	releaseA, err := g.Admit(ctx, "a", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admitted := make(chan error, 1)
+	go func() {
+		release, err := g.Admit(ctx, "b", size)
+		if err == nil {
+			release()
+		}
+		admitted <- err
+	}()
+	select {
+	case err := <-admitted:
+		t.Fatalf("b admitted while a was loading: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	releaseA()
+	select {
+	case err := <-admitted:
+		if err != nil {
+			t.Fatalf("queued request failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued request not woken when the load was released")
+	}
+
+	releaseA, _ = g.Admit(ctx, "a", size)
+	defer releaseA()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := g.Admit(canceled, "b", size); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
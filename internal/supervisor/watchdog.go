@@ -17,11 +17,19 @@ type Watchdog struct {
 	restartHook  *RestartHook
 
 	cancelFuncs map[string]context.CancelFunc
+	timeouts    map[string]Timeouts // per-request overrides of the defaults
 	mu          sync.RWMutex
 
 	stopCh chan struct{}
 }
 
+// Timeouts are the limits the watchdog holds one request to.
+type Timeouts struct {
+	TTFB  time.Duration
+	Stall time.Duration
+	Hard  time.Duration
+}
+
 // NewWatchdog creates a new watchdog instance.
 func NewWatchdog(tracker *Tracker, ttfbTimeout, stallTimeout, hardTimeout time.Duration, logger *slog.Logger, restartHook *RestartHook) *Watchdog {
 	return &Watchdog{
@@ -32,6 +40,7 @@ func NewWatchdog(tracker *Tracker, ttfbTimeout, stallTimeout, hardTimeout time.D
 		logger:       logger,
 		restartHook:  restartHook,
 		cancelFuncs:  make(map[string]context.CancelFunc),
+		timeouts:     make(map[string]Timeouts),
 		stopCh:       make(chan struct{}),
 	}
 }
@@ -50,6 +59,36 @@ func (w *Watchdog) Stop(reqID string) {
 	defer w.mu.Unlock()
 
 	delete(w.cancelFuncs, reqID)
+	delete(w.timeouts, reqID)
+}
+
+// SetTimeouts holds a monitored request to t instead of the watchdog's
+// defaults; zero fields keep the default. Requests not monitored are ignored.
+func (w *Watchdog) SetTimeouts(reqID string, t Timeouts) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.cancelFuncs[reqID]; ok {
+		w.timeouts[reqID] = t
+	}
+}
+
+// timeoutsFor returns the timeouts reqID is held to.
+func (w *Watchdog) timeoutsFor(reqID string) Timeouts {
+	w.mu.RLock()
+	t := w.timeouts[reqID]
+	w.mu.RUnlock()
+
+	if t.TTFB <= 0 {
+		t.TTFB = w.ttfbTimeout
+	}
+	if t.Stall <= 0 {
+		t.Stall = w.stallTimeout
+	}
+	if t.Hard <= 0 {
+		t.Hard = w.hardTimeout
+	}
+	return t
 }
 
 // Run starts the monitoring loop. This should be called in a separate goroutine.
@@ -86,23 +125,24 @@ func (w *Watchdog) checkTimeouts() {
 	for reqID, req := range snapshot.InFlight {
 		var timeoutType RequestStatus
 		var shouldCancel bool
+		limits := w.timeoutsFor(reqID)
 
 		// Check TTFB timeout: no bytes received at all
 		if req.FirstByteTime == nil {
-			if now.Sub(req.StartTime) > w.ttfbTimeout {
+			if now.Sub(req.StartTime) > limits.TTFB {
 				timeoutType = StatusTimeoutTTFB
 				shouldCancel = true
 			}
 		} else {
 			// Check stall timeout: bytes started but no activity
-			if now.Sub(req.LastActivityTime) > w.stallTimeout {
+			if now.Sub(req.LastActivityTime) > limits.Stall {
 				timeoutType = StatusTimeoutStall
 				shouldCancel = true
 			}
 		}
 
 		// Check hard timeout: total wall-clock time
-		if now.Sub(req.StartTime) > w.hardTimeout {
+		if now.Sub(req.StartTime) > limits.Hard {
 			timeoutType = StatusTimeoutHard
 			shouldCancel = true
 		}
@@ -124,6 +164,7 @@ func (w *Watchdog) cancelRequest(reqID string, timeoutType RequestStatus) {
 	}
 	// Remove from map to prevent double cancellation
 	delete(w.cancelFuncs, reqID)
+	delete(w.timeouts, reqID)
 	w.mu.Unlock()
 
 	// Cancel the request context
@@ -264,4 +264,45 @@ func TestWatchdog_Shutdown(t *testing.T) {
 	case <-time.After(100 * time.Millisecond):
 		t.Error("watchdog did not shut down within timeout")
 	}
-}
\ No newline at end of file
+}
+
+func TestWatchdog_SetTimeouts(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	tracker := NewTracker(10, nil, nil, 0.25, 250*time.Millisecond, nil)
+	watchdog := NewWatchdog(tracker, 50*time.Millisecond, time.Second, 10*time.Second, logger, nil)
+
+	defaultCtx, cancelDefault := context.WithCancel(context.Background())
+	defer cancelDefault()
+	patientCtx, cancelPatient := context.WithCancel(context.Background())
+	defer cancelPatient()
+
+	watchdog.Start("default", cancelDefault)
+	tracker.Start("default", "/api/chat", "model", false)
+	watchdog.Start("patient", cancelPatient)
+	tracker.Start("patient", "/api/chat", "model", false)
+	// Only the TTFB is raised; zero fields keep the defaults.
+	watchdog.SetTimeouts("patient", Timeouts{TTFB: 10 * time.Second})
+	// Requests that aren't monitored are ignored.
+	watchdog.SetTimeouts("unknown", Timeouts{TTFB: time.Second})
+
+	time.Sleep(100 * time.Millisecond)
+	watchdog.checkTimeouts()
+
+	if defaultCtx.Err() == nil {
+		t.Error("expected the request on the default TTFB timeout to be canceled")
+	}
+	if patientCtx.Err() != nil {
+		t.Error("expected the request with a raised TTFB timeout to keep running")
+	}
+	if got := watchdog.timeoutsFor("patient"); got != (Timeouts{TTFB: 10 * time.Second, Stall: time.Second, Hard: 10 * time.Second}) {
+		t.Errorf("timeoutsFor = %+v", got)
+	}
+	if _, ok := watchdog.timeouts["unknown"]; ok {
+		t.Error("expected timeouts for an unmonitored request to be dropped")
+	}
+
+	watchdog.Stop("patient")
+	if _, ok := watchdog.timeouts["patient"]; ok {
+		t.Error("expected Stop to drop the request's timeouts")
+	}
+}
@@ -14,6 +14,25 @@ func ToString(v any) (string, bool) {
 	return "", false
 }
 
+// ToStrings attempts to coerce v into a list of strings. It accepts []string
+// and []any; non-string elements of an []any are skipped.
+func ToStrings(v any) ([]string, bool) {
+	switch x := v.(type) {
+	case []string:
+		return x, true
+	case []any:
+		out := make([]string, 0, len(x))
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
 // ToBool attempts to coerce v into a bool.
 func ToBool(v any) (bool, bool) {
 	switch x := v.(type) {
@@ -31,6 +31,22 @@ func DecodeJSONMap(b []byte) (map[string]any, error) {
 	return m, nil
 }
 
+// DecodeFirstJSONMap decodes the first JSON value of b like DecodeJSONMap but
+// ignores whatever follows it, e.g. stray bytes some clients append. It also
+// returns the length of b up to the end of that value.
+func DecodeFirstJSONMap(b []byte) (map[string]any, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return nil, 0, err
+	}
+	if m == nil {
+		m = map[string]any{}
+	}
+	return m, int(dec.InputOffset()), nil
+}
+
 // EncodeJSON marshals a value to JSON using the standard library.
 func EncodeJSON(v any) ([]byte, error) {
 	return json.Marshal(v)